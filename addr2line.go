@@ -0,0 +1,289 @@
+package main
+
+// This file implements the addr2line subcommand, which converts addresses (for
+// example from a crash dump) back into source locations using the DWARF debug
+// information in the binary.
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// SourceLocation is a single frame in the (possibly inlined) call chain at a
+// given address.
+type SourceLocation struct {
+	Function string
+	File     string
+	Line     int
+	Inlined  bool // true if this frame was inlined into the frame below it
+}
+
+// String returns the location in the format used by the addr2line command.
+func (loc SourceLocation) String() string {
+	function := loc.Function
+	if function == "" {
+		function = "??"
+	}
+	file := loc.File
+	if file == "" {
+		file = "??"
+	}
+	s := function + "\n    " + file + ":" + strconv.Itoa(loc.Line)
+	if loc.Inlined {
+		s += " (inlined)"
+	}
+	return s
+}
+
+// Symbolizer converts addresses in a binary back to source locations.
+type Symbolizer struct {
+	data *dwarf.Data
+
+	// Offset to subtract from input addresses. This is used for WebAssembly,
+	// where addresses in the DWARF information are relative to the start of
+	// the code section but addresses in stack traces are file offsets.
+	offset uint64
+}
+
+// NewSymbolizer reads the debug information from the given ELF or WebAssembly
+// file.
+func NewSymbolizer(path string) (*Symbolizer, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(buf, []byte("\x00asm")) {
+		return newWasmSymbolizer(buf)
+	}
+	file, err := elf.NewFile(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	data, err := file.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("could not read debug information: %v", err)
+	}
+	return &Symbolizer{data: data}, nil
+}
+
+// newWasmSymbolizer reads the DWARF sections from a WebAssembly file, which
+// are stored in custom sections.
+func newWasmSymbolizer(buf []byte) (*Symbolizer, error) {
+	sections := make(map[string][]byte)
+	var codeOffset uint64
+	offset := 8 // skip magic and version
+	for offset < len(buf) {
+		id := buf[offset]
+		size, n := binary.Uvarint(buf[offset+1:])
+		if n <= 0 {
+			return nil, errors.New("wasm: invalid section size")
+		}
+		start := offset + 1 + n
+		end := start + int(size)
+		if end > len(buf) {
+			return nil, errors.New("wasm: section extends beyond end of file")
+		}
+		switch id {
+		case 0: // custom section
+			nameLen, n := binary.Uvarint(buf[start:end])
+			if n <= 0 || uint64(end-start-n) < nameLen {
+				return nil, errors.New("wasm: invalid custom section name")
+			}
+			name := string(buf[start+n : start+n+int(nameLen)])
+			if strings.HasPrefix(name, ".debug_") {
+				sections[name[len(".debug_"):]] = buf[start+n+int(nameLen) : end]
+			}
+		case 10: // code section
+			codeOffset = uint64(start)
+		}
+		offset = end
+	}
+	if sections["info"] == nil {
+		return nil, errors.New("could not read debug information: no .debug_info section")
+	}
+	data, err := dwarf.New(sections["abbrev"], sections["aranges"], sections["frame"], sections["info"], sections["line"], sections["pubnames"], sections["ranges"], sections["str"])
+	if err != nil {
+		return nil, fmt.Errorf("could not read debug information: %v", err)
+	}
+	return &Symbolizer{data: data, offset: codeOffset}, nil
+}
+
+// Lookup returns the call chain at the given address, with the innermost
+// (most deeply inlined) frame first. It returns an empty slice when there is
+// no debug information for this address.
+func (s *Symbolizer) Lookup(addr uint64) ([]SourceLocation, error) {
+	pc := addr - s.offset
+	r := s.data.Reader()
+	cu, err := r.SeekPC(pc)
+	if err == dwarf.ErrUnknownPC {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Find all (inlined) functions that contain this address, from the
+	// outermost function to the innermost.
+	var chain []*dwarf.Entry
+	depth := 1 // inside the compile unit
+	for depth > 0 {
+		entry, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		switch entry.Tag {
+		case 0:
+			depth--
+			continue
+		case dwarf.TagSubprogram, dwarf.TagInlinedSubroutine, dwarf.TagLexDwarfBlock:
+			ranges, err := s.data.Ranges(entry)
+			if err != nil {
+				return nil, err
+			}
+			if !rangesContain(ranges, pc) {
+				if entry.Children {
+					r.SkipChildren()
+				}
+				continue
+			}
+			if entry.Tag != dwarf.TagLexDwarfBlock {
+				chain = append(chain, entry)
+			}
+		default:
+			if entry.Children {
+				r.SkipChildren()
+			}
+			continue
+		}
+		if entry.Children {
+			depth++
+		}
+	}
+
+	// Find the innermost location using the line table.
+	lr, err := s.data.LineReader(cu)
+	if err != nil {
+		return nil, err
+	}
+	var file string
+	var line int
+	if lr != nil {
+		var lineEntry dwarf.LineEntry
+		if err := lr.SeekPC(pc, &lineEntry); err == nil {
+			file = lineEntry.File.Name
+			line = lineEntry.Line
+		}
+	}
+	if len(chain) == 0 {
+		// No function information, but there may be line information.
+		if file == "" {
+			return nil, nil
+		}
+		return []SourceLocation{{File: file, Line: line}}, nil
+	}
+
+	// Walk the chain from the inside out. Every inlined subroutine records
+	// the location it was called from, which is the location in the function
+	// it was inlined into.
+	var files []*dwarf.LineFile
+	if lr != nil {
+		files = lineFiles(lr)
+	}
+	locations := make([]SourceLocation, 0, len(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		entry := chain[i]
+		locations = append(locations, SourceLocation{
+			Function: s.functionName(entry),
+			File:     file,
+			Line:     line,
+			Inlined:  entry.Tag == dwarf.TagInlinedSubroutine,
+		})
+		file = ""
+		if index, ok := entry.Val(dwarf.AttrCallFile).(int64); ok && index >= 0 && int(index) < len(files) && files[index] != nil {
+			file = files[index].Name
+		}
+		line = 0
+		if callLine, ok := entry.Val(dwarf.AttrCallLine).(int64); ok {
+			line = int(callLine)
+		}
+	}
+	return locations, nil
+}
+
+// functionName returns the name of a subprogram or inlined subroutine,
+// following DW_AT_abstract_origin and DW_AT_specification as necessary.
+func (s *Symbolizer) functionName(entry *dwarf.Entry) string {
+	for i := 0; i < 4; i++ { // limit the number of indirections
+		if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+			return name
+		}
+		if name, ok := entry.Val(dwarf.AttrLinkageName).(string); ok {
+			return name
+		}
+		offset, ok := entry.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			offset, ok = entry.Val(dwarf.AttrSpecification).(dwarf.Offset)
+		}
+		if !ok {
+			break
+		}
+		r := s.data.Reader()
+		r.Seek(offset)
+		origin, err := r.Next()
+		if err != nil || origin == nil {
+			break
+		}
+		entry = origin
+	}
+	return ""
+}
+
+// rangesContain returns whether the given address is part of one of the
+// address ranges.
+func rangesContain(ranges [][2]uint64, pc uint64) bool {
+	for _, r := range ranges {
+		if pc >= r[0] && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Addr2Line prints the source locations for each of the given addresses in
+// the given ELF or WebAssembly file. Addresses are parsed as hexadecimal
+// numbers, with an optional 0x prefix. For WebAssembly, addresses are file
+// offsets as printed in browser stack traces.
+func Addr2Line(path string, addresses []string, w io.Writer) error {
+	symbolizer, err := NewSymbolizer(path)
+	if err != nil {
+		return err
+	}
+	for _, s := range addresses {
+		addr, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid address: %s", s)
+		}
+		locations, err := symbolizer.Lookup(addr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "0x%x\n", addr)
+		if len(locations) == 0 {
+			fmt.Fprintln(w, SourceLocation{})
+		}
+		for _, loc := range locations {
+			fmt.Fprintln(w, loc)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// +build go1.14
+
+package main
+
+import "debug/dwarf"
+
+// lineFiles returns the file table of a line table, for resolving the
+// DW_AT_call_file attribute.
+func lineFiles(lr *dwarf.LineReader) []*dwarf.LineFile {
+	return lr.Files()
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
+	"debug/dwarf"
 	"debug/elf"
+	"path/filepath"
 	"sort"
 	"strings"
 )
 
 // Statistics about code size in a program.
 type ProgramSize struct {
-	Packages map[string]*PackageSize
-	Sum      *PackageSize
-	Code     uint64
-	Data     uint64
-	BSS      uint64
+	Packages map[string]*PackageSize `json:"packages"`
+	Symbols  []*SymbolSize           `json:"symbols"`
+	Sum      *PackageSize            `json:"sum"`
+	Code     uint64                  `json:"code"`
+	Data     uint64                  `json:"data"`
+	BSS      uint64                  `json:"bss"`
 }
 
 // Return the list of package names (ProgramSize.Packages) sorted
@@ -26,12 +29,34 @@ func (ps *ProgramSize) SortedPackageNames() []string {
 	return names
 }
 
+// Return the list of symbols (ProgramSize.Symbols) sorted by size, largest
+// first.
+func (ps *ProgramSize) SortedSymbols() []*SymbolSize {
+	symbols := append([]*SymbolSize(nil), ps.Symbols...)
+	sort.SliceStable(symbols, func(i, j int) bool {
+		si := symbols[i].Flash() + symbols[i].RAM()
+		sj := symbols[j].Flash() + symbols[j].RAM()
+		if si != sj {
+			return si > sj
+		}
+		return symbols[i].Name < symbols[j].Name
+	})
+	return symbols
+}
+
 // The size of a package, calculated from the linked object file.
 type PackageSize struct {
-	Code   uint64
-	ROData uint64
-	Data   uint64
-	BSS    uint64
+	Code   uint64 `json:"code"`
+	ROData uint64 `json:"rodata"`
+	Data   uint64 `json:"data"`
+	BSS    uint64 `json:"bss"`
+}
+
+// The size of a single function or global, with the package it belongs to.
+type SymbolSize struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	PackageSize
 }
 
 // Flash usage in regular microcontrollers.
@@ -115,17 +140,30 @@ func Sizes(path string) (*ProgramSize, error) {
 	}
 	sort.Sort(symbolList(symbols))
 
+	// Symbols that are not Go symbols (C functions and compiler-rt) are
+	// attributed to the compile unit they're in, if there is debug
+	// information.
+	var units []compileUnit
+	if data, err := file.DWARF(); err == nil {
+		units = readCompileUnits(data)
+	}
+
 	sizes := map[string]*PackageSize{}
+	var symbolSizes []*SymbolSize
 	var lastSymbolValue uint64
 	for _, symbol := range symbols {
 		symType := elf.ST_TYPE(symbol.Info)
 		//bind := elf.ST_BIND(symbol.Info)
 		section := file.Sections[symbol.Section]
-		pkgName := "(bootstrap)"
-		symName := strings.TrimLeft(symbol.Name, "(*")
-		dot := strings.IndexByte(symName, '.')
-		if dot > 0 {
-			pkgName = symName[:dot]
+		pkgName := symbolPackage(symbol.Name)
+		if pkgName == "" {
+			pkgName = "(bootstrap)"
+			for _, unit := range units {
+				if unit.contains(symbol.Value) {
+					pkgName = "(C " + unit.name + ")"
+					break
+				}
+			}
 		}
 		pkgSize := sizes[pkgName]
 		if pkgSize == nil {
@@ -133,17 +171,23 @@ func Sizes(path string) (*ProgramSize, error) {
 			sizes[pkgName] = pkgSize
 		}
 		if lastSymbolValue != symbol.Value || lastSymbolValue == 0 {
+			symSize := &SymbolSize{Name: symbol.Name, Package: pkgName}
 			if symType == elf.STT_FUNC {
-				pkgSize.Code += symbol.Size
+				symSize.Code = symbol.Size
 			} else if section.Flags&elf.SHF_WRITE != 0 {
 				if section.Type == elf.SHT_NOBITS {
-					pkgSize.BSS += symbol.Size
+					symSize.BSS = symbol.Size
 				} else {
-					pkgSize.Data += symbol.Size
+					symSize.Data = symbol.Size
 				}
 			} else {
-				pkgSize.ROData += symbol.Size
+				symSize.ROData = symbol.Size
 			}
+			pkgSize.Code += symSize.Code
+			pkgSize.ROData += symSize.ROData
+			pkgSize.Data += symSize.Data
+			pkgSize.BSS += symSize.BSS
+			symbolSizes = append(symbolSizes, symSize)
 		}
 		lastSymbolValue = symbol.Value
 	}
@@ -156,5 +200,63 @@ func Sizes(path string) (*ProgramSize, error) {
 		sum.BSS += pkg.BSS
 	}
 
-	return &ProgramSize{Packages: sizes, Code: sumCode, Data: sumData, BSS: sumBSS, Sum: sum}, nil
+	return &ProgramSize{Packages: sizes, Symbols: symbolSizes, Code: sumCode, Data: sumData, BSS: sumBSS, Sum: sum}, nil
+}
+
+// symbolPackage returns the import path of the Go package a symbol belongs
+// to, or the empty string if it is not a Go symbol. Go symbols are named like
+// github.com/user/pkg.Function or (*github.com/user/pkg.Type).Method.
+func symbolPackage(name string) string {
+	name = strings.TrimLeft(name, "(*")
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot <= 0 {
+		return ""
+	}
+	return name[:slash+1+dot]
+}
+
+// compileUnit is a compile unit in the DWARF debug information, with the
+// address ranges of its code.
+type compileUnit struct {
+	name   string
+	ranges [][2]uint64
+}
+
+func (u compileUnit) contains(addr uint64) bool {
+	for _, r := range u.ranges {
+		if addr >= r[0] && addr < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// readCompileUnits returns the compile units in the debug information that
+// are not Go code (the Go code is in a single compile unit). Their names are
+// shortened to the file name.
+func readCompileUnits(data *dwarf.Data) []compileUnit {
+	var units []compileUnit
+	r := data.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil || entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		r.SkipChildren()
+		name, _ := entry.Val(dwarf.AttrName).(string)
+		if lang, ok := entry.Val(dwarf.AttrLanguage).(int64); ok && lang == 0x16 { // DW_LANG_Go
+			continue
+		}
+		ranges, err := data.Ranges(entry)
+		if err != nil || len(ranges) == 0 {
+			continue
+		}
+		units = append(units, compileUnit{name: filepath.Base(name), ranges: ranges})
+	}
+	return units
 }
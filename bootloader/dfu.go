@@ -0,0 +1,385 @@
+package bootloader
+
+// USB Device Firmware Upgrade (DFU) 1.1, and the DfuSe extensions used by the
+// ROM bootloader of STM32 chips. Plain DFU downloads the firmware as a stream
+// of blocks that the device writes where it wants, DfuSe has commands to set
+// the address and to erase flash pages.
+//
+// Specifications:
+// https://www.usb.org/sites/default/files/DFU_1.1.pdf
+// https://www.st.com/resource/en/application_note/cd00264379.pdf (AN3156)
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DFU class requests.
+const (
+	dfuDetach    = 0
+	dfuDnload    = 1
+	dfuGetStatus = 3
+	dfuClrStatus = 4
+	dfuAbort     = 6
+)
+
+// DFU states, as returned by DFU_GETSTATUS.
+const (
+	dfuStateIdle        = 2
+	dfuStateDnloadSync  = 3
+	dfuStateDnbusy      = 4
+	dfuStateDnloadIdle  = 5
+	dfuStateManifest    = 7
+	dfuStateManifestRst = 8
+	dfuStateError       = 10
+)
+
+// DfuSe commands, sent as a download of block 0.
+const (
+	dfuseSetAddress = 0x21
+	dfuseErase      = 0x41
+)
+
+// Request types of DFU class requests to the interface.
+const (
+	dfuRequestOut = 0x21
+	dfuRequestIn  = 0xa1
+)
+
+// dfuDevice is a device in DFU mode.
+type dfuDevice struct {
+	dev          usbDevice
+	iface        uint16
+	transferSize int
+	block        uint16
+}
+
+// FlashDFU writes the data to the flash of a device in DFU mode. The serial
+// number selects the device if several are connected, it may be empty. For
+// DfuSe devices (like the STM32 ROM bootloader) the flash pages are erased and
+// the data is written at the given address, after which the device starts the
+// program. Plain DFU devices decide themselves where the firmware is written,
+// so the address must match the start address of the application of the
+// bootloader.
+func FlashDFU(serial string, addr uint32, data []byte) error {
+	return FlashDFUChanged(serial, addr, data, nil)
+}
+
+// FlashDFUChanged is like FlashDFU, but it only erases and writes the flash
+// sectors of a DfuSe device that differ from the previous image, which is the
+// image that is currently in flash at the same address. Plain DFU devices are
+// always written completely.
+func FlashDFUChanged(serial string, addr uint32, data, previous []byte) error {
+	info, err := findUSBDevice(serial, "DFU device", func(d *usbDeviceInfo) bool {
+		return dfuInterface(d, 2) != nil
+	})
+	if err != nil {
+		// The device may be running its application, which can be asked
+		// to switch to DFU mode.
+		if detachErr := dfuDetachRuntime(serial); detachErr != nil {
+			return err
+		}
+		for i := 0; i < 50 && err != nil; i++ {
+			time.Sleep(100 * time.Millisecond)
+			info, err = findUSBDevice(serial, "DFU device", func(d *usbDeviceInfo) bool {
+				return dfuInterface(d, 2) != nil
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	dev, err := openUSBDevice(info)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	// DfuSe devices have an alternate setting for every memory, named by a
+	// string descriptor that also describes the layout of the memory. Use the
+	// internal flash.
+	iface := dfuInterface(info, 2)
+	var layout []flashSector
+	for i := range info.interfaces {
+		alt := &info.interfaces[i]
+		if alt.class != 0xfe || alt.subClass != 1 || alt.protocol != 2 || alt.name == 0 {
+			continue
+		}
+		name, err := stringDescriptor(dev, alt.name)
+		if err != nil || !strings.HasPrefix(name, "@Internal Flash") {
+			continue
+		}
+		layout, err = parseDfuseLayout(name)
+		if err != nil {
+			return err
+		}
+		iface = alt
+		break
+	}
+	if err := dev.claim(iface.number, iface.altSetting); err != nil {
+		return err
+	}
+
+	d := &dfuDevice{dev: dev, iface: uint16(iface.number), transferSize: 1024}
+	isDfuse := false
+	if len(iface.functional) >= 9 {
+		d.transferSize = int(binary.LittleEndian.Uint16(iface.functional[5:]))
+		isDfuse = binary.LittleEndian.Uint16(iface.functional[7:]) == 0x011a
+	}
+	if isDfuse && layout == nil {
+		return errors.New("bootloader: DfuSe device has no internal flash")
+	}
+	if err := d.reset(); err != nil {
+		return err
+	}
+
+	if isDfuse {
+		return d.downloadDfuse(layout, addr, data, previous)
+	}
+	return d.download(data)
+}
+
+// dfuInterface returns the first interface of the device with the DFU class
+// and the given protocol: 1 for the runtime interface of an application, 2 for
+// a device in DFU mode.
+func dfuInterface(d *usbDeviceInfo, protocol uint8) *usbInterface {
+	for i := range d.interfaces {
+		iface := &d.interfaces[i]
+		if iface.class == 0xfe && iface.subClass == 1 && iface.protocol == protocol {
+			return iface
+		}
+	}
+	return nil
+}
+
+// dfuDetachRuntime asks an application with a DFU runtime interface to switch
+// to DFU mode.
+func dfuDetachRuntime(serial string) error {
+	info, err := findUSBDevice(serial, "DFU device", func(d *usbDeviceInfo) bool {
+		return dfuInterface(d, 1) != nil
+	})
+	if err != nil {
+		return err
+	}
+	dev, err := openUSBDevice(info)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	iface := dfuInterface(info, 1)
+	if err := dev.claim(iface.number, iface.altSetting); err != nil {
+		return err
+	}
+	_, err = dev.control(dfuRequestOut, dfuDetach, 1000, uint16(iface.number), nil)
+	return err
+}
+
+// status returns the state of the device after waiting for the poll timeout
+// it requests.
+func (d *dfuDevice) status() (state uint8, err error) {
+	buf := make([]byte, 6)
+	n, err := d.dev.control(dfuRequestIn, dfuGetStatus, 0, d.iface, buf)
+	if err != nil {
+		return 0, err
+	}
+	if n != len(buf) {
+		return 0, errors.New("bootloader: invalid DFU status")
+	}
+	pollTimeout := uint32(buf[1]) | uint32(buf[2])<<8 | uint32(buf[3])<<16
+	time.Sleep(time.Duration(pollTimeout) * time.Millisecond)
+	if buf[0] != 0 {
+		return buf[4], fmt.Errorf("bootloader: DFU error status %d in state %d", buf[0], buf[4])
+	}
+	return buf[4], nil
+}
+
+// reset brings the device into the idle state, clearing an error state or
+// aborting a previous operation.
+func (d *dfuDevice) reset() error {
+	state, _ := d.status()
+	switch state {
+	case dfuStateIdle:
+		return nil
+	case dfuStateError:
+		if _, err := d.dev.control(dfuRequestOut, dfuClrStatus, 0, d.iface, nil); err != nil {
+			return err
+		}
+	default:
+		if _, err := d.dev.control(dfuRequestOut, dfuAbort, 0, d.iface, nil); err != nil {
+			return err
+		}
+	}
+	state, err := d.status()
+	if err != nil {
+		return err
+	}
+	if state != dfuStateIdle {
+		return fmt.Errorf("bootloader: DFU device is in state %d instead of idle", state)
+	}
+	return nil
+}
+
+// dnload sends a block to the device and waits until it has been processed.
+func (d *dfuDevice) dnload(block uint16, data []byte) error {
+	if _, err := d.dev.control(dfuRequestOut, dfuDnload, block, d.iface, data); err != nil {
+		return err
+	}
+	for {
+		state, err := d.status()
+		if err != nil {
+			return err
+		}
+		switch state {
+		case dfuStateDnbusy, dfuStateDnloadSync:
+			continue
+		case dfuStateDnloadIdle, dfuStateIdle, dfuStateManifest, dfuStateManifestRst:
+			return nil
+		default:
+			return fmt.Errorf("bootloader: unexpected DFU state %d", state)
+		}
+	}
+}
+
+// download writes the firmware to a plain DFU device and lets it start the
+// firmware.
+func (d *dfuDevice) download(data []byte) error {
+	for i := 0; i < len(data); i += d.transferSize {
+		end := i + d.transferSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := d.dnload(d.block, data[i:end]); err != nil {
+			return err
+		}
+		d.block++
+	}
+	// A download of zero bytes ends the transfer. The device may reset right
+	// away, so errors are ignored.
+	d.dnload(d.block, nil)
+	return nil
+}
+
+// flashSector is a flash sector that can be erased with the DfuSe erase
+// command.
+type flashSector struct {
+	addr uint32
+	size uint32
+}
+
+// parseDfuseLayout parses the memory layout in the name of a DfuSe alternate
+// setting, like "@Internal Flash /0x08000000/04*016Kg,01*064Kg,07*128Kg". It
+// lists the sectors as count*size with a unit (K or M) and a letter with the
+// access rights.
+func parseDfuseLayout(name string) ([]flashSector, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) < 3 {
+		return nil, errors.New("bootloader: invalid DfuSe memory layout: " + name)
+	}
+	var sectors []flashSector
+	for i := 1; i+1 < len(parts); i += 2 {
+		addr, err := strconv.ParseUint(strings.TrimSpace(parts[i]), 0, 32)
+		if err != nil {
+			return nil, errors.New("bootloader: invalid DfuSe memory layout: " + name)
+		}
+		for _, group := range strings.Split(parts[i+1], ",") {
+			group = strings.TrimSpace(group)
+			star := strings.IndexByte(group, '*')
+			if star < 0 || len(group) < star+3 {
+				return nil, errors.New("bootloader: invalid DfuSe memory layout: " + name)
+			}
+			count, err1 := strconv.Atoi(group[:star])
+			sizeText := group[star+1 : len(group)-1] // strip the access letter
+			unit := uint64(1)
+			switch sizeText[len(sizeText)-1] {
+			case 'K':
+				unit = 1024
+				sizeText = sizeText[:len(sizeText)-1]
+			case 'M':
+				unit = 1024 * 1024
+				sizeText = sizeText[:len(sizeText)-1]
+			case ' ', 'B':
+				sizeText = sizeText[:len(sizeText)-1]
+			}
+			size, err2 := strconv.ParseUint(sizeText, 10, 32)
+			if err1 != nil || err2 != nil {
+				return nil, errors.New("bootloader: invalid DfuSe memory layout: " + name)
+			}
+			for j := 0; j < count; j++ {
+				sectors = append(sectors, flashSector{uint32(addr), uint32(size * unit)})
+				addr += size * unit
+			}
+		}
+	}
+	return sectors, nil
+}
+
+// dfuseCommand sends a DfuSe command with an address argument.
+func (d *dfuDevice) dfuseCommand(cmd byte, addr uint32) error {
+	buf := []byte{cmd, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(buf[1:], addr)
+	return d.dnload(0, buf)
+}
+
+// downloadDfuse erases the sectors covered by the data, writes it at the given
+// address and starts the program. If previous is not nil, sectors with the
+// same contents in previous and data are left alone.
+func (d *dfuDevice) downloadDfuse(layout []flashSector, addr uint32, data, previous []byte) error {
+	// Find the parts of the data that must be written: the sectors that
+	// changed, merged when they are next to each other.
+	end := addr + uint32(len(data))
+	var runs []flashSector
+	for _, sector := range layout {
+		if sector.addr+sector.size <= addr || sector.addr >= end {
+			continue
+		}
+		start := sector.addr
+		if start < addr {
+			start = addr
+		}
+		stop := sector.addr + sector.size
+		if stop > end {
+			stop = end
+		}
+		if previous != nil && int(stop-addr) <= len(previous) && bytes.Equal(data[start-addr:stop-addr], previous[start-addr:stop-addr]) {
+			continue
+		}
+		if err := d.dfuseCommand(dfuseErase, sector.addr); err != nil {
+			return err
+		}
+		if n := len(runs); n != 0 && runs[n-1].addr+runs[n-1].size == start {
+			runs[n-1].size += stop - start
+		} else {
+			runs = append(runs, flashSector{start, stop - start})
+		}
+	}
+	for _, run := range runs {
+		if err := d.dfuseCommand(dfuseSetAddress, run.addr); err != nil {
+			return err
+		}
+		// Data blocks start at block number 2. The address of a block is the
+		// address pointer plus (block-2) * transferSize.
+		chunks := data[run.addr-addr : run.addr-addr+run.size]
+		for i := 0; i < len(chunks); i += d.transferSize {
+			chunk := chunks[i:]
+			if len(chunk) > d.transferSize {
+				chunk = chunk[:d.transferSize]
+			}
+			if err := d.dnload(uint16(2+i/d.transferSize), chunk); err != nil {
+				return err
+			}
+		}
+	}
+	// Leave DFU mode: jump to the program at the address pointer. The device
+	// resets, so errors after the request are ignored.
+	if err := d.dfuseCommand(dfuseSetAddress, addr); err != nil {
+		return err
+	}
+	d.dev.control(dfuRequestOut, dfuDnload, 0, d.iface, nil)
+	d.status()
+	return nil
+}
@@ -0,0 +1,162 @@
+package bootloader
+
+// PICOBOOT, the USB protocol of the RP2040 ROM bootloader. Commands are sent
+// as 32-byte packets on a bulk OUT endpoint of a vendor specific interface,
+// followed by a data phase for commands that transfer data, and acknowledged
+// by a zero length packet in the other direction.
+//
+// Specification: RP2040 datasheet, section 2.8.5.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// USB IDs of the RP2040 ROM bootloader.
+const (
+	picobootVID = 0x2e8a
+	picobootPID = 0x0003
+)
+
+// PICOBOOT commands.
+const (
+	picobootExclusiveAccess = 0x01
+	picobootReboot          = 0x02
+	picobootFlashErase      = 0x03
+	picobootWrite           = 0x05
+	picobootExitXIP         = 0x06
+)
+
+const (
+	picobootMagic       = 0x431fd10b
+	picobootIfReset     = 0x41 // vendor request to reset the interface
+	picobootSectorSize  = 4096
+	picobootPageSize    = 256
+	picobootFlashStart  = 0x10000000
+	picobootStackPtr    = 0x20042000 // end of SRAM, used as initial stack
+	picobootRebootDelay = 100        // in milliseconds
+)
+
+// picobootDevice is an opened RP2040 in bootloader mode.
+type picobootDevice struct {
+	dev   usbDevice
+	out   uint8 // bulk OUT endpoint
+	in    uint8 // bulk IN endpoint
+	token uint32
+}
+
+// FlashPicoboot writes the data to the flash of an RP2040 in bootloader mode
+// (BOOTSEL) at the given address and reboots it to start the program. The
+// serial number selects the device if several are connected, it may be empty.
+func FlashPicoboot(serial string, addr uint32, data []byte) error {
+	if addr < picobootFlashStart || addr%picobootPageSize != 0 {
+		return fmt.Errorf("bootloader: flash address 0x%08x is not a page in flash", addr)
+	}
+	info, err := findUSBDevice(serial, "RP2040 in BOOTSEL mode", func(d *usbDeviceInfo) bool {
+		return d.vid == picobootVID && d.pid == picobootPID
+	})
+	if err != nil {
+		return err
+	}
+	dev, err := openUSBDevice(info)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	// The PICOBOOT interface is the vendor specific interface with a bulk
+	// endpoint in each direction.
+	p := &picobootDevice{dev: dev}
+	var iface *usbInterface
+	for i := range info.interfaces {
+		if info.interfaces[i].class == 0xff {
+			iface = &info.interfaces[i]
+		}
+	}
+	if iface == nil {
+		return errors.New("bootloader: PICOBOOT interface not found")
+	}
+	for _, ep := range iface.endpoints {
+		if ep.attributes&3 != transferBulk {
+			continue
+		}
+		if ep.address&0x80 != 0 {
+			p.in = ep.address
+		} else {
+			p.out = ep.address
+		}
+	}
+	if p.in == 0 || p.out == 0 {
+		return errors.New("bootloader: PICOBOOT endpoints not found")
+	}
+	if err := dev.claim(iface.number, iface.altSetting); err != nil {
+		return err
+	}
+	if _, err := dev.control(0x41, picobootIfReset, 0, uint16(iface.number), nil); err != nil {
+		return err
+	}
+
+	// Pad the data to whole pages, and erase whole sectors.
+	data = append([]byte(nil), data...)
+	for len(data)%picobootPageSize != 0 {
+		data = append(data, 0xff)
+	}
+	eraseStart := addr &^ (picobootSectorSize - 1)
+	eraseEnd := (addr + uint32(len(data)) + picobootSectorSize - 1) &^ (picobootSectorSize - 1)
+
+	// Lock out the mass storage interface while flashing, and leave the XIP
+	// mode so that the flash can be written.
+	if err := p.command(picobootExclusiveAccess, []byte{1}, nil); err != nil {
+		return err
+	}
+	if err := p.command(picobootExitXIP, nil, nil); err != nil {
+		return err
+	}
+	if err := p.command(picobootFlashErase, p.addrSize(eraseStart, eraseEnd-eraseStart), nil); err != nil {
+		return err
+	}
+	if err := p.command(picobootWrite, p.addrSize(addr, uint32(len(data))), data); err != nil {
+		return err
+	}
+
+	// Reboot into the program in flash (PC 0 means a normal boot).
+	args := make([]byte, 12)
+	binary.LittleEndian.PutUint32(args[4:], picobootStackPtr)
+	binary.LittleEndian.PutUint32(args[8:], picobootRebootDelay)
+	return p.command(picobootReboot, args, nil)
+}
+
+// addrSize returns the arguments of a command that takes an address and a
+// size.
+func (p *picobootDevice) addrSize(addr, size uint32) []byte {
+	args := make([]byte, 8)
+	binary.LittleEndian.PutUint32(args, addr)
+	binary.LittleEndian.PutUint32(args[4:], size)
+	return args
+}
+
+// command sends a command with the given arguments, followed by the data to
+// write (if any), and waits for the acknowledgement.
+func (p *picobootDevice) command(id uint8, args, data []byte) error {
+	p.token++
+	packet := make([]byte, 32)
+	binary.LittleEndian.PutUint32(packet[0:], picobootMagic)
+	binary.LittleEndian.PutUint32(packet[4:], p.token)
+	packet[8] = id
+	packet[9] = uint8(len(args))
+	binary.LittleEndian.PutUint32(packet[12:], uint32(len(data)))
+	copy(packet[16:], args)
+	if _, err := p.dev.bulk(p.out, packet); err != nil {
+		return fmt.Errorf("bootloader: PICOBOOT command 0x%02x failed: %s", id, err)
+	}
+	if len(data) != 0 {
+		if _, err := p.dev.bulk(p.out, data); err != nil {
+			return fmt.Errorf("bootloader: PICOBOOT command 0x%02x failed: %s", id, err)
+		}
+	}
+	if _, err := p.dev.bulk(p.in, nil); err != nil {
+		return fmt.Errorf("bootloader: PICOBOOT command 0x%02x was not acknowledged: %s", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package bootloader
+
+// SAM-BA, the protocol of the Atmel ROM monitor that is also implemented by
+// the Arduino and Adafruit bootloaders for the SAMD21. It is a simple text
+// protocol over a (USB CDC) serial port with commands to read and write
+// memory and to jump to an address. There is no flash command: the flash is
+// programmed by driving the NVM controller through memory writes, like with a
+// debug probe.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// NVMCTRL of the SAMD21.
+const (
+	samNVMCtrlA    = 0x41004000
+	samNVMCtrlB    = 0x41004004
+	samNVMParam    = 0x41004008
+	samNVMIntFlag  = 0x41004014
+	samNVMStatus   = 0x41004018
+	samNVMAddr     = 0x4100401c
+	samNVMCmdER    = 0xa502 // erase row, with the CMDEX key
+	samNVMCmdWP    = 0xa504 // write page
+	samNVMCmdPBC   = 0xa544 // page buffer clear
+	samNVMManW     = 1 << 7 // manual page write
+	samNVMErrors   = 0x1c   // PROGE, LOCKE and NVME in STATUS
+	samPagesPerRow = 4
+)
+
+// Application Interrupt and Reset Control Register, to reset the chip after
+// flashing.
+const (
+	samAIRCR    = 0xe000ed0c
+	samSysReset = 0x05fa0004
+)
+
+// sambaConn is a connection to a SAM-BA bootloader.
+type sambaConn struct {
+	rw io.ReadWriter
+}
+
+// FlashSAMBA writes the data to the flash of a SAMD21 at the given address,
+// through the SAM-BA bootloader on the other side of the serial port, and
+// resets the chip to start the program. The bootloader itself is protected by
+// the chip, so the address must be after it (usually 0x2000).
+func FlashSAMBA(port io.ReadWriter, addr uint32, data []byte) error {
+	s := &sambaConn{port}
+
+	// Switch to binary mode, in which reads return raw bytes.
+	if _, err := io.WriteString(port, "N#"); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(port, reply); err != nil {
+		return errors.New("bootloader: no reply from SAM-BA bootloader, is the board in bootloader mode? " + err.Error())
+	}
+
+	param, err := s.readWord(samNVMParam)
+	if err != nil {
+		return err
+	}
+	pageSize := uint32(8) << ((param >> 16) & 7)
+	if addr%pageSize != 0 {
+		return errors.New("bootloader: flash address is not page aligned")
+	}
+	rowSize := pageSize * samPagesPerRow
+	data = append([]byte(nil), data...)
+	for uint32(len(data))%pageSize != 0 {
+		data = append(data, 0xff)
+	}
+	end := addr + uint32(len(data))
+
+	ctrlB, err := s.readWord(samNVMCtrlB)
+	if err != nil {
+		return err
+	}
+	if err := s.writeWord(samNVMCtrlB, ctrlB|samNVMManW); err != nil {
+		return err
+	}
+	for row := addr &^ (rowSize - 1); row < end; row += rowSize {
+		if err := s.nvmCommand(samNVMCmdER, row); err != nil {
+			return err
+		}
+	}
+	for page := addr; page < end; page += pageSize {
+		if err := s.nvmCommand(samNVMCmdPBC, page); err != nil {
+			return err
+		}
+		for i := page; i < page+pageSize; i += 4 {
+			word := binary.LittleEndian.Uint32(data[i-addr:])
+			if err := s.writeWord(i, word); err != nil {
+				return err
+			}
+		}
+		if err := s.nvmCommand(samNVMCmdWP, page); err != nil {
+			return err
+		}
+	}
+	if err := s.writeWord(samNVMCtrlB, ctrlB); err != nil {
+		return err
+	}
+
+	// The reset is not acknowledged, as the USB connection is gone.
+	s.writeWord(samAIRCR, samSysReset)
+	return nil
+}
+
+// nvmCommand executes a NVMCTRL command on the given address and waits for it
+// to complete.
+func (s *sambaConn) nvmCommand(cmd uint16, addr uint32) error {
+	if err := s.writeWord(samNVMAddr, addr/2); err != nil {
+		return err
+	}
+	if err := s.command(fmt.Sprintf("H%08X,%04X#", samNVMCtrlA, cmd)); err != nil {
+		return err
+	}
+	ready := false
+	for i := 0; i < 1000 && !ready; i++ {
+		flags, err := s.readWord(samNVMIntFlag)
+		if err != nil {
+			return err
+		}
+		ready = flags&1 != 0
+	}
+	if !ready {
+		return errors.New("bootloader: timeout waiting for the flash controller")
+	}
+	status, err := s.readWord(samNVMStatus)
+	if err != nil {
+		return err
+	}
+	if status&samNVMErrors != 0 {
+		s.writeWord(samNVMStatus, samNVMErrors) // clear the errors
+		if status&0x08 != 0 {
+			return errors.New("bootloader: flash region is locked (bootloader protection?)")
+		}
+		return errors.New("bootloader: flash programming error")
+	}
+	return nil
+}
+
+// command sends a command that has no reply.
+func (s *sambaConn) command(cmd string) error {
+	_, err := io.WriteString(s.rw, cmd)
+	return err
+}
+
+// readWord reads a 32-bit word from memory.
+func (s *sambaConn) readWord(addr uint32) (uint32, error) {
+	if err := s.command(fmt.Sprintf("w%08X,4#", addr)); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(s.rw, buf); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+// writeWord writes a 32-bit word to memory.
+func (s *sambaConn) writeWord(addr, value uint32) error {
+	return s.command(fmt.Sprintf("W%08X,%08X#", addr, value))
+}
@@ -0,0 +1,91 @@
+package bootloader
+
+// UF2 bootloaders present themselves as a USB drive. A UF2 file that is copied
+// to the drive is written to flash, after which the bootloader starts the
+// program. The drive is recognized by the INFO_UF2.TXT file in it.
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// FindUF2Drive returns the mount point of the drive of a UF2 bootloader, or
+// an error if no such drive (or more than one) is mounted.
+func FindUF2Drive() (string, error) {
+	var patterns []string
+	switch runtime.GOOS {
+	case "linux":
+		patterns = []string{"/media/*", "/media/*/*", "/run/media/*/*", "/mnt/*"}
+	case "darwin":
+		patterns = []string{"/Volumes/*"}
+	case "windows":
+		for c := 'D'; c <= 'Z'; c++ {
+			patterns = append(patterns, string(c)+`:\`)
+		}
+	}
+	var drives []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		for _, dir := range matches {
+			if _, err := os.Stat(filepath.Join(dir, "INFO_UF2.TXT")); err == nil {
+				drives = append(drives, dir)
+			}
+		}
+	}
+	switch len(drives) {
+	case 0:
+		msg := "bootloader: no UF2 drive found, is the board in bootloader mode?"
+		if u, err := user.Current(); err == nil && runtime.GOOS == "linux" {
+			msg += " (the drive must be mounted, for example in /media/" + u.Username + ")"
+		}
+		return "", errors.New(msg)
+	case 1:
+		return drives[0], nil
+	default:
+		msg := "bootloader: multiple UF2 drives found:"
+		for _, drive := range drives {
+			msg += "\n  " + drive
+		}
+		return "", errors.New(msg)
+	}
+}
+
+// FlashUF2 copies the UF2 file to the drive of a UF2 bootloader. If no drive
+// is mounted yet, it waits for the given time, as the drive only appears a
+// moment after the board entered the bootloader.
+func FlashUF2(path string, wait time.Duration) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(wait)
+	drive, err := FindUF2Drive()
+	for err != nil && time.Now().Before(deadline) {
+		time.Sleep(200 * time.Millisecond)
+		drive, err = FindUF2Drive()
+	}
+	if err != nil {
+		return err
+	}
+	// The bootloader resets the board when the last block is written, which
+	// may cause an error when the file is closed.
+	f, err := os.Create(filepath.Join(drive, "flash.uf2"))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	f.Sync()
+	f.Close()
+	return nil
+}
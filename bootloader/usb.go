@@ -0,0 +1,187 @@
+// Package bootloader implements the protocols of common bootloaders in Go, so
+// that boards can be flashed without installing the vendor tools:
+//
+//   * USB DFU 1.1, including the DfuSe extensions of the STM32 ROM bootloader
+//     (replaces dfu-util),
+//   * SAM-BA over a serial port, as used by the Arduino and Adafruit SAMD
+//     bootloaders (replaces bossac),
+//   * PICOBOOT, the USB protocol of the RP2040 ROM bootloader (replaces
+//     picotool),
+//   * UF2 mass storage: copying a UF2 file to the drive of the bootloader.
+//
+// Direct USB access is only implemented on Linux, through usbfs.
+package bootloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// USB descriptor types.
+const (
+	descDevice     = 1
+	descConfig     = 2
+	descString     = 3
+	descInterface  = 4
+	descEndpoint   = 5
+	descFunctional = 0x21 // class specific, like the DFU functional descriptor
+)
+
+// Standard USB requests.
+const (
+	reqGetDescriptor = 6
+)
+
+// usbDeviceInfo describes a connected USB device, from its descriptors.
+type usbDeviceInfo struct {
+	path       string // OS specific path to open the device
+	vid        uint16
+	pid        uint16
+	serial     string
+	interfaces []usbInterface
+}
+
+// String returns the USB IDs and the serial number of the device, for error
+// messages.
+func (d usbDeviceInfo) String() string {
+	s := fmt.Sprintf("%04x:%04x", d.vid, d.pid)
+	if d.serial != "" {
+		s += " serial=" + d.serial
+	}
+	return s
+}
+
+// usbInterface is an alternate setting of an interface of a USB device.
+type usbInterface struct {
+	number     uint8
+	altSetting uint8
+	class      uint8
+	subClass   uint8
+	protocol   uint8
+	name       uint8 // index of the string descriptor, 0 if none
+	endpoints  []usbEndpoint
+	functional []byte // class specific descriptor, if any
+}
+
+// usbEndpoint is an endpoint of an interface.
+type usbEndpoint struct {
+	address    uint8 // bit 7 is set for IN endpoints
+	attributes uint8 // transfer type in bits 0..1
+}
+
+// Endpoint transfer types.
+const (
+	transferBulk = 2
+)
+
+// usbDevice is an opened USB device.
+type usbDevice interface {
+	// control does a control transfer on endpoint 0. The direction is given by
+	// bit 7 of the request type. It returns the number of bytes transferred.
+	control(requestType, request uint8, value, index uint16, data []byte) (int, error)
+
+	// bulk does a bulk transfer on the given endpoint. It returns the number of
+	// bytes transferred.
+	bulk(endpoint uint8, data []byte) (int, error)
+
+	// claim claims an interface and selects the alternate setting.
+	claim(iface, altSetting uint8) error
+
+	// Close releases the claimed interface and closes the device.
+	Close() error
+}
+
+// parseDescriptors parses the device descriptor and the configuration
+// descriptor (with its interface, endpoint and functional descriptors) as
+// returned by the device, into the info struct.
+func parseDescriptors(data []byte, info *usbDeviceInfo) error {
+	var iface *usbInterface
+	for len(data) >= 2 {
+		length := int(data[0])
+		if length < 2 || length > len(data) {
+			return errors.New("bootloader: invalid USB descriptor")
+		}
+		desc := data[:length]
+		data = data[length:]
+		switch desc[1] {
+		case descDevice:
+			if length < 18 {
+				return errors.New("bootloader: invalid USB device descriptor")
+			}
+			info.vid = binary.LittleEndian.Uint16(desc[8:])
+			info.pid = binary.LittleEndian.Uint16(desc[10:])
+		case descInterface:
+			if length < 9 {
+				return errors.New("bootloader: invalid USB interface descriptor")
+			}
+			info.interfaces = append(info.interfaces, usbInterface{
+				number:     desc[2],
+				altSetting: desc[3],
+				class:      desc[5],
+				subClass:   desc[6],
+				protocol:   desc[7],
+				name:       desc[8],
+			})
+			iface = &info.interfaces[len(info.interfaces)-1]
+		case descEndpoint:
+			if iface != nil && length >= 4 {
+				iface.endpoints = append(iface.endpoints, usbEndpoint{desc[2], desc[3]})
+			}
+		case descFunctional:
+			if iface != nil {
+				iface.functional = desc
+			}
+		}
+	}
+	return nil
+}
+
+// stringDescriptor reads a string descriptor of the device, in US English.
+func stringDescriptor(dev usbDevice, index uint8) (string, error) {
+	buf := make([]byte, 255)
+	n, err := dev.control(0x80, reqGetDescriptor, descString<<8|uint16(index), 0x0409, buf)
+	if err != nil {
+		return "", err
+	}
+	if n < 2 || buf[1] != descString {
+		return "", errors.New("bootloader: invalid USB string descriptor")
+	}
+	var s []rune
+	for i := 2; i+1 < n; i += 2 {
+		s = append(s, rune(binary.LittleEndian.Uint16(buf[i:])))
+	}
+	return string(s), nil
+}
+
+// findUSBDevice returns the one connected USB device for which match returns
+// true. It is an error if there is no such device, or if there are several
+// and no serial number is given to choose one.
+func findUSBDevice(serial, what string, match func(d *usbDeviceInfo) bool) (*usbDeviceInfo, error) {
+	devices, err := listUSBDevices()
+	if err != nil {
+		return nil, err
+	}
+	var found []*usbDeviceInfo
+	for i := range devices {
+		d := &devices[i]
+		if match(d) && (serial == "" || d.serial == serial) {
+			found = append(found, d)
+		}
+	}
+	switch len(found) {
+	case 0:
+		if serial != "" {
+			return nil, errors.New("bootloader: no " + what + " found with serial number " + serial)
+		}
+		return nil, errors.New("bootloader: no " + what + " found, is the board in bootloader mode?")
+	case 1:
+		return found[0], nil
+	default:
+		msg := "bootloader: multiple devices found, please specify one with -port:"
+		for _, d := range found {
+			msg += "\n  " + d.String()
+		}
+		return nil, errors.New(msg)
+	}
+}
@@ -0,0 +1,175 @@
+// +build linux
+
+package bootloader
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl requests of usbfs, see linux/usbdevice_fs.h.
+var (
+	usbdevfsControl          = ioc(3, 'U', 0, unsafe.Sizeof(usbdevfsCtrlTransfer{}))
+	usbdevfsBulk             = ioc(3, 'U', 2, unsafe.Sizeof(usbdevfsBulkTransfer{}))
+	usbdevfsSetInterface     = ioc(2, 'U', 4, unsafe.Sizeof(usbdevfsSetInterfaceArgs{}))
+	usbdevfsClaimInterface   = ioc(2, 'U', 15, 4)
+	usbdevfsReleaseInterface = ioc(2, 'U', 16, 4)
+)
+
+// ioc encodes an ioctl request number, like the _IOC macro.
+func ioc(dir, typ, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | typ<<8 | nr
+}
+
+type usbdevfsCtrlTransfer struct {
+	requestType uint8
+	request     uint8
+	value       uint16
+	index       uint16
+	length      uint16
+	timeout     uint32 // in milliseconds
+	data        uintptr
+}
+
+type usbdevfsBulkTransfer struct {
+	endpoint uint32
+	length   uint32
+	timeout  uint32 // in milliseconds
+	data     uintptr
+}
+
+type usbdevfsSetInterfaceArgs struct {
+	iface      uint32
+	altSetting uint32
+}
+
+// usbTimeout is the timeout of a single transfer, in milliseconds. Erasing
+// flash can take a while before the device answers.
+const usbTimeout = 5000
+
+// listUSBDevices returns all connected USB devices, using the information in
+// /sys/bus/usb/devices.
+func listUSBDevices() ([]usbDeviceInfo, error) {
+	entries, err := ioutil.ReadDir("/sys/bus/usb/devices")
+	if err != nil {
+		return nil, err
+	}
+	var devices []usbDeviceInfo
+	for _, entry := range entries {
+		dir := filepath.Join("/sys/bus/usb/devices", entry.Name())
+		descriptors, err := ioutil.ReadFile(filepath.Join(dir, "descriptors"))
+		if err != nil {
+			continue // an interface, not a device
+		}
+		busnum, err1 := strconv.Atoi(readSysfsAttr(dir, "busnum"))
+		devnum, err2 := strconv.Atoi(readSysfsAttr(dir, "devnum"))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		info := usbDeviceInfo{
+			path:   filepath.Join("/dev/bus/usb", leftPad(busnum), leftPad(devnum)),
+			serial: readSysfsAttr(dir, "serial"),
+		}
+		if err := parseDescriptors(descriptors, &info); err != nil {
+			continue
+		}
+		devices = append(devices, info)
+	}
+	return devices, nil
+}
+
+// leftPad formats a bus or device number like in the /dev/bus/usb paths.
+func leftPad(n int) string {
+	s := strconv.Itoa(n)
+	for len(s) < 3 {
+		s = "0" + s
+	}
+	return s
+}
+
+// readSysfsAttr reads a single attribute from a sysfs directory, or returns
+// the empty string if it doesn't exist.
+func readSysfsAttr(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// usbfsDevice is a USB device opened through usbfs.
+type usbfsDevice struct {
+	f       *os.File
+	claimed int // claimed interface, or -1
+}
+
+func openUSBDevice(info *usbDeviceInfo) (usbDevice, error) {
+	f, err := os.OpenFile(info.path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, errors.New("bootloader: no permission to open " + info.path + ", you may need a udev rule for " + info.String())
+		}
+		return nil, err
+	}
+	return &usbfsDevice{f: f, claimed: -1}, nil
+}
+
+func (d *usbfsDevice) ioctl(request uintptr, arg unsafe.Pointer) (int, error) {
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.f.Fd(), request, uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func (d *usbfsDevice) control(requestType, request uint8, value, index uint16, data []byte) (int, error) {
+	transfer := usbdevfsCtrlTransfer{
+		requestType: requestType,
+		request:     request,
+		value:       value,
+		index:       index,
+		length:      uint16(len(data)),
+		timeout:     usbTimeout,
+	}
+	if len(data) != 0 {
+		transfer.data = uintptr(unsafe.Pointer(&data[0]))
+	}
+	return d.ioctl(usbdevfsControl, unsafe.Pointer(&transfer))
+}
+
+func (d *usbfsDevice) bulk(endpoint uint8, data []byte) (int, error) {
+	transfer := usbdevfsBulkTransfer{
+		endpoint: uint32(endpoint),
+		length:   uint32(len(data)),
+		timeout:  usbTimeout,
+	}
+	if len(data) != 0 {
+		transfer.data = uintptr(unsafe.Pointer(&data[0]))
+	}
+	return d.ioctl(usbdevfsBulk, unsafe.Pointer(&transfer))
+}
+
+func (d *usbfsDevice) claim(iface, altSetting uint8) error {
+	number := uint32(iface)
+	if _, err := d.ioctl(usbdevfsClaimInterface, unsafe.Pointer(&number)); err != nil {
+		return errors.New("bootloader: could not claim USB interface: " + err.Error())
+	}
+	d.claimed = int(iface)
+	args := usbdevfsSetInterfaceArgs{uint32(iface), uint32(altSetting)}
+	_, err := d.ioctl(usbdevfsSetInterface, unsafe.Pointer(&args))
+	return err
+}
+
+func (d *usbfsDevice) Close() error {
+	if d.claimed >= 0 {
+		number := uint32(d.claimed)
+		d.ioctl(usbdevfsReleaseInterface, unsafe.Pointer(&number))
+	}
+	return d.f.Close()
+}
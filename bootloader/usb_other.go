@@ -0,0 +1,17 @@
+// +build !linux
+
+package bootloader
+
+import (
+	"errors"
+)
+
+// listUSBDevices is only implemented on Linux, where usbfs gives direct
+// access to USB devices.
+func listUSBDevices() ([]usbDeviceInfo, error) {
+	return nil, errors.New("bootloader: USB bootloaders are only supported on Linux")
+}
+
+func openUSBDevice(info *usbDeviceInfo) (usbDevice, error) {
+	return nil, errors.New("bootloader: USB bootloaders are only supported on Linux")
+}
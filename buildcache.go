@@ -1,9 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -105,22 +111,157 @@ func moveFile(src, dst string) error {
 		return err
 	}
 	defer inf.Close()
-	outpath := dst + ".tmp"
-	outf, err := os.Create(outpath)
+	// Copy to a temporary file in the destination directory first, so that
+	// dst is replaced atomically. Concurrent builds each use their own
+	// temporary file.
+	outf, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+".tmp*")
 	if err != nil {
 		return err
 	}
+	outpath := outf.Name()
+	// TempFile creates the file with mode 0600, keep the mode of src instead.
+	if st, err := inf.Stat(); err == nil {
+		outf.Chmod(st.Mode().Perm())
+	}
 
 	_, err = io.Copy(outf, inf)
+	if err != nil {
+		outf.Close()
+		os.Remove(outpath)
+		return err
+	}
+
+	err = outf.Close()
 	if err != nil {
 		os.Remove(outpath)
 		return err
 	}
 
-	err = os.Rename(dst+".tmp", dst)
+	err = os.Rename(outpath, dst)
 	if err != nil {
+		os.Remove(outpath)
 		return err
 	}
+	return nil
+}
+
+// maxObjectCacheSize is the size of all object files in the cache above which
+// the least recently used ones are removed.
+const maxObjectCacheSize = 256 * 1024 * 1024
+
+// isObjectCacheable returns whether the object file of a build may be taken
+// from the cache. This is not possible if the object file itself is the
+// output, or when the optimization passes must run because they write a report
+// or print something. Note that warnings of the optimization passes (like
+// -interrupt-check=warn) are only printed when the object file is created.
+// Checks that fail the build (like -no-recursion) don't need to run again:
+// their options are part of the cache key, and only object files of
+// successful builds are stored.
+func isObjectCacheable(outpath string, config *BuildConfig) bool {
+	switch filepath.Ext(outpath) {
+	case ".o", ".bc", ".ll":
+		return false
+	}
+	return config.printAllocs == nil && !config.printAsync && config.strictReport == "" && config.scrubMap == "" && config.whyLive == "" && !config.dumpSSA
+}
+
+// objectCacheKey returns the key of an object file in the cache. The object
+// file only depends on the IR of the program before optimization, which
+// includes the contents of all Go packages and the compiler options that
+// affect them, and on the options of the later steps. The TinyGo executable
+// itself is part of the key, so that development builds of TinyGo don't reuse
+// object files of each other. All options that the interpreter and the
+// optimization passes read must be included, including the options of checks
+// that may fail the build.
+func objectCacheKey(ir string, spec *TargetSpec, config *BuildConfig) (string, error) {
+	specData, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "tinygo %s\n", version)
+	if executable, err := os.Executable(); err == nil {
+		if st, err := os.Stat(executable); err == nil {
+			fmt.Fprintf(h, "executable %s %d %d\n", executable, st.Size(), st.ModTime().UnixNano())
+		}
+	}
+	fmt.Fprintf(h, "opt=%s wasm-abi=%s panic=%s panic-trace=%v\n", config.opt, config.wasmAbi, config.panicStrategy, config.panicTrace)
+	fmt.Fprintf(h, "interrupt-check=%s no-recursion=%v strict=%v\n", config.isrCheck, config.noRecursion, config.strict)
+	fmt.Fprintf(h, "%s\n", specData)
+	io.WriteString(h, ir)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheLoadObject returns the path of the cached object file with the given
+// key, or "" if there is none.
+func cacheLoadObject(key string) string {
+	path := filepath.Join(cacheDir(), "objects", key+".o")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	// Update the modification time, so that recently used object files can
+	// be told apart from old ones when cleaning up the cache.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path
+}
+
+// cacheStoreObject copies an object file into the cache. Errors are ignored:
+// the cache is only an optimization.
+func cacheStoreObject(objfile, key string) {
+	dir := filepath.Join(cacheDir(), "objects")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return
+	}
+	// Write to a temporary file first, so that a concurrent build never sees
+	// a partially written object file. Every build uses its own temporary
+	// file.
+	tmpfile, err := ioutil.TempFile(dir, key+".o.tmp*")
+	if err != nil {
+		return
+	}
+	tmppath := tmpfile.Name()
+	tmpfile.Close()
+	data, err := ioutil.ReadFile(objfile)
+	if err == nil {
+		err = ioutil.WriteFile(tmppath, data, 0666)
+	}
+	if err == nil {
+		err = os.Rename(tmppath, filepath.Join(dir, key+".o"))
+	}
+	if err != nil {
+		os.Remove(tmppath)
+		return
+	}
+	trimObjectCache(dir, maxObjectCacheSize)
+}
 
-	return outf.Close()
+// trimObjectCache removes the least recently used object files from the cache
+// until the remaining ones take up at most maxSize bytes. Loading an object
+// file updates its modification time, see cacheLoadObject.
+func trimObjectCache(dir string, maxSize int64) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var objects []os.FileInfo
+	var size int64
+	for _, info := range infos {
+		if filepath.Ext(info.Name()) != ".o" || !info.Mode().IsRegular() {
+			continue // temporary file of a concurrent build, or not ours
+		}
+		objects = append(objects, info)
+		size += info.Size()
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ModTime().Before(objects[j].ModTime())
+	})
+	for _, info := range objects {
+		if size <= maxSize {
+			break
+		}
+		if os.Remove(filepath.Join(dir, info.Name())) == nil {
+			size -= info.Size()
+		}
+	}
 }
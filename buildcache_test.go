@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTrimObjectCache checks that the least recently used object files are
+// removed from the cache first, and that temporary files are left alone.
+func TestTrimObjectCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinygo-cache")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	files := []string{"a.o", "b.o", "c.o", "d.o.tmp123"}
+	for i, name := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, make([]byte, 100), 0666); err != nil {
+			t.Fatal(err)
+		}
+		// a.o is the oldest, c.o the newest.
+		mtime := now.Add(time.Duration(i-len(files)) * time.Minute)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trimObjectCache(dir, 250)
+	for _, file := range []struct {
+		name string
+		kept bool
+	}{
+		{"a.o", false},
+		{"b.o", true},
+		{"c.o", true},
+		{"d.o.tmp123", true},
+	} {
+		_, err := os.Stat(filepath.Join(dir, file.name))
+		if kept := err == nil; kept != file.kept {
+			t.Errorf("%s: expected kept=%v, got kept=%v", file.name, file.kept, kept)
+		}
+	}
+}
@@ -246,9 +246,20 @@ func compileBuiltins(target string, callback func(path string) error) error {
 	}
 
 	// Put all builtins in an archive to link as a static library.
-	// Note: this does not create a symbol index, but ld.lld doesn't seem to
-	// care.
 	arpath := filepath.Join(dir, "librt.a")
+	err = makeArchive(arpath, objs)
+	if err != nil {
+		return err
+	}
+
+	// Give the caller the resulting file. The callback must copy the file,
+	// because after it returns the temporary directory will be removed.
+	return callback(arpath)
+}
+
+// makeArchive creates a static library at arpath from the given object files.
+// Note: this does not create a symbol index, but ld.lld doesn't seem to care.
+func makeArchive(arpath string, objs []string) error {
 	arfile, err := os.Create(arpath)
 	if err != nil {
 		return err
@@ -286,9 +297,5 @@ func compileBuiltins(target string, callback func(path string) error) error {
 			return errors.New("file modified during ar creation: " + arpath)
 		}
 	}
-
-	// Give the caller the resulting file. The callback must copy the file,
-	// because after it returns the temporary directory will be removed.
-	arfile.Close()
-	return callback(arpath)
+	return arfile.Close()
 }
@@ -0,0 +1,148 @@
+package main
+
+// This file compiles the CMSIS-DSP library for programs that import the dsp
+// package. The library is compiled once per target triple and cached, like
+// compiler-rt.
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tinygo-org/tinygo/loader"
+)
+
+// Source files of the CMSIS-DSP library that are needed by the bindings in
+// src/dsp. Keep this in sync with tools/gen-cmsis-dsp.py.
+var cmsisDSPSources = []string{
+	"BasicMathFunctions/arm_dot_prod_f32.c",
+	"CommonTables/arm_common_tables.c",
+	"CommonTables/arm_const_structs.c",
+	"FilteringFunctions/arm_biquad_cascade_df1_f32.c",
+	"FilteringFunctions/arm_biquad_cascade_df1_init_f32.c",
+	"FilteringFunctions/arm_fir_f32.c",
+	"FilteringFunctions/arm_fir_init_f32.c",
+	"FilteringFunctions/arm_fir_init_q31.c",
+	"FilteringFunctions/arm_fir_q31.c",
+	"MatrixFunctions/arm_mat_add_f32.c",
+	"MatrixFunctions/arm_mat_init_f32.c",
+	"MatrixFunctions/arm_mat_inverse_f32.c",
+	"MatrixFunctions/arm_mat_mult_f32.c",
+	"MatrixFunctions/arm_mat_trans_f32.c",
+	"StatisticsFunctions/arm_max_f32.c",
+	"StatisticsFunctions/arm_mean_f32.c",
+	"StatisticsFunctions/arm_rms_f32.c",
+	"TransformFunctions/arm_bitreversal2.c",
+	"TransformFunctions/arm_cfft_f32.c",
+	"TransformFunctions/arm_cfft_radix8_f32.c",
+	"TransformFunctions/arm_rfft_fast_f32.c",
+	"TransformFunctions/arm_rfft_fast_init_f32.c",
+}
+
+// cmsisDSPDir returns the directory where the CMSIS-DSP sources are kept.
+func cmsisDSPDir() string {
+	return filepath.Join(sourceDir(), "lib", "CMSIS", "CMSIS", "DSP_Lib", "Source")
+}
+
+// cmsisDSPCore returns the preprocessor define that selects the code paths of
+// CMSIS-DSP for the given target triple.
+func cmsisDSPCore(target string) (string, error) {
+	switch {
+	case strings.HasPrefix(target, "armv6m"):
+		return "ARM_MATH_CM0_FAMILY", nil
+	case strings.HasPrefix(target, "armv7m"):
+		return "ARM_MATH_CM3", nil
+	case strings.HasPrefix(target, "armv7em"):
+		return "ARM_MATH_CM4", nil
+	default:
+		return "", errors.New("the dsp package is not supported on " + target)
+	}
+}
+
+// usesCMSISDSP returns whether the program imports the dsp package, which
+// needs the CMSIS-DSP library to be linked in.
+func usesCMSISDSP(pkgs []*loader.Package) bool {
+	for _, pkg := range pkgs {
+		if pkg.ImportPath == "dsp" {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the CMSIS-DSP archive, possibly compiling it as needed.
+func loadCMSISDSP(target string) (path string, err error) {
+	// Try to load a precompiled library, as shipped in a release.
+	precompiledPath := filepath.Join(sourceDir(), "pkg", target, "cmsis-dsp.a")
+	if _, err := os.Stat(precompiledPath); err == nil {
+		return precompiledPath, nil
+	}
+
+	outfile := "libcmsisdsp-" + target + ".a"
+	srcs := make([]string, len(cmsisDSPSources))
+	for i, name := range cmsisDSPSources {
+		srcs[i] = filepath.Join(cmsisDSPDir(), name)
+	}
+
+	if path, err := cacheLoad(outfile, commands["clang"][0], srcs); path != "" || err != nil {
+		return path, err
+	}
+
+	var cachepath string
+	err = compileCMSISDSP(target, func(path string) error {
+		path, err := cacheStore(path, outfile, commands["clang"][0], srcs)
+		cachepath = path
+		return err
+	})
+	return cachepath, err
+}
+
+// compileCMSISDSP compiles the CMSIS-DSP library into a static library. When
+// it succeeds, it will call the callback with the resulting path, see
+// compileBuiltins.
+func compileCMSISDSP(target string, callback func(path string) error) error {
+	core, err := cmsisDSPCore(target)
+	if err != nil {
+		return err
+	}
+
+	dirPrefix := "tinygo-cmsis-dsp"
+	remapDir := filepath.Join(os.TempDir(), dirPrefix)
+	dir, err := ioutil.TempDir(os.TempDir(), dirPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// There is no libc, so the few libc headers that CMSIS-DSP includes are
+	// provided by the dsp package.
+	includes := []string{
+		"-I" + filepath.Join(sourceDir(), "src", "dsp", "include"),
+		"-I" + filepath.Join(sourceDir(), "lib", "CMSIS", "CMSIS", "Include"),
+	}
+
+	objs := make([]string, 0, len(cmsisDSPSources))
+	for _, name := range cmsisDSPSources {
+		objpath := filepath.Join(dir, filepath.Base(name)+".o")
+		objs = append(objs, objpath)
+		srcpath := filepath.Join(cmsisDSPDir(), name)
+		// Compile with -O2 instead of -Oz: these are the hot loops of a
+		// program. Warnings are disabled as this is vendor code.
+		args := []string{"-c", "-O2", "-g", "-w", "-std=c99", "-mthumb", "-fshort-enums", "-nostdlibinc", "-ffunction-sections", "-fdata-sections", "--target=" + target, "-mfloat-abi=soft", "-D" + core, "-fdebug-prefix-map=" + dir + "=" + remapDir}
+		args = append(args, includes...)
+		args = append(args, "-o", objpath, srcpath)
+		err := execCommand(commands["clang"], args...)
+		if err != nil {
+			return &commandError{"failed to build", srcpath, err}
+		}
+	}
+
+	arpath := filepath.Join(dir, "libcmsisdsp.a")
+	err = makeArchive(arpath, objs)
+	if err != nil {
+		return err
+	}
+	return callback(arpath)
+}
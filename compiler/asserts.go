@@ -4,6 +4,7 @@ package compiler
 // required by the Go programming language.
 
 import (
+	"go/token"
 	"go/types"
 
 	"tinygo.org/x/go-llvm"
@@ -110,6 +111,50 @@ func (c *Compiler) emitSliceBoundsCheck(frame *Frame, capacity, low, high llvm.V
 	c.builder.SetInsertPointAtEnd(nextBlock)
 }
 
+// emitUnsafeSliceCheck emits the checks required by unsafe.Slice and
+// unsafe.String: the length must not be negative, the resulting slice must fit
+// in the address space, and a nil pointer is only allowed with a zero length.
+// callName is either "Slice" or "String".
+func (c *Compiler) emitUnsafeSliceCheck(frame *Frame, callName string, ptr, length llvm.Value, lengthType *types.Basic, elemSize uint64) {
+	if frame.fn.IsNoBounds() {
+		// The //go:nobounds pragma was added to the function to avoid bounds
+		// checking.
+		return
+	}
+
+	// Do all comparisons in a type that can hold both the length and the
+	// maximum length.
+	if length.Type().IntTypeWidth() < c.uintptrType.IntTypeWidth() {
+		length = c.extendToUintptr(length, lengthType)
+	}
+	maxLength := uint64(1)<<uint(c.uintptrType.IntTypeWidth()-1) - 1
+	if elemSize > 1 {
+		maxLength /= elemSize
+	}
+	zero := llvm.ConstInt(length.Type(), 0, false)
+
+	// The length is out of range when it is negative (which is the same as
+	// very large when compared unsigned) or when the slice would not fit in
+	// memory.
+	outOfBounds := c.builder.CreateICmp(llvm.IntUGT, length, llvm.ConstInt(length.Type(), maxLength, false), "unsafe.lenrange")
+	ptrIsNil := c.builder.CreateICmp(llvm.IntEQ, ptr, llvm.ConstPointerNull(ptr.Type()), "unsafe.ptrnil")
+	lenNotZero := c.builder.CreateICmp(llvm.IntNE, length, zero, "unsafe.lennotzero")
+	outOfBounds = c.builder.CreateOr(outOfBounds, c.builder.CreateAnd(ptrIsNil, lenNotZero, ""), "unsafe.outofbounds")
+
+	faultBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unsafe.outofbounds")
+	nextBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unsafe.next")
+	frame.blockExits[frame.currentBlock] = nextBlock // adjust outgoing block for phi nodes
+	c.builder.CreateCondBr(outOfBounds, faultBlock, nextBlock)
+
+	// Fail: the length is invalid, exit with a panic.
+	c.builder.SetInsertPointAtEnd(faultBlock)
+	c.createRuntimeCall("unsafe"+callName+"Panic", nil, "")
+	c.builder.CreateUnreachable()
+
+	// Ok: the slice or string can be created.
+	c.builder.SetInsertPointAtEnd(nextBlock)
+}
+
 // emitNilCheck checks whether the given pointer is nil, and panics if it is. It
 // has no effect in well-behaved programs, but makes sure no uncaught nil
 // pointer dereferences exist in valid Go code.
@@ -146,9 +191,48 @@ func (c *Compiler) emitNilCheck(frame *Frame, ptr llvm.Value, blockPrefix string
 
 	// Fail: this is a nil pointer, exit with a panic.
 	c.builder.SetInsertPointAtEnd(faultBlock)
-	c.createRuntimeCall("nilPanic", nil, "")
+	c.createNilPanic(frame)
 	c.builder.CreateUnreachable()
 
 	// Ok: this is a valid pointer.
 	c.builder.SetInsertPointAtEnd(nextBlock)
 }
+
+// createNilPanic creates the code that runs when a nil check fails, which
+// depends on the -nil-check flag:
+//   * panic: call runtime.nilPanic, which doesn't say where the nil pointer
+//     was dereferenced.
+//   * location: call runtime.nilPanicAt with the function and source location
+//     of the check. This needs a few bytes of flash for every check.
+//   * trap: execute the undefined instruction "udf #1", which takes two bytes.
+//     The HardFault handler recognizes it and prints its address, which the
+//     serial monitor of tinygo flash converts back to a source location.
+func (c *Compiler) createNilPanic(frame *Frame) {
+	switch c.NilCheck {
+	case "location":
+		c.createRuntimeCall("nilPanicAt", []llvm.Value{c.createPanicLocation(frame, c.instrPos)}, "")
+	case "trap":
+		fnType := llvm.FunctionType(c.ctx.VoidType(), nil, false)
+		target := llvm.InlineAsm(fnType, "udf #1", "", true, false, 0)
+		c.builder.CreateCall(target, nil, "")
+	default:
+		c.createRuntimeCall("nilPanic", nil, "")
+	}
+}
+
+// createPanicLocation returns a constant runtime.panicLocation with the
+// function and the given source location, for panics that print where they
+// happened.
+func (c *Compiler) createPanicLocation(frame *Frame, pos token.Pos) llvm.Value {
+	position := c.ir.Program.Fset.Position(pos)
+	locationType := c.getLLVMRuntimeType("panicLocation")
+	location := llvm.AddGlobal(c.mod, locationType, frame.fn.LinkName()+"$loc")
+	location.SetInitializer(llvm.ConstNamedStruct(locationType, []llvm.Value{
+		c.createConstString(frame.fn.LinkName()+"$loc.name", frame.fn.RelString(nil)),
+		c.createConstString(frame.fn.LinkName()+"$loc.file", position.Filename),
+		llvm.ConstInt(c.uintptrType, uint64(position.Line), false),
+	}))
+	location.SetLinkage(llvm.InternalLinkage)
+	location.SetGlobalConstant(true)
+	return location
+}
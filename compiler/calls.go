@@ -24,7 +24,13 @@ func (c *Compiler) createRuntimeCall(fnName string, args []llvm.Value, name stri
 		args = append(args, llvm.Undef(c.i8ptrType))            // unused context parameter
 		args = append(args, llvm.ConstPointerNull(c.i8ptrType)) // coroutine handle
 	}
-	return c.createCall(fn.LLVMFn, args, name)
+	call := c.createCall(fn.LLVMFn, args, name)
+	if fnName == "alloc" && (c.PrintAllocs != nil || c.ISRCheck != "off" || c.Strict) {
+		// Remember where this heap allocation comes from, for -print-allocs,
+		// the interrupt checker and the -strict mode.
+		c.allocPositions[call] = c.instrPos
+	}
+	return call
 }
 
 // Create a call to the given function with the arguments possibly expanded.
@@ -34,7 +40,13 @@ func (c *Compiler) createCall(fn llvm.Value, args []llvm.Value, name string) llv
 		fragments := c.expandFormalParam(arg)
 		expanded = append(expanded, fragments...)
 	}
-	return c.builder.CreateCall(fn, expanded, name)
+	call := c.builder.CreateCall(fn, expanded, name)
+	if c.instrPos.IsValid() {
+		// Remember where this call comes from, to explain why a function is
+		// blocking when goroutine lowering fails.
+		c.callPositions[call] = c.instrPos
+	}
+	return call
 }
 
 // Expand an argument type to a list that can be used in a function call
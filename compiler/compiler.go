@@ -1,6 +1,7 @@
 package compiler
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"go/ast"
@@ -8,9 +9,10 @@ import (
 	"go/constant"
 	"go/token"
 	"go/types"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
+	"regexp"
 	"strings"
 
 	"github.com/tinygo-org/tinygo/ir"
@@ -49,10 +51,33 @@ type Config struct {
 	GOPATH        string   // GOPATH, like `go env GOPATH`
 	BuildTags     []string // build tags for TinyGo (empty means {Config.GOOS/Config.GOARCH})
 	TestConfig    TestConfig
+	HeapRegions   []HeapRegion   // memory for the heap besides the main heap, like external RAM
+	PrintAllocs   *regexp.Regexp // print heap allocations in matching functions (nil means none)
+	PrintAsync    bool           // print which functions are blocking (async) and why
+	ISRCheck      string         // check interrupt handlers for heap allocations and blocking: "warn" (default), "error" or "off"
+	NoRecursion   bool           // report recursion as an error, except through //go:recursive functions
+	PanicTrace    bool           // keep frame pointers so that panics can print a call trace (Cortex-M only)
+	Strict        bool           // enforce the rules of the -strict mode for certifiable builds
+	StrictReport  string         // file to write the -strict compliance report to, if any
+	ScrubMap      string         // remove panic messages and symbol names, and write the map to decode them to this file (-scrub)
+	Profile       bool           // instrument functions for the runtime/pprof package (-pprof)
+	NilCheck      string         // what a failed nil check does: "panic" (default), "location" or "trap" (Cortex-M only)
+	OverflowCheck bool           // panic on signed integer overflow outside the standard library (-check=overflow)
+	Deadline      string         // what happens when a function exceeds its //go:deadline: "panic", "log" or "off"
+}
+
+// HeapRegion is an area of memory that is added to the heap, for example
+// external RAM.
+type HeapRegion struct {
+	Start     uint64
+	Size      uint64
+	MinObject uint64 // objects of at least this size prefer this region
 }
 
 type TestConfig struct {
 	CompileTestBinary bool
+	Format            string // result format of the test binary: text, tap or json
+	Bench             string // regular expression of the benchmarks to run
 	// TODO: Filter the test functions to run, include verbose flag, etc
 }
 
@@ -72,9 +97,18 @@ type Compiler struct {
 	uintptrType             llvm.Type
 	initFuncs               []llvm.Value
 	interfaceInvokeWrappers []interfaceInvokeWrapper
+	reflectCallThunks       []reflectCallThunk
 	ir                      *ir.Program
 	diagnostics             []error
 	astComments             map[string]*ast.CommentGroup
+	writeBarrierPkg         *ssa.Package             // package with the write barrier of a custom GC, if any
+	instrPos                token.Pos                // position of the instruction being compiled
+	allocPositions          map[llvm.Value]token.Pos // source positions of runtime.alloc calls, for -print-allocs
+	callPositions           map[llvm.Value]token.Pos // source positions of calls, for goroutine lowering errors
+	interruptHandlers       []interruptHandler
+	hotPathInvokes          []hotPathInvoke // interface calls in //go:hotpath functions, for -strict
+	scrubbedPanics          []scrubbedPanic // panics with a message replaced by a code, for -scrub
+	constStrings            map[string]llvm.Value
 }
 
 type Frame struct {
@@ -92,6 +126,7 @@ type Frame struct {
 	deferInvokeFuncs  map[string]int
 	deferClosureFuncs map[*ir.Function]int
 	selectRecvBuf     map[*ssa.Select]llvm.Value
+	deadlineStart     llvm.Value
 }
 
 type Phi struct {
@@ -107,8 +142,10 @@ func NewCompiler(pkgName string, config Config) (*Compiler, error) {
 		config.BuildTags = []string{config.GOOS, config.GOARCH}
 	}
 	c := &Compiler{
-		Config:  config,
-		difiles: make(map[string]llvm.Metadata),
+		Config:         config,
+		difiles:        make(map[string]llvm.Metadata),
+		allocPositions: make(map[llvm.Value]token.Pos),
+		callPositions:  make(map[llvm.Value]token.Pos),
 	}
 
 	target, err := llvm.GetTargetFromTriple(config.Triple)
@@ -155,6 +192,23 @@ func (c *Compiler) Packages() []*loader.Package {
 	return c.ir.LoaderProgram.Sorted()
 }
 
+// usesRuntimeCAPI returns whether the C files of any of the given packages
+// refer to the C API of the runtime (see src/runtime/capi.go).
+func usesRuntimeCAPI(packages []*loader.Package) (bool, error) {
+	for _, pkg := range packages {
+		for _, file := range pkg.CFiles {
+			data, err := ioutil.ReadFile(filepath.Join(pkg.Package.Dir, file))
+			if err != nil {
+				return false, err
+			}
+			if bytes.Contains(data, []byte("tinygo_chan_")) || bytes.Contains(data, []byte("tinygo_task_")) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 // Return the LLVM module. Only valid after a successful compile.
 func (c *Compiler) Module() llvm.Module {
 	return c.mod
@@ -173,6 +227,18 @@ func (c *Compiler) selectGC() string {
 	return "conservative"
 }
 
+// isBaremetal returns whether the target runs without an operating system.
+// Some standard library packages are only replaced on such targets, as the Go
+// implementation works fine elsewhere.
+func (c *Compiler) isBaremetal() bool {
+	for _, tag := range c.BuildTags {
+		if tag == "avr" || tag == "cortexm" || tag == "tinygo.riscv" {
+			return true
+		}
+	}
+	return false
+}
+
 // Compile the given package path or .go file path. Return an error when this
 // fails (in any stage).
 func (c *Compiler) Compile(mainPath string) []error {
@@ -189,6 +255,10 @@ func (c *Compiler) Compile(mainPath string) []error {
 	if err != nil {
 		return []error{err}
 	}
+	runtimeConfig := c.runtimeConfig()
+	if len(c.HeapRegions) != 0 && runtimeConfig.GC != "conservative" {
+		return []error{errors.New("heap regions are only supported by the conservative GC, not -gc=" + runtimeConfig.GC)}
+	}
 	lprogram := &loader.Program{
 		Build: &build.Context{
 			GOARCH:      c.GOARCH,
@@ -198,7 +268,7 @@ func (c *Compiler) Compile(mainPath string) []error {
 			CgoEnabled:  true,
 			UseAllFiles: false,
 			Compiler:    "gc", // must be one of the recognized compilers
-			BuildTags:   append([]string{"tinygo", "gc." + c.selectGC()}, c.BuildTags...),
+			BuildTags:   append(runtimeConfig.buildTags(), c.BuildTags...),
 		},
 		OverlayBuild: &build.Context{
 			GOARCH:      c.GOARCH,
@@ -208,7 +278,10 @@ func (c *Compiler) Compile(mainPath string) []error {
 			CgoEnabled:  true,
 			UseAllFiles: false,
 			Compiler:    "gc", // must be one of the recognized compilers
-			BuildTags:   append([]string{"tinygo", "gc." + c.selectGC()}, c.BuildTags...),
+			BuildTags:   append(runtimeConfig.buildTags(), c.BuildTags...),
+		},
+		Generated: map[string][]byte{
+			runtimeConfigPath: runtimeConfig.source(),
 		},
 		OverlayPath: func(path string) string {
 			// Return the (overlay) import path when it should be overlaid, and
@@ -219,14 +292,16 @@ func (c *Compiler) Compile(mainPath string) []error {
 				path = path[len(tinygoPath+"/src/"):]
 			}
 			switch path {
-			case "machine", "os", "reflect", "runtime", "runtime/volatile", "sync", "testing":
+			case "dsp", "fixedpoint", "machine", "machine/bluetooth", "machine/eeprom", "machine/littlefs", "netstack", "os", "reflect", "runtime", "runtime/cabi", "runtime/debug", "runtime/delta", "runtime/fakeclock", "runtime/interrupt", "runtime/metrics", "runtime/pprof", "runtime/shell", "runtime/volatile", "sync", "testing":
 				return path
 			default:
 				if strings.HasPrefix(path, "device/") || strings.HasPrefix(path, "examples/") {
 					return path
+				} else if (path == "crypto/aes" || path == "crypto/rand" || path == "crypto/tls" || path == "net") && c.isBaremetal() {
+					return path
 				} else if path == "syscall" {
 					for _, tag := range c.BuildTags {
-						if tag == "avr" || tag == "cortexm" || tag == "darwin" || tag == "riscv" {
+						if tag == "avr" || tag == "cortexm" || tag == "darwin" || tag == "riscv" || tag == "wasi" {
 							return path
 						}
 					}
@@ -245,6 +320,8 @@ func (c *Compiler) Compile(mainPath string) []error {
 		TINYGOROOT:   c.TINYGOROOT,
 		CFlags:       c.CFlags,
 		ClangHeaders: c.ClangHeaders,
+		TestFormat:   c.TestConfig.Format,
+		TestBench:    c.TestConfig.Bench,
 	}
 
 	if strings.HasSuffix(mainPath, ".go") {
@@ -274,6 +351,8 @@ func (c *Compiler) Compile(mainPath string) []error {
 	// Run a simple dead code elimination pass.
 	c.ir.SimpleDCE()
 
+	c.findWriteBarrier()
+
 	// Initialize debug information.
 	if c.Debug {
 		c.cu = c.dibuilder.CreateCompileUnit(llvm.DICompileUnit{
@@ -307,6 +386,7 @@ func (c *Compiler) Compile(mainPath string) []error {
 		}
 		c.parseFunc(frame)
 	}
+	c.instrPos = token.NoPos
 
 	// Define the already declared functions that wrap methods for use in
 	// interfaces.
@@ -314,6 +394,11 @@ func (c *Compiler) Compile(mainPath string) []error {
 		c.createInterfaceInvokeWrapper(state)
 	}
 
+	// Define the thunks that call methods from the reflect package.
+	for _, state := range c.reflectCallThunks {
+		c.createReflectCallThunk(state)
+	}
+
 	// After all packages are imported, add a synthetic initializer function
 	// that calls the initializer of each package.
 	initFn := c.ir.GetFunction(c.ir.Program.ImportedPackage("runtime").Members["initAll"].(*ssa.Function))
@@ -331,17 +416,34 @@ func (c *Compiler) Compile(mainPath string) []error {
 	}
 	c.builder.CreateRetVoid()
 
+	// Let the scheduler know whether C code can make goroutines runnable from
+	// an interrupt, in which case it must wait for interrupts instead of
+	// reporting a deadlock.
+	if capiUsed := c.mod.NamedGlobal("runtime.capiUsed"); !capiUsed.IsNil() {
+		used, err := usesRuntimeCAPI(lprogram.Sorted())
+		if err != nil {
+			return []error{err}
+		}
+		if used {
+			capiUsed.SetInitializer(llvm.ConstInt(c.ctx.Int1Type(), 1, false))
+		}
+	}
+
 	// Conserve for goroutine lowering. Without marking these as external, they
 	// would be optimized away.
 	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
 	realMain.SetLinkage(llvm.ExternalLinkage) // keep alive until goroutine lowering
 	c.mod.NamedFunction("runtime.alloc").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.free").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.allocTask").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.freeTask").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.sleepTask").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.setTaskPromisePtr").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.getTaskPromisePtr").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.activateTask").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.scheduler").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.selectNoCases").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.traceTaskCreated").SetLinkage(llvm.ExternalLinkage)
 
 	// Load some attributes
 	getAttr := func(attrName string) llvm.Attribute {
@@ -788,6 +890,12 @@ func (c *Compiler) parseFuncDecl(f *ir.Function) *Frame {
 		frame.fn.LLVMFn = llvm.AddFunction(c.mod, name, fnType)
 	}
 
+	// Functions imported from another WebAssembly module, like the WASI
+	// system calls.
+	if module := f.WasmModule(); module != "" {
+		frame.fn.LLVMFn.AddFunctionAttr(c.ctx.CreateStringAttribute("wasm-import-module", module))
+	}
+
 	// External/exported functions may not retain pointer values.
 	// https://golang.org/cmd/cgo/#hdr-Passing_pointers
 	if f.IsExported() {
@@ -843,6 +951,13 @@ func (c *Compiler) attachDebugInfoRaw(f *ir.Function, llvmFn llvm.Value, suffix,
 	return difunc
 }
 
+// isInterruptHandler returns whether the given function is called directly
+// from the interrupt vector: either it is marked //go:interrupt or it is an
+// exported Cortex-M interrupt handler.
+func (c *Compiler) isInterruptHandler(fn *ir.Function) bool {
+	return fn.IsInterrupt() || (fn.IsExported() && strings.HasSuffix(fn.LinkName(), "_IRQHandler"))
+}
+
 func (c *Compiler) parseFunc(frame *Frame) {
 	if c.DumpSSA {
 		fmt.Printf("\nfunc %s:\n", frame.fn.Function)
@@ -960,6 +1075,22 @@ func (c *Compiler) parseFunc(frame *Frame) {
 		}
 	}
 
+	if c.isInterruptHandler(frame.fn) {
+		c.interruptHandlers = append(c.interruptHandlers, interruptHandler{frame.fn.LLVMFn, frame.fn.Pos()})
+
+		// Let trace recorders know that an interrupt started.
+		handler := c.builder.CreatePtrToInt(frame.fn.LLVMFn, c.uintptrType, "")
+		c.createRuntimeCall("traceISREnter", []llvm.Value{handler}, "")
+	}
+
+	if c.isProfiled(frame.fn) {
+		c.createProfileEnter(frame.fn)
+	}
+
+	if c.hasDeadline(frame.fn) {
+		c.createDeadlineStart(frame)
+	}
+
 	if frame.fn.Recover != nil {
 		// This function has deferred function calls. Set some things up for
 		// them.
@@ -1003,6 +1134,7 @@ func (c *Compiler) parseFunc(frame *Frame) {
 }
 
 func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) {
+	c.instrPos = instr.Pos()
 	if c.Debug {
 		pos := c.ir.Program.Fset.Position(instr.Pos())
 		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), frame.difunc, llvm.Metadata{})
@@ -1076,10 +1208,21 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) {
 		mapType := instr.Map.Type().Underlying().(*types.Map)
 		c.emitMapUpdate(mapType.Key(), m, key, value, instr.Pos())
 	case *ssa.Panic:
-		value := c.getValue(frame, instr.X)
-		c.createRuntimeCall("_panic", []llvm.Value{value}, "")
+		if c.createPanicCode(instr.X, "", instr.Pos()).IsNil() {
+			value := c.getValue(frame, instr.X)
+			c.createRuntimeCall("_panic", []llvm.Value{value}, "")
+		}
 		c.builder.CreateUnreachable()
 	case *ssa.Return:
+		if c.isInterruptHandler(frame.fn) {
+			c.createRuntimeCall("traceISRExit", nil, "")
+		}
+		if c.isProfiled(frame.fn) {
+			c.createRuntimeCall("profileExit", nil, "")
+		}
+		if c.hasDeadline(frame.fn) {
+			c.createDeadlineCheck(frame)
+		}
 		if len(instr.Results) == 0 {
 			c.builder.CreateRetVoid()
 		} else if len(instr.Results) == 1 {
@@ -1105,6 +1248,7 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) {
 			// nothing to store
 			return
 		}
+		c.emitWriteBarrier(frame, llvmAddr, llvmVal)
 		c.builder.CreateStore(llvmVal, llvmAddr)
 	default:
 		c.addError(instr.Pos(), "unknown instruction: "+instr.String())
@@ -1113,6 +1257,35 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) {
 
 func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string, pos token.Pos) (llvm.Value, error) {
 	switch callName {
+	case "Add": // unsafe.Add
+		// The unsafe.Add, unsafe.Slice and unsafe.String builtins are only
+		// accepted when TinyGo is built with a Go version whose type checker
+		// knows about them (Go 1.17, or Go 1.20 for unsafe.String), and when
+		// the go.mod file of the module allows them (see the loader).
+		ptr := c.getValue(frame, args[0])
+		offset := c.extendToUintptr(c.getValue(frame, args[1]), args[1].Type().Underlying().(*types.Basic))
+		return c.builder.CreateGEP(ptr, []llvm.Value{offset}, ""), nil
+	case "Slice", "String": // unsafe.Slice, unsafe.String
+		// Build a slice or string header directly from the pointer, without
+		// allocating or copying anything.
+		ptr := c.getValue(frame, args[0])
+		length := c.getValue(frame, args[1])
+		lengthType := args[1].Type().Underlying().(*types.Basic)
+		elemType := args[0].Type().Underlying().(*types.Pointer).Elem()
+		c.emitUnsafeSliceCheck(frame, callName, ptr, length, lengthType, c.targetData.TypeAllocSize(c.getLLVMType(elemType)))
+		length = c.extendToUintptr(length, lengthType)
+		var result llvm.Value
+		if callName == "String" {
+			result = llvm.Undef(c.getLLVMType(types.Typ[types.String]))
+			result = c.builder.CreateInsertValue(result, ptr, 0, "")
+			result = c.builder.CreateInsertValue(result, length, 1, "")
+		} else {
+			result = llvm.Undef(c.getLLVMType(types.NewSlice(elemType)))
+			result = c.builder.CreateInsertValue(result, ptr, 0, "")
+			result = c.builder.CreateInsertValue(result, length, 1, "")
+			result = c.builder.CreateInsertValue(result, length, 2, "")
+		}
+		return result, nil
 	case "append":
 		src := c.getValue(frame, args[0])
 		elems := c.getValue(frame, args[1])
@@ -1216,51 +1389,8 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 				c.createRuntimeCall("printspace", nil, "")
 			}
 			value := c.getValue(frame, arg)
-			typ := arg.Type().Underlying()
-			switch typ := typ.(type) {
-			case *types.Basic:
-				switch typ.Kind() {
-				case types.String, types.UntypedString:
-					c.createRuntimeCall("printstring", []llvm.Value{value}, "")
-				case types.Uintptr:
-					c.createRuntimeCall("printptr", []llvm.Value{value}, "")
-				case types.UnsafePointer:
-					ptrValue := c.builder.CreatePtrToInt(value, c.uintptrType, "")
-					c.createRuntimeCall("printptr", []llvm.Value{ptrValue}, "")
-				default:
-					// runtime.print{int,uint}{8,16,32,64}
-					if typ.Info()&types.IsInteger != 0 {
-						name := "print"
-						if typ.Info()&types.IsUnsigned != 0 {
-							name += "uint"
-						} else {
-							name += "int"
-						}
-						name += strconv.FormatUint(c.targetData.TypeAllocSize(value.Type())*8, 10)
-						c.createRuntimeCall(name, []llvm.Value{value}, "")
-					} else if typ.Kind() == types.Bool {
-						c.createRuntimeCall("printbool", []llvm.Value{value}, "")
-					} else if typ.Kind() == types.Float32 {
-						c.createRuntimeCall("printfloat32", []llvm.Value{value}, "")
-					} else if typ.Kind() == types.Float64 {
-						c.createRuntimeCall("printfloat64", []llvm.Value{value}, "")
-					} else if typ.Kind() == types.Complex64 {
-						c.createRuntimeCall("printcomplex64", []llvm.Value{value}, "")
-					} else if typ.Kind() == types.Complex128 {
-						c.createRuntimeCall("printcomplex128", []llvm.Value{value}, "")
-					} else {
-						return llvm.Value{}, c.makeError(pos, "unknown basic arg type: "+typ.String())
-					}
-				}
-			case *types.Interface:
-				c.createRuntimeCall("printitf", []llvm.Value{value}, "")
-			case *types.Map:
-				c.createRuntimeCall("printmap", []llvm.Value{value}, "")
-			case *types.Pointer:
-				ptrValue := c.builder.CreatePtrToInt(value, c.uintptrType, "")
-				c.createRuntimeCall("printptr", []llvm.Value{ptrValue}, "")
-			default:
-				return llvm.Value{}, c.makeError(pos, "unknown arg type: "+typ.String())
+			if err := c.createPrint(frame, value, arg.Type(), 0, pos); err != nil {
+				return llvm.Value{}, err
 			}
 		}
 		if callName == "println" {
@@ -1280,6 +1410,24 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 	}
 }
 
+// extendToUintptr converts an integer of the given type to a uintptr, with
+// sign extension for signed integers. Integers that are wider than a uintptr
+// are truncated.
+func (c *Compiler) extendToUintptr(value llvm.Value, typ *types.Basic) llvm.Value {
+	width := value.Type().IntTypeWidth()
+	uintptrWidth := c.uintptrType.IntTypeWidth()
+	switch {
+	case width > uintptrWidth:
+		return c.builder.CreateTrunc(value, c.uintptrType, "")
+	case width < uintptrWidth && typ.Info()&types.IsUnsigned != 0:
+		return c.builder.CreateZExt(value, c.uintptrType, "")
+	case width < uintptrWidth:
+		return c.builder.CreateSExt(value, c.uintptrType, "")
+	default:
+		return value
+	}
+}
+
 func (c *Compiler) parseFunctionCall(frame *Frame, args []ssa.Value, llvmFn, context llvm.Value, exported bool) llvm.Value {
 	var params []llvm.Value
 	for _, param := range args {
@@ -1300,6 +1448,9 @@ func (c *Compiler) parseFunctionCall(frame *Frame, args []ssa.Value, llvmFn, con
 
 func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, error) {
 	if instr.IsInvoke() {
+		if c.Strict && frame.fn.IsHotPath() {
+			c.hotPathInvokes = append(c.hotPathInvokes, hotPathInvoke{frame.fn.RelString(nil), instr.Pos()})
+		}
 		fnCast, args := c.getInvokeCall(frame, instr)
 		return c.createCall(fnCast, args, ""), nil
 	}
@@ -1307,6 +1458,11 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, e
 	// Try to call the function directly for trivially static calls.
 	if fn := instr.StaticCallee(); fn != nil {
 		name := fn.RelString(nil)
+		if name == "runtime.runtimePanic" {
+			if call := c.createPanicCode(instr.Args[0], "runtime error: ", instr.Pos()); !call.IsNil() {
+				return call, nil
+			}
+		}
 		switch {
 		case name == "device/arm.ReadRegister" || name == "device/riscv.ReadRegister":
 			return c.emitReadRegister(name, instr.Args)
@@ -1322,6 +1478,8 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, e
 			return c.emitVolatileLoad(frame, instr)
 		case strings.HasPrefix(name, "runtime/volatile.Store"):
 			return c.emitVolatileStore(frame, instr)
+		case c.isDSPIntrinsic(name):
+			return c.emitDSPIntrinsic(frame, name, instr.Args)
 		}
 
 		targetFunc := c.ir.GetFunction(fn)
@@ -1421,6 +1579,9 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 	case *ssa.BinOp:
 		x := c.getValue(frame, expr.X)
 		y := c.getValue(frame, expr.Y)
+		if result, ok := c.createCheckedBinOp(frame, expr.Op, expr.X.Type(), x, y); ok {
+			return result, nil // -check=overflow
+		}
 		return c.parseBinOp(expr.Op, expr.X.Type(), x, y, expr.Pos())
 	case *ssa.Call:
 		// Passing the current task here to the subroutine. It is only used when
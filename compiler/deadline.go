@@ -0,0 +1,50 @@
+package compiler
+
+// This file implements the //go:deadline pragma, which sets the maximum
+// execution time of a function:
+//
+//     //go:deadline 50us
+//     func controlLoop() {
+//
+// Unless disabled with -deadline=off, such a function reads the cycle counter
+// when it starts and checks the elapsed time before it returns. The check
+// calls runtime.deadlineCheck, which panics (-deadline=panic) or prints a
+// message and continues (-deadline=log) when the deadline was exceeded. The
+// cycle counter is only available on chips with a DWT unit (Cortex-M3 and
+// higher), on other chips the check never fails.
+
+import (
+	"time"
+
+	"github.com/tinygo-org/tinygo/ir"
+	"tinygo.org/x/go-llvm"
+)
+
+// hasDeadline returns whether the execution time of the given function must
+// be checked.
+func (c *Compiler) hasDeadline(f *ir.Function) bool {
+	return f.Deadline() != 0 && c.Deadline != "off"
+}
+
+// createDeadlineStart reads the cycle counter at the start of a function with
+// a deadline.
+func (c *Compiler) createDeadlineStart(frame *Frame) {
+	if frame.fn.Deadline() >= 1<<32*time.Nanosecond {
+		c.addError(frame.fn.Pos(), "//go:deadline must be less than 4s")
+	}
+	frame.deadlineStart = c.createRuntimeCall("deadlineStart", nil, "deadline.start")
+}
+
+// createDeadlineCheck checks the execution time of a function before it
+// returns.
+func (c *Compiler) createDeadlineCheck(frame *Frame) {
+	fn := "deadlineCheck"
+	if c.Deadline == "log" {
+		fn = "deadlineCheckLog"
+	}
+	c.createRuntimeCall(fn, []llvm.Value{
+		frame.deadlineStart,
+		llvm.ConstInt(c.ctx.Int32Type(), uint64(frame.fn.Deadline()), false),
+		c.createPanicLocation(frame, frame.fn.Pos()),
+	}, "")
+}
@@ -6,12 +6,17 @@ package compiler
 //   * Creating an alloca in the entry block that contains a pointer (initially
 //     null) to the linked list of defer frames.
 //   * Every time a defer statement is executed, a new defer frame is created
-//     using alloca with a pointer to the previous defer frame, and the head
-//     pointer in the entry block is replaced with a pointer to this defer
-//     frame.
+//     with a pointer to the previous defer frame, and the head pointer in the
+//     entry block is replaced with a pointer to this defer frame. The defer
+//     frame is an alloca in the entry block, unless the defer statement is in
+//     a loop: it may then run any number of times so every defer frame is
+//     allocated on the heap.
 //   * On return, runtime.rundefers is called which calls all deferred functions
 //     from the head of the linked list until it has gone through all defer
 //     frames.
+// The deferred calls are emitted inline before the return instruction, so when
+// a deferred function is blocking, the goroutine lowering pass awaits the call
+// like any other blocking call before the final suspend of the coroutine.
 
 import (
 	"github.com/tinygo-org/tinygo/ir"
@@ -115,9 +120,28 @@ func (c *Compiler) emitDefer(frame *Frame, instr *ssa.Defer) {
 		values = append(values, context)
 		valueTypes = append(valueTypes, context.Type())
 
-	} else {
-		c.addError(instr.Pos(), "todo: defer on uncommon function call type")
+	} else if _, ok := instr.Call.Value.(*ssa.Builtin); ok {
+		c.addError(instr.Pos(), "todo: defer of builtin function")
 		return
+
+	} else {
+		// Call on a func value, for example a function parameter. The func
+		// value is only known at runtime, so every such defer statement gets
+		// its own callback number.
+		frame.allDeferFuncs = append(frame.allDeferFuncs, &instr.Call)
+		callback := llvm.ConstInt(c.uintptrType, uint64(len(frame.allDeferFuncs)-1), false)
+
+		// Collect all values to be put in the struct (starting with
+		// runtime._defer fields, followed by the func value and the call
+		// parameters).
+		funcValue := c.getValue(frame, instr.Call.Value)
+		values = []llvm.Value{callback, next, funcValue}
+		valueTypes = append(valueTypes, funcValue.Type())
+		for _, param := range instr.Call.Args {
+			llvmParam := c.getValue(frame, param)
+			values = append(values, llvmParam)
+			valueTypes = append(valueTypes, llvmParam.Type())
+		}
 	}
 
 	// Make a struct out of the collected values to put in the defer frame.
@@ -127,8 +151,17 @@ func (c *Compiler) emitDefer(frame *Frame, instr *ssa.Defer) {
 		deferFrame = c.builder.CreateInsertValue(deferFrame, value, i, "")
 	}
 
-	// Put this struct in an alloca.
-	alloca := c.builder.CreateAlloca(deferFrameType, "defer.alloca")
+	// Put this struct in an alloca, or on the heap if this defer statement may
+	// be executed more than once.
+	var alloca llvm.Value
+	if isInLoop(instr.Block()) {
+		size := c.targetData.TypeAllocSize(deferFrameType)
+		sizeValue := llvm.ConstInt(c.uintptrType, size, false)
+		alloca = c.createRuntimeCall("alloc", []llvm.Value{sizeValue}, "defer.alloc")
+		alloca = c.builder.CreateBitCast(alloca, llvm.PointerType(deferFrameType, 0), "")
+	} else {
+		alloca = c.createEntryBlockAlloca(deferFrameType, "defer.alloca")
+	}
 	c.builder.CreateStore(deferFrame, alloca)
 	if c.needsStackObjects() {
 		c.trackPointer(alloca)
@@ -197,11 +230,13 @@ func (c *Compiler) emitRunDefers(frame *Frame) {
 		c.builder.SetInsertPointAtEnd(block)
 		switch callback := callback.(type) {
 		case *ssa.CallCommon:
-			// Call on an interface value.
 			if !callback.IsInvoke() {
-				panic("expected an invoke call, not a direct call")
+				c.emitRunDeferFuncValue(deferData, callback)
+				break
 			}
 
+			// Call on an interface value.
+
 			// Get the real defer struct type and cast to it.
 			valueTypes := []llvm.Type{c.uintptrType, llvm.PointerType(c.getLLVMRuntimeType("_defer"), 0), c.i8ptrType}
 			for _, arg := range callback.Args {
@@ -305,3 +340,54 @@ func (c *Compiler) emitRunDefers(frame *Frame) {
 	// End of loop.
 	c.builder.SetInsertPointAtEnd(end)
 }
+
+// emitRunDeferFuncValue emits a deferred call on a func value, which is stored
+// in the defer frame before the call parameters.
+func (c *Compiler) emitRunDeferFuncValue(deferData llvm.Value, callback *ssa.CallCommon) {
+	// Get the real defer struct type and cast to it.
+	sig := callback.Signature()
+	valueTypes := []llvm.Type{c.uintptrType, llvm.PointerType(c.getLLVMRuntimeType("_defer"), 0), c.getFuncType(sig)}
+	for _, arg := range callback.Args {
+		valueTypes = append(valueTypes, c.getLLVMType(arg.Type()))
+	}
+	deferFrameType := c.ctx.StructType(valueTypes, false)
+	deferFramePtr := c.builder.CreateBitCast(deferData, llvm.PointerType(deferFrameType, 0), "deferFrame")
+
+	// Extract the func value and the params from the struct.
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	gep := c.builder.CreateInBoundsGEP(deferFramePtr, []llvm.Value{zero, llvm.ConstInt(c.ctx.Int32Type(), 2, false)}, "gep")
+	funcValue := c.builder.CreateLoad(gep, "funcValue")
+	forwardParams := []llvm.Value{}
+	for i := 3; i < len(valueTypes); i++ {
+		gep := c.builder.CreateInBoundsGEP(deferFramePtr, []llvm.Value{zero, llvm.ConstInt(c.ctx.Int32Type(), uint64(i), false)}, "gep")
+		forwardParam := c.builder.CreateLoad(gep, "param")
+		forwardParams = append(forwardParams, forwardParam)
+	}
+
+	// Add the context parameter and the parent coroutine handle.
+	funcPtr, context := c.decodeFuncValue(funcValue, sig)
+	forwardParams = append(forwardParams, context, llvm.Undef(c.i8ptrType))
+
+	// Call deferred function.
+	c.createCall(funcPtr, forwardParams, "")
+}
+
+// isInLoop returns whether the given basic block can be reached from itself,
+// which means that a defer statement in it may be executed more than once.
+func isInLoop(start *ssa.BasicBlock) bool {
+	visited := map[*ssa.BasicBlock]bool{}
+	worklist := append([]*ssa.BasicBlock{}, start.Succs...)
+	for len(worklist) != 0 {
+		block := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		if block == start {
+			return true
+		}
+		if visited[block] {
+			continue
+		}
+		visited[block] = true
+		worklist = append(worklist, block.Succs...)
+	}
+	return false
+}
@@ -16,3 +16,13 @@ func (c *Compiler) makeError(pos token.Pos, msg string) types.Error {
 func (c *Compiler) addError(pos token.Pos, msg string) {
 	c.diagnostics = append(c.diagnostics, c.makeError(pos, msg))
 }
+
+// MultiError is a list of errors (diagnostics) found by a single compiler
+// pass, for example by the interrupt safety checker.
+type MultiError struct {
+	Errs []error
+}
+
+func (e *MultiError) Error() string {
+	return e.Errs[0].Error()
+}
@@ -0,0 +1,61 @@
+package compiler
+
+// This file lowers the saturating arithmetic helpers of the fixedpoint package
+// to single DSP instructions (QADD, QSUB and SSAT) on targets that have the
+// DSP extension: the Cortex-M4 and Cortex-M7 (ARMv7E-M). On other targets the
+// helpers are compiled as regular Go functions.
+
+import (
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+	"tinygo.org/x/go-llvm"
+)
+
+// hasDSPExtension returns whether the target supports the ARM DSP
+// instructions.
+func (c *Compiler) hasDSPExtension() bool {
+	return strings.HasPrefix(c.Triple, "armv7em") || strings.HasPrefix(c.Triple, "thumbv7em")
+}
+
+// isDSPIntrinsic returns whether the given function is a fixedpoint helper
+// that should be lowered to a DSP instruction.
+func (c *Compiler) isDSPIntrinsic(name string) bool {
+	switch name {
+	case "fixedpoint.addSat32", "fixedpoint.subSat32", "fixedpoint.sat16":
+		return c.hasDSPExtension()
+	default:
+		return false
+	}
+}
+
+// emitDSPIntrinsic emits the DSP instruction for one of the fixedpoint helpers
+// accepted by isDSPIntrinsic.
+func (c *Compiler) emitDSPIntrinsic(frame *Frame, name string, args []ssa.Value) (llvm.Value, error) {
+	i32Type := c.ctx.Int32Type()
+	switch name {
+	case "fixedpoint.addSat32":
+		fn := c.getDSPIntrinsic("llvm.arm.qadd", i32Type, i32Type)
+		return c.builder.CreateCall(fn, []llvm.Value{c.getValue(frame, args[0]), c.getValue(frame, args[1])}, ""), nil
+	case "fixedpoint.subSat32":
+		fn := c.getDSPIntrinsic("llvm.arm.qsub", i32Type, i32Type)
+		return c.builder.CreateCall(fn, []llvm.Value{c.getValue(frame, args[0]), c.getValue(frame, args[1])}, ""), nil
+	case "fixedpoint.sat16":
+		// SSAT takes the bit position as an immediate.
+		fn := c.getDSPIntrinsic("llvm.arm.ssat", i32Type, i32Type)
+		return c.builder.CreateCall(fn, []llvm.Value{c.getValue(frame, args[0]), llvm.ConstInt(i32Type, 16, false)}, ""), nil
+	default:
+		panic("unknown DSP intrinsic: " + name)
+	}
+}
+
+// getDSPIntrinsic returns the given ARM intrinsic, which returns an i32, and
+// creates it first if it doesn't exist yet.
+func (c *Compiler) getDSPIntrinsic(name string, paramTypes ...llvm.Type) llvm.Value {
+	fn := c.mod.NamedFunction(name)
+	if fn.IsNil() {
+		fnType := llvm.FunctionType(c.ctx.Int32Type(), paramTypes, false)
+		fn = llvm.AddFunction(c.mod, name, fnType)
+	}
+	return fn
+}
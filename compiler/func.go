@@ -5,6 +5,7 @@ package compiler
 
 import (
 	"go/types"
+	"strings"
 
 	"golang.org/x/tools/go/ssa"
 	"tinygo.org/x/go-llvm"
@@ -32,7 +33,7 @@ const (
 // funcImplementation picks an appropriate func value implementation for the
 // target.
 func (c *Compiler) funcImplementation() funcValueImplementation {
-	if c.GOARCH == "wasm" {
+	if strings.HasPrefix(c.Triple, "wasm") {
 		return funcValueSwitch
 	} else {
 		return funcValueDoubleword
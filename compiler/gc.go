@@ -14,16 +14,38 @@ import (
 // needsStackObjects returns true if the compiler should insert stack objects
 // that can be traced by the garbage collector.
 func (c *Compiler) needsStackObjects() bool {
-	if c.selectGC() != "conservative" {
-		return false
+	return c.runtimeConfig().StackObjects
+}
+
+// findWriteBarrier looks for a write barrier implemented by a custom garbage
+// collector. Write barriers are only inserted when the collector provides one,
+// as they are relatively expensive.
+func (c *Compiler) findWriteBarrier() {
+	if c.selectGC() != "custom" {
+		return
 	}
-	for _, tag := range c.BuildTags {
-		if tag == "cortexm" || tag == "tinygo.riscv" {
-			return false
+	for _, f := range c.ir.Functions {
+		if f.LinkName() == "runtime.gcWriteBarrier" && f.Blocks != nil && f.Pkg.Pkg.Path() != "runtime" {
+			c.writeBarrierPkg = f.Pkg
+			return
 		}
 	}
+}
 
-	return true
+// emitWriteBarrier inserts a call to the write barrier of a custom garbage
+// collector just before a pointer is stored at the given address. No write
+// barriers are inserted in the runtime and in the collector itself, to avoid
+// infinite recursion.
+func (c *Compiler) emitWriteBarrier(frame *Frame, addr, value llvm.Value) {
+	if c.writeBarrierPkg == nil || value.Type().TypeKind() != llvm.PointerTypeKind {
+		return
+	}
+	if frame.fn.Pkg == nil || frame.fn.Pkg == c.writeBarrierPkg || frame.fn.Pkg.Pkg.Path() == "runtime" {
+		return
+	}
+	slot := c.builder.CreateBitCast(addr, c.i8ptrType, "")
+	value = c.builder.CreateBitCast(value, c.i8ptrType, "")
+	c.createRuntimeCall("gcWriteBarrier", []llvm.Value{slot, value}, "")
 }
 
 // trackExpr inserts pointer tracking intrinsics for the GC if the expression is
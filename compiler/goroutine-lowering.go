@@ -94,6 +94,9 @@ package compiler
 
 import (
 	"errors"
+	"fmt"
+	"go/token"
+	"sort"
 	"strings"
 
 	"tinygo.org/x/go-llvm"
@@ -110,6 +113,13 @@ type asyncFunc struct {
 // into one where all blocking functions are turned into goroutines and blocking
 // calls into await calls.
 func (c *Compiler) LowerGoroutines() error {
+	// main.main was set to external linkage during IR construction. Set it to
+	// internal linkage to enable interprocedural optimizations. This must be
+	// done before marking async functions, as exported functions don't
+	// reactivate their parent when they return.
+	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
+	realMain.SetLinkage(llvm.InternalLinkage)
+
 	needsScheduler, err := c.markAsyncFunctions()
 	if err != nil {
 		return err
@@ -124,36 +134,170 @@ func (c *Compiler) LowerGoroutines() error {
 	// Replace call of runtime.callMain() with a real call to main.main(),
 	// optionally followed by a call to runtime.scheduler().
 	c.builder.SetInsertPointBefore(mainCall)
-	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
-	c.builder.CreateCall(realMain, []llvm.Value{llvm.Undef(c.i8ptrType), llvm.ConstPointerNull(c.i8ptrType)}, "")
 	if needsScheduler {
+		// Let the scheduler know when main.main returns, for deadlock
+		// detection: main.main is called with a dummy parent coroutine that it
+		// reactivates when it returns. A main.main that isn't async (doesn't
+		// use its parent handle) has returned when the call returns.
+		mainParent := c.builder.CreateBitCast(c.mod.NamedGlobal("runtime.mainParentTask"), c.i8ptrType, "")
+		c.builder.CreateCall(realMain, []llvm.Value{llvm.Undef(c.i8ptrType), mainParent}, "")
+		if len(getUses(realMain.LastParam())) == 0 {
+			c.createRuntimeCall("activateTask", []llvm.Value{mainParent}, "")
+		}
 		c.createRuntimeCall("scheduler", nil, "")
+	} else {
+		c.builder.CreateCall(realMain, []llvm.Value{llvm.Undef(c.i8ptrType), llvm.ConstPointerNull(c.i8ptrType)}, "")
 	}
 	mainCall.EraseFromParentAsInstruction()
 
 	if !needsScheduler {
-		go_scheduler := c.mod.NamedFunction("go_scheduler")
-		if !go_scheduler.IsNil() {
-			// This is the WebAssembly backend.
-			// There is no need to export the go_scheduler function, but it is
-			// still exported. Make sure it is optimized away.
-			go_scheduler.SetLinkage(llvm.InternalLinkage)
+		for _, name := range []string{"go_scheduler", "tinygo_poll"} {
+			fn := c.mod.NamedFunction(name)
+			if !fn.IsNil() {
+				// This is the WebAssembly backend.
+				// There is no need to export the scheduler entry points, but
+				// they are still exported. Make sure they are optimized away.
+				fn.SetLinkage(llvm.InternalLinkage)
+			}
 		}
 	}
 
-	// main.main was set to external linkage during IR construction. Set it to
-	// internal linkage to enable interprocedural optimizations.
-	realMain.SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.alloc").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.free").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.allocTask").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.freeTask").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.sleepTask").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.setTaskPromisePtr").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.getTaskPromisePtr").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.scheduler").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.selectNoCases").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.traceTaskCreated").SetLinkage(llvm.InternalLinkage)
+
+	return nil
+}
+
+// Blocking operations that make a function async, with a description for
+// error messages.
+var blockingOperations = map[string]string{
+	"time.Sleep":           "sleeps",
+	"runtime.chanSend":     "sends to a channel",
+	"runtime.chanRecv":     "receives from a channel",
+	"runtime.deadlockStub": "blocks forever",
+}
+
+// makeAsyncError returns an error for a blocking function that is used in an
+// unsupported way by the given instruction (or constant). Blocking functions
+// are turned into coroutines, which need a different calling convention, so
+// they can't be called through a function pointer. The error is reported at
+// the use if its position is known and explains why the function is blocking,
+// with the chain of calls from the function to the blocking operation.
+func (c *Compiler) makeAsyncError(f, use llvm.Value, msg string, asyncCalls map[llvm.Value]llvm.Value) error {
+	positions := c.functionPositions()
+	pos := c.callPositions[use]
+	if !pos.IsValid() && !use.IsAInstruction().IsNil() {
+		pos = positions[use.InstructionParent().Parent()]
+	}
+	if !pos.IsValid() {
+		pos = positions[f]
+	}
+	return c.makeError(pos, msg+" ("+c.asyncReason(f, asyncCalls)+")")
+}
+
+// functionPositions returns the source position of every Go function, by LLVM
+// function.
+func (c *Compiler) functionPositions() map[llvm.Value]token.Pos {
+	positions := map[llvm.Value]token.Pos{}
+	for _, fn := range c.ir.Functions {
+		if !fn.LLVMFn.IsNil() {
+			positions[fn.LLVMFn] = fn.Pos()
+		}
+	}
+	return positions
+}
+
+// asyncReason explains why the given function is blocking, like
+// "main.foo sleeps: main.foo -> main.bar -> time.Sleep at main.go:12:13", by
+// following the calls that made each function async down to the blocking
+// operation itself.
+func (c *Compiler) asyncReason(f llvm.Value, asyncCalls map[llvm.Value]llvm.Value) string {
+	path := []string{f.Name()}
+	var call llvm.Value
+	for fn := f; ; {
+		next, ok := asyncCalls[fn]
+		if !ok {
+			break
+		}
+		call = next
+		fn = call.CalledValue()
+		path = append(path, fn.Name())
+	}
+	operation := blockingOperations[path[len(path)-1]]
+	if operation == "" {
+		operation = "blocks"
+	}
+	reason := f.Name() + " " + operation + ": " + strings.Join(path, " -> ")
+	if callPos := c.callPositions[call]; !call.IsNil() && callPos.IsValid() {
+		reason += " at " + c.ir.Program.Fset.Position(callPos).String()
+	}
+	return reason
+}
 
+// checkNonBlocking returns an error for every function marked
+// //go:nonblocking that turned out to be blocking.
+func (c *Compiler) checkNonBlocking(asyncFuncs map[llvm.Value]*asyncFunc, asyncCalls map[llvm.Value]llvm.Value) error {
+	var errs []error
+	for _, f := range c.ir.Functions {
+		if !f.IsNonBlocking() || f.LLVMFn.IsNil() {
+			continue
+		}
+		if _, ok := asyncFuncs[f.LLVMFn]; ok {
+			msg := "function marked //go:nonblocking is blocking: " + c.asyncReason(f.LLVMFn, asyncCalls)
+			errs = append(errs, c.makeError(f.Pos(), msg))
+		}
+	}
+	if len(errs) != 0 {
+		return &MultiError{errs}
+	}
 	return nil
 }
 
+// printAsync prints every blocking function with the reason why it is
+// blocking, for -print-async. Blocking functions are only turned into
+// coroutines when a scheduler is needed, which is noted at the end.
+func (c *Compiler) printAsync(asyncList []llvm.Value, asyncCalls map[llvm.Value]llvm.Value, needsScheduler bool) {
+	positions := c.functionPositions()
+	type asyncFunction struct {
+		pos    token.Position
+		reason string
+	}
+	var funcs []asyncFunction
+	for _, f := range asyncList {
+		if _, ok := asyncCalls[f]; !ok {
+			continue // blocking operation itself
+		}
+		funcs = append(funcs, asyncFunction{
+			pos:    c.ir.Program.Fset.Position(positions[f]),
+			reason: c.asyncReason(f, asyncCalls),
+		})
+	}
+	sort.SliceStable(funcs, func(i, j int) bool {
+		if funcs[i].pos.Filename != funcs[j].pos.Filename {
+			return funcs[i].pos.Filename < funcs[j].pos.Filename
+		}
+		return funcs[i].pos.Offset < funcs[j].pos.Offset
+	})
+	for _, f := range funcs {
+		location := "-"
+		if f.pos.IsValid() {
+			location = f.pos.String()
+		}
+		fmt.Printf("%s: blocking function: %s\n", location, f.reason)
+	}
+	if !needsScheduler {
+		fmt.Println("no goroutine is blocking, so blocking functions are called directly instead of as coroutines")
+	}
+}
+
 // markAsyncFunctions does the bulk of the work of lowering goroutines. It
 // determines whether a scheduler is needed, and if it is, it transforms
 // blocking operations into goroutines and blocking calls into await calls.
@@ -198,6 +342,8 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	// the work items are then grey objects.
 	asyncFuncs := make(map[llvm.Value]*asyncFunc)
 	asyncList := make([]llvm.Value, 0, 4)
+	// The call that made each function async, for error messages.
+	asyncCalls := make(map[llvm.Value]llvm.Value)
 	for len(worklist) != 0 {
 		// Pick the topmost.
 		f := worklist[len(worklist)-1]
@@ -215,7 +361,8 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 				bitcastUses := getUses(use)
 				for _, call := range bitcastUses {
 					if call.IsACallInst().IsNil() || call.CalledValue().Name() != "runtime.makeGoroutine" {
-						return false, errors.New("async function " + f.Name() + " incorrectly used in bitcast, expected runtime.makeGoroutine")
+						msg := "blocking function " + f.Name() + " can only be called directly or started as a goroutine"
+						return false, c.makeAsyncError(f, call, msg, asyncCalls)
 					}
 				}
 				// This is a go statement. Do not mark the parent as async, as
@@ -226,18 +373,28 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 				// Not a call instruction. Maybe a store to a global? In any
 				// case, this requires support for async calls across function
 				// pointers which is not yet supported.
-				return false, errors.New("async function " + f.Name() + " used as function pointer")
+				msg := "blocking function " + f.Name() + " used as function pointer, which is not supported"
+				return false, c.makeAsyncError(f, use, msg, asyncCalls)
 			}
 			parent := use.InstructionParent().Parent()
 			for i := 0; i < use.OperandsCount()-1; i++ {
 				if use.Operand(i) == f {
-					return false, errors.New("async function " + f.Name() + " used as function pointer in " + parent.Name())
+					msg := "blocking function " + f.Name() + " passed as function pointer in " + parent.Name() + ", which is not supported"
+					return false, c.makeAsyncError(f, use, msg, asyncCalls)
 				}
 			}
+			if _, ok := asyncCalls[parent]; !ok {
+				asyncCalls[parent] = use
+			}
 			worklist = append(worklist, parent)
 		}
 	}
 
+	// Functions marked //go:nonblocking must not have become async.
+	if err := c.checkNonBlocking(asyncFuncs, asyncCalls); err != nil {
+		return false, err
+	}
+
 	// Check whether a scheduler is needed.
 	makeGoroutine := c.mod.NamedFunction("runtime.makeGoroutine")
 	if c.GOOS == "js" && strings.HasPrefix(c.Triple, "wasm") {
@@ -263,6 +420,10 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		}
 	}
 
+	if c.PrintAsync {
+		c.printAsync(asyncList, asyncCalls, needsScheduler) // -print-async
+	}
+
 	if !needsScheduler {
 		// No scheduler is needed. Do not transform all functions here.
 		// However, make sure that all go calls (which are all non-async) are
@@ -335,7 +496,7 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		} else if c.targetData.TypeAllocSize(size.Type()) < c.targetData.TypeAllocSize(c.uintptrType) {
 			size = c.builder.CreateZExt(size, c.uintptrType, "task.size.uintptr")
 		}
-		data := c.createRuntimeCall("alloc", []llvm.Value{size}, "task.data")
+		data := c.createRuntimeCall("allocTask", []llvm.Value{size}, "task.data")
 		if c.needsStackObjects() {
 			c.trackPointer(data)
 		}
@@ -427,13 +588,14 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 			// (possibly right after the following suspend).
 			c.createRuntimeCall("activateTask", []llvm.Value{parentHandle}, "")
 
-			// Suspend this coroutine.
-			// It would look like this is unnecessary, but if this
-			// suspend point is left out, it leads to undefined
-			// behavior somehow (with the unreachable instruction).
+			// Suspend this coroutine at its final suspend point. The frame
+			// can't be freed here as it is still in use, but it is final so
+			// that the scheduler sees that the coroutine is done after
+			// resuming it and destroys it (see runTask), which frees the
+			// frame right away instead of leaving it to the GC.
 			continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
 				llvm.ConstNull(c.ctx.TokenType()),
-				llvm.ConstInt(c.ctx.Int1Type(), 0, false),
+				llvm.ConstInt(c.ctx.Int1Type(), 1, false),
 			}, "ret")
 			sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
 			sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), frame.unreachableBlock)
@@ -444,7 +606,7 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		// Coroutine cleanup. Free resources associated with this coroutine.
 		c.builder.SetInsertPointAtEnd(frame.cleanupBlock)
 		mem := c.builder.CreateCall(coroFreeFunc, []llvm.Value{id, frame.taskHandle}, "task.data.free")
-		c.createRuntimeCall("free", []llvm.Value{mem}, "")
+		c.createRuntimeCall("freeTask", []llvm.Value{mem}, "")
 		c.builder.CreateBr(frame.suspendBlock)
 
 		// Coroutine suspend. A call to llvm.coro.suspend() will branch here.
@@ -502,6 +664,7 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 
 		// Exit coroutine.
 		c.builder.SetInsertPointBefore(deadlockCall)
+		c.createRuntimeCall("selectNoCases", []llvm.Value{frame.taskHandle}, "")
 		continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
 			llvm.ConstNull(c.ctx.TokenType()),
 			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
@@ -584,13 +747,17 @@ func (c *Compiler) lowerMakeGoroutineCalls() error {
 		}
 		realCall := uses[0]
 
+		// Let trace recorders know that a goroutine is started.
+		c.builder.SetInsertPointBefore(realCall)
+		fnPtr := c.builder.CreatePtrToInt(origFunc, c.uintptrType, "")
+		c.createRuntimeCall("traceTaskCreated", []llvm.Value{fnPtr}, "")
+
 		// Create call instruction.
 		var params []llvm.Value
 		for i := 0; i < realCall.OperandsCount()-1; i++ {
 			params = append(params, realCall.Operand(i))
 		}
 		params[len(params)-1] = llvm.ConstPointerNull(c.i8ptrType) // parent coroutine handle (must be nil)
-		c.builder.SetInsertPointBefore(realCall)
 		c.builder.CreateCall(origFunc, params, "")
 		realCall.EraseFromParentAsInstruction()
 		bitcastOut.EraseFromParentAsInstruction()
@@ -24,8 +24,9 @@ package compiler
 // interfaceImplements:
 //     This call is translated into a call that checks whether the underlying
 //     type is one of the types implementing this interface.
-//     When there is only one type implementing this interface, the check is
-//     replaced with a simple icmp instruction, just like a type assert.
+//     When there is only one type implementing this interface (ignoring types
+//     that are never put in an interface), the check is replaced with a simple
+//     icmp instruction, just like a type assert.
 //     When there is no type at all that implements this interface, it is
 //     replaced with a constant false to optimize it completely.
 //
@@ -33,7 +34,11 @@ package compiler
 //     This call is replaced with a call to a function that calls the
 //     appropriate method depending on the underlying type.
 //     When there is only one type implementing this interface, this call is
-//     translated into a direct call of that method.
+//     translated into a direct call of that method. This also happens when
+//     other types implement the interface, but are never put in an interface
+//     in the (optimized) program: these can never be the dynamic type of an
+//     interface value, so the call is devirtualized, after which LLVM may
+//     inline the method.
 //     When there is no type implementing this interface, this code is marked
 //     unreachable as there is no way such an interface could be constructed.
 //
@@ -82,6 +87,7 @@ type typeInfo struct {
 	name                string
 	typecode            llvm.Value
 	methodSet           llvm.Value
+	reflectMethods      llvm.Value
 	num                 uint64 // the type number after lowering
 	countMakeInterfaces int    // how often this type is used in an interface
 	countTypeAsserts    int    // how often a type assert happens on this method
@@ -187,6 +193,10 @@ func (p *lowerInterfacesPass) run() {
 			methodSet := llvm.ConstExtractValue(initializer, []uint32{1})
 			t := p.types[typecode.Name()]
 			p.addTypeMethods(t, methodSet)
+			reflectMethods := llvm.ConstExtractValue(initializer, []uint32{2})
+			if t.reflectMethods.IsNil() && !reflectMethods.IsNull() {
+				t.reflectMethods = reflectMethods.Operand(0) // get global from GEP
+			}
 
 			// Count the number of MakeInterface instructions, for sorting the
 			// typecodes later.
@@ -233,6 +243,15 @@ func (p *lowerInterfacesPass) run() {
 			continue
 		}
 
+		// This type is never put in an interface (after optimizations removed
+		// dead code), so an interface can never hold it and it does not need
+		// to be considered for interface method calls or type asserts. This
+		// devirtualizes interface method calls when only one of the types
+		// that implement the interface is actually used in an interface.
+		if t.countMakeInterfaces == 0 {
+			continue
+		}
+
 		// Pre-calculate a set of signatures that this type has, for easy
 		// lookup/check.
 		typeSignatureSet := make(map[*signatureInfo]struct{})
@@ -459,6 +478,29 @@ func (p *lowerInterfacesPass) run() {
 			typ.methodSet.EraseFromParentAsGlobal()
 			typ.methodSet = llvm.Value{}
 		}
+		if !typ.reflectMethods.IsNil() {
+			typ.reflectMethods.EraseFromParentAsGlobal()
+			typ.reflectMethods = llvm.Value{}
+		}
+	}
+
+	// Remove the type information stored in type codes, which has been
+	// converted to the reflect sidetables. The struct field and func parameter
+	// lists refer to external globals that only exist for their names.
+	var typeLists []llvm.Value
+	for _, typ := range p.types {
+		initializer := typ.typecode.Initializer()
+		if initializer.IsNil() {
+			continue
+		}
+		references := llvm.ConstExtractValue(initializer, []uint32{0})
+		if !references.IsAConstantExpr().IsNil() && references.Opcode() == llvm.BitCast {
+			typeLists = append(typeLists, references.Operand(0))
+		}
+		typ.typecode.SetInitializer(llvm.ConstNull(initializer.Type()))
+	}
+	for _, global := range typeLists {
+		global.EraseFromParentAsGlobal()
 	}
 }
 
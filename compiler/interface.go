@@ -26,11 +26,12 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, pos token.
 	itfValue := c.emitPointerPack([]llvm.Value{val})
 	itfTypeCodeGlobal := c.getTypeCode(typ)
 	itfMethodSetGlobal := c.getTypeMethodSet(typ)
+	itfReflectMethodsGlobal := c.getTypeReflectMethods(typ)
 	itfConcreteTypeGlobal := c.mod.NamedGlobal("typeInInterface:" + itfTypeCodeGlobal.Name())
 	if itfConcreteTypeGlobal.IsNil() {
 		typeInInterface := c.getLLVMRuntimeType("typeInInterface")
 		itfConcreteTypeGlobal = llvm.AddGlobal(c.mod, typeInInterface, "typeInInterface:"+itfTypeCodeGlobal.Name())
-		itfConcreteTypeGlobal.SetInitializer(llvm.ConstNamedStruct(typeInInterface, []llvm.Value{itfTypeCodeGlobal, itfMethodSetGlobal}))
+		itfConcreteTypeGlobal.SetInitializer(llvm.ConstNamedStruct(typeInInterface, []llvm.Value{itfTypeCodeGlobal, itfMethodSetGlobal, itfReflectMethodsGlobal}))
 		itfConcreteTypeGlobal.SetGlobalConstant(true)
 		itfConcreteTypeGlobal.SetLinkage(llvm.PrivateLinkage)
 	}
@@ -42,14 +43,159 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, pos token.
 }
 
 // getTypeCode returns a reference to a type code.
-// It returns a pointer to an external global which should be replaced with the
-// real type in the interface lowering pass.
+// It returns a pointer to a global which should be replaced with the real type
+// in the interface lowering pass.
 func (c *Compiler) getTypeCode(typ types.Type) llvm.Value {
 	globalName := "type:" + getTypeCodeName(typ)
 	global := c.mod.NamedGlobal(globalName)
 	if global.IsNil() {
 		global = llvm.AddGlobal(c.mod, c.getLLVMRuntimeType("typecodeID"), globalName)
 		global.SetGlobalConstant(true)
+
+		// Some type classes contain more information for underlying types or
+		// element types. Store it directly in the typecode global to make
+		// reflect lowering simpler. Note that the global must exist before
+		// recursing, for recursive types.
+		var references llvm.Value
+		var length uint64
+		switch typ := typ.(type) {
+		case *types.Named:
+			references = c.getTypeCode(typ.Underlying())
+		case *types.Chan:
+			references = c.getTypeCode(typ.Elem())
+		case *types.Pointer:
+			references = c.getTypeCode(typ.Elem())
+		case *types.Slice:
+			references = c.getTypeCode(typ.Elem())
+		case *types.Array:
+			references = c.getTypeCode(typ.Elem())
+			length = uint64(typ.Len())
+		case *types.Struct:
+			references = llvm.ConstBitCast(c.makeStructTypeFields(typ), global.Type())
+			length = c.targetData.TypeAllocSize(c.getLLVMType(typ))
+		case *types.Signature:
+			references = llvm.ConstBitCast(c.makeFuncTypeParams(typ), global.Type())
+			length = uint64(typ.Params().Len())
+		case *types.Map:
+			references = llvm.ConstBitCast(c.makeMapTypeElems(typ), global.Type())
+		case *types.Interface:
+			if typ.NumMethods() != 0 {
+				references = llvm.ConstBitCast(c.makeInterfaceTypeMethods(typ), global.Type())
+				length = uint64(typ.NumMethods())
+			}
+		}
+		if !references.IsNil() {
+			// Set the fields of the runtime.typecodeID struct.
+			globalValue := c.getZeroValue(global.Type().ElementType())
+			globalValue = llvm.ConstInsertValue(globalValue, references, []uint32{0})
+			globalValue = llvm.ConstInsertValue(globalValue, llvm.ConstInt(c.uintptrType, length, false), []uint32{1})
+			global.SetInitializer(globalValue)
+			global.SetLinkage(llvm.PrivateLinkage)
+		}
+	}
+	return global
+}
+
+// makeStructTypeFields creates a new global with an array of runtime.structField
+// values, one for each field of the struct. It is only used by the interface
+// lowering pass.
+func (c *Compiler) makeStructTypeFields(typ *types.Struct) llvm.Value {
+	structFieldType := c.getLLVMRuntimeType("structField")
+	llvmStructType := c.getLLVMType(typ)
+	fields := make([]llvm.Value, typ.NumFields())
+	for i := range fields {
+		field := typ.Field(i)
+		tag := llvm.ConstPointerNull(c.i8ptrType)
+		if typ.Tag(i) != "" {
+			tag = c.getReflectName(typ.Tag(i))
+		}
+		embedded := uint64(0)
+		if field.Embedded() {
+			embedded = 1
+		}
+		fields[i] = llvm.ConstNamedStruct(structFieldType, []llvm.Value{
+			c.getTypeCode(field.Type()),
+			c.getReflectName(field.Name()),
+			tag,
+			llvm.ConstInt(c.uintptrType, c.targetData.ElementOffset(llvmStructType, i), false),
+			llvm.ConstInt(c.ctx.Int1Type(), embedded, false),
+		})
+	}
+	value := llvm.ConstArray(structFieldType, fields)
+	global := llvm.AddGlobal(c.mod, value.Type(), "reflect/types.structFields")
+	global.SetInitializer(value)
+	global.SetGlobalConstant(true)
+	global.SetLinkage(llvm.PrivateLinkage)
+	return global
+}
+
+// makeFuncTypeParams creates a new global with an array of type codes: first
+// the parameters and then the results of the signature. It is only used by the
+// interface lowering pass.
+func (c *Compiler) makeFuncTypeParams(typ *types.Signature) llvm.Value {
+	typecodeIDPtr := llvm.PointerType(c.getLLVMRuntimeType("typecodeID"), 0)
+	var params []llvm.Value
+	for i := 0; i < typ.Params().Len(); i++ {
+		params = append(params, c.getTypeCode(typ.Params().At(i).Type()))
+	}
+	for i := 0; i < typ.Results().Len(); i++ {
+		params = append(params, c.getTypeCode(typ.Results().At(i).Type()))
+	}
+	value := llvm.ConstArray(typecodeIDPtr, params)
+	global := llvm.AddGlobal(c.mod, value.Type(), "reflect/types.funcParams")
+	global.SetInitializer(value)
+	global.SetGlobalConstant(true)
+	global.SetLinkage(llvm.PrivateLinkage)
+	return global
+}
+
+// makeMapTypeElems creates a new global with an array of two type codes: the
+// key type and the element type of the map. It is only used by the interface
+// lowering pass.
+func (c *Compiler) makeMapTypeElems(typ *types.Map) llvm.Value {
+	typecodeIDPtr := llvm.PointerType(c.getLLVMRuntimeType("typecodeID"), 0)
+	value := llvm.ConstArray(typecodeIDPtr, []llvm.Value{
+		c.getTypeCode(typ.Key()),
+		c.getTypeCode(typ.Elem()),
+	})
+	global := llvm.AddGlobal(c.mod, value.Type(), "reflect/types.mapElems")
+	global.SetInitializer(value)
+	global.SetGlobalConstant(true)
+	global.SetLinkage(llvm.PrivateLinkage)
+	return global
+}
+
+// makeInterfaceTypeMethods creates a new global with an array of
+// runtime.reflectMethod values, one for each method of the interface. The call
+// field is always nil. It is only used by the interface lowering pass.
+func (c *Compiler) makeInterfaceTypeMethods(typ *types.Interface) llvm.Value {
+	reflectMethodType := c.getLLVMRuntimeType("reflectMethod")
+	methods := make([]llvm.Value, typ.NumMethods())
+	for i := range methods {
+		method := typ.Method(i)
+		methods[i] = llvm.ConstNamedStruct(reflectMethodType, []llvm.Value{
+			c.getReflectName(method.Name()),
+			c.getTypeCode(method.Type()),
+			llvm.ConstPointerNull(c.i8ptrType),
+		})
+	}
+	value := llvm.ConstArray(reflectMethodType, methods)
+	global := llvm.AddGlobal(c.mod, value.Type(), "reflect/types.interfaceMethods")
+	global.SetInitializer(value)
+	global.SetGlobalConstant(true)
+	global.SetLinkage(llvm.PrivateLinkage)
+	return global
+}
+
+// getReflectName returns a reference to an external global that has the given
+// string (a field name, tag or method name) in its name. Like method
+// signatures, only the name of the global is used by the interface lowering
+// pass.
+func (c *Compiler) getReflectName(name string) llvm.Value {
+	global := c.mod.NamedGlobal("reflect/names:" + name)
+	if global.IsNil() {
+		global = llvm.AddGlobal(c.mod, c.ctx.Int8Type(), "reflect/names:"+name)
+		global.SetGlobalConstant(true)
 	}
 	return global
 }
@@ -142,7 +288,19 @@ func getTypeCodeName(t types.Type) string {
 			panic("cgo unions are not allowed in interfaces")
 		}
 		for i := 0; i < t.NumFields(); i++ {
-			elems[i] = getTypeCodeName(t.Field(i).Type())
+			field := t.Field(i)
+			elem := field.Name()
+			if !field.Exported() {
+				elem = field.Pkg().Path() + "." + elem
+			}
+			if field.Embedded() {
+				elem = "#" + elem
+			}
+			elem += ":" + getTypeCodeName(field.Type())
+			if t.Tag(i) != "" {
+				elem += "`" + strconv.Quote(t.Tag(i)) + "`"
+			}
+			elems[i] = elem
 		}
 		return "struct:" + name + "{" + strings.Join(elems, ",") + "}"
 	default:
@@ -192,6 +350,55 @@ func (c *Compiler) getTypeMethodSet(typ types.Type) llvm.Value {
 	return llvm.ConstGEP(global, []llvm.Value{zero, zero})
 }
 
+// getTypeReflectMethods returns a reference (GEP) to a global with the exported
+// methods of the given type, for use by the reflect package. Like the method
+// set, it should be unreferenced after the interface lowering pass.
+func (c *Compiler) getTypeReflectMethods(typ types.Type) llvm.Value {
+	reflectMethodType := c.getLLVMRuntimeType("reflectMethod")
+	if c.ir.Program.ImportedPackage("reflect") == nil {
+		// The reflect package isn't used, so there is no way to call these
+		// methods.
+		return llvm.ConstPointerNull(llvm.PointerType(reflectMethodType, 0))
+	}
+	global := c.mod.NamedGlobal(typ.String() + "$reflectmethods")
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	if !global.IsNil() {
+		// the method list already exists
+		return llvm.ConstGEP(global, []llvm.Value{zero, zero})
+	}
+
+	ms := c.ir.Program.MethodSets.MethodSet(typ)
+	var methods []llvm.Value
+	for i := 0; i < ms.Len(); i++ {
+		method := ms.At(i)
+		if !method.Obj().Exported() {
+			// Unexported methods can't be called using reflection.
+			continue
+		}
+		f := c.ir.GetFunction(c.ir.Program.MethodValue(method))
+		if f.LLVMFn.IsNil() {
+			// compiler error, so panic
+			panic("cannot find function: " + f.LinkName())
+		}
+		methods = append(methods, llvm.ConstNamedStruct(reflectMethodType, []llvm.Value{
+			c.getReflectName(method.Obj().Name()),
+			c.getTypeCode(method.Type()),
+			llvm.ConstBitCast(c.getReflectCallThunk(f), c.i8ptrType),
+		}))
+	}
+	if len(methods) == 0 {
+		// no exported methods, so can leave that one out
+		return llvm.ConstPointerNull(llvm.PointerType(reflectMethodType, 0))
+	}
+
+	value := llvm.ConstArray(reflectMethodType, methods)
+	global = llvm.AddGlobal(c.mod, value.Type(), typ.String()+"$reflectmethods")
+	global.SetInitializer(value)
+	global.SetGlobalConstant(true)
+	global.SetLinkage(llvm.PrivateLinkage)
+	return llvm.ConstGEP(global, []llvm.Value{zero, zero})
+}
+
 // getInterfaceMethodSet returns a global variable with the method set of the
 // given named interface type. This method set is used by the interface lowering
 // pass.
@@ -423,3 +630,95 @@ func (c *Compiler) createInterfaceInvokeWrapper(state interfaceInvokeWrapper) {
 		c.builder.CreateRet(ret)
 	}
 }
+
+// reflectCallThunk keeps some state between getReflectCallThunk and
+// createReflectCallThunk, like interfaceInvokeWrapper.
+type reflectCallThunk struct {
+	fn      *ir.Function
+	thunk   llvm.Value
+	wrapper llvm.Value
+}
+
+// getReflectCallThunk returns a thunk to call a method from the reflect
+// package. The thunk has the following signature:
+//
+//     func(receiver, args, results unsafe.Pointer)
+//
+// The receiver is passed as in an interface value. The args and results
+// parameters point to arrays of pointers to each parameter and result value,
+// so that the reflect package doesn't need to know anything about the calling
+// convention.
+func (c *Compiler) getReflectCallThunk(f *ir.Function) llvm.Value {
+	thunkName := f.LinkName() + "$reflectcall"
+	thunk := c.mod.NamedFunction(thunkName)
+	if !thunk.IsNil() {
+		// Thunk already created. Return it directly.
+		return thunk
+	}
+
+	paramTypes := []llvm.Type{c.i8ptrType, c.i8ptrType, c.i8ptrType, c.i8ptrType, c.i8ptrType}
+	thunkType := llvm.FunctionType(c.ctx.VoidType(), paramTypes, false)
+	thunk = llvm.AddFunction(c.mod, thunkName, thunkType)
+	c.reflectCallThunks = append(c.reflectCallThunks, reflectCallThunk{
+		fn:      f,
+		thunk:   thunk,
+		wrapper: c.getInterfaceInvokeWrapper(f),
+	})
+	return thunk
+}
+
+// createReflectCallThunk finishes the work of getReflectCallThunk, see that
+// function for details.
+func (c *Compiler) createReflectCallThunk(state reflectCallThunk) {
+	thunk := state.thunk
+	fn := state.fn
+	wrapper := state.wrapper
+	thunk.SetLinkage(llvm.InternalLinkage)
+	thunk.SetUnnamedAddr(true)
+
+	// add debug info if needed
+	if c.Debug {
+		pos := c.ir.Program.Fset.Position(fn.Pos())
+		difunc := c.attachDebugInfoRaw(fn, thunk, "$reflectcall", pos.Filename, pos.Line)
+		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), difunc, llvm.Metadata{})
+	}
+
+	// set up IR builder
+	block := c.ctx.AddBasicBlock(thunk, "entry")
+	c.builder.SetInsertPointAtEnd(block)
+
+	receiver := thunk.Param(0)
+	if receiver.Type() != wrapper.FirstParam().Type() {
+		// When the receiver is a pointer, the method is not wrapped.
+		receiver = c.builder.CreateBitCast(receiver, wrapper.FirstParam().Type(), "")
+	}
+	args := []llvm.Value{receiver}
+
+	// Load all parameters through the array of pointers.
+	sig := fn.Signature
+	argPtrs := c.builder.CreateBitCast(thunk.Param(1), llvm.PointerType(c.i8ptrType, 0), "args")
+	for i := 0; i < sig.Params().Len(); i++ {
+		argType := c.getLLVMType(sig.Params().At(i).Type())
+		index := llvm.ConstInt(c.ctx.Int32Type(), uint64(i), false)
+		argPtr := c.builder.CreateLoad(c.builder.CreateInBoundsGEP(argPtrs, []llvm.Value{index}, ""), "")
+		argPtr = c.builder.CreateBitCast(argPtr, llvm.PointerType(argType, 0), "")
+		args = append(args, c.builder.CreateLoad(argPtr, ""))
+	}
+	args = append(args, llvm.Undef(c.i8ptrType)) // unused context parameter
+	args = append(args, llvm.Undef(c.i8ptrType)) // parent goroutine handle
+	result := c.createCall(wrapper, args, "")
+
+	// Store all results through the array of pointers.
+	resultPtrs := c.builder.CreateBitCast(thunk.Param(2), llvm.PointerType(c.i8ptrType, 0), "results")
+	for i := 0; i < sig.Results().Len(); i++ {
+		value := result
+		if sig.Results().Len() > 1 {
+			value = c.builder.CreateExtractValue(result, i, "")
+		}
+		index := llvm.ConstInt(c.ctx.Int32Type(), uint64(i), false)
+		resultPtr := c.builder.CreateLoad(c.builder.CreateInBoundsGEP(resultPtrs, []llvm.Value{index}, ""), "")
+		resultPtr = c.builder.CreateBitCast(resultPtr, llvm.PointerType(value.Type(), 0), "")
+		c.builder.CreateStore(value, resultPtr)
+	}
+	c.builder.CreateRetVoid()
+}
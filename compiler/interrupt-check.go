@@ -0,0 +1,123 @@
+package compiler
+
+// This file implements a check for interrupt handlers. Code that runs in an
+// interrupt must not allocate heap memory (the heap is not protected against
+// concurrent use) and must not block or use channels (the scheduler cannot
+// switch away from an interrupt). This check walks the call graph from every
+// interrupt handler and reports when one of those operations can be reached.
+//
+// The check works on the LLVM IR after the Go-specific optimizations, so heap
+// allocations that were moved to the stack and interface and func value calls
+// that were turned into direct calls are handled precisely. Calls through
+// function pointers that could not be resolved are not followed.
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"strings"
+
+	"tinygo.org/x/go-llvm"
+)
+
+// interruptHandler is a function that is called directly from the interrupt
+// vector, see isInterruptHandler.
+type interruptHandler struct {
+	fn  llvm.Value
+	pos token.Pos
+}
+
+// Runtime functions that are not allowed in an interrupt handler, with a
+// description of the problem.
+var interruptUnsafeFunctions = map[string]string{
+	"runtime.alloc":        "may allocate heap memory",
+	"runtime.chanSend":     "may use a channel",
+	"runtime.chanRecv":     "may use a channel",
+	"runtime.chanSelect":   "may use a channel",
+	"runtime.chanClose":    "may use a channel",
+	"runtime.deadlockStub": "may block",
+	"time.Sleep":           "may block",
+}
+
+// checkInterrupts checks that interrupt handlers don't allocate or block,
+// depending on the ISRCheck setting: problems are returned as errors,
+// printed as warnings or not checked at all.
+func (c *Compiler) checkInterrupts() error {
+	if c.ISRCheck == "off" {
+		return nil
+	}
+	var errs []error
+	for _, handler := range c.interruptHandlers {
+		for _, msg := range c.checkInterruptHandler(handler.fn) {
+			if c.ISRCheck == "error" {
+				errs = append(errs, c.makeError(handler.pos, msg))
+			} else {
+				fmt.Fprintln(os.Stderr, c.makeError(handler.pos, "warning: "+msg))
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return &MultiError{errs}
+	}
+	return nil
+}
+
+// checkInterruptHandler walks all functions reachable from the given interrupt
+// handler and returns a message for each kind of problem it finds. Only the
+// first problem of each kind is reported, with the call path that leads to
+// it.
+func (c *Compiler) checkInterruptHandler(handler llvm.Value) []string {
+	var msgs []string
+	reported := make(map[string]bool)
+	parents := map[llvm.Value]llvm.Value{handler: llvm.Value{}}
+	worklist := []llvm.Value{handler}
+	for len(worklist) != 0 {
+		fn := worklist[0]
+		worklist = worklist[1:]
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if inst.IsACallInst().IsNil() {
+					continue
+				}
+				callee := inst.CalledValue()
+				if callee.IsAFunction().IsNil() {
+					continue // function pointer, can't follow
+				}
+				if problem, ok := interruptUnsafeFunctions[callee.Name()]; ok {
+					if reported[problem] {
+						continue
+					}
+					reported[problem] = true
+					msg := fmt.Sprintf("interrupt handler %s %s: %s", handler.Name(), problem, callPath(parents, fn, callee))
+					if pos := c.allocPositions[inst]; pos.IsValid() {
+						msg += " at " + c.ir.Program.Fset.Position(pos).String()
+					}
+					msgs = append(msgs, msg)
+					continue
+				}
+				if callee.IsDeclaration() {
+					continue
+				}
+				if _, ok := parents[callee]; !ok {
+					parents[callee] = fn
+					worklist = append(worklist, callee)
+				}
+			}
+		}
+	}
+	return msgs
+}
+
+// callPath returns the chain of calls from the interrupt handler to the given
+// callee in fn, like "handler -> main.foo -> runtime.alloc".
+func callPath(parents map[llvm.Value]llvm.Value, fn, callee llvm.Value) string {
+	path := []string{callee.Name()}
+	for !fn.IsNil() {
+		path = append(path, fn.Name())
+		fn = parents[fn]
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return strings.Join(path, " -> ")
+}
@@ -23,6 +23,10 @@ func (c *Compiler) emitMapLookup(keyType, valueType types.Type, m, key llvm.Valu
 		// key is a string
 		params := []llvm.Value{m, key, mapValuePtr}
 		commaOkValue = c.createRuntimeCall("hashmapStringGet", params, "")
+	} else if c.hashmapIsIntKey(keyType) {
+		// key is a bool or small integer, passed by value
+		params := []llvm.Value{m, c.createHashmapIntKey(key), mapValuePtr}
+		commaOkValue = c.createRuntimeCall("hashmapIntGet", params, "")
 	} else if hashmapIsBinaryKey(keyType) {
 		// key can be compared with runtime.memequal
 		// Store the key in an alloca, in the entry block to avoid dynamic stack
@@ -33,9 +37,16 @@ func (c *Compiler) emitMapLookup(keyType, valueType types.Type, m, key llvm.Valu
 		params := []llvm.Value{m, mapKeyPtr, mapValuePtr}
 		commaOkValue = c.createRuntimeCall("hashmapBinaryGet", params, "")
 		c.emitLifetimeEnd(mapKeyPtr, mapKeySize)
+	} else if hashmapIsFloatKey(keyType) {
+		// key is a float32 or float64
+		mapKeyAlloca, mapKeyPtr, mapKeySize := c.createTemporaryAlloca(key.Type(), "hashmap.key")
+		c.builder.CreateStore(key, mapKeyAlloca)
+		params := []llvm.Value{m, mapKeyPtr, mapValuePtr}
+		commaOkValue = c.createRuntimeCall("hashmapFloatGet", params, "")
+		c.emitLifetimeEnd(mapKeyPtr, mapKeySize)
 	} else {
 		// Not trivially comparable using memcmp.
-		return llvm.Value{}, c.makeError(pos, "only strings, bools, ints, pointers or structs of bools/ints are supported as map keys, but got: "+keyType.String())
+		return llvm.Value{}, c.makeError(pos, "only strings, bools, ints, floats, pointers or structs of bools/ints are supported as map keys, but got: "+keyType.String())
 	}
 
 	// Load the resulting value from the hashmap. The value is set to the zero
@@ -61,6 +72,10 @@ func (c *Compiler) emitMapUpdate(keyType types.Type, m, key, value llvm.Value, p
 		// key is a string
 		params := []llvm.Value{m, key, valuePtr}
 		c.createRuntimeCall("hashmapStringSet", params, "")
+	} else if c.hashmapIsIntKey(keyType) {
+		// key is a bool or small integer, passed by value
+		params := []llvm.Value{m, c.createHashmapIntKey(key), valuePtr}
+		c.createRuntimeCall("hashmapIntSet", params, "")
 	} else if hashmapIsBinaryKey(keyType) {
 		// key can be compared with runtime.memequal
 		keyAlloca, keyPtr, keySize := c.createTemporaryAlloca(key.Type(), "hashmap.key")
@@ -68,8 +83,15 @@ func (c *Compiler) emitMapUpdate(keyType types.Type, m, key, value llvm.Value, p
 		params := []llvm.Value{m, keyPtr, valuePtr}
 		c.createRuntimeCall("hashmapBinarySet", params, "")
 		c.emitLifetimeEnd(keyPtr, keySize)
+	} else if hashmapIsFloatKey(keyType) {
+		// key is a float32 or float64
+		keyAlloca, keyPtr, keySize := c.createTemporaryAlloca(key.Type(), "hashmap.key")
+		c.builder.CreateStore(key, keyAlloca)
+		params := []llvm.Value{m, keyPtr, valuePtr}
+		c.createRuntimeCall("hashmapFloatSet", params, "")
+		c.emitLifetimeEnd(keyPtr, keySize)
 	} else {
-		c.addError(pos, "only strings, bools, ints, pointers or structs of bools/ints are supported as map keys, but got: "+keyType.String())
+		c.addError(pos, "only strings, bools, ints, floats, pointers or structs of bools/ints are supported as map keys, but got: "+keyType.String())
 	}
 	c.emitLifetimeEnd(valuePtr, valueSize)
 }
@@ -81,6 +103,10 @@ func (c *Compiler) emitMapDelete(keyType types.Type, m, key llvm.Value, pos toke
 		params := []llvm.Value{m, key}
 		c.createRuntimeCall("hashmapStringDelete", params, "")
 		return nil
+	} else if c.hashmapIsIntKey(keyType) {
+		params := []llvm.Value{m, c.createHashmapIntKey(key)}
+		c.createRuntimeCall("hashmapIntDelete", params, "")
+		return nil
 	} else if hashmapIsBinaryKey(keyType) {
 		keyAlloca, keyPtr, keySize := c.createTemporaryAlloca(key.Type(), "hashmap.key")
 		c.builder.CreateStore(key, keyAlloca)
@@ -88,8 +114,15 @@ func (c *Compiler) emitMapDelete(keyType types.Type, m, key llvm.Value, pos toke
 		c.createRuntimeCall("hashmapBinaryDelete", params, "")
 		c.emitLifetimeEnd(keyPtr, keySize)
 		return nil
+	} else if hashmapIsFloatKey(keyType) {
+		keyAlloca, keyPtr, keySize := c.createTemporaryAlloca(key.Type(), "hashmap.key")
+		c.builder.CreateStore(key, keyAlloca)
+		params := []llvm.Value{m, keyPtr}
+		c.createRuntimeCall("hashmapFloatDelete", params, "")
+		c.emitLifetimeEnd(keyPtr, keySize)
+		return nil
 	} else {
-		return c.makeError(pos, "only strings, bools, ints, pointers or structs of bools/ints are supported as map keys, but got: "+keyType.String())
+		return c.makeError(pos, "only strings, bools, ints, floats, pointers or structs of bools/ints are supported as map keys, but got: "+keyType.String())
 	}
 }
 
@@ -139,3 +172,29 @@ func hashmapIsBinaryKey(keyType types.Type) bool {
 		return false
 	}
 }
+
+// Returns true if this key type is a bool or an integer of at most 32 bits.
+// These keys are passed to the runtime by value, see hashmapIntSet.
+func (c *Compiler) hashmapIsIntKey(keyType types.Type) bool {
+	t, ok := keyType.Underlying().(*types.Basic)
+	if !ok || t.Info()&(types.IsBoolean|types.IsInteger) == 0 {
+		return false
+	}
+	return c.targetData.TypeAllocSize(c.getLLVMType(t)) <= 4
+}
+
+// createHashmapIntKey zero-extends a key for which hashmapIsIntKey returns
+// true to 32 bits.
+func (c *Compiler) createHashmapIntKey(key llvm.Value) llvm.Value {
+	if key.Type().IntTypeWidth() < 32 {
+		return c.builder.CreateZExt(key, c.ctx.Int32Type(), "hashmap.key")
+	}
+	return key
+}
+
+// Returns true if this key type is a float32 or float64. These keys need to be
+// compared as floats, because of negative zero and NaN.
+func hashmapIsFloatKey(keyType types.Type) bool {
+	t, ok := keyType.Underlying().(*types.Basic)
+	return ok && t.Info()&types.IsFloat != 0
+}
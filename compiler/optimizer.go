@@ -2,6 +2,9 @@ package compiler
 
 import (
 	"errors"
+	"fmt"
+	"go/token"
+	"sort"
 
 	"tinygo.org/x/go-llvm"
 )
@@ -21,6 +24,9 @@ func (c *Compiler) Optimize(optLevel, sizeLevel int, inlinerThreshold uint) erro
 	if c.PanicStrategy == "trap" {
 		c.replacePanicsWithTrap() // -panic=trap
 	}
+	if c.PanicTrace {
+		c.addFramePointers() // -panic-trace
+	}
 
 	// Run function passes for each function.
 	funcPasses := llvm.NewFunctionPassManagerForModule(c.mod)
@@ -45,6 +51,7 @@ func (c *Compiler) Optimize(optLevel, sizeLevel int, inlinerThreshold uint) erro
 		// Run Go-specific optimization passes.
 		c.OptimizeMaps()
 		c.OptimizeStringToBytes()
+		c.OptimizeBytesToString()
 		c.OptimizeAllocs()
 		c.LowerInterfaces()
 		c.LowerFuncValues()
@@ -56,7 +63,20 @@ func (c *Compiler) Optimize(optLevel, sizeLevel int, inlinerThreshold uint) erro
 
 		// Run TinyGo-specific interprocedural optimizations.
 		c.OptimizeAllocs()
+		if c.PrintAllocs != nil {
+			c.printAllocs() // -print-allocs
+		}
+		if err := c.checkInterrupts(); err != nil {
+			return err
+		}
+		if err := c.checkRecursion(); err != nil {
+			return err
+		}
+		if err := c.checkStrict(); err != nil {
+			return err
+		}
 		c.OptimizeStringToBytes()
+		c.OptimizeBytesToString()
 
 		// Lower runtime.isnil calls to regular nil comparisons.
 		isnil := c.mod.NamedFunction("runtime.isnil")
@@ -82,6 +102,15 @@ func (c *Compiler) Optimize(optLevel, sizeLevel int, inlinerThreshold uint) erro
 		// Must be run at any optimization level.
 		c.LowerInterfaces()
 		c.LowerFuncValues()
+		if err := c.checkInterrupts(); err != nil {
+			return err
+		}
+		if err := c.checkRecursion(); err != nil {
+			return err
+		}
+		if err := c.checkStrict(); err != nil {
+			return err
+		}
 		err := c.LowerGoroutines()
 		if err != nil {
 			return err
@@ -122,14 +151,14 @@ func (c *Compiler) Optimize(optLevel, sizeLevel int, inlinerThreshold uint) erro
 		}
 	}
 
-	return nil
+	return c.scrubSymbols() // -scrub
 }
 
 // Replace panic calls with calls to llvm.trap, to reduce code size. This is the
 // -panic=trap intrinsic.
 func (c *Compiler) replacePanicsWithTrap() {
 	trap := c.mod.NamedFunction("llvm.trap")
-	for _, name := range []string{"runtime._panic", "runtime.runtimePanic"} {
+	for _, name := range []string{"runtime._panic", "runtime.runtimePanic", "runtime.panicCode", "runtime.runtimePanicAt"} {
 		fn := c.mod.NamedFunction(name)
 		if fn.IsNil() {
 			continue
@@ -144,6 +173,19 @@ func (c *Compiler) replacePanicsWithTrap() {
 	}
 }
 
+// Keep a frame pointer in every function, so that the runtime can walk the
+// chain of frame pointers to print a call trace on a panic. This is the
+// -panic-trace flag.
+func (c *Compiler) addFramePointers() {
+	attr := c.ctx.CreateStringAttribute("no-frame-pointer-elim", "true")
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if fn.IsDeclaration() {
+			continue
+		}
+		fn.AddFunctionAttr(attr)
+	}
+}
+
 // Eliminate created but not used maps.
 //
 // In the future, this should statically allocate created but never modified
@@ -157,6 +199,8 @@ func (c *Compiler) OptimizeMaps() {
 
 	hashmapBinarySet := c.mod.NamedFunction("runtime.hashmapBinarySet")
 	hashmapStringSet := c.mod.NamedFunction("runtime.hashmapStringSet")
+	hashmapFloatSet := c.mod.NamedFunction("runtime.hashmapFloatSet")
+	hashmapIntSet := c.mod.NamedFunction("runtime.hashmapIntSet")
 
 	for _, makeInst := range getUses(hashmapMake) {
 		updateInsts := []llvm.Value{}
@@ -165,7 +209,7 @@ func (c *Compiler) OptimizeMaps() {
 		for _, use := range getUses(makeInst) {
 			if use := use.IsACallInst(); !use.IsNil() {
 				switch use.CalledValue() {
-				case hashmapBinarySet, hashmapStringSet:
+				case hashmapBinarySet, hashmapStringSet, hashmapFloatSet, hashmapIntSet:
 					updateInsts = append(updateInsts, use)
 				default:
 					unknownUses = true
@@ -187,9 +231,10 @@ func (c *Compiler) OptimizeMaps() {
 }
 
 // Transform runtime.stringToBytes(...) calls into const []byte slices whenever
-// possible. This optimizes the following pattern:
+// possible. This optimizes the following patterns:
 //     w.Write([]byte("foo"))
-// where Write does not store to the slice.
+//     r := bytes.NewReader([]byte("foo"))
+// where Write does not store to the slice, and a bytes.Reader never does.
 func (c *Compiler) OptimizeStringToBytes() {
 	stringToBytes := c.mod.NamedFunction("runtime.stringToBytes")
 	if stringToBytes.IsNil() {
@@ -236,6 +281,59 @@ func (c *Compiler) OptimizeStringToBytes() {
 	}
 }
 
+// Transform runtime.stringFromBytes(...) calls into direct string headers when
+// the byte slice points into a constant global. This avoids copying embedded
+// data (which usually lives in flash) into RAM in the following patterns:
+//     var data = [...]byte{...} // never modified
+//     s := string(data[:])
+//     r := strings.NewReader(string(data[:]))
+// A global that is never written to is marked constant by the global optimizer
+// pass, so both the bytes and the resulting string are immutable. Reading such
+// a global through bytes.NewReader(data[:]) doesn't copy it in the first place.
+//
+// Note that on Harvard architectures such as AVR, constant data is still read
+// with regular load instructions and thus has to be in RAM. These
+// optimizations only avoid the extra heap copy there.
+func (c *Compiler) OptimizeBytesToString() {
+	stringFromBytes := c.mod.NamedFunction("runtime.stringFromBytes")
+	if stringFromBytes.IsNil() {
+		// nothing to optimize
+		return
+	}
+
+	for _, call := range getUses(stringFromBytes) {
+		bufptr := call.Operand(0)
+		buflen := call.Operand(1)
+		if !c.isConstantGlobalPointer(bufptr) {
+			continue
+		}
+		c.builder.SetInsertPointBefore(call)
+		str := llvm.Undef(call.Type())
+		str = c.builder.CreateInsertValue(str, bufptr, 0, "")
+		str = c.builder.CreateInsertValue(str, buflen, 1, "")
+		call.ReplaceAllUsesWith(str)
+		call.EraseFromParentAsInstruction()
+	}
+}
+
+// isConstantGlobalPointer returns whether the given value is a constant pointer
+// into a global that is marked constant.
+func (c *Compiler) isConstantGlobalPointer(value llvm.Value) bool {
+	for !value.IsAConstantExpr().IsNil() {
+		switch value.Opcode() {
+		case llvm.GetElementPtr, llvm.BitCast:
+			value = value.Operand(0)
+		default:
+			return false
+		}
+	}
+	return !value.IsAGlobalVariable().IsNil() && value.IsGlobalConstant()
+}
+
+// The maximum size of a heap allocation that is moved to the stack.
+// TODO: tune this, this is just a random value.
+const maxStackAlloc = 256
+
 // Basic escape analysis: translate runtime.alloc calls into alloca
 // instructions.
 func (c *Compiler) OptimizeAllocs() {
@@ -247,64 +345,75 @@ func (c *Compiler) OptimizeAllocs() {
 
 	heapallocs := getUses(allocator)
 	for _, heapalloc := range heapallocs {
-		nilValue := llvm.Value{}
-		if heapalloc.Operand(0).IsAConstant() == nilValue {
-			// Do not allocate variable length arrays on the stack.
+		bitcast, reason := c.heapAllocReason(heapalloc)
+		if reason != "" {
+			// This allocation must stay on the heap.
 			continue
 		}
 		size := heapalloc.Operand(0).ZExtValue()
-		if size > 256 {
-			// The maximum value for a stack allocation.
-			// TODO: tune this, this is just a random value.
-			continue
-		}
 
-		// In general the pattern is:
-		//     %0 = call i8* @runtime.alloc(i32 %size)
-		//     %1 = bitcast i8* %0 to type*
-		//     (use %1 only)
-		// But the bitcast might sometimes be dropped when allocating an *i8.
-		// The 'bitcast' variable below is thus usually a bitcast of the
-		// heapalloc but not always.
-		bitcast := heapalloc // instruction that creates the value
-		if uses := getUses(heapalloc); len(uses) == 1 && uses[0].IsABitCastInst() != nilValue {
-			// getting only bitcast use
-			bitcast = uses[0]
-		}
-		if !c.doesEscape(bitcast) {
-			// Insert alloca in the entry block. Do it here so that mem2reg can
-			// promote it to a SSA value.
-			fn := bitcast.InstructionParent().Parent()
-			c.builder.SetInsertPointBefore(fn.EntryBasicBlock().FirstInstruction())
-			alignment := c.targetData.ABITypeAlignment(c.i8ptrType)
-			sizeInWords := (size + uint64(alignment) - 1) / uint64(alignment)
-			allocaType := llvm.ArrayType(c.ctx.IntType(alignment*8), int(sizeInWords))
-			alloca := c.builder.CreateAlloca(allocaType, "stackalloc.alloca")
-			zero := c.getZeroValue(alloca.Type().ElementType())
-			c.builder.CreateStore(zero, alloca)
-			stackalloc := c.builder.CreateBitCast(alloca, bitcast.Type(), "stackalloc")
-			bitcast.ReplaceAllUsesWith(stackalloc)
-			if heapalloc != bitcast {
-				bitcast.EraseFromParentAsInstruction()
-			}
-			heapalloc.EraseFromParentAsInstruction()
+		// Insert alloca in the entry block. Do it here so that mem2reg can
+		// promote it to a SSA value.
+		fn := bitcast.InstructionParent().Parent()
+		c.builder.SetInsertPointBefore(fn.EntryBasicBlock().FirstInstruction())
+		alignment := c.targetData.ABITypeAlignment(c.i8ptrType)
+		sizeInWords := (size + uint64(alignment) - 1) / uint64(alignment)
+		allocaType := llvm.ArrayType(c.ctx.IntType(alignment*8), int(sizeInWords))
+		alloca := c.builder.CreateAlloca(allocaType, "stackalloc.alloca")
+		zero := c.getZeroValue(alloca.Type().ElementType())
+		c.builder.CreateStore(zero, alloca)
+		stackalloc := c.builder.CreateBitCast(alloca, bitcast.Type(), "stackalloc")
+		bitcast.ReplaceAllUsesWith(stackalloc)
+		if heapalloc != bitcast {
+			bitcast.EraseFromParentAsInstruction()
 		}
+		heapalloc.EraseFromParentAsInstruction()
 	}
 }
 
-// Very basic escape analysis.
-func (c *Compiler) doesEscape(value llvm.Value) bool {
+// heapAllocReason checks whether the given runtime.alloc call can be replaced
+// with a stack allocation. It returns the instruction that creates the value,
+// and a description of why it must stay on the heap or an empty string if it
+// can be moved to the stack.
+func (c *Compiler) heapAllocReason(heapalloc llvm.Value) (llvm.Value, string) {
+	if heapalloc.Operand(0).IsAConstant().IsNil() {
+		// Do not allocate variable length arrays on the stack.
+		return heapalloc, "size is not known at compile time"
+	}
+	size := heapalloc.Operand(0).ZExtValue()
+	if size > maxStackAlloc {
+		return heapalloc, fmt.Sprintf("object of %d bytes is too big for the stack", size)
+	}
+
+	// In general the pattern is:
+	//     %0 = call i8* @runtime.alloc(i32 %size)
+	//     %1 = bitcast i8* %0 to type*
+	//     (use %1 only)
+	// But the bitcast might sometimes be dropped when allocating an *i8.
+	// The 'bitcast' variable below is thus usually a bitcast of the
+	// heapalloc but not always.
+	bitcast := heapalloc // instruction that creates the value
+	if uses := getUses(heapalloc); len(uses) == 1 && !uses[0].IsABitCastInst().IsNil() {
+		// getting only bitcast use
+		bitcast = uses[0]
+	}
+	return bitcast, c.escapeReason(bitcast)
+}
+
+// Very basic escape analysis. It returns a description of how the value
+// escapes, or an empty string if it doesn't escape.
+func (c *Compiler) escapeReason(value llvm.Value) string {
 	uses := getUses(value)
 	for _, use := range uses {
 		nilValue := llvm.Value{}
 		if use.IsAGetElementPtrInst() != nilValue {
-			if c.doesEscape(use) {
-				return true
+			if reason := c.escapeReason(use); reason != "" {
+				return reason
 			}
 		} else if use.IsABitCastInst() != nilValue {
 			// A bitcast escapes if the casted-to value escapes.
-			if c.doesEscape(use) {
-				return true
+			if reason := c.escapeReason(use); reason != "" {
+				return reason
 			}
 		} else if use.IsALoadInst() != nilValue {
 			// Load does not escape.
@@ -312,23 +421,91 @@ func (c *Compiler) doesEscape(value llvm.Value) bool {
 			// Store only escapes when the value is stored to, not when the
 			// value is stored into another value.
 			if use.Operand(0) == value {
-				return true
+				return "pointer is stored in memory"
 			}
 		} else if use.IsACallInst() != nilValue {
 			if !c.hasFlag(use, value, "nocapture") {
-				return true
+				fn := use.CalledValue()
+				if fn.IsAFunction().IsNil() {
+					return "pointer is passed to a function pointer or interface method"
+				}
+				return "pointer is passed to " + fn.Name()
 			}
 		} else if use.IsAICmpInst() != nilValue {
 			// Comparing pointers don't let the pointer escape.
 			// This is often a compiler-inserted nil check.
 		} else {
 			// Unknown instruction, might escape.
-			return true
+			switch use.InstructionOpcode() {
+			case llvm.Ret:
+				return "pointer is returned from the function"
+			case llvm.PHI, llvm.Select:
+				return "pointer is merged with another value"
+			case llvm.PtrToInt:
+				return "pointer is converted to an integer"
+			default:
+				return "pointer is used by an instruction the escape analysis doesn't understand"
+			}
 		}
 	}
 
 	// does not escape
-	return false
+	return ""
+}
+
+// printAllocs prints all remaining heap allocations in functions that match
+// the -print-allocs regular expression, together with the reason why they
+// couldn't be moved to the stack. It must be run after the last OptimizeAllocs
+// pass.
+func (c *Compiler) printAllocs() {
+	allocator := c.mod.NamedFunction("runtime.alloc")
+	if allocator.IsNil() {
+		return
+	}
+	type heapAlloc struct {
+		pos    token.Position
+		fn     string
+		reason string
+	}
+	var allocs []heapAlloc
+	for _, heapalloc := range getUses(allocator) {
+		if heapalloc.IsACallInst().IsNil() {
+			continue
+		}
+		fn := heapalloc.InstructionParent().Parent().Name()
+		if !c.PrintAllocs.MatchString(fn) {
+			continue
+		}
+		_, reason := c.heapAllocReason(heapalloc)
+		allocs = append(allocs, heapAlloc{
+			pos:    c.ir.Program.Fset.Position(c.allocPositions[heapalloc]),
+			fn:     fn,
+			reason: reason,
+		})
+	}
+	sort.SliceStable(allocs, func(i, j int) bool {
+		if allocs[i].pos.Filename != allocs[j].pos.Filename {
+			return allocs[i].pos.Filename < allocs[j].pos.Filename
+		}
+		return allocs[i].pos.Offset < allocs[j].pos.Offset
+	})
+	for _, alloc := range allocs {
+		location := alloc.fn
+		if alloc.pos.IsValid() {
+			location = alloc.pos.String()
+		}
+		fmt.Printf("%s: object allocated on the heap: %s\n", location, alloc.reason)
+	}
+}
+
+// readOnlyByteSliceFuncs are functions that keep a reference to a byte slice
+// passed to them, but never write to it. LLVM can't prove this as the slice is
+// stored in a struct, but these functions are used to read from embedded data
+// as an io.Reader:
+//     r := bytes.NewReader([]byte("..."))
+var readOnlyByteSliceFuncs = map[string]bool{
+	"bytes.NewReader":       true,
+	"(*bytes.Reader).Reset": true,
 }
 
 // Check whether the given value (which is of pointer type) is never stored to.
@@ -341,6 +518,9 @@ func (c *Compiler) isReadOnly(value llvm.Value) bool {
 				return false
 			}
 		} else if use.IsACallInst() != nilValue {
+			if readOnlyByteSliceFuncs[use.CalledValue().Name()] {
+				continue
+			}
 			if !c.hasFlag(use, value, "readonly") {
 				return false
 			}
@@ -0,0 +1,76 @@
+package compiler
+
+// This file implements -check=overflow, which makes signed integer arithmetic
+// panic on overflow instead of silently wrapping around. Go defines signed
+// overflow to wrap, so this is not enabled by default and only applies to
+// packages outside the standard library, which relies on wrapping in places
+// (for example in hash functions and random number generators).
+
+import (
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"tinygo.org/x/go-llvm"
+)
+
+// isOverflowChecked returns whether signed arithmetic in the function that is
+// being compiled must be checked for overflow.
+func (c *Compiler) isOverflowChecked(frame *Frame) bool {
+	if !c.OverflowCheck || frame.fn.Pkg == nil {
+		return false
+	}
+	// Standard library packages have no dot in the first path element.
+	path := frame.fn.Pkg.Pkg.Path()
+	return path == "main" || strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+}
+
+// createCheckedBinOp creates an addition, subtraction or multiplication of
+// signed integers that calls runtime.overflowPanic on overflow. It returns
+// false if the operation can't overflow or is not checked, in which case
+// parseBinOp must be used instead.
+func (c *Compiler) createCheckedBinOp(frame *Frame, op token.Token, typ types.Type, x, y llvm.Value) (llvm.Value, bool) {
+	basic, ok := typ.Underlying().(*types.Basic)
+	if !ok || basic.Info()&types.IsInteger == 0 || basic.Info()&types.IsUnsigned != 0 || !c.isOverflowChecked(frame) {
+		return llvm.Value{}, false
+	}
+	var intrinsic string
+	switch op {
+	case token.ADD:
+		intrinsic = "sadd"
+	case token.SUB:
+		intrinsic = "ssub"
+	case token.MUL:
+		intrinsic = "smul"
+	default:
+		return llvm.Value{}, false
+	}
+
+	// Use the LLVM intrinsic that returns the result and whether it
+	// overflowed, for example llvm.sadd.with.overflow.i32.
+	name := "llvm." + intrinsic + ".with.overflow.i" + strconv.Itoa(x.Type().IntTypeWidth())
+	fn := c.mod.NamedFunction(name)
+	if fn.IsNil() {
+		resultType := c.ctx.StructType([]llvm.Type{x.Type(), c.ctx.Int1Type()}, false)
+		fnType := llvm.FunctionType(resultType, []llvm.Type{x.Type(), x.Type()}, false)
+		fn = llvm.AddFunction(c.mod, name, fnType)
+	}
+	result := c.builder.CreateCall(fn, []llvm.Value{x, y}, "")
+	value := c.builder.CreateExtractValue(result, 0, "")
+	overflow := c.builder.CreateExtractValue(result, 1, "")
+
+	faultBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "overflow.panic")
+	nextBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "overflow.next")
+	frame.blockExits[frame.currentBlock] = nextBlock // adjust outgoing block for phi nodes
+	c.builder.CreateCondBr(overflow, faultBlock, nextBlock)
+
+	// Fail: the result doesn't fit in the type.
+	c.builder.SetInsertPointAtEnd(faultBlock)
+	c.createRuntimeCall("overflowPanic", []llvm.Value{c.createPanicLocation(frame, c.instrPos)}, "")
+	c.builder.CreateUnreachable()
+
+	// Ok: continue with the result.
+	c.builder.SetInsertPointAtEnd(nextBlock)
+	return value, true
+}
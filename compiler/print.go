@@ -0,0 +1,232 @@
+package compiler
+
+// This file implements the print and println builtins. Composite values are
+// printed like fmt.Print would print them (with %v), but the code to print
+// them is generated by the compiler so that no reflection is needed. To keep
+// the generated code small, only the first few elements of arrays, slices and
+// maps are printed and values nested too deeply are elided.
+
+import (
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"tinygo.org/x/go-llvm"
+)
+
+const (
+	printMaxElements = 16 // elements printed of an array, slice or map
+	printMaxDepth    = 3  // nesting depth of printed composite values
+)
+
+// createPrint emits code to print a single value of the given type.
+func (c *Compiler) createPrint(frame *Frame, value llvm.Value, typ types.Type, depth int, pos token.Pos) error {
+	switch typ := typ.Underlying().(type) {
+	case *types.Basic:
+		switch typ.Kind() {
+		case types.String, types.UntypedString:
+			c.createRuntimeCall("printstring", []llvm.Value{value}, "")
+		case types.Uintptr:
+			c.createRuntimeCall("printptr", []llvm.Value{value}, "")
+		case types.UnsafePointer:
+			ptrValue := c.builder.CreatePtrToInt(value, c.uintptrType, "")
+			c.createRuntimeCall("printptr", []llvm.Value{ptrValue}, "")
+		default:
+			// runtime.print{int,uint}{8,16,32,64}
+			if typ.Info()&types.IsInteger != 0 {
+				name := "print"
+				if typ.Info()&types.IsUnsigned != 0 {
+					name += "uint"
+				} else {
+					name += "int"
+				}
+				name += strconv.FormatUint(c.targetData.TypeAllocSize(value.Type())*8, 10)
+				c.createRuntimeCall(name, []llvm.Value{value}, "")
+			} else if typ.Kind() == types.Bool {
+				c.createRuntimeCall("printbool", []llvm.Value{value}, "")
+			} else if typ.Kind() == types.Float32 {
+				c.createRuntimeCall("printfloat32", []llvm.Value{value}, "")
+			} else if typ.Kind() == types.Float64 {
+				c.createRuntimeCall("printfloat64", []llvm.Value{value}, "")
+			} else if typ.Kind() == types.Complex64 {
+				c.createRuntimeCall("printcomplex64", []llvm.Value{value}, "")
+			} else if typ.Kind() == types.Complex128 {
+				c.createRuntimeCall("printcomplex128", []llvm.Value{value}, "")
+			} else {
+				return c.makeError(pos, "unknown basic arg type: "+typ.String())
+			}
+		}
+	case *types.Interface:
+		c.createRuntimeCall("printitf", []llvm.Value{value}, "")
+	case *types.Pointer, *types.Chan:
+		ptrValue := c.builder.CreatePtrToInt(value, c.uintptrType, "")
+		c.createRuntimeCall("printptr", []llvm.Value{ptrValue}, "")
+	case *types.Struct:
+		if depth >= printMaxDepth || typ.NumFields() > 1 && typ.Field(0).Name() == "C union" {
+			c.createPrintPunctuation("{...}")
+			return nil
+		}
+		c.createPrintPunctuation("{")
+		for i := 0; i < typ.NumFields(); i++ {
+			if i != 0 {
+				c.createRuntimeCall("printspace", nil, "")
+			}
+			field := c.builder.CreateExtractValue(value, i, "")
+			if err := c.createPrint(frame, field, typ.Field(i).Type(), depth+1, pos); err != nil {
+				return err
+			}
+		}
+		c.createPrintPunctuation("}")
+	case *types.Array:
+		if depth >= printMaxDepth {
+			c.createPrintPunctuation("[...]")
+			return nil
+		}
+		c.createPrintPunctuation("[")
+		for i := 0; i < int(typ.Len()); i++ {
+			if i != 0 {
+				c.createRuntimeCall("printspace", nil, "")
+			}
+			if i == printMaxElements {
+				c.createPrintPunctuation("...")
+				break
+			}
+			elem := c.builder.CreateExtractValue(value, i, "")
+			if err := c.createPrint(frame, elem, typ.Elem(), depth+1, pos); err != nil {
+				return err
+			}
+		}
+		c.createPrintPunctuation("]")
+	case *types.Slice:
+		if depth >= printMaxDepth {
+			c.createPrintPunctuation("[...]")
+			return nil
+		}
+		return c.createPrintSlice(frame, value, typ, depth, pos)
+	case *types.Map:
+		if depth >= printMaxDepth {
+			c.createPrintPunctuation("map[...]")
+			return nil
+		}
+		return c.createPrintMap(frame, value, typ, depth, pos)
+	default:
+		return c.makeError(pos, "unknown arg type: "+typ.String())
+	}
+	return nil
+}
+
+// createPrintPunctuation prints a short constant string, without creating a
+// string global.
+func (c *Compiler) createPrintPunctuation(s string) {
+	for i := 0; i < len(s); i++ {
+		c.createRuntimeCall("printbyte", []llvm.Value{llvm.ConstInt(c.ctx.Int8Type(), uint64(s[i]), false)}, "")
+	}
+}
+
+// createPrintSlice prints the elements of a slice, in a loop over at most
+// printMaxElements elements.
+func (c *Compiler) createPrintSlice(frame *Frame, value llvm.Value, typ *types.Slice, depth int, pos token.Pos) error {
+	ptr := c.builder.CreateExtractValue(value, 0, "print.ptr")
+	length := c.builder.CreateExtractValue(value, 1, "print.len")
+	max := llvm.ConstInt(c.uintptrType, printMaxElements, false)
+	truncated := c.builder.CreateICmp(llvm.IntUGT, length, max, "")
+	n := c.builder.CreateSelect(truncated, max, length, "print.n")
+
+	c.createPrintPunctuation("[")
+	prevBlock := c.builder.GetInsertBlock()
+	loopBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "print.loop")
+	bodyBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "print.body")
+	doneBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "print.done")
+	frame.blockExits[frame.currentBlock] = doneBlock // adjust outgoing block for phi nodes
+	c.builder.CreateBr(loopBlock)
+
+	c.builder.SetInsertPointAtEnd(loopBlock)
+	index := c.builder.CreatePHI(c.uintptrType, "print.index")
+	c.builder.CreateCondBr(c.builder.CreateICmp(llvm.IntULT, index, n, ""), bodyBlock, doneBlock)
+
+	c.builder.SetInsertPointAtEnd(bodyBlock)
+	c.createPrintSeparator(frame, index)
+	elemPtr := c.builder.CreateInBoundsGEP(ptr, []llvm.Value{index}, "")
+	if err := c.createPrint(frame, c.builder.CreateLoad(elemPtr, ""), typ.Elem(), depth+1, pos); err != nil {
+		return err
+	}
+	next := c.builder.CreateAdd(index, llvm.ConstInt(c.uintptrType, 1, false), "")
+	index.AddIncoming([]llvm.Value{llvm.ConstInt(c.uintptrType, 0, false), next}, []llvm.BasicBlock{prevBlock, c.builder.GetInsertBlock()})
+	c.builder.CreateBr(loopBlock)
+
+	c.builder.SetInsertPointAtEnd(doneBlock)
+	c.createPrintIf(frame, truncated, " ...") // not all elements were printed
+	c.createPrintPunctuation("]")
+	return nil
+}
+
+// createPrintMap prints the entries of a map as key:value pairs, like
+// fmt.Print does. At most printMaxElements entries are printed.
+func (c *Compiler) createPrintMap(frame *Frame, value llvm.Value, typ *types.Map, depth int, pos token.Pos) error {
+	llvmKeyType := c.getLLVMType(typ.Key())
+	llvmValueType := c.getLLVMType(typ.Elem())
+	iteratorType := c.getLLVMRuntimeType("hashmapIterator")
+	it, itPtr, itSize := c.createTemporaryAlloca(iteratorType, "print.it")
+	c.builder.CreateStore(c.getZeroValue(iteratorType), it)
+	mapKeyAlloca, mapKeyPtr, mapKeySize := c.createTemporaryAlloca(llvmKeyType, "print.key")
+	mapValueAlloca, mapValuePtr, mapValueSize := c.createTemporaryAlloca(llvmValueType, "print.value")
+
+	c.createPrintPunctuation("map[")
+	prevBlock := c.builder.GetInsertBlock()
+	loopBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "print.loop")
+	bodyBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "print.body")
+	doneBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "print.done")
+	frame.blockExits[frame.currentBlock] = doneBlock // adjust outgoing block for phi nodes
+	isNil := c.builder.CreateICmp(llvm.IntEQ, value, llvm.ConstNull(value.Type()), "")
+	c.builder.CreateCondBr(isNil, doneBlock, loopBlock)
+
+	c.builder.SetInsertPointAtEnd(loopBlock)
+	index := c.builder.CreatePHI(c.uintptrType, "print.index")
+	ok := c.createRuntimeCall("hashmapNext", []llvm.Value{value, it, mapKeyPtr, mapValuePtr}, "print.next")
+	// Stop at the end of the map, or when printMaxElements entries have been
+	// printed (with an ellipsis if there are more).
+	truncated := c.builder.CreateAnd(ok, c.builder.CreateICmp(llvm.IntEQ, index, llvm.ConstInt(c.uintptrType, printMaxElements, false), ""), "")
+	more := c.builder.CreateAnd(ok, c.builder.CreateNot(truncated, ""), "")
+	c.builder.CreateCondBr(more, bodyBlock, doneBlock)
+
+	c.builder.SetInsertPointAtEnd(bodyBlock)
+	c.createPrintSeparator(frame, index)
+	if err := c.createPrint(frame, c.builder.CreateLoad(mapKeyAlloca, ""), typ.Key(), depth+1, pos); err != nil {
+		return err
+	}
+	c.createPrintPunctuation(":")
+	if err := c.createPrint(frame, c.builder.CreateLoad(mapValueAlloca, ""), typ.Elem(), depth+1, pos); err != nil {
+		return err
+	}
+	next := c.builder.CreateAdd(index, llvm.ConstInt(c.uintptrType, 1, false), "")
+	index.AddIncoming([]llvm.Value{llvm.ConstInt(c.uintptrType, 0, false), next}, []llvm.BasicBlock{prevBlock, c.builder.GetInsertBlock()})
+	c.builder.CreateBr(loopBlock)
+
+	c.builder.SetInsertPointAtEnd(doneBlock)
+	truncatedPhi := c.builder.CreatePHI(truncated.Type(), "")
+	truncatedPhi.AddIncoming([]llvm.Value{llvm.ConstInt(truncated.Type(), 0, false), truncated}, []llvm.BasicBlock{prevBlock, loopBlock})
+	c.emitLifetimeEnd(itPtr, itSize)
+	c.emitLifetimeEnd(mapKeyPtr, mapKeySize)
+	c.emitLifetimeEnd(mapValuePtr, mapValueSize)
+	c.createPrintIf(frame, truncatedPhi, " ...") // not all elements were printed
+	c.createPrintPunctuation("]")
+	return nil
+}
+
+// createPrintSeparator prints a space before all but the first element.
+func (c *Compiler) createPrintSeparator(frame *Frame, index llvm.Value) {
+	notFirst := c.builder.CreateICmp(llvm.IntNE, index, llvm.ConstInt(c.uintptrType, 0, false), "")
+	c.createPrintIf(frame, notFirst, " ")
+}
+
+// createPrintIf prints a short constant string if the condition is true.
+func (c *Compiler) createPrintIf(frame *Frame, cond llvm.Value, s string) {
+	thenBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "print.if")
+	nextBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "print.next")
+	frame.blockExits[frame.currentBlock] = nextBlock // adjust outgoing block for phi nodes
+	c.builder.CreateCondBr(cond, thenBlock, nextBlock)
+	c.builder.SetInsertPointAtEnd(thenBlock)
+	c.createPrintPunctuation(s)
+	c.builder.CreateBr(nextBlock)
+	c.builder.SetInsertPointAtEnd(nextBlock)
+}
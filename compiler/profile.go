@@ -0,0 +1,68 @@
+package compiler
+
+// This file implements the instrumentation for the runtime/pprof package
+// (-pprof). Every function outside the runtime calls runtime.profileEnter at
+// the start and runtime.profileExit before it returns, which maintains a
+// shadow call stack. The runtime samples this stack for CPU profiles and on
+// heap allocations. This works the same on every target, including
+// WebAssembly, where the call stack can't be inspected otherwise.
+
+import (
+	"strings"
+
+	"github.com/tinygo-org/tinygo/ir"
+	"tinygo.org/x/go-llvm"
+)
+
+// isProfiled returns whether calls to the profiler should be inserted in the
+// given function.
+func (c *Compiler) isProfiled(f *ir.Function) bool {
+	if !c.Profile || f.Pkg == nil || c.isInterruptHandler(f) {
+		return false
+	}
+	// The runtime is not instrumented: the profiler itself is part of it,
+	// and its functions are called from places where the shadow stack must
+	// not change (like the scheduler and the garbage collector).
+	path := f.Pkg.Pkg.Path()
+	return path != "runtime" && !strings.HasPrefix(path, "runtime/")
+}
+
+// createProfileEnter inserts a call to runtime.profileEnter with a descriptor
+// of the function (a runtime.profileFunction), which holds the information
+// that is written to the profile.
+func (c *Compiler) createProfileEnter(f *ir.Function) {
+	pos := c.ir.Program.Fset.Position(f.Pos())
+	descriptorType := c.getLLVMRuntimeType("profileFunction")
+	descriptor := llvm.AddGlobal(c.mod, descriptorType, f.LinkName()+"$profile")
+	descriptor.SetInitializer(llvm.ConstNamedStruct(descriptorType, []llvm.Value{
+		c.createConstString(f.LinkName()+"$profile.name", f.RelString(nil)),
+		c.createConstString(f.LinkName()+"$profile.file", pos.Filename),
+		llvm.ConstInt(c.uintptrType, uint64(pos.Line), false),
+	}))
+	descriptor.SetLinkage(llvm.InternalLinkage)
+	descriptor.SetGlobalConstant(true)
+	c.createRuntimeCall("profileEnter", []llvm.Value{descriptor}, "")
+}
+
+// createConstString returns a constant Go string with the given contents,
+// stored in a new global with the given name. Strings with the same contents
+// share a global.
+func (c *Compiler) createConstString(name, s string) llvm.Value {
+	if str, ok := c.constStrings[s]; ok {
+		return str
+	}
+	global := llvm.AddGlobal(c.mod, llvm.ArrayType(c.ctx.Int8Type(), len(s)), name)
+	global.SetInitializer(c.ctx.ConstString(s, false))
+	global.SetLinkage(llvm.InternalLinkage)
+	global.SetGlobalConstant(true)
+	global.SetUnnamedAddr(true)
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	strPtr := llvm.ConstInBoundsGEP(global, []llvm.Value{zero, zero})
+	strLen := llvm.ConstInt(c.uintptrType, uint64(len(s)), false)
+	str := llvm.ConstNamedStruct(c.getLLVMRuntimeType("_string"), []llvm.Value{strPtr, strLen})
+	if c.constStrings == nil {
+		c.constStrings = map[string]llvm.Value{}
+	}
+	c.constStrings[s] = str
+	return str
+}
@@ -0,0 +1,179 @@
+package compiler
+
+// This file implements the -no-recursion check. Many embedded projects forbid
+// recursion, as it makes the stack usage of a program unbounded. This check
+// finds all cycles in the call graph (direct and mutual recursion) and reports
+// them as errors, except when one of the functions in the cycle is marked with
+// //go:recursive, for example because the recursion depth is known to be
+// small.
+//
+// Like the interrupt check, it works on the LLVM IR after the Go-specific
+// optimizations, so interface and func value calls that could be resolved are
+// part of the call graph. Calls through function pointers are not followed.
+
+import (
+	"go/token"
+	"strings"
+
+	"tinygo.org/x/go-llvm"
+)
+
+// checkRecursion returns an error for every recursion cycle in the program if
+// recursion is forbidden (-no-recursion).
+func (c *Compiler) checkRecursion() error {
+	if !c.NoRecursion {
+		return nil
+	}
+
+	// Functions that are allowed to recurse are left out of the call graph,
+	// which breaks all cycles through them.
+	allowed := map[llvm.Value]bool{}
+	positions := map[llvm.Value]token.Pos{}
+	for _, f := range c.ir.Functions {
+		if f.LLVMFn.IsNil() {
+			continue
+		}
+		positions[f.LLVMFn] = f.Pos()
+		if f.IsRecursive() {
+			allowed[f.LLVMFn] = true
+		}
+	}
+
+	// Find the strongly connected components of the call graph, using
+	// Tarjan's algorithm. Every component with more than one function, or
+	// with a function that calls itself, is a recursion cycle.
+	s := &recursionSearch{
+		allowed: allowed,
+		index:   map[llvm.Value]int{},
+		lowlink: map[llvm.Value]int{},
+		onStack: map[llvm.Value]bool{},
+	}
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if fn.IsDeclaration() || allowed[fn] {
+			continue
+		}
+		if _, ok := s.index[fn]; !ok {
+			s.visit(fn)
+		}
+	}
+
+	var errs []error
+	for _, component := range s.components {
+		cycle := s.cycle(component)
+		if cycle == nil {
+			continue // not recursive
+		}
+		names := make([]string, len(cycle))
+		for i, fn := range cycle {
+			names[i] = fn.Name()
+		}
+		names = append(names, cycle[0].Name())
+		msg := "recursion is not allowed (-no-recursion): " + strings.Join(names, " -> ")
+		errs = append(errs, c.makeError(positions[cycle[0]], msg))
+	}
+	if len(errs) != 0 {
+		return &MultiError{errs}
+	}
+	return nil
+}
+
+// recursionSearch is the state of Tarjan's strongly connected components
+// algorithm.
+type recursionSearch struct {
+	allowed    map[llvm.Value]bool
+	index      map[llvm.Value]int
+	lowlink    map[llvm.Value]int
+	onStack    map[llvm.Value]bool
+	stack      []llvm.Value
+	components [][]llvm.Value
+}
+
+func (s *recursionSearch) visit(fn llvm.Value) {
+	s.index[fn] = len(s.index)
+	s.lowlink[fn] = s.index[fn]
+	s.stack = append(s.stack, fn)
+	s.onStack[fn] = true
+	for _, callee := range s.callees(fn) {
+		if _, ok := s.index[callee]; !ok {
+			s.visit(callee)
+			if s.lowlink[callee] < s.lowlink[fn] {
+				s.lowlink[fn] = s.lowlink[callee]
+			}
+		} else if s.onStack[callee] && s.index[callee] < s.lowlink[fn] {
+			s.lowlink[fn] = s.index[callee]
+		}
+	}
+	if s.lowlink[fn] != s.index[fn] {
+		return // part of a component that is not complete yet
+	}
+	var component []llvm.Value
+	for {
+		top := s.stack[len(s.stack)-1]
+		s.stack = s.stack[:len(s.stack)-1]
+		s.onStack[top] = false
+		component = append(component, top)
+		if top == fn {
+			break
+		}
+	}
+	s.components = append(s.components, component)
+}
+
+// callees returns the functions that are called directly from fn, in the
+// order in which the calls appear.
+func (s *recursionSearch) callees(fn llvm.Value) []llvm.Value {
+	var callees []llvm.Value
+	seen := map[llvm.Value]bool{}
+	for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+		for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+			if inst.IsACallInst().IsNil() {
+				continue
+			}
+			callee := inst.CalledValue()
+			if callee.IsAFunction().IsNil() || callee.IsDeclaration() || s.allowed[callee] || seen[callee] {
+				continue
+			}
+			seen[callee] = true
+			callees = append(callees, callee)
+		}
+	}
+	return callees
+}
+
+// cycle returns a shortest call cycle through the functions of a strongly
+// connected component, starting at the function that was found first, or nil
+// if the component is a single function that doesn't call itself.
+func (s *recursionSearch) cycle(component []llvm.Value) []llvm.Value {
+	start := component[len(component)-1]
+	inComponent := map[llvm.Value]bool{}
+	for _, fn := range component {
+		inComponent[fn] = true
+	}
+
+	// Breadth-first search from the start function back to itself.
+	parents := map[llvm.Value]llvm.Value{}
+	worklist := []llvm.Value{start}
+	for len(worklist) != 0 {
+		fn := worklist[0]
+		worklist = worklist[1:]
+		for _, callee := range s.callees(fn) {
+			if callee == start {
+				var cycle []llvm.Value
+				for ; fn != start; fn = parents[fn] {
+					cycle = append(cycle, fn)
+				}
+				cycle = append(cycle, start)
+				for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+					cycle[i], cycle[j] = cycle[j], cycle[i]
+				}
+				return cycle
+			}
+			if _, ok := parents[callee]; ok || !inComponent[callee] {
+				continue
+			}
+			parents[callee] = fn
+			worklist = append(worklist, callee)
+		}
+	}
+	return nil
+}
@@ -1,8 +1,17 @@
 package compiler
 
+// This file assigns type codes the way the reflect package expects them and
+// creates the sidetables with extra type information (struct fields, array
+// lengths, map keys, method sets, etc.) that don't fit in a type code.
+// See src/reflect/type.go and src/reflect/sidetables.go for the runtime side.
+
 import (
+	"go/ast"
 	"math/big"
+	"sort"
 	"strings"
+
+	"tinygo.org/x/go-llvm"
 )
 
 var basicTypes = map[string]int64{
@@ -26,6 +35,43 @@ var basicTypes = map[string]int64{
 	"unsafeptr":  18,
 }
 
+// typeCodeAssignmentState keeps some state around for assigning type codes.
+type typeCodeAssignmentState struct {
+	// Map of named basic types to their number, which is stored in the upper
+	// bits of the type code. The name of named basic type n is stored at index
+	// n-1 of namedBasicTypeNamesSidetable, as an offset in namesSidetable.
+	namedBasicTypes              map[string]int
+	namedBasicTypeNamesSidetable []uint64
+
+	// Map of named non-basic types to their index in
+	// namedNonBasicTypesSidetable, which stores the type code of the underlying
+	// type, and namedNonBasicTypeNamesSidetable, which stores the offset of the
+	// type name in namesSidetable.
+	namedNonBasicTypes              map[string]int
+	namedNonBasicTypesSidetable     []uint64
+	namedNonBasicTypeNamesSidetable []uint64
+
+	// Maps of array, struct, func, map and interface types to their offset in
+	// the respective sidetable. The sidetables are byte arrays with
+	// varint-encoded entries.
+	arrayTypes              map[string]int
+	arrayTypesSidetable     []byte
+	structTypes             map[string]int
+	structTypesSidetable    []byte
+	funcTypes               map[string]int
+	funcTypesSidetable      []byte
+	mapTypes                map[string]int
+	mapTypesSidetable       []byte
+	interfaceTypes          map[string]int
+	interfaceTypesSidetable []byte
+
+	// Map of struct field names, struct tags, method names and type names to
+	// their offset in namesSidetable. Each name is stored as a varint length followed by
+	// the string itself.
+	names          map[string]int
+	namesSidetable []byte
+}
+
 func (c *Compiler) assignTypeCodes(typeSlice typeInfoSlice) {
 	fn := c.mod.NamedFunction("reflect.ValueOf")
 	if fn.IsNil() {
@@ -34,39 +80,101 @@ func (c *Compiler) assignTypeCodes(typeSlice typeInfoSlice) {
 		for i, t := range typeSlice {
 			t.num = uint64(i + 1)
 		}
+		c.createReflectCallMethod(nil)
 		return
 	}
 
 	// Assign typecodes the way the reflect package expects.
-	fallbackIndex := 1
-	namedTypes := make(map[string]int)
+	state := &typeCodeAssignmentState{
+		namedBasicTypes:    make(map[string]int),
+		namedNonBasicTypes: make(map[string]int),
+		arrayTypes:         make(map[string]int),
+		structTypes:        make(map[string]int),
+		funcTypes:          make(map[string]int),
+		mapTypes:           make(map[string]int),
+		interfaceTypes:     make(map[string]int),
+		names:              make(map[string]int),
+	}
 	for _, t := range typeSlice {
 		if t.name[:5] != "type:" {
 			panic("expected type name to start with 'type:'")
 		}
-		num := c.getTypeCodeNum(t.name[5:], &fallbackIndex, namedTypes)
-		if num.BitLen() > c.uintptrType.IntTypeWidth() || !num.IsUint64() {
-			// TODO: support this in some way, using a side table for example.
-			// That's less efficient but better than not working at all.
-			// Particularly important on systems with 16-bit pointers (e.g.
-			// AVR).
-			panic("compiler: could not store type code number inside interface type code")
+		t.num = c.getTypeCodeNum(t.typecode, state)
+	}
+
+	// Describe the exported methods of all types that have them. Every entry
+	// is the type code followed by the number of methods, and for each method
+	// the name, the method type and the index to pass to reflect.callMethod.
+	// The list ends with a zero type code.
+	var methodSetsSidetable []byte
+	var thunks []llvm.Value
+	sortedTypes := make(typeInfoSlice, len(typeSlice))
+	copy(sortedTypes, typeSlice)
+	sort.Slice(sortedTypes, func(i, j int) bool {
+		return sortedTypes[i].name < sortedTypes[j].name
+	})
+	for _, t := range sortedTypes {
+		if t.reflectMethods.IsNil() {
+			continue
+		}
+		methods := t.reflectMethods.Initializer()
+		numMethods := methods.Type().ArrayLength()
+		methodSetsSidetable = appendVarint(methodSetsSidetable, t.num)
+		methodSetsSidetable = appendVarint(methodSetsSidetable, uint64(numMethods))
+		for i := 0; i < numMethods; i++ {
+			method := llvm.ConstExtractValue(methods, []uint32{uint32(i)})
+			name := llvm.ConstExtractValue(method, []uint32{0}).Name()
+			methodType := llvm.ConstExtractValue(method, []uint32{1})
+			thunk := llvm.ConstExtractValue(method, []uint32{2}).Operand(0)
+			methodSetsSidetable = appendVarint(methodSetsSidetable, uint64(state.getNameIndex(name[len("reflect/names:"):])))
+			methodSetsSidetable = appendVarint(methodSetsSidetable, c.getTypeCodeNum(methodType, state))
+			methodSetsSidetable = appendVarint(methodSetsSidetable, uint64(len(thunks)))
+			thunks = append(thunks, thunk)
 		}
-		t.num = num.Uint64()
 	}
+	methodSetsSidetable = appendVarint(methodSetsSidetable, 0)
+	c.createReflectCallMethod(thunks)
+
+	// Replace the placeholder sidetables in the reflect package with the real
+	// ones.
+	c.replaceSidetable("reflect.namedBasicTypeNamesSidetable", c.makeUintptrArray(state.namedBasicTypeNamesSidetable))
+	c.replaceSidetable("reflect.namedNonBasicTypesSidetable", c.makeUintptrArray(state.namedNonBasicTypesSidetable))
+	c.replaceSidetable("reflect.namedNonBasicTypeNamesSidetable", c.makeUintptrArray(state.namedNonBasicTypeNamesSidetable))
+	c.replaceSidetable("reflect.arrayTypesSidetable", c.ctx.ConstString(string(state.arrayTypesSidetable), false))
+	c.replaceSidetable("reflect.structTypesSidetable", c.ctx.ConstString(string(state.structTypesSidetable), false))
+	c.replaceSidetable("reflect.funcTypesSidetable", c.ctx.ConstString(string(state.funcTypesSidetable), false))
+	c.replaceSidetable("reflect.mapTypesSidetable", c.ctx.ConstString(string(state.mapTypesSidetable), false))
+	c.replaceSidetable("reflect.interfaceTypesSidetable", c.ctx.ConstString(string(state.interfaceTypesSidetable), false))
+	c.replaceSidetable("reflect.methodSetsSidetable", c.ctx.ConstString(string(methodSetsSidetable), false))
+	c.replaceSidetable("reflect.namesSidetable", c.ctx.ConstString(string(state.namesSidetable), false))
 }
 
 // getTypeCodeNum returns the typecode for a given type as expected by the
-// reflect package. Also see getTypeCodeName, which serializes types to a string
-// based on a types.Type value for this function.
-func (c *Compiler) getTypeCodeNum(id string, fallbackIndex *int, namedTypes map[string]int) *big.Int {
+// reflect package. The type is identified by its typecode global, see
+// getTypeCode and getTypeCodeName.
+func (c *Compiler) getTypeCodeNum(typecode llvm.Value, state *typeCodeAssignmentState) uint64 {
+	num := c.getTypeCodeNumBig(typecode, state)
+	if num.BitLen() > c.uintptrType.IntTypeWidth() || !num.IsUint64() {
+		// TODO: support this in some way, using a side table for example.
+		// That's less efficient but better than not working at all.
+		// Particularly important on systems with 16-bit pointers (e.g.
+		// AVR).
+		panic("compiler: could not store type code number inside interface type code")
+	}
+	return num.Uint64()
+}
+
+// getTypeCodeNumBig is like getTypeCodeNum, but it returns the type code as a
+// big integer without checking whether it fits in a uintptr.
+func (c *Compiler) getTypeCodeNumBig(typecode llvm.Value, state *typeCodeAssignmentState) *big.Int {
 	// Note: see src/reflect/type.go for bit allocations.
 	// A type can be named or unnamed. Example of both:
 	//     basic:~foo:uint64
 	//     basic:uint64
 	// Extract the class (basic, slice, pointer, etc.), the name, and the
 	// contents of this type ID string. Allocate bits based on that, as
-	// src/runtime/types.go expects.
+	// src/reflect/type.go expects.
+	id := typecode.Name()[len("type:"):]
 	class := id[:strings.IndexByte(id, ':')]
 	value := id[len(class)+1:]
 	name := ""
@@ -74,6 +182,16 @@ func (c *Compiler) getTypeCodeNum(id string, fallbackIndex *int, namedTypes map[
 		name = value[1:strings.IndexByte(value, ':')]
 		value = value[len(name)+2:]
 	}
+
+	// Most types store more information in the typecode global, see
+	// runtime.typecodeID.
+	var references llvm.Value
+	var length uint64
+	if initializer := typecode.Initializer(); !initializer.IsNil() {
+		references = llvm.ConstExtractValue(initializer, []uint32{0})
+		length = llvm.ConstExtractValue(initializer, []uint32{1}).ZExtValue()
+	}
+
 	if class == "basic" {
 		// Basic types follow the following bit pattern:
 		//    ...xxxxx0
@@ -85,61 +203,269 @@ func (c *Compiler) getTypeCodeNum(id string, fallbackIndex *int, namedTypes map[
 		}
 		if name != "" {
 			// This type is named, set the upper bits to the name ID.
-			num |= int64(getNamedTypeNum(namedTypes, name)) << 5
+			namedNum := getNamedTypeNum(state.namedBasicTypes, name)
+			if namedNum > len(state.namedBasicTypeNamesSidetable) {
+				state.namedBasicTypeNamesSidetable = append(state.namedBasicTypeNamesSidetable, uint64(state.getNameIndex(name)))
+			}
+			num |= int64(namedNum) << 5
 		}
 		return big.NewInt(num << 1)
-	} else {
-		// Complex types use the following bit pattern:
-		//    ...nxxx1
-		// where xxx indicates the complex type (any non-basic type). The upper
-		// bits contain whatever the type contains. Types that wrap a single
-		// other type (channel, interface, pointer, slice) just contain the bits
-		// of the wrapped type. Other types (like struct) have a different
-		// method of encoding the contents of the type.
-		var num *big.Int
-		var classNumber int64
-		switch class {
-		case "chan":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 0
-		case "interface":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 1
-		case "pointer":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 2
-		case "slice":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 3
-		case "array":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 4
-		case "func":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 5
-		case "map":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 6
-		case "struct":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 7
-		default:
-			panic("unknown type kind: " + id)
-		}
-		if name == "" {
-			num.Lsh(num, 5).Or(num, big.NewInt((classNumber<<1)+1))
-		} else {
-			// TODO: store num in a sidetable
-			num = big.NewInt(int64(getNamedTypeNum(namedTypes, name))<<1 | 1)
-			num.Lsh(num, 4).Or(num, big.NewInt((classNumber<<1)+1))
+	}
+
+	// Complex types use the following bit pattern:
+	//    ...nxxx1
+	// where xxx indicates the complex type (any non-basic type). The upper
+	// bits contain whatever the type contains. Types that wrap a single
+	// other type (channel, pointer, slice) just contain the bits of the
+	// wrapped type. Arrays, structs, funcs, maps and interfaces contain an
+	// offset into their sidetable. Named types contain an index into
+	// namedNonBasicTypesSidetable, which contains the underlying type.
+	var classNumber int64
+	switch class {
+	case "chan":
+		classNumber = 0
+	case "interface":
+		classNumber = 1
+	case "pointer":
+		classNumber = 2
+	case "slice":
+		classNumber = 3
+	case "array":
+		classNumber = 4
+	case "func":
+		classNumber = 5
+	case "map":
+		classNumber = 6
+	case "struct":
+		classNumber = 7
+	default:
+		panic("unknown type kind: " + id)
+	}
+
+	var num *big.Int
+	if name != "" {
+		// Named type. Reserve the index before looking at the underlying
+		// type, as the underlying type may refer back to this named type.
+		index, ok := state.namedNonBasicTypes[id]
+		if !ok {
+			index = len(state.namedNonBasicTypesSidetable)
+			state.namedNonBasicTypes[id] = index
+			state.namedNonBasicTypesSidetable = append(state.namedNonBasicTypesSidetable, 0)
+			state.namedNonBasicTypeNamesSidetable = append(state.namedNonBasicTypeNamesSidetable, uint64(state.getNameIndex(name)))
+			state.namedNonBasicTypesSidetable[index] = c.getTypeCodeNum(references, state)
 		}
+		num = big.NewInt(int64(index)<<1 | 1)
+		num.Lsh(num, 4).Or(num, big.NewInt((classNumber<<1)+1))
 		return num
 	}
+
+	switch class {
+	case "chan", "pointer", "slice":
+		num = c.getTypeCodeNumBig(references, state)
+	case "array":
+		offset, ok := state.arrayTypes[id]
+		if !ok {
+			elem := c.getTypeCodeNum(references, state)
+			offset = len(state.arrayTypesSidetable)
+			state.arrayTypes[id] = offset
+			state.arrayTypesSidetable = appendVarint(state.arrayTypesSidetable, elem)
+			state.arrayTypesSidetable = appendVarint(state.arrayTypesSidetable, length)
+		}
+		num = big.NewInt(int64(offset))
+	case "struct":
+		offset, ok := state.structTypes[id]
+		if !ok {
+			offset = c.addStructType(references.Operand(0), length, state)
+			state.structTypes[id] = offset
+		}
+		num = big.NewInt(int64(offset))
+	case "func":
+		offset, ok := state.funcTypes[id]
+		if !ok {
+			offset = c.addFuncType(references.Operand(0), length, state)
+			state.funcTypes[id] = offset
+		}
+		num = big.NewInt(int64(offset))
+	case "map":
+		offset, ok := state.mapTypes[id]
+		if !ok {
+			elems := references.Operand(0).Initializer()
+			key := c.getTypeCodeNum(llvm.ConstExtractValue(elems, []uint32{0}), state)
+			elem := c.getTypeCodeNum(llvm.ConstExtractValue(elems, []uint32{1}), state)
+			offset = len(state.mapTypesSidetable)
+			state.mapTypes[id] = offset
+			state.mapTypesSidetable = appendVarint(state.mapTypesSidetable, key)
+			state.mapTypesSidetable = appendVarint(state.mapTypesSidetable, elem)
+		}
+		num = big.NewInt(int64(offset))
+	case "interface":
+		offset, ok := state.interfaceTypes[id]
+		if !ok {
+			offset = c.addInterfaceType(references, length, state)
+			state.interfaceTypes[id] = offset
+		}
+		num = big.NewInt(int64(offset))
+	}
+	num.Lsh(num, 5).Or(num, big.NewInt((classNumber<<1)+1))
+	return num
+}
+
+// addStructType adds a struct to the struct sidetable and returns its offset.
+// Every struct starts with its size and number of fields. Every field consists
+// of a flags byte (1: embedded, 2: has a tag, 4: exported), its type, its
+// offset, its name and optionally its tag.
+func (c *Compiler) addStructType(fieldsGlobal llvm.Value, size uint64, state *typeCodeAssignmentState) int {
+	fields := fieldsGlobal.Initializer()
+	numFields := fieldsGlobal.Type().ElementType().ArrayLength()
+
+	// First determine the contents, as field types may also be added to the
+	// sidetable.
+	var buf []byte
+	buf = appendVarint(buf, size)
+	buf = appendVarint(buf, uint64(numFields))
+	for i := 0; i < numFields; i++ {
+		field := llvm.ConstExtractValue(fields, []uint32{uint32(i)})
+		fieldType := llvm.ConstExtractValue(field, []uint32{0})
+		name := llvm.ConstExtractValue(field, []uint32{1}).Name()[len("reflect/names:"):]
+		tag := llvm.ConstExtractValue(field, []uint32{2})
+		offset := llvm.ConstExtractValue(field, []uint32{3}).ZExtValue()
+		embedded := llvm.ConstExtractValue(field, []uint32{4}).ZExtValue() != 0
+
+		var flags byte
+		if embedded {
+			flags |= 1
+		}
+		if !tag.IsNull() {
+			flags |= 2
+		}
+		if ast.IsExported(name) {
+			flags |= 4
+		}
+		buf = append(buf, flags)
+		buf = appendVarint(buf, c.getTypeCodeNum(fieldType, state))
+		buf = appendVarint(buf, offset)
+		buf = appendVarint(buf, uint64(state.getNameIndex(name)))
+		if !tag.IsNull() {
+			buf = appendVarint(buf, uint64(state.getNameIndex(tag.Name()[len("reflect/names:"):])))
+		}
+	}
+	offset := len(state.structTypesSidetable)
+	state.structTypesSidetable = append(state.structTypesSidetable, buf...)
+	return offset
+}
+
+// addFuncType adds a signature to the func sidetable and returns its offset.
+// Every signature consists of the number of parameters and results, followed
+// by their types.
+func (c *Compiler) addFuncType(paramsGlobal llvm.Value, numParams uint64, state *typeCodeAssignmentState) int {
+	params := paramsGlobal.Initializer()
+	numTypes := paramsGlobal.Type().ElementType().ArrayLength()
+	var buf []byte
+	buf = appendVarint(buf, numParams)
+	buf = appendVarint(buf, uint64(numTypes)-numParams)
+	for i := 0; i < numTypes; i++ {
+		param := llvm.ConstExtractValue(params, []uint32{uint32(i)})
+		buf = appendVarint(buf, c.getTypeCodeNum(param, state))
+	}
+	offset := len(state.funcTypesSidetable)
+	state.funcTypesSidetable = append(state.funcTypesSidetable, buf...)
+	return offset
+}
+
+// addInterfaceType adds an interface to the interface sidetable and returns its
+// offset. Every interface consists of the number of methods, followed by the
+// name and type of each method. The references value is null for interfaces
+// without methods.
+func (c *Compiler) addInterfaceType(references llvm.Value, numMethods uint64, state *typeCodeAssignmentState) int {
+	var buf []byte
+	buf = appendVarint(buf, numMethods)
+	if numMethods != 0 {
+		methods := references.Operand(0).Initializer()
+		for i := 0; i < int(numMethods); i++ {
+			method := llvm.ConstExtractValue(methods, []uint32{uint32(i)})
+			name := llvm.ConstExtractValue(method, []uint32{0}).Name()[len("reflect/names:"):]
+			methodType := llvm.ConstExtractValue(method, []uint32{1})
+			buf = appendVarint(buf, uint64(state.getNameIndex(name)))
+			buf = appendVarint(buf, c.getTypeCodeNum(methodType, state))
+		}
+	}
+	offset := len(state.interfaceTypesSidetable)
+	state.interfaceTypesSidetable = append(state.interfaceTypesSidetable, buf...)
+	return offset
+}
+
+// getNameIndex returns the offset of the given name in the names sidetable,
+// adding it if necessary.
+func (state *typeCodeAssignmentState) getNameIndex(name string) int {
+	if index, ok := state.names[name]; ok {
+		return index
+	}
+	index := len(state.namesSidetable)
+	state.names[name] = index
+	state.namesSidetable = appendVarint(state.namesSidetable, uint64(len(name)))
+	state.namesSidetable = append(state.namesSidetable, name...)
+	return index
+}
+
+// replaceSidetable replaces a placeholder global in the reflect package with a
+// new global with the given contents. Nothing happens when the placeholder
+// isn't used.
+func (c *Compiler) replaceSidetable(name string, value llvm.Value) {
+	global := c.mod.NamedGlobal(name)
+	if global.IsNil() {
+		return
+	}
+	sidetable := llvm.AddGlobal(c.mod, value.Type(), name+"$sidetable")
+	sidetable.SetInitializer(value)
+	sidetable.SetGlobalConstant(true)
+	sidetable.SetLinkage(llvm.InternalLinkage)
+	global.ReplaceAllUsesWith(llvm.ConstBitCast(sidetable, global.Type()))
+	global.EraseFromParentAsGlobal()
+}
+
+// makeUintptrArray returns a constant uintptr array with the given values.
+func (c *Compiler) makeUintptrArray(values []uint64) llvm.Value {
+	elements := make([]llvm.Value, len(values))
+	for i, value := range values {
+		elements[i] = llvm.ConstInt(c.uintptrType, value, false)
+	}
+	return llvm.ConstArray(c.uintptrType, elements)
+}
+
+// createReflectCallMethod defines reflect.callMethod, which calls the method
+// thunk with the given index. It is implemented as a big switch over all
+// methods that can be called using reflection.
+func (c *Compiler) createReflectCallMethod(thunks []llvm.Value) {
+	fn := c.mod.NamedFunction("reflect.callMethod")
+	if fn.IsNil() {
+		// Methods are never called using reflection.
+		return
+	}
+	fn.SetLinkage(llvm.InternalLinkage)
+	fn.SetUnnamedAddr(true)
+
+	// TODO: debug info
+
+	// Create entry block.
+	entry := llvm.AddBasicBlock(fn, "entry")
+
+	// Create default block and make it unreachable (which it is, because the
+	// reflect package only passes valid indices).
+	defaultBlock := llvm.AddBasicBlock(fn, "default")
+	c.builder.SetInsertPointAtEnd(defaultBlock)
+	c.builder.CreateUnreachable()
+
+	// Call the thunk of the given method.
+	c.builder.SetInsertPointAtEnd(entry)
+	sw := c.builder.CreateSwitch(fn.Param(0), defaultBlock, len(thunks))
+	params := []llvm.Value{fn.Param(1), fn.Param(2), fn.Param(3), llvm.Undef(c.i8ptrType), llvm.Undef(c.i8ptrType)}
+	for i, thunk := range thunks {
+		bb := llvm.AddBasicBlock(fn, thunk.Name())
+		sw.AddCase(llvm.ConstInt(c.uintptrType, uint64(i), false), bb)
+		c.builder.SetInsertPointAtEnd(bb)
+		c.builder.CreateCall(thunk, params, "")
+		c.builder.CreateRetVoid()
+	}
 }
 
 // getNamedTypeNum returns an appropriate (unique) number for the given named
@@ -154,3 +480,14 @@ func getNamedTypeNum(namedTypes map[string]int, name string) int {
 		return num
 	}
 }
+
+// appendVarint appends an unsigned varint as read by readVarint in the reflect
+// package: 7 bits per byte, least significant bits first, with the high bit
+// set on all bytes except the last.
+func appendVarint(buf []byte, n uint64) []byte {
+	for n >= 0x80 {
+		buf = append(buf, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(buf, byte(n))
+}
@@ -0,0 +1,93 @@
+package compiler
+
+// This file determines how the runtime is configured for a given build. The
+// configuration is derived in one place and exposed to the runtime in two
+// ways: as build tags (to select between implementations that cannot coexist
+// in one package, such as different garbage collectors) and as constants in
+// the generated runtime/internal/config package (for everything else).
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Import path of the generated runtime configuration package.
+const runtimeConfigPath = "runtime/internal/config"
+
+// runtimeConfig is the configuration of the runtime for a single build.
+type runtimeConfig struct {
+	GC             string // garbage collector: "conservative", "leaking", "none", or "custom"
+	Scheduler      string // goroutine scheduler: currently only "coroutines"
+	AsyncScheduler bool   // the scheduler returns to the host when it has to wait
+	StackObjects   bool   // the compiler tracks pointers on the stack and in globals for the GC
+	PanicTrace     bool   // panics print a call trace, using frame pointers
+	Profile        bool   // functions call the profiler, for runtime/pprof
+
+	// Memory for the heap besides the main heap, as start and end addresses
+	// and the minimum size of objects that prefer the region.
+	ExtraHeapRegions [][3]uint64
+}
+
+// runtimeConfig returns the runtime configuration for the current build.
+func (c *Compiler) runtimeConfig() runtimeConfig {
+	config := runtimeConfig{
+		GC:             c.selectGC(),
+		Scheduler:      "coroutines",
+		AsyncScheduler: c.GOARCH == "wasm",
+		PanicTrace:     c.PanicTrace,
+		Profile:        c.Profile,
+	}
+	for _, region := range c.HeapRegions {
+		config.ExtraHeapRegions = append(config.ExtraHeapRegions, [3]uint64{region.Start, region.Start + region.Size, region.MinObject})
+	}
+	if config.GC == "conservative" || config.GC == "custom" {
+		// The stack can only be scanned directly on targets where the stack
+		// top and the stack pointer are known. Other targets need help from
+		// the compiler to find pointers on the stack.
+		config.StackObjects = true
+		for _, tag := range c.BuildTags {
+			if tag == "cortexm" || tag == "tinygo.riscv" {
+				config.StackObjects = false
+			}
+		}
+	}
+	return config
+}
+
+// buildTags returns the build tags that select the runtime implementation for
+// this configuration.
+func (rc runtimeConfig) buildTags() []string {
+	tags := []string{"tinygo", "gc." + rc.GC, "scheduler." + rc.Scheduler}
+	if rc.StackObjects {
+		tags = append(tags, "gc.stackobjects")
+	}
+	return tags
+}
+
+// source returns the Go source code of the runtime/internal/config package.
+func (rc runtimeConfig) source() []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "// Code generated by the TinyGo compiler. DO NOT EDIT.")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "// Package config contains the configuration of the runtime for this build.")
+	fmt.Fprintln(buf, "package config")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "const (")
+	fmt.Fprintf(buf, "\tGC             = %q\n", rc.GC)
+	fmt.Fprintf(buf, "\tScheduler      = %q\n", rc.Scheduler)
+	fmt.Fprintf(buf, "\tAsyncScheduler = %v\n", rc.AsyncScheduler)
+	fmt.Fprintf(buf, "\tStackObjects   = %v\n", rc.StackObjects)
+	fmt.Fprintf(buf, "\tPanicTrace     = %v\n", rc.PanicTrace)
+	fmt.Fprintf(buf, "\tProfile        = %v\n", rc.Profile)
+	fmt.Fprintln(buf, ")")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "// ExtraHeapRegions are the start and end addresses of memory that is used")
+	fmt.Fprintln(buf, "// for the heap besides the main heap, and the minimum size of objects that")
+	fmt.Fprintln(buf, "// are preferably allocated in it.")
+	fmt.Fprintln(buf, "var ExtraHeapRegions = [...][3]uintptr{")
+	for _, region := range rc.ExtraHeapRegions {
+		fmt.Fprintf(buf, "\t{%#x, %#x, %#x},\n", region[0], region[1], region[2])
+	}
+	fmt.Fprintln(buf, "}")
+	return buf.Bytes()
+}
@@ -0,0 +1,106 @@
+package compiler
+
+// This file implements the -scrub flag, for production firmware that should
+// not reveal more about its source than necessary (and should be small):
+//
+//   * panics with a constant message call runtime.panicCode with a number
+//     instead, so that the message strings are not part of the binary,
+//   * internal symbols are renamed to short meaningless names.
+//
+// Both can be reversed with the map file that is written at the end of the
+// build. Source file paths are removed by building without debug
+// information, which the -scrub flag implies.
+
+import (
+	"bytes"
+	"fmt"
+	"go/constant"
+	"go/token"
+	"io/ioutil"
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+	"tinygo.org/x/go-llvm"
+)
+
+// scrubbedPanic is a panic call site of which the message was replaced with a
+// code.
+type scrubbedPanic struct {
+	code uint32
+	pos  token.Pos
+	msg  string // message as it would have been printed
+}
+
+// createPanicCode creates a call to runtime.panicCode if message strings are
+// removed (-scrub) and the panic value is a constant string. The prefix is
+// printed before the message by the original panic function. It returns the
+// call, or a nil value if the panic must be done as usual.
+func (c *Compiler) createPanicCode(value ssa.Value, prefix string, pos token.Pos) llvm.Value {
+	if c.ScrubMap == "" {
+		return llvm.Value{}
+	}
+	if itf, ok := value.(*ssa.MakeInterface); ok {
+		value = itf.X
+	}
+	msg, ok := value.(*ssa.Const)
+	if !ok || msg.Value == nil || msg.Value.Kind() != constant.String {
+		return llvm.Value{}
+	}
+	code := uint32(len(c.scrubbedPanics) + 1)
+	c.scrubbedPanics = append(c.scrubbedPanics, scrubbedPanic{
+		code: code,
+		pos:  pos,
+		msg:  "panic: " + prefix + constant.StringVal(msg.Value),
+	})
+	return c.createRuntimeCall("panicCode", []llvm.Value{llvm.ConstInt(c.ctx.Int32Type(), uint64(code), false)}, "")
+}
+
+// scrubSymbols renames all functions and globals with internal linkage, which
+// can't be referenced from outside the module, and writes the map file.
+func (c *Compiler) scrubSymbols() error {
+	if c.ScrubMap == "" {
+		return nil
+	}
+	symbols := map[string]string{}
+	rename := func(value llvm.Value) {
+		name := value.Name()
+		switch value.Linkage() {
+		case llvm.InternalLinkage, llvm.PrivateLinkage:
+		default:
+			return
+		}
+		if name == "" {
+			return
+		}
+		// LLVM makes the name unique if it is already in use.
+		value.SetName(fmt.Sprintf("_s%d", len(symbols)+1))
+		symbols[value.Name()] = name
+	}
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if !fn.IsDeclaration() {
+			rename(fn)
+		}
+	}
+	for global := c.mod.FirstGlobal(); !global.IsNil(); global = llvm.NextGlobal(global) {
+		rename(global)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "# panic codes (printed as \"panic: #<code>\")")
+	for _, p := range c.scrubbedPanics {
+		fmt.Fprintf(buf, "#%d\t%s\t%s\n", p.code, c.ir.Program.Fset.Position(p.pos), p.msg)
+	}
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "# symbols")
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) < len(names[j]) || len(names[i]) == len(names[j]) && names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Fprintf(buf, "%s\t%s\n", name, symbols[name])
+	}
+	return ioutil.WriteFile(c.ScrubMap, buf.Bytes(), 0666)
+}
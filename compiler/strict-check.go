@@ -0,0 +1,307 @@
+package compiler
+
+// This file implements the -strict mode, a set of rules inspired by coding
+// standards like MISRA that are commonly required for certifiable embedded
+// software:
+//
+//   * no heap allocations after package initialization, so that the program
+//     can't run out of memory at runtime,
+//   * no loops without an obvious bound in interrupt handlers, so that the
+//     worst case execution time of an interrupt is bounded,
+//   * no interface method calls in functions marked //go:hotpath, so that the
+//     called code is known statically.
+//
+// Violations are reported as errors. Optionally, a compliance report listing
+// every rule with its result is written to a file (-strict-report).
+//
+// Like the interrupt check, the rules are checked on the LLVM IR after the
+// Go-specific optimizations, so heap allocations that were moved to the stack
+// are not reported. Calls through function pointers are not followed.
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+
+	"tinygo.org/x/go-llvm"
+)
+
+// strictRule is a rule of the -strict mode with the violations found in the
+// program.
+type strictRule struct {
+	name        string
+	description string
+	violations  []error
+}
+
+// hotPathInvoke is an interface method call in a //go:hotpath function.
+type hotPathInvoke struct {
+	fn  string
+	pos token.Pos
+}
+
+// checkStrict checks the rules of the -strict mode and writes the compliance
+// report, if requested.
+func (c *Compiler) checkStrict() error {
+	if !c.Strict {
+		return nil
+	}
+	rules := []*strictRule{
+		{name: "no-heap-after-init", description: "no heap allocations after package initialization"},
+		{name: "bounded-isr-loops", description: "no loops without an obvious bound in interrupt handlers"},
+		{name: "no-hotpath-interface-calls", description: "no interface method calls in //go:hotpath functions"},
+	}
+
+	// Heap allocations are allowed in package initializers, but not in code
+	// that runs afterwards: the main function, goroutines started from it and
+	// interrupt handlers.
+	var roots []llvm.Value
+	if main := c.mod.NamedFunction("main.main"); !main.IsNil() {
+		roots = append(roots, main)
+	}
+	for _, handler := range c.interruptHandlers {
+		roots = append(roots, handler.fn)
+	}
+	for _, root := range roots {
+		c.walkStrictCallGraph(root, func(parents map[llvm.Value]llvm.Value, fn, inst llvm.Value) {
+			if inst.IsACallInst().IsNil() || inst.CalledValue().Name() != "runtime.alloc" {
+				return
+			}
+			msg := fmt.Sprintf("heap allocation after initialization: %s", callPath(parents, fn, inst.CalledValue()))
+			rules[0].violations = append(rules[0].violations, c.makeError(c.allocPositions[inst], msg))
+		})
+	}
+
+	// Loops in interrupt handlers must have a bound.
+	for _, handler := range c.interruptHandlers {
+		checked := map[llvm.Value]bool{}
+		c.walkStrictCallGraph(handler.fn, func(parents map[llvm.Value]llvm.Value, fn, inst llvm.Value) {
+			if checked[fn] {
+				return
+			}
+			checked[fn] = true
+			if len(unboundedLoops(fn)) == 0 {
+				return
+			}
+			msg := fmt.Sprintf("loop in interrupt handler may not terminate: %s", callPath(parents, parents[fn], fn))
+			rules[1].violations = append(rules[1].violations, c.makeError(handler.pos, msg))
+		})
+	}
+
+	// Interface calls in hot paths are found while compiling the functions.
+	for _, invoke := range c.hotPathInvokes {
+		msg := fmt.Sprintf("interface method call in hot path %s", invoke.fn)
+		rules[2].violations = append(rules[2].violations, c.makeError(invoke.pos, msg))
+	}
+
+	if c.StrictReport != "" {
+		if err := ioutil.WriteFile(c.StrictReport, strictReport(rules), 0666); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, rule := range rules {
+		errs = append(errs, rule.violations...)
+	}
+	if len(errs) != 0 {
+		return &MultiError{errs}
+	}
+	return nil
+}
+
+// strictReport formats the compliance report for the -strict mode.
+func strictReport(rules []*strictRule) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "TinyGo strict mode compliance report")
+	compliant := true
+	for _, rule := range rules {
+		fmt.Fprintln(buf)
+		if len(rule.violations) == 0 {
+			fmt.Fprintf(buf, "PASS  %s: %s\n", rule.name, rule.description)
+			continue
+		}
+		compliant = false
+		fmt.Fprintf(buf, "FAIL  %s: %s (%d violations)\n", rule.name, rule.description, len(rule.violations))
+		for _, violation := range rule.violations {
+			fmt.Fprintf(buf, "      %s\n", violation)
+		}
+	}
+	fmt.Fprintln(buf)
+	if compliant {
+		fmt.Fprintln(buf, "result: compliant")
+	} else {
+		fmt.Fprintln(buf, "result: not compliant")
+	}
+	return buf.Bytes()
+}
+
+// walkStrictCallGraph calls the callback for every instruction in the functions
+// reachable from the root function, including goroutines that are started from
+// them. The parents map can be used to reconstruct the call path, see
+// callPath.
+func (c *Compiler) walkStrictCallGraph(root llvm.Value, callback func(parents map[llvm.Value]llvm.Value, fn, inst llvm.Value)) {
+	parents := map[llvm.Value]llvm.Value{root: llvm.Value{}}
+	worklist := []llvm.Value{root}
+	for len(worklist) != 0 {
+		fn := worklist[0]
+		worklist = worklist[1:]
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				callback(parents, fn, inst)
+				if inst.IsACallInst().IsNil() {
+					continue
+				}
+				callee := inst.CalledValue()
+				if callee.Name() == "runtime.makeGoroutine" {
+					// Start of a goroutine: the function is passed as a
+					// bitcast to the runtime.
+					callee = inst.Operand(0)
+					if !callee.IsAConstantExpr().IsNil() {
+						callee = callee.Operand(0)
+					}
+				}
+				if callee.IsAFunction().IsNil() || callee.IsDeclaration() {
+					continue
+				}
+				if _, ok := parents[callee]; !ok {
+					parents[callee] = fn
+					worklist = append(worklist, callee)
+				}
+			}
+		}
+	}
+}
+
+// unboundedLoops returns the header of every loop in the function that has no
+// obvious bound. A loop is bounded if it exits on a comparison of an induction
+// variable: a value that is incremented or decremented by a constant in every
+// iteration, like the index in a for or range loop. Other loops, like loops
+// that wait for a peripheral register to change, may not terminate.
+func unboundedLoops(fn llvm.Value) []llvm.BasicBlock {
+	// Find the successors and predecessors of every block.
+	successors := map[llvm.BasicBlock][]llvm.BasicBlock{}
+	predecessors := map[llvm.BasicBlock][]llvm.BasicBlock{}
+	for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+		terminator := bb.LastInstruction()
+		for i := 0; i < terminator.OperandsCount(); i++ {
+			operand := terminator.Operand(i)
+			if operand.IsBasicBlock() {
+				succ := operand.AsBasicBlock()
+				successors[bb] = append(successors[bb], succ)
+				predecessors[succ] = append(predecessors[succ], bb)
+			}
+		}
+	}
+
+	// Find the back edges with a depth-first search: an edge to a block that
+	// is still being visited closes a loop.
+	var headers []llvm.BasicBlock
+	loops := map[llvm.BasicBlock]map[llvm.BasicBlock]bool{}
+	visiting := map[llvm.BasicBlock]bool{}
+	visited := map[llvm.BasicBlock]bool{}
+	var visit func(bb llvm.BasicBlock)
+	visit = func(bb llvm.BasicBlock) {
+		visiting[bb] = true
+		visited[bb] = true
+		for _, succ := range successors[bb] {
+			if visiting[succ] {
+				// Back edge from bb to the loop header succ. The loop consists
+				// of all blocks that can reach bb without passing through the
+				// header.
+				if loops[succ] == nil {
+					headers = append(headers, succ)
+					loops[succ] = map[llvm.BasicBlock]bool{succ: true}
+				}
+				worklist := []llvm.BasicBlock{bb}
+				for len(worklist) != 0 {
+					block := worklist[len(worklist)-1]
+					worklist = worklist[:len(worklist)-1]
+					if loops[succ][block] {
+						continue
+					}
+					loops[succ][block] = true
+					worklist = append(worklist, predecessors[block]...)
+				}
+			} else if !visited[succ] {
+				visit(succ)
+			}
+		}
+		visiting[bb] = false
+	}
+	visit(fn.EntryBasicBlock())
+
+	var unbounded []llvm.BasicBlock
+	for _, header := range headers {
+		if !isBoundedLoop(header, loops[header]) {
+			unbounded = append(unbounded, header)
+		}
+	}
+	return unbounded
+}
+
+// isBoundedLoop returns whether one of the exits of the loop is a comparison of
+// an induction variable of the loop.
+func isBoundedLoop(header llvm.BasicBlock, blocks map[llvm.BasicBlock]bool) bool {
+	for bb := range blocks {
+		br := bb.LastInstruction()
+		if br.IsABranchInst().IsNil() || br.OperandsCount() != 3 {
+			continue // not a conditional branch
+		}
+		exits := !blocks[br.Operand(1).AsBasicBlock()] || !blocks[br.Operand(2).AsBasicBlock()]
+		cmp := br.Operand(0)
+		if !exits || cmp.IsAICmpInst().IsNil() {
+			continue
+		}
+		if isInductionVariable(cmp.Operand(0), header) || isInductionVariable(cmp.Operand(1), header) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInductionVariable returns whether the value is a phi node in the loop
+// header that is incremented or decremented by a constant in the loop, or the
+// incremented value itself.
+func isInductionVariable(value llvm.Value, header llvm.BasicBlock) bool {
+	if phi := value.IsAPHINode(); !phi.IsNil() {
+		if phi.InstructionParent() != header {
+			return false
+		}
+		for i := 0; i < phi.IncomingCount(); i++ {
+			if isStep(phi.IncomingValue(i), phi) {
+				return true
+			}
+		}
+		return false
+	}
+	if value.IsABinaryOperator().IsNil() {
+		return false
+	}
+	for i := 0; i < 2; i++ {
+		phi := value.Operand(i).IsAPHINode()
+		if !phi.IsNil() && phi.InstructionParent() == header && isStep(value, phi) {
+			for j := 0; j < phi.IncomingCount(); j++ {
+				if phi.IncomingValue(j) == value {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isStep returns whether value adds a constant to or subtracts a constant from
+// the phi node.
+func isStep(value, phi llvm.Value) bool {
+	if value.IsABinaryOperator().IsNil() {
+		return false
+	}
+	switch value.InstructionOpcode() {
+	case llvm.Add, llvm.Sub:
+	default:
+		return false
+	}
+	lhs, rhs := value.Operand(0), value.Operand(1)
+	return lhs == phi && !rhs.IsAConstantInt().IsNil() || rhs == phi && !lhs.IsAConstantInt().IsNil()
+}
@@ -0,0 +1,107 @@
+package compiler
+
+// This file implements the -why-live flag, which explains why a function or
+// global is part of the binary by printing a chain of references to it,
+// starting at a symbol that is kept anyway (like main or an interrupt
+// handler). This helps to find the one call that pulls in a large package like
+// fmt or reflect.
+
+import (
+	"errors"
+
+	"tinygo.org/x/go-llvm"
+)
+
+// WhyLive returns a chain of references from a symbol that is visible outside
+// the module to the function or global with the given name, in the IR after
+// optimization. The first element is the root and the last is the symbol
+// itself.
+func (c *Compiler) WhyLive(name string) ([]string, error) {
+	target := c.mod.NamedFunction(name)
+	if target.IsNil() {
+		target = c.mod.NamedGlobal(name)
+	}
+	if target.IsNil() {
+		return nil, errors.New(name + " is not part of the program (it is never used or was removed by the optimizer)")
+	}
+
+	// Do a breadth-first search from all roots, so that the shortest chain is
+	// found.
+	parents := map[llvm.Value]llvm.Value{}
+	var worklist []llvm.Value
+	addRoot := func(value llvm.Value) {
+		if value.IsDeclaration() {
+			return
+		}
+		switch value.Linkage() {
+		case llvm.InternalLinkage, llvm.PrivateLinkage:
+			return
+		}
+		parents[value] = llvm.Value{}
+		worklist = append(worklist, value)
+	}
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		addRoot(fn)
+	}
+	for global := c.mod.FirstGlobal(); !global.IsNil(); global = llvm.NextGlobal(global) {
+		addRoot(global) // includes llvm.used, which keeps interrupt handlers
+	}
+	for len(worklist) != 0 {
+		value := worklist[0]
+		worklist = worklist[1:]
+		if value == target {
+			break
+		}
+		for _, ref := range referencedGlobals(value) {
+			if _, ok := parents[ref]; ok {
+				continue
+			}
+			parents[ref] = value
+			worklist = append(worklist, ref)
+		}
+	}
+
+	if _, ok := parents[target]; !ok {
+		return nil, errors.New(name + " is part of the program, but not referenced from any exported symbol")
+	}
+	var chain []string
+	for value := target; !value.IsNil(); value = parents[value] {
+		chain = append([]string{value.Name()}, chain...)
+	}
+	return chain, nil
+}
+
+// referencedGlobals returns the functions and globals that are used in the
+// body of a function or the initializer of a global.
+func referencedGlobals(value llvm.Value) []llvm.Value {
+	var refs []llvm.Value
+	var addOperand func(operand llvm.Value)
+	addOperand = func(operand llvm.Value) {
+		if operand.IsNil() {
+			return
+		}
+		if !operand.IsAGlobalValue().IsNil() {
+			refs = append(refs, operand)
+			return
+		}
+		if operand.IsAConstant().IsNil() {
+			return // instruction, argument or basic block
+		}
+		// A constant expression or aggregate, which may contain globals.
+		for i := 0; i < operand.OperandsCount(); i++ {
+			addOperand(operand.Operand(i))
+		}
+	}
+	if !value.IsAFunction().IsNil() {
+		for bb := value.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				for i := 0; i < inst.OperandsCount(); i++ {
+					addOperand(inst.Operand(i))
+				}
+			}
+		}
+	} else if !value.IsAGlobalVariable().IsNil() {
+		addOperand(value.Initializer())
+	}
+	return refs
+}
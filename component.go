@@ -0,0 +1,72 @@
+package main
+
+// This file implements -wasm-abi=component: WebAssembly modules that follow
+// the canonical ABI of the component model (see the runtime/cabi package).
+// The version of the ABI is recorded in a custom section. With -wit, the
+// module is turned into a component with wasm-tools, using the world defined
+// in the given WIT file.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// cabiVersion must be kept in sync with cabi.ABIVersion.
+const cabiVersion = 1
+
+// makeComponent adds the component metadata to a WebAssembly module built
+// with -wasm-abi=component and, if a WIT file was given, converts the module
+// into a component. Other targets are rejected, as the canonical ABI only
+// exists for WebAssembly.
+func makeComponent(executable string, spec *TargetSpec, config *BuildConfig) error {
+	if config.wasmAbi != "component" {
+		return nil
+	}
+	if !strings.HasPrefix(spec.Triple, "wasm") {
+		return fmt.Errorf("-wasm-abi=component is only supported for WebAssembly, not for target %s", spec.Triple)
+	}
+	data, err := ioutil.ReadFile(executable)
+	if err != nil {
+		return err
+	}
+	data = appendCustomSection(data, "tinygo:cabi", []byte{cabiVersion})
+	if err := ioutil.WriteFile(executable, data, 0666); err != nil {
+		return err
+	}
+	if config.witFile == "" {
+		return nil
+	}
+	for _, args := range [][]string{
+		{"component", "embed", config.witFile, executable, "-o", executable},
+		{"component", "new", executable, "-o", executable},
+	} {
+		cmd := exec.Command("wasm-tools", args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("wasm-tools component %s failed: %s", args[1], bytes.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// appendCustomSection appends a custom section to a WebAssembly module.
+// Custom sections may appear anywhere in a module.
+func appendCustomSection(module []byte, name string, payload []byte) []byte {
+	var content []byte
+	content = appendUvarint(content, uint64(len(name)))
+	content = append(content, name...)
+	content = append(content, payload...)
+	module = append(module, 0) // section ID of a custom section
+	module = appendUvarint(module, uint64(len(content)))
+	return append(module, content...)
+}
+
+// appendUvarint appends an unsigned LEB128 number, which is the same encoding
+// as a Go uvarint.
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], x)]...)
+}
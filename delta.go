@@ -0,0 +1,97 @@
+package main
+
+// This file creates patches for delta updates (-delta-from), which are applied
+// on the device by the runtime/delta package. See that package for the patch
+// format.
+//
+// The patch is created greedily: at every position of the new image, the
+// longest match in the old image is searched through an index of all 8-byte
+// sequences in the old image. Matches are copied, everything else is
+// inserted. Matches are encoded relative to the end of the previous match,
+// so that code that moved as a whole is cheap to encode.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+)
+
+const (
+	deltaMinMatch   = 8 // shorter matches are inserted instead
+	deltaCandidates = 8 // positions per sequence that are tried
+)
+
+// makeDelta returns a patch that converts the old image to the new image.
+func makeDelta(old, new []byte) []byte {
+	index := make(map[uint64][]int)
+	for i := 0; i+deltaMinMatch <= len(old); i++ {
+		key := binary.LittleEndian.Uint64(old[i:])
+		if len(index[key]) < deltaCandidates {
+			index[key] = append(index[key], i)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("TGD1")
+	for _, v := range []uint32{uint32(len(old)), crc32.ChecksumIEEE(old), uint32(len(new)), crc32.ChecksumIEEE(new)} {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+	varint := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(x uint64) {
+		buf.Write(varint[:binary.PutUvarint(varint, x)])
+	}
+
+	var literal []byte
+	flushLiteral := func() {
+		if len(literal) != 0 {
+			buf.WriteByte(0) // insert
+			writeUvarint(uint64(len(literal)))
+			buf.Write(literal)
+			literal = literal[:0]
+		}
+	}
+	copyEnd := 0
+	for i := 0; i < len(new); {
+		bestPos, bestLen := 0, 0
+		if i+deltaMinMatch <= len(new) {
+			// Try the position after the previous match first, which is
+			// where unchanged data continues.
+			candidates := append([]int{copyEnd}, index[binary.LittleEndian.Uint64(new[i:])]...)
+			for _, pos := range candidates {
+				n := 0
+				for pos+n < len(old) && i+n < len(new) && old[pos+n] == new[i+n] {
+					n++
+				}
+				if n > bestLen {
+					bestPos, bestLen = pos, n
+				}
+			}
+		}
+		if bestLen < deltaMinMatch {
+			literal = append(literal, new[i])
+			i++
+			continue
+		}
+		flushLiteral()
+		buf.WriteByte(1) // copy
+		d := int64(bestPos - copyEnd)
+		writeUvarint(uint64(d<<1 ^ d>>63)) // zigzag encoding
+		writeUvarint(uint64(bestLen))
+		copyEnd = bestPos + bestLen
+		i += bestLen
+	}
+	flushLiteral()
+	return buf.Bytes()
+}
+
+// writeDelta writes a patch from the old image in the given file (a .bin file
+// of the firmware currently on the device) to the new image.
+func writeDelta(oldPath string, new []byte, outpath string) (int, error) {
+	old, err := ioutil.ReadFile(oldPath)
+	if err != nil {
+		return 0, err
+	}
+	patch := makeDelta(old, new)
+	return len(patch), ioutil.WriteFile(outpath, patch, 0666)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tinygo-org/tinygo/bootloader"
+)
+
+// flashCommandInstalled returns whether the program used by the flash command
+// of the target is installed.
+func flashCommandInstalled(spec *TargetSpec) bool {
+	fields := strings.Fields(spec.Flasher)
+	if len(fields) == 0 {
+		return false
+	}
+	_, err := exec.LookPath(fields[0])
+	return err == nil
+}
+
+// FlashBootloader compiles the given package and flashes it with one of the
+// built-in implementations of bootloader protocols (see the bootloader
+// package), without the need for vendor tools. The port is the serial port of
+// the board (bossa, uf2) or the USB serial number of the device (dfu,
+// picoboot), or empty to find it automatically.
+func FlashBootloader(method, pkgName, port string, spec *TargetSpec, config *BuildConfig) error {
+	outext := ".elf"
+	switch method {
+	case "bossa", "dfu", "picoboot":
+	case "uf2":
+		outext = ".uf2"
+	default:
+		return errors.New("unknown bootloader: " + method)
+	}
+	return Compile(pkgName, outext, spec, config, func(tmppath string) error {
+		// The ELF file is always next to the flashed file.
+		executable := filepath.Join(filepath.Dir(tmppath), "main")
+		addr, data, err := ExtractROM(executable)
+		if err != nil {
+			return err
+		}
+		serial := port
+		if strings.HasPrefix(port, "/") {
+			serial = "" // a serial port, not a USB serial number
+		}
+
+		// With -incremental, compare against the image of the last flash.
+		// Only the DFU and UF2 methods can write part of the flash.
+		var previous []byte
+		imagePath := ""
+		if config.incremental {
+			imagePath = flashedImagePath(method, port, spec)
+			previous = loadFlashedImage(imagePath, addr)
+		}
+
+		fmt.Printf("flashing %d bytes at 0x%08x (%s)\n", len(data), addr, method)
+		switch method {
+		case "bossa":
+			err = flashSAMBA(port, spec, uint32(addr), data)
+		case "dfu":
+			err = bootloader.FlashDFUChanged(serial, uint32(addr), data, previous)
+		case "picoboot":
+			err = bootloader.FlashPicoboot(serial, uint32(addr), data)
+		case "uf2":
+			if previous != nil {
+				uf2, blocks := convertChangedBinToUF2(data, previous)
+				if blocks == 0 {
+					fmt.Println("image unchanged, nothing to flash")
+					break
+				}
+				fmt.Printf("writing %d changed blocks of 256 bytes\n", blocks)
+				tmppath = filepath.Join(filepath.Dir(tmppath), "changed.uf2")
+				if err := ioutil.WriteFile(tmppath, uf2, 0666); err != nil {
+					return err
+				}
+			}
+			resetToBootloader(port, spec)
+			err = bootloader.FlashUF2(tmppath, 10*time.Second)
+		}
+		if err != nil {
+			return err
+		}
+		if imagePath != "" {
+			storeFlashedImage(imagePath, addr, data)
+		}
+		return afterFlash(port, spec, executable, config)
+	})
+}
+
+// flashSAMBA resets the board into its bootloader and flashes it over SAM-BA.
+func flashSAMBA(port string, spec *TargetSpec, addr uint32, data []byte) error {
+	resetToBootloader(port, spec)
+
+	// The serial port disappears for a moment while the board resets.
+	var path string
+	var err error
+	for i := 0; ; i++ {
+		path, err = findSerialPort(port, spec)
+		if err == nil || i == 30 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+	// Let reads time out after two seconds, so that a board that doesn't
+	// answer is reported instead of waiting forever.
+	if err := configureSerialPort(path, 115200, "min", "0", "time", "20"); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return bootloader.FlashSAMBA(f, addr, data)
+}
+
+// resetToBootloader resets a board with a USB CDC serial port into its
+// bootloader, by opening the port at 1200 baud and closing it again. This is
+// the convention of the Arduino and Adafruit bootloaders. Only ports that are
+// known to belong to the target are touched. Errors are ignored: the board may
+// already be in bootloader mode.
+func resetToBootloader(port string, spec *TargetSpec) {
+	path := port
+	if !strings.HasPrefix(port, "/") {
+		ports, err := ListSerialPorts()
+		if err != nil {
+			return
+		}
+		var candidates []string
+		for _, p := range ports {
+			if p.matches(spec.SerialPort) && (port == "" || p.Serial == strings.ToLower(port)) {
+				candidates = append(candidates, p.Path)
+			}
+		}
+		if len(candidates) != 1 {
+			return
+		}
+		path = candidates[0]
+	}
+	if err := configureSerialPort(path, 1200); err != nil {
+		return
+	}
+	if f, err := os.OpenFile(path, os.O_RDWR, 0); err == nil {
+		f.Close()
+	}
+	time.Sleep(500 * time.Millisecond)
+}
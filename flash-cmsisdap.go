@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/tinygo-org/tinygo/probe"
+)
+
+// FlashCMSISDAP compiles the given package and flashes it with the built-in
+// CMSIS-DAP driver, without the need for OpenOCD. The port is the USB serial
+// number of the probe to use, or empty to use the first probe.
+func FlashCMSISDAP(pkgName, port string, spec *TargetSpec, config *BuildConfig) error {
+	if spec.FlashAlgo == "" {
+		return errors.New("target does not support flashing with a CMSIS-DAP probe (no flash-algorithm)")
+	}
+	probePort := port
+	if strings.HasPrefix(port, "/") {
+		probePort = "" // a serial port, not a probe serial number
+	}
+	return Compile(pkgName, ".elf", spec, config, func(tmppath string) error {
+		addr, data, err := ExtractROM(tmppath)
+		if err != nil {
+			return err
+		}
+
+		d, err := probe.OpenCMSISDAP(probePort)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		if err := d.Connect(1000000); err != nil {
+			return err
+		}
+		if err := d.ResetHalt(); err != nil {
+			return err
+		}
+		fmt.Printf("flashing %d bytes at 0x%08x\n", len(data), addr)
+		if config.incremental {
+			// Read back the flash and only write the blocks that changed.
+			n, err := d.FlashChanged(spec.FlashAlgo, uint32(addr), data)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("wrote %d changed bytes\n", n)
+		} else if err := d.Flash(spec.FlashAlgo, uint32(addr), data); err != nil {
+			return err
+		}
+		if err := d.Reset(); err != nil {
+			return err
+		}
+		return afterFlash(port, spec, tmppath, config)
+	})
+}
+
+// startGDBServer connects to the chip through a CMSIS-DAP probe and starts the
+// built-in GDB server on port 3333, which is where the gdb-initial-cmds of the
+// targets connect to. The ELF file is used to find the flash area that GDB
+// writes to with the load command. The probe is closed when the returned
+// value is closed.
+func startGDBServer(port, executable string, spec *TargetSpec) (io.Closer, error) {
+	if strings.HasPrefix(port, "/") {
+		port = "" // a serial port, not a probe serial number
+	}
+	addr, data, err := ExtractROM(executable)
+	if err != nil {
+		return nil, err
+	}
+	d, err := probe.OpenCMSISDAP(port)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Connect(1000000); err != nil {
+		d.Close()
+		return nil, err
+	}
+	server, err := probe.NewGDBServer(d)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	server.FlashAlgo = spec.FlashAlgo
+	server.FlashStart = uint32(addr)
+	server.FlashEnd = uint32(addr) + uint32(len(data))
+	server.Log = os.Stderr
+	l, err := net.Listen("tcp", "localhost:3333")
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			server.Serve(conn)
+			conn.Close()
+		}
+	}()
+	return closerFunc(func() error {
+		l.Close()
+		return d.Close()
+	}), nil
+}
+
+// closerFunc implements io.Closer with a function.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,50 @@
+package main
+
+// This file implements the cache of flashed images for -incremental, which
+// only writes the parts of the image that changed since the last flash. The
+// built-in CMSIS-DAP driver reads back the flash instead, so it doesn't need
+// the cache.
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// flashedImagePath returns the path of the cached image that was last flashed
+// to the given port with the given method.
+func flashedImagePath(method, port string, spec *TargetSpec) string {
+	specData, _ := json.Marshal(spec)
+	h := sha256.New()
+	h.Write([]byte(method + "\x00" + port + "\x00"))
+	h.Write(specData)
+	return filepath.Join(cacheDir(), "flashed", hex.EncodeToString(h.Sum(nil))+".bin")
+}
+
+// loadFlashedImage returns the cached image at the given path if it was
+// flashed at the same address, or nil otherwise. The cache entry is removed,
+// so that it is not used after a flash that failed halfway: the flash
+// contents are unknown then.
+func loadFlashedImage(path string, addr uint64) []byte {
+	buf, err := ioutil.ReadFile(path)
+	os.Remove(path)
+	if err != nil || len(buf) < 8 || binary.LittleEndian.Uint64(buf) != addr {
+		return nil
+	}
+	return buf[8:]
+}
+
+// storeFlashedImage stores the image that was flashed at the given address.
+// Errors are ignored, the next flash will simply write the whole image.
+func storeFlashedImage(path string, addr uint64, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return
+	}
+	buf := make([]byte, 8, 8+len(data))
+	binary.LittleEndian.PutUint64(buf, addr)
+	ioutil.WriteFile(path, append(buf, data...), 0666)
+}
@@ -95,8 +95,17 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 		case !inst.IsALoadInst().IsNil():
 			operand := fr.getLocal(inst.Operand(0)).(*LocalValue)
 			var value llvm.Value
-			if !operand.IsConstant() || inst.IsVolatile() || (!operand.Underlying.IsAConstantExpr().IsNil() && operand.Underlying.Opcode() == llvm.BitCast) {
+			if register := fr.register(operand.Underlying); inst.IsVolatile() && register != nil && inst.Type().TypeKind() == llvm.IntegerTypeKind {
+				// Load from a modelled peripheral register.
+				if known, ok := register.Load(); ok {
+					value = llvm.ConstInt(inst.Type(), known, false)
+				} else {
+					value = fr.builder.CreateLoad(operand.Value(), inst.Name())
+					value.SetVolatile(true)
+				}
+			} else if !operand.IsConstant() || inst.IsVolatile() || (!operand.Underlying.IsAConstantExpr().IsNil() && operand.Underlying.Opcode() == llvm.BitCast) {
 				value = fr.builder.CreateLoad(operand.Value(), inst.Name())
+				value.SetVolatile(inst.IsVolatile())
 			} else {
 				value = operand.Load()
 			}
@@ -108,7 +117,17 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 			value := fr.getLocal(inst.Operand(0))
 			ptr := fr.getLocal(inst.Operand(1))
 			if inst.IsVolatile() {
-				fr.builder.CreateStore(value.Value(), ptr.Value())
+				if register := fr.register(ptr.Value()); register != nil {
+					llvmValue := value.Value()
+					known := !llvmValue.IsAConstantInt().IsNil()
+					if known {
+						register.Store(llvmValue.ZExtValue(), true)
+					} else {
+						register.Store(0, false)
+					}
+				}
+				store := fr.builder.CreateStore(value.Value(), ptr.Value())
+				store.SetVolatile(true)
 			} else {
 				ptr.Store(value.Value())
 			}
@@ -289,6 +308,18 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 				keyBuf := fr.getLocal(inst.Operand(1)).(*LocalValue)
 				valPtr := fr.getLocal(inst.Operand(2)).(*LocalValue)
 				m.PutBinary(keyBuf, valPtr)
+			case callee.Name() == "runtime.hashmapFloatSet":
+				// set a float32 or float64 key in the map
+				m := fr.getLocal(inst.Operand(0)).(*MapValue)
+				keyBuf := fr.getLocal(inst.Operand(1)).(*LocalValue)
+				valPtr := fr.getLocal(inst.Operand(2)).(*LocalValue)
+				m.PutBinary(keyBuf, valPtr)
+			case callee.Name() == "runtime.hashmapIntSet":
+				// set a bool or small integer key in the map
+				m := fr.getLocal(inst.Operand(0)).(*MapValue)
+				key := fr.getLocal(inst.Operand(1)).(*LocalValue)
+				valPtr := fr.getLocal(inst.Operand(2)).(*LocalValue)
+				m.PutInt(key, valPtr)
 			case callee.Name() == "runtime.stringConcat":
 				// adding two strings together
 				buf1Ptr := fr.getLocal(inst.Operand(0))
@@ -384,7 +415,7 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 				// do nothing
 			case callee.Name() == "runtime.trackPointer":
 				// do nothing
-			case strings.HasPrefix(callee.Name(), "runtime.print") || callee.Name() == "runtime._panic":
+			case strings.HasPrefix(callee.Name(), "runtime.print") || callee.Name() == "runtime._panic" || callee.Name() == "runtime.panicCode":
 				// This are all print instructions, which necessarily have side
 				// effects but no results.
 				// TODO: print an error when executing runtime._panic (with the
@@ -399,6 +430,7 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 				fr.builder.CreateCall(callee, params, inst.Name())
 			case !callee.IsAFunction().IsNil() && callee.IsDeclaration():
 				// external functions
+				fr.invalidatePeripherals()
 				var params []llvm.Value
 				for i := 0; i < inst.OperandsCount()-1; i++ {
 					operand := fr.getLocal(inst.Operand(i)).Value()
@@ -424,6 +456,11 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 				}
 				var ret Value
 				scanResult := fr.Eval.hasSideEffects(callee)
+				if !fr.registerParams(scanResult, params) {
+					// The function accesses a peripheral register that is not
+					// modelled through one of its parameters.
+					dirtyParams = true
+				}
 				if scanResult.severity == sideEffectLimited || dirtyParams && scanResult.severity != sideEffectAll {
 					// Side effect is bounded. This means the operation invokes
 					// side effects (like calling an external function) but it
@@ -434,6 +471,7 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 					for i, param := range params {
 						llvmParams[i] = param.Value()
 					}
+					fr.invalidatePeripherals()
 					result := fr.builder.CreateCall(callee, llvmParams, inst.Name())
 					ret = &LocalValue{fr.Eval, result}
 					// mark all mentioned globals as dirty
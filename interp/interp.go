@@ -17,14 +17,16 @@ type Eval struct {
 	Mod             llvm.Module
 	TargetData      llvm.TargetData
 	Debug           bool
+	Peripherals     Peripherals // model of peripheral registers, may be nil
 	builder         llvm.Builder
 	dirtyGlobals    map[llvm.Value]struct{}
 	sideEffectFuncs map[llvm.Value]*sideEffectResult // cache of side effect scan results
 }
 
 // Run evaluates the function with the given name and then eliminates all
-// callers.
-func Run(mod llvm.Module, targetData llvm.TargetData, debug bool) error {
+// callers. Volatile accesses to the registers in the peripherals model (if not
+// nil) are assumed to have no side effects.
+func Run(mod llvm.Module, targetData llvm.TargetData, peripherals Peripherals, debug bool) error {
 	if debug {
 		println("\ncompile-time evaluation:")
 	}
@@ -34,6 +36,7 @@ func Run(mod llvm.Module, targetData llvm.TargetData, debug bool) error {
 		Mod:          mod,
 		TargetData:   targetData,
 		Debug:        debug,
+		Peripherals:  peripherals,
 		dirtyGlobals: map[llvm.Value]struct{}{},
 	}
 	e.builder = mod.Context().NewBuilder()
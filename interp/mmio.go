@@ -0,0 +1,214 @@
+package interp
+
+// This file models memory-mapped peripheral registers. Volatile loads and
+// stores normally make a function run at runtime, because the interpreter
+// can't know what they do. But some registers are known to have no effect
+// other than on the peripheral itself, like the clock enable registers of most
+// chips. Accesses to such registers are kept in the initialization code (in the
+// same order) and do not prevent the rest of the function from being evaluated
+// at compile time.
+
+import (
+	"tinygo.org/x/go-llvm"
+)
+
+// Peripherals is a model of the memory-mapped peripherals of a chip. A
+// different model can be plugged in by passing it to Run.
+type Peripherals interface {
+	// Register returns the register at the given address, or nil if the
+	// register is not part of the model. Every volatile access to a register
+	// that is not modelled is assumed to have side effects.
+	Register(address uint64) Register
+
+	// Invalidate is called when a call is emitted that runs at runtime. The
+	// called function may access registers, so their values are not known
+	// anymore at compile time.
+	Invalidate()
+}
+
+// Register is a modelled peripheral register: accesses to it have no effect
+// other than on the peripheral.
+type Register interface {
+	// Load returns the value of the register, if it is known at compile time.
+	// If it is not, the register is read at runtime.
+	Load() (value uint64, known bool)
+
+	// Store records the value that is written to the register. The value is
+	// only valid if known is set. The store itself is always done at runtime.
+	Store(value uint64, known bool)
+}
+
+// RegisterSpec describes a register for the default model, see
+// NewPeripherals. It is usually read from the "mmio-registers" property of a
+// target.
+type RegisterSpec struct {
+	Address uint64
+	Reset   uint64 // value after reset
+
+	// Whether the register only changes when it is written, so that it reads
+	// back the last value written to it. This is the case for clock enable
+	// registers, but not for status registers or registers with bits that
+	// clear themselves. Loads from other registers are done at runtime.
+	Memory bool
+}
+
+// NewPeripherals returns the default model: a list of registers that can be
+// accessed without side effects, from the target specification.
+func NewPeripherals(registers []RegisterSpec) Peripherals {
+	p := make(peripherals, len(registers))
+	for _, spec := range registers {
+		p[spec.Address] = &register{
+			spec:  spec,
+			value: spec.Reset,
+			known: spec.Memory,
+		}
+	}
+	return p
+}
+
+type peripherals map[uint64]*register
+
+func (p peripherals) Register(address uint64) Register {
+	if r, ok := p[address]; ok {
+		return r
+	}
+	return nil
+}
+
+func (p peripherals) Invalidate() {
+	for _, r := range p {
+		r.known = false
+	}
+}
+
+type register struct {
+	spec  RegisterSpec
+	value uint64
+	known bool
+}
+
+func (r *register) Load() (uint64, bool) {
+	return r.value, r.known
+}
+
+func (r *register) Store(value uint64, known bool) {
+	r.value = value
+	r.known = known && r.spec.Memory
+}
+
+// register returns the modelled register the pointer points to, or nil if it
+// does not point to one.
+func (e *Eval) register(ptr llvm.Value) Register {
+	if e.Peripherals == nil {
+		return nil
+	}
+	address, ok := e.pointerAddress(ptr)
+	if !ok {
+		return nil
+	}
+	return e.Peripherals.Register(address)
+}
+
+// pointerAddress returns the address of a pointer if it can be calculated at
+// compile time. The pointer may be a constant expression or an instruction that
+// calculates it, for example a GEP on a peripheral pointer from a device
+// package.
+func (e *Eval) pointerAddress(ptr llvm.Value) (uint64, bool) {
+	var opcode llvm.Opcode
+	switch {
+	case !ptr.IsAInstruction().IsNil():
+		opcode = ptr.InstructionOpcode()
+	case !ptr.IsAConstantExpr().IsNil():
+		opcode = ptr.Opcode()
+	default:
+		return 0, false
+	}
+	switch opcode {
+	case llvm.IntToPtr:
+		if ptr.Operand(0).IsAConstantInt().IsNil() {
+			return 0, false
+		}
+		return ptr.Operand(0).ZExtValue(), true
+	case llvm.BitCast:
+		return e.pointerAddress(ptr.Operand(0))
+	case llvm.Load:
+		// A pointer stored in a global, like the peripherals in the device
+		// packages. It is only known while the global is not dirty.
+		global := ptr.Operand(0)
+		if ptr.IsVolatile() || global.IsAGlobalVariable().IsNil() {
+			return 0, false
+		}
+		if _, ok := e.dirtyGlobals[global]; ok {
+			return 0, false
+		}
+		if global.Initializer().IsNil() {
+			return 0, false // external global
+		}
+		return e.pointerAddress(global.Initializer())
+	case llvm.GetElementPtr:
+		address, ok := e.pointerAddress(ptr.Operand(0))
+		if !ok {
+			return 0, false
+		}
+		typ := ptr.Operand(0).Type()
+		for i := 1; i < ptr.OperandsCount(); i++ {
+			index := ptr.Operand(i)
+			if index.IsAConstantInt().IsNil() {
+				return 0, false
+			}
+			switch typ.TypeKind() {
+			case llvm.StructTypeKind:
+				address += e.TargetData.ElementOffset(typ, int(index.ZExtValue()))
+				typ = typ.StructElementTypes()[index.ZExtValue()]
+			case llvm.PointerTypeKind, llvm.ArrayTypeKind:
+				typ = typ.ElementType()
+				address += uint64(index.SExtValue()) * e.TargetData.TypeAllocSize(typ)
+			default:
+				return 0, false
+			}
+		}
+		return address, true
+	default:
+		return 0, false
+	}
+}
+
+// pointerParam returns the index of the parameter of fn the pointer is derived
+// from, or -1 if it is not derived from a parameter.
+func pointerParam(fn, ptr llvm.Value) int {
+	for {
+		switch {
+		case !ptr.IsAGetElementPtrInst().IsNil(), !ptr.IsABitCastInst().IsNil():
+			ptr = ptr.Operand(0)
+		case !ptr.IsAArgument().IsNil():
+			for i, param := range fn.Params() {
+				if param == ptr {
+					return i
+				}
+			}
+			return -1
+		default:
+			return -1
+		}
+	}
+}
+
+// registerParams returns whether all parameters that are used as the address of
+// a volatile load or store in the called function point to a modelled
+// register. If they don't, the function must be called at runtime.
+func (e *Eval) registerParams(result *sideEffectResult, params []Value) bool {
+	for i := range result.registerParams {
+		if e.register(params[i].Value()) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidatePeripherals forgets the values of all registers, before emitting a
+// call that runs at runtime.
+func (e *Eval) invalidatePeripherals() {
+	if e.Peripherals != nil {
+		e.Peripherals.Invalidate()
+	}
+}
@@ -18,6 +18,7 @@ const (
 type sideEffectResult struct {
 	severity        sideEffectSeverity
 	mentionsGlobals map[llvm.Value]struct{}
+	registerParams  map[int]struct{} // parameters used as address of a volatile load or store
 }
 
 // hasSideEffects scans this function and all descendants, recursively. It
@@ -31,7 +32,7 @@ func (e *Eval) hasSideEffects(fn llvm.Value) *sideEffectResult {
 	case "runtime.nanotime":
 		// Fixed value at compile time.
 		return &sideEffectResult{severity: sideEffectNone}
-	case "runtime._panic":
+	case "runtime._panic", "runtime.panicCode":
 		return &sideEffectResult{severity: sideEffectLimited}
 	case "runtime.interfaceImplements":
 		return &sideEffectResult{severity: sideEffectNone}
@@ -49,6 +50,7 @@ func (e *Eval) hasSideEffects(fn llvm.Value) *sideEffectResult {
 	result := &sideEffectResult{
 		severity:        sideEffectInProgress,
 		mentionsGlobals: map[llvm.Value]struct{}{},
+		registerParams:  map[int]struct{}{},
 	}
 	e.sideEffectFuncs[fn] = result
 	dirtyLocals := map[llvm.Value]struct{}{}
@@ -113,7 +115,12 @@ func (e *Eval) hasSideEffects(fn llvm.Value) *sideEffectResult {
 				}
 			case llvm.Load:
 				if inst.IsVolatile() {
-					result.updateSeverity(sideEffectLimited)
+					if !e.isRegisterAccess(fn, inst.Operand(0), result) {
+						result.updateSeverity(sideEffectLimited)
+					} else if e.hasLocalSideEffects(dirtyLocals, inst) {
+						// The value may only be known at runtime.
+						result.updateSeverity(sideEffectLimited)
+					}
 				}
 				if _, ok := e.dirtyGlobals[inst.Operand(0)]; ok {
 					if e.hasLocalSideEffects(dirtyLocals, inst) {
@@ -121,7 +128,7 @@ func (e *Eval) hasSideEffects(fn llvm.Value) *sideEffectResult {
 					}
 				}
 			case llvm.Store:
-				if inst.IsVolatile() {
+				if inst.IsVolatile() && !e.isRegisterAccess(fn, inst.Operand(1), result) {
 					result.updateSeverity(sideEffectLimited)
 				}
 			case llvm.IntToPtr:
@@ -142,6 +149,24 @@ func (e *Eval) hasSideEffects(fn llvm.Value) *sideEffectResult {
 	return result
 }
 
+// isRegisterAccess returns whether the pointer of a volatile load or store
+// points to a modelled peripheral register. A pointer that is derived from a
+// parameter is recorded in the result, and checked when the function is
+// called.
+func (e *Eval) isRegisterAccess(fn, ptr llvm.Value, result *sideEffectResult) bool {
+	if e.Peripherals == nil {
+		return false
+	}
+	if e.register(ptr) != nil {
+		return true
+	}
+	if param := pointerParam(fn, ptr); param >= 0 {
+		result.registerParams[param] = struct{}{}
+		return true
+	}
+	return false
+}
+
 // hasLocalSideEffects checks whether the given instruction flows into a branch
 // or return instruction, in which case the whole function must be marked as
 // having side effects and be called at runtime.
@@ -173,6 +198,11 @@ func (e *Eval) hasLocalSideEffects(dirtyLocals map[llvm.Value]struct{}, inst llv
 				// Already handled in (*Eval).hasSideEffects.
 				continue
 			}
+			if user.IsVolatile() {
+				// Store to a peripheral register, which is done at runtime.
+				// Already handled in (*Eval).hasSideEffects.
+				continue
+			}
 			// But a store might also store to an alloca, in which case all uses
 			// of the alloca (possibly indirect through a GEP, bitcast, etc.)
 			// must be marked dirty.
@@ -3,6 +3,7 @@ package interp
 // This file provides a litte bit of abstraction around LLVM values.
 
 import (
+	"math"
 	"strconv"
 
 	"tinygo.org/x/go-llvm"
@@ -192,6 +193,7 @@ func (v *MapValue) Value() llvm.Value {
 	bucketGlobal := firstBucketGlobal
 	for i, key := range v.Keys {
 		var keyBuf []byte
+		hashFunc := v.binaryHash
 		llvmKey := key.Value()
 		llvmValue := v.Values[i].Value()
 		if key.Type().TypeKind() == llvm.StructTypeKind && key.Type().StructName() == "runtime._string" {
@@ -199,6 +201,7 @@ func (v *MapValue) Value() llvm.Value {
 			keyLen := llvm.ConstExtractValue(llvmKey, []uint32{1})
 			keyPtrVal := v.Eval.getValue(keyPtr)
 			keyBuf = getStringBytes(keyPtrVal, keyLen)
+			hashFunc = v.hash
 		} else if key.Type().TypeKind() == llvm.IntegerTypeKind {
 			keyBuf = make([]byte, v.Eval.TargetData.TypeAllocSize(key.Type()))
 			n := key.Value().ZExtValue()
@@ -206,6 +209,25 @@ func (v *MapValue) Value() llvm.Value {
 				keyBuf[i] = byte(n)
 				n >>= 8
 			}
+		} else if kind := key.Type().TypeKind(); kind == llvm.FloatTypeKind || kind == llvm.DoubleTypeKind {
+			// Hash like hashmapFloatHash in the runtime: as a float64, with
+			// negative zero hashed like positive zero.
+			size := int(v.Eval.TargetData.TypeAllocSize(key.Type()))
+			bits := llvm.ConstBitCast(llvmKey, ctx.IntType(size*8)).ZExtValue()
+			f := math.Float64frombits(bits)
+			if size == 4 {
+				f = float64(math.Float32frombits(uint32(bits)))
+			}
+			if f == 0 {
+				f = 0
+			}
+			keyBuf = make([]byte, 8)
+			n := math.Float64bits(f)
+			for i := range keyBuf {
+				keyBuf[i] = byte(n)
+				n >>= 8
+			}
+			hashFunc = v.hash
 		} else if key.Type().TypeKind() == llvm.ArrayTypeKind &&
 			key.Type().ElementType().TypeKind() == llvm.IntegerTypeKind &&
 			key.Type().ElementType().IntTypeWidth() == 8 {
@@ -216,7 +238,7 @@ func (v *MapValue) Value() llvm.Value {
 		} else {
 			panic("interp: map key type not implemented: " + key.Type().String())
 		}
-		hash := v.hash(keyBuf)
+		hash := hashFunc(keyBuf)
 
 		if i%8 == 0 && i != 0 {
 			// Bucket is full, create a new one.
@@ -363,6 +385,40 @@ func (v *MapValue) PutBinary(keyPtr, valPtr *LocalValue) {
 	v.Values = append(v.Values, &LocalValue{v.Eval, value})
 }
 
+// PutInt does a map assign operation for a map with a bool or integer key of at
+// most 32 bits, which is passed zero-extended to an i32.
+func (v *MapValue) PutInt(key, valPtr *LocalValue) {
+	if !v.Underlying.IsNil() {
+		panic("map already created")
+	}
+
+	if valPtr.Underlying.Opcode() == llvm.BitCast {
+		valPtr = &LocalValue{v.Eval, valPtr.Underlying.Operand(0)}
+	}
+	value := valPtr.Load()
+	if v.ValueType.IsNil() {
+		v.ValueType = value.Type()
+		if int(v.Eval.TargetData.TypeAllocSize(v.ValueType)) != v.ValueSize {
+			panic("interp: map store value type has the wrong size")
+		}
+	} else {
+		if value.Type() != v.ValueType {
+			panic("interp: map store value type is inconsistent")
+		}
+	}
+
+	keyType := v.Eval.Mod.Context().IntType(v.KeySize * 8)
+	v.KeyType = keyType
+	keyValue := key.Value()
+	if v.KeySize < 4 {
+		keyValue = llvm.ConstTrunc(keyValue, keyType)
+	}
+
+	// TODO: avoid duplicate keys
+	v.Keys = append(v.Keys, &LocalValue{v.Eval, keyValue})
+	v.Values = append(v.Values, &LocalValue{v.Eval, value})
+}
+
 // Get FNV-1a hash of this string.
 //
 // https://en.wikipedia.org/wiki/Fowler%E2%80%93Noll%E2%80%93Vo_hash_function#FNV-1a_hash
@@ -375,6 +431,26 @@ func (v *MapValue) hash(data []byte) uint32 {
 	return result
 }
 
+// Get the hash of a binary key, like hashmapBinaryHash in the runtime: keys of
+// 1, 2 or 4 bytes are hashed as integers and other keys with FNV-1a.
+func (v *MapValue) binaryHash(data []byte) uint32 {
+	switch len(data) {
+	case 1, 2, 4:
+		var x uint32
+		for i := len(data) - 1; i >= 0; i-- {
+			x = x<<8 | uint32(data[i])
+		}
+		// lowbias32, see hashmapIntHash in the runtime
+		x ^= x >> 16
+		x *= 0x7feb352d
+		x ^= x >> 15
+		x *= 0x846ca68b
+		x ^= x >> 16
+		return x
+	}
+	return v.hash(data)
+}
+
 // Get the topmost 8 bits of the hash, without using a special value (like 0).
 func (v *MapValue) topHash(hash uint32) uint8 {
 	tophash := uint8(hash >> 24)
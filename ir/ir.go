@@ -5,6 +5,7 @@ import (
 	"go/types"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/tinygo-org/tinygo/loader"
 	"golang.org/x/tools/go/ssa"
@@ -33,7 +34,12 @@ type Function struct {
 	nobounds  bool       // go:nobounds
 	flag      bool       // used by dead code elimination
 	interrupt bool       // go:interrupt
+	recursive bool       // go:recursive
+	hotpath   bool       // go:hotpath
+	nonblock  bool       // go:nonblocking
+	module    string     // go:wasm-module
 	inline    InlineType // go:inline
+	deadline  time.Duration
 }
 
 // Interface type that is at some point used in a type assert (to check whether
@@ -233,6 +239,26 @@ func (f *Function) parsePragmas() {
 				f.inline = InlineHint
 			case "//go:noinline":
 				f.inline = InlineNone
+			case "//go:recursive":
+				f.recursive = true
+			case "//go:hotpath":
+				f.hotpath = true
+			case "//go:nonblocking":
+				f.nonblock = true
+			case "//go:wasm-module":
+				// Import this (external) function from the given WebAssembly
+				// module instead of the default "env" module.
+				if len(parts) != 2 {
+					continue
+				}
+				f.module = parts[1]
+			case "//go:deadline":
+				if len(parts) != 2 {
+					continue
+				}
+				if deadline, err := time.ParseDuration(parts[1]); err == nil && deadline > 0 {
+					f.deadline = deadline
+				}
 			case "//go:interrupt":
 				if len(parts) != 2 {
 					continue
@@ -286,6 +312,36 @@ func (f *Function) IsInterrupt() bool {
 	return f.interrupt
 }
 
+// Return true for functions annotated with //go:recursive, which may be part of
+// a recursion cycle when recursion is otherwise forbidden (-no-recursion).
+func (f *Function) IsRecursive() bool {
+	return f.recursive
+}
+
+// Return true for functions annotated with //go:hotpath, which must not make
+// interface method calls in -strict mode.
+func (f *Function) IsHotPath() bool {
+	return f.hotpath
+}
+
+// Return true for functions annotated with //go:nonblocking, which must not
+// (directly or indirectly) do a blocking operation.
+func (f *Function) IsNonBlocking() bool {
+	return f.nonblock
+}
+
+// Return the WebAssembly module set with //go:wasm-module that this function is
+// imported from, or "" if it is imported from the default module.
+func (f *Function) WasmModule() string {
+	return f.module
+}
+
+// Return the maximum execution time of this function set with //go:deadline,
+// or 0 if there is none.
+func (f *Function) Deadline() time.Duration {
+	return f.deadline
+}
+
 // Return the inline directive of this function.
 func (f *Function) Inline() InlineType {
 	return f.inline
@@ -2,6 +2,7 @@ package ir
 
 import (
 	"go/types"
+	"strings"
 
 	"golang.org/x/tools/go/ssa"
 )
@@ -65,14 +66,15 @@ func (p *Program) SimpleDCE() {
 	}
 
 	// Initial set of live functions. Include main.main, *.init and runtime.*
-	// functions.
+	// functions, and functions that implement runtime functions using
+	// go:linkname (for example a custom garbage collector).
 	main := p.mainPkg.Members["main"].(*ssa.Function)
 	runtimePkg := p.Program.ImportedPackage("runtime")
 	mathPkg := p.Program.ImportedPackage("math")
 	p.GetFunction(main).flag = true
 	worklist := []*ssa.Function{main}
 	for _, f := range p.Functions {
-		if f.exported || f.Synthetic == "package initializer" || f.Pkg == runtimePkg || (f.Pkg == mathPkg && f.Pkg != nil) {
+		if f.exported || f.Synthetic == "package initializer" || f.Pkg == runtimePkg || (f.Pkg == mathPkg && f.Pkg != nil) || strings.HasPrefix(f.linkName, "runtime.") {
 			if f.flag {
 				continue
 			}
@@ -10,6 +10,7 @@ import (
 	"go/types"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"text/template"
@@ -23,6 +24,8 @@ type Program struct {
 	Build        *build.Context
 	OverlayBuild *build.Context
 	OverlayPath  func(path string) string
+	Generated    map[string][]byte // source of compiler-generated packages, by import path
+	genFiles     map[string][]byte // contents of generated files, by path
 	Packages     map[string]*Package
 	sorted       []*Package
 	fset         *token.FileSet
@@ -31,6 +34,8 @@ type Program struct {
 	TINYGOROOT   string // root of the TinyGo installation or root of the source code
 	CFlags       []string
 	ClangHeaders string
+	TestFormat   string // output format of a test binary, see testing.M
+	TestBench    string // regular expression of the benchmarks to run in a test binary
 }
 
 // Package holds a loaded package, its imports, and its parsed files.
@@ -51,6 +56,10 @@ func (p *Program) Import(path, srcDir string) (*Package, error) {
 		p.Packages = make(map[string]*Package)
 	}
 
+	if src, ok := p.Generated[path]; ok {
+		return p.importGenerated(path, src)
+	}
+
 	// Load this package.
 	ctx := p.Build
 	if newPath := p.OverlayPath(path); newPath != "" {
@@ -110,6 +119,39 @@ func (p *Program) ImportFile(path string) (*Package, error) {
 	return pkg, nil
 }
 
+// importGenerated loads a package that does not exist on disk but is
+// generated by the compiler, such as the runtime configuration. It consists of
+// a single file that is parsed from src.
+func (p *Program) importGenerated(path string, src []byte) (*Package, error) {
+	if existingPkg, ok := p.Packages[path]; ok {
+		return existingPkg, nil
+	}
+	dir := filepath.Join(p.TINYGOROOT, "src", filepath.FromSlash(path))
+	name := "zgenerated.go"
+	if p.genFiles == nil {
+		p.genFiles = make(map[string][]byte)
+	}
+	p.genFiles[filepath.Join(dir, name)] = src
+	file, err := p.parseFile(filepath.Join(dir, name), parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	buildPkg := &build.Package{
+		Dir:        dir,
+		Name:       file.Name.Name,
+		ImportPath: path,
+		Goroot:     true,
+		GoFiles:    []string{name},
+	}
+	for _, importSpec := range file.Imports {
+		buildPkg.Imports = append(buildPkg.Imports, importSpec.Path.Value[1:len(importSpec.Path.Value)-1])
+	}
+	p.sorted = nil // invalidate the sorted order of packages
+	pkg := p.newPackage(buildPkg)
+	p.Packages[path] = pkg
+	return pkg, nil
+}
+
 // newPackage instantiates a new *Package object with initialized members.
 func (p *Program) newPackage(pkg *build.Package) *Package {
 	return &Package{
@@ -228,7 +270,7 @@ func (p *Program) Parse(compileTestBinary bool) error {
 }
 
 func (p *Program) SwapTestMain() error {
-	var tests []string
+	var tests, benchmarks []string
 
 	isTestFunc := func(f *ast.FuncDecl) bool {
 		// TODO: improve signature check
@@ -237,6 +279,15 @@ func (p *Program) SwapTestMain() error {
 		}
 		return false
 	}
+	// Benchmarks are only run when selected, like with go test -bench.
+	var bench *regexp.Regexp
+	if p.TestBench != "" {
+		var err error
+		bench, err = regexp.Compile(p.TestBench)
+		if err != nil {
+			return err
+		}
+	}
 	mainPkg := p.Packages[p.mainPkg]
 	for _, f := range mainPkg.Files {
 		for i, d := range f.Decls {
@@ -245,6 +296,9 @@ func (p *Program) SwapTestMain() error {
 				if isTestFunc(v) {
 					tests = append(tests, v.Name.Name)
 				}
+				if bench != nil && strings.HasPrefix(v.Name.Name, "Benchmark") && bench.MatchString(v.Name.Name) {
+					benchmarks = append(benchmarks, v.Name.Name)
+				}
 				if v.Name.Name == "main" {
 					// Remove main
 					if len(f.Decls) == 1 {
@@ -272,6 +326,12 @@ func main () {
 			{Name: "{{.}}", Func: {{.}}},
 {{end}}
 		},
+		Benchmarks: []testing.BenchmarkToCall{
+{{range .BenchmarkFunctions}}
+			{Name: "{{.}}", Func: {{.}}},
+{{end}}
+		},
+		Format: "{{.Format}}",
 	}
 
 	testing.TestMain(m)
@@ -280,9 +340,13 @@ func main () {
 	tmpl := template.Must(template.New("testmain").Parse(mainBody))
 	b := bytes.Buffer{}
 	tmplData := struct {
-		TestFunctions []string
+		TestFunctions      []string
+		BenchmarkFunctions []string
+		Format             string
 	}{
-		TestFunctions: tests,
+		TestFunctions:      tests,
+		BenchmarkFunctions: benchmarks,
+		Format:             p.TestFormat,
 	}
 
 	err := tmpl.Execute(&b, tmplData)
@@ -310,18 +374,23 @@ func (p *Program) parseFile(path string, mode parser.Mode) (*ast.File, error) {
 		p.fset = token.NewFileSet()
 	}
 
-	rd, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer rd.Close()
 	relpath := path
 	if filepath.IsAbs(path) {
+		var err error
 		relpath, err = filepath.Rel(p.Dir, path)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if src, ok := p.genFiles[path]; ok {
+		return parser.ParseFile(p.fset, relpath, src, mode)
+	}
+
+	rd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
 	return parser.ParseFile(p.fset, relpath, rd, mode)
 }
 
@@ -376,6 +445,9 @@ func (p *Package) Check() error {
 		}
 		return Errors{p, typeErrors}
 	}
+	if errs := p.checkLanguageVersion(); len(errs) != 0 {
+		return Errors{p, errs}
+	}
 	p.Pkg = typesPkg
 	return nil
 }
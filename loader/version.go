@@ -0,0 +1,146 @@
+package loader
+
+// This file implements language version checks. Code in a module may only use
+// language features that are available in the Go version declared by the go
+// directive in the go.mod file of that module, even if the Go toolchain in
+// GOROOT is newer. This mirrors the -lang flag of the gc compiler.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// goDirective matches the go directive in a go.mod file.
+var goDirective = regexp.MustCompile(`(?m)^go\s+1\.(\d+)\s*(//.*)?$`)
+
+// ModuleGoVersion returns the minor Go version declared by the go directive in
+// the go.mod file of the module that contains the given directory. If there is
+// no go.mod file or it has no go directive, 0 is returned.
+func ModuleGoVersion(dir string) (minor int, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			matches := goDirective.FindSubmatch(data)
+			if matches == nil {
+				return 0, nil
+			}
+			return strconv.Atoi(string(matches[1]))
+		}
+		if !os.IsNotExist(err) {
+			return 0, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return 0, nil // reached the root, no go.mod found
+		}
+		dir = parent
+	}
+}
+
+// checkLanguageVersion returns an error for every language feature used in
+// this package that is not available in the Go version of the module it is
+// part of. It must be called after type checking, because some checks need
+// type information.
+func (p *Package) checkLanguageVersion() []error {
+	if p.Goroot {
+		// Standard library packages are always compiled with the language
+		// version of GOROOT.
+		return nil
+	}
+	goVersion, err := ModuleGoVersion(p.Package.Dir)
+	if err != nil {
+		return []error{err}
+	}
+	if goVersion == 0 {
+		// Not part of a module, or the module doesn't declare a Go version.
+		return nil
+	}
+	var errs []error
+	report := func(pos token.Pos, feature string, minor int) {
+		if goVersion >= minor {
+			return
+		}
+		errs = append(errs, types.Error{
+			Fset: p.fset,
+			Pos:  pos,
+			Msg:  fmt.Sprintf("%s requires go1.%d or later (-lang was set to go1.%d; check go.mod)", feature, minor, goVersion),
+		})
+	}
+	for _, file := range p.Files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.BasicLit:
+				if n.Kind != token.INT && n.Kind != token.FLOAT && n.Kind != token.IMAG {
+					break
+				}
+				lit := strings.ToLower(n.Value)
+				if strings.Contains(lit, "_") {
+					report(n.Pos(), "'_' in numeric literal", 13)
+				} else if strings.HasPrefix(lit, "0b") {
+					report(n.Pos(), "binary literal", 13)
+				} else if strings.HasPrefix(lit, "0o") {
+					report(n.Pos(), "0o/0O-style octal literal", 13)
+				} else if strings.HasPrefix(lit, "0x") && n.Kind != token.INT {
+					report(n.Pos(), "hexadecimal floating-point literal", 13)
+				}
+			case *ast.BinaryExpr:
+				if n.Op == token.SHL || n.Op == token.SHR {
+					p.checkShiftCount(n.Y, report)
+				}
+			case *ast.AssignStmt:
+				if n.Tok == token.SHL_ASSIGN || n.Tok == token.SHR_ASSIGN {
+					p.checkShiftCount(n.Rhs[0], report)
+				}
+			case *ast.CallExpr:
+				p.checkUnsafeCall(n, report)
+			}
+			return true
+		})
+	}
+	return errs
+}
+
+// checkShiftCount reports signed (non-constant) shift counts, which were not
+// allowed before Go 1.13.
+func (p *Package) checkShiftCount(count ast.Expr, report func(token.Pos, string, int)) {
+	tv, ok := p.Info.Types[count]
+	if !ok || tv.Value != nil {
+		return // constant shift counts were always allowed
+	}
+	if basic, ok := tv.Type.Underlying().(*types.Basic); ok && basic.Info()&types.IsUnsigned == 0 {
+		report(count.Pos(), "signed shift count", 13)
+	}
+}
+
+// checkUnsafeCall reports calls to the unsafe.Add and unsafe.Slice builtins,
+// which were added in Go 1.17, and to unsafe.String, which was added in Go
+// 1.20. The type checker only knows about them when TinyGo itself is built
+// with such a Go version.
+func (p *Package) checkUnsafeCall(call *ast.CallExpr, report func(token.Pos, string, int)) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+	builtin, ok := p.Info.Uses[sel.Sel].(*types.Builtin)
+	if !ok || builtin.Pkg() != types.Unsafe {
+		return
+	}
+	switch builtin.Name() {
+	case "Add", "Slice":
+		report(call.Pos(), "unsafe."+builtin.Name(), 17)
+	case "String":
+		report(call.Pos(), "unsafe.String", 20)
+	}
+}
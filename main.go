@@ -1,6 +1,8 @@
 package main
 
 import (
+	"debug/elf"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -11,14 +13,17 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/tinygo-org/tinygo/compiler"
 	"github.com/tinygo-org/tinygo/interp"
 	"github.com/tinygo-org/tinygo/loader"
+	"github.com/tinygo-org/tinygo/stacksize"
 )
 
 // commandError is an error type to wrap os/exec.Command errors. This provides
@@ -43,6 +48,14 @@ func (e *multiError) Error() string {
 	return e.Errs[0].Error()
 }
 
+// The range of Go versions (go1.x) whose standard library is known to compile
+// with TinyGo. Older versions are rejected, newer versions only cause a
+// warning because they usually work.
+const (
+	minGoMinor = 11
+	maxGoMinor = 12
+)
+
 type BuildConfig struct {
 	opt           string
 	gc            string
@@ -51,12 +64,37 @@ type BuildConfig struct {
 	dumpSSA       bool
 	debug         bool
 	printSizes    string
+	whyLive       string
+	sizeReport    string // JSON file with the size of every package and symbol
 	cFlags        []string
 	ldFlags       []string
 	tags          string
 	wasmAbi       string
+	witFile       string
 	heapSize      int64
 	testConfig    compiler.TestConfig
+	printAllocs   *regexp.Regexp
+	printAsync    bool
+	isrCheck      string
+	noRecursion   bool
+	panicTrace    bool
+	nilCheck      string
+	overflowCheck bool
+	deadline      string
+	strict        bool
+	strictReport  string
+	scrubMap      string
+	profile       bool
+	signKey       string
+	deltaFrom     string // old image to create a delta patch against
+	incremental   bool   // only flash the parts of the image that changed
+	programmer    string
+	monitor       bool // open a serial console after flashing
+	baudRate      int
+	testTimeout   time.Duration
+	recovery      string // package of the recovery app, if any
+	recoveryAt    uint32 // offset of the recovery app in the flash
+	isRecovery    bool   // building the recovery app itself
 }
 
 // Helper function for Compiler object.
@@ -64,6 +102,20 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 	if config.gc == "" && spec.GC != "" {
 		config.gc = spec.GC
 	}
+	if config.panicTrace || config.nilCheck == "trap" {
+		isCortexM := false
+		for _, tag := range spec.BuildTags {
+			if tag == "cortexm" {
+				isCortexM = true
+			}
+		}
+		if !isCortexM && config.panicTrace {
+			return errors.New("-panic-trace is only supported on Cortex-M targets")
+		}
+		if !isCortexM {
+			return errors.New("-nil-check=trap is only supported on Cortex-M targets")
+		}
+	}
 
 	root := sourceDir()
 
@@ -78,6 +130,14 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 	for _, flag := range spec.LDFlags {
 		ldflags = append(ldflags, strings.Replace(flag, "{root}", root, -1))
 	}
+	ldflags = append(ldflags, slotLDFlags(config)...)
+
+	if config.recovery != "" {
+		err := checkRecovery(filepath.Ext(outpath), spec, config)
+		if err != nil {
+			return err
+		}
+	}
 
 	goroot := getGoroot()
 	if goroot == "" {
@@ -88,15 +148,57 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 	if err != nil {
 		return fmt.Errorf("could not read version from GOROOT (%v): %v", goroot, err)
 	}
-	if major != 1 {
-		return fmt.Errorf("expected major version 1, got go%d.%d", major, minor)
+	if major != 1 || minor < minGoMinor {
+		return fmt.Errorf("requires go version 1.%d through 1.%d, got go%d.%d", minGoMinor, maxGoMinor, major, minor)
+	}
+	if minor > maxGoMinor {
+		fmt.Fprintf(os.Stderr, "warning: go%d.%d is newer than the Go versions TinyGo was tested with (1.%d through 1.%d)\n", major, minor, minGoMinor, maxGoMinor)
+	}
+	// Language features are gated by the go directive in the go.mod file of
+	// each module (see the loader). The main module must not require a newer
+	// Go version than the one in GOROOT.
+	moduleDir := "."
+	if info, err := os.Stat(pkgName); err == nil {
+		moduleDir = pkgName
+		if !info.IsDir() {
+			moduleDir = filepath.Dir(pkgName)
+		}
+	}
+	goVersion, err := loader.ModuleGoVersion(moduleDir)
+	if err != nil {
+		return fmt.Errorf("could not read go.mod: %v", err)
+	}
+	if goVersion > minor {
+		return fmt.Errorf("module requires go1.%d, but GOROOT (%s) is go%d.%d", goVersion, goroot, major, minor)
 	}
 	for i := 1; i <= minor; i++ {
 		tags = append(tags, fmt.Sprintf("go1.%d", i))
 	}
+	if spec.Filesystem != "" {
+		tags = append(tags, "filesystem."+spec.Filesystem)
+	}
 	if extraTags := strings.Fields(config.tags); len(extraTags) != 0 {
 		tags = append(tags, extraTags...)
 	}
+	var heapRegions []compiler.HeapRegion
+	for _, region := range spec.HeapRegions {
+		start, err := parseSize(region.Start)
+		if err != nil {
+			return fmt.Errorf("invalid heap region start %q: %v", region.Start, err)
+		}
+		size, err := parseSize(region.Size)
+		if err != nil {
+			return fmt.Errorf("invalid heap region size %q: %v", region.Size, err)
+		}
+		minObject := int64(256)
+		if region.MinObject != "" {
+			minObject, err = parseSize(region.MinObject)
+			if err != nil {
+				return fmt.Errorf("invalid heap region min-object-size %q: %v", region.MinObject, err)
+			}
+		}
+		heapRegions = append(heapRegions, compiler.HeapRegion{Start: uint64(start), Size: uint64(size), MinObject: uint64(minObject)})
+	}
 	compilerConfig := compiler.Config{
 		Triple:        spec.Triple,
 		CPU:           spec.CPU,
@@ -104,12 +206,25 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		GOOS:          spec.GOOS,
 		GOARCH:        spec.GOARCH,
 		GC:            config.gc,
+		HeapRegions:   heapRegions,
 		PanicStrategy: config.panicStrategy,
 		CFlags:        cflags,
 		LDFlags:       ldflags,
 		ClangHeaders:  getClangHeaderPath(root),
 		Debug:         config.debug,
 		DumpSSA:       config.dumpSSA,
+		PrintAllocs:   config.printAllocs,
+		PrintAsync:    config.printAsync,
+		ISRCheck:      config.isrCheck,
+		NoRecursion:   config.noRecursion,
+		PanicTrace:    config.panicTrace,
+		NilCheck:      config.nilCheck,
+		OverflowCheck: config.overflowCheck,
+		Deadline:      config.deadline,
+		Strict:        config.strict,
+		StrictReport:  config.strictReport,
+		ScrubMap:      config.scrubMap,
+		Profile:       config.profile,
 		TINYGOROOT:    root,
 		GOROOT:        goroot,
 		GOPATH:        getGopath(),
@@ -137,68 +252,31 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		return errors.New("verification error after IR construction")
 	}
 
-	err = interp.Run(c.Module(), c.TargetData(), config.dumpSSA)
-	if err != nil {
-		return err
-	}
-	if err := c.Verify(); err != nil {
-		return errors.New("verification error after interpreting runtime.initAll")
-	}
-
-	if spec.GOOS != "darwin" {
-		c.ApplyFunctionSections() // -ffunction-sections
-	}
-	if err := c.Verify(); err != nil {
-		return errors.New("verification error after applying function sections")
-	}
-
-	// Browsers cannot handle external functions that have type i64 because it
-	// cannot be represented exactly in JavaScript (JS only has doubles). To
-	// keep functions interoperable, pass int64 types as pointers to
-	// stack-allocated values.
-	// Use -wasm-abi=generic to disable this behaviour.
-	if config.wasmAbi == "js" && strings.HasPrefix(spec.Triple, "wasm") {
-		err := c.ExternalInt64AsPtr()
+	// Reuse the object file of an earlier build with exactly the same IR and
+	// options, if there is one. Interpreting and optimizing the IR and
+	// generating machine code take most of the build time.
+	var objectKey, cachedObject string
+	if isObjectCacheable(outpath, config) {
+		objectKey, err = objectCacheKey(c.IR(), spec, config)
 		if err != nil {
 			return err
 		}
-		if err := c.Verify(); err != nil {
-			return errors.New("verification error after running the wasm i64 hack")
-		}
-	}
-
-	// Optimization levels here are roughly the same as Clang, but probably not
-	// exactly.
-	switch config.opt {
-	case "none:", "0":
-		err = c.Optimize(0, 0, 0) // -O0
-	case "1":
-		err = c.Optimize(1, 0, 0) // -O1
-	case "2":
-		err = c.Optimize(2, 0, 225) // -O2
-	case "s":
-		err = c.Optimize(2, 1, 225) // -Os
-	case "z":
-		err = c.Optimize(2, 2, 5) // -Oz, default
-	default:
-		err = errors.New("unknown optimization level: -opt=" + config.opt)
+		cachedObject = cacheLoadObject(objectKey)
 	}
-	if err != nil {
-		return err
-	}
-	if err := c.Verify(); err != nil {
-		return errors.New("verification failure after LLVM optimization passes")
+	if cachedObject == "" {
+		if err := optimizeModule(c, spec, config); err != nil {
+			return err
+		}
 	}
 
-	// On the AVR, pointers can point either to flash or to RAM, but we don't
-	// know. As a temporary fix, load all global variables in RAM.
-	// In the future, there should be a compiler pass that determines which
-	// pointers are flash and which are in RAM so that pointers can have a
-	// correct address space parameter (address space 1 is for flash).
-	if strings.HasPrefix(spec.Triple, "avr") {
-		c.NonConstGlobals()
-		if err := c.Verify(); err != nil {
-			return errors.New("verification error after making all globals non-constant on AVR")
+	if config.whyLive != "" {
+		chain, err := c.WhyLive(config.whyLive)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s is kept because of these references:\n", config.whyLive)
+		for i, name := range chain {
+			fmt.Printf("%s%s\n", strings.Repeat("  ", i+1), name)
 		}
 	}
 
@@ -221,11 +299,17 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		}
 		defer os.RemoveAll(dir)
 
-		// Write the object file.
-		objfile := filepath.Join(dir, "main.o")
-		err = c.EmitObject(objfile)
-		if err != nil {
-			return err
+		// Write the object file, or use the one from the cache.
+		objfile := cachedObject
+		if objfile == "" {
+			objfile = filepath.Join(dir, "main.o")
+			err = c.EmitObject(objfile)
+			if err != nil {
+				return err
+			}
+			if objectKey != "" {
+				cacheStoreObject(objfile, objectKey)
+			}
 		}
 
 		// Load builtins library from the cache, possibly compiling it on the
@@ -245,7 +329,16 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		if spec.RTLib == "compiler-rt" {
 			ldflags = append(ldflags, librt)
 		}
-		if spec.GOARCH == "wasm" {
+
+		// Link the CMSIS-DSP library if the dsp package is used.
+		if usesCMSISDSP(c.Packages()) {
+			libdsp, err := loadCMSISDSP(spec.Triple)
+			if err != nil {
+				return err
+			}
+			ldflags = append(ldflags, libdsp)
+		}
+		if strings.HasPrefix(spec.Triple, "wasm") {
 			// Round heap size to next multiple of 65536 (the WebAssembly page
 			// size).
 			heapSize := (config.heapSize + (65536 - 1)) &^ (65536 - 1)
@@ -290,15 +383,24 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 			return &commandError{"failed to link", executable, err}
 		}
 
-		if config.printSizes == "short" || config.printSizes == "full" {
+		if config.printSizes == "short" || config.printSizes == "full" || config.sizeReport != "" {
 			sizes, err := Sizes(executable)
 			if err != nil {
 				return err
 			}
+			if config.sizeReport != "" {
+				data, err := json.MarshalIndent(sizes, "", "\t")
+				if err != nil {
+					return err
+				}
+				if err := ioutil.WriteFile(config.sizeReport, append(data, '\n'), 0666); err != nil {
+					return err
+				}
+			}
 			if config.printSizes == "short" {
 				fmt.Printf("   code    data     bss |   flash     ram\n")
 				fmt.Printf("%7d %7d %7d | %7d %7d\n", sizes.Code, sizes.Data, sizes.BSS, sizes.Code+sizes.Data, sizes.Data+sizes.BSS)
-			} else {
+			} else if config.printSizes == "full" {
 				fmt.Printf("   code  rodata    data     bss |   flash     ram | package\n")
 				for _, name := range sizes.SortedPackageNames() {
 					pkgSize := sizes.Packages[name]
@@ -306,20 +408,48 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 				}
 				fmt.Printf("%7d %7d %7d %7d | %7d %7d | (sum)\n", sizes.Sum.Code, sizes.Sum.ROData, sizes.Sum.Data, sizes.Sum.BSS, sizes.Sum.Flash(), sizes.Sum.RAM())
 				fmt.Printf("%7d       - %7d %7d | %7d %7d | (all)\n", sizes.Code, sizes.Data, sizes.BSS, sizes.Code+sizes.Data, sizes.Data+sizes.BSS)
+				fmt.Printf("\n   code  rodata    data     bss |   flash     ram | symbol\n")
+				for _, sym := range sizes.SortedSymbols() {
+					if sym.Flash()+sym.RAM() == 0 {
+						continue
+					}
+					fmt.Printf("%7d %7d %7d %7d | %7d %7d | %s\n", sym.Code, sym.ROData, sym.Data, sym.BSS, sym.Flash(), sym.RAM(), sym.Name)
+				}
+			}
+		}
+
+		// Fill in the hash (and signature) of the image, if the target has a
+		// header field for it.
+		if err := embedImageHash(executable, spec, config); err != nil {
+			return err
+		}
+
+		// Add the metadata of the component model to WebAssembly modules.
+		if err := makeComponent(executable, spec, config); err != nil {
+			return err
+		}
+
+		// Build the recovery app, which is combined with the main app below.
+		images := []string{executable}
+		if config.recovery != "" {
+			recovery, err := buildRecovery(dir, spec, config)
+			if err != nil {
+				return err
 			}
+			images = append(images, recovery)
 		}
 
 		// Get an Intel .hex file or .bin file from the .elf file.
 		if outext == ".hex" || outext == ".bin" {
 			tmppath = filepath.Join(dir, "main"+outext)
-			err := Objcopy(executable, tmppath)
+			err := Objcopy(images, tmppath)
 			if err != nil {
 				return err
 			}
 		} else if outext == ".uf2" {
 			// Get UF2 from the .elf file.
 			tmppath = filepath.Join(dir, "main"+outext)
-			err := ConvertELFFileToUF2File(executable, tmppath)
+			err := ConvertELFFileToUF2File(images, tmppath)
 			if err != nil {
 				return err
 			}
@@ -328,6 +458,101 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 	}
 }
 
+// optimizeModule runs the interpreter for package initializers and the
+// optimization passes over the IR of the program.
+func optimizeModule(c *compiler.Compiler, spec *TargetSpec, config *BuildConfig) error {
+	var registers []interp.RegisterSpec
+	for _, register := range spec.Registers {
+		address, err := strconv.ParseUint(register.Address, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid address %q of register %s: %v", register.Address, register.Name, err)
+		}
+		var reset uint64
+		if register.Reset != "" {
+			reset, err = strconv.ParseUint(register.Reset, 0, 64)
+			if err != nil {
+				return fmt.Errorf("invalid reset value %q of register %s: %v", register.Reset, register.Name, err)
+			}
+		}
+		switch register.Kind {
+		case "", "memory", "write-only":
+		default:
+			return fmt.Errorf("invalid kind %q of register %s", register.Kind, register.Name)
+		}
+		registers = append(registers, interp.RegisterSpec{
+			Address: address,
+			Reset:   reset,
+			Memory:  register.Kind == "memory",
+		})
+	}
+	err := interp.Run(c.Module(), c.TargetData(), interp.NewPeripherals(registers), config.dumpSSA)
+	if err != nil {
+		return err
+	}
+	if err := c.Verify(); err != nil {
+		return errors.New("verification error after interpreting runtime.initAll")
+	}
+
+	if spec.GOOS != "darwin" {
+		c.ApplyFunctionSections() // -ffunction-sections
+	}
+	if err := c.Verify(); err != nil {
+		return errors.New("verification error after applying function sections")
+	}
+
+	// Browsers cannot handle external functions that have type i64 because it
+	// cannot be represented exactly in JavaScript (JS only has doubles). To
+	// keep functions interoperable, pass int64 types as pointers to
+	// stack-allocated values.
+	// Use -wasm-abi=generic to disable this behaviour. WASI hosts are not
+	// JavaScript, so it doesn't apply to them.
+	if config.wasmAbi == "js" && strings.HasPrefix(spec.Triple, "wasm") && spec.GOOS == "js" {
+		err := c.ExternalInt64AsPtr()
+		if err != nil {
+			return err
+		}
+		if err := c.Verify(); err != nil {
+			return errors.New("verification error after running the wasm i64 hack")
+		}
+	}
+
+	// Optimization levels here are roughly the same as Clang, but probably not
+	// exactly.
+	switch config.opt {
+	case "none:", "0":
+		err = c.Optimize(0, 0, 0) // -O0
+	case "1":
+		err = c.Optimize(1, 0, 0) // -O1
+	case "2":
+		err = c.Optimize(2, 0, 225) // -O2
+	case "s":
+		err = c.Optimize(2, 1, 225) // -Os
+	case "z":
+		err = c.Optimize(2, 2, 5) // -Oz, default
+	default:
+		err = errors.New("unknown optimization level: -opt=" + config.opt)
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.Verify(); err != nil {
+		return errors.New("verification failure after LLVM optimization passes")
+	}
+
+	// On the AVR, pointers can point either to flash or to RAM, but we don't
+	// know. As a temporary fix, load all global variables in RAM.
+	// In the future, there should be a compiler pass that determines which
+	// pointers are flash and which are in RAM so that pointers can have a
+	// correct address space parameter (address space 1 is for flash).
+	if strings.HasPrefix(spec.Triple, "avr") {
+		c.NonConstGlobals()
+		if err := c.Verify(); err != nil {
+			return errors.New("verification error after making all globals non-constant on AVR")
+		}
+	}
+	return nil
+}
+
 func Build(pkgName, outpath, target string, config *BuildConfig) error {
 	spec, err := LoadTarget(target)
 	if err != nil {
@@ -335,6 +560,19 @@ func Build(pkgName, outpath, target string, config *BuildConfig) error {
 	}
 
 	return Compile(pkgName, outpath, spec, config, func(tmppath string) error {
+		if config.deltaFrom != "" {
+			// The patch is made from the flashed image, which is extracted
+			// from the ELF file next to the output file.
+			_, data, err := ExtractROM(filepath.Join(filepath.Dir(tmppath), "main"))
+			if err != nil {
+				return err
+			}
+			size, err := writeDelta(config.deltaFrom, data, outpath+".delta")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("delta patch: %d bytes (new image: %d bytes)\n", size, len(data))
+		}
 		if err := os.Rename(tmppath, outpath); err != nil {
 			// Moving failed. Do a file copy.
 			inf, err := os.Open(tmppath)
@@ -362,7 +600,7 @@ func Build(pkgName, outpath, target string, config *BuildConfig) error {
 	})
 }
 
-func Test(pkgName, target string, config *BuildConfig) error {
+func Test(pkgName, target, port string, config *BuildConfig) error {
 	spec, err := LoadTarget(target)
 	if err != nil {
 		return err
@@ -370,6 +608,15 @@ func Test(pkgName, target string, config *BuildConfig) error {
 
 	spec.BuildTags = append(spec.BuildTags, "test")
 	config.testConfig.CompileTestBinary = true
+	if len(spec.Emulator) == 0 && (spec.Flasher != "" || spec.Bootloader != "" || config.programmer != "") {
+		// A board: flash the test binary and read the results from the
+		// serial port (see collectTestResults).
+		err := flash(pkgName, port, spec, config)
+		if err == errTestsFailed {
+			os.Exit(1)
+		}
+		return err
+	}
 	return Compile(pkgName, ".elf", spec, config, func(tmppath string) error {
 		cmd := exec.Command(tmppath)
 		cmd.Stdout = os.Stdout
@@ -394,6 +641,24 @@ func Flash(pkgName, target, port string, config *BuildConfig) error {
 	if err != nil {
 		return err
 	}
+	return flash(pkgName, port, spec, config)
+}
+
+func flash(pkgName, port string, spec *TargetSpec, config *BuildConfig) error {
+	switch config.programmer {
+	case "":
+		// Use the flash command of the target, or the built-in implementation
+		// of its bootloader if the command is not installed.
+		if spec.Bootloader != "" && !flashCommandInstalled(spec) {
+			return FlashBootloader(spec.Bootloader, pkgName, port, spec, config)
+		}
+	case "cmsis-dap":
+		return FlashCMSISDAP(pkgName, port, spec, config)
+	case "bossa", "dfu", "picoboot", "uf2":
+		return FlashBootloader(config.programmer, pkgName, port, spec, config)
+	default:
+		return errors.New("unknown programmer: " + config.programmer)
+	}
 
 	// determine the type of file to compile
 	var fileExt string
@@ -420,7 +685,13 @@ func Flash(pkgName, target, port string, config *BuildConfig) error {
 		flashCmd := spec.Flasher
 		fileToken := "{" + fileExt[1:] + "}"
 		flashCmd = strings.Replace(flashCmd, fileToken, tmppath, -1)
-		flashCmd = strings.Replace(flashCmd, "{port}", port, -1)
+		if strings.Contains(flashCmd, "{port}") {
+			port, err := findSerialPort(port, spec)
+			if err != nil {
+				return err
+			}
+			flashCmd = strings.Replace(flashCmd, "{port}", port, -1)
+		}
 
 		// Execute the command.
 		cmd := exec.Command("/bin/sh", "-c", flashCmd)
@@ -431,10 +702,23 @@ func Flash(pkgName, target, port string, config *BuildConfig) error {
 		if err != nil {
 			return &commandError{"failed to flash", tmppath, err}
 		}
-		return nil
+		// The ELF file is always next to the flashed file.
+		return afterFlash(port, spec, filepath.Join(filepath.Dir(tmppath), "main"), config)
 	})
 }
 
+// afterFlash is called after a program has been flashed to a board, to open a
+// serial console (-monitor) or to read the results of tinygo test.
+func afterFlash(port string, spec *TargetSpec, executable string, config *BuildConfig) error {
+	if config.testConfig.CompileTestBinary {
+		return collectTestResults(port, spec, executable, config)
+	}
+	if config.monitor {
+		return Monitor(port, spec, executable, config.baudRate)
+	}
+	return nil
+}
+
 // Flash a program on a microcontroller and drop into a GDB shell.
 //
 // Note: this command is expected to execute just before exiting, as it
@@ -450,7 +734,34 @@ func FlashGDB(pkgName, target, port string, ocdOutput bool, config *BuildConfig)
 	}
 
 	return Compile(pkgName, "", spec, config, func(tmppath string) error {
-		if len(spec.OCDDaemon) != 0 {
+		if config.programmer == "cmsis-dap" {
+			// Use the built-in GDB server instead of a debugging daemon.
+			server, err := startGDBServer(port, tmppath, spec)
+			if err != nil {
+				return err
+			}
+			defer server.Close()
+		} else if len(spec.Emulator) != 0 && strings.HasPrefix(spec.Emulator[0], "qemu-system-") {
+			// Run the program in QEMU, which provides a GDB server on port
+			// 1234. The -S flag makes it wait for GDB before starting the
+			// program. Output (UART and semihosting) goes to the terminal.
+			args := append(spec.Emulator[1:len(spec.Emulator):len(spec.Emulator)], tmppath, "-S", "-gdb", "tcp::1234")
+			emulator := exec.Command(spec.Emulator[0], args...)
+			emulator.Stdout = os.Stdout
+			emulator.Stderr = os.Stderr
+			// Like the debugging daemon below, QEMU must not receive Ctrl-C.
+			emulator.SysProcAttr = &syscall.SysProcAttr{
+				Setpgid: true,
+				Pgid:    0,
+			}
+			if err := emulator.Start(); err != nil {
+				return &commandError{"failed to run emulator with", tmppath, err}
+			}
+			defer func() {
+				emulator.Process.Kill()
+				emulator.Wait()
+			}()
+		} else if len(spec.OCDDaemon) != 0 {
 			// We need a separate debugging daemon for on-chip debugging.
 			daemon := exec.Command(spec.OCDDaemon[0], spec.OCDDaemon[1:]...)
 			if ocdOutput {
@@ -547,6 +858,43 @@ func Run(pkgName, target string, config *BuildConfig) error {
 	})
 }
 
+// StackSize compiles the given program and prints the worst-case stack usage
+// of each entry point (reset handler, interrupt handlers and goroutines).
+func StackSize(pkgName, target string, config *BuildConfig) error {
+	spec, err := LoadTarget(target)
+	if err != nil {
+		return err
+	}
+
+	return Compile(pkgName, ".elf", spec, config, func(tmppath string) error {
+		f, err := elf.Open(tmppath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		nodes, err := stacksize.CallGraph(f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-40s %s\n", "function", "stack usage (in bytes)")
+		for _, name := range stacksize.EntryPoints(nodes) {
+			size, sizeType, culprit := nodes[name].StackSize()
+			switch sizeType {
+			case stacksize.Bounded:
+				fmt.Printf("%-40s %d\n", name, size)
+			case stacksize.Unknown:
+				fmt.Printf("%-40s unknown, %s does not have stack frame information\n", name, culprit.Name())
+			case stacksize.Recursive:
+				fmt.Printf("%-40s recursive, %s may call itself\n", name, culprit.Name())
+			case stacksize.IndirectCall:
+				fmt.Printf("%-40s unknown, %s calls a function pointer\n", name, culprit.Name())
+			}
+		}
+		return nil
+	})
+}
+
 // parseSize converts a human-readable size (with k/m/g suffix) into a plain
 // number.
 func parseSize(s string) (int64, error) {
@@ -581,6 +929,9 @@ func usage() {
 	fmt.Fprintln(os.Stderr, "  test:  test packages")
 	fmt.Fprintln(os.Stderr, "  flash: compile and flash to the device")
 	fmt.Fprintln(os.Stderr, "  gdb:   run/flash and immediately enter GDB")
+	fmt.Fprintln(os.Stderr, "  monitor: open a serial console to a board")
+	fmt.Fprintln(os.Stderr, "  addr2line: convert addresses in a binary to source locations")
+	fmt.Fprintln(os.Stderr, "  stacksize: print the worst-case stack usage of each entry point")
 	fmt.Fprintln(os.Stderr, "  clean: empty cache directory ("+cacheDir()+")")
 	fmt.Fprintln(os.Stderr, "  help:  print this help text")
 	fmt.Fprintln(os.Stderr, "\nflags:")
@@ -606,6 +957,10 @@ func handleCompilerError(err error) {
 			for _, err := range err.Errs {
 				fmt.Fprintln(os.Stderr, err)
 			}
+		case *compiler.MultiError:
+			for _, err := range err.Errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
 		default:
 			fmt.Fprintln(os.Stderr, "error:", err)
 		}
@@ -616,19 +971,46 @@ func handleCompilerError(err error) {
 func main() {
 	outpath := flag.String("o", "", "output filename")
 	opt := flag.String("opt", "z", "optimization level: 0, 1, 2, s, z")
-	gc := flag.String("gc", "", "garbage collector to use (none, leaking, conservative)")
+	gc := flag.String("gc", "", "garbage collector to use (none, leaking, conservative, custom)")
 	panicStrategy := flag.String("panic", "print", "panic strategy (abort, trap)")
+	panicTrace := flag.Bool("panic-trace", false, "print a call trace on panics, to be converted with tinygo addr2line (Cortex-M only)")
+	checks := flag.String("check", "", "comma-separated list of extra runtime checks: overflow (panic on signed integer overflow)")
+	deadline := flag.String("deadline", "", "what happens when a function exceeds its //go:deadline: panic, log or off (default panic, or off with -no-debug)")
+	nilCheck := flag.String("nil-check", "panic", "on a nil pointer dereference: panic, location (also print the source location) or trap (smallest, decoded by the serial monitor, Cortex-M only)")
 	printIR := flag.Bool("printir", false, "print LLVM IR")
 	dumpSSA := flag.Bool("dumpssa", false, "dump internal Go SSA")
+	printAllocs := flag.String("print-allocs", "", "regular expression of functions for which heap allocations should be printed")
+	printAsync := flag.Bool("print-async", false, "print which functions are blocking and are turned into coroutines, and why")
+	isrCheck := flag.String("interrupt-check", "warn", "report heap allocations and blocking operations in interrupt handlers (off, warn, error)")
+	noRecursion := flag.Bool("no-recursion", false, "report recursion as an error, except through functions marked //go:recursive")
+	strict := flag.Bool("strict", false, "reject heap allocations after init, unbounded loops in interrupts and interface calls in //go:hotpath functions")
+	strictReport := flag.String("strict-report", "", "write a compliance report of the -strict mode to this file")
+	scrubMap := flag.String("scrub", "", "replace constant panic messages with codes, rename internal symbols and remove debug information, and write the map to decode them to this file")
+	profile := flag.Bool("pprof", false, "instrument functions for CPU and heap profiles with the runtime/pprof package")
+	signKey := flag.String("sign-key", "", "sign the image with the Ed25519 private key in this PEM file (needs image-hash-offset in the target)")
+	incremental := flag.Bool("incremental", false, "only flash the parts of the image that changed since the last flash (built-in cmsis-dap, dfu and uf2 programmers)")
+	deltaFrom := flag.String("delta-from", "", "also write a delta patch (<output>.delta) from this .bin file of the old firmware, for the runtime/delta package")
 	tags := flag.String("tags", "", "a space-separated list of extra build tags")
 	target := flag.String("target", "", "LLVM target | .json file with TargetSpec")
 	printSize := flag.String("size", "", "print sizes (none, short, full)")
+	whyLive := flag.String("why-live", "", "print the chain of references that keeps this function or global in the program")
+	sizeReport := flag.String("size-report", "", "write the size of every package and symbol to this JSON file")
 	nodebug := flag.Bool("no-debug", false, "disable DWARF debug symbol generation")
 	ocdOutput := flag.Bool("ocd-output", false, "print OCD daemon output during debug")
-	port := flag.String("port", "/dev/ttyACM0", "flash port")
+	port := flag.String("port", "", "flash port: a device path or USB serial number (default: auto-detect)")
+	programmer := flag.String("programmer", "", "programmer to use for flashing: cmsis-dap for the built-in CMSIS-DAP driver, or bossa, dfu, picoboot or uf2 for a built-in bootloader protocol (default: the flash command of the target)")
+	recovery := flag.String("recovery", "", "package of a recovery app to link into the firmware image at -recovery-offset")
+	recoveryAt := flag.String("recovery-offset", "", "offset of the recovery app in the flash, like 0x30000")
+	monitor := flag.Bool("monitor", false, "open a serial console to the board after flashing")
+	baudRate := flag.Int("baudrate", 115200, "baud rate of the serial console")
+	listPorts := flag.Bool("list-ports", false, "list the serial ports that can be used with -port and exit")
 	cFlags := flag.String("cflags", "", "additional cflags for compiler")
 	ldFlags := flag.String("ldflags", "", "additional ldflags for linker")
-	wasmAbi := flag.String("wasm-abi", "js", "WebAssembly ABI conventions: js (no i64 params) or generic")
+	wasmAbi := flag.String("wasm-abi", "js", "WebAssembly ABI conventions: js (no i64 params), generic, or component (canonical ABI of the component model, see runtime/cabi)")
+	witFile := flag.String("wit", "", "WIT file with the world of a component, to create a component with wasm-tools (needs -wasm-abi=component)")
+	testFormat := flag.String("test-format", "text", "output format of test results: text, tap or json")
+	bench := flag.String("bench", "", "run the benchmarks that match this regular expression in tinygo test")
+	testTimeout := flag.Duration("test-timeout", time.Minute, "time after which tests on a board are aborted")
 	heapSize := flag.String("heap-size", "1M", "default heap size in bytes (only supported by WebAssembly)")
 
 	if len(os.Args) < 2 {
@@ -645,10 +1027,31 @@ func main() {
 		panicStrategy: *panicStrategy,
 		printIR:       *printIR,
 		dumpSSA:       *dumpSSA,
-		debug:         !*nodebug,
+		debug:         !*nodebug && *scrubMap == "", // debug information contains source paths
 		printSizes:    *printSize,
+		whyLive:       *whyLive,
+		sizeReport:    *sizeReport,
 		tags:          *tags,
 		wasmAbi:       *wasmAbi,
+		witFile:       *witFile,
+		printAsync:    *printAsync,
+		isrCheck:      *isrCheck,
+		noRecursion:   *noRecursion,
+		panicTrace:    *panicTrace,
+		nilCheck:      *nilCheck,
+		deadline:      *deadline,
+		strict:        *strict || *strictReport != "",
+		strictReport:  *strictReport,
+		scrubMap:      *scrubMap,
+		profile:       *profile,
+		signKey:       *signKey,
+		deltaFrom:     *deltaFrom,
+		incremental:   *incremental,
+		programmer:    *programmer,
+		monitor:       *monitor,
+		baudRate:      *baudRate,
+		testTimeout:   *testTimeout,
+		recovery:      *recovery,
 	}
 
 	if *cFlags != "" {
@@ -659,12 +1062,90 @@ func main() {
 		config.ldFlags = strings.Split(*ldFlags, " ")
 	}
 
+	if *wasmAbi != "js" && *wasmAbi != "generic" && *wasmAbi != "component" {
+		fmt.Fprintln(os.Stderr, "WebAssembly ABI must be either js, generic or component.")
+		usage()
+		os.Exit(1)
+	}
+	if *witFile != "" && *wasmAbi != "component" {
+		fmt.Fprintln(os.Stderr, "A WIT file can only be used with -wasm-abi=component.")
+		usage()
+		os.Exit(1)
+	}
+
 	if *panicStrategy != "print" && *panicStrategy != "trap" {
 		fmt.Fprintln(os.Stderr, "Panic strategy must be either print or trap.")
 		usage()
 		os.Exit(1)
 	}
 
+	if *checks != "" {
+		for _, check := range strings.Split(*checks, ",") {
+			switch check {
+			case "overflow":
+				config.overflowCheck = true
+			default:
+				fmt.Fprintln(os.Stderr, "Unknown check:", check)
+				usage()
+				os.Exit(1)
+			}
+		}
+	}
+
+	switch *deadline {
+	case "":
+		// Check deadlines in debug builds.
+		config.deadline = "off"
+		if config.debug {
+			config.deadline = "panic"
+		}
+	case "panic", "log", "off":
+	default:
+		fmt.Fprintln(os.Stderr, "Deadline checks must be either panic, log or off.")
+		usage()
+		os.Exit(1)
+	}
+
+	if *nilCheck != "panic" && *nilCheck != "location" && *nilCheck != "trap" {
+		fmt.Fprintln(os.Stderr, "Nil check must be either panic, location or trap.")
+		usage()
+		os.Exit(1)
+	}
+
+	if *isrCheck != "off" && *isrCheck != "warn" && *isrCheck != "error" {
+		fmt.Fprintln(os.Stderr, "Interrupt check must be either off, warn or error.")
+		usage()
+		os.Exit(1)
+	}
+
+	if *recoveryAt != "" {
+		offset, err := strconv.ParseUint(*recoveryAt, 0, 32)
+		if err != nil || offset == 0 {
+			fmt.Fprintln(os.Stderr, "Invalid -recovery-offset:", *recoveryAt)
+			usage()
+			os.Exit(1)
+		}
+		config.recoveryAt = uint32(offset)
+	}
+
+	if *testFormat != "text" && *testFormat != "tap" && *testFormat != "json" {
+		fmt.Fprintln(os.Stderr, "Test format must be either text, tap or json.")
+		usage()
+		os.Exit(1)
+	}
+	config.testConfig.Format = *testFormat
+	config.testConfig.Bench = *bench
+
+	if *printAllocs != "" {
+		r, err := regexp.Compile(*printAllocs)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -print-allocs regular expression:", err)
+			usage()
+			os.Exit(1)
+		}
+		config.printAllocs = r
+	}
+
 	var err error
 	if config.heapSize, err = parseSize(*heapSize); err != nil {
 		fmt.Fprintln(os.Stderr, "Could not read heap size:", *heapSize)
@@ -709,12 +1190,37 @@ func main() {
 			return moveFile(path, *outpath)
 		})
 		handleCompilerError(err)
+	case "build-cmsis-dsp":
+		// Note: this command is only meant to be used while making a release!
+		if *outpath == "" {
+			fmt.Fprintln(os.Stderr, "No output filename supplied (-o).")
+			usage()
+			os.Exit(1)
+		}
+		if *target == "" {
+			fmt.Fprintln(os.Stderr, "No target (-target).")
+		}
+		err := compileCMSISDSP(*target, func(path string) error {
+			return moveFile(path, *outpath)
+		})
+		handleCompilerError(err)
 	case "flash", "gdb":
 		if *outpath != "" {
 			fmt.Fprintln(os.Stderr, "Output cannot be specified with the flash command.")
 			usage()
 			os.Exit(1)
 		}
+		if *listPorts {
+			var spec *TargetSpec
+			if *target != "" {
+				var err error
+				spec, err = LoadTarget(*target)
+				handleCompilerError(err)
+			}
+			err := printSerialPorts(spec)
+			handleCompilerError(err)
+			return
+		}
 		if command == "flash" {
 			err := Flash(flag.Arg(0), *target, *port, config)
 			handleCompilerError(err)
@@ -744,7 +1250,45 @@ func main() {
 			usage()
 			os.Exit(1)
 		}
-		err := Test(pkgName, *target, config)
+		err := Test(pkgName, *target, *port, config)
+		handleCompilerError(err)
+	case "stacksize":
+		pkgName := "."
+		if flag.NArg() == 1 {
+			pkgName = flag.Arg(0)
+		} else if flag.NArg() > 1 {
+			fmt.Fprintln(os.Stderr, "stacksize only accepts a single positional argument: package name, but multiple were specified")
+			usage()
+			os.Exit(1)
+		}
+		if !config.debug {
+			fmt.Fprintln(os.Stderr, "Debug information is required to determine stack sizes.")
+			usage()
+			os.Exit(1)
+		}
+		err := StackSize(pkgName, *target, config)
+		handleCompilerError(err)
+	case "monitor":
+		if flag.NArg() > 1 {
+			fmt.Fprintln(os.Stderr, "usage: tinygo monitor [-target=<target>] [-port=<port>] [<binary>]")
+			usage()
+			os.Exit(1)
+		}
+		spec := &TargetSpec{}
+		if *target != "" {
+			var err error
+			spec, err = LoadTarget(*target)
+			handleCompilerError(err)
+		}
+		err := Monitor(*port, spec, flag.Arg(0), *baudRate)
+		handleCompilerError(err)
+	case "addr2line":
+		if flag.NArg() < 2 {
+			fmt.Fprintln(os.Stderr, "usage: tinygo addr2line <binary> <address>...")
+			usage()
+			os.Exit(1)
+		}
+		err := Addr2Line(flag.Arg(0), flag.Args()[1:], os.Stdout)
 		handleCompilerError(err)
 	case "clean":
 		// remove cache directory
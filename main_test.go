@@ -96,7 +96,42 @@ func TestCompiler(t *testing.T) {
 	}
 }
 
+// TestNoRecursion checks that a program that allocates builds with
+// -no-recursion, which means all recursion in the runtime (for example in the
+// garbage collector) is marked with //go:recursive.
+func TestNoRecursion(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	config := defaultTestConfig()
+	config.noRecursion = true
+	runTestWithConfig(filepath.Join(TESTDATA, "alloc.go"), tmpdir, "", config, t)
+	if !testing.Short() {
+		runTestWithConfig(filepath.Join(TESTDATA, "alloc.go"), tmpdir, "qemu", config, t)
+	}
+}
+
+// defaultTestConfig returns the build configuration used for the programs in
+// testdata.
+func defaultTestConfig() *BuildConfig {
+	return &BuildConfig{
+		opt:        "z",
+		printIR:    false,
+		dumpSSA:    false,
+		debug:      false,
+		printSizes: "",
+		wasmAbi:    "js",
+	}
+}
+
 func runTest(path, tmpdir string, target string, t *testing.T) {
+	runTestWithConfig(path, tmpdir, target, defaultTestConfig(), t)
+}
+
+func runTestWithConfig(path, tmpdir string, target string, config *BuildConfig, t *testing.T) {
 	// Get the expected output for this test.
 	txtpath := path[:len(path)-3] + ".txt"
 	if path[len(path)-1] == os.PathSeparator {
@@ -112,14 +147,6 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 	}
 
 	// Build the test binary.
-	config := &BuildConfig{
-		opt:        "z",
-		printIR:    false,
-		dumpSSA:    false,
-		debug:      false,
-		printSizes: "",
-		wasmAbi:    "js",
-	}
 	binary := filepath.Join(tmpdir, "test")
 	err = Build("./"+path, binary, target, config)
 	if err != nil {
@@ -0,0 +1,134 @@
+package main
+
+// This file implements the monitor subcommand and the -monitor flag of the
+// flash command: a simple serial console for a connected board. Output of the
+// board is printed as it arrives and lines typed on stdin are sent to the
+// board. When the binary of the running program is known, the addresses in
+// panic traces (see -panic-trace) are converted to source locations.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Monitor opens the serial port of a board and connects it to stdin and
+// stdout until the port is closed or the user presses Ctrl-C. The port is
+// found as for flashing, see findSerialPort. If executable is not empty, it is
+// the ELF file used to symbolize panic traces.
+func Monitor(port string, spec *TargetSpec, executable string, baudRate int) error {
+	var symbolizer *Symbolizer
+	if executable != "" {
+		var err error
+		symbolizer, err = NewSymbolizer(executable)
+		if err != nil {
+			return err
+		}
+	}
+	f, err := openSerialPort(port, spec, baudRate)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(os.Stderr, "Connected to %s. Press Ctrl-C to exit.\n", f.Name())
+
+	// Send input to the board. The terminal sends a line at a time.
+	go io.Copy(f, os.Stdin)
+
+	// Print the output of the board as it arrives, and look at complete lines
+	// for addresses to symbolize.
+	buf := make([]byte, 256)
+	var line []byte
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("serial port closed")
+			}
+			return err
+		}
+		os.Stdout.Write(buf[:n])
+		if symbolizer == nil {
+			continue
+		}
+		for _, c := range buf[:n] {
+			if c != '\n' {
+				line = append(line, c)
+				continue
+			}
+			printTraceLocations(os.Stdout, symbolizer, string(line))
+			line = line[:0]
+		}
+	}
+}
+
+// openSerialPort finds the serial port of a board as for flashing (see
+// findSerialPort), configures it and opens it.
+func openSerialPort(port string, spec *TargetSpec, baudRate int) (*os.File, error) {
+	// Boards with a native USB port disappear for a moment after they have
+	// been reset, so retry for a while before giving up.
+	var path string
+	var err error
+	for i := 0; ; i++ {
+		path, err = findSerialPort(port, spec)
+		if err == nil || i == 30 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := configureSerialPort(path, baudRate); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+// configureSerialPort sets the baud rate of the serial port and puts it in raw
+// mode, so that the output of the board is passed through unmodified. Extra
+// settings can be passed to stty.
+func configureSerialPort(path string, baudRate int, settings ...string) error {
+	var flag string
+	switch runtime.GOOS {
+	case "linux":
+		flag = "-F"
+	case "darwin":
+		flag = "-f"
+	default:
+		return errors.New("the serial monitor is not supported on " + runtime.GOOS)
+	}
+	args := append([]string{flag, path, strconv.Itoa(baudRate), "raw", "-echo"}, settings...)
+	cmd := exec.Command("stty", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not configure serial port %s: %s", path, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// printTraceLocations prints the source locations of the address in a line of
+// a panic trace, which consists of only a hexadecimal address. Other lines are
+// ignored.
+func printTraceLocations(w io.Writer, symbolizer *Symbolizer, line string) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "0x") {
+		return
+	}
+	addr, err := strconv.ParseUint(line[2:], 16, 64)
+	if err != nil {
+		return
+	}
+	locations, err := symbolizer.Lookup(addr)
+	if err != nil {
+		return
+	}
+	for _, loc := range locations {
+		fmt.Fprintf(w, "        %s\r\n            %s:%d\r\n", loc.Function, loc.File, loc.Line)
+	}
+}
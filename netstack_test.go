@@ -0,0 +1,76 @@
+package main
+
+// This file runs the tests of the netstack package with the host Go
+// toolchain. The package imports the TinyGo version of the net package, which
+// is only available as an overlay when compiling with TinyGo. Therefore both
+// packages are copied to a temporary module in which the import is rewritten.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const netstackTestModule = "netstacktest"
+
+func TestNetstack(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not found:", err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "tinygo-netstack")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	err = ioutil.WriteFile(filepath.Join(tmpdir, "go.mod"), []byte("module "+netstackTestModule+"\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range []string{"net", "netstack"} {
+		if err := copyNetstackPackage(pkg, tmpdir); err != nil {
+			t.Fatal("could not copy package:", err)
+		}
+	}
+
+	cmd := exec.Command("go", "test", "./netstack")
+	cmd.Dir = tmpdir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GO111MODULE=on")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("netstack tests failed: %v\n%s", err, output)
+	}
+}
+
+// copyNetstackPackage copies the Go files of the package in src/ to the
+// temporary module, replacing imports of "net" with the copy of the TinyGo
+// version.
+func copyNetstackPackage(pkg, tmpdir string) error {
+	files, err := filepath.Glob(filepath.Join("src", pkg, "*.go"))
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(tmpdir, pkg)
+	if err := os.Mkdir(dir, 0777); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if pkg == "net" && strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		data = bytes.Replace(data, []byte("\t\"net\"\n"), []byte("\t\""+netstackTestModule+"/net\"\n"), 1)
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(file)), data, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
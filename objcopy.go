@@ -91,9 +91,46 @@ func ExtractROM(path string) (uint64, []byte, error) {
 	}
 }
 
-// Objcopy converts an ELF file to a different (simpler) output file format:
-// .bin or .hex. It extracts only the .text section.
-func Objcopy(infile, outfile string) error {
+// ExtractROMs extracts the firmware images of several ELF files, like a main
+// app and a recovery app linked at different addresses, and combines them in
+// one image. Gaps between the images are filled with 0xff, like erased flash.
+func ExtractROMs(paths []string) (uint64, []byte, error) {
+	type image struct {
+		path string
+		addr uint64
+		data []byte
+	}
+	var images []image
+	for _, path := range paths {
+		addr, data, err := ExtractROM(path)
+		if err != nil {
+			return 0, nil, err
+		}
+		images = append(images, image{path, addr, data})
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].addr < images[j].addr
+	})
+
+	start := images[0].addr
+	var rom []byte
+	for _, img := range images {
+		offset := img.addr - start
+		if offset < uint64(len(rom)) {
+			return 0, nil, ObjcopyError{"firmware images overlap: " + img.path, nil}
+		}
+		for uint64(len(rom)) < offset {
+			rom = append(rom, 0xff)
+		}
+		rom = append(rom, img.data...)
+	}
+	return start, rom, nil
+}
+
+// Objcopy converts one or more ELF files to a different (simpler) output file
+// format: .bin or .hex. It extracts only the .text section. Multiple ELF files
+// are combined into one image, see ExtractROMs.
+func Objcopy(infiles []string, outfile string) error {
 	f, err := os.OpenFile(outfile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
@@ -101,7 +138,7 @@ func Objcopy(infile, outfile string) error {
 	defer f.Close()
 
 	// Read the .text segment.
-	addr, data, err := ExtractROM(infile)
+	addr, data, err := ExtractROMs(infiles)
 	if err != nil {
 		return err
 	}
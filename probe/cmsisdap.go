@@ -0,0 +1,428 @@
+// Package probe implements a driver for CMSIS-DAP debug probes, so that chips
+// can be flashed without OpenOCD. It talks to the probe over USB HID (CMSIS-DAP
+// v1) and to the chip over SWD, following the ARM Debug Interface v5.
+//
+// The driver gives access to the memory of the chip, can halt and reset
+// Cortex-M cores, programs the internal flash of a few chip families by
+// writing to their flash controller (see Flash) and reads SEGGER RTT buffers.
+// ST-Link probes use a different protocol and are not supported.
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// CMSIS-DAP command IDs.
+const (
+	dapInfo              = 0x00
+	dapConnect           = 0x02
+	dapDisconnect        = 0x03
+	dapTransferConfigure = 0x04
+	dapTransfer          = 0x05
+	dapTransferBlock     = 0x06
+	dapSWJClock          = 0x11
+	dapSWJSequence       = 0x12
+	dapSWDConfigure      = 0x13
+)
+
+// DAP_Info ID of the maximum packet size.
+const dapInfoPacketSize = 0xff
+
+// Bits in the request byte of a transfer.
+const (
+	reqAP   = 1 << 0 // access port instead of debug port
+	reqRead = 1 << 1 // read instead of write
+)
+
+// Transfer acknowledgements.
+const (
+	ackOK    = 1
+	ackWait  = 2
+	ackFault = 4
+)
+
+// Debug port registers.
+const (
+	dpIDCODE   = 0x0 // read
+	dpABORT    = 0x0 // write
+	dpCTRLSTAT = 0x4
+	dpSELECT   = 0x8
+)
+
+// Bits in the CTRL/STAT register.
+const (
+	ctrlCDBGPWRUPREQ = 1 << 28
+	ctrlCDBGPWRUPACK = 1 << 29
+	ctrlCSYSPWRUPREQ = 1 << 30
+	ctrlCSYSPWRUPACK = 1 << 31
+)
+
+// MEM-AP registers.
+const (
+	apCSW = 0x00
+	apTAR = 0x04
+	apDRW = 0x0c
+)
+
+// CSW values for 32-bit, 16-bit and 8-bit accesses, with single address
+// increment and the usual debug master bits set.
+const (
+	csw32 = 0x23000052
+	csw16 = 0x23000051
+	csw8  = 0x23000050
+)
+
+// The TAR auto-increment is only guaranteed to work within a 1kB block.
+const tarWrapSize = 1024
+
+// CMSISDAP is a connection to a CMSIS-DAP probe.
+type CMSISDAP struct {
+	dev        io.ReadWriteCloser
+	packetSize int
+	idcode     uint32
+	selectAP   uint32 // cached value of the SELECT register
+	csw        uint32 // cached value of the CSW register
+}
+
+// OpenCMSISDAP opens a CMSIS-DAP probe. When serial is not empty, the probe
+// with that USB serial number is opened, otherwise the first probe that is
+// found.
+func OpenCMSISDAP(serial string) (*CMSISDAP, error) {
+	dev, err := openHID(serial)
+	if err != nil {
+		return nil, err
+	}
+	d := &CMSISDAP{
+		dev:        dev,
+		packetSize: 64, // full speed USB HID report size
+	}
+	resp, err := d.command([]byte{dapInfo, dapInfoPacketSize})
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	if resp[1] == 2 {
+		d.packetSize = int(binary.LittleEndian.Uint16(resp[2:]))
+	}
+	return d, nil
+}
+
+// Close disconnects from the chip and closes the probe.
+func (d *CMSISDAP) Close() error {
+	d.command([]byte{dapDisconnect})
+	return d.dev.Close()
+}
+
+// command sends a single command to the probe and returns the response. The
+// response starts with the command ID.
+func (d *CMSISDAP) command(req []byte) ([]byte, error) {
+	if len(req) > d.packetSize {
+		return nil, errors.New("probe: CMSIS-DAP command too long")
+	}
+	packet := make([]byte, d.packetSize+1) // starts with report ID 0
+	copy(packet[1:], req)
+	if _, err := d.dev.Write(packet); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, d.packetSize)
+	n, err := d.dev.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	if n < 2 || resp[0] != req[0] {
+		return nil, errors.New("probe: unexpected CMSIS-DAP response")
+	}
+	return resp[:n], nil
+}
+
+// commandStatus sends a command that only returns a status byte.
+func (d *CMSISDAP) commandStatus(req ...byte) error {
+	resp, err := d.command(req)
+	if err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return errors.New("probe: CMSIS-DAP command " + strconv.Itoa(int(req[0])) + " failed")
+	}
+	return nil
+}
+
+// Connect connects to the chip over SWD at the given clock frequency in Hz and
+// powers up the debug port.
+func (d *CMSISDAP) Connect(clock uint32) error {
+	resp, err := d.command([]byte{dapConnect, 1}) // SWD
+	if err != nil {
+		return err
+	}
+	if resp[1] != 1 {
+		return errors.New("probe: probe does not support SWD")
+	}
+	var clockBytes [4]byte
+	binary.LittleEndian.PutUint32(clockBytes[:], clock)
+	if err := d.commandStatus(dapSWJClock, clockBytes[0], clockBytes[1], clockBytes[2], clockBytes[3]); err != nil {
+		return err
+	}
+	// No idle cycles, retry up to 1000 times on a WAIT response (flash writes
+	// stall the bus) and no value matching.
+	if err := d.commandStatus(dapTransferConfigure, 0, 0xe8, 0x03, 0, 0); err != nil {
+		return err
+	}
+	if err := d.commandStatus(dapSWDConfigure, 0); err != nil {
+		return err
+	}
+
+	// Switch the debug port from JTAG to SWD: a line reset, the 16-bit
+	// JTAG-to-SWD sequence, another line reset and some idle cycles.
+	for _, seq := range [][]byte{
+		{51, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{16, 0x9e, 0xe7},
+		{51, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{8, 0x00},
+	} {
+		if err := d.commandStatus(append([]byte{dapSWJSequence}, seq...)...); err != nil {
+			return err
+		}
+	}
+	d.idcode, err = d.transfer(reqRead|dpIDCODE, 0)
+	if err != nil {
+		return err
+	}
+
+	// Clear sticky errors and power up the debug and system domains.
+	if _, err := d.transfer(dpABORT, 0x1e); err != nil {
+		return err
+	}
+	if _, err := d.transfer(dpCTRLSTAT, ctrlCDBGPWRUPREQ|ctrlCSYSPWRUPREQ); err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		ctrl, err := d.transfer(reqRead|dpCTRLSTAT, 0)
+		if err != nil {
+			return err
+		}
+		if ctrl&(ctrlCDBGPWRUPACK|ctrlCSYSPWRUPACK) == ctrlCDBGPWRUPACK|ctrlCSYSPWRUPACK {
+			break
+		}
+		if i == 100 {
+			return errors.New("probe: debug port does not power up")
+		}
+	}
+	d.invalidateCache()
+	return nil
+}
+
+// IDCODE returns the identification code of the debug port, as read while
+// connecting.
+func (d *CMSISDAP) IDCODE() uint32 {
+	return d.idcode
+}
+
+// invalidateCache forgets the cached SELECT and CSW values, for when they may
+// have been changed by a reset.
+func (d *CMSISDAP) invalidateCache() {
+	d.selectAP = ^uint32(0)
+	d.csw = 0
+}
+
+// transferError converts the acknowledgement of a failed transfer to an error.
+func transferError(ack byte) error {
+	switch ack {
+	case ackWait:
+		return errors.New("probe: SWD transfer timed out")
+	case ackFault:
+		return errors.New("probe: SWD transfer fault")
+	default:
+		return errors.New("probe: no SWD response (ack " + strconv.Itoa(int(ack)) + ")")
+	}
+}
+
+// transfer does a single debug port or access port register access. The
+// request contains the register address and the reqAP and reqRead bits.
+func (d *CMSISDAP) transfer(request byte, value uint32) (uint32, error) {
+	req := []byte{dapTransfer, 0, 1, request}
+	if request&reqRead == 0 {
+		req = append(req, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(req[4:], value)
+	}
+	resp, err := d.command(req)
+	if err != nil {
+		return 0, err
+	}
+	if resp[1] != 1 || resp[2] != ackOK {
+		return 0, transferError(resp[2])
+	}
+	if request&reqRead != 0 {
+		if len(resp) < 7 {
+			return 0, errors.New("probe: short CMSIS-DAP response")
+		}
+		return binary.LittleEndian.Uint32(resp[3:]), nil
+	}
+	return 0, nil
+}
+
+// writeAP writes to a register of the MEM-AP (access port 0).
+func (d *CMSISDAP) writeAP(addr byte, value uint32) error {
+	if err := d.selectBank(addr); err != nil {
+		return err
+	}
+	_, err := d.transfer(reqAP|addr&0xc, value)
+	return err
+}
+
+// selectBank selects the register bank of an access port register.
+func (d *CMSISDAP) selectBank(addr byte) error {
+	sel := uint32(addr & 0xf0)
+	if sel == d.selectAP {
+		return nil
+	}
+	if _, err := d.transfer(dpSELECT, sel); err != nil {
+		return err
+	}
+	d.selectAP = sel
+	return nil
+}
+
+// setupAccess sets the access size and the address of the next memory access.
+func (d *CMSISDAP) setupAccess(csw, addr uint32) error {
+	if csw != d.csw {
+		if err := d.writeAP(apCSW, csw); err != nil {
+			return err
+		}
+		d.csw = csw
+	}
+	return d.writeAP(apTAR, addr)
+}
+
+// blockWords returns how many words of a block transfer starting at addr can
+// be done in a single command.
+func (d *CMSISDAP) blockWords(addr uint32, words, overhead int) int {
+	n := (d.packetSize - overhead) / 4
+	if wrap := int(tarWrapSize-addr%tarWrapSize) / 4; wrap < n {
+		n = wrap
+	}
+	if words < n {
+		n = words
+	}
+	return n
+}
+
+// ReadMem32 reads words from memory, starting at the given (word aligned)
+// address.
+func (d *CMSISDAP) ReadMem32(addr uint32, buf []uint32) error {
+	for len(buf) != 0 {
+		n := d.blockWords(addr, len(buf), 4)
+		if err := d.setupAccess(csw32, addr); err != nil {
+			return err
+		}
+		resp, err := d.command([]byte{dapTransferBlock, 0, byte(n), byte(n >> 8), reqAP | reqRead | apDRW})
+		if err != nil {
+			return err
+		}
+		if int(binary.LittleEndian.Uint16(resp[1:])) != n || resp[3] != ackOK {
+			return transferError(resp[3])
+		}
+		if len(resp) < 4+n*4 {
+			return errors.New("probe: short CMSIS-DAP response")
+		}
+		for i := 0; i < n; i++ {
+			buf[i] = binary.LittleEndian.Uint32(resp[4+i*4:])
+		}
+		buf = buf[n:]
+		addr += uint32(n) * 4
+	}
+	return nil
+}
+
+// WriteMem32 writes words to memory, starting at the given (word aligned)
+// address.
+func (d *CMSISDAP) WriteMem32(addr uint32, data []uint32) error {
+	for len(data) != 0 {
+		n := d.blockWords(addr, len(data), 5)
+		if err := d.setupAccess(csw32, addr); err != nil {
+			return err
+		}
+		req := make([]byte, 5+n*4)
+		req[0] = dapTransferBlock
+		binary.LittleEndian.PutUint16(req[2:], uint16(n))
+		req[4] = reqAP | apDRW
+		for i := 0; i < n; i++ {
+			binary.LittleEndian.PutUint32(req[5+i*4:], data[i])
+		}
+		resp, err := d.command(req)
+		if err != nil {
+			return err
+		}
+		if int(binary.LittleEndian.Uint16(resp[1:])) != n || resp[3] != ackOK {
+			return transferError(resp[3])
+		}
+		data = data[n:]
+		addr += uint32(n) * 4
+	}
+	return nil
+}
+
+// ReadWord reads a single word from memory.
+func (d *CMSISDAP) ReadWord(addr uint32) (uint32, error) {
+	var buf [1]uint32
+	err := d.ReadMem32(addr, buf[:])
+	return buf[0], err
+}
+
+// WriteWord writes a single word to memory.
+func (d *CMSISDAP) WriteWord(addr, value uint32) error {
+	return d.WriteMem32(addr, []uint32{value})
+}
+
+// WriteHalfword writes a single 16-bit value to memory. Some flash
+// controllers can only be programmed with 16-bit writes.
+func (d *CMSISDAP) WriteHalfword(addr uint32, value uint16) error {
+	if err := d.setupAccess(csw16, addr); err != nil {
+		return err
+	}
+	// The value must be placed on the byte lanes of the address.
+	return d.writeAP(apDRW, uint32(value)<<((addr&2)*8))
+}
+
+// ReadMem reads bytes from memory at any alignment.
+func (d *CMSISDAP) ReadMem(addr uint32, buf []byte) error {
+	start := addr &^ 3
+	words := make([]uint32, (addr+uint32(len(buf))-start+3)/4)
+	if err := d.ReadMem32(start, words); err != nil {
+		return err
+	}
+	raw := make([]byte, len(words)*4)
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(raw[i*4:], w)
+	}
+	copy(buf, raw[addr-start:])
+	return nil
+}
+
+// WriteMem writes bytes to memory at any alignment. Whole words are written
+// with word accesses, the bytes before and after them with byte accesses.
+func (d *CMSISDAP) WriteMem(addr uint32, data []byte) error {
+	for len(data) != 0 && (addr%4 != 0 || len(data) < 4) {
+		if err := d.setupAccess(csw8, addr); err != nil {
+			return err
+		}
+		if err := d.writeAP(apDRW, uint32(data[0])<<((addr&3)*8)); err != nil {
+			return err
+		}
+		addr++
+		data = data[1:]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	words := make([]uint32, len(data)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	if err := d.WriteMem32(addr, words); err != nil {
+		return err
+	}
+	return d.WriteMem(addr+uint32(len(words))*4, data[len(words)*4:])
+}
@@ -0,0 +1,206 @@
+package probe
+
+// Control of a Cortex-M core through its debug registers.
+
+import (
+	"errors"
+	"time"
+)
+
+// Debug registers in the System Control Space.
+const (
+	regAIRCR = 0xe000ed0c // Application Interrupt and Reset Control Register
+	regDHCSR = 0xe000edf0 // Debug Halting Control and Status Register
+	regDEMCR = 0xe000edfc // Debug Exception and Monitor Control Register
+	regDCRSR = 0xe000edf4 // Debug Core Register Selector Register
+	regDCRDR = 0xe000edf8 // Debug Core Register Data Register
+	regFPCtr = 0xe0002000 // Flash Patch and Breakpoint control
+	regFPCmp = 0xe0002008 // first breakpoint comparator
+)
+
+const (
+	dhcsrKey      = 0xa05f0000 // must be written to change DHCSR
+	dhcsrDebugEn  = 1 << 0
+	dhcsrHalt     = 1 << 1
+	dhcsrStep     = 1 << 2
+	dhcsrMaskInts = 1 << 3
+	dhcsrSRegRdy  = 1 << 16
+	dhcsrSHalt    = 1 << 17
+	dcrsrWrite    = 1 << 16
+	demcrVCReset  = 1 << 0 // halt after a reset
+	aircrSysReset = 0x05fa0004
+	fpCtrlKey     = 1 << 1 // must be set to change FP_CTRL
+	fpCtrlEnable  = 1 << 0
+)
+
+// Core register numbers, as used in DCRSR. The registers r0-r12 have the
+// numbers 0-12.
+const (
+	RegSP   = 13
+	RegLR   = 14
+	RegPC   = 15 // the debug return address
+	RegXPSR = 16
+)
+
+// Halt stops the core.
+func (d *CMSISDAP) Halt() error {
+	if err := d.WriteWord(regDHCSR, dhcsrKey|dhcsrDebugEn|dhcsrHalt); err != nil {
+		return err
+	}
+	return d.waitHalted()
+}
+
+// waitHalted waits until the core reports that it is halted.
+func (d *CMSISDAP) waitHalted() error {
+	for i := 0; i < 100; i++ {
+		dhcsr, err := d.ReadWord(regDHCSR)
+		if err == nil && dhcsr&dhcsrSHalt != 0 {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return errors.New("probe: core does not halt")
+}
+
+// ResetHalt resets the chip and halts the core before it executes the first
+// instruction, which is the state needed to program the flash.
+func (d *CMSISDAP) ResetHalt() error {
+	if err := d.WriteWord(regDHCSR, dhcsrKey|dhcsrDebugEn|dhcsrHalt); err != nil {
+		return err
+	}
+	demcr, err := d.ReadWord(regDEMCR)
+	if err != nil {
+		return err
+	}
+	if err := d.WriteWord(regDEMCR, demcr|demcrVCReset); err != nil {
+		return err
+	}
+	d.WriteWord(regAIRCR, aircrSysReset) // may not be acknowledged
+	time.Sleep(10 * time.Millisecond)
+	d.invalidateCache()
+	if err := d.waitHalted(); err != nil {
+		return err
+	}
+	return d.WriteWord(regDEMCR, demcr&^demcrVCReset)
+}
+
+// Reset resets the chip and lets it run.
+func (d *CMSISDAP) Reset() error {
+	demcr, err := d.ReadWord(regDEMCR)
+	if err != nil {
+		return err
+	}
+	if err := d.WriteWord(regDEMCR, demcr&^demcrVCReset); err != nil {
+		return err
+	}
+	if err := d.WriteWord(regDHCSR, dhcsrKey); err != nil {
+		return err
+	}
+	d.WriteWord(regAIRCR, aircrSysReset) // may not be acknowledged
+	d.invalidateCache()
+	return nil
+}
+
+// ReadRegister reads a core register. The core must be halted.
+func (d *CMSISDAP) ReadRegister(reg uint32) (uint32, error) {
+	if err := d.WriteWord(regDCRSR, reg); err != nil {
+		return 0, err
+	}
+	if err := d.waitRegister(); err != nil {
+		return 0, err
+	}
+	return d.ReadWord(regDCRDR)
+}
+
+// WriteRegister writes a core register. The core must be halted.
+func (d *CMSISDAP) WriteRegister(reg, value uint32) error {
+	if err := d.WriteWord(regDCRDR, value); err != nil {
+		return err
+	}
+	if err := d.WriteWord(regDCRSR, reg|dcrsrWrite); err != nil {
+		return err
+	}
+	return d.waitRegister()
+}
+
+// waitRegister waits until a core register transfer has completed.
+func (d *CMSISDAP) waitRegister() error {
+	for i := 0; i < 100; i++ {
+		dhcsr, err := d.ReadWord(regDHCSR)
+		if err != nil {
+			return err
+		}
+		if dhcsr&dhcsrSRegRdy != 0 {
+			return nil
+		}
+	}
+	return errors.New("probe: core register transfer does not complete")
+}
+
+// Halted returns whether the core is halted, for example because it hit a
+// breakpoint.
+func (d *CMSISDAP) Halted() (bool, error) {
+	dhcsr, err := d.ReadWord(regDHCSR)
+	return dhcsr&dhcsrSHalt != 0, err
+}
+
+// Resume lets a halted core run.
+func (d *CMSISDAP) Resume() error {
+	return d.WriteWord(regDHCSR, dhcsrKey|dhcsrDebugEn)
+}
+
+// Step executes a single instruction, with interrupts masked, and halts the
+// core again.
+func (d *CMSISDAP) Step() error {
+	if err := d.WriteWord(regDHCSR, dhcsrKey|dhcsrDebugEn|dhcsrHalt|dhcsrMaskInts); err != nil {
+		return err
+	}
+	if err := d.WriteWord(regDHCSR, dhcsrKey|dhcsrDebugEn|dhcsrStep|dhcsrMaskInts); err != nil {
+		return err
+	}
+	if err := d.waitHalted(); err != nil {
+		return err
+	}
+	return d.WriteWord(regDHCSR, dhcsrKey|dhcsrDebugEn|dhcsrHalt)
+}
+
+// Breakpoints returns the number of hardware breakpoints of the core.
+func (d *CMSISDAP) Breakpoints() (int, error) {
+	ctrl, err := d.ReadWord(regFPCtr)
+	if err != nil {
+		return 0, err
+	}
+	return int(ctrl>>4&0xf | ctrl>>8&0x70), nil
+}
+
+// SetBreakpoint sets hardware breakpoint n at the given address, or clears it
+// if the address is zero. The breakpoint unit is enabled when it isn't yet.
+func (d *CMSISDAP) SetBreakpoint(n int, addr uint32) error {
+	ctrl, err := d.ReadWord(regFPCtr)
+	if err != nil {
+		return err
+	}
+	if ctrl&fpCtrlEnable == 0 {
+		if err := d.WriteWord(regFPCtr, fpCtrlKey|fpCtrlEnable); err != nil {
+			return err
+		}
+	}
+	var comp uint32
+	if addr != 0 {
+		if ctrl>>28 == 0 {
+			// FPB version 1 (Cortex-M0, M3, M4): the comparator matches a
+			// word, the replace bits select the halfword.
+			comp = addr&0x1ffffffc | 1
+			if addr&2 == 0 {
+				comp |= 1 << 30
+			} else {
+				comp |= 2 << 30
+			}
+		} else {
+			// FPB version 2 (Cortex-M7, M33): the comparator contains the
+			// address of the instruction.
+			comp = addr | 1
+		}
+	}
+	return d.WriteWord(regFPCmp+uint32(n)*4, comp)
+}
@@ -0,0 +1,312 @@
+package probe
+
+// Flash programming. Instead of loading a flash algorithm into the RAM of the
+// chip, the flash controller is driven directly over the debug port. This is
+// slower, but it is simple and needs no code running on the chip.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// flashAlgorithms are the supported chip families, by the name used in the
+// "flash-algorithm" property of a target.
+var flashAlgorithms = map[string]func(d *CMSISDAP, addr uint32, data []byte) error{
+	"nrf5":    flashNRF5,
+	"samd21":  flashSAMD21,
+	"stm32f1": flashSTM32F1,
+}
+
+// Flash erases the flash pages covered by the data and programs the data at
+// the given address, using the flash controller of the given chip family. The
+// core must be halted, see ResetHalt.
+func (d *CMSISDAP) Flash(algorithm string, addr uint32, data []byte) error {
+	flash, ok := flashAlgorithms[algorithm]
+	if !ok {
+		return errors.New("probe: unknown flash algorithm: " + algorithm)
+	}
+	if addr%4 != 0 {
+		return errors.New("probe: flash address is not word aligned")
+	}
+	return flash(d, addr, data)
+}
+
+// flashBlockSize is the size of the blocks that FlashChanged compares. It is a
+// multiple of the erase size of all supported chips, so that programming a
+// block never erases data outside of it.
+const flashBlockSize = 4096
+
+// FlashChanged is like Flash, but it first reads back the flash and only
+// programs the blocks that differ from the data. It returns the number of
+// bytes that were programmed.
+func (d *CMSISDAP) FlashChanged(algorithm string, addr uint32, data []byte) (int, error) {
+	current := make([]byte, len(data))
+	if err := d.ReadMem(addr, current); err != nil {
+		return 0, err
+	}
+	// Blocks are aligned to flashBlockSize, except at the start and end of
+	// the data.
+	end := addr + uint32(len(data))
+	blockEnd := func(start uint32) uint32 {
+		e := start&^(flashBlockSize-1) + flashBlockSize
+		if e > end {
+			e = end
+		}
+		return e
+	}
+	changed := func(start uint32) bool {
+		return !bytes.Equal(current[start-addr:blockEnd(start)-addr], data[start-addr:blockEnd(start)-addr])
+	}
+
+	// Program every run of changed blocks.
+	programmed := 0
+	for start := addr; start < end; {
+		if !changed(start) {
+			start = blockEnd(start)
+			continue
+		}
+		runEnd := blockEnd(start)
+		for runEnd < end && changed(runEnd) {
+			runEnd = blockEnd(runEnd)
+		}
+		if err := d.Flash(algorithm, start, data[start-addr:runEnd-addr]); err != nil {
+			return programmed, err
+		}
+		programmed += int(runEnd - start)
+		start = runEnd
+	}
+	return programmed, nil
+}
+
+// flashWords pads the data with erased bytes to a multiple of size bytes and
+// returns it as little endian words.
+func flashWords(data []byte, size int) []uint32 {
+	data = append([]byte(nil), data...)
+	for len(data)%size != 0 {
+		data = append(data, 0xff)
+	}
+	words := make([]uint32, len(data)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	return words
+}
+
+// waitFlash polls a flash controller status register until the given bits
+// match the expected value.
+func (d *CMSISDAP) waitFlash(reg, mask, expected uint32) error {
+	for i := 0; i < 1000; i++ {
+		value, err := d.ReadWord(reg)
+		if err != nil {
+			return err
+		}
+		if value&mask == expected {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return errors.New("probe: timeout waiting for the flash controller")
+}
+
+// NVMC of the nRF51 and nRF52 series.
+const (
+	nrfFICRCodePageSize = 0x10000010
+	nrfNVMCReady        = 0x4001e400
+	nrfNVMCConfig       = 0x4001e504
+	nrfNVMCErasePage    = 0x4001e508
+)
+
+func flashNRF5(d *CMSISDAP, addr uint32, data []byte) error {
+	pageSize, err := d.ReadWord(nrfFICRCodePageSize)
+	if err != nil {
+		return err
+	}
+	words := flashWords(data, 4)
+	end := addr + uint32(len(words))*4
+
+	// Erase the pages.
+	if err := d.WriteWord(nrfNVMCConfig, 2); err != nil { // EEN
+		return err
+	}
+	for page := addr &^ (pageSize - 1); page < end; page += pageSize {
+		if err := d.WriteWord(nrfNVMCErasePage, page); err != nil {
+			return err
+		}
+		if err := d.waitFlash(nrfNVMCReady, 1, 1); err != nil {
+			return err
+		}
+	}
+
+	// Write the data. The NVMC stalls the bus while a word is written, which
+	// the probe handles by retrying.
+	if err := d.WriteWord(nrfNVMCConfig, 1); err != nil { // WEN
+		return err
+	}
+	if err := d.WriteMem32(addr, words); err != nil {
+		return err
+	}
+	if err := d.waitFlash(nrfNVMCReady, 1, 1); err != nil {
+		return err
+	}
+	return d.WriteWord(nrfNVMCConfig, 0) // REN
+}
+
+// NVMCTRL of the SAMD21.
+const (
+	samNVMCtrlA    = 0x41004000
+	samNVMCtrlB    = 0x41004004
+	samNVMParam    = 0x41004008
+	samNVMIntFlag  = 0x41004014
+	samNVMStatus   = 0x41004018
+	samNVMAddr     = 0x4100401c
+	samNVMCmdER    = 0xa502 // erase row, with the CMDEX key
+	samNVMCmdWP    = 0xa504 // write page
+	samNVMCmdPBC   = 0xa544 // page buffer clear
+	samNVMManW     = 1 << 7 // manual page write
+	samNVMErrors   = 0x1c   // PROGE, LOCKE and NVME in STATUS
+	samPagesPerRow = 4
+)
+
+// samNVMCommand executes a NVMCTRL command on the given address and waits for
+// it to complete.
+func (d *CMSISDAP) samNVMCommand(cmd uint16, addr uint32) error {
+	if err := d.WriteWord(samNVMAddr, addr/2); err != nil {
+		return err
+	}
+	if err := d.WriteHalfword(samNVMCtrlA, cmd); err != nil {
+		return err
+	}
+	if err := d.waitFlash(samNVMIntFlag, 1, 1); err != nil {
+		return err
+	}
+	status, err := d.ReadWord(samNVMStatus)
+	if err != nil {
+		return err
+	}
+	if status&samNVMErrors != 0 {
+		d.WriteWord(samNVMStatus, samNVMErrors) // clear the errors
+		if status&0x08 != 0 {
+			return errors.New("probe: flash region is locked (bootloader protection?)")
+		}
+		return errors.New("probe: flash programming error")
+	}
+	return nil
+}
+
+func flashSAMD21(d *CMSISDAP, addr uint32, data []byte) error {
+	param, err := d.ReadWord(samNVMParam)
+	if err != nil {
+		return err
+	}
+	pageSize := uint32(8) << ((param >> 16) & 7)
+	if addr%pageSize != 0 {
+		return errors.New("probe: flash address is not page aligned")
+	}
+	rowSize := pageSize * samPagesPerRow
+	words := flashWords(data, int(pageSize))
+	end := addr + uint32(len(words))*4
+
+	ctrlB, err := d.ReadWord(samNVMCtrlB)
+	if err != nil {
+		return err
+	}
+	if err := d.WriteWord(samNVMCtrlB, ctrlB|samNVMManW); err != nil {
+		return err
+	}
+	for row := addr &^ (rowSize - 1); row < end; row += rowSize {
+		if err := d.samNVMCommand(samNVMCmdER, row); err != nil {
+			return err
+		}
+	}
+	for page := addr; page < end; page += pageSize {
+		if err := d.samNVMCommand(samNVMCmdPBC, page); err != nil {
+			return err
+		}
+		i := (page - addr) / 4
+		if err := d.WriteMem32(page, words[i:i+pageSize/4]); err != nil {
+			return err
+		}
+		if err := d.samNVMCommand(samNVMCmdWP, page); err != nil {
+			return err
+		}
+	}
+	return d.WriteWord(samNVMCtrlB, ctrlB)
+}
+
+// Flash interface of the STM32F1 series.
+const (
+	stm32FlashKEYR   = 0x40022004
+	stm32FlashSR     = 0x4002200c
+	stm32FlashCR     = 0x40022010
+	stm32FlashAR     = 0x40022014
+	stm32FlashKey1   = 0x45670123
+	stm32FlashKey2   = 0xcdef89ab
+	stm32FlashPG     = 1 << 0
+	stm32FlashPER    = 1 << 1
+	stm32FlashSTRT   = 1 << 6
+	stm32FlashLOCK   = 1 << 7
+	stm32FlashBSY    = 1 << 0
+	stm32FlashErrors = 0x14 // PGERR and WRPRTERR in SR
+	stm32PageSize    = 1024 // low and medium density devices
+)
+
+// stm32FlashWait waits until the flash controller is no longer busy and
+// checks for errors.
+func (d *CMSISDAP) stm32FlashWait() error {
+	if err := d.waitFlash(stm32FlashSR, stm32FlashBSY, 0); err != nil {
+		return err
+	}
+	sr, err := d.ReadWord(stm32FlashSR)
+	if err != nil {
+		return err
+	}
+	if sr&stm32FlashErrors != 0 {
+		d.WriteWord(stm32FlashSR, stm32FlashErrors) // clear the errors
+		return errors.New("probe: flash programming error")
+	}
+	return nil
+}
+
+func flashSTM32F1(d *CMSISDAP, addr uint32, data []byte) error {
+	words := flashWords(data, 4)
+	end := addr + uint32(len(words))*4
+	if err := d.WriteWord(stm32FlashKEYR, stm32FlashKey1); err != nil {
+		return err
+	}
+	if err := d.WriteWord(stm32FlashKEYR, stm32FlashKey2); err != nil {
+		return err
+	}
+
+	for page := addr &^ (stm32PageSize - 1); page < end; page += stm32PageSize {
+		if err := d.WriteWord(stm32FlashCR, stm32FlashPER); err != nil {
+			return err
+		}
+		if err := d.WriteWord(stm32FlashAR, page); err != nil {
+			return err
+		}
+		if err := d.WriteWord(stm32FlashCR, stm32FlashPER|stm32FlashSTRT); err != nil {
+			return err
+		}
+		if err := d.stm32FlashWait(); err != nil {
+			return err
+		}
+	}
+
+	// The flash can only be programmed a halfword at a time.
+	if err := d.WriteWord(stm32FlashCR, stm32FlashPG); err != nil {
+		return err
+	}
+	for i, word := range words {
+		for half := uint32(0); half < 2; half++ {
+			if err := d.WriteHalfword(addr+uint32(i)*4+half*2, uint16(word>>(half*16))); err != nil {
+				return err
+			}
+			if err := d.stm32FlashWait(); err != nil {
+				return err
+			}
+		}
+	}
+	return d.WriteWord(stm32FlashCR, stm32FlashLOCK)
+}
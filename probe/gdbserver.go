@@ -0,0 +1,455 @@
+package probe
+
+// A GDB server (remote serial protocol) for a Cortex-M core, so that GDB can
+// debug a chip through a CMSIS-DAP probe without OpenOCD. It implements the
+// subset of the protocol that GDB needs for ordinary debugging: registers,
+// memory, hardware breakpoints, stepping and continuing. Memory writes to the
+// flash (as done by the GDB load command) are collected and programmed with
+// the flash algorithm of the chip before the core runs again.
+//
+// Protocol: https://sourceware.org/gdb/onlinedocs/gdb/Remote-Protocol.html
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gdbTargetXML describes the registers of a Cortex-M core to GDB, in the order
+// of the core register numbers.
+const gdbTargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target>
+<architecture>arm</architecture>
+<feature name="org.gnu.gdb.arm.m-profile">
+<reg name="r0" bitsize="32"/>
+<reg name="r1" bitsize="32"/>
+<reg name="r2" bitsize="32"/>
+<reg name="r3" bitsize="32"/>
+<reg name="r4" bitsize="32"/>
+<reg name="r5" bitsize="32"/>
+<reg name="r6" bitsize="32"/>
+<reg name="r7" bitsize="32"/>
+<reg name="r8" bitsize="32"/>
+<reg name="r9" bitsize="32"/>
+<reg name="r10" bitsize="32"/>
+<reg name="r11" bitsize="32"/>
+<reg name="r12" bitsize="32"/>
+<reg name="sp" bitsize="32" type="data_ptr"/>
+<reg name="lr" bitsize="32"/>
+<reg name="pc" bitsize="32" type="code_ptr"/>
+<reg name="xpsr" bitsize="32"/>
+</feature>
+</target>
+`
+
+// Stop replies: the signal that stopped the program.
+const (
+	gdbSigInt  = "S02" // interrupted with Ctrl-C
+	gdbSigTrap = "S05" // breakpoint or step
+)
+
+// GDBServer is a GDB server for the core connected to a probe.
+type GDBServer struct {
+	d *CMSISDAP
+
+	// The flash of the chip and the algorithm to program it, see Flash.
+	// Writes to this range are programmed before the core runs again.
+	FlashAlgo  string
+	FlashStart uint32
+	FlashEnd   uint32
+
+	Log io.Writer // errors are written here, if not nil
+
+	flashWrites []gdbFlashWrite
+	breakpoints []uint32 // address per hardware breakpoint, 0 if unused
+}
+
+// gdbFlashWrite is a memory write to flash that hasn't been programmed yet.
+type gdbFlashWrite struct {
+	addr uint32
+	data []byte
+}
+
+// NewGDBServer returns a GDB server for the core connected to the probe. The
+// probe must be connected, see Connect.
+func NewGDBServer(d *CMSISDAP) (*GDBServer, error) {
+	n, err := d.Breakpoints()
+	if err != nil {
+		return nil, err
+	}
+	return &GDBServer{d: d, breakpoints: make([]uint32, n)}, nil
+}
+
+// gdbConn is a connection to GDB. Packets are read in a separate goroutine,
+// so that an interrupt (Ctrl-C in GDB) can be seen while the core runs.
+type gdbConn struct {
+	w          io.Writer
+	packets    chan string
+	interrupts chan struct{}
+}
+
+// readPackets reads packets and interrupts from GDB, acknowledging every
+// packet, until the connection is closed.
+func (c *gdbConn) readPackets(r io.Reader) {
+	defer close(c.packets)
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 0x03:
+			select {
+			case c.interrupts <- struct{}{}:
+			default:
+			}
+		case '$':
+			data, err := br.ReadString('#')
+			if err != nil {
+				return
+			}
+			if _, err := io.ReadFull(br, make([]byte, 2)); err != nil {
+				return // checksum, TCP already makes sure the data is intact
+			}
+			c.w.Write([]byte{'+'})
+			c.packets <- strings.TrimSuffix(data, "#")
+		}
+		// Acknowledgements ('+' and '-') from GDB are ignored.
+	}
+}
+
+// send sends a packet to GDB.
+func (c *gdbConn) send(data string) error {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	_, err := fmt.Fprintf(c.w, "$%s#%02x", data, sum)
+	return err
+}
+
+// Serve handles a single GDB connection until GDB detaches or the connection
+// is closed.
+func (s *GDBServer) Serve(rw io.ReadWriter) error {
+	c := &gdbConn{
+		w:          rw,
+		packets:    make(chan string),
+		interrupts: make(chan struct{}, 1),
+	}
+	go c.readPackets(rw)
+	for packet := range c.packets {
+		reply, done := s.handle(c, packet)
+		if err := c.send(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// handle executes a single packet and returns the reply, and whether the
+// session has ended.
+func (s *GDBServer) handle(c *gdbConn, packet string) (reply string, done bool) {
+	if packet == "" {
+		return "", false
+	}
+	args := packet[1:]
+	switch packet[0] {
+	case '?':
+		return gdbSigTrap, false
+	case 'q':
+		return s.query(args), false
+	case 'H':
+		return "OK", false // there is only one thread
+	case 'g':
+		buf := make([]byte, (RegXPSR+1)*4)
+		for reg := uint32(0); reg <= RegXPSR; reg++ {
+			value, err := s.d.ReadRegister(reg)
+			if err != nil {
+				return s.fail(err), false
+			}
+			binary.LittleEndian.PutUint32(buf[reg*4:], value)
+		}
+		return hex.EncodeToString(buf), false
+	case 'G':
+		buf, err := hex.DecodeString(args)
+		if err != nil {
+			return s.fail(err), false
+		}
+		for reg := uint32(0); reg <= RegXPSR && int(reg)*4+4 <= len(buf); reg++ {
+			if err := s.d.WriteRegister(reg, binary.LittleEndian.Uint32(buf[reg*4:])); err != nil {
+				return s.fail(err), false
+			}
+		}
+		return "OK", false
+	case 'p':
+		reg, err := strconv.ParseUint(args, 16, 32)
+		if err != nil || reg > RegXPSR {
+			return "E01", false
+		}
+		value, err := s.d.ReadRegister(uint32(reg))
+		if err != nil {
+			return s.fail(err), false
+		}
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, value)
+		return hex.EncodeToString(buf), false
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		reg, err := strconv.ParseUint(parts[0], 16, 32)
+		if err != nil || reg > RegXPSR || len(parts) != 2 {
+			return "E01", false
+		}
+		buf, err := hex.DecodeString(parts[1])
+		if err != nil || len(buf) != 4 {
+			return "E01", false
+		}
+		if err := s.d.WriteRegister(uint32(reg), binary.LittleEndian.Uint32(buf)); err != nil {
+			return s.fail(err), false
+		}
+		return "OK", false
+	case 'm':
+		addr, length, _, err := parseAddrLength(args)
+		if err != nil {
+			return "E01", false
+		}
+		buf := make([]byte, length)
+		if err := s.d.ReadMem(addr, buf); err != nil {
+			return s.fail(err), false
+		}
+		return hex.EncodeToString(buf), false
+	case 'M':
+		addr, _, data, err := parseAddrLength(args)
+		if err != nil {
+			return "E01", false
+		}
+		buf, err := hex.DecodeString(data)
+		if err != nil {
+			return "E01", false
+		}
+		if err := s.writeMem(addr, buf); err != nil {
+			return s.fail(err), false
+		}
+		return "OK", false
+	case 'c':
+		if err := s.flush(); err != nil {
+			return s.fail(err), false
+		}
+		if err := s.d.Resume(); err != nil {
+			return s.fail(err), false
+		}
+		return s.waitStop(c), false
+	case 's':
+		if err := s.flush(); err != nil {
+			return s.fail(err), false
+		}
+		if err := s.d.Step(); err != nil {
+			return s.fail(err), false
+		}
+		return gdbSigTrap, false
+	case 'Z', 'z':
+		// Software breakpoints (Z0) are implemented as hardware breakpoints,
+		// as the code is usually in flash.
+		parts := strings.Split(args, ",")
+		if len(parts) < 2 || (parts[0] != "0" && parts[0] != "1") {
+			return "", false // not supported
+		}
+		addr, err := strconv.ParseUint(parts[1], 16, 32)
+		if err != nil {
+			return "E01", false
+		}
+		if err := s.breakpoint(uint32(addr), packet[0] == 'Z'); err != nil {
+			return s.fail(err), false
+		}
+		return "OK", false
+	case 'D', 'k':
+		// Detach or kill: remove the breakpoints and let the program run.
+		s.flush()
+		for i, addr := range s.breakpoints {
+			if addr != 0 {
+				s.d.SetBreakpoint(i, 0)
+				s.breakpoints[i] = 0
+			}
+		}
+		s.d.Resume()
+		return "OK", true
+	default:
+		return "", false // not supported
+	}
+}
+
+// query handles the general query packets, of which the 'q' is stripped.
+func (s *GDBServer) query(args string) string {
+	switch {
+	case strings.HasPrefix(args, "Supported"):
+		return "PacketSize=1000;qXfer:features:read+"
+	case args == "Attached":
+		return "1"
+	case strings.HasPrefix(args, "Xfer:features:read:target.xml:"):
+		offset, length, _, err := parseAddrLength(strings.TrimPrefix(args, "Xfer:features:read:target.xml:"))
+		if err != nil {
+			return "E01"
+		}
+		if int(offset) >= len(gdbTargetXML) {
+			return "l"
+		}
+		chunk := gdbTargetXML[offset:]
+		if len(chunk) > int(length) {
+			return "m" + chunk[:length]
+		}
+		return "l" + chunk
+	case strings.HasPrefix(args, "Rcmd,"):
+		cmd, err := hex.DecodeString(strings.TrimPrefix(args, "Rcmd,"))
+		if err != nil {
+			return "E01"
+		}
+		if err := s.monitor(string(cmd)); err != nil {
+			return s.fail(err)
+		}
+		return "OK"
+	default:
+		return "" // not supported
+	}
+}
+
+// monitor executes a monitor command, like "monitor reset" in GDB. The
+// commands are the ones OpenOCD uses in the gdb-initial-cmds of targets.
+func (s *GDBServer) monitor(cmd string) error {
+	switch strings.TrimSpace(cmd) {
+	case "halt":
+		return s.d.Halt()
+	case "reset":
+		if err := s.flush(); err != nil {
+			return err
+		}
+		return s.d.Reset()
+	case "reset halt", "reset init":
+		if err := s.flush(); err != nil {
+			return err
+		}
+		return s.d.ResetHalt()
+	default:
+		return errors.New("unknown monitor command: " + cmd)
+	}
+}
+
+// waitStop waits until the core halts, for example on a breakpoint, or until
+// GDB interrupts it.
+func (s *GDBServer) waitStop(c *gdbConn) string {
+	for {
+		select {
+		case <-c.interrupts:
+			if err := s.d.Halt(); err != nil {
+				return s.fail(err)
+			}
+			return gdbSigInt
+		default:
+		}
+		halted, err := s.d.Halted()
+		if err != nil {
+			return s.fail(err)
+		}
+		if halted {
+			return gdbSigTrap
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeMem writes to memory. Writes to flash are collected, to be programmed
+// all at once by flush.
+func (s *GDBServer) writeMem(addr uint32, data []byte) error {
+	if s.FlashAlgo != "" && addr >= s.FlashStart && addr < s.FlashEnd {
+		s.flashWrites = append(s.flashWrites, gdbFlashWrite{addr, data})
+		return nil
+	}
+	return s.d.WriteMem(addr, data)
+}
+
+// flush programs the collected flash writes. The gaps between them are filled
+// with erased bytes.
+func (s *GDBServer) flush() error {
+	if len(s.flashWrites) == 0 {
+		return nil
+	}
+	start, end := s.flashWrites[0].addr, s.flashWrites[0].addr
+	for _, w := range s.flashWrites {
+		if w.addr < start {
+			start = w.addr
+		}
+		if w.addr+uint32(len(w.data)) > end {
+			end = w.addr + uint32(len(w.data))
+		}
+	}
+	start &^= 3
+	buf := make([]byte, end-start)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	for _, w := range s.flashWrites {
+		copy(buf[w.addr-start:], w.data)
+	}
+	s.flashWrites = nil
+	if err := s.d.Halt(); err != nil {
+		return err
+	}
+	return s.d.Flash(s.FlashAlgo, start, buf)
+}
+
+// breakpoint sets or removes a hardware breakpoint.
+func (s *GDBServer) breakpoint(addr uint32, set bool) error {
+	for i, bp := range s.breakpoints {
+		if set && bp == 0 || !set && bp == addr {
+			if set {
+				s.breakpoints[i] = addr
+				return s.d.SetBreakpoint(i, addr)
+			}
+			s.breakpoints[i] = 0
+			return s.d.SetBreakpoint(i, 0)
+		}
+	}
+	if set {
+		return errors.New("probe: no hardware breakpoints left")
+	}
+	return nil
+}
+
+// parseAddrLength parses the "addr,length" argument of memory packets,
+// optionally followed by ":" and data, which is returned too.
+func parseAddrLength(args string) (addr, length uint32, data string, err error) {
+	if colon := strings.IndexByte(args, ':'); colon >= 0 {
+		args, data = args[:colon], args[colon+1:]
+	}
+	parts := strings.Split(args, ",")
+	if len(parts) != 2 {
+		return 0, 0, "", errors.New("probe: invalid GDB packet")
+	}
+	a, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	l, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return uint32(a), uint32(l), data, nil
+}
+
+// fail returns an error reply. GDB only shows the error number, so the error
+// itself is written to the log.
+func (s *GDBServer) fail(err error) string {
+	if s.Log != nil {
+		fmt.Fprintln(s.Log, "gdb server:", err)
+	}
+	return "E01"
+}
@@ -0,0 +1,58 @@
+// +build linux
+
+package probe
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// openHID opens the HID interface of a CMSIS-DAP probe through the hidraw
+// driver. The interface is recognized by the "CMSIS-DAP" product string, which
+// the specification requires. When serial is not empty, only the probe with
+// that USB serial number is opened.
+func openHID(serial string) (io.ReadWriteCloser, error) {
+	devices, err := ioutil.ReadDir("/sys/class/hidraw")
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range devices {
+		name, uniq := readHIDUevent(filepath.Join("/sys/class/hidraw", dev.Name(), "device", "uevent"))
+		if !strings.Contains(name, "CMSIS-DAP") {
+			continue
+		}
+		if serial != "" && !strings.EqualFold(uniq, serial) {
+			continue
+		}
+		return os.OpenFile("/dev/"+dev.Name(), os.O_RDWR, 0)
+	}
+	if serial != "" {
+		return nil, errors.New("probe: no CMSIS-DAP probe found with serial number " + serial)
+	}
+	return nil, errors.New("probe: no CMSIS-DAP probe found")
+}
+
+// readHIDUevent returns the HID_NAME and HID_UNIQ (serial number) properties
+// of a HID device.
+func readHIDUevent(path string) (name, uniq string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "HID_NAME=") {
+			name = line[len("HID_NAME="):]
+		} else if strings.HasPrefix(line, "HID_UNIQ=") {
+			uniq = line[len("HID_UNIQ="):]
+		}
+	}
+	return name, uniq
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package probe
+
+import (
+	"errors"
+	"io"
+)
+
+// openHID is only implemented on Linux, where the hidraw driver gives direct
+// access to HID devices.
+func openHID(serial string) (io.ReadWriteCloser, error) {
+	return nil, errors.New("probe: CMSIS-DAP probes are only supported on Linux")
+}
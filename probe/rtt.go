@@ -0,0 +1,96 @@
+package probe
+
+// Support for SEGGER RTT (Real Time Transfer). The program on the chip keeps a
+// control block in RAM with ring buffers, which the probe reads while the chip
+// is running. This is much faster than semihosting and doesn't need a UART.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// rttID is the identifier at the start of the RTT control block.
+var rttID = []byte("SEGGER RTT\x00")
+
+// Offsets in the RTT control block. The identifier is followed by the number
+// of up and down buffers, and then the buffer descriptors.
+const (
+	rttMaxUpBuffers = 16
+	rttBuffers      = 24
+	rttBufferSize   = 24 // size of a buffer descriptor
+)
+
+// Offsets in an RTT buffer descriptor.
+const (
+	rttBufPtr   = 4
+	rttBufSize  = 8
+	rttBufWrOff = 12
+	rttBufRdOff = 16
+)
+
+// RTT is an RTT control block found in the memory of the chip.
+type RTT struct {
+	d       *CMSISDAP
+	addr    uint32
+	upCount uint32
+}
+
+// FindRTT searches the given range of RAM for an RTT control block.
+func (d *CMSISDAP) FindRTT(start, size uint32) (*RTT, error) {
+	buf := make([]byte, size)
+	if err := d.ReadMem(start, buf); err != nil {
+		return nil, err
+	}
+	index := bytes.Index(buf, rttID)
+	if index < 0 || index+rttBuffers > len(buf) {
+		return nil, errors.New("probe: no RTT control block found")
+	}
+	return &RTT{
+		d:       d,
+		addr:    start + uint32(index),
+		upCount: binary.LittleEndian.Uint32(buf[index+rttMaxUpBuffers:]),
+	}, nil
+}
+
+// Read reads the data that is available in the given up buffer (from the chip
+// to the host), without waiting for new data. It returns the number of bytes
+// read into buf.
+func (r *RTT) Read(channel int, buf []byte) (int, error) {
+	if channel < 0 || uint32(channel) >= r.upCount {
+		return 0, errors.New("probe: RTT channel out of range")
+	}
+	desc := r.addr + rttBuffers + uint32(channel)*rttBufferSize
+	var fields [4]uint32 // pointer, size, write offset, read offset
+	if err := r.d.ReadMem32(desc+rttBufPtr, fields[:]); err != nil {
+		return 0, err
+	}
+	ptr, size, wrOff, rdOff := fields[0], fields[1], fields[2], fields[3]
+	if size == 0 || wrOff >= size || rdOff >= size {
+		return 0, errors.New("probe: corrupt RTT buffer descriptor")
+	}
+	n := 0
+	for rdOff != wrOff && n < len(buf) {
+		// Read up to the write offset or the end of the ring buffer.
+		end := wrOff
+		if wrOff < rdOff {
+			end = size
+		}
+		chunk := int(end - rdOff)
+		if chunk > len(buf)-n {
+			chunk = len(buf) - n
+		}
+		if err := r.d.ReadMem(ptr+rdOff, buf[n:n+chunk]); err != nil {
+			return n, err
+		}
+		n += chunk
+		rdOff = (rdOff + uint32(chunk)) % size
+	}
+	if n != 0 {
+		// Let the chip know the data was consumed.
+		if err := r.d.WriteWord(desc+rttBufRdOff, rdOff); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
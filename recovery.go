@@ -0,0 +1,66 @@
+package main
+
+// This file builds images that contain a small recovery app besides the main
+// app. The main app is linked at the start of the flash as usual, the recovery
+// app at a fixed offset after it (-recovery-offset), and both are combined in
+// one firmware image. The programs can start each other with
+// machine.BootInto, so that the recovery app can for example update a broken
+// main app in the field without the need for a separate bootloader.
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// checkRecovery returns an error if a recovery app can't be added to the given
+// output file for this target.
+func checkRecovery(outext string, spec *TargetSpec, config *BuildConfig) error {
+	isCortexM := false
+	for _, tag := range spec.BuildTags {
+		if tag == "cortexm" {
+			isCortexM = true
+		}
+	}
+	if !isCortexM {
+		// Only the Cortex-M linker script supports program slots.
+		return errors.New("a recovery app is only supported on Cortex-M targets")
+	}
+	if config.recoveryAt == 0 {
+		return errors.New("a recovery app needs an address, please set -recovery-offset")
+	}
+	switch outext {
+	case ".hex", ".bin", ".uf2":
+		return nil
+	default:
+		return errors.New("a recovery app can only be combined with the main app in a .hex, .bin or .uf2 file")
+	}
+}
+
+// slotLDFlags returns the linker flags that place the program in its slot of
+// the flash, see targets/arm.ld. The main app must end before the recovery
+// app, which starts at the recovery offset.
+func slotLDFlags(config *BuildConfig) []string {
+	if config.recoveryAt == 0 {
+		return nil
+	}
+	offset := "0x" + strconv.FormatUint(uint64(config.recoveryAt), 16)
+	if config.isRecovery {
+		return []string{"--defsym=_recovery_offset=" + offset, "--defsym=_slot_offset=" + offset}
+	}
+	return []string{"--defsym=_recovery_offset=" + offset, "--defsym=_slot_size=" + offset}
+}
+
+// buildRecovery compiles the recovery app for the same target and stores the
+// resulting ELF file in the given directory. It returns the path to the file.
+func buildRecovery(dir string, spec *TargetSpec, config *BuildConfig) (string, error) {
+	recoveryConfig := *config
+	recoveryConfig.recovery = ""
+	recoveryConfig.isRecovery = true
+	path := filepath.Join(dir, "recovery.elf")
+	err := Compile(config.recovery, ".elf", spec, &recoveryConfig, func(tmppath string) error {
+		return os.Rename(tmppath, path)
+	})
+	return path, err
+}
@@ -0,0 +1,206 @@
+package main
+
+// This file finds the serial port of a connected board, for flash commands
+// that need one. Ports are identified by the USB vendor and product ID listed
+// in the "serial-port" property of the target, so that the right board is
+// picked when several boards are connected.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SerialPort is a serial port on the host, usually a USB CDC-ACM or USB to
+// serial converter of a development board.
+type SerialPort struct {
+	Path    string // device path, like /dev/ttyACM0
+	VID     string // USB vendor ID in lowercase hexadecimal, empty if unknown
+	PID     string // USB product ID in lowercase hexadecimal, empty if unknown
+	Serial  string // USB serial number, empty if unknown
+	Product string // USB product description, empty if unknown
+}
+
+// String returns a one-line description of the port, as shown by -list-ports.
+func (p SerialPort) String() string {
+	s := p.Path
+	if p.VID != "" {
+		s += " " + p.VID + ":" + p.PID
+	}
+	if p.Serial != "" {
+		s += " serial=" + p.Serial
+	}
+	if p.Product != "" {
+		s += " (" + p.Product + ")"
+	}
+	return s
+}
+
+// matches returns whether this port has one of the given USB IDs, in the form
+// "vid:pid" as used in the "serial-port" target property.
+func (p SerialPort) matches(ids []string) bool {
+	for _, id := range ids {
+		if strings.ToLower(id) == p.VID+":"+p.PID {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSerialPorts returns the serial ports that are currently connected. The
+// USB IDs and serial numbers are only known on Linux, on macOS only the device
+// paths are returned.
+func ListSerialPorts() ([]SerialPort, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return listSerialPortsSysfs()
+	case "darwin":
+		paths, err := filepath.Glob("/dev/cu.usb*")
+		if err != nil {
+			return nil, err
+		}
+		var ports []SerialPort
+		for _, path := range paths {
+			ports = append(ports, SerialPort{Path: path})
+		}
+		return ports, nil
+	default:
+		return nil, errors.New("listing serial ports is not supported on " + runtime.GOOS + ", please specify the port with -port")
+	}
+}
+
+// listSerialPortsSysfs lists all USB serial ports using the information in
+// /sys/class/tty.
+func listSerialPortsSysfs() ([]SerialPort, error) {
+	ttys, err := ioutil.ReadDir("/sys/class/tty")
+	if err != nil {
+		return nil, err
+	}
+	var ports []SerialPort
+	for _, tty := range ttys {
+		dir, err := filepath.EvalSymlinks(filepath.Join("/sys/class/tty", tty.Name(), "device"))
+		if err != nil {
+			continue // not backed by a device, like a virtual console
+		}
+		// The device is a USB interface (or a child of one for USB to serial
+		// converters). Walk up to the USB device, which has the IDs.
+		for ; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+			if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+				break
+			}
+		}
+		if dir == "/" || dir == "." {
+			continue // not a USB device, like a built-in UART
+		}
+		ports = append(ports, SerialPort{
+			Path:    "/dev/" + tty.Name(),
+			VID:     readSysfsAttr(dir, "idVendor"),
+			PID:     readSysfsAttr(dir, "idProduct"),
+			Serial:  readSysfsAttr(dir, "serial"),
+			Product: readSysfsAttr(dir, "product"),
+		})
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].Path < ports[j].Path
+	})
+	return ports, nil
+}
+
+// readSysfsAttr reads a single attribute from a sysfs directory, or returns
+// the empty string if it doesn't exist.
+func readSysfsAttr(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(string(data)))
+}
+
+// findSerialPort determines the port to use for flashing. The port may be a
+// device path, a USB serial number of a connected board or empty to pick the
+// board that matches the USB IDs of the target. When several boards match and
+// stdin is a terminal, the user is asked to choose one.
+func findSerialPort(port string, spec *TargetSpec) (string, error) {
+	if strings.HasPrefix(port, "/") || strings.HasPrefix(strings.ToUpper(port), "COM") {
+		return port, nil // device path
+	}
+	ports, err := ListSerialPorts()
+	if err != nil {
+		return "", err
+	}
+	if port != "" {
+		for _, p := range ports {
+			if p.Serial != "" && p.Serial == strings.ToLower(port) {
+				return p.Path, nil
+			}
+		}
+		return "", errors.New("no board found with serial number " + port)
+	}
+
+	// Prefer the ports that are known to belong to this target. Fall back to
+	// all ports for boards with unknown (or cloned) USB IDs.
+	var candidates []SerialPort
+	for _, p := range ports {
+		if p.matches(spec.SerialPort) || p.VID == "" {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = ports
+	}
+	switch len(candidates) {
+	case 0:
+		return "", errors.New("no serial port found, please specify the port with -port")
+	case 1:
+		return candidates[0].Path, nil
+	}
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		msg := "multiple serial ports found, please specify one with -port:"
+		for _, p := range candidates {
+			msg += "\n  " + p.String()
+		}
+		return "", errors.New(msg)
+	}
+	fmt.Fprintln(os.Stderr, "Multiple serial ports found:")
+	for i, p := range candidates {
+		fmt.Fprintf(os.Stderr, "  %d: %s\n", i+1, p)
+	}
+	fmt.Fprint(os.Stderr, "Port to flash: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(candidates) {
+		return "", errors.New("invalid port number: " + strings.TrimSpace(line))
+	}
+	return candidates[n-1].Path, nil
+}
+
+// printSerialPorts prints all connected serial ports, marking the ones that
+// belong to the given target. This is the -list-ports flag.
+func printSerialPorts(spec *TargetSpec) error {
+	ports, err := ListSerialPorts()
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		fmt.Println("no serial ports found")
+		return nil
+	}
+	for _, p := range ports {
+		marker := " "
+		if spec != nil && p.matches(spec.SerialPort) {
+			marker = "*"
+		}
+		fmt.Println(marker, p)
+	}
+	return nil
+}
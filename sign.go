@@ -0,0 +1,91 @@
+package main
+
+// This file embeds a hash of the firmware image in the image itself, and
+// optionally an Ed25519 signature of the hash, so that the image can be
+// verified by a secure bootloader without external scripts. The target
+// specifies where the header field is ("image-hash-offset", relative to the
+// start of the image) and must reserve it, for example in its startup code:
+//
+//     offset + 0:  SHA-256 hash of the image (32 bytes)
+//     offset + 32: Ed25519 signature of the hash (64 bytes, with -sign-key)
+//
+// The hash covers the whole image as it is written to flash, with the header
+// field set to zero.
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	imageHashSize      = sha256.Size
+	imageSignatureSize = 64
+)
+
+// embedImageHash writes the hash (and signature) of the firmware image into
+// the header field of the linked ELF file, before other file formats are
+// created from it. It does nothing for targets without a header field.
+func embedImageHash(executable string, spec *TargetSpec, config *BuildConfig) error {
+	if spec.ImageHash == "" {
+		if config.signKey != "" {
+			return errors.New("cannot sign the image: the target has no image-hash-offset")
+		}
+		return nil
+	}
+	offset, err := strconv.ParseUint(spec.ImageHash, 0, 32)
+	if err != nil {
+		return fmt.Errorf("invalid image-hash-offset %#v in target", spec.ImageHash)
+	}
+	size := uint64(imageHashSize)
+	if config.signKey != "" {
+		size += imageSignatureSize
+	}
+
+	addr, image, err := ExtractROM(executable)
+	if err != nil {
+		return err
+	}
+	if offset+size > uint64(len(image)) {
+		return fmt.Errorf("image-hash-offset 0x%x is outside the image of %d bytes", offset, len(image))
+	}
+	for i := offset; i < offset+size; i++ {
+		image[i] = 0
+	}
+	hash := sha256.Sum256(image)
+	field := hash[:]
+	if config.signKey != "" {
+		signature, err := signImageHash(config.signKey, field)
+		if err != nil {
+			return err
+		}
+		field = append(field, signature...)
+	}
+
+	// Find the header field in the ELF file.
+	f, err := elf.Open(executable)
+	if err != nil {
+		return err
+	}
+	fieldAddr := addr + offset
+	fileOffset := int64(-1)
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Paddr <= fieldAddr && fieldAddr+size <= prog.Paddr+prog.Filesz {
+			fileOffset = int64(prog.Off + fieldAddr - prog.Paddr)
+		}
+	}
+	f.Close()
+	if fileOffset < 0 {
+		return fmt.Errorf("image-hash-offset 0x%x is not in a loaded segment", offset)
+	}
+	out, err := os.OpenFile(executable, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = out.WriteAt(field, fileOffset)
+	return err
+}
@@ -0,0 +1,11 @@
+// +build !go1.13
+
+package main
+
+import "errors"
+
+// signImageHash is not supported before Go 1.13, which added the
+// crypto/ed25519 package.
+func signImageHash(keyFile string, hash []byte) ([]byte, error) {
+	return nil, errors.New("signing images requires TinyGo to be built with Go 1.13 or later")
+}
@@ -0,0 +1,33 @@
+// +build go1.13
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+)
+
+// signImageHash signs the hash with the Ed25519 private key in the given PEM
+// file (PKCS #8, as created by `openssl genpkey -algorithm ed25519`).
+func signImageHash(keyFile string, hash []byte) ([]byte, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in " + keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key: " + keyFile)
+	}
+	return ed25519.Sign(privateKey, hash), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+// This file checks for code size regressions by compiling the programs in
+// testdata/size for a number of targets and comparing their size with the
+// budgets recorded in testdata/size/budgets.txt. It is only run when the
+// -size-budgets flag is passed, see `make test-size`.
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	checkSizeBudgets  = flag.Bool("size-budgets", false, "check code size budgets in testdata/size")
+	updateSizeBudgets = flag.Bool("update-size-budgets", false, "record the current code size in testdata/size/budgets.txt")
+	sizeTolerance     = flag.Float64("size-tolerance", 1, "allowed code size increase over the budget, in percent")
+)
+
+// sizeBudget is a single line in the size budgets file.
+type sizeBudget struct {
+	target  string
+	program string
+	flash   uint64
+	ram     uint64
+}
+
+func TestSizeBudgets(t *testing.T) {
+	if !*checkSizeBudgets && !*updateSizeBudgets {
+		t.Skip("code size budgets are only checked with -size-budgets")
+	}
+
+	budgetsPath := filepath.Join(TESTDATA, "size", "budgets.txt")
+	header, budgets, err := readSizeBudgets(budgetsPath)
+	if err != nil {
+		t.Fatal("could not read size budgets:", err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for i := range budgets {
+		budget := &budgets[i]
+		t.Run(budget.target+"/"+budget.program, func(t *testing.T) {
+			flash, ram, err := measureSize(budget.target, budget.program, tmpdir)
+			if err != nil {
+				t.Fatal("failed to build:", err)
+			}
+			if *updateSizeBudgets {
+				budget.flash = flash
+				budget.ram = ram
+				return
+			}
+			if budget.flash == 0 && budget.ram == 0 {
+				t.Fatalf("no budget recorded (flash=%d ram=%d), run `make update-size-budgets`", flash, ram)
+			}
+			if exceedsBudget(flash, budget.flash) {
+				t.Errorf("flash usage increased from %d to %d bytes (%+d)", budget.flash, flash, int64(flash)-int64(budget.flash))
+			}
+			if exceedsBudget(ram, budget.ram) {
+				t.Errorf("RAM usage increased from %d to %d bytes (%+d)", budget.ram, ram, int64(ram)-int64(budget.ram))
+			}
+			if flash < budget.flash || ram < budget.ram {
+				t.Logf("size decreased: flash=%d (budget %d) ram=%d (budget %d), consider updating the budget", flash, budget.flash, ram, budget.ram)
+			}
+		})
+	}
+
+	if *updateSizeBudgets {
+		err := writeSizeBudgets(budgetsPath, header, budgets)
+		if err != nil {
+			t.Fatal("could not write size budgets:", err)
+		}
+	}
+}
+
+// measureSize builds the given program in testdata/size for the given target
+// and returns its flash and static RAM usage.
+func measureSize(target, program, tmpdir string) (flash, ram uint64, err error) {
+	config := &BuildConfig{
+		opt:        "z",
+		printSizes: "",
+	}
+	binary := filepath.Join(tmpdir, target+"-"+program+".elf")
+	err = Build("./"+filepath.Join(TESTDATA, "size", program)+string(filepath.Separator), binary, target, config)
+	if err != nil {
+		return 0, 0, err
+	}
+	sizes, err := Sizes(binary)
+	if err != nil {
+		return 0, 0, err
+	}
+	return sizes.Code + sizes.Data, sizes.Data + sizes.BSS, nil
+}
+
+// exceedsBudget returns whether the given size is more than the allowed
+// tolerance above the budget.
+func exceedsBudget(size, budget uint64) bool {
+	return float64(size) > float64(budget)*(1+*sizeTolerance/100)
+}
+
+// readSizeBudgets parses the budgets file. It returns the leading comment
+// block separately so that it can be preserved when the file is rewritten.
+func readSizeBudgets(path string) (header []byte, budgets []sizeBudget, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			if len(budgets) == 0 {
+				header = append(header, line+"\n"...)
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, nil, fmt.Errorf("%s:%d: expected 4 fields", path, lineNumber)
+		}
+		flash, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: invalid flash size: %v", path, lineNumber, err)
+		}
+		ram, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: invalid RAM size: %v", path, lineNumber, err)
+		}
+		budgets = append(budgets, sizeBudget{fields[0], fields[1], flash, ram})
+	}
+	return header, budgets, scanner.Err()
+}
+
+// writeSizeBudgets writes the budgets file, in the same format as read by
+// readSizeBudgets.
+func writeSizeBudgets(path string, header []byte, budgets []sizeBudget) error {
+	buf := bytes.NewBuffer(header)
+	for _, budget := range budgets {
+		fmt.Fprintf(buf, "%-21s %-11s %-6d %d\n", budget.target, budget.program, budget.flash, budget.ram)
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0666)
+}
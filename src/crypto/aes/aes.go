@@ -0,0 +1,77 @@
+// Package aes implements a subset of the Go "crypto/aes" package. See
+// https://godoc.org/crypto/aes for details.
+//
+// Blocks are encrypted with the AES hardware of the chip when available (see
+// machine.CryptoAES). The software fallback is a compact byte-oriented
+// implementation without the large lookup tables of the standard library,
+// which is slower but much smaller. Note that, like the standard library on
+// most architectures without AES instructions, it is not constant time.
+package aes
+
+import (
+	"crypto/cipher"
+	"machine"
+	"strconv"
+)
+
+// The AES block size in bytes.
+const BlockSize = 16
+
+// KeySizeError is returned for keys that are not 16, 24 or 32 bytes long.
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+	return "crypto/aes: invalid key size " + strconv.Itoa(int(k))
+}
+
+// aesCipher is an instance of AES encryption using a particular key.
+type aesCipher struct {
+	key    []byte
+	rounds int
+	enc    [60]uint32 // expanded key, 4 words per round
+}
+
+// NewCipher creates and returns a new cipher.Block. The key argument should
+// be the AES key, either 16, 24, or 32 bytes to select AES-128, AES-192, or
+// AES-256.
+func NewCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, KeySizeError(len(key))
+	}
+	c := &aesCipher{
+		key:    append([]byte(nil), key...),
+		rounds: len(key)/4 + 6,
+	}
+	expandKey(key, c.enc[:(c.rounds+1)*4])
+	return c, nil
+}
+
+func (c *aesCipher) BlockSize() int { return BlockSize }
+
+func (c *aesCipher) Encrypt(dst, src []byte) {
+	if len(src) < BlockSize {
+		panic("crypto/aes: input not full block")
+	}
+	if len(dst) < BlockSize {
+		panic("crypto/aes: output not full block")
+	}
+	if machine.CryptoAES != nil && machine.CryptoAES.EncryptBlock(c.key, dst, src) {
+		return
+	}
+	encryptBlock(c.enc[:], c.rounds, dst, src)
+}
+
+func (c *aesCipher) Decrypt(dst, src []byte) {
+	if len(src) < BlockSize {
+		panic("crypto/aes: input not full block")
+	}
+	if len(dst) < BlockSize {
+		panic("crypto/aes: output not full block")
+	}
+	if machine.CryptoAES != nil && machine.CryptoAES.DecryptBlock(c.key, dst, src) {
+		return
+	}
+	decryptBlock(c.enc[:], c.rounds, dst, src)
+}
@@ -0,0 +1,47 @@
+// Package rand implements a subset of the Go "crypto/rand" package. See
+// https://godoc.org/crypto/rand for details.
+//
+// Random data is read from the hardware random number generator of the chip
+// with machine.GetRNG. On chips without one, it is gathered from ADC noise,
+// which is much slower. Reads fail with machine.ErrNoRNG on chips where neither
+// is available.
+//
+// To get a different sequence from math/rand on every boot, seed it from this
+// package:
+//
+//	var seed [8]byte
+//	rand.Read(seed[:])
+//	mathrand.Seed(int64(binary.LittleEndian.Uint64(seed[:])))
+package rand
+
+import (
+	"io"
+	"machine"
+)
+
+// Reader is a global, shared instance of a cryptographically secure random
+// number generator.
+var Reader io.Reader = &reader{}
+
+type reader struct{}
+
+func (r *reader) Read(b []byte) (n int, err error) {
+	for n < len(b) {
+		value, err := machine.GetRNG()
+		if err != nil {
+			return n, err
+		}
+		for i := 0; i < 4 && n < len(b); i++ {
+			b[n] = byte(value)
+			value >>= 8
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Read is a helper function that calls Reader.Read using io.ReadFull. On
+// return, n == len(b) if and only if err == nil.
+func Read(b []byte) (n int, err error) {
+	return io.ReadFull(Reader, b)
+}
@@ -0,0 +1,179 @@
+// Package tls implements a subset of the Go "crypto/tls" package. See
+// https://godoc.org/crypto/tls for details.
+//
+// The TLS protocol itself is not implemented in Go: connections are encrypted
+// by the network device (for example the TLS support in the firmware of a
+// WiFi co-processor), see net.TLSConn. This keeps the code size small, but it
+// means TLS is only supported on connections made with Dial, not on arbitrary
+// net.Conn values, and that certificates are verified by the device.
+package tls
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// TLS versions, for compatibility. The version used for a connection is
+// decided by the network device.
+const (
+	VersionTLS10 = 0x0301
+	VersionTLS11 = 0x0302
+	VersionTLS12 = 0x0303
+	VersionTLS13 = 0x0304
+)
+
+var errNotSupported = errors.New("tls: only connections made with tls.Dial are supported")
+
+// A Config structure is used to configure a TLS client. Only the fields below
+// are supported.
+type Config struct {
+	// ServerName is used to verify the hostname on the returned certificates
+	// and is sent in the handshake (SNI). It defaults to the host that is
+	// dialed.
+	ServerName string
+
+	// InsecureSkipVerify controls whether a client verifies the server's
+	// certificate chain and host name. Not all network devices support this.
+	InsecureSkipVerify bool
+
+	// RootCAsPEM contains PEM encoded certificates that are trusted in
+	// addition to the certificates stored in the network device. This field
+	// replaces RootCAs, as the device does the verification.
+	RootCAsPEM []byte
+
+	// MinVersion and MaxVersion are accepted for compatibility but ignored.
+	MinVersion uint16
+	MaxVersion uint16
+}
+
+// Clone returns a copy of c.
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	return &clone
+}
+
+// ConnectionState records basic TLS details about the connection.
+type ConnectionState struct {
+	HandshakeComplete bool   // TLS handshake is complete
+	ServerName        string // server name used for SNI
+}
+
+// Conn represents a secured connection. It implements the net.Conn interface.
+type Conn struct {
+	conn *net.TLSConn
+	err  error // set for connections created with Client
+}
+
+// Dial connects to the given network address using net.Dial and then
+// initiates a TLS handshake, returning the resulting TLS connection.
+func Dial(network, addr string, config *Config) (*Conn, error) {
+	return DialWithDialer(new(net.Dialer), network, addr, config)
+}
+
+// DialWithDialer connects to the given network address using dialer.Dial and
+// then initiates a TLS handshake, returning the resulting TLS connection. Any
+// timeout or deadline given in the dialer apply to connection and TLS
+// handshake as a whole.
+func DialWithDialer(dialer *net.Dialer, network, addr string, config *Config) (*Conn, error) {
+	var options net.TLSOptions
+	if config != nil {
+		options.ServerName = config.ServerName
+		options.InsecureSkipVerify = config.InsecureSkipVerify
+		options.RootCAs = config.RootCAsPEM
+	}
+	c, err := dialer.DialTLS(network, addr, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: c}, nil
+}
+
+// Client returns a new TLS client side connection using conn as the
+// underlying transport. This is not supported, as TLS is done by the network
+// device: all operations on the returned connection fail.
+func Client(conn net.Conn, config *Config) *Conn {
+	return &Conn{err: errNotSupported}
+}
+
+// Handshake runs the client handshake if it has not yet been run. With TLS
+// offloading, the handshake is done by Dial.
+func (c *Conn) Handshake() error {
+	return c.err
+}
+
+// ConnectionState returns basic TLS details about the connection.
+func (c *Conn) ConnectionState() ConnectionState {
+	if c.err != nil {
+		return ConnectionState{}
+	}
+	return ConnectionState{HandshakeComplete: true, ServerName: c.conn.ServerName()}
+}
+
+// Read reads data from the connection.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Read(b)
+}
+
+// Write writes data to the connection.
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Write(b)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.err != nil {
+		return nil
+	}
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.err != nil {
+		return nil
+	}
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines associated with the
+// connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline on the underlying connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline on the underlying connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.conn.SetWriteDeadline(t)
+}
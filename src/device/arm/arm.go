@@ -73,6 +73,7 @@ func SVCall4(num uintptr, a1, a2, a3, a4 interface{}) uintptr
 const (
 	SCS_BASE  = 0xE000E000
 	NVIC_BASE = SCS_BASE + 0x0100
+	SCB_BASE  = SCS_BASE + 0x0D00
 )
 
 // Nested Vectored Interrupt Controller (NVIC).
@@ -95,11 +96,38 @@ type NVIC_Type struct {
 
 var NVIC = (*NVIC_Type)(unsafe.Pointer(uintptr(NVIC_BASE)))
 
+// System Control Block (SCB).
+//
+// Source:
+// http://infocenter.arm.com/help/index.jsp?topic=/com.arm.doc.dui0553a/CIHFDJCA.html
+type SCB_Type struct {
+	CPUID volatile.Register32 // CPUID Base Register
+	ICSR  volatile.Register32 // Interrupt Control and State Register
+	VTOR  volatile.Register32 // Vector Table Offset Register
+	AIRCR volatile.Register32 // Application Interrupt and Reset Control Register
+	SCR   volatile.Register32 // System Control Register
+	CCR   volatile.Register32 // Configuration and Control Register
+}
+
+var SCB = (*SCB_Type)(unsafe.Pointer(uintptr(SCB_BASE)))
+
+// Bits in the System Control Register.
+const (
+	SCB_SCR_SLEEPONEXIT = 1 << 1 // sleep when returning from an interrupt to thread mode
+	SCB_SCR_SLEEPDEEP   = 1 << 2 // use deep sleep instead of sleep for WFI/WFE
+	SCB_SCR_SEVONPEND   = 1 << 4 // wake from WFE on pending (also disabled) interrupts
+)
+
 // Enable the given interrupt number.
 func EnableIRQ(irq uint32) {
 	NVIC.ISER[irq>>5].Set(1 << (irq & 0x1F))
 }
 
+// Disable the given interrupt number.
+func DisableIRQ(irq uint32) {
+	NVIC.ICER[irq>>5].Set(1 << (irq & 0x1F))
+}
+
 // Set the priority of the given interrupt number.
 // Note that the priority is given as a 0-255 number, where some of the lower
 // bits are not implemented by the hardware. For example, to set a low interrupt
@@ -117,20 +145,15 @@ func SetPriority(irq uint32, priority uint32) {
 	NVIC.IPR[regnum].Set((uint32(NVIC.IPR[regnum].Get()) &^ mask) | priority)
 }
 
-// DisableInterrupts disables all interrupts, and returns the old state.
+// DisableInterrupts disables all interrupts, and returns the old state. It is
+// implemented in cortexm.s.
 //
-// TODO: it doesn't actually return the old state, meaning that it cannot be
-// nested.
-func DisableInterrupts() uintptr {
-	Asm("cpsid if")
-	return 0
-}
+//go:export tinygo_disableInterrupts
+func DisableInterrupts() uintptr
 
 // EnableInterrupts enables all interrupts again. The value passed in must be
-// the mask returned by DisableInterrupts.
+// the mask returned by DisableInterrupts: interrupts stay disabled if they were
+// already disabled before, so that calls can be nested.
 //
-// TODO: it doesn't actually use the old state, meaning that it cannot be
-// nested.
-func EnableInterrupts(mask uintptr) {
-	Asm("cpsie if")
-}
+//go:export tinygo_enableInterrupts
+func EnableInterrupts(mask uintptr)
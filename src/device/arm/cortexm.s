@@ -19,3 +19,19 @@ HardFault_Handler:
 
     // Continue handling this error in Go.
     bl handleHardFault
+
+.section .text.tinygo_disableInterrupts
+.global  tinygo_disableInterrupts
+.type    tinygo_disableInterrupts, %function
+tinygo_disableInterrupts:
+    // Return the old value of PRIMASK, so that the caller can restore it.
+    mrs r0, PRIMASK
+    cpsid i
+    bx lr
+
+.section .text.tinygo_enableInterrupts
+.global  tinygo_enableInterrupts
+.type    tinygo_enableInterrupts, %function
+tinygo_enableInterrupts:
+    msr PRIMASK, r0
+    bx lr
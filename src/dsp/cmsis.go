@@ -0,0 +1,103 @@
+// Automatically generated file. DO NOT EDIT.
+// Generated by gen-cmsis-dsp.py from arm_math.h.
+
+// +build cortexm
+
+package dsp
+
+// armStatus is the arm_status enum of CMSIS-DSP.
+type armStatus int8
+
+// biquadCasdDf1InstF32 is arm_biquad_casd_df1_inst_f32 from CMSIS-DSP.
+type biquadCasdDf1InstF32 struct {
+	numStages uint32
+	pState    *float32
+	pCoeffs   *float32
+}
+
+// cfftInstanceF32 is arm_cfft_instance_f32 from CMSIS-DSP.
+type cfftInstanceF32 struct {
+	fftLen       uint16
+	pTwiddle     *float32
+	pBitRevTable *uint16
+	bitRevLength uint16
+}
+
+// firInstanceF32 is arm_fir_instance_f32 from CMSIS-DSP.
+type firInstanceF32 struct {
+	numTaps uint16
+	pState  *float32
+	pCoeffs *float32
+}
+
+// firInstanceQ31 is arm_fir_instance_q31 from CMSIS-DSP.
+type firInstanceQ31 struct {
+	numTaps uint16
+	pState  *int32
+	pCoeffs *int32
+}
+
+// matrixInstanceF32 is arm_matrix_instance_f32 from CMSIS-DSP.
+type matrixInstanceF32 struct {
+	numRows uint16
+	numCols uint16
+	pData   *float32
+}
+
+// rfftFastInstanceF32 is arm_rfft_fast_instance_f32 from CMSIS-DSP.
+type rfftFastInstanceF32 struct {
+	Sint         cfftInstanceF32
+	fftLenRFFT   uint16
+	pTwiddleRFFT *float32
+}
+
+//go:export arm_biquad_cascade_df1_f32
+func arm_biquad_cascade_df1_f32(S *biquadCasdDf1InstF32, pSrc *float32, pDst *float32, blockSize uint32)
+
+//go:export arm_biquad_cascade_df1_init_f32
+func arm_biquad_cascade_df1_init_f32(S *biquadCasdDf1InstF32, numStages uint8, pCoeffs *float32, pState *float32)
+
+//go:export arm_dot_prod_f32
+func arm_dot_prod_f32(pSrcA *float32, pSrcB *float32, blockSize uint32, result *float32)
+
+//go:export arm_fir_f32
+func arm_fir_f32(S *firInstanceF32, pSrc *float32, pDst *float32, blockSize uint32)
+
+//go:export arm_fir_init_f32
+func arm_fir_init_f32(S *firInstanceF32, numTaps uint16, pCoeffs *float32, pState *float32, blockSize uint32)
+
+//go:export arm_fir_init_q31
+func arm_fir_init_q31(S *firInstanceQ31, numTaps uint16, pCoeffs *int32, pState *int32, blockSize uint32)
+
+//go:export arm_fir_q31
+func arm_fir_q31(S *firInstanceQ31, pSrc *int32, pDst *int32, blockSize uint32)
+
+//go:export arm_mat_add_f32
+func arm_mat_add_f32(pSrcA *matrixInstanceF32, pSrcB *matrixInstanceF32, pDst *matrixInstanceF32) armStatus
+
+//go:export arm_mat_init_f32
+func arm_mat_init_f32(S *matrixInstanceF32, nRows uint16, nColumns uint16, pData *float32)
+
+//go:export arm_mat_inverse_f32
+func arm_mat_inverse_f32(src *matrixInstanceF32, dst *matrixInstanceF32) armStatus
+
+//go:export arm_mat_mult_f32
+func arm_mat_mult_f32(pSrcA *matrixInstanceF32, pSrcB *matrixInstanceF32, pDst *matrixInstanceF32) armStatus
+
+//go:export arm_mat_trans_f32
+func arm_mat_trans_f32(pSrc *matrixInstanceF32, pDst *matrixInstanceF32) armStatus
+
+//go:export arm_max_f32
+func arm_max_f32(pSrc *float32, blockSize uint32, pResult *float32, pIndex *uint32)
+
+//go:export arm_mean_f32
+func arm_mean_f32(pSrc *float32, blockSize uint32, pResult *float32)
+
+//go:export arm_rfft_fast_f32
+func arm_rfft_fast_f32(S *rfftFastInstanceF32, p *float32, pOut *float32, ifftFlag uint8)
+
+//go:export arm_rfft_fast_init_f32
+func arm_rfft_fast_init_f32(S *rfftFastInstanceF32, fftLen uint16) armStatus
+
+//go:export arm_rms_f32
+func arm_rms_f32(pSrc *float32, blockSize uint32, pResult *float32)
@@ -0,0 +1,318 @@
+// +build cortexm
+
+// Package dsp provides signal processing routines (filters, FFTs, matrices
+// and vector statistics) implemented by the CMSIS-DSP library from ARM. The
+// library is compiled and linked automatically when this package is imported.
+//
+// The routines use the DSP and floating point instructions of the chip when
+// available: the Cortex-M4 versions are much faster than the Cortex-M0 and
+// Cortex-M3 versions. This package is only available on Cortex-M chips.
+//
+// The bindings to the C library are generated by tools/gen-cmsis-dsp.py, the
+// types in this file wrap them in a Go API.
+package dsp
+
+import (
+	"errors"
+
+	"fixedpoint"
+)
+
+// Errors returned by the routines in this package, corresponding to the
+// arm_status values of CMSIS-DSP.
+var (
+	ErrArgument = errors.New("dsp: invalid argument")
+	ErrLength   = errors.New("dsp: invalid length")
+	ErrSize     = errors.New("dsp: matrix size mismatch")
+	ErrNaNInf   = errors.New("dsp: NaN or infinity generated")
+	ErrSingular = errors.New("dsp: matrix is singular")
+)
+
+// err converts an arm_status to an error.
+func (status armStatus) err() error {
+	switch status {
+	case 0:
+		return nil
+	case -1:
+		return ErrArgument
+	case -2:
+		return ErrLength
+	case -3:
+		return ErrSize
+	case -4:
+		return ErrNaNInf
+	case -5:
+		return ErrSingular
+	default:
+		return errors.New("dsp: unknown error")
+	}
+}
+
+// FIR is a finite impulse response filter for floating point samples.
+type FIR struct {
+	instance  firInstanceF32
+	coeffs    []float32
+	state     []float32
+	blockSize int
+}
+
+// NewFIR creates a FIR filter with the given coefficients (in time-reversed
+// order, as in CMSIS-DSP). The block size is the maximum number of samples
+// processed at once; larger buffers are processed in multiple blocks.
+func NewFIR(coeffs []float32, blockSize int) *FIR {
+	if len(coeffs) == 0 || len(coeffs) > 0xffff || blockSize <= 0 {
+		panic("dsp: invalid FIR parameters")
+	}
+	f := &FIR{
+		coeffs:    coeffs,
+		state:     make([]float32, len(coeffs)+blockSize-1),
+		blockSize: blockSize,
+	}
+	arm_fir_init_f32(&f.instance, uint16(len(coeffs)), &f.coeffs[0], &f.state[0], uint32(blockSize))
+	return f
+}
+
+// Process filters the samples in src and stores the result in dst, which must
+// be at least as long as src. The filter keeps its state between calls.
+func (f *FIR) Process(src, dst []float32) {
+	if len(dst) < len(src) {
+		panic("dsp: destination too short")
+	}
+	for len(src) != 0 {
+		n := len(src)
+		if n > f.blockSize {
+			n = f.blockSize
+		}
+		arm_fir_f32(&f.instance, &src[0], &dst[0], uint32(n))
+		src = src[n:]
+		dst = dst[n:]
+	}
+}
+
+// FIRQ31 is a finite impulse response filter for Q31 fixed-point samples.
+type FIRQ31 struct {
+	instance  firInstanceQ31
+	coeffs    []fixedpoint.Q31
+	state     []fixedpoint.Q31
+	blockSize int
+}
+
+// NewFIRQ31 creates a FIR filter for Q31 samples, see NewFIR.
+func NewFIRQ31(coeffs []fixedpoint.Q31, blockSize int) *FIRQ31 {
+	if len(coeffs) == 0 || len(coeffs) > 0xffff || blockSize <= 0 {
+		panic("dsp: invalid FIR parameters")
+	}
+	f := &FIRQ31{
+		coeffs:    coeffs,
+		state:     make([]fixedpoint.Q31, len(coeffs)+blockSize-1),
+		blockSize: blockSize,
+	}
+	arm_fir_init_q31(&f.instance, uint16(len(coeffs)), (*int32)(&f.coeffs[0]), (*int32)(&f.state[0]), uint32(blockSize))
+	return f
+}
+
+// Process filters the samples in src and stores the result in dst, which must
+// be at least as long as src.
+func (f *FIRQ31) Process(src, dst []fixedpoint.Q31) {
+	if len(dst) < len(src) {
+		panic("dsp: destination too short")
+	}
+	for len(src) != 0 {
+		n := len(src)
+		if n > f.blockSize {
+			n = f.blockSize
+		}
+		arm_fir_q31(&f.instance, (*int32)(&src[0]), (*int32)(&dst[0]), uint32(n))
+		src = src[n:]
+		dst = dst[n:]
+	}
+}
+
+// Biquad is a cascade of second order IIR filters (biquads) in direct form I,
+// for floating point samples.
+type Biquad struct {
+	instance biquadCasdDf1InstF32
+	coeffs   []float32
+	state    []float32
+}
+
+// NewBiquad creates a biquad cascade. The coefficients are five values per
+// stage: b0, b1, b2, a1 and a2, where a1 and a2 are negated compared to the
+// usual notation (as in CMSIS-DSP).
+func NewBiquad(coeffs []float32) *Biquad {
+	stages := len(coeffs) / 5
+	if stages == 0 || stages > 0xff || len(coeffs)%5 != 0 {
+		panic("dsp: invalid biquad coefficients")
+	}
+	f := &Biquad{
+		coeffs: coeffs,
+		state:  make([]float32, stages*4),
+	}
+	arm_biquad_cascade_df1_init_f32(&f.instance, uint8(stages), &f.coeffs[0], &f.state[0])
+	return f
+}
+
+// Process filters the samples in src and stores the result in dst, which must
+// be at least as long as src.
+func (f *Biquad) Process(src, dst []float32) {
+	if len(dst) < len(src) {
+		panic("dsp: destination too short")
+	}
+	if len(src) == 0 {
+		return
+	}
+	arm_biquad_cascade_df1_f32(&f.instance, &src[0], &dst[0], uint32(len(src)))
+}
+
+// RFFT computes fast Fourier transforms of real-valued data.
+//
+// Note that the twiddle factor tables of all supported lengths are linked in
+// when an RFFT is used, which takes a significant amount of flash.
+type RFFT struct {
+	instance rfftFastInstanceF32
+	n        int
+}
+
+// NewRFFT prepares an FFT of n real values. Supported lengths are the powers
+// of two from 32 to 4096.
+func NewRFFT(n int) (*RFFT, error) {
+	if n <= 0 || n > 0xffff {
+		return nil, ErrLength
+	}
+	f := &RFFT{n: n}
+	err := arm_rfft_fast_init_f32(&f.instance, uint16(n)).err()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Forward computes the FFT of the n real values in src and stores n/2 complex
+// values in dst, as pairs of real and imaginary parts. The real part of the
+// highest frequency bin is stored in the imaginary part of the first value.
+// The contents of src are modified.
+func (f *RFFT) Forward(src, dst []float32) {
+	f.transform(src, dst, 0)
+}
+
+// Inverse computes the inverse FFT of the complex values in src (as produced
+// by Forward) and stores the real values in dst. The contents of src are
+// modified.
+func (f *RFFT) Inverse(src, dst []float32) {
+	f.transform(src, dst, 1)
+}
+
+func (f *RFFT) transform(src, dst []float32, inverse uint8) {
+	if len(src) < f.n || len(dst) < f.n {
+		panic("dsp: FFT buffer too short")
+	}
+	arm_rfft_fast_f32(&f.instance, &src[0], &dst[0], inverse)
+}
+
+// Matrix is a matrix of floating point values, stored in row-major order.
+type Matrix struct {
+	instance matrixInstanceF32
+	Data     []float32
+}
+
+// NewMatrix creates a matrix of the given size. If data is nil, it is
+// allocated.
+func NewMatrix(rows, cols int, data []float32) *Matrix {
+	if rows <= 0 || cols <= 0 || rows > 0xffff || cols > 0xffff {
+		panic("dsp: invalid matrix size")
+	}
+	if data == nil {
+		data = make([]float32, rows*cols)
+	}
+	if len(data) != rows*cols {
+		panic("dsp: matrix data has the wrong length")
+	}
+	m := &Matrix{Data: data}
+	arm_mat_init_f32(&m.instance, uint16(rows), uint16(cols), &m.Data[0])
+	return m
+}
+
+// Rows returns the number of rows of the matrix.
+func (m *Matrix) Rows() int {
+	return int(m.instance.numRows)
+}
+
+// Cols returns the number of columns of the matrix.
+func (m *Matrix) Cols() int {
+	return int(m.instance.numCols)
+}
+
+// At returns the value at the given row and column.
+func (m *Matrix) At(row, col int) float32 {
+	return m.Data[row*m.Cols()+col]
+}
+
+// Set changes the value at the given row and column.
+func (m *Matrix) Set(row, col int, value float32) {
+	m.Data[row*m.Cols()+col] = value
+}
+
+// Mul stores the matrix product a*b in m.
+func (m *Matrix) Mul(a, b *Matrix) error {
+	return arm_mat_mult_f32(&a.instance, &b.instance, &m.instance).err()
+}
+
+// Add stores the sum a+b in m.
+func (m *Matrix) Add(a, b *Matrix) error {
+	return arm_mat_add_f32(&a.instance, &b.instance, &m.instance).err()
+}
+
+// Transpose stores the transpose of a in m.
+func (m *Matrix) Transpose(a *Matrix) error {
+	return arm_mat_trans_f32(&a.instance, &m.instance).err()
+}
+
+// Inverse stores the inverse of a in m. The contents of a are modified.
+func (m *Matrix) Inverse(a *Matrix) error {
+	return arm_mat_inverse_f32(&a.instance, &m.instance).err()
+}
+
+// Dot returns the dot product of a and b, which must have the same length.
+func Dot(a, b []float32) float32 {
+	if len(a) != len(b) {
+		panic("dsp: length mismatch")
+	}
+	if len(a) == 0 {
+		return 0
+	}
+	var result float32
+	arm_dot_prod_f32(&a[0], &b[0], uint32(len(a)), &result)
+	return result
+}
+
+// Mean returns the mean of the values.
+func Mean(values []float32) float32 {
+	if len(values) == 0 {
+		return 0
+	}
+	var result float32
+	arm_mean_f32(&values[0], uint32(len(values)), &result)
+	return result
+}
+
+// RMS returns the root mean square of the values.
+func RMS(values []float32) float32 {
+	if len(values) == 0 {
+		return 0
+	}
+	var result float32
+	arm_rms_f32(&values[0], uint32(len(values)), &result)
+	return result
+}
+
+// Max returns the largest value and its index. The index is -1 for an empty
+// slice.
+func Max(values []float32) (float32, int) {
+	if len(values) == 0 {
+		return 0, -1
+	}
+	var result float32
+	var index uint32
+	arm_max_f32(&values[0], uint32(len(values)), &result, &index)
+	return result, int(index)
+}
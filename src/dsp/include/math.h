@@ -0,0 +1,6 @@
+// Minimal math.h for compiling CMSIS-DSP without a libc. The functions are
+// implemented in Go, see src/dsp/libm.go.
+
+#pragma once
+
+float sqrtf(float x);
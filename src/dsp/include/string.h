@@ -0,0 +1,10 @@
+// Minimal string.h for compiling CMSIS-DSP without a libc. The functions are
+// implemented in the runtime and compiler-rt.
+
+#pragma once
+
+#include <stddef.h>
+
+void *memcpy(void *dst, const void *src, size_t n);
+void *memmove(void *dst, const void *src, size_t n);
+void *memset(void *s, int c, size_t n);
@@ -0,0 +1,14 @@
+// +build cortexm
+
+package dsp
+
+import (
+	"math"
+)
+
+// sqrtf is used by CMSIS-DSP (for example in arm_rms_f32) on chips without a
+// floating point unit. There is no libc, so it is provided here.
+//go:export sqrtf
+func sqrtf(x float32) float32 {
+	return float32(math.Sqrt(float64(x)))
+}
@@ -0,0 +1,21 @@
+package main
+
+// This example outputs a sawtooth wave on the DAC pin. Connect an oscilloscope
+// (or a speaker, through a suitable amplifier) to pin A0 to see it.
+
+import (
+	"machine"
+	"time"
+)
+
+func main() {
+	dac := machine.DAC{machine.A0}
+	dac.Configure(machine.DACConfig{})
+
+	for {
+		for value := 0; value < 0x10000; value += 0x400 {
+			dac.Set(uint16(value))
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
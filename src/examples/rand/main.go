@@ -0,0 +1,29 @@
+package main
+
+// This example prints random numbers from the hardware random number
+// generator, and uses it to seed math/rand.
+
+import (
+	"crypto/rand"
+	mathrand "math/rand"
+	"time"
+)
+
+func main() {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		println("could not read random data:", err.Error())
+		return
+	}
+	var seed int64
+	for _, b := range buf {
+		seed = seed<<8 | int64(b)
+	}
+	mathrand.Seed(seed)
+
+	for {
+		rand.Read(buf[:4])
+		println("crypto/rand:", buf[0], buf[1], buf[2], buf[3], " math/rand:", mathrand.Intn(100))
+		time.Sleep(time.Second)
+	}
+}
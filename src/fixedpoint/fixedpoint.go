@@ -0,0 +1,162 @@
+// Package fixedpoint implements Q15 and Q31 fixed-point numbers with
+// saturating arithmetic, for fast math on chips without a floating point unit.
+//
+// A Q15 number is a 16-bit integer that represents a value in the range
+// [-1, 1) in steps of 2^-15, a Q31 number is a 32-bit integer that represents
+// a value in the same range in steps of 2^-31. All operations saturate:
+// results that don't fit are clamped to the minimum or maximum value instead
+// of wrapping around.
+//
+// On the Cortex-M4 and Cortex-M7, the compiler lowers the saturating additions,
+// subtractions and conversions to single DSP instructions (QADD, QSUB and
+// SSAT).
+package fixedpoint
+
+// Q15 is a signed fixed-point number with 15 fractional bits.
+type Q15 int16
+
+// Q31 is a signed fixed-point number with 31 fractional bits.
+type Q31 int32
+
+// Limits of the fixed-point types.
+const (
+	MaxQ15 Q15 = 0x7fff  // largest Q15 value, just below 1
+	MinQ15 Q15 = -0x8000 // smallest Q15 value, -1
+	MaxQ31 Q31 = 0x7fffffff
+	MinQ31 Q31 = -0x80000000
+)
+
+// Q15FromFloat converts a floating point number to Q15, saturating values
+// outside the range [-1, 1).
+func Q15FromFloat(f float32) Q15 {
+	return Q15(sat16(floatToInt32(f * (1 << 15))))
+}
+
+// Float returns the value as a floating point number.
+func (a Q15) Float() float32 {
+	return float32(a) / (1 << 15)
+}
+
+// Add returns a+b.
+func (a Q15) Add(b Q15) Q15 {
+	return Q15(sat16(int32(a) + int32(b)))
+}
+
+// Sub returns a-b.
+func (a Q15) Sub(b Q15) Q15 {
+	return Q15(sat16(int32(a) - int32(b)))
+}
+
+// Mul returns a*b, rounded to the nearest value.
+func (a Q15) Mul(b Q15) Q15 {
+	return Q15(sat16((int32(a)*int32(b) + 1<<14) >> 15))
+}
+
+// Neg returns -a.
+func (a Q15) Neg() Q15 {
+	return Q15(sat16(-int32(a)))
+}
+
+// Q31 returns the value as a Q31 number. This conversion is exact.
+func (a Q15) Q31() Q31 {
+	return Q31(int32(a) << 16)
+}
+
+// Q31FromFloat converts a floating point number to Q31, saturating values
+// outside the range [-1, 1).
+func Q31FromFloat(f float64) Q31 {
+	f *= 1 << 31
+	if f >= float64(MaxQ31) {
+		return MaxQ31
+	}
+	if f <= float64(MinQ31) {
+		return MinQ31
+	}
+	return Q31(f)
+}
+
+// Float returns the value as a floating point number.
+func (a Q31) Float() float64 {
+	return float64(a) / (1 << 31)
+}
+
+// Add returns a+b.
+func (a Q31) Add(b Q31) Q31 {
+	return Q31(addSat32(int32(a), int32(b)))
+}
+
+// Sub returns a-b.
+func (a Q31) Sub(b Q31) Q31 {
+	return Q31(subSat32(int32(a), int32(b)))
+}
+
+// Mul returns a*b, rounded to the nearest value.
+func (a Q31) Mul(b Q31) Q31 {
+	product := (int64(a)*int64(b) + 1<<30) >> 31
+	if product > int64(MaxQ31) {
+		// Only possible for -1 * -1.
+		return MaxQ31
+	}
+	return Q31(product)
+}
+
+// Neg returns -a.
+func (a Q31) Neg() Q31 {
+	return Q31(subSat32(0, int32(a)))
+}
+
+// Q15 returns the value as a Q15 number, rounded to the nearest value.
+func (a Q31) Q15() Q15 {
+	return Q15(sat16(int32((int64(a) + 1<<15) >> 16)))
+}
+
+// The functions below are replaced by DSP instructions on targets that
+// support them, see compiler/fixedpoint.go. Their behavior must match the
+// instructions exactly.
+
+// addSat32 returns a+b, saturated to the int32 range (QADD).
+func addSat32(a, b int32) int32 {
+	sum := int64(a) + int64(b)
+	if sum > 0x7fffffff {
+		return 0x7fffffff
+	}
+	if sum < -0x80000000 {
+		return -0x80000000
+	}
+	return int32(sum)
+}
+
+// subSat32 returns a-b, saturated to the int32 range (QSUB).
+func subSat32(a, b int32) int32 {
+	diff := int64(a) - int64(b)
+	if diff > 0x7fffffff {
+		return 0x7fffffff
+	}
+	if diff < -0x80000000 {
+		return -0x80000000
+	}
+	return int32(diff)
+}
+
+// sat16 saturates x to the int16 range (SSAT #16).
+func sat16(x int32) int32 {
+	if x > 0x7fff {
+		return 0x7fff
+	}
+	if x < -0x8000 {
+		return -0x8000
+	}
+	return x
+}
+
+// floatToInt32 converts f to an integer, saturating values outside the int32
+// range.
+func floatToInt32(f float32) int32 {
+	if f >= 0x7fffffff {
+		return 0x7fffffff
+	}
+	if f <= -0x80000000 {
+		return -0x80000000
+	}
+	return int32(f)
+}
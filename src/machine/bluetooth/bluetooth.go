@@ -0,0 +1,176 @@
+// Package bluetooth implements the Bluetooth Low Energy link layer for
+// advertising and scanning, on top of the raw radio of the chip. It doesn't
+// need a vendor stack like the Nordic SoftDevice:
+//
+//     err := bluetooth.Configure(bluetooth.Config{})
+//     ...
+//     adv := bluetooth.AppendField(nil, bluetooth.ADFlags, []byte{bluetooth.FlagBREDRNotSupported})
+//     adv = bluetooth.AppendField(adv, bluetooth.ADCompleteLocalName, []byte("tinygo"))
+//     err = bluetooth.StartAdvertising(bluetooth.AdvertisementOptions{
+//         AdvertisementData: adv,
+//     })
+//
+// Advertisements are sent as non-connectable (ADV_NONCONN_IND) or, when there
+// is a scan response, as scannable (ADV_SCAN_IND) advertising PDUs. Scanning
+// is passive: received advertising PDUs are queued from the radio interrupt
+// and can be read with Report. Connections are not supported.
+//
+// The package is currently implemented for the nRF52 series.
+package bluetooth
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrAdvertisementDataSize = errors.New("bluetooth: advertisement data too long")
+	ErrAdvertisementInterval = errors.New("bluetooth: advertising interval out of range")
+	ErrScanWindow            = errors.New("bluetooth: scan interval or window out of range")
+)
+
+// MaxAdvertisementData is the maximum size of the advertisement data and the
+// scan response data in a legacy advertising PDU.
+const MaxAdvertisementData = 31
+
+// Address is a Bluetooth device address.
+type Address struct {
+	// MAC is the 48-bit address, least significant byte first as it is sent
+	// over the air. This is the reverse of the usual notation.
+	MAC [6]byte
+
+	// Random is set for a random device address, and cleared for a public
+	// address.
+	Random bool
+}
+
+// String returns the address in the usual notation, like
+// "C4:8A:1E:2F:70:01".
+func (a Address) String() string {
+	const hex = "0123456789ABCDEF"
+	buf := make([]byte, 0, 17)
+	for i := 5; i >= 0; i-- {
+		if i != 5 {
+			buf = append(buf, ':')
+		}
+		buf = append(buf, hex[a.MAC[i]>>4], hex[a.MAC[i]&0xf])
+	}
+	return string(buf)
+}
+
+// PDUType is the type of an advertising channel PDU.
+type PDUType uint8
+
+const (
+	AdvInd        PDUType = 0x0 // connectable and scannable undirected advertising
+	AdvDirectInd  PDUType = 0x1 // connectable directed advertising
+	AdvNonconnInd PDUType = 0x2 // non-connectable and non-scannable advertising
+	ScanReq       PDUType = 0x3
+	ScanRsp       PDUType = 0x4
+	ConnectInd    PDUType = 0x5
+	AdvScanInd    PDUType = 0x6 // scannable undirected advertising
+)
+
+// AdvertisementReport is an advertising PDU received while scanning.
+type AdvertisementReport struct {
+	// Type is the type of PDU: AdvInd, AdvDirectInd, AdvNonconnInd,
+	// AdvScanInd or ScanRsp.
+	Type PDUType
+
+	// Address is the address of the advertiser.
+	Address Address
+
+	// RSSI is the received signal strength in dBm.
+	RSSI int8
+
+	// Channel is the advertising channel, 37, 38 or 39.
+	Channel uint8
+
+	// Timestamp is the time at which the access address was received, in
+	// microseconds. See Now.
+	Timestamp uint32
+
+	// Length is the number of bytes in Data, the advertisement data or scan
+	// response data.
+	Length uint8
+	Data   [MaxAdvertisementData]byte
+}
+
+// Field returns the value of the first field with the given type in the
+// advertisement data, or nil if there is none.
+func (r *AdvertisementReport) Field(typ uint8) []byte {
+	return Field(r.Data[:r.Length], typ)
+}
+
+// AdvertisementOptions are the parameters for StartAdvertising.
+type AdvertisementOptions struct {
+	// Interval is the time between advertising events, from 20ms to 10.24s.
+	// It defaults to 100ms. A random delay of up to 10ms is added to every
+	// interval, as required by the specification.
+	Interval time.Duration
+
+	// AdvertisementData is sent in every advertising PDU. It is a list of
+	// fields, see AppendField.
+	AdvertisementData []byte
+
+	// ScanResponseData is sent in reply to scan requests. Without it, the
+	// advertisements are not scannable.
+	ScanResponseData []byte
+}
+
+// ScanOptions are the parameters for StartScan.
+type ScanOptions struct {
+	// Interval is the time after which the scanner switches to the next
+	// advertising channel. It defaults to 100ms.
+	Interval time.Duration
+
+	// Window is how long the scanner listens in each interval. It defaults
+	// to the interval, which means listening all the time.
+	Window time.Duration
+}
+
+// Advertising data types, as assigned by the Bluetooth SIG.
+const (
+	ADFlags                     = 0x01
+	ADIncompleteServiceUUIDs16  = 0x02
+	ADCompleteServiceUUIDs16    = 0x03
+	ADIncompleteServiceUUIDs128 = 0x06
+	ADCompleteServiceUUIDs128   = 0x07
+	ADShortLocalName            = 0x08
+	ADCompleteLocalName         = 0x09
+	ADTxPowerLevel              = 0x0a
+	ADServiceData16             = 0x16
+	ADManufacturerData          = 0xff
+)
+
+// Bits in the value of the ADFlags field.
+const (
+	FlagLELimitedDiscoverable = 0x01
+	FlagLEGeneralDiscoverable = 0x02
+	FlagBREDRNotSupported     = 0x04
+)
+
+// AppendField appends a field with the given type and value to advertisement
+// data and returns the extended buffer.
+func AppendField(buf []byte, typ uint8, value []byte) []byte {
+	buf = append(buf, uint8(len(value)+1), typ)
+	return append(buf, value...)
+}
+
+// Field returns the value of the first field with the given type in
+// advertisement data, or nil if there is none.
+func Field(data []byte, typ uint8) []byte {
+	for len(data) >= 2 {
+		length := int(data[0])
+		if length == 0 || length >= len(data) {
+			// Zero length marks the end of the data, the rest is padding.
+			// A field that doesn't fit is invalid.
+			return nil
+		}
+		if data[1] == typ {
+			return data[2 : 1+length]
+		}
+		data = data[1+length:]
+	}
+	return nil
+}
@@ -0,0 +1,623 @@
+// +build nrf52 nrf52840
+
+package bluetooth
+
+// Link layer on the radio of the nRF52 series. Everything that has to happen
+// at a precise time is done by the hardware: advertising events are started
+// by TIMER0 through the pre-programmed PPI channel 20 (COMPARE[0] to TXEN),
+// the turnaround between a scan request and a scan response is timed by the
+// radio (TIFS) through shortcuts, and received PDUs are timestamped through
+// PPI channel 26. The interrupt handlers only prepare the next step.
+//
+// TIMER0 counts microseconds. Its compare registers are used as follows:
+//   CC[0]: start of the next advertising event
+//   CC[1]: time of the last received access address
+//   CC[2]: next timeout of the scheduler, with an interrupt
+//   CC[3]: capture of the current time
+//
+// The radio and TIMER0 are used exclusively, so this can't be combined with
+// the SoftDevice or with the 802.15.4 support in the machine package.
+
+import (
+	"device/arm"
+	"device/nrf"
+	"machine"
+	"runtime/volatile"
+	"time"
+	"unsafe"
+)
+
+const (
+	// Access address and CRC initialization value of advertising channel
+	// PDUs, and the polynomial of the 24-bit CRC.
+	advAccessAddress = 0x8e89bed6
+	advCRCInit       = 0x555555
+	crcPoly          = 0x00065b
+
+	// Maximum length of an advertising channel PDU payload.
+	maxPayload = 6 + MaxAdvertisementData
+
+	// Bits in the header of an advertising channel PDU.
+	headerTypeMask = 0x0f
+	headerTxAdd    = 1 << 6
+	headerRxAdd    = 1 << 7
+
+	// Timing in microseconds.
+	tIFS        = 150   // interframe space
+	advDelayMax = 10000 // maximum random delay added to the advertising interval
+	advGuard    = 500   // time to stop scanning before an advertising event
+	timerMargin = 20    // minimum time until a timeout, to not miss it
+
+	// Time after an advertising PDU within which the access address of a
+	// scan request must have been received: the interframe space and the
+	// preamble and access address, with some margin.
+	scanReqTimeout = tIFS + 40 + 60
+
+	// Number of received advertisements that can be buffered before new ones
+	// are dropped. Must be a power of two.
+	reportBufferSize = 8
+)
+
+// States of the link layer, as seen from the interrupt handlers.
+const (
+	stateIdle         = iota // radio disabled, waiting for the next timeout
+	stateScanRx              // receiving advertisements
+	stateAdvTx               // waiting for an advertising event or sending an advertising PDU
+	stateAdvRx               // waiting for a scan request after an advertising PDU
+	stateAdvScanRsp          // turning around to send a scan response
+	stateAdvScanRspTx        // sending a scan response
+)
+
+// Config is the configuration of the link layer.
+type Config struct {
+	// Address is the device address used for advertising. It defaults to the
+	// random static address that is programmed in the factory information
+	// configuration registers (FICR) of the chip.
+	Address Address
+
+	// TxPower is the transmit power in dBm, from -40 to 4. It is rounded
+	// down to a supported value.
+	TxPower int8
+}
+
+var (
+	address Address
+	state   volatile.Register8
+	random  uint32
+
+	advertising  bool
+	advScannable bool
+	advInterval  uint32
+	advNext      uint32 // start of the next advertising event
+	advChannel   uint8  // channel of the current advertising event, 37 to 39
+	advPDU       [2 + maxPayload]byte
+	scanRspPDU   [2 + maxPayload]byte
+
+	scanning     bool
+	scanInterval uint32
+	scanWindow   uint32
+	scanStart    uint32 // start of the current scan interval
+	scanChannel  uint8
+
+	rxPDU   [2 + maxPayload]byte
+	reports = &reportRingBuffer{}
+)
+
+// Configure takes over the radio and sets up the link layer. Advertising and
+// scanning are stopped.
+func Configure(config Config) error {
+	if config.Address == (Address{}) {
+		config.Address = Address{Random: true}
+		addr := uint64(nrf.FICR.DEVICEADDR[0].Get()) | uint64(nrf.FICR.DEVICEADDR[1].Get())<<32
+		for i := range config.Address.MAC {
+			config.Address.MAC[i] = uint8(addr >> (8 * uint(i)))
+		}
+		// The two most significant bits of a random static address are set.
+		config.Address.MAC[5] |= 0xc0
+	}
+	machine.SetRadioInterrupts(handleRadio, handleTimer)
+	disable()
+	nrf.PPI.CHENCLR.Set(1 << 20)
+	advertising = false
+	scanning = false
+	state.Set(stateIdle)
+	address = config.Address
+
+	nrf.RADIO.POWER.Set(1)
+	nrf.RADIO.MODE.Set(nrf.RADIO_MODE_MODE_Ble_1Mbit)
+	nrf.RADIO.MODECNF0.Set(nrf.RADIO_MODECNF0_RU_Default << nrf.RADIO_MODECNF0_RU_Pos)
+
+	// A PDU starts with a 1-byte header (S0) and the length of the payload,
+	// and is whitened. The 32-bit access address is split in a 3-byte base
+	// address and a prefix. The CRC doesn't cover the access address.
+	nrf.RADIO.PCNF0.Set(1<<nrf.RADIO_PCNF0_S0LEN_Pos | 8<<nrf.RADIO_PCNF0_LFLEN_Pos)
+	nrf.RADIO.PCNF1.Set(maxPayload<<nrf.RADIO_PCNF1_MAXLEN_Pos |
+		3<<nrf.RADIO_PCNF1_BALEN_Pos |
+		nrf.RADIO_PCNF1_ENDIAN_Little<<nrf.RADIO_PCNF1_ENDIAN_Pos |
+		nrf.RADIO_PCNF1_WHITEEN_Enabled<<nrf.RADIO_PCNF1_WHITEEN_Pos)
+	nrf.RADIO.BASE0.Set(advAccessAddress << 8)
+	nrf.RADIO.PREFIX0.Set(advAccessAddress >> 24)
+	nrf.RADIO.TXADDRESS.Set(0)
+	nrf.RADIO.RXADDRESSES.Set(1)
+	nrf.RADIO.CRCCNF.Set(nrf.RADIO_CRCCNF_LEN_Three<<nrf.RADIO_CRCCNF_LEN_Pos |
+		nrf.RADIO_CRCCNF_SKIPADDR_Skip<<nrf.RADIO_CRCCNF_SKIPADDR_Pos)
+	nrf.RADIO.CRCPOLY.Set(crcPoly)
+	nrf.RADIO.CRCINIT.Set(advCRCInit)
+	nrf.RADIO.TIFS.Set(tIFS)
+	nrf.RADIO.TXPOWER.Set(uint32(uint8(txPower(config.TxPower))))
+
+	nrf.TIMER0.TASKS_STOP.Set(1)
+	nrf.TIMER0.MODE.Set(nrf.TIMER_MODE_MODE_Timer)
+	nrf.TIMER0.BITMODE.Set(nrf.TIMER_BITMODE_BITMODE_32Bit)
+	nrf.TIMER0.PRESCALER.Set(4) // 16MHz / 2^4
+	nrf.TIMER0.TASKS_CLEAR.Set(1)
+	nrf.TIMER0.TASKS_START.Set(1)
+	nrf.TIMER0.INTENSET.Set(nrf.TIMER_INTENSET_COMPARE2)
+	nrf.PPI.CHENSET.Set(1 << 26)
+
+	// Seed the generator for the random advertising delay.
+	random, _ = machine.GetRNG()
+	random |= 1
+
+	nrf.RADIO.INTENSET.Set(nrf.RADIO_INTENSET_END | nrf.RADIO_INTENSET_DISABLED)
+	arm.SetPriority(nrf.IRQ_RADIO, 0x40) // high priority, for scan responses
+	arm.SetPriority(nrf.IRQ_TIMER0, 0x40)
+	unlock()
+	return nil
+}
+
+// txPower returns the highest transmit power supported by all nRF52 chips that
+// is not higher than the requested power.
+func txPower(dBm int8) int8 {
+	switch {
+	case dBm >= 4:
+		return 4
+	case dBm >= 3:
+		return 3
+	case dBm >= 0:
+		return 0
+	case dBm < -20:
+		return -40
+	default:
+		// -4, -8, -12, -16 or -20.
+		return -((-dBm + 3) / 4 * 4)
+	}
+}
+
+// StartAdvertising starts sending advertisements, until StopAdvertising is
+// called. If it was already advertising, the new options replace the old
+// ones.
+func StartAdvertising(options AdvertisementOptions) error {
+	if len(options.AdvertisementData) > MaxAdvertisementData || len(options.ScanResponseData) > MaxAdvertisementData {
+		return ErrAdvertisementDataSize
+	}
+	if options.Interval == 0 {
+		options.Interval = 100 * time.Millisecond
+	}
+	if options.Interval < 20*time.Millisecond || options.Interval > 10240*time.Millisecond {
+		return ErrAdvertisementInterval
+	}
+
+	lock()
+	abort()
+	advScannable = len(options.ScanResponseData) != 0
+	if advScannable {
+		makePDU(&advPDU, AdvScanInd, options.AdvertisementData)
+		makePDU(&scanRspPDU, ScanRsp, options.ScanResponseData)
+	} else {
+		makePDU(&advPDU, AdvNonconnInd, options.AdvertisementData)
+	}
+	advInterval = uint32(options.Interval / time.Microsecond)
+	advNext = now() + advGuard
+	advertising = true
+	schedule()
+	unlock()
+	return nil
+}
+
+// StopAdvertising stops sending advertisements.
+func StopAdvertising() {
+	lock()
+	abort()
+	advertising = false
+	schedule()
+	unlock()
+}
+
+// makePDU fills in an advertising channel PDU that starts with the address of
+// this device.
+func makePDU(pdu *[2 + maxPayload]byte, typ PDUType, data []byte) {
+	pdu[0] = uint8(typ)
+	if address.Random {
+		pdu[0] |= headerTxAdd
+	}
+	pdu[1] = uint8(6 + len(data))
+	copy(pdu[2:8], address.MAC[:])
+	copy(pdu[8:], data)
+}
+
+// StartScan starts receiving advertisements, until StopScan is called. They
+// can be read with Report. The scanner still listens while the device is
+// advertising, except during advertising events.
+func StartScan(options ScanOptions) error {
+	if options.Interval == 0 {
+		options.Interval = 100 * time.Millisecond
+	}
+	if options.Window == 0 {
+		options.Window = options.Interval
+	}
+	if options.Window > options.Interval || options.Interval > 10240*time.Millisecond {
+		return ErrScanWindow
+	}
+
+	lock()
+	abort()
+	scanInterval = uint32(options.Interval / time.Microsecond)
+	scanWindow = uint32(options.Window / time.Microsecond)
+	scanStart = now()
+	scanChannel = 37
+	scanning = true
+	schedule()
+	unlock()
+	return nil
+}
+
+// StopScan stops receiving advertisements. Advertisements that were already
+// received can still be read with Report.
+func StopScan() {
+	lock()
+	abort()
+	scanning = false
+	schedule()
+	unlock()
+}
+
+// Report returns the oldest received advertisement. The second return value
+// is false if no advertisement has been received.
+func Report() (AdvertisementReport, bool) {
+	return reports.Get()
+}
+
+// Buffered returns the number of received advertisements waiting to be read.
+func Buffered() int {
+	return int(reports.Used())
+}
+
+// Now returns the current time in microseconds, in the same unit as the
+// timestamps of received advertisements. It wraps around after about 71
+// minutes.
+func Now() uint32 {
+	return now()
+}
+
+func now() uint32 {
+	nrf.TIMER0.TASKS_CAPTURE[3].Set(1)
+	return nrf.TIMER0.CC[3].Get()
+}
+
+// lock disables the interrupts of the link layer, so that its state can be
+// changed.
+func lock() {
+	arm.DisableIRQ(nrf.IRQ_RADIO)
+	arm.DisableIRQ(nrf.IRQ_TIMER0)
+}
+
+// unlock enables the interrupts of the link layer again.
+func unlock() {
+	arm.EnableIRQ(nrf.IRQ_RADIO)
+	arm.EnableIRQ(nrf.IRQ_TIMER0)
+}
+
+// abort stops whatever the radio is doing, including an advertising event
+// that is in progress or about to start.
+func abort() {
+	nrf.PPI.CHENCLR.Set(1 << 20)
+	nrf.TIMER0.EVENTS_COMPARE[2].Set(0)
+	disable()
+	state.Set(stateIdle)
+}
+
+// disable disables the radio and waits until it has done so. Pending radio
+// events are cleared.
+func disable() {
+	nrf.RADIO.SHORTS.Set(0)
+	if nrf.RADIO.STATE.Get() != nrf.RADIO_STATE_STATE_Disabled {
+		nrf.RADIO.EVENTS_DISABLED.Set(0)
+		nrf.RADIO.TASKS_DISABLE.Set(1)
+		for nrf.RADIO.EVENTS_DISABLED.Get() == 0 {
+		}
+	}
+	nrf.RADIO.EVENTS_DISABLED.Set(0)
+	nrf.RADIO.EVENTS_END.Set(0)
+}
+
+// setChannel switches the radio to an advertising channel. The whitening is
+// initialized with the channel index.
+func setChannel(channel uint8) {
+	switch channel {
+	case 37:
+		nrf.RADIO.FREQUENCY.Set(2) // 2402MHz
+	case 38:
+		nrf.RADIO.FREQUENCY.Set(26) // 2426MHz
+	case 39:
+		nrf.RADIO.FREQUENCY.Set(80) // 2480MHz
+	}
+	nrf.RADIO.DATAWHITEIV.Set(uint32(channel))
+}
+
+// setTimeout sets the time at which the timer interrupt calls the scheduler.
+func setTimeout(t uint32) {
+	if int32(t-now()) < timerMargin {
+		t = now() + timerMargin
+	}
+	nrf.TIMER0.EVENTS_COMPARE[2].Set(0)
+	nrf.TIMER0.CC[2].Set(t)
+}
+
+// schedule decides what the radio does next. It is called when the radio is
+// disabled and no advertising event is in progress: it prepares the next
+// advertising event if it is near, and otherwise receives on the current scan
+// channel until the end of the scan window or until the advertising event
+// gets near.
+func schedule() {
+	state.Set(stateIdle)
+	t := now()
+	if advertising && int32(advNext-t) < advGuard {
+		prepareAdvEvent()
+		return
+	}
+
+	var deadline uint32
+	hasDeadline := false
+	if advertising {
+		deadline = advNext - advGuard
+		hasDeadline = true
+	}
+	if scanning {
+		if int32(t-scanStart) >= int32(2*scanInterval) {
+			// Many intervals were missed, for example because scanning was
+			// stopped. Start a new one now.
+			scanStart = t - scanInterval
+		}
+		for int32(t-scanStart) >= int32(scanInterval) {
+			scanStart += scanInterval
+			scanChannel++
+			if scanChannel > 39 {
+				scanChannel = 37
+			}
+		}
+		end := scanStart + scanInterval
+		if int32(t-scanStart) < int32(scanWindow) {
+			startScanRx()
+			end = scanStart + scanWindow
+		}
+		if !hasDeadline || int32(end-deadline) < 0 {
+			deadline = end
+			hasDeadline = true
+		}
+	}
+	if hasDeadline {
+		setTimeout(deadline)
+	}
+}
+
+// startScanRx starts receiving advertising PDUs on the current scan channel.
+// The radio restarts reception after every PDU by itself.
+func startScanRx() {
+	setChannel(scanChannel)
+	nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&rxPDU))))
+	nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_READY_START |
+		nrf.RADIO_SHORTS_END_START |
+		nrf.RADIO_SHORTS_ADDRESS_RSSISTART)
+	state.Set(stateScanRx)
+	nrf.RADIO.TASKS_RXEN.Set(1)
+}
+
+// prepareAdvEvent sets up the radio to send the first advertising PDU of the
+// next advertising event, which is started by the timer.
+func prepareAdvEvent() {
+	if int32(advNext-now()) < timerMargin {
+		advNext = now() + timerMargin
+	}
+	advChannel = 37
+	setAdvTx()
+	nrf.TIMER0.EVENTS_COMPARE[0].Set(0)
+	nrf.TIMER0.CC[0].Set(advNext)
+	nrf.PPI.CHENSET.Set(1 << 20)
+}
+
+// setAdvTx sets up the radio to send the advertising PDU on the current
+// advertising channel. When the advertisement is scannable, the radio turns
+// around to receive a scan request after it.
+func setAdvTx() {
+	setChannel(advChannel)
+	nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&advPDU))))
+	shorts := uint32(nrf.RADIO_SHORTS_READY_START | nrf.RADIO_SHORTS_END_DISABLE)
+	if advScannable {
+		shorts |= nrf.RADIO_SHORTS_DISABLED_RXEN
+	}
+	nrf.RADIO.SHORTS.Set(shorts)
+	state.Set(stateAdvTx)
+}
+
+// nextAdvChannel continues the advertising event on the next channel, or ends
+// it and schedules the next one.
+func nextAdvChannel() {
+	if advChannel < 39 {
+		advChannel++
+		setAdvTx()
+		nrf.RADIO.TASKS_TXEN.Set(1)
+		return
+	}
+
+	// Add a random delay to the interval, so that devices that advertise
+	// with the same interval don't keep colliding.
+	nrf.PPI.CHENCLR.Set(1 << 20)
+	advNext += advInterval + nextRandom()%(advDelayMax+1)
+	schedule()
+}
+
+// nextRandom returns a pseudorandom number for the advertising delay.
+func nextRandom() uint32 {
+	// xorshift32
+	random ^= random << 13
+	random ^= random >> 17
+	random ^= random << 5
+	return random
+}
+
+// handleRadio handles the END and DISABLED events of the radio. The END event
+// is handled first, as both may be pending when the radio turns around.
+func handleRadio() {
+	if nrf.RADIO.EVENTS_END.Get() != 0 {
+		nrf.RADIO.EVENTS_END.Set(0)
+		switch state.Get() {
+		case stateScanRx:
+			receiveReport()
+		case stateAdvRx:
+			if isScanRequest() {
+				// The radio is already turning around to send the scan
+				// response.
+				nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&scanRspPDU))))
+				state.Set(stateAdvScanRsp)
+			} else {
+				disable()
+				nextAdvChannel()
+			}
+		}
+	}
+
+	if nrf.RADIO.EVENTS_DISABLED.Get() != 0 {
+		nrf.RADIO.EVENTS_DISABLED.Set(0)
+		switch state.Get() {
+		case stateAdvTx:
+			if !advScannable {
+				nextAdvChannel()
+				break
+			}
+			// The radio is turning around to receive a scan request. If one
+			// arrives, it turns around again to send the response.
+			nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&rxPDU))))
+			nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_READY_START |
+				nrf.RADIO_SHORTS_END_DISABLE |
+				nrf.RADIO_SHORTS_DISABLED_TXEN)
+			nrf.RADIO.EVENTS_ADDRESS.Set(0)
+			state.Set(stateAdvRx)
+			setTimeout(now() + scanReqTimeout)
+		case stateAdvScanRsp:
+			// Sending the scan response: don't turn around after it.
+			nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_READY_START | nrf.RADIO_SHORTS_END_DISABLE)
+			state.Set(stateAdvScanRspTx)
+		case stateAdvScanRspTx:
+			nextAdvChannel()
+		}
+	}
+}
+
+// handleTimer handles the timeouts set with setTimeout.
+func handleTimer() {
+	if nrf.TIMER0.EVENTS_COMPARE[2].Get() == 0 {
+		return
+	}
+	nrf.TIMER0.EVENTS_COMPARE[2].Set(0)
+	switch state.Get() {
+	case stateIdle:
+		schedule()
+	case stateScanRx:
+		disable()
+		schedule()
+	case stateAdvRx:
+		if nrf.RADIO.EVENTS_ADDRESS.Get() == 0 {
+			// No scan request arrived.
+			disable()
+			nextAdvChannel()
+		}
+	}
+}
+
+// isScanRequest returns whether the received PDU is a valid scan request for
+// this device.
+func isScanRequest() bool {
+	if nrf.RADIO.CRCSTATUS.Get() != nrf.RADIO_CRCSTATUS_CRCSTATUS_CRCOk ||
+		PDUType(rxPDU[0]&headerTypeMask) != ScanReq || rxPDU[1] != 12 ||
+		(rxPDU[0]&headerRxAdd != 0) != address.Random {
+		return false
+	}
+	// The payload is the address of the scanner followed by the address of
+	// the advertiser.
+	for i, b := range address.MAC {
+		if rxPDU[8+i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// receiveReport stores a received advertising PDU in the buffer.
+func receiveReport() {
+	if nrf.RADIO.CRCSTATUS.Get() != nrf.RADIO_CRCSTATUS_CRCSTATUS_CRCOk {
+		return
+	}
+	typ := PDUType(rxPDU[0] & headerTypeMask)
+	length := rxPDU[1]
+	switch typ {
+	case AdvInd, AdvNonconnInd, AdvScanInd, ScanRsp:
+		if length < 6 || length > maxPayload {
+			return
+		}
+	case AdvDirectInd:
+		// The payload is the address of the advertiser and of the target.
+		if length != 12 {
+			return
+		}
+		length = 6
+	default:
+		return
+	}
+	report := AdvertisementReport{
+		Type:      typ,
+		Address:   Address{Random: rxPDU[0]&headerTxAdd != 0},
+		RSSI:      -int8(nrf.RADIO.RSSISAMPLE.Get()),
+		Channel:   scanChannel,
+		Timestamp: nrf.TIMER0.CC[1].Get(),
+		Length:    length - 6,
+	}
+	copy(report.Address.MAC[:], rxPDU[2:8])
+	copy(report.Data[:], rxPDU[8:2+length])
+	reports.Put(report)
+}
+
+// reportRingBuffer is a ring buffer of received advertisements, filled from
+// the radio interrupt.
+type reportRingBuffer struct {
+	reports [reportBufferSize]AdvertisementReport
+	head    volatile.Register8
+	tail    volatile.Register8
+}
+
+// Used returns how many advertisements are in the buffer.
+func (rb *reportRingBuffer) Used() uint8 {
+	return rb.head.Get() - rb.tail.Get()
+}
+
+// Put stores an advertisement in the buffer. If the buffer is full, it is
+// dropped and false is returned.
+func (rb *reportRingBuffer) Put(report AdvertisementReport) bool {
+	if rb.Used() == reportBufferSize {
+		return false
+	}
+	rb.reports[rb.head.Get()%reportBufferSize] = report
+	rb.head.Set(rb.head.Get() + 1)
+	return true
+}
+
+// Get returns the oldest advertisement from the buffer. The second return
+// value is false if the buffer is empty.
+func (rb *reportRingBuffer) Get() (AdvertisementReport, bool) {
+	if rb.Used() == 0 {
+		return AdvertisementReport{}, false
+	}
+	report := rb.reports[rb.tail.Get()%reportBufferSize]
+	rb.tail.Set(rb.tail.Get() + 1)
+	return report, true
+}
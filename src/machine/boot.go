@@ -0,0 +1,92 @@
+// +build nrf52 nrf52840 sam,atsamd21 stm32
+
+package machine
+
+import (
+	"device/arm"
+	"errors"
+	"runtime/volatile"
+	"unsafe"
+)
+
+// Start addresses of the program slots, as defined in the linker script.
+//go:extern _slot_start
+var slotStartSymbol [0]byte
+
+//go:extern _main_start
+var mainStartSymbol [0]byte
+
+//go:extern _recovery_start
+var recoveryStartSymbol [0]byte
+
+// ErrNoProgram is returned by BootInto when there is no program in the
+// requested slot.
+var ErrNoProgram = errors.New("machine: no program in slot")
+
+// Slot is a program image in the internal flash. A build with the -recovery
+// flag contains two programs: the main app at the start of the flash and a
+// small recovery app at a fixed address after it, which can for example update
+// the main app in the field when it doesn't work anymore. Each of them can
+// start the other with BootInto.
+type Slot uint8
+
+const (
+	SlotMain     Slot = iota // the main app
+	SlotRecovery             // the recovery app, linked in with the -recovery flag
+)
+
+// address returns the start address of the vector table of the program in
+// the slot.
+func (s Slot) address() uintptr {
+	if s == SlotRecovery {
+		return uintptr(unsafe.Pointer(&recoveryStartSymbol))
+	}
+	return uintptr(unsafe.Pointer(&mainStartSymbol))
+}
+
+// CurrentSlot returns the slot of the running program.
+func CurrentSlot() Slot {
+	recovery := SlotRecovery.address()
+	if recovery != SlotMain.address() && uintptr(unsafe.Pointer(&slotStartSymbol)) == recovery {
+		return SlotRecovery
+	}
+	return SlotMain
+}
+
+// BootInto stops the running program and starts the program in the given
+// slot, as if the chip was reset into it. Peripherals are not reset, so the
+// started program must not make assumptions about their state. It only
+// returns if there is no program in the slot.
+func BootInto(slot Slot) error {
+	vectors := slot.address()
+	if slot == SlotRecovery && vectors == SlotMain.address() {
+		return ErrNoProgram // built without a recovery app
+	}
+	stackTop := *(*uintptr)(unsafe.Pointer(vectors))
+	reset := *(*uintptr)(unsafe.Pointer(vectors + 4))
+	if stackTop == 0xffffffff || reset == 0xffffffff {
+		return ErrNoProgram // erased flash
+	}
+
+	// Make sure no interrupt of this program fires in the other one.
+	arm.DisableInterrupts()
+	for i := range arm.NVIC.ICER {
+		arm.NVIC.ICER[i].Set(0xffffffff)
+		arm.NVIC.ICPR[i].Set(0xffffffff)
+	}
+	(*volatile.Register32)(unsafe.Pointer(uintptr(0xe000e010))).Set(0) // SysTick CTRL
+
+	// Start the other program like the hardware does on reset: load the stack
+	// pointer and the reset handler from its vector table, with interrupts
+	// enabled (but none of them active).
+	arm.SCB.VTOR.Set(uint32(vectors))
+	arm.AsmFull(`
+		msr MSP, {stack}
+		cpsie i
+		bx {reset}
+	`, map[string]interface{}{
+		"stack": stackTop,
+		"reset": reset,
+	})
+	return nil // unreachable
+}
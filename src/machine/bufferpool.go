@@ -0,0 +1,100 @@
+package machine
+
+import (
+	"runtime/volatile"
+)
+
+// Maximum number of buffers in a BufferPool. It must be a power of two that
+// divides 256, so that the queue indices can wrap around.
+const bufferPoolSize = 16
+
+// BufferPool passes buffers between a driver and a goroutine without copying
+// them, for streaming data like ADC or I2S samples that arrive by DMA. Every
+// buffer is owned either by the driver or by the goroutine:
+//
+//   * The driver takes a free buffer with Get and starts a transfer into it.
+//     When the transfer is done (usually in the DMA interrupt), it passes the
+//     buffer to the goroutine with Send.
+//   * The goroutine takes a filled buffer with Receive, processes it, and gives
+//     it back to the driver with Release.
+//
+// All buffers are allocated by NewBufferPool, so passing them around doesn't
+// allocate. Get and Send may be called from an interrupt handler, Receive and
+// Release from a goroutine; there must be only one of each. An interrupt can't
+// wake up a goroutine yet, so the goroutine must poll Receive (for example
+// with a short time.Sleep in between).
+type BufferPool struct {
+	buffers [][]byte
+	lengths [bufferPoolSize]volatile.Register32
+	free    bufferQueue // owned by the driver
+	filled  bufferQueue // owned by the goroutine
+}
+
+// NewBufferPool allocates a pool of count buffers of the given size. All
+// buffers are free at the start. The count must be at most 16.
+func NewBufferPool(count, size int) *BufferPool {
+	if count <= 0 || count > bufferPoolSize {
+		panic("machine: invalid number of buffers in BufferPool")
+	}
+	p := &BufferPool{buffers: make([][]byte, count)}
+	for i := range p.buffers {
+		p.buffers[i] = make([]byte, size)
+		p.free.put(uint8(i))
+	}
+	return p
+}
+
+// Get returns a free buffer for the driver to fill. It returns false if all
+// buffers are still being processed by the goroutine, in which case the data
+// must be dropped.
+func (p *BufferPool) Get() (id uint8, buf []byte, ok bool) {
+	id, ok = p.free.get()
+	if !ok {
+		return 0, nil, false
+	}
+	return id, p.buffers[id], true
+}
+
+// Send passes a buffer that was filled with n bytes to the goroutine.
+func (p *BufferPool) Send(id uint8, n int) {
+	p.lengths[id].Set(uint32(n))
+	p.filled.put(id)
+}
+
+// Receive returns the oldest filled buffer, or false if there is none. The
+// buffer must be given back with Release after it has been processed.
+func (p *BufferPool) Receive() (id uint8, data []byte, ok bool) {
+	id, ok = p.filled.get()
+	if !ok {
+		return 0, nil, false
+	}
+	return id, p.buffers[id][:p.lengths[id].Get()], true
+}
+
+// Release gives a buffer that was returned by Receive back to the driver.
+func (p *BufferPool) Release(id uint8) {
+	p.free.put(id)
+}
+
+// bufferQueue is a queue of buffer IDs, with one reader and one writer that
+// may run in an interrupt handler. It can't overflow, as there are never more
+// IDs than it can hold.
+type bufferQueue struct {
+	ids  [bufferPoolSize]volatile.Register8
+	head volatile.Register8
+	tail volatile.Register8
+}
+
+func (q *bufferQueue) put(id uint8) {
+	q.ids[q.head.Get()%bufferPoolSize].Set(id)
+	q.head.Set(q.head.Get() + 1)
+}
+
+func (q *bufferQueue) get() (uint8, bool) {
+	if q.head.Get() == q.tail.Get() {
+		return 0, false
+	}
+	id := q.ids[q.tail.Get()%bufferPoolSize].Get()
+	q.tail.Set(q.tail.Get() + 1)
+	return id, true
+}
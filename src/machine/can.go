@@ -0,0 +1,284 @@
+// +build stm32
+
+package machine
+
+// CAN bus support using the bxCAN controller found in the STM32F1 and STM32F4
+// series. Other chips with CAN controllers (such as the SAME5x and ESP32) are
+// not supported by this version of TinyGo.
+
+import (
+	"device/arm"
+	"device/stm32"
+	"errors"
+	"runtime/volatile"
+	"unsafe"
+)
+
+var (
+	ErrInvalidCANBitrate = errors.New("machine: CAN bitrate cannot be generated from the bus clock")
+	ErrTooManyCANFilters = errors.New("machine: too many CAN filters")
+	ErrCANTxBusy         = errors.New("machine: CAN transmit mailbox busy")
+	ErrCANTimeout        = errors.New("machine: CAN controller did not respond")
+)
+
+// Number of filter banks available to CAN1. On chips with two CAN controllers
+// the banks are shared and by default the first half belongs to CAN1.
+const canFilterBanks = 14
+
+// Number of received frames that can be buffered before new frames are
+// dropped. Must be a power of two.
+const canBufferSize = 16
+
+// CANFrame is a single CAN data or remote frame.
+type CANFrame struct {
+	ID       uint32 // 11-bit standard or 29-bit extended identifier
+	Extended bool   // ID is an extended identifier
+	Remote   bool   // remote transmission request, carries no data
+	Length   uint8  // number of data bytes (0..8)
+	Data     [8]byte
+}
+
+// CANFilter accepts all frames for which (frame ID & Mask) == (ID & Mask). A
+// mask of zero accepts all frames.
+type CANFilter struct {
+	ID       uint32
+	Mask     uint32
+	Extended bool // match extended identifiers instead of standard identifiers
+}
+
+// CANConfig is the configuration of a CAN controller.
+type CANConfig struct {
+	Bitrate uint32      // bits per second, defaults to 500kbit/s
+	Filters []CANFilter // accepted frames, defaults to accepting all frames
+	TX      Pin
+	RX      Pin
+}
+
+// CAN is a CAN controller. Received frames are queued from the receive
+// interrupt and can be read with Rx.
+type CAN struct {
+	Bus    *stm32.CAN_Type
+	buffer *canRingBuffer
+}
+
+var CAN1 = CAN{Bus: stm32.CAN1, buffer: &canRingBuffer{}}
+
+// Configure the CAN controller and start participating on the bus.
+func (can CAN) Configure(config CANConfig) error {
+	if config.Bitrate == 0 {
+		config.Bitrate = 500000
+	}
+	if len(config.Filters) > canFilterBanks {
+		return ErrTooManyCANFilters
+	}
+	btr, ok := canBitTiming(config.Bitrate)
+	if !ok {
+		return ErrInvalidCANBitrate
+	}
+
+	configureCANPins(config.TX, config.RX)
+	enableCANClock()
+
+	// Leave sleep mode and enter initialization mode.
+	can.Bus.MCR.ClearBits(stm32.CAN_MCR_SLEEP)
+	can.Bus.MCR.SetBits(stm32.CAN_MCR_INRQ)
+	if !can.waitMSR(stm32.CAN_MSR_INAK, true) {
+		return ErrCANTimeout
+	}
+
+	// Recover automatically from the bus-off state and transmit frames in
+	// the order they were requested.
+	can.Bus.MCR.SetBits(stm32.CAN_MCR_ABOM | stm32.CAN_MCR_TXFP)
+	can.Bus.BTR.Set(btr)
+
+	can.configureFilters(config.Filters)
+
+	// Enable the receive interrupt for FIFO 0.
+	can.Bus.IER.SetBits(stm32.CAN_IER_FMPIE0)
+	arm.SetPriority(canRxIRQ, 0xc0)
+	arm.EnableIRQ(canRxIRQ)
+
+	// Leave initialization mode. The controller joins the bus after it has
+	// seen 11 recessive bits.
+	can.Bus.MCR.ClearBits(stm32.CAN_MCR_INRQ)
+	if !can.waitMSR(stm32.CAN_MSR_INAK, false) {
+		return ErrCANTimeout
+	}
+	return nil
+}
+
+// waitMSR waits until the given bit in the status register has the given
+// value. It returns false on timeout.
+func (can CAN) waitMSR(bit uint32, set bool) bool {
+	for i := 0; i < 0x10000; i++ {
+		if can.Bus.MSR.HasBits(bit) == set {
+			return true
+		}
+	}
+	return false
+}
+
+// canBitTiming calculates the value of the bit timing register for the given
+// bitrate. It prefers many time quanta per bit, with the sample point close to
+// 87.5% of the bit time as recommended for CANopen and most other protocols.
+func canBitTiming(bitrate uint32) (uint32, bool) {
+	for tq := uint32(16); tq >= 8; tq-- {
+		if apb1Clock%(bitrate*tq) != 0 {
+			continue
+		}
+		prescaler := apb1Clock / (bitrate * tq)
+		if prescaler == 0 || prescaler > 1024 {
+			continue
+		}
+		seg1 := tq*7/8 - 1 // one quantum is used for the sync segment
+		seg2 := tq - 1 - seg1
+		// The resynchronization jump width is left at one quantum.
+		return (prescaler-1)<<stm32.CAN_BTR_BRP_Pos |
+			(seg1-1)<<stm32.CAN_BTR_TS1_Pos |
+			(seg2-1)<<stm32.CAN_BTR_TS2_Pos, true
+	}
+	return 0, false
+}
+
+// configureFilters sets up one 32-bit mask mode filter bank per filter, all
+// assigned to FIFO 0. Without filters, a single bank accepts all frames.
+func (can CAN) configureFilters(filters []CANFilter) {
+	if len(filters) == 0 {
+		filters = []CANFilter{{}}
+	}
+	can.Bus.FMR.SetBits(stm32.CAN_FMR_FINIT)
+	can.Bus.FA1R.Set(0)
+	can.Bus.FM1R.Set(0)  // mask mode
+	can.Bus.FFA1R.Set(0) // FIFO 0
+	can.Bus.FS1R.Set(1<<canFilterBanks - 1)
+	for i, filter := range filters {
+		id := canIdentifier(filter.ID, filter.Extended, false)
+		mask := canIdentifier(filter.Mask, filter.Extended, false) | canIdentifierExtended
+		if filter.Mask == 0 {
+			// Accept both standard and extended frames.
+			mask = 0
+		}
+		can.filterRegister(i, 0).Set(id)
+		can.filterRegister(i, 1).Set(mask)
+		can.Bus.FA1R.SetBits(1 << uint(i))
+	}
+	can.Bus.FMR.ClearBits(stm32.CAN_FMR_FINIT)
+}
+
+// filterRegister returns one of the two registers of a filter bank. The 28
+// banks are laid out consecutively, starting at F0R1.
+func (can CAN) filterRegister(bank, reg int) *volatile.Register32 {
+	return (*volatile.Register32)(unsafe.Pointer(uintptr(unsafe.Pointer(&can.Bus.F0R1)) + uintptr(bank*8+reg*4)))
+}
+
+// Bits in the identifier registers of the mailboxes and the filter banks.
+const (
+	canIdentifierRemote   = 1 << 1
+	canIdentifierExtended = 1 << 2
+)
+
+// canIdentifier returns the identifier in the layout used by the mailbox and
+// filter registers.
+func canIdentifier(id uint32, extended, remote bool) uint32 {
+	var value uint32
+	if extended {
+		value = (id&0x1fffffff)<<3 | canIdentifierExtended
+	} else {
+		value = (id & 0x7ff) << 21
+	}
+	if remote {
+		value |= canIdentifierRemote
+	}
+	return value
+}
+
+// Tx queues a frame for transmission. It returns ErrCANTxBusy if the transmit
+// mailbox is still in use by a previous frame.
+func (can CAN) Tx(frame CANFrame) error {
+	if !can.Bus.TSR.HasBits(stm32.CAN_TSR_TME0) {
+		return ErrCANTxBusy
+	}
+	if frame.Length > 8 {
+		frame.Length = 8
+	}
+	can.Bus.TDT0R.Set(uint32(frame.Length))
+	can.Bus.TDL0R.Set(uint32(frame.Data[0]) | uint32(frame.Data[1])<<8 | uint32(frame.Data[2])<<16 | uint32(frame.Data[3])<<24)
+	can.Bus.TDH0R.Set(uint32(frame.Data[4]) | uint32(frame.Data[5])<<8 | uint32(frame.Data[6])<<16 | uint32(frame.Data[7])<<24)
+	can.Bus.TI0R.Set(canIdentifier(frame.ID, frame.Extended, frame.Remote) | stm32.CAN_TI0R_TXRQ)
+	return nil
+}
+
+// Rx returns the oldest received frame. The second return value is false if
+// no frame has been received.
+func (can CAN) Rx() (CANFrame, bool) {
+	return can.buffer.Get()
+}
+
+// Buffered returns the number of received frames waiting to be read.
+func (can CAN) Buffered() int {
+	return int(can.buffer.Used())
+}
+
+// handleInterrupt moves all frames in receive FIFO 0 to the receive buffer.
+func (can CAN) handleInterrupt() {
+	for can.Bus.RF0R.Get()&stm32.CAN_RF0R_FMP0_Msk != 0 {
+		id := can.Bus.RI0R.Get()
+		var frame CANFrame
+		if id&canIdentifierExtended != 0 {
+			frame.ID = id >> 3
+			frame.Extended = true
+		} else {
+			frame.ID = id >> 21
+		}
+		frame.Remote = id&canIdentifierRemote != 0
+		frame.Length = uint8(can.Bus.RDT0R.Get() & 0xf)
+		if frame.Length > 8 {
+			frame.Length = 8
+		}
+		low := can.Bus.RDL0R.Get()
+		high := can.Bus.RDH0R.Get()
+		for i := uint(0); i < 4; i++ {
+			frame.Data[i] = byte(low >> (i * 8))
+			frame.Data[i+4] = byte(high >> (i * 8))
+		}
+		can.buffer.Put(frame)
+
+		// Release the FIFO entry.
+		can.Bus.RF0R.SetBits(stm32.CAN_RF0R_RFOM0)
+	}
+}
+
+// canRingBuffer is a ring buffer of received CAN frames, filled from the
+// receive interrupt.
+type canRingBuffer struct {
+	frames [canBufferSize]CANFrame
+	head   volatile.Register8
+	tail   volatile.Register8
+}
+
+// Used returns how many frames are in the buffer.
+func (rb *canRingBuffer) Used() uint8 {
+	return rb.head.Get() - rb.tail.Get()
+}
+
+// Put stores a frame in the buffer. If the buffer is full, the frame is
+// dropped and false is returned.
+func (rb *canRingBuffer) Put(frame CANFrame) bool {
+	if rb.Used() == canBufferSize {
+		return false
+	}
+	rb.frames[rb.head.Get()%canBufferSize] = frame
+	rb.head.Set(rb.head.Get() + 1)
+	return true
+}
+
+// Get returns the oldest frame from the buffer. The second return value is
+// false if the buffer is empty.
+func (rb *canRingBuffer) Get() (CANFrame, bool) {
+	if rb.Used() == 0 {
+		return CANFrame{}, false
+	}
+	frame := rb.frames[rb.tail.Get()%canBufferSize]
+	rb.tail.Set(rb.tail.Get() + 1)
+	return frame, true
+}
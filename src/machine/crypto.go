@@ -0,0 +1,22 @@
+package machine
+
+// Hardware crypto accelerators. Chips that have one register a driver here
+// during initialization. The crypto/aes package uses it when it is set and
+// falls back to a software implementation when it is nil or when it doesn't
+// support an operation.
+
+// AESEngine is a hardware implementation of the AES block cipher.
+type AESEngine interface {
+	// EncryptBlock encrypts the 16-byte block src into dst with the given key.
+	// It returns false, without touching dst, if the key size or operation is
+	// not supported by the hardware.
+	EncryptBlock(key, dst, src []byte) bool
+
+	// DecryptBlock decrypts the 16-byte block src into dst with the given key.
+	// It returns false if this is not supported by the hardware.
+	DecryptBlock(key, dst, src []byte) bool
+}
+
+// CryptoAES is the AES accelerator of the current chip, or nil if there is
+// none.
+var CryptoAES AESEngine
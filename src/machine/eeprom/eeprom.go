@@ -0,0 +1,390 @@
+// Package eeprom emulates a small byte-addressable EEPROM on top of flash
+// memory, for chips that do not have a real EEPROM. It is the equivalent of
+// the Arduino EEPROM library:
+//
+//     ee := eeprom.New(machine.Flash)
+//     err := ee.Configure(eeprom.Config{Size: 1024})
+//     ...
+//     value := ee.Get(0)
+//     err = ee.Set(0, value+1)
+//
+// Flash can only be erased in large blocks and has a limited number of erase
+// cycles, so the EEPROM contents are not stored directly. Instead, every write
+// appends a small record to a log. When the log is full, the other bank is
+// erased and the current contents are copied to it. This spreads the wear over
+// the whole bank: with a 4kB erase block and a 1kB EEPROM, hundreds of bytes
+// can be written before a block needs to be erased.
+//
+// The two banks are placed at the end of the block device, so that the start
+// of the device can still be used for other purposes.
+package eeprom
+
+import (
+	"errors"
+)
+
+var (
+	ErrOutOfRange   = errors.New("eeprom: address out of range")
+	ErrNoSpace      = errors.New("eeprom: block device too small")
+	ErrUnconfigured = errors.New("eeprom: not configured")
+)
+
+// BlockDevice is the flash memory used to store the EEPROM contents, such as
+// machine.Flash. Writes must be aligned to WriteBlockSize and erases to
+// EraseBlockSize, and an erased block must read as all 0xff bytes.
+type BlockDevice interface {
+	ReadAt(p []byte, off int64) (n int, err error)
+	WriteAt(p []byte, off int64) (n int, err error)
+	Size() int64
+	WriteBlockSize() int64
+	EraseBlockSize() int64
+	EraseBlocks(start, count int64) error
+}
+
+// Config is the configuration of an emulated EEPROM.
+type Config struct {
+	// Size of the EEPROM in bytes, at most 16kB. Defaults to 1024 bytes.
+	Size int
+}
+
+// Layout of a bank:
+//
+//     header record
+//     snapshot of the EEPROM contents (size bytes, rounded up)
+//     log records
+//
+// A record is 4 bytes (padded to the write block size of the device): a check
+// byte, the address (little endian) and the value. An erased record (all 0xff)
+// marks the end of the log. The header record uses a magic value instead of
+// the address and is only written once the snapshot is complete, so that an
+// interrupted bank switch leaves the old bank active.
+const (
+	recordLength = 4
+	headerMagic  = 0xee
+	chunkSize    = 64 // buffer size used while copying the snapshot
+)
+
+// EEPROM is an emulated EEPROM. Create it with New and call Configure before
+// use. It is not safe for concurrent use.
+type EEPROM struct {
+	dev        BlockDevice
+	size       int
+	recordSize int64 // size of a record, at least the write block size
+	bankSize   int64 // size of a single bank, a multiple of the erase block size
+	start      int64 // offset of the first bank in the block device
+	bank       int   // index of the active bank (0 or 1)
+	sequence   uint8 // sequence number of the active bank
+	next       int64 // offset of the next free record in the active bank
+}
+
+// New returns a new emulated EEPROM on the given block device.
+func New(dev BlockDevice) *EEPROM {
+	return &EEPROM{dev: dev}
+}
+
+// Configure sets up the EEPROM, reading the current contents from flash. When
+// there is no valid bank (for example, on first use), the EEPROM is formatted
+// and all bytes read as 0xff.
+func (e *EEPROM) Configure(config Config) error {
+	if config.Size == 0 {
+		config.Size = 1024
+	}
+	if config.Size < 0 || config.Size > 0x4000 {
+		return ErrOutOfRange
+	}
+	e.size = config.Size
+
+	e.recordSize = recordLength
+	if e.dev.WriteBlockSize() > e.recordSize {
+		e.recordSize = e.dev.WriteBlockSize()
+	}
+
+	// Each bank must hold a header, a snapshot and a log that is at least as
+	// large as the snapshot, so that a bank switch isn't needed too often.
+	eraseBlockSize := e.dev.EraseBlockSize()
+	needed := e.recordSize + 2*e.snapshotSize()
+	e.bankSize = (needed + eraseBlockSize - 1) / eraseBlockSize * eraseBlockSize
+	e.start = (e.dev.Size() - 2*e.bankSize) / eraseBlockSize * eraseBlockSize
+	if e.start < 0 {
+		return ErrNoSpace
+	}
+
+	// Find the active bank: the bank with a valid header and the most recent
+	// sequence number.
+	seq0, valid0 := e.readHeader(0)
+	seq1, valid1 := e.readHeader(1)
+	switch {
+	case valid0 && valid1:
+		e.bank = 0
+		e.sequence = seq0
+		if int8(seq1-seq0) > 0 {
+			e.bank = 1
+			e.sequence = seq1
+		}
+	case valid0:
+		e.bank = 0
+		e.sequence = seq0
+	case valid1:
+		e.bank = 1
+		e.sequence = seq1
+	default:
+		return e.format()
+	}
+
+	// Find the end of the log.
+	e.next = e.logStart()
+	record := make([]byte, recordLength)
+	for e.next+e.recordSize <= e.bankSize {
+		err := e.readRecord(e.next, record)
+		if err != nil {
+			return err
+		}
+		if isErased(record) {
+			break
+		}
+		e.next += e.recordSize
+	}
+	return nil
+}
+
+// Size returns the size of the EEPROM in bytes.
+func (e *EEPROM) Size() int {
+	return e.size
+}
+
+// Get returns the byte at the given address. It returns 0xff for addresses
+// that are out of range or when the EEPROM could not be read.
+func (e *EEPROM) Get(addr int) byte {
+	var buf [1]byte
+	_, err := e.ReadAt(buf[:], int64(addr))
+	if err != nil {
+		return 0xff
+	}
+	return buf[0]
+}
+
+// Set changes the byte at the given address. Nothing is written to flash when
+// the byte already has this value.
+func (e *EEPROM) Set(addr int, value byte) error {
+	_, err := e.WriteAt([]byte{value}, int64(addr))
+	return err
+}
+
+// ReadAt reads len(p) bytes starting at the given address.
+func (e *EEPROM) ReadAt(p []byte, off int64) (n int, err error) {
+	if e.size == 0 {
+		return 0, ErrUnconfigured
+	}
+	if off < 0 || off+int64(len(p)) > int64(e.size) {
+		return 0, ErrOutOfRange
+	}
+	_, err = e.dev.ReadAt(p, e.bankOffset(e.bank)+e.recordSize+off)
+	if err != nil {
+		return 0, err
+	}
+
+	// Apply all log records in order, so that the last write wins.
+	record := make([]byte, recordLength)
+	for pos := e.logStart(); pos < e.next; pos += e.recordSize {
+		err := e.readRecord(pos, record)
+		if err != nil {
+			return 0, err
+		}
+		addr, value, ok := decodeRecord(record)
+		if ok && int64(addr) >= off && int64(addr) < off+int64(len(p)) {
+			p[int64(addr)-off] = value
+		}
+	}
+	return len(p), nil
+}
+
+// WriteAt writes p starting at the given address. Bytes that do not change
+// are not written to flash.
+func (e *EEPROM) WriteAt(p []byte, off int64) (n int, err error) {
+	if e.size == 0 {
+		return 0, ErrUnconfigured
+	}
+	if off < 0 || off+int64(len(p)) > int64(e.size) {
+		return 0, ErrOutOfRange
+	}
+	current := make([]byte, len(p))
+	_, err = e.ReadAt(current, off)
+	if err != nil {
+		return 0, err
+	}
+	record := make([]byte, e.recordSize)
+	for i, value := range p {
+		if current[i] == value {
+			continue
+		}
+		if e.next+e.recordSize > e.bankSize {
+			// The log is full, switch to the other bank. The snapshot
+			// includes the bytes written so far.
+			err := e.switchBank()
+			if err != nil {
+				return i, err
+			}
+		}
+		encodeRecord(record, int(off)+i, value)
+		_, err := e.dev.WriteAt(record, e.bankOffset(e.bank)+e.next)
+		if err != nil {
+			return i, err
+		}
+		e.next += e.recordSize
+	}
+	return len(p), nil
+}
+
+// format erases the first bank and makes it the active bank, with all bytes
+// set to 0xff.
+func (e *EEPROM) format() error {
+	err := e.eraseBank(0)
+	if err != nil {
+		return err
+	}
+	e.bank = 0
+	e.sequence = 0
+	e.next = e.logStart()
+	return e.writeHeader(0, e.sequence)
+}
+
+// switchBank copies the current EEPROM contents to the other bank, which then
+// becomes the active bank with an empty log. The old bank is left intact until
+// the new header has been written.
+func (e *EEPROM) switchBank() error {
+	newBank := 1 - e.bank
+	err := e.eraseBank(newBank)
+	if err != nil {
+		return err
+	}
+	chunkLength := e.chunkLength()
+	chunk := make([]byte, chunkLength)
+	for off := int64(0); off < int64(e.size); off += chunkLength {
+		for i := range chunk {
+			chunk[i] = 0xff
+		}
+		length := int64(e.size) - off
+		if length > chunkLength {
+			length = chunkLength
+		}
+		_, err := e.ReadAt(chunk[:length], off)
+		if err != nil {
+			return err
+		}
+		if isErased(chunk) {
+			// Nothing to write, the flash is already erased.
+			continue
+		}
+		_, err = e.dev.WriteAt(chunk, e.bankOffset(newBank)+e.recordSize+off)
+		if err != nil {
+			return err
+		}
+	}
+	err = e.writeHeader(newBank, e.sequence+1)
+	if err != nil {
+		return err
+	}
+	e.bank = newBank
+	e.sequence++
+	e.next = e.logStart()
+	return nil
+}
+
+// chunkLength returns the size of the buffer used while copying the snapshot,
+// rounded up to the write block size.
+func (e *EEPROM) chunkLength() int64 {
+	return (chunkSize + e.recordSize - 1) / e.recordSize * e.recordSize
+}
+
+// snapshotSize returns the size of the snapshot in a bank. It is rounded up
+// to the chunk length so that it can be written in full chunks.
+func (e *EEPROM) snapshotSize() int64 {
+	chunkLength := e.chunkLength()
+	return (int64(e.size) + chunkLength - 1) / chunkLength * chunkLength
+}
+
+// logStart returns the offset of the first log record within a bank.
+func (e *EEPROM) logStart() int64 {
+	return e.recordSize + e.snapshotSize()
+}
+
+// bankOffset returns the offset of the given bank in the block device.
+func (e *EEPROM) bankOffset(bank int) int64 {
+	return e.start + int64(bank)*e.bankSize
+}
+
+func (e *EEPROM) eraseBank(bank int) error {
+	eraseBlockSize := e.dev.EraseBlockSize()
+	return e.dev.EraseBlocks(e.bankOffset(bank)/eraseBlockSize, e.bankSize/eraseBlockSize)
+}
+
+// readRecord reads the record at the given offset in the active bank.
+func (e *EEPROM) readRecord(pos int64, record []byte) error {
+	_, err := e.dev.ReadAt(record, e.bankOffset(e.bank)+pos)
+	return err
+}
+
+// readHeader returns the sequence number of the given bank and whether it has
+// a valid header.
+func (e *EEPROM) readHeader(bank int) (uint8, bool) {
+	header := make([]byte, recordLength)
+	_, err := e.dev.ReadAt(header, e.bankOffset(bank))
+	if err != nil {
+		return 0, false
+	}
+	if header[0] != headerMagic || header[1] != headerMagic || header[3] != ^header[2] {
+		return 0, false
+	}
+	return header[2], true
+}
+
+func (e *EEPROM) writeHeader(bank int, sequence uint8) error {
+	header := make([]byte, e.recordSize)
+	for i := range header {
+		header[i] = 0xff
+	}
+	header[0] = headerMagic
+	header[1] = headerMagic
+	header[2] = sequence
+	header[3] = ^sequence
+	_, err := e.dev.WriteAt(header, e.bankOffset(bank))
+	return err
+}
+
+// encodeRecord stores a log record in the given buffer. As the address is
+// less than 0x4000, the upper address byte is never 0xff and a valid record
+// can't be confused with erased flash.
+func encodeRecord(record []byte, addr int, value byte) {
+	for i := range record {
+		record[i] = 0xff
+	}
+	record[0] = recordCheck(byte(addr), byte(addr>>8), value)
+	record[1] = byte(addr)
+	record[2] = byte(addr >> 8)
+	record[3] = value
+}
+
+// decodeRecord returns the address and value of a log record. Records that
+// were only partially written (because of a reset during the write) are
+// reported as invalid: the bytes that were not written yet read as 0xff, which
+// is never a valid upper address byte, or doesn't match the check byte.
+func decodeRecord(record []byte) (addr int, value byte, ok bool) {
+	if record[2] >= 0x40 || record[0] != recordCheck(record[1], record[2], record[3]) {
+		return 0, 0, false
+	}
+	return int(record[1]) | int(record[2])<<8, record[3], true
+}
+
+func recordCheck(addrLow, addrHigh, value byte) byte {
+	return ^(addrLow + addrHigh*3 + value*7)
+}
+
+// isErased returns whether all bytes in the buffer are 0xff.
+func isErased(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0xff {
+			return false
+		}
+	}
+	return true
+}
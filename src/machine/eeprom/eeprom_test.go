@@ -0,0 +1,291 @@
+package eeprom
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var errPowerLoss = errors.New("power loss")
+
+// testFlash is a block device in RAM that behaves like NOR flash: writes can
+// only clear bits and an erased block reads as all 0xff bytes. It can simulate
+// a power loss after a given number of writes and erases.
+type testFlash struct {
+	t              *testing.T
+	data           []byte
+	writeBlockSize int64
+	eraseBlockSize int64
+	budget         int // operations left before a power loss, or -1
+	erases         int
+}
+
+func newTestFlash(t *testing.T, size, writeBlockSize, eraseBlockSize int64) *testFlash {
+	f := &testFlash{
+		t:              t,
+		data:           make([]byte, size),
+		writeBlockSize: writeBlockSize,
+		eraseBlockSize: eraseBlockSize,
+		budget:         -1,
+	}
+	for i := range f.data {
+		f.data[i] = 0xff
+	}
+	return f
+}
+
+// powerLoss returns whether the power is lost before the next operation.
+func (f *testFlash) powerLoss() bool {
+	if f.budget == 0 {
+		return true
+	}
+	if f.budget > 0 {
+		f.budget--
+	}
+	return false
+}
+
+func (f *testFlash) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(f.data)) {
+		f.t.Fatalf("read out of range: %d bytes at %#x", len(p), off)
+	}
+	return copy(p, f.data[off:]), nil
+}
+
+func (f *testFlash) WriteAt(p []byte, off int64) (int, error) {
+	if off%f.writeBlockSize != 0 || int64(len(p))%f.writeBlockSize != 0 {
+		f.t.Fatalf("unaligned write: %d bytes at %#x", len(p), off)
+	}
+	if off < 0 || off+int64(len(p)) > int64(len(f.data)) {
+		f.t.Fatalf("write out of range: %d bytes at %#x", len(p), off)
+	}
+	if f.powerLoss() {
+		// Only the first half of the data reaches the flash.
+		p = p[:len(p)/2]
+		for i, b := range p {
+			f.data[off+int64(i)] &= b
+		}
+		return 0, errPowerLoss
+	}
+	for i, b := range p {
+		f.data[off+int64(i)] &= b
+	}
+	return len(p), nil
+}
+
+func (f *testFlash) Size() int64 {
+	return int64(len(f.data))
+}
+
+func (f *testFlash) WriteBlockSize() int64 {
+	return f.writeBlockSize
+}
+
+func (f *testFlash) EraseBlockSize() int64 {
+	return f.eraseBlockSize
+}
+
+func (f *testFlash) EraseBlocks(start, count int64) error {
+	if (start+count)*f.eraseBlockSize > int64(len(f.data)) {
+		f.t.Fatalf("erase out of range: %d blocks at block %d", count, start)
+	}
+	for block := start; block < start+count; block++ {
+		if f.powerLoss() {
+			return errPowerLoss
+		}
+		f.erases++
+		for i := block * f.eraseBlockSize; i < (block+1)*f.eraseBlockSize; i++ {
+			f.data[i] = 0xff
+		}
+	}
+	return nil
+}
+
+// open configures a new EEPROM on the given flash, as happens after a reset.
+func open(t *testing.T, flash *testFlash, size int) *EEPROM {
+	ee := New(flash)
+	err := ee.Configure(Config{Size: size})
+	if err != nil {
+		t.Fatal("could not configure EEPROM:", err)
+	}
+	return ee
+}
+
+// checkContents verifies that the EEPROM contains the expected bytes.
+func checkContents(t *testing.T, ee *EEPROM, expected []byte) {
+	t.Helper()
+	actual := make([]byte, len(expected))
+	_, err := ee.ReadAt(actual, 0)
+	if err != nil {
+		t.Fatal("could not read EEPROM:", err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("unexpected contents:\nexpected: %x\nactual:   %x", expected, actual)
+	}
+}
+
+func erased(size int) []byte {
+	buf := make([]byte, size)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	return buf
+}
+
+func TestFormat(t *testing.T) {
+	flash := newTestFlash(t, 4096, 4, 256)
+	ee := open(t, flash, 64)
+	checkContents(t, ee, erased(64))
+	if ee.Get(64) != 0xff || ee.Get(-1) != 0xff {
+		t.Error("expected 0xff for addresses out of range")
+	}
+	if err := ee.Set(64, 0); err != ErrOutOfRange {
+		t.Error("expected ErrOutOfRange, got", err)
+	}
+
+	// The start of the device must be left alone.
+	for i, b := range flash.data[:4096-2*256] {
+		if b != 0xff {
+			t.Fatalf("byte %#x outside of the EEPROM banks was modified", i)
+		}
+	}
+}
+
+func TestUnconfigured(t *testing.T) {
+	ee := New(newTestFlash(t, 4096, 4, 256))
+	if err := ee.Set(0, 1); err != ErrUnconfigured {
+		t.Error("expected ErrUnconfigured, got", err)
+	}
+}
+
+func TestNoSpace(t *testing.T) {
+	ee := New(newTestFlash(t, 256, 4, 256))
+	if err := ee.Configure(Config{Size: 64}); err != ErrNoSpace {
+		t.Error("expected ErrNoSpace, got", err)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	for _, writeBlockSize := range []int64{1, 4, 8} {
+		flash := newTestFlash(t, 8192, writeBlockSize, 512)
+		ee := open(t, flash, 100)
+		expected := erased(100)
+		for i := 0; i < 300; i++ {
+			addr := i * 7 % 100
+			value := byte(i)
+			if err := ee.Set(addr, value); err != nil {
+				t.Fatal("could not write:", err)
+			}
+			expected[addr] = value
+		}
+		_, err := ee.WriteAt([]byte("hello"), 90)
+		if err != nil {
+			t.Fatal("could not write:", err)
+		}
+		copy(expected[90:], "hello")
+		checkContents(t, ee, expected)
+
+		// The contents must survive a reset.
+		checkContents(t, open(t, flash, 100), expected)
+	}
+}
+
+func TestUnchangedBytesAreNotWritten(t *testing.T) {
+	flash := newTestFlash(t, 4096, 4, 256)
+	ee := open(t, flash, 64)
+	if err := ee.Set(3, 0x42); err != nil {
+		t.Fatal(err)
+	}
+	next := ee.next
+	if err := ee.Set(3, 0x42); err != nil {
+		t.Fatal(err)
+	}
+	if ee.next != next {
+		t.Error("writing the same value again added a record")
+	}
+}
+
+// TestWraparound writes so often that the log wraps around between the two
+// banks many times, and the 8-bit sequence number of the banks overflows.
+func TestWraparound(t *testing.T) {
+	flash := newTestFlash(t, 4096, 4, 256)
+	ee := open(t, flash, 64)
+	expected := erased(64)
+	for i := 0; i < 20000; i++ {
+		addr := i % 64
+		value := byte(i / 64)
+		if err := ee.Set(addr, value); err != nil {
+			t.Fatal("could not write:", err)
+		}
+		expected[addr] = value
+		if i%997 == 0 {
+			// Check after a reset now and then, with the banks in various
+			// states.
+			ee = open(t, flash, 64)
+			checkContents(t, ee, expected)
+		}
+	}
+	if flash.erases < 256 {
+		t.Fatalf("expected the sequence number to wrap around, but there were only %d erases", flash.erases)
+	}
+	checkContents(t, ee, expected)
+	checkContents(t, open(t, flash, 64), expected)
+}
+
+// TestPowerLoss interrupts a sequence of writes at every possible flash
+// operation, including the ones that copy the contents to the other bank, and
+// checks that the contents after a reset are either those from before or from
+// after the interrupted write.
+func TestPowerLoss(t *testing.T) {
+	const size = 64
+	const writes = 200 // enough for a few bank switches
+
+	// Count the number of flash operations of the whole sequence.
+	flash := newTestFlash(t, 4096, 4, 256)
+	flash.budget = 1 << 30
+	ee := open(t, flash, size)
+	start := flash.budget
+	for i := 0; i < writes; i++ {
+		if err := ee.Set(i*5%size, byte(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	operations := start - flash.budget
+
+	for cut := 0; cut < operations; cut++ {
+		flash := newTestFlash(t, 4096, 4, 256)
+		ee := open(t, flash, size)
+		flash.budget = cut
+		before := erased(size)
+		var after []byte
+		for i := 0; i < writes; i++ {
+			after = append([]byte(nil), before...)
+			after[i*5%size] = byte(i)
+			if err := ee.Set(i*5%size, byte(i)); err != nil {
+				if err != errPowerLoss {
+					t.Fatal("unexpected error:", err)
+				}
+				break
+			}
+			before = after
+		}
+
+		// Reset, and check that no data was lost.
+		flash.budget = -1
+		ee = open(t, flash, size)
+		actual := make([]byte, size)
+		if _, err := ee.ReadAt(actual, 0); err != nil {
+			t.Fatal("could not read EEPROM:", err)
+		}
+		if !bytes.Equal(actual, before) && !bytes.Equal(actual, after) {
+			t.Fatalf("power loss after %d operations:\nexpected: %x\n      or: %x\nactual:   %x", cut, before, after, actual)
+		}
+
+		// The EEPROM must still be usable.
+		if err := ee.Set(0, 0x5a); err != nil {
+			t.Fatal("could not write after power loss:", err)
+		}
+		actual[0] = 0x5a
+		checkContents(t, open(t, flash, size), actual)
+	}
+}
@@ -0,0 +1,29 @@
+// +build nrf sam,atsamd21
+
+package machine
+
+import "errors"
+
+// Hardware event routing lets a peripheral trigger another peripheral without
+// involving the CPU, for example a timer that starts an ADC conversion at a
+// precise interval. An event is routed to one or more tasks through a channel
+// of an EventRouter: the PPI on the nRF and the event system (EVSYS) on the
+// SAMD21. The peripherals must be configured to generate the event and to
+// accept the task; events and tasks are identified in a chip-specific way,
+// see the Event and Task types.
+
+var (
+	ErrNoEventChannel   = errors.New("machine: no free event channel")
+	ErrEventChannelFull = errors.New("machine: no room for another task on event channel")
+)
+
+// EventChannel is a channel of an EventRouter, which routes an event to one or
+// more tasks.
+type EventChannel struct {
+	index uint8
+}
+
+// Index returns the hardware channel number.
+func (ch EventChannel) Index() uint8 {
+	return ch.index
+}
@@ -0,0 +1,109 @@
+// +build nrf sam,atsamd21 stm32
+
+package machine
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// The flash that is not used by the program image, as defined in the linker
+// script.
+//go:extern _flash_data_start
+var flashDataStartSymbol [0]byte
+
+//go:extern _flash_data_end
+var flashDataEndSymbol [0]byte
+
+var (
+	ErrFlashOutOfRange = errors.New("machine: flash access out of range")
+	ErrFlashNotAligned = errors.New("machine: flash write or erase not aligned")
+	ErrFlashFailed     = errors.New("machine: flash operation failed")
+)
+
+// Flash is the part of the internal flash memory that is not used by the
+// program image. It can be used to store configuration data or a new firmware
+// image. Offsets are relative to the start of this region, which starts at the
+// first erase block after the program image.
+//
+// Flash must be erased before it can be written: erasing sets all bytes in an
+// erase block to 0xff and writing can only clear bits. Writes must be aligned
+// to WriteBlockSize and erases to EraseBlockSize.
+var Flash = flashBlockDevice{}
+
+type flashBlockDevice struct{}
+
+// start returns the address of the first usable byte of flash.
+func (f flashBlockDevice) start() uintptr {
+	start := uintptr(unsafe.Pointer(&flashDataStartSymbol))
+	blockSize := uintptr(f.EraseBlockSize())
+	return (start + blockSize - 1) &^ (blockSize - 1)
+}
+
+// Size returns the number of bytes available in the flash region.
+func (f flashBlockDevice) Size() int64 {
+	end := uintptr(unsafe.Pointer(&flashDataEndSymbol))
+	start := f.start()
+	if start >= end {
+		return 0
+	}
+	return int64(end - start)
+}
+
+// WriteBlockSize returns the size (and alignment) of a single write operation.
+func (f flashBlockDevice) WriteBlockSize() int64 {
+	return flashWriteBlockSize
+}
+
+// EraseBlockSize returns the size (and alignment) of an erase block.
+func (f flashBlockDevice) EraseBlockSize() int64 {
+	return int64(flashEraseBlockSize())
+}
+
+// ReadAt reads len(p) bytes at the given offset. The flash is memory mapped, so
+// this is a plain memory copy.
+func (f flashBlockDevice) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off+int64(len(p)) > f.Size() {
+		return 0, ErrFlashOutOfRange
+	}
+	addr := f.start() + uintptr(off)
+	for i := range p {
+		p[i] = *(*byte)(unsafe.Pointer(addr + uintptr(i)))
+	}
+	return len(p), nil
+}
+
+// WriteAt writes p at the given offset. The offset and the length of p must be
+// multiples of WriteBlockSize, and the flash must have been erased before.
+func (f flashBlockDevice) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off+int64(len(p)) > f.Size() {
+		return 0, ErrFlashOutOfRange
+	}
+	if off%flashWriteBlockSize != 0 || int64(len(p))%flashWriteBlockSize != 0 {
+		return 0, ErrFlashNotAligned
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	err = writeFlash(f.start()+uintptr(off), p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// EraseBlocks erases the given number of erase blocks, starting at the given
+// block number.
+func (f flashBlockDevice) EraseBlocks(start, count int64) error {
+	blockSize := f.EraseBlockSize()
+	if start < 0 || count < 0 || (start+count)*blockSize > f.Size() {
+		return ErrFlashOutOfRange
+	}
+	for i := start; i < start+count; i++ {
+		err := eraseFlashBlock(f.start() + uintptr(i*blockSize))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
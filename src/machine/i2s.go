@@ -1,4 +1,4 @@
-// +build sam
+// +build sam nrf52840
 
 // This is the definition for I2S bus functions.
 // Actual implementations if available for any given hardware
@@ -51,4 +51,9 @@ type I2SConfig struct {
 	AudioFrequency    uint32
 	MasterClockOutput bool
 	Stereo            bool
+
+	// MCK is the pin the master clock is output on when MasterClockOutput is
+	// set, on chips where it can be routed to any pin (the nRF52840). The
+	// master clock is not output when MCK is left unset.
+	MCK Pin
 }
@@ -0,0 +1,241 @@
+package littlefs
+
+// This file implements access to the block device: reading, programming
+// through a cache so that writes are aligned to the program size, erasing and
+// allocating blocks.
+
+const (
+	blockNull   = 0xffffffff // no block, for example the tail of the last metadata pair
+	blockInline = 0xfffffffe // the data of a file is stored in its metadata
+)
+
+// cache buffers the data that is programmed to a block, so that the device is
+// only written in multiples of the program size. An empty cache has block
+// blockNull.
+type cache struct {
+	block uint32
+	off   uint32
+	size  uint32
+	buf   []byte
+}
+
+func (fs *FS) newCache() cache {
+	c := cache{buf: make([]byte, fs.cacheSize)}
+	c.drop()
+	return c
+}
+
+// drop discards the contents of the cache.
+func (c *cache) drop() {
+	c.block = blockNull
+	c.size = 0
+	for i := range c.buf {
+		c.buf[i] = 0xff
+	}
+}
+
+// read reads len(p) bytes at the given offset in a block. Data that is still
+// in the given program cache (which may be nil) is read from the cache.
+func (fs *FS) read(pc *cache, block, off uint32, p []byte) error {
+	if block >= fs.blockCount || off+uint32(len(p)) > fs.blockSize {
+		return ErrCorrupt
+	}
+	for len(p) != 0 {
+		if pc != nil && pc.block == block && off < pc.off+pc.size {
+			if off >= pc.off {
+				n := copy(p, pc.buf[off-pc.off:pc.size])
+				p = p[n:]
+				off += uint32(n)
+				continue
+			}
+			// Read from the device up to the start of the cache.
+			n := pc.off - off
+			if n > uint32(len(p)) {
+				n = uint32(len(p))
+			}
+			if err := fs.readDevice(block, off, p[:n]); err != nil {
+				return err
+			}
+			p = p[n:]
+			off += n
+			continue
+		}
+		return fs.readDevice(block, off, p)
+	}
+	return nil
+}
+
+func (fs *FS) readDevice(block, off uint32, p []byte) error {
+	_, err := fs.dev.ReadAt(p, int64(block)*int64(fs.blockSize)+int64(off))
+	return err
+}
+
+// prog programs data at the given offset in a block, through the given
+// program cache. The data may not reach the device until the cache is
+// flushed.
+func (fs *FS) prog(pc *cache, block, off uint32, data []byte) error {
+	if block >= fs.blockCount || off+uint32(len(data)) > fs.blockSize {
+		return ErrCorrupt
+	}
+	for len(data) != 0 {
+		if pc.block == block && off >= pc.off && off < pc.off+fs.cacheSize {
+			n := uint32(copy(pc.buf[off-pc.off:], data))
+			data = data[n:]
+			off += n
+			if off-pc.off > pc.size {
+				pc.size = off - pc.off
+			}
+			if pc.size == fs.cacheSize {
+				// Eagerly flush a full cache.
+				if err := fs.flush(pc); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// Start a new cache window, which starts at a program boundary.
+		if err := fs.flush(pc); err != nil {
+			return err
+		}
+		pc.block = block
+		pc.off = off / fs.progSize * fs.progSize
+	}
+	return nil
+}
+
+// flush writes the contents of the program cache to the device, padded with
+// 0xff to a multiple of the program size.
+func (fs *FS) flush(pc *cache) error {
+	if pc.block == blockNull || pc.size == 0 {
+		pc.drop()
+		return nil
+	}
+	n := alignUp(pc.size, fs.progSize)
+	_, err := fs.dev.WriteAt(pc.buf[:n], int64(pc.block)*int64(fs.blockSize)+int64(pc.off))
+	pc.drop()
+	return err
+}
+
+func (fs *FS) erase(block uint32) error {
+	return fs.dev.EraseBlocks(int64(block), 1)
+}
+
+// lookahead is a bitmap of blocks that are in use, for a window of blocks
+// starting at off. Blocks before i have already been handed out.
+type lookahead struct {
+	off  uint32
+	size uint32
+	i    uint32
+	ack  uint32 // number of blocks that can be looked at before giving up
+	buf  []byte
+}
+
+// alloc finds a free block. The block is not erased.
+//
+// Blocks are handed out in order, starting at a pseudorandom block after a
+// mount, which spreads the wear over the whole device. The window of blocks
+// that is scanned for free blocks never extends to blocks that were looked at
+// since the last allocAck, so blocks that were allocated but that aren't
+// referenced by the filesystem yet are never handed out twice.
+func (fs *FS) alloc() (uint32, error) {
+	for {
+		for fs.free.i != fs.free.size {
+			off := fs.free.i
+			fs.free.i++
+			fs.free.ack--
+			if fs.free.buf[off/8]&(1<<(off%8)) == 0 {
+				block := (fs.free.off + off) % fs.blockCount
+				// Skip the blocks that are in use, so that an ack doesn't
+				// count them.
+				for fs.free.i != fs.free.size && fs.free.buf[fs.free.i/8]&(1<<(fs.free.i%8)) != 0 {
+					fs.free.i++
+					fs.free.ack--
+				}
+				return block, nil
+			}
+		}
+
+		// Check whether all blocks have been looked at since the last ack.
+		if fs.free.ack == 0 {
+			return 0, ErrNoSpace
+		}
+
+		// Move the window and find the blocks that are in use.
+		fs.free.off = (fs.free.off + fs.free.size) % fs.blockCount
+		fs.free.size = uint32(len(fs.free.buf)) * 8
+		if fs.free.size > fs.free.ack {
+			fs.free.size = fs.free.ack
+		}
+		fs.free.i = 0
+		for i := range fs.free.buf {
+			fs.free.buf[i] = 0
+		}
+		err := fs.traverse(func(block uint32) {
+			off := (block + fs.blockCount - fs.free.off) % fs.blockCount
+			if off < fs.free.size {
+				fs.free.buf[off/8] |= 1 << (off % 8)
+			}
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// allocAck marks all blocks that were allocated as referenced by the
+// filesystem (or by an open file).
+func (fs *FS) allocAck() {
+	fs.free.ack = fs.blockCount
+}
+
+// allocDrop forgets the free blocks, so that the next alloc scans the
+// filesystem again.
+func (fs *FS) allocDrop() {
+	fs.free.size = 0
+	fs.free.i = 0
+	fs.allocAck()
+}
+
+// crcTable is the table for crc, for four bits at a time.
+var crcTable = [16]uint32{
+	0x00000000, 0x1db71064, 0x3b6e20c8, 0x26d930ac,
+	0x76dc4190, 0x6b6b51f4, 0x4db26158, 0x5005713c,
+	0xedb88320, 0xf00f9344, 0xd6d6a3e8, 0xcb61b38c,
+	0x9b64c2b0, 0x86d3d2d4, 0xa00ae278, 0xbdbdf21c,
+}
+
+// crc updates a CRC-32 checksum the way LittleFS does: with the reversed
+// polynomial 0xedb88320 but without inverting the result.
+func crc(c uint32, data []byte) uint32 {
+	for _, b := range data {
+		c = c>>4 ^ crcTable[(c^uint32(b))&0xf]
+		c = c>>4 ^ crcTable[(c^uint32(b>>4))&0xf]
+	}
+	return c
+}
+
+func alignUp(n, align uint32) uint32 {
+	return (n + align - 1) / align * align
+}
+
+func getLE32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func putLE32(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+	b[3] = byte(v >> 24)
+}
+
+func getBE32(b []byte) uint32 {
+	return uint32(b[3]) | uint32(b[2])<<8 | uint32(b[1])<<16 | uint32(b[0])<<24
+}
+
+func putBE32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
@@ -0,0 +1,1054 @@
+package littlefs
+
+// This file implements metadata pairs, which store directories (and the
+// superblock) as a log of tags. See the LittleFS specification for details:
+// https://github.com/littlefs-project/littlefs/blob/master/SPEC.md
+//
+// A metadata pair is two blocks, which are written alternately: commits are
+// appended to the block with the most recent revision count until it is full,
+// after which the pair is compacted into the other block. Each commit ends in
+// a CRC tag, so that an interrupted commit is ignored.
+//
+// Every tag is 32 bits, stored big endian and XORed with the previous tag:
+//
+//     [valid (1 bit)] [type (11 bits)] [id (10 bits)] [size (10 bits)]
+//
+// The id is the index of a directory entry in the metadata pair. Entries are
+// created and deleted with splice tags, which shift the ids of the entries
+// that follow.
+
+// Tag types.
+const (
+	typeName       = 0x000
+	typeReg        = 0x001
+	typeDir        = 0x002
+	typeSuperblock = 0x0ff
+
+	typeStruct       = 0x200
+	typeDirStruct    = 0x200
+	typeInlineStruct = 0x201
+	typeCTZStruct    = 0x202
+
+	typeUserAttr = 0x300
+
+	typeSplice = 0x400
+	typeCreate = 0x401
+	typeDelete = 0x4ff
+
+	typeCRC = 0x500
+
+	typeTail     = 0x600
+	typeSoftTail = 0x600
+	typeHardTail = 0x601
+
+	typeGlobals   = 0x700
+	typeMoveState = 0x7ff
+)
+
+// noID is the id of tags that don't belong to an entry.
+const noID = 0x3ff
+
+type tag uint32
+
+func mkTag(typ, id, size uint32) tag {
+	return tag(typ<<20 | id<<10 | size)
+}
+
+func (t tag) isValid() bool {
+	return t&0x80000000 == 0
+}
+
+// isDelete returns whether this tag removes an attribute, which is indicated
+// with a size of 0x3ff.
+func (t tag) isDelete() bool {
+	return t&0x3ff == 0x3ff
+}
+
+func (t tag) type1() uint32 {
+	return uint32(t&0x70000000) >> 20
+}
+
+func (t tag) type3() uint32 {
+	return uint32(t&0x7ff00000) >> 20
+}
+
+func (t tag) chunk() uint32 {
+	return uint32(t&0x0ff00000) >> 20
+}
+
+func (t tag) id() uint32 {
+	return uint32(t&0x000ffc00) >> 10
+}
+
+func (t tag) size() uint32 {
+	return uint32(t & 0x3ff)
+}
+
+// dsize returns the size of the tag together with its data.
+func (t tag) dsize() uint32 {
+	if t.isDelete() {
+		return 4
+	}
+	return 4 + t.size()
+}
+
+// isCommitCRC returns whether this tag ends a commit. Newer versions of
+// LittleFS use types 0x580-0x5ff for other CRCs, which are ordinary tags.
+func (t tag) isCommitCRC() bool {
+	return t&0x78000000 == 0x50000000
+}
+
+// key returns the type that identifies the attribute of an entry this tag
+// sets. Names and structs are unique per entry (a file that becomes a
+// directory replaces its name tag), user attributes are unique per type.
+func (t tag) key() uint32 {
+	if t.type3()&0x100 != 0 {
+		return t.type3()
+	}
+	return t.type1()
+}
+
+// An attr is a tag together with its data. The data is either in memory or
+// in a metadata block on the device.
+type attr struct {
+	tag   tag
+	buf   []byte
+	block uint32
+	off   uint32
+}
+
+// readAttr reads the first len(p) bytes of the data of the attribute.
+func (fs *FS) readAttr(a attr, p []byte) error {
+	if a.buf != nil {
+		copy(p, a.buf)
+		return nil
+	}
+	return fs.read(&fs.pcache, a.block, a.off, p)
+}
+
+// readPair reads the metadata pair stored in a tail or directory struct.
+func (fs *FS) readPair(a attr) ([2]uint32, error) {
+	var buf [8]byte
+	if a.tag.size() < 8 {
+		return [2]uint32{}, ErrCorrupt
+	}
+	err := fs.readAttr(a, buf[:])
+	return [2]uint32{getLE32(buf[0:]), getLE32(buf[4:])}, err
+}
+
+func pairBytes(pair [2]uint32) []byte {
+	buf := make([]byte, 8)
+	putLE32(buf[0:], pair[0])
+	putLE32(buf[4:], pair[1])
+	return buf
+}
+
+func pairIsNull(pair [2]uint32) bool {
+	return pair[0] == blockNull || pair[1] == blockNull
+}
+
+// pairOverlap returns whether two pairs share a block: the blocks of a pair
+// swap places when it is compacted.
+func pairOverlap(a, b [2]uint32) bool {
+	return a[0] == b[0] || a[0] == b[1] || a[1] == b[0] || a[1] == b[1]
+}
+
+// pairSync returns whether two pairs consist of the same blocks.
+func pairSync(a, b [2]uint32) bool {
+	return a[0] == b[0] && a[1] == b[1] || a[0] == b[1] && a[1] == b[0]
+}
+
+// gstate is the global state: a move that is in progress and whether there
+// may be orphaned metadata pairs. It is the XOR of the move state tags of all
+// metadata pairs. In memory, the size field counts the orphans; on the device
+// only the top bit is set when there are orphans.
+type gstate struct {
+	tag  tag
+	pair [2]uint32
+}
+
+func (g gstate) xor(o gstate) gstate {
+	return gstate{g.tag ^ o.tag, [2]uint32{g.pair[0] ^ o.pair[0], g.pair[1] ^ o.pair[1]}}
+}
+
+func (g gstate) isZero() bool {
+	return g.tag == 0 && g.pair[0] == 0 && g.pair[1] == 0
+}
+
+func (g gstate) hasOrphans() bool {
+	return g.tag.size() != 0
+}
+
+func (g gstate) hasMove() bool {
+	return g.tag.type1() != 0
+}
+
+func (g gstate) hasMoveHere(pair [2]uint32) bool {
+	return g.hasMove() && pairOverlap(g.pair, pair)
+}
+
+func (g gstate) bytes() []byte {
+	buf := make([]byte, 12)
+	putLE32(buf[0:], uint32(g.tag))
+	putLE32(buf[4:], g.pair[0])
+	putLE32(buf[8:], g.pair[1])
+	return buf
+}
+
+// prepOrphans changes the number of orphans in the global state, which is
+// written to the device with the next commit.
+func (fs *FS) prepOrphans(n int) {
+	fs.gstate.tag = tag(int32(fs.gstate.tag) + int32(n))
+	fs.gstate.tag &^= 0x80000000
+	if fs.gstate.hasOrphans() {
+		fs.gstate.tag |= 0x80000000
+	}
+}
+
+// prepMove records in the global state that the entry with the given id in
+// the given pair is being moved, and must be deleted if the move is
+// interrupted. An id of noID clears the move.
+func (fs *FS) prepMove(id uint32, pair [2]uint32) {
+	fs.gstate.tag &^= mkTag(0x7ff, 0x3ff, 0)
+	fs.gstate.pair = [2]uint32{}
+	if id != noID {
+		fs.gstate.tag |= mkTag(typeDelete, id, 0)
+		fs.gstate.pair = pair
+	}
+}
+
+// entry is a directory entry: its name, struct and user attributes.
+type entry struct {
+	attrs []attr
+	moved bool // entry is the source of an interrupted move, and hidden
+}
+
+func (e *entry) get(key uint32) (attr, bool) {
+	for _, a := range e.attrs {
+		if a.tag.key() == key {
+			return a, true
+		}
+	}
+	return attr{}, false
+}
+
+// set replaces the attribute with the same key, or removes it for a delete
+// tag. The attrs slice is never modified in place, because it may be shared
+// with older versions of the entry.
+func (e *entry) set(a attr) {
+	attrs := make([]attr, 0, len(e.attrs)+1)
+	for _, old := range e.attrs {
+		if old.tag.key() != a.tag.key() {
+			attrs = append(attrs, old)
+		}
+	}
+	if !a.tag.isDelete() {
+		attrs = append(attrs, a)
+	}
+	e.attrs = attrs
+}
+
+// typ returns the type of the entry: typeReg, typeDir or typeSuperblock.
+func (e *entry) typ() uint32 {
+	a, ok := e.get(typeName)
+	if !ok {
+		return 0
+	}
+	return a.tag.type3()
+}
+
+// mdir is a metadata pair as read from the device.
+type mdir struct {
+	pair    [2]uint32 // pair[0] is the block with the most recent commit
+	rev     uint32
+	off     uint32 // end of the last commit
+	etag    tag    // last tag, to XOR the next tag with
+	erased  bool   // the rest of the block is erased, so commits can be appended
+	split   bool   // the directory continues in the tail
+	tail    [2]uint32
+	entries []entry
+	gstate  gstate // contribution of this pair to the global state
+}
+
+// applyEntryTag applies a tag that changes the entries of a metadata pair.
+func applyEntryTag(entries []entry, a attr) ([]entry, error) {
+	id := int(a.tag.id())
+	switch {
+	case a.tag.type3() == typeCreate:
+		if id > len(entries) {
+			return nil, ErrCorrupt
+		}
+		entries = append(entries, entry{})
+		copy(entries[id+1:], entries[id:])
+		entries[id] = entry{}
+	case a.tag.type3() == typeDelete:
+		if id >= len(entries) {
+			return nil, ErrCorrupt
+		}
+		entries = append(entries[:id:id], entries[id+1:]...)
+	case a.tag.type1() == typeName || a.tag.type1() == typeStruct || a.tag.type1() == typeUserAttr:
+		if id == noID {
+			return nil, ErrCorrupt
+		}
+		for id >= len(entries) {
+			entries = append(entries, entry{})
+		}
+		entries[id].set(a)
+	}
+	return entries, nil
+}
+
+// fetch reads a metadata pair.
+func (fs *FS) fetch(pair [2]uint32) (*mdir, error) {
+	if pair[0] >= fs.blockCount || pair[1] >= fs.blockCount {
+		return nil, ErrCorrupt
+	}
+
+	// Start with the block with the most recent revision count.
+	var revs [2]uint32
+	for i := range revs {
+		var buf [4]byte
+		if err := fs.read(&fs.pcache, pair[i], 0, buf[:]); err != nil {
+			return nil, err
+		}
+		revs[i] = getLE32(buf[:])
+	}
+	r := 0
+	if int32(revs[1]-revs[0]) > 0 {
+		r = 1
+	}
+
+	for i := 0; i < 2; i++ {
+		d := &mdir{
+			pair: [2]uint32{pair[(r+i)%2], pair[(r+i+1)%2]},
+			rev:  revs[(r+i)%2],
+			tail: [2]uint32{blockNull, blockNull},
+		}
+		ok, err := fs.fetchBlock(d)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			fs.markMoved(d)
+			return d, nil
+		}
+	}
+	return nil, ErrCorrupt
+}
+
+// refetch reads the commits of a metadata pair again after a commit. Only
+// pair[0] is read: the other block may contain an older filesystem.
+func (fs *FS) refetch(d *mdir) error {
+	nd := &mdir{
+		pair: d.pair,
+		rev:  d.rev,
+		tail: [2]uint32{blockNull, blockNull},
+	}
+	ok, err := fs.fetchBlock(nd)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCorrupt
+	}
+	fs.markMoved(nd)
+	*d = *nd
+	return nil
+}
+
+// markMoved hides the source entry of a move that was interrupted.
+func (fs *FS) markMoved(d *mdir) {
+	if fs.gdisk.hasMoveHere(d.pair) {
+		if id := int(fs.gdisk.tag.id()); id < len(d.entries) {
+			d.entries[id].moved = true
+		}
+	}
+}
+
+// fetchBlock reads the commits in d.pair[0]. It returns false if there is no
+// valid commit in the block.
+func (fs *FS) fetchBlock(d *mdir) (bool, error) {
+	block := d.pair[0]
+	var buf [32]byte
+	putLE32(buf[:], d.rev)
+	c := crc(0xffffffff, buf[:4])
+
+	// The state up to the last valid commit, and the state including the
+	// commit that is being read.
+	found := false
+	var entries, tmpEntries []entry
+	modified := false
+	tmpTail := d.tail
+	tmpSplit := false
+	var tmpGstate gstate
+
+	off := uint32(0)
+	ptag := tag(0xffffffff)
+scan:
+	for {
+		off += ptag.dsize()
+		if off+4 > fs.blockSize {
+			d.erased = false
+			break
+		}
+		if err := fs.read(&fs.pcache, block, off, buf[:4]); err != nil {
+			return false, err
+		}
+		c = crc(c, buf[:4])
+		t := tag(getBE32(buf[:4])) ^ ptag
+		if !t.isValid() {
+			// The next commit hasn't been written yet.
+			d.erased = ptag.type1() == typeCRC && d.off%fs.progSize == 0
+			break
+		}
+		if off+t.dsize() > fs.blockSize {
+			d.erased = false
+			break
+		}
+		ptag = t
+
+		if t.isCommitCRC() {
+			if err := fs.read(&fs.pcache, block, off+4, buf[:4]); err != nil {
+				return false, err
+			}
+			if c != getLE32(buf[:4]) {
+				// Interrupted commit.
+				d.erased = false
+				break
+			}
+			// The low bit of the type tells whether the valid bit of the
+			// next tag is inverted, so that erased flash reads as invalid.
+			ptag ^= tag(t.chunk()&1) << 31
+
+			// Use the CRCs as a seed for the block allocator.
+			fs.seed ^= c
+
+			found = true
+			if modified {
+				entries = tmpEntries
+				modified = false
+			}
+			d.tail = tmpTail
+			d.split = tmpSplit
+			d.gstate = tmpGstate
+			d.off = off + t.dsize()
+			d.etag = ptag
+			c = 0xffffffff
+			continue
+		}
+
+		// CRC the data.
+		for i := uint32(4); i < t.dsize(); i += uint32(len(buf)) {
+			n := t.dsize() - i
+			if n > uint32(len(buf)) {
+				n = uint32(len(buf))
+			}
+			if err := fs.read(&fs.pcache, block, off+i, buf[:n]); err != nil {
+				return false, err
+			}
+			c = crc(c, buf[:n])
+		}
+
+		// Tags that don't make sense end the scan, as for a bad CRC: they
+		// can only be part of an interrupted commit.
+		a := attr{tag: t, block: block, off: off + 4}
+		switch {
+		case t.type1() == typeTail:
+			if t.size() < 8 {
+				d.erased = false
+				break scan
+			}
+			pair, err := fs.readPair(a)
+			if err != nil {
+				return false, err
+			}
+			tmpTail = pair
+			tmpSplit = t.chunk()&1 != 0
+		case t.type3() == typeMoveState:
+			if t.size() < 12 {
+				d.erased = false
+				break scan
+			}
+			if err := fs.readAttr(a, buf[:12]); err != nil {
+				return false, err
+			}
+			tmpGstate = gstate{tag(getLE32(buf[0:])), [2]uint32{getLE32(buf[4:]), getLE32(buf[8:])}}
+		default:
+			if !modified {
+				// Don't modify the entries of the last valid commit.
+				tmpEntries = append([]entry(nil), entries...)
+				modified = true
+			}
+			var err error
+			tmpEntries, err = applyEntryTag(tmpEntries, a)
+			if err != nil {
+				d.erased = false
+				break scan
+			}
+		}
+	}
+	d.entries = entries
+	return found, nil
+}
+
+// commitState is a commit that is being written.
+type commitState struct {
+	block uint32
+	off   uint32
+	ptag  tag
+	crc   uint32
+	end   uint32
+}
+
+func (fs *FS) commitProg(c *commitState, data []byte) error {
+	if err := fs.prog(&fs.pcache, c.block, c.off, data); err != nil {
+		return err
+	}
+	c.crc = crc(c.crc, data)
+	c.off += uint32(len(data))
+	return nil
+}
+
+// commitAttr writes a tag with its data. It returns ErrNoSpace if the tag
+// doesn't fit in the block.
+func (fs *FS) commitAttr(c *commitState, a attr) error {
+	if c.off+a.tag.dsize() > c.end {
+		return ErrNoSpace
+	}
+	var buf [32]byte
+	putBE32(buf[:4], uint32(a.tag&0x7fffffff^c.ptag))
+	if err := fs.commitProg(c, buf[:4]); err != nil {
+		return err
+	}
+	size := a.tag.dsize() - 4
+	if a.buf != nil {
+		if err := fs.commitProg(c, a.buf[:size]); err != nil {
+			return err
+		}
+	} else {
+		// Copy the data from another metadata block.
+		for i := uint32(0); i < size; i += uint32(len(buf)) {
+			n := size - i
+			if n > uint32(len(buf)) {
+				n = uint32(len(buf))
+			}
+			if err := fs.read(&fs.pcache, a.block, a.off+i, buf[:n]); err != nil {
+				return err
+			}
+			if err := fs.commitProg(c, buf[:n]); err != nil {
+				return err
+			}
+		}
+	}
+	c.ptag = a.tag & 0x7fffffff
+	return nil
+}
+
+// commitCRC ends a commit with a CRC tag, padded to the program size.
+func (fs *FS) commitCRC(c *commitState) error {
+	end := alignUp(c.off+4+4, fs.progSize)
+
+	// The padding is not included in the CRC, so that a fetch can skip it.
+	// A single CRC tag can only skip 0x3fe bytes, so there may be more.
+	for c.off < end {
+		off := c.off + 4
+		noff := off + 0x3fe
+		if end-off < 0x3fe {
+			noff = end
+		}
+		if noff < end && noff > end-8 {
+			noff = end - 8
+		}
+
+		// Read the erased state of the next word, to make sure it isn't
+		// read as a valid tag.
+		next := uint32(0xffffffff)
+		if noff+4 <= fs.blockSize {
+			var buf [4]byte
+			if err := fs.readDevice(c.block, noff, buf[:]); err != nil {
+				return err
+			}
+			next = getBE32(buf[:])
+		}
+		reset := ^next >> 31
+		t := mkTag(typeCRC+reset, noID, noff-off)
+
+		var footer [8]byte
+		putBE32(footer[:4], uint32(t^c.ptag))
+		c.crc = crc(c.crc, footer[:4])
+		putLE32(footer[4:], c.crc)
+		if err := fs.prog(&fs.pcache, c.block, c.off, footer[:]); err != nil {
+			return err
+		}
+		c.off = noff
+		c.ptag = t ^ tag(reset<<31)
+		c.crc = 0xffffffff
+	}
+	return fs.flush(&fs.pcache)
+}
+
+// globalDelta returns the change of the global state that must be written
+// with the next commit, including the current contribution of the pair.
+func (fs *FS) globalDelta() gstate {
+	delta := fs.gstate.xor(fs.gdisk).xor(fs.gdelta)
+	delta.tag &^= mkTag(0, 0, 0x3ff)
+	return delta
+}
+
+// commit writes attrs to a metadata pair, appending a commit if possible and
+// compacting the pair otherwise. The ids of open files are updated.
+func (fs *FS) commit(d *mdir, attrs []attr) error {
+	// Calculate the changes to the directory.
+	entries := d.entries
+	hasDelete := false
+	for _, a := range attrs {
+		if a.tag.type1() == typeTail {
+			pair, err := fs.readPair(a)
+			if err != nil {
+				return err
+			}
+			d.tail = pair
+			d.split = a.tag.chunk()&1 != 0
+			continue
+		}
+		if a.tag.type3() == typeDelete {
+			hasDelete = true
+		}
+		var err error
+		entries, err = applyEntryTag(append([]entry(nil), entries...), a)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Drop a metadata pair of a directory that has become empty, unless it
+	// is the first pair of the directory.
+	if hasDelete && len(entries) == 0 {
+		pred, err := fs.pred(d.pair)
+		if err != nil && err != ErrNotExist {
+			return err
+		}
+		if err == nil && pred.split {
+			fs.updateFiles(d.pair, nil, attrs)
+			return fs.drop(pred, d)
+		}
+	}
+
+	oldPair := d.pair
+	appended := false
+	if d.erased {
+		err := fs.commitAppend(d, attrs)
+		if err == nil {
+			appended = true
+		} else if err != ErrNoSpace {
+			return err
+		}
+	}
+	if !appended {
+		fs.pcache.drop()
+		if err := fs.compact(d, entries, 0, len(entries)); err != nil {
+			return err
+		}
+	}
+
+	// Read the new state back, so that the entries refer to their data in
+	// this pair.
+	if err := fs.refetch(d); err != nil {
+		return err
+	}
+	return fs.updateFiles(oldPair, d, attrs)
+}
+
+// commitAppend appends a commit to the most recent block of a pair.
+func (fs *FS) commitAppend(d *mdir, attrs []attr) error {
+	c := commitState{
+		block: d.pair[0],
+		off:   d.off,
+		ptag:  d.etag,
+		crc:   0xffffffff,
+		end:   fs.blockSize - 8,
+	}
+	for _, a := range attrs {
+		if err := fs.commitAttr(&c, a); err != nil {
+			return err
+		}
+	}
+
+	// Commit changes to the global state.
+	delta := fs.globalDelta()
+	if !delta.isZero() {
+		delta = delta.xor(d.gstate)
+		err := fs.commitAttr(&c, attr{tag: mkTag(typeMoveState, noID, 12), buf: delta.bytes()})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := fs.commitCRC(&c); err != nil {
+		return err
+	}
+	fs.gdisk = fs.gstate
+	fs.gdelta = gstate{}
+	return nil
+}
+
+// commitSize returns the size of the tags of the given entries.
+func commitSize(entries []entry) uint32 {
+	size := uint32(0)
+	for _, e := range entries {
+		for _, a := range e.attrs {
+			size += a.tag.dsize()
+		}
+	}
+	return size
+}
+
+// compact writes the entries from begin to end to the other block of the
+// pair. When they take more than half a block, the upper half is split off
+// into a new pair, to leave room for new commits.
+func (fs *FS) compact(d *mdir, entries []entry, begin, end int) error {
+	maxSize := alignUp(fs.blockSize/2, fs.progSize)
+	if maxSize > fs.blockSize-36 {
+		maxSize = fs.blockSize - 36
+	}
+	for end-begin > 1 {
+		if end-begin < 0xff && commitSize(entries[begin:end]) <= maxSize {
+			break
+		}
+		split := begin + (end-begin)/2
+		if err := fs.split(d, entries, split, end); err != nil {
+			return err
+		}
+		end = split
+	}
+
+	d.rev++
+	if err := fs.erase(d.pair[1]); err != nil {
+		return err
+	}
+	c := commitState{
+		block: d.pair[1],
+		ptag:  0xffffffff,
+		crc:   0xffffffff,
+		end:   fs.blockSize - 8,
+	}
+	var rev [4]byte
+	putLE32(rev[:], d.rev)
+	if err := fs.commitProg(&c, rev[:]); err != nil {
+		return err
+	}
+	for i, e := range entries[begin:end] {
+		for _, a := range e.attrs {
+			a.tag = a.tag&^mkTag(0, 0x3ff, 0) | mkTag(0, uint32(i), 0)
+			if err := fs.commitAttr(&c, a); err != nil {
+				return err
+			}
+		}
+	}
+	if !pairIsNull(d.tail) {
+		typ := uint32(typeSoftTail)
+		if d.split {
+			typ = typeHardTail
+		}
+		err := fs.commitAttr(&c, attr{tag: mkTag(typ, noID, 8), buf: pairBytes(d.tail)})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The old block is erased with the next compaction, so the contribution
+	// of this pair to the global state must be carried over.
+	delta := fs.globalDelta().xor(d.gstate)
+	if !delta.isZero() {
+		err := fs.commitAttr(&c, attr{tag: mkTag(typeMoveState, noID, 12), buf: delta.bytes()})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := fs.commitCRC(&c); err != nil {
+		return err
+	}
+	d.pair[0], d.pair[1] = d.pair[1], d.pair[0]
+	d.entries = entries[begin:end]
+	d.off = c.off
+	d.etag = c.ptag
+	d.erased = true
+	d.gstate = delta
+	fs.gdisk = fs.gstate
+	fs.gdelta = gstate{}
+	return nil
+}
+
+// split moves the entries from split to end to a new metadata pair, which
+// becomes the tail of d.
+func (fs *FS) split(d *mdir, entries []entry, split, end int) error {
+	tail, err := fs.allocDir()
+	if err != nil {
+		return err
+	}
+	tail.split = d.split
+	tail.tail = d.tail
+	if err := fs.compact(tail, entries, split, end); err != nil {
+		return err
+	}
+	d.tail = tail.pair
+	d.split = true
+	return nil
+}
+
+// drop removes the metadata pair tail, which follows d, from the list of
+// metadata pairs.
+func (fs *FS) drop(d, tail *mdir) error {
+	// Take over the global state and the tail of the dropped pair.
+	fs.gdelta = fs.gdelta.xor(tail.gstate)
+	typ := uint32(typeSoftTail)
+	if tail.split {
+		typ = typeHardTail
+	}
+	return fs.commit(d, []attr{{tag: mkTag(typ, noID, 8), buf: pairBytes(tail.tail)}})
+}
+
+// allocDir allocates a new, empty metadata pair. It is written with the first
+// commit.
+func (fs *FS) allocDir() (*mdir, error) {
+	d := &mdir{
+		off:  4,
+		etag: 0xffffffff,
+		tail: [2]uint32{blockNull, blockNull},
+	}
+	// Allocate backwards, so that block 1 is written first.
+	for i := 0; i < 2; i++ {
+		block, err := fs.alloc()
+		if err != nil {
+			return nil, err
+		}
+		d.pair[(i+1)%2] = block
+	}
+	// Rather than erasing one of the blocks, pretend that its revision count
+	// may be valid, so that the new revision count is higher.
+	var buf [4]byte
+	if err := fs.readDevice(d.pair[0], 0, buf[:]); err != nil {
+		return nil, err
+	}
+	d.rev = getLE32(buf[:])
+	return d, nil
+}
+
+// updateFiles updates the location of the entries of open files after a
+// commit to a metadata pair. When d is nil, the pair has been dropped.
+func (fs *FS) updateFiles(oldPair [2]uint32, d *mdir, attrs []attr) error {
+	for _, f := range fs.files {
+		if !pairOverlap(f.pair, oldPair) {
+			continue
+		}
+		for _, a := range attrs {
+			switch a.tag.type3() {
+			case typeDelete:
+				if f.id == a.tag.id() {
+					// The file has been removed.
+					f.pair = [2]uint32{blockNull, blockNull}
+				} else if f.id > a.tag.id() {
+					f.id--
+				}
+			case typeCreate:
+				if f.id >= a.tag.id() {
+					f.id++
+				}
+			}
+		}
+		if d == nil || pairIsNull(f.pair) {
+			f.pair = [2]uint32{blockNull, blockNull}
+			continue
+		}
+		pair, id, err := fs.locate(d, int(f.id))
+		if err != nil {
+			return err
+		}
+		f.pair = pair
+		f.id = uint32(id)
+	}
+	return nil
+}
+
+// locate follows the tails of a split metadata pair until it finds the pair
+// that contains the entry with the given id, counted from the start of d.
+func (fs *FS) locate(d *mdir, id int) ([2]uint32, int, error) {
+	for id >= len(d.entries) && d.split {
+		id -= len(d.entries)
+		var err error
+		d, err = fs.fetch(d.tail)
+		if err != nil {
+			return [2]uint32{}, 0, err
+		}
+	}
+	return d.pair, id, nil
+}
+
+// rootPair is the metadata pair with the superblock, at the start of the list
+// of all metadata pairs.
+var rootPair = [2]uint32{0, 1}
+
+// traverse calls fn for every block that is in use.
+func (fs *FS) traverse(fn func(block uint32)) error {
+	tail := rootPair
+	for n := uint32(0); !pairIsNull(tail); n++ {
+		if n > fs.blockCount/2 {
+			// The list of metadata pairs contains a cycle.
+			return ErrCorrupt
+		}
+		fn(tail[0])
+		fn(tail[1])
+		d, err := fs.fetch(tail)
+		if err != nil {
+			return err
+		}
+		for _, e := range d.entries {
+			a, ok := e.get(typeStruct)
+			if !ok || a.tag.type3() != typeCTZStruct {
+				continue
+			}
+			head, size, err := fs.readCTZ(a)
+			if err != nil {
+				return err
+			}
+			if err := fs.ctzTraverse(nil, head, size, fn); err != nil {
+				return err
+			}
+		}
+		tail = d.tail
+	}
+
+	// The blocks of open files that haven't been committed yet.
+	for _, f := range fs.files {
+		if f.state&fileInline != 0 {
+			continue
+		}
+		if f.state&fileDirty != 0 && f.head != blockInline {
+			if err := fs.ctzTraverse(&f.cache, f.head, f.size, fn); err != nil {
+				return err
+			}
+		}
+		if f.state&fileWriting != 0 {
+			if err := fs.ctzTraverse(&f.cache, f.block, f.pos, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pred returns the metadata pair whose tail is the given pair.
+func (fs *FS) pred(pair [2]uint32) (*mdir, error) {
+	tail := rootPair
+	for n := uint32(0); !pairIsNull(tail); n++ {
+		if n > fs.blockCount/2 {
+			return nil, ErrCorrupt
+		}
+		d, err := fs.fetch(tail)
+		if err != nil {
+			return nil, err
+		}
+		if pairOverlap(d.tail, pair) {
+			return d, nil
+		}
+		tail = d.tail
+	}
+	return nil, ErrNotExist
+}
+
+// parent returns the metadata pair and the id of the directory entry that
+// refers to the given pair.
+func (fs *FS) parent(pair [2]uint32) (*mdir, int, error) {
+	tail := rootPair
+	for n := uint32(0); !pairIsNull(tail); n++ {
+		if n > fs.blockCount/2 {
+			return nil, 0, ErrCorrupt
+		}
+		d, err := fs.fetch(tail)
+		if err != nil {
+			return nil, 0, err
+		}
+		for id, e := range d.entries {
+			a, ok := e.get(typeStruct)
+			if e.moved || !ok || a.tag.type3() != typeDirStruct {
+				continue
+			}
+			p, err := fs.readPair(a)
+			if err != nil {
+				return nil, 0, err
+			}
+			if pairOverlap(p, pair) {
+				return d, id, nil
+			}
+		}
+		tail = d.tail
+	}
+	return nil, 0, ErrNotExist
+}
+
+// forceConsistency finishes a move that was interrupted and removes orphaned
+// metadata pairs. It must be called before the filesystem is modified.
+func (fs *FS) forceConsistency() error {
+	if fs.gdisk.hasMove() {
+		d, err := fs.fetch(fs.gdisk.pair)
+		if err != nil {
+			return err
+		}
+		id := fs.gdisk.tag.id()
+		fs.prepMove(noID, [2]uint32{})
+		if err := fs.commit(d, []attr{{tag: mkTag(typeDelete, id, 0)}}); err != nil {
+			return err
+		}
+	}
+	if fs.gstate.hasOrphans() {
+		return fs.deorphan()
+	}
+	return nil
+}
+
+// deorphan removes metadata pairs of directories that have no parent (after
+// an interrupted directory removal) and repairs the list when a parent refers
+// to a different pair.
+func (fs *FS) deorphan() error {
+	pdir := &mdir{split: true, tail: rootPair}
+	for n := uint32(0); !pairIsNull(pdir.tail); n++ {
+		if n > fs.blockCount {
+			return ErrCorrupt
+		}
+		d, err := fs.fetch(pdir.tail)
+		if err != nil {
+			return err
+		}
+		if !pdir.split {
+			// The tail is the first pair of a directory, check its parent.
+			parent, id, err := fs.parent(pdir.tail)
+			if err == ErrNotExist {
+				// Orphan.
+				if err := fs.drop(pdir, d); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			a, _ := parent.entries[id].get(typeStruct)
+			pair, err := fs.readPair(a)
+			if err != nil {
+				return err
+			}
+			if !pairSync(pair, pdir.tail) {
+				// The parent refers to a different pair.
+				err := fs.commit(pdir, []attr{{tag: mkTag(typeSoftTail, noID, 8), buf: pairBytes(pair)}})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		pdir = d
+	}
+	fs.prepOrphans(-int(fs.gstate.tag.size()))
+	return nil
+}
@@ -0,0 +1,593 @@
+package littlefs
+
+import (
+	"io"
+	"math/bits"
+)
+
+// Flags for OpenFile. Exactly one of O_RDONLY, O_WRONLY and O_RDWR must be
+// given. The values are the same as in the C implementation of LittleFS.
+const (
+	O_RDONLY = 1
+	O_WRONLY = 2
+	O_RDWR   = O_RDONLY | O_WRONLY
+	O_CREATE = 0x0100 // create the file if it doesn't exist
+	O_EXCL   = 0x0200 // with O_CREATE, fail if the file already exists
+	O_TRUNC  = 0x0400 // truncate the file to zero bytes
+	O_APPEND = 0x0800 // always write at the end of the file
+)
+
+// Internal state of a file.
+const (
+	fileDirty   = 1 << iota // file must be committed to its metadata pair
+	fileWriting             // file data is being written to a new block
+	fileReading             // block and off are the read position
+	fileInline              // file data is stored in its metadata pair
+	fileErred               // a write failed, don't commit the file
+)
+
+// A File is an open file. The data of small files is stored inline in the
+// metadata pair of the directory. Larger files are stored in a linked list of
+// blocks (a CTZ skip-list), which is written from the end: every block points
+// back to the blocks before it, and changing the file copies the blocks after
+// the first change.
+type File struct {
+	fs     *FS
+	pair   [2]uint32 // metadata pair with the entry of the file
+	id     uint32    // id of the entry in the metadata pair
+	flag   int
+	state  int
+	head   uint32 // last block of the committed file, or blockInline
+	size   uint32 // size of the committed file
+	inline []byte // data of an inline file
+	pos    uint32
+	block  uint32
+	off    uint32
+	cache  cache
+}
+
+// OpenFile opens the file with the given name. See the O_* constants for the
+// possible flags.
+func (fs *FS) OpenFile(path string, flag int) (*File, error) {
+	if flag&O_RDWR == 0 {
+		return nil, ErrInvalid
+	}
+	if flag&O_WRONLY != 0 {
+		if err := fs.forceConsistency(); err != nil {
+			return nil, err
+		}
+	}
+
+	d, id, name, err := fs.find(path)
+	if err == ErrNotExist && d != nil && flag&O_CREATE != 0 {
+		// Create a new, empty file.
+		if len(name) > int(fs.nameMax) {
+			return nil, ErrNameTooLong
+		}
+		id = len(d.entries)
+		err = fs.commit(d, []attr{
+			{tag: mkTag(typeCreate, uint32(id), 0)},
+			{tag: mkTag(typeReg, uint32(id), uint32(len(name))), buf: []byte(name)},
+			{tag: mkTag(typeInlineStruct, uint32(id), 0), buf: []byte{}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		flag &^= O_TRUNC
+	} else if err != nil {
+		return nil, err
+	} else if flag&O_CREATE != 0 && flag&O_EXCL != 0 {
+		return nil, ErrExist
+	} else if id < 0 || d.entries[id].typ() != typeReg {
+		return nil, ErrIsDir
+	}
+
+	f := &File{
+		fs:    fs,
+		flag:  flag,
+		cache: fs.newCache(),
+	}
+	f.pair, id, err = fs.locate(d, id)
+	if err != nil {
+		return nil, err
+	}
+	f.id = uint32(id)
+	if flag&O_TRUNC != 0 {
+		f.head = blockInline
+		f.state = fileInline | fileDirty
+	} else {
+		d, err := fs.fetch(f.pair)
+		if err != nil {
+			return nil, err
+		}
+		a, ok := d.entries[f.id].get(typeStruct)
+		if !ok {
+			return nil, ErrCorrupt
+		}
+		switch a.tag.type3() {
+		case typeInlineStruct:
+			f.head = blockInline
+			f.size = a.tag.size()
+			f.inline = make([]byte, f.size)
+			if err := fs.readAttr(a, f.inline); err != nil {
+				return nil, err
+			}
+			f.state = fileInline
+		case typeCTZStruct:
+			f.head, f.size, err = fs.readCTZ(a)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, ErrCorrupt
+		}
+	}
+	fs.files = append(fs.files, f)
+	return f, nil
+}
+
+// Open opens the file with the given name for reading.
+func (fs *FS) Open(path string) (*File, error) {
+	return fs.OpenFile(path, O_RDONLY)
+}
+
+// Create creates the file with the given name, or truncates it if it already
+// exists, and opens it for writing.
+func (fs *FS) Create(path string) (*File, error) {
+	return fs.OpenFile(path, O_WRONLY|O_CREATE|O_TRUNC)
+}
+
+// readCTZ reads the head and size of a file from a CTZ struct.
+func (fs *FS) readCTZ(a attr) (head, size uint32, err error) {
+	var buf [8]byte
+	if a.tag.size() < 8 {
+		return 0, 0, ErrCorrupt
+	}
+	err = fs.readAttr(a, buf[:])
+	return getLE32(buf[0:]), getLE32(buf[4:]), err
+}
+
+// Read reads up to len(p) bytes from the file.
+func (f *File) Read(p []byte) (int, error) {
+	if f.fs == nil {
+		return 0, ErrClosed
+	}
+	if f.flag&O_RDONLY == 0 {
+		return 0, ErrWriteOnly
+	}
+	if f.state&fileWriting != 0 {
+		if err := f.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if uint32(len(p)) > f.size-f.pos {
+		p = p[:f.size-f.pos]
+	}
+	n := 0
+	for len(p) != 0 {
+		if f.state&fileReading == 0 || f.off == f.fs.blockSize {
+			if f.state&fileInline != 0 {
+				f.block = blockInline
+				f.off = f.pos
+			} else {
+				var err error
+				f.block, f.off, err = f.fs.ctzFind(nil, f.head, f.size, f.pos)
+				if err != nil {
+					return n, err
+				}
+			}
+			f.state |= fileReading
+		}
+
+		chunk := p
+		if uint32(len(chunk)) > f.fs.blockSize-f.off {
+			chunk = chunk[:f.fs.blockSize-f.off]
+		}
+		if f.block == blockInline {
+			copy(chunk, f.inline[f.off:])
+		} else if err := f.fs.read(nil, f.block, f.off, chunk); err != nil {
+			return n, err
+		}
+		f.pos += uint32(len(chunk))
+		f.off += uint32(len(chunk))
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Write writes len(p) bytes to the file. The data is stored on the device
+// when the file is synced or closed.
+func (f *File) Write(p []byte) (int, error) {
+	if f.fs == nil {
+		return 0, ErrClosed
+	}
+	if f.flag&O_WRONLY == 0 {
+		return 0, ErrReadOnly
+	}
+	if f.state&fileReading != 0 {
+		if err := f.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if f.flag&O_APPEND != 0 && f.pos < f.size {
+		f.pos = f.size
+	}
+	if uint64(f.pos)+uint64(len(p)) > uint64(f.fs.fileMax) {
+		return 0, ErrNoSpace
+	}
+
+	if f.state&fileWriting == 0 && f.pos > f.size {
+		// Fill the gap with zeros.
+		var zeros [16]byte
+		pos := f.pos
+		f.pos = f.size
+		for f.pos < pos {
+			n := pos - f.pos
+			if n > uint32(len(zeros)) {
+				n = uint32(len(zeros))
+			}
+			if err := f.write(zeros[:n]); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := f.write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *File) write(p []byte) error {
+	fs := f.fs
+	end := f.pos + uint32(len(p))
+	if end < f.size {
+		end = f.size
+	}
+	if f.state&fileInline != 0 && end > fs.inlineMax {
+		// The file doesn't fit in its metadata pair anymore.
+		if err := f.outline(); err != nil {
+			f.state |= fileErred
+			return err
+		}
+	}
+
+	for len(p) != 0 {
+		if f.state&fileWriting == 0 || f.off == fs.blockSize {
+			if f.state&fileInline != 0 {
+				f.block = blockInline
+				f.off = f.pos
+			} else {
+				if f.state&fileWriting == 0 && f.pos > 0 {
+					// Find the block to continue the file from.
+					var err error
+					f.block, _, err = fs.ctzFind(nil, f.head, f.size, f.pos-1)
+					if err != nil {
+						f.state |= fileErred
+						return err
+					}
+					f.cache.drop()
+				}
+
+				// Add a new block to the file.
+				fs.allocAck()
+				var err error
+				f.block, f.off, err = fs.ctzExtend(&f.cache, f.block, f.pos)
+				if err != nil {
+					f.state |= fileErred
+					return err
+				}
+			}
+			f.state |= fileWriting
+		}
+
+		chunk := p
+		if uint32(len(chunk)) > fs.blockSize-f.off {
+			chunk = chunk[:fs.blockSize-f.off]
+		}
+		if f.block == blockInline {
+			if end := f.off + uint32(len(chunk)); end > uint32(len(f.inline)) {
+				f.inline = append(f.inline, make([]byte, end-uint32(len(f.inline)))...)
+			}
+			copy(f.inline[f.off:], chunk)
+		} else if err := fs.prog(&f.cache, f.block, f.off, chunk); err != nil {
+			f.state |= fileErred
+			return err
+		}
+		f.pos += uint32(len(chunk))
+		f.off += uint32(len(chunk))
+		p = p[len(chunk):]
+		fs.allocAck()
+	}
+	f.state &^= fileErred
+	return nil
+}
+
+// outline moves the data of an inline file to a block. The rest of the inline
+// data is copied when the file is flushed.
+func (f *File) outline() error {
+	fs := f.fs
+	fs.allocAck()
+	block, err := fs.alloc()
+	if err != nil {
+		return err
+	}
+	if err := fs.erase(block); err != nil {
+		return err
+	}
+	f.cache.drop()
+	if err := fs.prog(&f.cache, block, 0, f.inline[:f.pos]); err != nil {
+		return err
+	}
+	f.block = block
+	f.off = f.pos
+	f.state |= fileWriting
+	f.state &^= fileInline
+	return nil
+}
+
+// flush writes the data that is being written to the device, after copying
+// the rest of the old data of the file. The file still has to be committed.
+func (f *File) flush() error {
+	fs := f.fs
+	if f.state&fileReading != 0 {
+		f.state &^= fileReading
+	}
+	if f.state&fileWriting == 0 {
+		return nil
+	}
+
+	pos := f.pos
+	if f.state&fileInline == 0 {
+		// Copy the part of the old file after the written data.
+		orig := File{
+			fs:     fs,
+			flag:   O_RDONLY,
+			head:   f.head,
+			size:   f.size,
+			inline: f.inline,
+			pos:    f.pos,
+		}
+		if f.head == blockInline {
+			orig.state = fileInline
+		}
+		var buf [16]byte
+		for f.pos < f.size {
+			n, err := orig.Read(buf[:])
+			if err != nil {
+				f.state |= fileErred
+				return err
+			}
+			if err := f.write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err := fs.flush(&f.cache); err != nil {
+			f.state |= fileErred
+			return err
+		}
+		f.head = f.block
+		f.inline = nil
+	} else if f.pos < f.size {
+		f.pos = f.size
+	}
+	f.size = f.pos
+	f.state &^= fileWriting
+	f.state |= fileDirty
+	f.pos = pos
+	return nil
+}
+
+// Sync writes the file to the device and commits it to its directory.
+func (f *File) Sync() error {
+	if f.fs == nil {
+		return ErrClosed
+	}
+	if f.state&fileErred != 0 {
+		// A write failed, keep the old version of the file.
+		return nil
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+	if f.state&fileDirty == 0 || pairIsNull(f.pair) {
+		// Not changed, or removed while it was open.
+		return nil
+	}
+
+	var a attr
+	if f.state&fileInline != 0 {
+		buf := make([]byte, f.size)
+		copy(buf, f.inline)
+		a = attr{tag: mkTag(typeInlineStruct, f.id, f.size), buf: buf}
+	} else {
+		buf := make([]byte, 8)
+		putLE32(buf[0:], f.head)
+		putLE32(buf[4:], f.size)
+		a = attr{tag: mkTag(typeCTZStruct, f.id, 8), buf: buf}
+	}
+	d, err := f.fs.fetch(f.pair)
+	if err == nil {
+		err = f.fs.commit(d, []attr{a})
+	}
+	if err != nil {
+		f.state |= fileErred
+		return err
+	}
+	f.state &^= fileDirty
+	return nil
+}
+
+// Close syncs the file and closes it.
+func (f *File) Close() error {
+	if f.fs == nil {
+		return ErrClosed
+	}
+	err := f.Sync()
+	files := f.fs.files
+	for i, other := range files {
+		if other == f {
+			f.fs.files = append(files[:i:i], files[i+1:]...)
+			break
+		}
+	}
+	f.fs = nil
+	return err
+}
+
+// Seek sets the position for the next Read or Write, as defined by
+// io.Seeker.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.fs == nil {
+		return 0, ErrClosed
+	}
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = int64(f.pos) + offset
+	case io.SeekEnd:
+		pos = f.Size() + offset
+	default:
+		return 0, ErrInvalid
+	}
+	if pos < 0 || pos > int64(f.fs.fileMax) {
+		return 0, ErrInvalid
+	}
+	if uint32(pos) == f.pos {
+		return pos, nil
+	}
+	if err := f.flush(); err != nil {
+		return 0, err
+	}
+	f.pos = uint32(pos)
+	return pos, nil
+}
+
+// Size returns the size of the file, including data that hasn't been synced.
+func (f *File) Size() int64 {
+	if f.state&fileWriting != 0 && f.pos > f.size {
+		return int64(f.pos)
+	}
+	return int64(f.size)
+}
+
+// ctzIndex returns the index of the block that contains the given offset in a
+// file, and the offset within that block. Block i starts with ctz(i)+1
+// pointers to earlier blocks (block 0 has none).
+func (fs *FS) ctzIndex(off uint32) (uint32, uint32) {
+	b := fs.blockSize - 2*4
+	i := off / b
+	if i == 0 {
+		return 0, off
+	}
+	i = (off - 4*(uint32(bits.OnesCount32(i-1))+2)) / b
+	return i, off - b*i - 4*uint32(bits.OnesCount32(i))
+}
+
+// ctzFind returns the block and offset of the byte at pos in a file, by
+// following the pointers from the last block.
+func (fs *FS) ctzFind(pc *cache, head, size, pos uint32) (uint32, uint32, error) {
+	if size == 0 {
+		return blockNull, 0, nil
+	}
+	current, _ := fs.ctzIndex(size - 1)
+	target, off := fs.ctzIndex(pos)
+	for current > target {
+		skip := uint32(bits.Len32(current-target)) - 1
+		if tz := uint32(bits.TrailingZeros32(current)); tz < skip {
+			skip = tz
+		}
+		var buf [4]byte
+		if err := fs.read(pc, head, 4*skip, buf[:]); err != nil {
+			return 0, 0, err
+		}
+		head = getLE32(buf[:])
+		current -= 1 << skip
+	}
+	return head, off, nil
+}
+
+// ctzExtend allocates a new last block for a file of the given size. If the
+// last block is only partially used, it is copied to the new block. It
+// returns the new block and the offset in it where the file continues.
+func (fs *FS) ctzExtend(pc *cache, head, size uint32) (uint32, uint32, error) {
+	block, err := fs.alloc()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := fs.erase(block); err != nil {
+		return 0, 0, err
+	}
+	if size == 0 {
+		return block, 0, nil
+	}
+
+	index, off := fs.ctzIndex(size - 1)
+	off++
+	if off != fs.blockSize {
+		// Copy the partial last block.
+		var buf [16]byte
+		for i := uint32(0); i < off; i += uint32(len(buf)) {
+			n := off - i
+			if n > uint32(len(buf)) {
+				n = uint32(len(buf))
+			}
+			if err := fs.read(pc, head, i, buf[:n]); err != nil {
+				return 0, 0, err
+			}
+			if err := fs.prog(pc, block, i, buf[:n]); err != nil {
+				return 0, 0, err
+			}
+		}
+		return block, off, nil
+	}
+
+	// Append a block, with pointers to the blocks 2^n blocks back.
+	index++
+	skips := uint32(bits.TrailingZeros32(index)) + 1
+	var buf [4]byte
+	for i := uint32(0); i < skips; i++ {
+		putLE32(buf[:], head)
+		if err := fs.prog(pc, block, 4*i, buf[:]); err != nil {
+			return 0, 0, err
+		}
+		if i != skips-1 {
+			if err := fs.read(pc, head, 4*i, buf[:]); err != nil {
+				return 0, 0, err
+			}
+			head = getLE32(buf[:])
+		}
+	}
+	return block, 4 * skips, nil
+}
+
+// ctzTraverse calls fn for every block of a file.
+func (fs *FS) ctzTraverse(pc *cache, head, size uint32, fn func(block uint32)) error {
+	if size == 0 {
+		return nil
+	}
+	index, _ := fs.ctzIndex(size - 1)
+	for {
+		fn(head)
+		if index == 0 {
+			return nil
+		}
+		// Block i has ctz(i)+1 pointers, so block i-1 can be found in the
+		// first pointer and block i-2 in the second if i is even.
+		count := 2 - index&1
+		var buf [8]byte
+		if err := fs.read(pc, head, 0, buf[:4*count]); err != nil {
+			return err
+		}
+		if count == 2 {
+			fn(getLE32(buf[0:]))
+		}
+		head = getLE32(buf[4*(count-1):])
+		index -= count
+	}
+}
@@ -0,0 +1,662 @@
+// Package littlefs implements the LittleFS filesystem on top of flash memory,
+// such as machine.Flash. It can be mounted in the os package, so that files
+// can be accessed with os.Open and os.Create.
+//
+// The on-flash format is version 2.0 of LittleFS, so a filesystem can be
+// created or read on a host with the C implementation (for example with
+// littlefs-fuse or mklittlefs), as long as the block size matches the erase
+// block size of the device. Filesystems of version 2.1 can be read and
+// written as well.
+//
+// LittleFS is designed to survive a reset at any time: every change is
+// committed atomically, and file data is written to new blocks that only
+// become part of the file when the file is synced or closed. Compared to the
+// C implementation, this package does not relocate metadata pairs to spread
+// the wear (block_cycles) and does not handle blocks that fail to program.
+//
+// An FS is not safe for concurrent use.
+package littlefs
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNotExist    = errors.New("littlefs: file does not exist")
+	ErrExist       = errors.New("littlefs: file already exists")
+	ErrNotDir      = errors.New("littlefs: not a directory")
+	ErrIsDir       = errors.New("littlefs: is a directory")
+	ErrNotEmpty    = errors.New("littlefs: directory not empty")
+	ErrNoSpace     = errors.New("littlefs: no space left on device")
+	ErrNameTooLong = errors.New("littlefs: file name too long")
+	ErrInvalid     = errors.New("littlefs: invalid argument")
+	ErrClosed      = errors.New("littlefs: file already closed")
+	ErrReadOnly    = errors.New("littlefs: file not open for writing")
+	ErrWriteOnly   = errors.New("littlefs: file not open for reading")
+	ErrCorrupt     = errors.New("littlefs: filesystem is corrupt")
+	ErrVersion     = errors.New("littlefs: unsupported version or geometry")
+	ErrTooSmall    = errors.New("littlefs: block device too small")
+)
+
+// BlockDevice is the flash memory the filesystem is stored in, such as
+// machine.Flash. Writes must be aligned to WriteBlockSize and erases to
+// EraseBlockSize, and an erased block must read as all 0xff bytes.
+type BlockDevice interface {
+	ReadAt(p []byte, off int64) (n int, err error)
+	WriteAt(p []byte, off int64) (n int, err error)
+	Size() int64
+	WriteBlockSize() int64
+	EraseBlockSize() int64
+	EraseBlocks(start, count int64) error
+}
+
+// Limits stored in the superblock.
+const (
+	nameMax = 255
+	fileMax = 0x7fffffff
+	attrMax = 0x3fe
+)
+
+// diskVersion is the version of the on-flash format, 2.0.
+const diskVersion = 0x00020000
+
+// FS is a mounted LittleFS filesystem.
+type FS struct {
+	dev        BlockDevice
+	blockSize  uint32
+	blockCount uint32
+	progSize   uint32
+	cacheSize  uint32
+	inlineMax  uint32
+	nameMax    uint32
+	fileMax    uint32
+
+	root   [2]uint32 // metadata pair with the superblock
+	pcache cache     // program cache for metadata
+	seed   uint32    // seed for the block allocator
+
+	gstate gstate // global state in memory
+	gdisk  gstate // global state on the device
+	gdelta gstate // change of the global state to commit with the next commit
+
+	free  lookahead
+	files []*File // open files
+}
+
+// Info describes a file or directory.
+type Info struct {
+	Name  string
+	Size  int64
+	IsDir bool
+}
+
+func newFS(dev BlockDevice) (*FS, error) {
+	fs := &FS{
+		dev:       dev,
+		blockSize: uint32(dev.EraseBlockSize()),
+		progSize:  uint32(dev.WriteBlockSize()),
+		nameMax:   nameMax,
+		fileMax:   fileMax,
+	}
+	if fs.blockSize < 128 || fs.progSize == 0 || fs.blockSize%fs.progSize != 0 {
+		return nil, ErrTooSmall
+	}
+	fs.blockCount = uint32(dev.Size() / int64(fs.blockSize))
+	if fs.blockCount < 2 {
+		return nil, ErrTooSmall
+	}
+
+	// Cache a few program blocks at a time. Small inline files are kept in
+	// the cache of the file in the C implementation, so the cache size also
+	// limits their size.
+	fs.cacheSize = fs.progSize
+	for fs.cacheSize < 64 && fs.blockSize%(fs.cacheSize*2) == 0 {
+		fs.cacheSize *= 2
+	}
+	fs.inlineMax = fs.cacheSize
+	if fs.inlineMax > attrMax {
+		fs.inlineMax = attrMax
+	}
+	if fs.inlineMax > fs.blockSize/8 {
+		fs.inlineMax = fs.blockSize / 8
+	}
+
+	fs.pcache = fs.newCache()
+	lookaheadSize := (fs.blockCount + 7) / 8
+	if lookaheadSize > 128 {
+		lookaheadSize = 128
+	}
+	fs.free.buf = make([]byte, lookaheadSize)
+	fs.root = [2]uint32{blockNull, blockNull}
+	return fs, nil
+}
+
+// Mount reads the filesystem from the given block device. It returns
+// ErrCorrupt if there is no valid filesystem on the device, use Format to
+// create one.
+func Mount(dev BlockDevice) (*FS, error) {
+	fs, err := newFS(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the superblock and the global state in the list of metadata
+	// pairs.
+	tail := rootPair
+	for n := uint32(0); !pairIsNull(tail); n++ {
+		if n > fs.blockCount/2 {
+			return nil, ErrCorrupt
+		}
+		d, err := fs.fetch(tail)
+		if err != nil {
+			return nil, err
+		}
+		if len(d.entries) != 0 && d.entries[0].typ() == typeSuperblock {
+			if err := fs.readSuperblock(&d.entries[0]); err != nil {
+				return nil, err
+			}
+			fs.root = d.pair
+		}
+		fs.gstate = fs.gstate.xor(d.gstate)
+		tail = d.tail
+	}
+	if pairIsNull(fs.root) {
+		return nil, ErrCorrupt
+	}
+
+	// The number of orphans isn't stored, only whether there are any.
+	if !fs.gstate.tag.isValid() {
+		fs.gstate.tag++
+	}
+	fs.gdisk = fs.gstate
+
+	// Start allocating at a pseudorandom block, to spread the wear.
+	fs.free.off = fs.seed % fs.blockCount
+	fs.allocDrop()
+	return fs, nil
+}
+
+func (fs *FS) readSuperblock(e *entry) error {
+	name, _ := e.get(typeName)
+	buf := make([]byte, 24)
+	if name.tag.size() != 8 {
+		return ErrCorrupt
+	}
+	if err := fs.readAttr(name, buf[:8]); err != nil {
+		return err
+	}
+	if string(buf[:8]) != "littlefs" {
+		return ErrCorrupt
+	}
+	a, ok := e.get(typeStruct)
+	if !ok || a.tag.type3() != typeInlineStruct || a.tag.size() < 24 {
+		return ErrCorrupt
+	}
+	if err := fs.readAttr(a, buf); err != nil {
+		return err
+	}
+	version := getLE32(buf[0:])
+	if version>>16 != diskVersion>>16 || version&0xffff > 1 {
+		return ErrVersion
+	}
+	if getLE32(buf[4:]) != fs.blockSize || getLE32(buf[8:]) != fs.blockCount {
+		return ErrVersion
+	}
+	if n := getLE32(buf[12:]); n != 0 && n < fs.nameMax {
+		fs.nameMax = n
+	}
+	if n := getLE32(buf[16:]); n != 0 && n < fs.fileMax {
+		fs.fileMax = n
+	}
+	return nil
+}
+
+// Format creates an empty filesystem on the given block device, removing all
+// files that were stored on it, and mounts it.
+func Format(dev BlockDevice) (*FS, error) {
+	fs, err := newFS(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	// All blocks are free.
+	fs.free.size = uint32(len(fs.free.buf)) * 8
+	if fs.free.size > fs.blockCount {
+		fs.free.size = fs.blockCount
+	}
+	fs.allocAck()
+
+	// The root directory starts in blocks 0 and 1, with the superblock.
+	root, err := fs.allocDir()
+	if err != nil {
+		return nil, err
+	}
+	superblock := make([]byte, 24)
+	putLE32(superblock[0:], diskVersion)
+	putLE32(superblock[4:], fs.blockSize)
+	putLE32(superblock[8:], fs.blockCount)
+	putLE32(superblock[12:], nameMax)
+	putLE32(superblock[16:], fileMax)
+	putLE32(superblock[20:], attrMax)
+	err = fs.commit(root, []attr{
+		{tag: mkTag(typeCreate, 0, 0)},
+		{tag: mkTag(typeSuperblock, 0, 8), buf: []byte("littlefs")},
+		{tag: mkTag(typeInlineStruct, 0, 24), buf: superblock},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Compact the pair, so that the other block doesn't contain a valid
+	// commit of an older filesystem.
+	root.erased = false
+	if err := fs.commit(root, nil); err != nil {
+		return nil, err
+	}
+	return Mount(dev)
+}
+
+// splitPath returns the components of a path, with "." and ".." resolved.
+func splitPath(path string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			if len(names) == 0 {
+				return nil, ErrInvalid
+			}
+			names = names[:len(names)-1]
+		default:
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// find looks up a path. It returns the metadata pair with the entry and its
+// id, or id -1 for the root directory. If only the last component doesn't
+// exist, it returns ErrNotExist together with the last metadata pair of the
+// directory it would be created in, and its name.
+func (fs *FS) find(path string) (*mdir, int, string, error) {
+	names, err := splitPath(path)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	d, err := fs.fetch(fs.root)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	id := -1
+	for i, name := range names {
+		if id >= 0 {
+			// Enter the directory.
+			a, ok := d.entries[id].get(typeStruct)
+			if d.entries[id].typ() != typeDir || !ok || a.tag.type3() != typeDirStruct {
+				return nil, 0, "", ErrNotDir
+			}
+			pair, err := fs.readPair(a)
+			if err != nil {
+				return nil, 0, "", err
+			}
+			d, err = fs.fetch(pair)
+			if err != nil {
+				return nil, 0, "", err
+			}
+		}
+		d, id, err = fs.lookup(d, name)
+		if err == ErrNotExist && i == len(names)-1 {
+			return d, -1, name, err
+		}
+		if err != nil {
+			return nil, 0, "", err
+		}
+	}
+	return d, id, "", nil
+}
+
+// lookup finds the entry with the given name in the directory that starts
+// with metadata pair d. If it doesn't exist, it returns the last metadata
+// pair of the directory.
+func (fs *FS) lookup(d *mdir, name string) (*mdir, int, error) {
+	buf := make([]byte, len(name))
+	for n := uint32(0); ; n++ {
+		if n > fs.blockCount/2 {
+			return nil, 0, ErrCorrupt
+		}
+		for id, e := range d.entries {
+			if e.moved || e.typ() != typeReg && e.typ() != typeDir {
+				continue
+			}
+			a, _ := e.get(typeName)
+			if a.tag.size() != uint32(len(name)) {
+				continue
+			}
+			if err := fs.readAttr(a, buf); err != nil {
+				return nil, 0, err
+			}
+			if string(buf) == name {
+				return d, id, nil
+			}
+		}
+		if !d.split {
+			return d, -1, ErrNotExist
+		}
+		var err error
+		d, err = fs.fetch(d.tail)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// entryName returns the name of an entry.
+func (fs *FS) entryName(e *entry) (string, error) {
+	a, _ := e.get(typeName)
+	name := make([]byte, a.tag.size())
+	err := fs.readAttr(a, name)
+	return string(name), err
+}
+
+// entryInfo returns the name, size and type of an entry.
+func (fs *FS) entryInfo(e *entry) (Info, error) {
+	name, err := fs.entryName(e)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{Name: name, IsDir: e.typ() == typeDir}
+	if a, ok := e.get(typeStruct); ok {
+		switch a.tag.type3() {
+		case typeInlineStruct:
+			info.Size = int64(a.tag.size())
+		case typeCTZStruct:
+			_, size, err := fs.readCTZ(a)
+			if err != nil {
+				return Info{}, err
+			}
+			info.Size = int64(size)
+		}
+	}
+	return info, nil
+}
+
+// Stat returns information about a file or directory.
+func (fs *FS) Stat(path string) (Info, error) {
+	d, id, _, err := fs.find(path)
+	if err != nil {
+		return Info{}, err
+	}
+	if id < 0 {
+		return Info{Name: "/", IsDir: true}, nil
+	}
+	return fs.entryInfo(&d.entries[id])
+}
+
+// ReadDir returns the files and directories in a directory.
+func (fs *FS) ReadDir(path string) ([]Info, error) {
+	d, err := fs.openDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var infos []Info
+	for n := uint32(0); ; n++ {
+		if n > fs.blockCount/2 {
+			return nil, ErrCorrupt
+		}
+		for i := range d.entries {
+			e := &d.entries[i]
+			if e.moved || e.typ() != typeReg && e.typ() != typeDir {
+				continue
+			}
+			info, err := fs.entryInfo(e)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		if !d.split {
+			return infos, nil
+		}
+		d, err = fs.fetch(d.tail)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// openDir returns the first metadata pair of a directory.
+func (fs *FS) openDir(path string) (*mdir, error) {
+	d, id, _, err := fs.find(path)
+	if err != nil || id < 0 {
+		return d, err
+	}
+	a, ok := d.entries[id].get(typeStruct)
+	if d.entries[id].typ() != typeDir || !ok || a.tag.type3() != typeDirStruct {
+		return nil, ErrNotDir
+	}
+	pair, err := fs.readPair(a)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fetch(pair)
+}
+
+// Mkdir creates a directory.
+func (fs *FS) Mkdir(path string) error {
+	if err := fs.forceConsistency(); err != nil {
+		return err
+	}
+	cwd, _, name, err := fs.find(path)
+	if err == nil {
+		return ErrExist
+	}
+	if err != ErrNotExist || cwd == nil {
+		return err
+	}
+	if len(name) > int(fs.nameMax) {
+		return ErrNameTooLong
+	}
+
+	// Write the new directory, and insert it into the list of metadata
+	// pairs after the last pair of the parent directory. It only becomes
+	// part of the list with the commit to the parent.
+	fs.allocAck()
+	dir, err := fs.allocDir()
+	if err != nil {
+		return err
+	}
+	err = fs.commit(dir, []attr{{tag: mkTag(typeSoftTail, noID, 8), buf: pairBytes(cwd.tail)}})
+	if err != nil {
+		return err
+	}
+	id := uint32(len(cwd.entries))
+	return fs.commit(cwd, []attr{
+		{tag: mkTag(typeCreate, id, 0)},
+		{tag: mkTag(typeDir, id, uint32(len(name))), buf: []byte(name)},
+		{tag: mkTag(typeDirStruct, id, 8), buf: pairBytes(dir.pair)},
+		{tag: mkTag(typeSoftTail, noID, 8), buf: pairBytes(dir.pair)},
+	})
+}
+
+// Remove removes a file or an empty directory.
+func (fs *FS) Remove(path string) error {
+	if err := fs.forceConsistency(); err != nil {
+		return err
+	}
+	cwd, id, _, err := fs.find(path)
+	if err != nil {
+		return err
+	}
+	if id < 0 {
+		return ErrInvalid
+	}
+
+	var dir *mdir
+	if cwd.entries[id].typ() == typeDir {
+		dir, err = fs.openDir(path)
+		if err != nil {
+			return err
+		}
+		if len(dir.entries) != 0 || dir.split {
+			return ErrNotEmpty
+		}
+		// The directory is an orphan until it is removed from the list.
+		fs.prepOrphans(1)
+	}
+
+	err = fs.commit(cwd, []attr{{tag: mkTag(typeDelete, uint32(id), 0)}})
+	if err != nil {
+		return err
+	}
+
+	if dir != nil {
+		fs.prepOrphans(-1)
+		return fs.dropDir(dir.pair)
+	}
+	return nil
+}
+
+// dropDir removes the metadata pair of a removed directory from the list.
+func (fs *FS) dropDir(pair [2]uint32) error {
+	pred, err := fs.pred(pair)
+	if err != nil {
+		return err
+	}
+	dir, err := fs.fetch(pair)
+	if err != nil {
+		return err
+	}
+	return fs.drop(pred, dir)
+}
+
+// Rename renames (moves) a file or directory. If newpath exists, it is
+// replaced, which requires it to be of the same type and, for a directory,
+// to be empty. An open file that is renamed can't be synced anymore.
+func (fs *FS) Rename(oldpath, newpath string) error {
+	if err := fs.forceConsistency(); err != nil {
+		return err
+	}
+	oldcwd, oldid, _, err := fs.find(oldpath)
+	if err != nil {
+		return err
+	}
+	if oldid < 0 {
+		return ErrInvalid
+	}
+	oldEntry := oldcwd.entries[oldid]
+	typ := oldEntry.typ()
+	if typ == typeDir {
+		// Moving a directory into itself would disconnect it.
+		oldNames, _ := splitPath(oldpath)
+		newNames, err := splitPath(newpath)
+		if err != nil {
+			return err
+		}
+		if len(newNames) > len(oldNames) && strings.Join(newNames[:len(oldNames)], "/") == strings.Join(oldNames, "/") {
+			return ErrInvalid
+		}
+	}
+
+	newcwd, newid, name, err := fs.find(newpath)
+	exists := err == nil
+	if err == ErrNotExist && newcwd != nil {
+		if len(name) > int(fs.nameMax) {
+			return ErrNameTooLong
+		}
+		newid = len(newcwd.entries)
+	} else if err != nil {
+		return err
+	} else if newid < 0 {
+		return ErrInvalid
+	} else {
+		name, err = fs.entryName(&newcwd.entries[newid])
+		if err != nil {
+			return err
+		}
+	}
+
+	samePair := newcwd.pair == oldcwd.pair
+	newoldid := uint32(oldid)
+	var prevdir *mdir
+	if !exists {
+		// The old entry moves up if the new entry is inserted before it.
+		if samePair && newid <= oldid {
+			newoldid++
+		}
+	} else if newcwd.entries[newid].typ() != typ {
+		if typ == typeDir {
+			return ErrNotDir
+		}
+		return ErrIsDir
+	} else if samePair && newid == oldid {
+		return nil
+	} else if typ == typeDir {
+		prevdir, err = fs.openDir(newpath)
+		if err != nil {
+			return err
+		}
+		if len(prevdir.entries) != 0 || prevdir.split {
+			return ErrNotEmpty
+		}
+		fs.prepOrphans(1)
+	}
+
+	// Move the entry. If the entries are in different metadata pairs, the
+	// global state records the move until the old entry has been removed.
+	if !samePair {
+		fs.prepMove(uint32(oldid), oldcwd.pair)
+	}
+	var attrs []attr
+	if exists {
+		attrs = append(attrs, attr{tag: mkTag(typeDelete, uint32(newid), 0)})
+	}
+	attrs = append(attrs,
+		attr{tag: mkTag(typeCreate, uint32(newid), 0)},
+		attr{tag: mkTag(typ, uint32(newid), uint32(len(name))), buf: []byte(name)})
+	for _, a := range oldEntry.attrs {
+		if a.tag.type1() == typeName {
+			continue
+		}
+		a.tag = a.tag&^mkTag(0, 0x3ff, 0) | mkTag(0, uint32(newid), 0)
+		attrs = append(attrs, a)
+	}
+	if samePair {
+		attrs = append(attrs, attr{tag: mkTag(typeDelete, newoldid, 0)})
+	}
+	if err := fs.commit(newcwd, attrs); err != nil {
+		return err
+	}
+
+	if !samePair {
+		fs.prepMove(noID, [2]uint32{})
+		oldcwd, err = fs.fetch(oldcwd.pair)
+		if err != nil {
+			return err
+		}
+		if err := fs.commit(oldcwd, []attr{{tag: mkTag(typeDelete, uint32(oldid), 0)}}); err != nil {
+			return err
+		}
+	}
+
+	if prevdir != nil {
+		fs.prepOrphans(-1)
+		return fs.dropDir(prevdir.pair)
+	}
+	return nil
+}
+
+// Free returns the number of bytes that are not in use.
+func (fs *FS) Free() (int64, error) {
+	used := uint32(0)
+	err := fs.traverse(func(block uint32) {
+		used++
+	})
+	if err != nil {
+		return 0, err
+	}
+	if used > fs.blockCount {
+		used = fs.blockCount
+	}
+	return int64(fs.blockCount-used) * int64(fs.blockSize), nil
+}
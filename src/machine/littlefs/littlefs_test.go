@@ -0,0 +1,784 @@
+package littlefs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+var errPowerLoss = errors.New("power loss")
+
+// testFlash is a block device in RAM that behaves like NOR flash: writes can
+// only clear bits and an erased block reads as all 0xff bytes. It fails the
+// test if a write block is written twice without an erase. It can simulate a
+// power loss after a given number of writes and erases.
+type testFlash struct {
+	t              *testing.T
+	data           []byte
+	written        []bool // write blocks written since the last erase
+	writeBlockSize int64
+	eraseBlockSize int64
+	budget         int // operations left before a power loss, or -1
+}
+
+func newTestFlash(t *testing.T, size, writeBlockSize, eraseBlockSize int64) *testFlash {
+	f := &testFlash{
+		t:              t,
+		data:           make([]byte, size),
+		written:        make([]bool, size/writeBlockSize),
+		writeBlockSize: writeBlockSize,
+		eraseBlockSize: eraseBlockSize,
+		budget:         -1,
+	}
+	for i := range f.data {
+		f.data[i] = 0xff
+	}
+	return f
+}
+
+// powerLoss returns whether the power is lost before the next operation.
+func (f *testFlash) powerLoss() bool {
+	if f.budget == 0 {
+		return true
+	}
+	if f.budget > 0 {
+		f.budget--
+	}
+	return false
+}
+
+func (f *testFlash) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(f.data)) {
+		f.t.Fatalf("read out of range: %d bytes at %#x", len(p), off)
+	}
+	return copy(p, f.data[off:]), nil
+}
+
+func (f *testFlash) WriteAt(p []byte, off int64) (int, error) {
+	if off%f.writeBlockSize != 0 || int64(len(p))%f.writeBlockSize != 0 {
+		f.t.Fatalf("unaligned write: %d bytes at %#x", len(p), off)
+	}
+	if off < 0 || off+int64(len(p)) > int64(len(f.data)) {
+		f.t.Fatalf("write out of range: %d bytes at %#x", len(p), off)
+	}
+	for i := off / f.writeBlockSize; i < (off+int64(len(p)))/f.writeBlockSize; i++ {
+		if f.written[i] {
+			f.t.Fatalf("write block written twice: %d bytes at %#x", len(p), off)
+		}
+		f.written[i] = true
+	}
+	if f.powerLoss() {
+		// Only the first half of the data reaches the flash.
+		p = p[:len(p)/2]
+		for i, b := range p {
+			f.data[off+int64(i)] &= b
+		}
+		return 0, errPowerLoss
+	}
+	for i, b := range p {
+		f.data[off+int64(i)] &= b
+	}
+	return len(p), nil
+}
+
+func (f *testFlash) Size() int64 {
+	return int64(len(f.data))
+}
+
+func (f *testFlash) WriteBlockSize() int64 {
+	return f.writeBlockSize
+}
+
+func (f *testFlash) EraseBlockSize() int64 {
+	return f.eraseBlockSize
+}
+
+func (f *testFlash) EraseBlocks(start, count int64) error {
+	if (start+count)*f.eraseBlockSize > int64(len(f.data)) {
+		f.t.Fatalf("erase out of range: %d blocks at block %d", count, start)
+	}
+	for block := start; block < start+count; block++ {
+		if f.powerLoss() {
+			return errPowerLoss
+		}
+		for i := block * f.eraseBlockSize; i < (block+1)*f.eraseBlockSize; i++ {
+			f.data[i] = 0xff
+		}
+		for i := block * f.eraseBlockSize / f.writeBlockSize; i < (block+1)*f.eraseBlockSize/f.writeBlockSize; i++ {
+			f.written[i] = false
+		}
+	}
+	return nil
+}
+
+func format(t *testing.T, flash *testFlash) *FS {
+	t.Helper()
+	fs, err := Format(flash)
+	if err != nil {
+		t.Fatal("could not format:", err)
+	}
+	return fs
+}
+
+// mount mounts the filesystem again, as happens after a reset.
+func mount(t *testing.T, flash *testFlash) *FS {
+	t.Helper()
+	fs, err := Mount(flash)
+	if err != nil {
+		t.Fatal("could not mount:", err)
+	}
+	return fs
+}
+
+func writeFile(fs *FS, path string, data []byte) error {
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readFile(fs *FS, path string) ([]byte, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func checkFile(t *testing.T, fs *FS, path string, expected []byte) {
+	t.Helper()
+	data, err := readFile(fs, path)
+	if err != nil {
+		t.Fatalf("could not read %s: %v", path, err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("%s: read %d bytes that differ from the %d bytes written", path, len(data), len(expected))
+	}
+	info, err := fs.Stat(path)
+	if err != nil {
+		t.Fatalf("could not stat %s: %v", path, err)
+	}
+	if info.Size != int64(len(expected)) || info.IsDir {
+		t.Fatalf("%s: unexpected info %+v", path, info)
+	}
+}
+
+func listDir(t *testing.T, fs *FS, path string) []string {
+	t.Helper()
+	infos, err := fs.ReadDir(path)
+	if err != nil {
+		t.Fatalf("could not read directory %s: %v", path, err)
+	}
+	var names []string
+	for _, info := range infos {
+		name := info.Name
+		if info.IsDir {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func randomData(r *rand.Rand, n int) []byte {
+	data := make([]byte, n)
+	r.Read(data)
+	return data
+}
+
+func TestCRC(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	for _, c := range []uint32{0, 0xffffffff, 0x12345678} {
+		expected := ^crc32.Update(^c, crc32.IEEETable, data)
+		if got := crc(c, data); got != expected {
+			t.Errorf("crc(%#x): got %#x, expected %#x", c, got, expected)
+		}
+	}
+}
+
+func TestCTZIndex(t *testing.T) {
+	// Walk through a file byte by byte and check that every block starts
+	// with ctz(i)+1 pointers, except block 0.
+	fs := &FS{blockSize: 128}
+	index, off := uint32(0), uint32(0)
+	for pos := uint32(0); pos < 100000; pos++ {
+		if off == fs.blockSize {
+			index++
+			off = 4 * (uint32(bitsTrailingZeros(index)) + 1)
+		}
+		i, o := fs.ctzIndex(pos)
+		if i != index || o != off {
+			t.Fatalf("ctzIndex(%d) = %d, %d; expected %d, %d", pos, i, o, index, off)
+		}
+		off++
+	}
+}
+
+func bitsTrailingZeros(n uint32) int {
+	z := 0
+	for n&1 == 0 {
+		n >>= 1
+		z++
+	}
+	return z
+}
+
+// TestSuperblock checks the superblock that is written by Format against the
+// LittleFS specification.
+func TestSuperblock(t *testing.T) {
+	flash := newTestFlash(t, 64*512, 16, 512)
+	format(t, flash)
+
+	// After a format, the root pair has been compacted twice: block 1 has the
+	// most recent revision. A compaction doesn't write create tags.
+	block := flash.data[512:1024]
+	rev0 := getLE32(flash.data[0:])
+	rev1 := getLE32(block[0:])
+	if int32(rev1-rev0) <= 0 {
+		t.Fatalf("revision of block 1 (%d) is not newer than block 0 (%d)", rev1, rev0)
+	}
+
+	expected := []struct {
+		tag  uint32
+		data string
+	}{
+		{0x0ff00008, "littlefs"}, // superblock name
+		{0x20100018, "\x00\x00\x02\x00" + // version 2.0
+			"\x00\x02\x00\x00" + // block size
+			"\x40\x00\x00\x00" + // block count
+			"\xff\x00\x00\x00" + // name max
+			"\xff\xff\xff\x7f" + // file max
+			"\xfe\x03\x00\x00"}, // attr max
+	}
+	off := 4
+	ptag := uint32(0xffffffff)
+	for _, e := range expected {
+		tag := getBE32(block[off:]) ^ ptag
+		if tag != e.tag {
+			t.Fatalf("tag at %d: got %#08x, expected %#08x", off, tag, e.tag)
+		}
+		data := string(block[off+4 : off+4+len(e.data)])
+		if data != e.data {
+			t.Fatalf("data of tag %#08x: got %q, expected %q", tag, data, e.data)
+		}
+		ptag = tag
+		off += 4 + len(e.data)
+	}
+
+	// The commit ends with a CRC tag, over the revision count and all tags.
+	tag := getBE32(block[off:]) ^ ptag
+	if tag&0xfff00000 != 0x50000000 && tag&0xfff00000 != 0x50100000 || tag&0xffc00 != 0xffc00 {
+		t.Fatalf("tag at %d: got %#08x, expected a CRC tag", off, tag)
+	}
+	expectedCRC := ^crc32.ChecksumIEEE(block[:off+4])
+	if got := getLE32(block[off+4:]); got != expectedCRC {
+		t.Fatalf("CRC: got %#08x, expected %#08x", got, expectedCRC)
+	}
+	if end := off + 4 + int(tag&0x3ff); end%16 != 0 {
+		t.Fatalf("commit ends at %d, which is not aligned to the write block size", end)
+	}
+}
+
+// imageBuilder writes a metadata block as described in the LittleFS
+// specification, independently of the code in this package.
+type imageBuilder struct {
+	buf   []byte
+	start int // start of the commit, for the CRC
+	ptag  uint32
+}
+
+func newImageBuilder(rev uint32) *imageBuilder {
+	b := &imageBuilder{ptag: 0xffffffff}
+	b.buf = append(b.buf, byte(rev), byte(rev>>8), byte(rev>>16), byte(rev>>24))
+	return b
+}
+
+func (b *imageBuilder) tag(typ, id uint32, data []byte) {
+	tag := typ<<20 | id<<10 | uint32(len(data))
+	x := tag ^ b.ptag
+	b.buf = append(b.buf, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+	b.buf = append(b.buf, data...)
+	b.ptag = tag
+}
+
+// commit ends a commit, padded to the given alignment.
+func (b *imageBuilder) commit(align int) {
+	start := len(b.buf)
+	end := (start + 8 + align - 1) / align * align
+	tag := uint32(0x500)<<20 | 0x3ff<<10 | uint32(end-start-4)
+	x := tag ^ b.ptag
+	b.buf = append(b.buf, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+	c := ^crc32.ChecksumIEEE(b.buf[b.start:])
+	b.buf = append(b.buf, byte(c), byte(c>>8), byte(c>>16), byte(c>>24))
+	for len(b.buf) < end {
+		b.buf = append(b.buf, 0xff)
+	}
+	b.start = end
+	// The next tag is XORed with the CRC tag. The next word is erased, so
+	// the low bit of the CRC type is 0 and the valid bit isn't inverted.
+	b.ptag = tag
+}
+
+func le32(v uint32) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+}
+
+// TestMountImage mounts a filesystem built by hand from the specification,
+// with a directory, an inline file and a file in a single block, spread over
+// two commits.
+func TestMountImage(t *testing.T) {
+	const blockSize = 256
+	flash := newTestFlash(t, 16*blockSize, 16, blockSize)
+
+	// Blocks 0 and 1: the superblock and root directory. Block 0 has an
+	// older revision with garbage.
+	root := newImageBuilder(7)
+	sb := append(le32(0x00020000), le32(blockSize)...)
+	sb = append(sb, le32(16)...)
+	sb = append(sb, le32(255)...)
+	sb = append(sb, le32(0x7fffffff)...)
+	sb = append(sb, le32(0x3fe)...)
+	root.tag(0x401, 0, nil)
+	root.tag(0x0ff, 0, []byte("littlefs"))
+	root.tag(0x201, 0, sb)
+	root.tag(0x401, 1, nil)
+	root.tag(0x001, 1, []byte("hello.txt"))
+	root.tag(0x201, 1, []byte("Hello, world!"))
+	root.commit(16)
+	root.tag(0x401, 2, nil)
+	root.tag(0x002, 2, []byte("dir"))
+	root.tag(0x200, 2, append(le32(2), le32(3)...))
+	root.tag(0x600, 0x3ff, append(le32(2), le32(3)...))
+	root.commit(16)
+	copy(flash.data[blockSize:], root.buf)
+	copy(flash.data, le32(6))
+	copy(flash.data[4:], "garbage")
+
+	// Blocks 2 and 3: the directory, with a file in block 4.
+	content := bytes.Repeat([]byte("0123456789abcdef"), 10)
+	dir := newImageBuilder(1)
+	dir.tag(0x401, 0, nil)
+	dir.tag(0x001, 0, []byte("data.bin"))
+	dir.tag(0x202, 0, append(le32(4), le32(uint32(len(content)))...))
+	dir.commit(16)
+	copy(flash.data[2*blockSize:], dir.buf)
+	copy(flash.data[4*blockSize:], content)
+
+	fs := mount(t, flash)
+	checkFile(t, fs, "hello.txt", []byte("Hello, world!"))
+	checkFile(t, fs, "/dir/data.bin", content)
+	if names := listDir(t, fs, "/"); fmt.Sprint(names) != "[dir/ hello.txt]" {
+		t.Errorf("unexpected root directory: %v", names)
+	}
+
+	// The filesystem can be modified, and the allocator must not reuse the
+	// blocks that are in use.
+	data := randomData(rand.New(rand.NewSource(1)), 2000)
+	if err := writeFile(fs, "dir/new.bin", data); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+	fs = mount(t, flash)
+	checkFile(t, fs, "hello.txt", []byte("Hello, world!"))
+	checkFile(t, fs, "dir/data.bin", content)
+	checkFile(t, fs, "dir/new.bin", data)
+}
+
+func TestReadWrite(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, geometry := range []struct{ writeBlockSize, eraseBlockSize int64 }{
+		{1, 128},
+		{4, 512},
+		{16, 4096},
+		{256, 4096},
+	} {
+		t.Run(fmt.Sprintf("%d-%d", geometry.writeBlockSize, geometry.eraseBlockSize), func(t *testing.T) {
+			flash := newTestFlash(t, 256*1024, geometry.writeBlockSize, geometry.eraseBlockSize)
+			fs := format(t, flash)
+			files := map[string][]byte{}
+			for _, size := range []int{0, 1, 10, 64, 100, 127, 128, 129, 1000, 4095, 4096, 4097, 20000, 60000} {
+				name := fmt.Sprintf("file%d", size)
+				files[name] = randomData(r, size)
+				if err := writeFile(fs, name, files[name]); err != nil {
+					t.Fatalf("could not write %s: %v", name, err)
+				}
+			}
+			for name, data := range files {
+				checkFile(t, fs, name, data)
+			}
+			fs = mount(t, flash)
+			for name, data := range files {
+				checkFile(t, fs, name, data)
+			}
+
+			// Overwrite the files with different sizes.
+			for name := range files {
+				files[name] = randomData(r, r.Intn(10000))
+				if err := writeFile(fs, name, files[name]); err != nil {
+					t.Fatalf("could not write %s: %v", name, err)
+				}
+			}
+			fs = mount(t, flash)
+			for name, data := range files {
+				checkFile(t, fs, name, data)
+			}
+		})
+	}
+}
+
+func TestSeek(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	flash := newTestFlash(t, 128*1024, 16, 512)
+	fs := format(t, flash)
+	for _, size := range []int{20, 3000} {
+		name := fmt.Sprintf("file%d", size)
+		data := randomData(r, size)
+		if err := writeFile(fs, name, data); err != nil {
+			t.Fatal("could not write file:", err)
+		}
+
+		// Modify the file in place at a few positions, including past the
+		// end of the file, which fills the gap with zeros.
+		f, err := fs.OpenFile(name, O_RDWR)
+		if err != nil {
+			t.Fatal("could not open file:", err)
+		}
+		for _, pos := range []int{size / 2, 0, size - 1, size + 10} {
+			patch := randomData(r, 7)
+			if _, err := f.Seek(int64(pos), io.SeekStart); err != nil {
+				t.Fatal("could not seek:", err)
+			}
+			if _, err := f.Write(patch); err != nil {
+				t.Fatal("could not write:", err)
+			}
+			for len(data) < pos {
+				data = append(data, 0)
+			}
+			for i, b := range patch {
+				if pos+i < len(data) {
+					data[pos+i] = b
+				} else {
+					data = append(data, b)
+				}
+			}
+			if f.Size() != int64(len(data)) {
+				t.Fatalf("size: got %d, expected %d", f.Size(), len(data))
+			}
+
+			// Read back part of the file through the same handle.
+			if _, err := f.Seek(int64(pos/2), io.SeekStart); err != nil {
+				t.Fatal("could not seek:", err)
+			}
+			buf := make([]byte, pos-pos/2+7)
+			if _, err := io.ReadFull(f, buf); err != nil {
+				t.Fatal("could not read:", err)
+			}
+			if !bytes.Equal(buf, data[pos/2:pos+7]) {
+				t.Fatalf("read after write at %d returned wrong data", pos)
+			}
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal("could not close:", err)
+		}
+		checkFile(t, fs, name, data)
+		fs = mount(t, flash)
+		checkFile(t, fs, name, data)
+
+		// Append.
+		f, err = fs.OpenFile(name, O_WRONLY|O_APPEND)
+		if err != nil {
+			t.Fatal("could not open file:", err)
+		}
+		extra := randomData(r, 1000)
+		f.Write(extra[:500])
+		f.Write(extra[500:])
+		if err := f.Close(); err != nil {
+			t.Fatal("could not close:", err)
+		}
+		checkFile(t, fs, name, append(data, extra...))
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	flash := newTestFlash(t, 128*1024, 16, 512)
+	fs := format(t, flash)
+	for _, path := range []string{"a", "a/b", "a/b/c", "d"} {
+		if err := fs.Mkdir(path); err != nil {
+			t.Fatalf("could not create directory %s: %v", path, err)
+		}
+	}
+	if err := fs.Mkdir("a/b"); err != ErrExist {
+		t.Errorf("mkdir of existing directory: got %v", err)
+	}
+	if err := fs.Mkdir("x/y"); err != ErrNotExist {
+		t.Errorf("mkdir in missing directory: got %v", err)
+	}
+	for _, path := range []string{"a/1", "a/b/2", "a/b/c/3", "d/4", "5"} {
+		if err := writeFile(fs, path, []byte(path)); err != nil {
+			t.Fatalf("could not write %s: %v", path, err)
+		}
+	}
+	if _, err := fs.Open("5/x"); err != ErrNotDir {
+		t.Errorf("open through a file: got %v", err)
+	}
+	if _, err := fs.Open("a"); err != ErrIsDir {
+		t.Errorf("open of a directory: got %v", err)
+	}
+
+	fs = mount(t, flash)
+	for path, expected := range map[string]string{
+		"/":     "[5 a/ d/]",
+		"a":     "[1 b/]",
+		"a/b":   "[2 c/]",
+		"a/b/c": "[3]",
+		"./d/":  "[4]",
+	} {
+		if names := listDir(t, fs, path); fmt.Sprint(names) != expected {
+			t.Errorf("directory %s: got %v, expected %s", path, names, expected)
+		}
+	}
+	checkFile(t, fs, "a/b/../b/c/./3", []byte("a/b/c/3"))
+
+	if err := fs.Remove("a/b"); err != ErrNotEmpty {
+		t.Errorf("remove of non-empty directory: got %v", err)
+	}
+	for _, path := range []string{"a/b/c/3", "a/b/c", "a/b/2", "a/b"} {
+		if err := fs.Remove(path); err != nil {
+			t.Fatalf("could not remove %s: %v", path, err)
+		}
+	}
+	if _, err := fs.Stat("a/b"); err != ErrNotExist {
+		t.Errorf("stat of removed directory: got %v", err)
+	}
+	fs = mount(t, flash)
+	if names := listDir(t, fs, "a"); fmt.Sprint(names) != "[1]" {
+		t.Errorf("directory a: got %v", names)
+	}
+	checkFile(t, fs, "d/4", []byte("d/4"))
+}
+
+func TestRename(t *testing.T) {
+	flash := newTestFlash(t, 128*1024, 16, 512)
+	fs := format(t, flash)
+	fs.Mkdir("dir")
+	fs.Mkdir("empty")
+	writeFile(fs, "a", []byte("a"))
+	writeFile(fs, "b", bytes.Repeat([]byte("b"), 3000))
+	writeFile(fs, "dir/c", []byte("c"))
+
+	steps := []struct {
+		oldpath, newpath string
+		err              error
+	}{
+		{"a", "a2", nil},     // same directory
+		{"b", "dir/b", nil},  // different directory
+		{"dir/c", "a2", nil}, // replace a file
+		{"dir", "dir/sub", ErrInvalid},
+		{"dir", "empty", nil},     // replace an empty directory
+		{"a2", "empty", ErrIsDir}, // file over directory
+		{"missing", "x", ErrNotExist},
+	}
+	for _, step := range steps {
+		if err := fs.Rename(step.oldpath, step.newpath); err != step.err {
+			t.Fatalf("rename %s to %s: got %v, expected %v", step.oldpath, step.newpath, err, step.err)
+		}
+	}
+	fs = mount(t, flash)
+	if names := listDir(t, fs, "/"); fmt.Sprint(names) != "[a2 empty/]" {
+		t.Errorf("root directory: got %v", names)
+	}
+	if names := listDir(t, fs, "empty"); fmt.Sprint(names) != "[b]" {
+		t.Errorf("directory: got %v", names)
+	}
+	checkFile(t, fs, "a2", []byte("c"))
+	checkFile(t, fs, "empty/b", bytes.Repeat([]byte("b"), 3000))
+}
+
+// TestManyFiles creates enough files that the metadata pairs of the root
+// directory are split, while files are open, and then removes them all.
+func TestManyFiles(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	flash := newTestFlash(t, 512*1024, 16, 512)
+	fs := format(t, flash)
+	free, err := fs.Free()
+	if err != nil {
+		t.Fatal("could not get free space:", err)
+	}
+
+	// Keep a file open while other files are created.
+	open, err := fs.Create("open")
+	if err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	openData := randomData(r, 1500)
+
+	files := map[string][]byte{}
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("file-with-a-long-name-%d", i)
+		files[name] = randomData(r, r.Intn(100))
+		if err := writeFile(fs, name, files[name]); err != nil {
+			t.Fatalf("could not write %s: %v", name, err)
+		}
+		if i%20 == 0 {
+			open.Write(openData[i/20*100 : i/20*100+100])
+		}
+	}
+	if err := open.Close(); err != nil {
+		t.Fatal("could not close file:", err)
+	}
+	files["open"] = openData[:1000]
+
+	fs = mount(t, flash)
+	for name, data := range files {
+		checkFile(t, fs, name, data)
+	}
+	if n := len(listDir(t, fs, "/")); n != len(files) {
+		t.Errorf("root directory has %d entries, expected %d", n, len(files))
+	}
+	for name := range files {
+		if err := fs.Remove(name); err != nil {
+			t.Fatalf("could not remove %s: %v", name, err)
+		}
+	}
+	fs = mount(t, flash)
+	if names := listDir(t, fs, "/"); len(names) != 0 {
+		t.Errorf("root directory is not empty: %v", names)
+	}
+	if f, err := fs.Free(); err != nil || f != free {
+		t.Errorf("free space after removing all files: %d (%v), expected %d", f, err, free)
+	}
+}
+
+func TestNoSpace(t *testing.T) {
+	flash := newTestFlash(t, 32*512, 16, 512)
+	fs := format(t, flash)
+	writeFile(fs, "keep", []byte("keep"))
+	data := make([]byte, 32*512)
+	if err := writeFile(fs, "big", data); err != ErrNoSpace {
+		t.Fatalf("writing a file that is too big: got %v", err)
+	}
+	fs = mount(t, flash)
+	checkFile(t, fs, "keep", []byte("keep"))
+
+	// The space can still be used.
+	data = data[:8*512]
+	if err := writeFile(fs, "big", data); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+	checkFile(t, fs, "big", data)
+}
+
+// TestPowerLoss interrupts a sequence of operations at every possible write
+// or erase, and checks that the filesystem can be mounted and contains either
+// the old or the new version of every file.
+func TestPowerLoss(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	oldA := randomData(r, 30)
+	newA := randomData(r, 40)
+	oldB := randomData(r, 2000)
+	newB := randomData(r, 2500)
+
+	operations := func(fs *FS) error {
+		if err := writeFile(fs, "dir/a", newA); err != nil {
+			return err
+		}
+		if err := writeFile(fs, "b", newB); err != nil {
+			return err
+		}
+		if err := fs.Rename("b", "dir/c"); err != nil {
+			return err
+		}
+		if err := fs.Mkdir("new"); err != nil {
+			return err
+		}
+		return fs.Remove("old")
+	}
+
+	for budget := 0; ; budget++ {
+		flash := newTestFlash(t, 64*512, 16, 512)
+		fs := format(t, flash)
+		fs.Mkdir("dir")
+		fs.Mkdir("old")
+		writeFile(fs, "dir/a", oldA)
+		writeFile(fs, "b", oldB)
+
+		flash.budget = budget
+		err := operations(fs)
+		flash.budget = -1
+		if err == nil {
+			if budget == 0 {
+				t.Fatal("operations did not write anything")
+			}
+			break
+		}
+		if err != errPowerLoss {
+			t.Fatalf("budget %d: unexpected error: %v", budget, err)
+		}
+
+		fs, err = Mount(flash)
+		if err != nil {
+			t.Fatalf("budget %d: could not mount: %v", budget, err)
+		}
+		a, err := readFile(fs, "dir/a")
+		if err != nil || !bytes.Equal(a, oldA) && !bytes.Equal(a, newA) {
+			t.Fatalf("budget %d: dir/a is corrupt (%v)", budget, err)
+		}
+		b, errB := readFile(fs, "b")
+		c, errC := readFile(fs, "dir/c")
+		switch {
+		case errB == nil && errC == ErrNotExist:
+			if !bytes.Equal(b, oldB) && !bytes.Equal(b, newB) {
+				t.Fatalf("budget %d: b is corrupt", budget)
+			}
+		case errB == ErrNotExist && errC == nil:
+			if !bytes.Equal(c, newB) {
+				t.Fatalf("budget %d: dir/c is corrupt", budget)
+			}
+		default:
+			t.Fatalf("budget %d: unexpected state after rename: %v, %v", budget, errB, errC)
+		}
+
+		// The filesystem must still be usable, and the interrupted
+		// operations can be done again.
+		if err := writeFile(fs, "dir/a", newA); err != nil {
+			t.Fatalf("budget %d: could not write after power loss: %v", budget, err)
+		}
+		if errB == nil {
+			if err := writeFile(fs, "b", newB); err != nil {
+				t.Fatalf("budget %d: could not write after power loss: %v", budget, err)
+			}
+			if err := fs.Rename("b", "dir/c"); err != nil {
+				t.Fatalf("budget %d: could not rename after power loss: %v", budget, err)
+			}
+		}
+		if _, err := fs.Stat("new"); err == ErrNotExist {
+			if err := fs.Mkdir("new"); err != nil {
+				t.Fatalf("budget %d: could not create directory after power loss: %v", budget, err)
+			}
+		}
+		if _, err := fs.Stat("old"); err == nil {
+			if err := fs.Remove("old"); err != nil {
+				t.Fatalf("budget %d: could not remove directory after power loss: %v", budget, err)
+			}
+		}
+		fs = mount(t, flash)
+		checkFile(t, fs, "dir/a", newA)
+		checkFile(t, fs, "dir/c", newB)
+		if names := listDir(t, fs, "/"); fmt.Sprint(names) != "[dir/ new/]" {
+			t.Fatalf("budget %d: root directory: got %v", budget, names)
+		}
+	}
+}
@@ -34,3 +34,70 @@ type PWM struct {
 type ADC struct {
 	Pin Pin
 }
+
+// DAC is a digital-to-analog converter, outputting an analog voltage on the
+// given pin. Only a few chips have a DAC and usually only on a few specific
+// pins.
+type DAC struct {
+	Pin Pin
+}
+
+// DACConfig is used to configure a DAC. It is currently empty but may be
+// extended in the future.
+type DACConfig struct {
+}
+
+// PinCapabilities describes the electrical capabilities of a pin, as a set of
+// flags. It can be used by drivers to refuse configurations that would damage
+// the chip and by tooling to document the wiring of a board.
+//
+// A capability is only reported when it is known to be present, so a missing
+// flag is the safe assumption: for example, a pin that doesn't report
+// PinFiveVoltTolerant must be treated as a 3.3V pin.
+type PinCapabilities uint8
+
+const (
+	// The pin can take 5V on its input without damage, even though the chip
+	// runs at a lower voltage.
+	PinFiveVoltTolerant PinCapabilities = 1 << iota
+
+	// The pin can be used as an ADC input.
+	PinAnalogCapable
+
+	// The pin can be configured to drive a higher current than usual, for
+	// example to drive a LED directly.
+	PinHighDrive
+)
+
+// Has returns whether all the given capabilities are present.
+func (c PinCapabilities) Has(capabilities PinCapabilities) bool {
+	return c&capabilities == capabilities
+}
+
+// String returns a human readable list of capabilities, like "5V,analog".
+func (c PinCapabilities) String() string {
+	s := ""
+	if c.Has(PinFiveVoltTolerant) {
+		s += ",5V"
+	}
+	if c.Has(PinAnalogCapable) {
+		s += ",analog"
+	}
+	if c.Has(PinHighDrive) {
+		s += ",high-drive"
+	}
+	if s == "" {
+		return "none"
+	}
+	return s[1:]
+}
+
+// IsFiveVoltTolerant returns whether this pin can take 5V on its input.
+func (p Pin) IsFiveVoltTolerant() bool {
+	return p.Capabilities().Has(PinFiveVoltTolerant)
+}
+
+// IsAnalogCapable returns whether this pin can be used as an ADC input.
+func (p Pin) IsAnalogCapable() bool {
+	return p.Capabilities().Has(PinAnalogCapable)
+}
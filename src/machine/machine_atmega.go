@@ -257,3 +257,59 @@ func handleUSART_RX() {
 		UART0.Receive(byte(data))
 	}
 }
+
+// Callbacks for the INT0 and INT1 external interrupts.
+var pinCallbacks [2]func(Pin)
+
+// SetInterrupt sets a callback to be called from an interrupt when the pin
+// changes. Pass a nil callback to remove the interrupt again. Only the
+// external interrupt pins INT0 (PD2) and INT1 (PD3) are supported.
+func (p Pin) SetInterrupt(change PinChange, callback func(Pin)) error {
+	var num uint8
+	switch p {
+	case 2:
+		num = 0
+	case 3:
+		num = 1
+	default:
+		return ErrNoPinChangeChannel
+	}
+
+	if callback == nil {
+		avr.EIMSK.ClearBits(1 << num)
+		pinCallbacks[num] = nil
+		return nil
+	}
+	pinCallbacks[num] = callback
+
+	// Interrupt sense control: 01 for any change, 10 for the falling edge and
+	// 11 for the rising edge.
+	var sense uint8
+	switch change {
+	case PinRising:
+		sense = 3
+	case PinFalling:
+		sense = 2
+	default:
+		sense = 1
+	}
+	pos := num * 2
+	avr.EICRA.Set(avr.EICRA.Get()&^(3<<pos) | sense<<pos)
+	avr.EIFR.Set(1 << num) // clear pending interrupt (write 1 to clear)
+	avr.EIMSK.SetBits(1 << num)
+	return nil
+}
+
+//go:interrupt INT0_vect
+func handleINT0() {
+	if callback := pinCallbacks[0]; callback != nil {
+		callback(2)
+	}
+}
+
+//go:interrupt INT1_vect
+func handleINT1() {
+	if callback := pinCallbacks[1]; callback != nil {
+		callback(3)
+	}
+}
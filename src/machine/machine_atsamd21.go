@@ -13,6 +13,7 @@ import (
 	"device/sam"
 	"encoding/binary"
 	"errors"
+	"runtime/volatile"
 	"unsafe"
 )
 
@@ -246,6 +247,48 @@ func waitADCSync() {
 	}
 }
 
+// Configure the DAC. The SAMD21 has a single 10-bit DAC, which is always
+// connected to pin PA02.
+func (dac DAC) Configure(config DACConfig) {
+	if dac.Pin != PA02 {
+		return // no DAC on this pin
+	}
+	dac.Pin.Configure(PinConfig{Mode: PinAnalog})
+
+	// Turn on clock for DAC
+	sam.PM.APBCMASK.SetBits(sam.PM_APBCMASK_DAC_)
+
+	// Use Generic Clock Generator 0 as source for DAC.
+	sam.GCLK.CLKCTRL.Set((sam.GCLK_CLKCTRL_ID_DAC << sam.GCLK_CLKCTRL_ID_Pos) |
+		(sam.GCLK_CLKCTRL_GEN_GCLK0 << sam.GCLK_CLKCTRL_GEN_Pos) |
+		sam.GCLK_CLKCTRL_CLKEN)
+	waitForSync()
+
+	// reset DAC
+	sam.DAC.CTRLA.Set(sam.DAC_CTRLA_SWRST)
+	waitDACSync()
+	for sam.DAC.CTRLA.HasBits(sam.DAC_CTRLA_SWRST) {
+	}
+
+	// Enable the external output, using the analog supply voltage as
+	// reference.
+	sam.DAC.CTRLB.Set(sam.DAC_CTRLB_EOEN | (sam.DAC_CTRLB_REFSEL_AVCC << sam.DAC_CTRLB_REFSEL_Pos))
+	sam.DAC.CTRLA.Set(sam.DAC_CTRLA_ENABLE)
+	waitDACSync()
+}
+
+// Set the output of the DAC to the given value, in the range 0..0xffff. The
+// DAC has a resolution of 10 bits so the lower 6 bits are ignored.
+func (dac DAC) Set(value uint16) {
+	sam.DAC.DATA.Set(value >> 6)
+	waitDACSync()
+}
+
+func waitDACSync() {
+	for sam.DAC.STATUS.HasBits(sam.DAC_STATUS_SYNCBUSY) {
+	}
+}
+
 // UART on the SAMD21.
 type UART struct {
 	Buffer *RingBuffer
@@ -2076,3 +2119,372 @@ func setEPINTENSET(ep uint32, val uint8) {
 		return
 	}
 }
+
+// Timer is a hardware timer that calls a callback at a fixed frequency, from
+// the timer interrupt. It uses one of the TC peripherals in 16-bit mode.
+type Timer struct {
+	Bus   *sam.TC_COUNT16_Type
+	index uint8
+}
+
+// TC3 is shared with TCC2 for the clock, which is used for PWM, but that
+// doesn't matter as both run from the same generic clock.
+var (
+	Timer3 = Timer{Bus: sam.TC3_COUNT16, index: 0}
+	Timer4 = Timer{Bus: sam.TC4_COUNT16, index: 1}
+	Timer5 = Timer{Bus: sam.TC5_COUNT16, index: 2}
+)
+
+var timerCallbacks [3]func()
+
+// Configure sets the frequency of the timer. The timer runs from the 48MHz
+// main clock with a prescaler and a 16-bit counter, so frequencies from 1Hz up
+// to a few MHz are supported.
+func (t Timer) Configure(config TimerConfig) error {
+	if config.Frequency == 0 {
+		return ErrTimerFrequency
+	}
+
+	// Find the smallest prescaler (giving the highest precision) for which
+	// the compare value fits in 16 bits.
+	var prescaler, compare uint32
+	for prescaler = 0; prescaler < 8; prescaler++ {
+		shift := prescaler
+		if prescaler > 4 {
+			shift = prescaler*2 - 4 // DIV64, DIV256, DIV1024
+		}
+		compare = (CPU_FREQUENCY >> shift) / config.Frequency
+		if compare <= 0xffff+1 {
+			break
+		}
+	}
+	if compare == 0 || compare > 0xffff+1 {
+		return ErrTimerFrequency
+	}
+
+	// Turn on the clock and use Generic Clock Generator 0 as source.
+	var clockID uint32
+	switch t.index {
+	case 0:
+		sam.PM.APBCMASK.SetBits(sam.PM_APBCMASK_TC3_)
+		clockID = sam.GCLK_CLKCTRL_ID_TCC2_TC3
+	case 1:
+		sam.PM.APBCMASK.SetBits(sam.PM_APBCMASK_TC4_)
+		clockID = sam.GCLK_CLKCTRL_ID_TC4_TC5
+	case 2:
+		sam.PM.APBCMASK.SetBits(sam.PM_APBCMASK_TC5_)
+		clockID = sam.GCLK_CLKCTRL_ID_TC4_TC5
+	}
+	sam.GCLK.CLKCTRL.Set((clockID << sam.GCLK_CLKCTRL_ID_Pos) |
+		(sam.GCLK_CLKCTRL_GEN_GCLK0 << sam.GCLK_CLKCTRL_GEN_Pos) |
+		sam.GCLK_CLKCTRL_CLKEN)
+	waitForSync()
+
+	// Disable the timer before changing the configuration.
+	t.Bus.CTRLA.ClearBits(sam.TC_COUNT16_CTRLA_ENABLE)
+	t.waitSync()
+
+	// Count up to CC0 and then restart from zero (match frequency mode).
+	t.Bus.CTRLA.Set((sam.TC_COUNT16_CTRLA_MODE_COUNT16 << sam.TC_COUNT16_CTRLA_MODE_Pos) |
+		(sam.TC_COUNT16_CTRLA_WAVEGEN_MFRQ << sam.TC_COUNT16_CTRLA_WAVEGEN_Pos) |
+		(prescaler << sam.TC_COUNT16_CTRLA_PRESCALER_Pos))
+	t.waitSync()
+	t.Bus.CC[0].Set(uint16(compare - 1))
+	t.waitSync()
+	return nil
+}
+
+// Start calls the callback at the configured frequency until the timer is
+// stopped. The callback is called from an interrupt, so it must be short and
+// must not block.
+func (t Timer) Start(callback func()) {
+	timerCallbacks[t.index] = callback
+	t.Bus.INTFLAG.Set(sam.TC_COUNT16_INTFLAG_MC0)
+	t.Bus.INTENSET.Set(sam.TC_COUNT16_INTENSET_MC0)
+	irq := t.irq()
+	arm.SetPriority(irq, 0xc0) // low priority
+	arm.EnableIRQ(irq)
+	t.Bus.CTRLA.SetBits(sam.TC_COUNT16_CTRLA_ENABLE)
+	t.waitSync()
+}
+
+// Stop the timer. The callback won't be called anymore after this call.
+func (t Timer) Stop() {
+	t.Bus.CTRLA.ClearBits(sam.TC_COUNT16_CTRLA_ENABLE)
+	t.waitSync()
+	t.Bus.INTENCLR.Set(sam.TC_COUNT16_INTENSET_MC0)
+	arm.DisableIRQ(t.irq())
+	timerCallbacks[t.index] = nil
+}
+
+func (t Timer) irq() uint32 {
+	switch t.index {
+	case 0:
+		return sam.IRQ_TC3
+	case 1:
+		return sam.IRQ_TC4
+	default:
+		return sam.IRQ_TC5
+	}
+}
+
+func (t Timer) waitSync() {
+	for t.Bus.STATUS.HasBits(sam.TC_COUNT16_STATUS_SYNCBUSY) {
+	}
+}
+
+func (t Timer) handleInterrupt() {
+	if t.Bus.INTFLAG.HasBits(sam.TC_COUNT16_INTFLAG_MC0) {
+		t.Bus.INTFLAG.Set(sam.TC_COUNT16_INTFLAG_MC0)
+		if callback := timerCallbacks[t.index]; callback != nil {
+			callback()
+		}
+	}
+}
+
+//go:export TC3_IRQHandler
+func handleTC3() {
+	Timer3.handleInterrupt()
+}
+
+//go:export TC4_IRQHandler
+func handleTC4() {
+	Timer4.handleInterrupt()
+}
+
+//go:export TC5_IRQHandler
+func handleTC5() {
+	Timer5.handleInterrupt()
+}
+
+// Configure sets the timeout of the watchdog. The watchdog runs from a 1024Hz
+// clock derived from the internal ultra low power oscillator and supports
+// timeouts from 8ms up to 16s, rounded up to the next power of two.
+func (wd watchdogImpl) Configure(config WatchdogConfig) error {
+	cycles := uint64(config.TimeoutMillis) * 1024 / 1000
+	var period uint8
+	for period = 0; period <= 11; period++ {
+		if 8<<period >= cycles {
+			break
+		}
+	}
+	if period > 11 {
+		return ErrWatchdogTimeout
+	}
+
+	// Use Generic Clock Generator 5 running at 1024Hz (32.768kHz / 32) from
+	// the ultra low power oscillator, which is always running.
+	sam.GCLK.GENDIV.Set((5 << sam.GCLK_GENDIV_ID_Pos) |
+		(32 << sam.GCLK_GENDIV_DIV_Pos))
+	waitForSync()
+	sam.GCLK.GENCTRL.Set((5 << sam.GCLK_GENCTRL_ID_Pos) |
+		(sam.GCLK_GENCTRL_SRC_OSCULP32K << sam.GCLK_GENCTRL_SRC_Pos) |
+		sam.GCLK_GENCTRL_GENEN)
+	waitForSync()
+	sam.GCLK.CLKCTRL.Set((sam.GCLK_CLKCTRL_ID_WDT << sam.GCLK_CLKCTRL_ID_Pos) |
+		(sam.GCLK_CLKCTRL_GEN_GCLK5 << sam.GCLK_CLKCTRL_GEN_Pos) |
+		sam.GCLK_CLKCTRL_CLKEN)
+	waitForSync()
+
+	// The watchdog must be disabled while it is configured.
+	sam.WDT.CTRL.ClearBits(sam.WDT_CTRL_ENABLE)
+	waitWDTSync()
+	sam.WDT.CONFIG.Set(period << sam.WDT_CONFIG_PER_Pos)
+	waitWDTSync()
+	return nil
+}
+
+// Start the watchdog.
+func (wd watchdogImpl) Start() {
+	sam.WDT.CTRL.SetBits(sam.WDT_CTRL_ENABLE)
+	waitWDTSync()
+}
+
+// Feed the watchdog, restarting the timeout.
+func (wd watchdogImpl) Feed() {
+	sam.WDT.CLEAR.Set(sam.WDT_CLEAR_CLEAR_KEY)
+	waitWDTSync()
+}
+
+// CausedReset returns whether the last reset was caused by the watchdog.
+func (wd watchdogImpl) CausedReset() bool {
+	return sam.PM.RCAUSE.HasBits(sam.PM_RCAUSE_WDT)
+}
+
+func waitWDTSync() {
+	for sam.WDT.STATUS.HasBits(sam.WDT_STATUS_SYNCBUSY) {
+	}
+}
+
+// prepareDeepSleep configures the chip for deep sleep (standby mode). The RTC
+// used by the scheduler is clocked from the 32kHz oscillator through Generic
+// Clock Generator 2 (see initRTC in the runtime), which both need to be kept
+// running in standby mode.
+func prepareDeepSleep() bool {
+	sam.SYSCTRL.OSC32K.SetBits(sam.SYSCTRL_OSC32K_RUNSTDBY)
+	sam.GCLK.GENCTRL.Set((2 << sam.GCLK_GENCTRL_ID_Pos) |
+		(sam.GCLK_GENCTRL_SRC_OSC32K << sam.GCLK_GENCTRL_SRC_Pos) |
+		sam.GCLK_GENCTRL_GENEN |
+		sam.GCLK_GENCTRL_RUNSTDBY)
+	waitForSync()
+	return true
+}
+
+// Callbacks and pins for each of the 16 external interrupt lines of the EIC.
+var (
+	pinCallbacks     [16]func(Pin)
+	pinInterruptPins [16]Pin
+)
+
+// SetInterrupt sets a callback to be called from an interrupt when the pin
+// changes. Pass a nil callback to remove the interrupt again. Every pin is
+// connected to external interrupt line (pin number % 16), so two pins with
+// the same line cannot both have an interrupt at the same time. PA08 is
+// connected to the NMI instead and cannot be used.
+func (p Pin) SetInterrupt(change PinChange, callback func(Pin)) error {
+	if p == PA08 {
+		return ErrNoPinChangeChannel
+	}
+	extint := uint8(p) % 16
+
+	if callback == nil {
+		if pinCallbacks[extint] != nil && pinInterruptPins[extint] == p {
+			sam.EIC.INTENCLR.Set(1 << extint)
+			pinCallbacks[extint] = nil
+		}
+		return nil
+	}
+	if pinCallbacks[extint] != nil && pinInterruptPins[extint] != p {
+		return ErrNoPinChangeChannel
+	}
+	pinCallbacks[extint] = callback
+	pinInterruptPins[extint] = p
+
+	// Turn on the clock and use Generic Clock Generator 0 as source.
+	sam.PM.APBAMASK.SetBits(sam.PM_APBAMASK_EIC_)
+	sam.GCLK.CLKCTRL.Set((sam.GCLK_CLKCTRL_ID_EIC << sam.GCLK_CLKCTRL_ID_Pos) |
+		(sam.GCLK_CLKCTRL_GEN_GCLK0 << sam.GCLK_CLKCTRL_GEN_Pos) |
+		sam.GCLK_CLKCTRL_CLKEN)
+	waitForSync()
+
+	// The EIC must be disabled while the sense configuration is changed. The
+	// sense values (rise, fall, both) match the PinChange values.
+	sam.EIC.CTRL.ClearBits(sam.EIC_CTRL_ENABLE)
+	waitEICSync()
+	pos := (extint % 8) * 4
+	config := sam.EIC.CONFIG[extint/8].Get() &^ (0xf << pos)
+	sam.EIC.CONFIG[extint/8].Set(config | uint32(change)<<pos)
+	sam.EIC.INTFLAG.Set(1 << extint)
+	sam.EIC.INTENSET.Set(1 << extint)
+	sam.EIC.CTRL.SetBits(sam.EIC_CTRL_ENABLE)
+	waitEICSync()
+
+	// Connect the pin to the EIC (peripheral function A, which is zero),
+	// keeping the pull configuration.
+	if p&1 > 0 {
+		// odd pin, so save the even pins
+		p.setPMux(p.getPMux() & sam.PORT_PMUX0_PMUXE_Msk)
+	} else {
+		// even pin, so save the odd pins
+		p.setPMux(p.getPMux() & sam.PORT_PMUX0_PMUXO_Msk)
+	}
+	p.setPinCfg(p.getPinCfg() | sam.PORT_PINCFG0_PMUXEN | sam.PORT_PINCFG0_INEN)
+
+	arm.SetPriority(sam.IRQ_EIC, 0xc0) // low priority
+	arm.EnableIRQ(sam.IRQ_EIC)
+	return nil
+}
+
+func waitEICSync() {
+	for sam.EIC.STATUS.HasBits(sam.EIC_STATUS_SYNCBUSY) {
+	}
+}
+
+//go:export EIC_IRQHandler
+func handleEIC() {
+	flags := sam.EIC.INTFLAG.Get()
+	sam.EIC.INTFLAG.Set(flags)
+	for extint := uint8(0); extint < 16; extint++ {
+		if flags&(1<<extint) == 0 {
+			continue
+		}
+		if callback := pinCallbacks[extint]; callback != nil {
+			callback(pinInterruptPins[extint])
+		}
+	}
+}
+
+// Flash is written in 32-bit words, through the page buffer of the flash
+// controller.
+const flashWriteBlockSize = 4
+
+// Size of a flash page, the unit in which flash is written.
+const flashPageSize = 64
+
+// flashEraseBlockSize returns the size of a flash row, which consists of four
+// pages.
+func flashEraseBlockSize() uintptr {
+	return flashPageSize * 4
+}
+
+// writeFlash writes p to flash at the given address, one page at a time. The
+// runtime enables manual write mode, so a page is only written by an explicit
+// write page command.
+func writeFlash(addr uintptr, p []byte) error {
+	for len(p) > 0 {
+		// Fill the page buffer up to the end of the current page.
+		n := int(flashPageSize - addr%flashPageSize)
+		if n > len(p) {
+			n = len(p)
+		}
+		if err := nvmctrlCommand(sam.NVMCTRL_CTRLA_CMD_PBC); err != nil {
+			return err
+		}
+		for i := 0; i < n; i += 4 {
+			word := uint32(p[i]) | uint32(p[i+1])<<8 | uint32(p[i+2])<<16 | uint32(p[i+3])<<24
+			volatile.StoreUint32((*uint32)(unsafe.Pointer(addr+uintptr(i))), word)
+		}
+		if err := nvmctrlCommand(sam.NVMCTRL_CTRLA_CMD_WP); err != nil {
+			return err
+		}
+		addr += uintptr(n)
+		p = p[n:]
+	}
+	return nil
+}
+
+// eraseFlashBlock erases the flash row at the given address.
+func eraseFlashBlock(addr uintptr) error {
+	// The address register takes 16-bit word addresses.
+	sam.NVMCTRL.ADDR.Set(uint32(addr / 2))
+	return nvmctrlCommand(sam.NVMCTRL_CTRLA_CMD_ER)
+}
+
+// nvmctrlCommand executes a flash controller command and waits for it to
+// finish.
+func nvmctrlCommand(cmd uint16) error {
+	for !sam.NVMCTRL.INTFLAG.HasBits(sam.NVMCTRL_INTFLAG_READY) {
+	}
+	sam.NVMCTRL.STATUS.Set(sam.NVMCTRL_STATUS_LOCKE | sam.NVMCTRL_STATUS_PROGE | sam.NVMCTRL_STATUS_NVME)
+	sam.NVMCTRL.CTRLA.Set(cmd | sam.NVMCTRL_CTRLA_CMDEX_KEY<<sam.NVMCTRL_CTRLA_CMDEX_Pos)
+	for !sam.NVMCTRL.INTFLAG.HasBits(sam.NVMCTRL_INTFLAG_READY) {
+	}
+	if sam.NVMCTRL.STATUS.HasBits(sam.NVMCTRL_STATUS_LOCKE | sam.NVMCTRL_STATUS_PROGE | sam.NVMCTRL_STATUS_NVME) {
+		return ErrFlashFailed
+	}
+	return nil
+}
+
+// Capabilities returns the electrical capabilities of this pin. All pins
+// support a stronger drive strength (DRVSTR), the pins of AIN0-AIN19 can be
+// used as ADC input. No pins are 5V tolerant.
+func (p Pin) Capabilities() PinCapabilities {
+	switch {
+	case p >= PA02 && p <= PA11, p >= PB00 && p <= PB09:
+		return PinAnalogCapable | PinHighDrive
+	case p == NoPin:
+		return 0
+	default:
+		return PinHighDrive
+	}
+}
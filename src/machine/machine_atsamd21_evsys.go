@@ -0,0 +1,96 @@
+// +build sam,atsamd21
+
+package machine
+
+import (
+	"device/sam"
+)
+
+// Event is an event generator of the event system, identified by its number
+// (the EVGEN value in the datasheet). The peripheral must be configured to
+// output the event, usually in its EVCTRL register.
+type Event uint8
+
+// Task is an event user of the event system, identified by its number (the
+// USER value in the datasheet). The peripheral must be configured to act on
+// the event, usually in its EVCTRL register.
+type Task uint8
+
+// EventRouter routes events to tasks with the event system (EVSYS). Events
+// take the asynchronous path, which doesn't need a clock and has the lowest
+// latency, but can't detect edges or generate interrupts.
+type EventRouter struct{}
+
+// EVSYS is the event router of the SAMD21.
+var EVSYS = EventRouter{}
+
+// Number of event system channels.
+const evsysChannels = 12
+
+var (
+	evsysUsed   uint16                // channels that are in use
+	evsysEvents [evsysChannels]Event  // event of each channel in use
+	evsysTasks  [evsysChannels]uint32 // bitmap of the users of each channel
+)
+
+// Connect routes an event to a task through a free channel and enables the
+// channel.
+func (r EventRouter) Connect(event Event, task Task) (EventChannel, error) {
+	sam.PM.APBCMASK.SetBits(sam.PM_APBCMASK_EVSYS_)
+	for i := uint8(0); i < evsysChannels; i++ {
+		if evsysUsed&(1<<i) != 0 {
+			continue
+		}
+		evsysUsed |= 1 << i
+		evsysEvents[i] = event
+		ch := EventChannel{i}
+		ch.AddTask(task)
+		ch.Enable()
+		return ch, nil
+	}
+	return EventChannel{}, ErrNoEventChannel
+}
+
+// AddTask makes the channel trigger another task. A channel can trigger any
+// number of tasks, but a task can only be triggered by one channel.
+func (ch EventChannel) AddTask(task Task) error {
+	for i := range evsysTasks {
+		evsysTasks[i] &^= 1 << task
+	}
+	evsysTasks[ch.index] |= 1 << task
+	// A user is connected to channel n by writing n+1.
+	sam.EVSYS.USER.Set(uint16(task)<<sam.EVSYS_USER_USER_Pos |
+		uint16(ch.index+1)<<sam.EVSYS_USER_CHANNEL_Pos)
+	return nil
+}
+
+// Enable starts routing events through this channel.
+func (ch EventChannel) Enable() {
+	ch.setGenerator(evsysEvents[ch.index])
+}
+
+// Disable stops routing events through this channel, without releasing it.
+func (ch EventChannel) Disable() {
+	ch.setGenerator(0)
+}
+
+// setGenerator configures the channel with the given event generator. A
+// channel without a generator is disabled.
+func (ch EventChannel) setGenerator(event Event) {
+	sam.EVSYS.CHANNEL.Set(uint32(ch.index)<<sam.EVSYS_CHANNEL_CHANNEL_Pos |
+		uint32(event)<<sam.EVSYS_CHANNEL_EVGEN_Pos |
+		sam.EVSYS_CHANNEL_PATH_ASYNCHRONOUS<<sam.EVSYS_CHANNEL_PATH_Pos)
+}
+
+// Close disables the channel, disconnects its tasks and makes it available
+// again.
+func (ch EventChannel) Close() {
+	ch.Disable()
+	for task := uint8(0); task < 32; task++ {
+		if evsysTasks[ch.index]&(1<<task) != 0 {
+			sam.EVSYS.USER.Set(uint16(task) << sam.EVSYS_USER_USER_Pos)
+		}
+	}
+	evsysTasks[ch.index] = 0
+	evsysUsed &^= 1 << ch.index
+}
@@ -0,0 +1,39 @@
+// +build sam,atsamd21
+
+package machine
+
+import (
+	"device/sam"
+)
+
+// The SAMD21 has no RNG peripheral. Random data is gathered from the noise of
+// the internal temperature sensor.
+
+var rngADCInitialized bool
+
+// GetRNG returns 32 bits of random data gathered from ADC noise. It is much
+// slower than a hardware random number generator.
+func GetRNG() (uint32, error) {
+	if !rngADCInitialized {
+		InitADC()
+		sam.SYSCTRL.VREF.SetBits(sam.SYSCTRL_VREF_TSEN)
+		rngADCInitialized = true
+	}
+	sam.ADC.INPUTCTRL.ClearBits(sam.ADC_INPUTCTRL_MUXPOS_Msk | sam.ADC_INPUTCTRL_MUXNEG_Msk)
+	waitADCSync()
+	sam.ADC.INPUTCTRL.SetBits(sam.ADC_INPUTCTRL_MUXPOS_TEMP<<sam.ADC_INPUTCTRL_MUXPOS_Pos |
+		sam.ADC_INPUTCTRL_MUXNEG_GND<<sam.ADC_INPUTCTRL_MUXNEG_Pos)
+	waitADCSync()
+	sam.ADC.CTRLA.SetBits(sam.ADC_CTRLA_ENABLE)
+	waitADCSync()
+	result, err := adcNoise(func() bool {
+		sam.ADC.SWTRIG.SetBits(sam.ADC_SWTRIG_START)
+		waitADCSync()
+		for !sam.ADC.INTFLAG.HasBits(sam.ADC_INTFLAG_RESRDY) {
+		}
+		return sam.ADC.RESULT.Get()&1 != 0
+	})
+	sam.ADC.CTRLA.ClearBits(sam.ADC_CTRLA_ENABLE)
+	waitADCSync()
+	return result, err
+}
@@ -93,3 +93,9 @@ var (
 	// UART0 is the hardware serial port on the AVR.
 	UART0 = UART{Buffer: NewRingBuffer()}
 )
+
+// Capabilities returns the electrical capabilities of this pin. They are not
+// known for this chip, so no capabilities are reported.
+func (p Pin) Capabilities() PinCapabilities {
+	return 0
+}
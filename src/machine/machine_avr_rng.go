@@ -0,0 +1,24 @@
+// +build avr
+
+package machine
+
+// AVR chips have no RNG peripheral. Random data is gathered from the noise of
+// ADC channel 0.
+
+var rngADCInitialized bool
+
+// GetRNG returns 32 bits of random data gathered from ADC noise. It is much
+// slower than a hardware random number generator, and works best when the
+// ADC0 pin is not connected.
+func GetRNG() (uint32, error) {
+	if !rngADCInitialized {
+		InitADC()
+		rngADCInitialized = true
+	}
+	adc := ADC{Pin: 0}
+	return adcNoise(func() bool {
+		// The result is left-adjusted, so the least significant bit of the
+		// 10-bit conversion is bit 6.
+		return adc.Get()&(1<<6) != 0
+	})
+}
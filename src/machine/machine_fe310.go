@@ -52,3 +52,15 @@ func (uart UART) WriteByte(c byte) {
 
 	sifive.UART0.TXDATA.Set(uint32(c))
 }
+
+// GetRNG is not supported: the FE310 has neither a random number generator nor
+// an ADC.
+func GetRNG() (uint32, error) {
+	return 0, ErrNoRNG
+}
+
+// Capabilities returns the electrical capabilities of this pin. They are not
+// known for this chip, so no capabilities are reported.
+func (p Pin) Capabilities() PinCapabilities {
+	return 0
+}
@@ -84,6 +84,14 @@ func (adc ADC) Get() uint16 {
 //go:export __tinygo_adc_read
 func adcRead(pin Pin) uint16
 
+// GetRNG returns 32 bits of random data from the host.
+func GetRNG() (uint32, error) {
+	return rngRead(), nil
+}
+
+//go:export __tinygo_rng_read
+func rngRead() uint32
+
 // InitPWM enables support for PWM peripherals.
 func InitPWM() {
 	// Nothing to do here.
@@ -101,6 +109,18 @@ func (pwm PWM) Set(value uint16) {
 //go:export __tinygo_pwm_set
 func pwmSet(pin Pin, value uint16)
 
+// Configure configures a DAC pin for output.
+func (dac DAC) Configure(config DACConfig) {
+}
+
+// Set sets the output voltage of a DAC pin using the provided value.
+func (dac DAC) Set(value uint16) {
+	dacSet(dac.Pin, value)
+}
+
+//go:export __tinygo_dac_set
+func dacSet(pin Pin, value uint16)
+
 // I2C is a generic implementation of the Inter-IC communication protocol.
 type I2C struct {
 	Bus uint8
@@ -182,3 +202,9 @@ func uartRead(bus uint8, buf *byte, bufLen int) int
 
 //go:export __tinygo_uart_write
 func uartWrite(bus uint8, buf *byte, bufLen int) int
+
+// Capabilities returns the electrical capabilities of this pin. They are not
+// known for this chip, so no capabilities are reported.
+func (p Pin) Capabilities() PinCapabilities {
+	return 0
+}
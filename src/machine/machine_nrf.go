@@ -5,6 +5,8 @@ package machine
 import (
 	"device/arm"
 	"device/nrf"
+	"runtime/volatile"
+	"unsafe"
 )
 
 type PinMode uint8
@@ -322,3 +324,247 @@ func (spi SPI) Transfer(w byte) (byte, error) {
 	// TODO: handle SPI errors
 	return byte(r), nil
 }
+
+// Timer is a hardware timer that calls a callback at a fixed frequency, from
+// the timer interrupt.
+type Timer struct {
+	Bus   *nrf.TIMER_Type
+	index uint8
+}
+
+// TIMER0 is used by the (optional) SoftDevice, so it is not made available
+// here.
+var (
+	Timer1 = Timer{Bus: nrf.TIMER1, index: 1}
+	Timer2 = Timer{Bus: nrf.TIMER2, index: 2}
+)
+
+var timerCallbacks [3]func()
+
+// Configure sets the frequency of the timer. The timer runs from the 16MHz
+// peripheral clock with a power-of-two prescaler and a 16-bit counter, so
+// frequencies from 1Hz up to a few MHz are supported.
+func (t Timer) Configure(config TimerConfig) error {
+	if config.Frequency == 0 {
+		return ErrTimerFrequency
+	}
+	// Find the smallest prescaler (giving the highest precision) for which
+	// the compare value fits in 16 bits.
+	for prescaler := uint32(0); prescaler <= 9; prescaler++ {
+		compare := (16000000 >> prescaler) / config.Frequency
+		if compare == 0 {
+			return ErrTimerFrequency
+		}
+		if compare > 0xffff {
+			continue
+		}
+		t.Bus.TASKS_STOP.Set(1)
+		t.Bus.MODE.Set(nrf.TIMER_MODE_MODE_Timer)
+		t.Bus.BITMODE.Set(nrf.TIMER_BITMODE_BITMODE_16Bit)
+		t.Bus.PRESCALER.Set(prescaler)
+		t.Bus.CC[0].Set(compare)
+		t.Bus.SHORTS.Set(nrf.TIMER_SHORTS_COMPARE0_CLEAR)
+		t.Bus.TASKS_CLEAR.Set(1)
+		return nil
+	}
+	return ErrTimerFrequency
+}
+
+// Start calls the callback at the configured frequency until the timer is
+// stopped. The callback is called from an interrupt, so it must be short and
+// must not block.
+func (t Timer) Start(callback func()) {
+	timerCallbacks[t.index] = callback
+	t.Bus.EVENTS_COMPARE[0].Set(0)
+	t.Bus.INTENSET.Set(nrf.TIMER_INTENSET_COMPARE0)
+	irq := t.irq()
+	arm.SetPriority(irq, 0xc0) // low priority
+	arm.EnableIRQ(irq)
+	t.Bus.TASKS_START.Set(1)
+}
+
+// Stop the timer. The callback won't be called anymore after this call.
+func (t Timer) Stop() {
+	t.Bus.TASKS_STOP.Set(1)
+	t.Bus.INTENCLR.Set(nrf.TIMER_INTENSET_COMPARE0)
+	arm.DisableIRQ(t.irq())
+	timerCallbacks[t.index] = nil
+}
+
+func (t Timer) irq() uint32 {
+	if t.index == 1 {
+		return nrf.IRQ_TIMER1
+	}
+	return nrf.IRQ_TIMER2
+}
+
+func (t Timer) handleInterrupt() {
+	if t.Bus.EVENTS_COMPARE[0].Get() != 0 {
+		t.Bus.EVENTS_COMPARE[0].Set(0)
+		if callback := timerCallbacks[t.index]; callback != nil {
+			callback()
+		}
+	}
+}
+
+//go:export TIMER1_IRQHandler
+func handleTIMER1() {
+	Timer1.handleInterrupt()
+}
+
+//go:export TIMER2_IRQHandler
+func handleTIMER2() {
+	Timer2.handleInterrupt()
+}
+
+// Configure sets the timeout of the watchdog. It must be called before Start,
+// as the watchdog cannot be reconfigured while it is running. The watchdog
+// keeps running while the CPU is sleeping.
+func (wd watchdogImpl) Configure(config WatchdogConfig) error {
+	// The watchdog counts down from the reload value at 32.768kHz.
+	reload := uint64(config.TimeoutMillis) * 32768 / 1000
+	if reload < 0xf || reload > 0xffffffff {
+		return ErrWatchdogTimeout
+	}
+	nrf.WDT.CONFIG.Set(nrf.WDT_CONFIG_SLEEP_Run << nrf.WDT_CONFIG_SLEEP_Pos)
+	nrf.WDT.CRV.Set(uint32(reload))
+	nrf.WDT.RREN.Set(nrf.WDT_RREN_RR0_Enabled << nrf.WDT_RREN_RR0_Pos)
+	return nil
+}
+
+// Start the watchdog. It cannot be stopped anymore, except by a reset.
+func (wd watchdogImpl) Start() {
+	nrf.WDT.TASKS_START.Set(1)
+}
+
+// Feed the watchdog, restarting the timeout.
+func (wd watchdogImpl) Feed() {
+	nrf.WDT.RR[0].Set(0x6E524635) // magic reload value
+}
+
+// CausedReset returns whether the last reset was caused by the watchdog.
+func (wd watchdogImpl) CausedReset() bool {
+	return nrf.POWER.RESETREAS.HasBits(nrf.POWER_RESETREAS_DOG)
+}
+
+// prepareDeepSleep configures the chip for deep sleep. The RTC used by the
+// scheduler runs from the low frequency clock, which keeps running in deep
+// sleep, so nothing needs to be done.
+func prepareDeepSleep() bool {
+	return true
+}
+
+// EnableWakeup configures this pin as a wake-up source for runtime.Suspend.
+// The chip wakes up (and resets) when the pin has the given level.
+func (p Pin) EnableWakeup(high bool) {
+	port, pin := p.getPortPin()
+	sense := uint32(nrf.GPIO_PIN_CNF_SENSE_Low)
+	if high {
+		sense = nrf.GPIO_PIN_CNF_SENSE_High
+	}
+	cnf := port.PIN_CNF[pin].Get() &^ nrf.GPIO_PIN_CNF_SENSE_Msk
+	port.PIN_CNF[pin].Set(cnf | sense<<nrf.GPIO_PIN_CNF_SENSE_Pos)
+}
+
+// DisableWakeup removes this pin as a wake-up source.
+func (p Pin) DisableWakeup() {
+	port, pin := p.getPortPin()
+	port.PIN_CNF[pin].ClearBits(nrf.GPIO_PIN_CNF_SENSE_Msk)
+}
+
+// Callbacks for each GPIOTE channel. The nRF51 has 4 channels, the nRF52 has 8.
+var pinCallbacks [8]func(Pin)
+
+// SetInterrupt sets a callback to be called from an interrupt when the pin
+// changes. Pass a nil callback to remove the interrupt again. Pin change
+// interrupts use the GPIOTE peripheral, which has a limited number of
+// channels.
+func (p Pin) SetInterrupt(change PinChange, callback func(Pin)) error {
+	// Look for the channel already used by this pin, or else a free channel.
+	channel := -1
+	for ch := range nrf.GPIOTE.CONFIG {
+		config := nrf.GPIOTE.CONFIG[ch].Get()
+		mode := (config & nrf.GPIOTE_CONFIG_MODE_Msk) >> nrf.GPIOTE_CONFIG_MODE_Pos
+		if mode == nrf.GPIOTE_CONFIG_MODE_Event && Pin(config>>nrf.GPIOTE_CONFIG_PSEL_Pos&0x3f) == p {
+			channel = ch
+			break
+		}
+		if mode == nrf.GPIOTE_CONFIG_MODE_Disabled && channel < 0 {
+			channel = ch
+		}
+	}
+	if channel < 0 {
+		return ErrNoPinChangeChannel
+	}
+
+	if callback == nil {
+		nrf.GPIOTE.INTENCLR.Set(1 << uint(channel))
+		nrf.GPIOTE.CONFIG[channel].Set(0)
+		pinCallbacks[channel] = nil
+		return nil
+	}
+
+	pinCallbacks[channel] = callback
+	// The pin number includes the port on the nRF52840, which conveniently
+	// corresponds to the PORT field directly above PSEL.
+	nrf.GPIOTE.CONFIG[channel].Set((nrf.GPIOTE_CONFIG_MODE_Event << nrf.GPIOTE_CONFIG_MODE_Pos) |
+		(uint32(p) << nrf.GPIOTE_CONFIG_PSEL_Pos) |
+		(uint32(change) << nrf.GPIOTE_CONFIG_POLARITY_Pos))
+	nrf.GPIOTE.EVENTS_IN[channel].Set(0)
+	nrf.GPIOTE.INTENSET.Set(1 << uint(channel))
+	arm.SetPriority(nrf.IRQ_GPIOTE, 0xc0) // low priority
+	arm.EnableIRQ(nrf.IRQ_GPIOTE)
+	return nil
+}
+
+//go:export GPIOTE_IRQHandler
+func handleGPIOTE() {
+	for ch := range nrf.GPIOTE.EVENTS_IN {
+		if nrf.GPIOTE.EVENTS_IN[ch].Get() == 0 {
+			continue
+		}
+		nrf.GPIOTE.EVENTS_IN[ch].Set(0)
+		if callback := pinCallbacks[ch]; callback != nil {
+			pin := Pin(nrf.GPIOTE.CONFIG[ch].Get() >> nrf.GPIOTE_CONFIG_PSEL_Pos & 0x3f)
+			callback(pin)
+		}
+	}
+}
+
+// Flash is written in 32-bit words.
+const flashWriteBlockSize = 4
+
+// flashEraseBlockSize returns the size of a flash page.
+func flashEraseBlockSize() uintptr {
+	return uintptr(nrf.FICR.CODEPAGESIZE.Get())
+}
+
+// writeFlash writes p to flash at the given address. The CPU is halted while
+// each word is written. This does not work while a SoftDevice is enabled, as
+// the SoftDevice protects the flash controller.
+func writeFlash(addr uintptr, p []byte) error {
+	nrf.NVMC.CONFIG.Set(nrf.NVMC_CONFIG_WEN_Wen)
+	for i := 0; i < len(p); i += 4 {
+		word := uint32(p[i]) | uint32(p[i+1])<<8 | uint32(p[i+2])<<16 | uint32(p[i+3])<<24
+		volatile.StoreUint32((*uint32)(unsafe.Pointer(addr+uintptr(i))), word)
+		waitForNVMC()
+	}
+	nrf.NVMC.CONFIG.Set(nrf.NVMC_CONFIG_WEN_Ren)
+	return nil
+}
+
+// eraseFlashBlock erases the flash page at the given address.
+func eraseFlashBlock(addr uintptr) error {
+	nrf.NVMC.CONFIG.Set(nrf.NVMC_CONFIG_WEN_Een)
+	nrf.NVMC.ERASEPAGE.Set(uint32(addr))
+	waitForNVMC()
+	nrf.NVMC.CONFIG.Set(nrf.NVMC_CONFIG_WEN_Ren)
+	return nil
+}
+
+// waitForNVMC waits until the flash controller has finished the current
+// operation.
+func waitForNVMC() {
+	for !nrf.NVMC.READY.HasBits(nrf.NVMC_READY_READY) {
+	}
+}
@@ -43,3 +43,30 @@ func (spi SPI) setPins(sck, mosi, miso Pin) {
 	spi.Bus.PSELMOSI.Set(uint32(mosi))
 	spi.Bus.PSELMISO.Set(uint32(miso))
 }
+
+// Number of programmable PPI channels. Channels 20 to 31 are pre-programmed.
+const ppiChannels = 16
+
+// AddTask makes the channel trigger a second task. This is not supported on
+// the nRF51, which has no PPI forks, so it always returns ErrEventChannelFull.
+func (ch EventChannel) AddTask(task Task) error {
+	return ErrEventChannelFull
+}
+
+// clearForks does nothing, as the nRF51 has no PPI forks.
+func (ch EventChannel) clearForks() {
+}
+
+// Capabilities returns the electrical capabilities of this pin. All pins
+// support high drive, the pins of AIN0-AIN7 can be used as ADC input. No pins
+// are 5V tolerant.
+func (p Pin) Capabilities() PinCapabilities {
+	switch p {
+	case 1, 2, 3, 4, 5, 6, 26, 27:
+		return PinAnalogCapable | PinHighDrive
+	case NoPin:
+		return 0
+	default:
+		return PinHighDrive
+	}
+}
@@ -190,3 +190,17 @@ func (pwm PWM) Set(value uint16) {
 		}
 	}
 }
+
+// Capabilities returns the electrical capabilities of this pin. All pins
+// support high drive, the pins of AIN0-AIN7 can be used as ADC input. No pins
+// are 5V tolerant.
+func (p Pin) Capabilities() PinCapabilities {
+	switch p {
+	case 2, 3, 4, 5, 28, 29, 30, 31:
+		return PinAnalogCapable | PinHighDrive
+	case NoPin:
+		return 0
+	default:
+		return PinHighDrive
+	}
+}
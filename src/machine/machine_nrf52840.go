@@ -4,6 +4,7 @@ package machine
 
 import (
 	"device/nrf"
+	"runtime/volatile"
 	"unsafe"
 )
 
@@ -194,3 +195,179 @@ func (pwm PWM) Set(value uint16) {
 		}
 	}
 }
+
+// I2S on the nRF52840.
+type I2S struct {
+	Bus *nrf.I2S_Type
+}
+
+var (
+	I2S0 = I2S{Bus: nrf.I2S}
+)
+
+// Configure is used to configure the I2S interface. You must call this
+// before you can use the I2S bus. The SD pin is used as input by Read and as
+// output by Write.
+func (i2s I2S) Configure(config I2SConfig) {
+	if config.AudioFrequency == 0 {
+		config.AudioFrequency = 48000
+	}
+	if config.DataFormat == I2SDataFormatDefault {
+		config.DataFormat = I2SDataFormat16bit
+	}
+
+	i2s.Bus.ENABLE.Set(nrf.I2S_ENABLE_ENABLE_Disabled)
+
+	if config.Mode == I2SModeSlave {
+		i2s.Bus.CONFIG.MODE.Set(nrf.I2S_CONFIG_MODE_MODE_Slave)
+	} else {
+		i2s.Bus.CONFIG.MODE.Set(nrf.I2S_CONFIG_MODE_MODE_Master)
+	}
+
+	// Set the sample width. The nRF52840 doesn't support 32-bit samples, so
+	// use 24-bit samples in that case.
+	switch config.DataFormat {
+	case I2SDataFormat8bit:
+		i2s.Bus.CONFIG.SWIDTH.Set(nrf.I2S_CONFIG_SWIDTH_SWIDTH_8Bit)
+	case I2SDataFormat16bit:
+		i2s.Bus.CONFIG.SWIDTH.Set(nrf.I2S_CONFIG_SWIDTH_SWIDTH_16Bit)
+	default:
+		i2s.Bus.CONFIG.SWIDTH.Set(nrf.I2S_CONFIG_SWIDTH_SWIDTH_24Bit)
+	}
+
+	// The ratio between MCK and LRCK must be at least twice the sample width
+	// (two channels per frame).
+	ratio := uint32(nrf.I2S_CONFIG_RATIO_RATIO_32X)
+	ratioValue := uint32(32)
+	if config.DataFormat != I2SDataFormat8bit && config.DataFormat != I2SDataFormat16bit {
+		ratio = nrf.I2S_CONFIG_RATIO_RATIO_48X
+		ratioValue = 48
+	}
+	i2s.Bus.CONFIG.RATIO.Set(ratio)
+
+	// Calculate the MCK divider from the 32MHz clock. The formula comes from
+	// the datasheet:
+	//     MCKFREQ = 4096 * floor(f_MCK * 1048576 / (f_source + f_MCK / 2))
+	mck := uint64(config.AudioFrequency) * uint64(ratioValue)
+	i2s.Bus.CONFIG.MCKFREQ.Set(uint32(4096 * (mck * 1048576 / (32000000 + mck/2))))
+	// SCK and LRCK are derived from MCK in master mode, so it must run even
+	// when it isn't output on a pin.
+	if config.Mode == I2SModeSlave {
+		i2s.Bus.CONFIG.MCKEN.Set(nrf.I2S_CONFIG_MCKEN_MCKEN_Disabled)
+	} else {
+		i2s.Bus.CONFIG.MCKEN.Set(nrf.I2S_CONFIG_MCKEN_MCKEN_Enabled)
+	}
+
+	if config.Standard == I2StandardPhilips {
+		i2s.Bus.CONFIG.FORMAT.Set(nrf.I2S_CONFIG_FORMAT_FORMAT_I2S)
+	} else {
+		i2s.Bus.CONFIG.FORMAT.Set(nrf.I2S_CONFIG_FORMAT_FORMAT_Aligned)
+	}
+	if config.Standard == I2SStandardLSB {
+		i2s.Bus.CONFIG.ALIGN.Set(nrf.I2S_CONFIG_ALIGN_ALIGN_Right)
+	} else {
+		i2s.Bus.CONFIG.ALIGN.Set(nrf.I2S_CONFIG_ALIGN_ALIGN_Left)
+	}
+
+	if config.Stereo {
+		i2s.Bus.CONFIG.CHANNELS.Set(nrf.I2S_CONFIG_CHANNELS_CHANNELS_Stereo)
+	} else {
+		i2s.Bus.CONFIG.CHANNELS.Set(nrf.I2S_CONFIG_CHANNELS_CHANNELS_Left)
+	}
+
+	// Configure pins. The data pin starts out as input.
+	i2s.Bus.PSEL.SCK.Set(uint32(config.SCK))
+	i2s.Bus.PSEL.LRCK.Set(uint32(config.WS))
+	i2s.Bus.PSEL.SDIN.Set(uint32(config.SD))
+	i2s.Bus.PSEL.SDOUT.Set(nrf.I2S_PSEL_SDOUT_CONNECT_Disconnected << nrf.I2S_PSEL_SDOUT_CONNECT_Pos)
+	// MCK is only output on a pin that is set explicitly: pin 0 (the zero
+	// value) is the 32kHz crystal input on most boards.
+	if config.MasterClockOutput && config.Mode != I2SModeSlave && config.MCK != 0 && config.MCK != NoPin {
+		i2s.Bus.PSEL.MCK.Set(uint32(config.MCK))
+	} else {
+		i2s.Bus.PSEL.MCK.Set(nrf.I2S_PSEL_MCK_CONNECT_Disconnected << nrf.I2S_PSEL_MCK_CONNECT_Pos)
+	}
+
+	i2s.Bus.ENABLE.Set(nrf.I2S_ENABLE_ENABLE_Enabled)
+}
+
+// Read data from the I2S bus into the provided slice. Every element of the
+// slice contains one frame: both the left and right channel in stereo mode
+// (when using 8-bit or 16-bit samples) or a single sample otherwise.
+// The I2S bus must already have been configured correctly.
+func (i2s I2S) Read(p []uint32) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Use the data pin as input.
+	if sdout := i2s.Bus.PSEL.SDOUT.Get(); sdout&nrf.I2S_PSEL_SDOUT_CONNECT_Msk == 0 {
+		i2s.Bus.PSEL.SDOUT.Set(nrf.I2S_PSEL_SDOUT_CONNECT_Disconnected << nrf.I2S_PSEL_SDOUT_CONNECT_Pos)
+		i2s.Bus.PSEL.SDIN.Set(sdout)
+	}
+	i2s.Bus.CONFIG.TXEN.Set(nrf.I2S_CONFIG_TXEN_TXEN_Disabled)
+	i2s.Bus.CONFIG.RXEN.Set(nrf.I2S_CONFIG_RXEN_RXEN_Enabled)
+	i2s.Bus.RXD.PTR.Set(uint32(uintptr(unsafe.Pointer(&p[0]))))
+	i2s.transfer(len(p), &i2s.Bus.EVENTS_RXPTRUPD)
+	return len(p), nil
+}
+
+// Write data to the I2S bus from the provided slice, in the same format as
+// Read. The I2S bus must already have been configured correctly.
+func (i2s I2S) Write(p []uint32) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Use the data pin as output.
+	if sdin := i2s.Bus.PSEL.SDIN.Get(); sdin&nrf.I2S_PSEL_SDIN_CONNECT_Msk == 0 {
+		i2s.Bus.PSEL.SDIN.Set(nrf.I2S_PSEL_SDIN_CONNECT_Disconnected << nrf.I2S_PSEL_SDIN_CONNECT_Pos)
+		i2s.Bus.PSEL.SDOUT.Set(sdin)
+	}
+	i2s.Bus.CONFIG.RXEN.Set(nrf.I2S_CONFIG_RXEN_RXEN_Disabled)
+	i2s.Bus.CONFIG.TXEN.Set(nrf.I2S_CONFIG_TXEN_TXEN_Enabled)
+	i2s.Bus.TXD.PTR.Set(uint32(uintptr(unsafe.Pointer(&p[0]))))
+	i2s.transfer(len(p), &i2s.Bus.EVENTS_TXPTRUPD)
+	return len(p), nil
+}
+
+// transfer runs a single EasyDMA transfer of the given number of words. The
+// pointer update event fires once when the buffer pointer has been read by the
+// peripheral and once again when the buffer has been fully transferred.
+func (i2s I2S) transfer(words int, ptrupd *volatile.Register32) {
+	i2s.Bus.RXTXD.MAXCNT.Set(uint32(words))
+	ptrupd.Set(0)
+	i2s.Bus.TASKS_START.Set(1)
+	for ptrupd.Get() == 0 {
+	}
+	ptrupd.Set(0)
+	for ptrupd.Get() == 0 {
+	}
+	ptrupd.Set(0)
+
+	i2s.Bus.EVENTS_STOPPED.Set(0)
+	i2s.Bus.TASKS_STOP.Set(1)
+	for i2s.Bus.EVENTS_STOPPED.Get() == 0 {
+	}
+	i2s.Bus.EVENTS_STOPPED.Set(0)
+}
+
+// Close the I2S bus.
+func (i2s I2S) Close() error {
+	i2s.Bus.ENABLE.Set(nrf.I2S_ENABLE_ENABLE_Disabled)
+	return nil
+}
+
+// Capabilities returns the electrical capabilities of this pin. All pins
+// support high drive, the pins of AIN0-AIN7 can be used as ADC input. No pins
+// are 5V tolerant.
+func (p Pin) Capabilities() PinCapabilities {
+	switch p {
+	case 2, 3, 4, 5, 28, 29, 30, 31:
+		return PinAnalogCapable | PinHighDrive
+	case NoPin:
+		return 0
+	default:
+		return PinHighDrive
+	}
+}
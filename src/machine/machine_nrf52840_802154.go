@@ -0,0 +1,526 @@
+// +build nrf52840
+
+package machine
+
+// IEEE 802.15.4 support using the radio of the nRF52840. This is the physical
+// layer and the time-critical parts of the MAC layer (CSMA-CA, acknowledgments
+// and address filtering) that protocols like 6LoWPAN, Thread and Zigbee are
+// built on. The rest of the MAC layer is left to the stack using it, which may
+// be written in Go or linked from C.
+//
+// The radio and TIMER0 are used exclusively, so this can't be combined with
+// the SoftDevice or with the machine/bluetooth package. Frame timestamps are taken by TIMER0 through the
+// pre-programmed PPI channel 26, without CPU involvement.
+
+import (
+	"device/arm"
+	"device/nrf"
+	"errors"
+	"runtime/volatile"
+	"unsafe"
+)
+
+var (
+	ErrRadioChannel   = errors.New("machine: 802.15.4 channel out of range")
+	ErrRadioFrameSize = errors.New("machine: 802.15.4 frame too long")
+	ErrChannelBusy    = errors.New("machine: 802.15.4 channel busy")
+	ErrNoAck          = errors.New("machine: 802.15.4 frame not acknowledged")
+)
+
+// Number of received frames that can be buffered before new frames are
+// dropped. Must be a power of two.
+const radioBufferSize = 4
+
+const (
+	// Maximum size of a PSDU: the MAC header, payload and the 2-byte FCS.
+	radioMaxPSDU = 127
+
+	// Broadcast PAN ID and short address.
+	radioBroadcast = 0xffff
+
+	// Timing in microseconds. A symbol takes 16µs at 250kbit/s.
+	radioBackoffPeriod = 20 * 16 // aUnitBackoffPeriod
+	radioAckWait       = 54 * 16 // macAckWaitDuration
+	radioRampUp        = 40      // fast ramp-up, with some margin
+
+	// CSMA-CA and retransmission parameters, the defaults of the standard.
+	radioMinBE           = 3
+	radioMaxBE           = 5
+	radioMaxBackoffs     = 4
+	radioMaxFrameRetries = 3
+
+	// Conversion of energy detection levels to dBm, see the nRF52840 product
+	// specification.
+	radioEDOffset = -92
+	radioEDScale  = 4
+)
+
+// Frame control bits used by the MAC layer.
+const (
+	radioFrameTypeMask = 0x7
+	radioFrameTypeAck  = 0x2
+	radioAckRequest    = 1 << 5
+)
+
+// Radio802154Config is the configuration of the 802.15.4 radio.
+type Radio802154Config struct {
+	// Channel is the channel number, from 11 to 26 (2405 to 2480MHz). It
+	// defaults to 11.
+	Channel uint8
+
+	// TxPower is the transmit power in dBm, from -40 to 8. It is rounded
+	// down to a supported value.
+	TxPower int8
+
+	// CCAThreshold is the energy level in dBm above which the channel is
+	// considered busy. It defaults to -75dBm.
+	CCAThreshold int8
+
+	// Addresses of this device, used for filtering received frames and for
+	// acknowledgments.
+	PANID           uint16
+	ShortAddress    uint16
+	ExtendedAddress uint64
+
+	// AutoAck acknowledges received frames that request it and are addressed
+	// to this device.
+	AutoAck bool
+
+	// Promiscuous receives all frames with a valid FCS, including frames for
+	// other devices and acknowledgments.
+	Promiscuous bool
+}
+
+// Radio802154Frame is a received 802.15.4 frame.
+type Radio802154Frame struct {
+	// Length is the number of bytes in Data: the MAC header and the payload,
+	// without the FCS.
+	Length uint8
+	Data   [radioMaxPSDU - 2]byte
+
+	// RSSI is the received signal strength in dBm and LQI the link quality
+	// indicator reported by the radio.
+	RSSI int8
+	LQI  uint8
+
+	// Timestamp is the time at which the start of frame delimiter was
+	// received, in microseconds. See Radio802154.Now.
+	Timestamp uint32
+}
+
+// Radio802154 is the radio in IEEE 802.15.4 mode. Received frames are queued
+// from the radio interrupt and can be read with Rx.
+type Radio802154 struct {
+	buffer *radioRingBuffer
+}
+
+// Radio is the 802.15.4 radio of the nRF52840.
+var Radio = Radio802154{buffer: &radioRingBuffer{}}
+
+// States of the radio, as seen from the interrupt handler.
+const (
+	radioStateDisabled = iota
+	radioStateRx
+	radioStateTxAck
+)
+
+var (
+	radioConfig   Radio802154Config
+	radioState    volatile.Register8
+	radioRandom   uint32
+	radioRxBuffer [1 + radioMaxPSDU]byte // PHR (length) followed by the PSDU
+	radioTxBuffer [1 + radioMaxPSDU]byte
+	radioAckFrame [1 + 5]byte
+)
+
+// Configure the radio and start receiving.
+func (r Radio802154) Configure(config Radio802154Config) error {
+	if config.Channel == 0 {
+		config.Channel = 11
+	}
+	if config.Channel < 11 || config.Channel > 26 {
+		return ErrRadioChannel
+	}
+	if config.CCAThreshold == 0 {
+		config.CCAThreshold = -75
+	}
+	SetRadioInterrupts(radioInterrupt802154, nil)
+	r.stop()
+	radioConfig = config
+
+	nrf.RADIO.POWER.Set(1)
+	nrf.RADIO.MODE.Set(nrf.RADIO_MODE_MODE_Ieee802154_250Kbit)
+	nrf.RADIO.MODECNF0.Set(nrf.RADIO_MODECNF0_RU_Fast << nrf.RADIO_MODECNF0_RU_Pos)
+
+	// The packet starts with an 8-bit length field, which includes the FCS.
+	// The FCS is the 16-bit ITU-T CRC over the whole PSDU.
+	nrf.RADIO.PCNF0.Set(8<<nrf.RADIO_PCNF0_LFLEN_Pos |
+		nrf.RADIO_PCNF0_PLEN_32bitZero<<nrf.RADIO_PCNF0_PLEN_Pos |
+		nrf.RADIO_PCNF0_CRCINC_Include<<nrf.RADIO_PCNF0_CRCINC_Pos)
+	nrf.RADIO.PCNF1.Set(radioMaxPSDU << nrf.RADIO_PCNF1_MAXLEN_Pos)
+	nrf.RADIO.CRCCNF.Set(nrf.RADIO_CRCCNF_LEN_Two<<nrf.RADIO_CRCCNF_LEN_Pos |
+		nrf.RADIO_CRCCNF_SKIPADDR_Ieee802154<<nrf.RADIO_CRCCNF_SKIPADDR_Pos)
+	nrf.RADIO.CRCPOLY.Set(0x11021)
+	nrf.RADIO.CRCINIT.Set(0)
+	nrf.RADIO.FREQUENCY.Set(uint32(config.Channel-10) * 5)
+	nrf.RADIO.TXPOWER.Set(uint32(uint8(radioTxPower(config.TxPower))))
+
+	edLevel := (int(config.CCAThreshold) - radioEDOffset) / radioEDScale
+	if edLevel < 0 {
+		edLevel = 0
+	}
+	nrf.RADIO.CCACTRL.Set(nrf.RADIO_CCACTRL_CCAMODE_EdMode<<nrf.RADIO_CCACTRL_CCAMODE_Pos |
+		uint32(edLevel)<<nrf.RADIO_CCACTRL_CCAEDTHRES_Pos)
+
+	// TIMER0 counts microseconds. PPI channel 26 captures it in CC[1] when a
+	// start of frame delimiter is sent or received.
+	nrf.TIMER0.TASKS_STOP.Set(1)
+	nrf.TIMER0.MODE.Set(nrf.TIMER_MODE_MODE_Timer)
+	nrf.TIMER0.BITMODE.Set(nrf.TIMER_BITMODE_BITMODE_32Bit)
+	nrf.TIMER0.PRESCALER.Set(4) // 16MHz / 2^4
+	nrf.TIMER0.TASKS_CLEAR.Set(1)
+	nrf.TIMER0.TASKS_START.Set(1)
+	nrf.PPI.CHENSET.Set(1 << 26)
+
+	// Seed the generator for the CSMA-CA backoff.
+	radioRandom, _ = GetRNG()
+	radioRandom |= 1
+
+	nrf.RADIO.INTENSET.Set(nrf.RADIO_INTENSET_END)
+	arm.SetPriority(nrf.IRQ_RADIO, 0x40) // high priority, for acknowledgments
+	arm.EnableIRQ(nrf.IRQ_RADIO)
+	r.startRx()
+	return nil
+}
+
+// radioTxPower returns the highest supported transmit power that is not
+// higher than the requested power.
+func radioTxPower(dBm int8) int8 {
+	switch {
+	case dBm >= 8:
+		return 8
+	case dBm >= 2:
+		return dBm
+	case dBm >= 0:
+		return 0
+	case dBm < -20:
+		return -40
+	default:
+		// -4, -8, -12, -16 or -20.
+		return -((-dBm + 3) / 4 * 4)
+	}
+}
+
+// SetChannel switches to another channel, from 11 to 26.
+func (r Radio802154) SetChannel(channel uint8) error {
+	if channel < 11 || channel > 26 {
+		return ErrRadioChannel
+	}
+	r.stop()
+	radioConfig.Channel = channel
+	nrf.RADIO.FREQUENCY.Set(uint32(channel-10) * 5)
+	r.startRx()
+	return nil
+}
+
+// Now returns the current time in microseconds, in the same unit as the
+// timestamps of received frames. It wraps around after about 71 minutes.
+func (r Radio802154) Now() uint32 {
+	nrf.TIMER0.TASKS_CAPTURE[0].Set(1)
+	return nrf.TIMER0.CC[0].Get()
+}
+
+// Rx returns the oldest received frame. The second return value is false if
+// no frame has been received.
+func (r Radio802154) Rx() (Radio802154Frame, bool) {
+	return r.buffer.Get()
+}
+
+// Buffered returns the number of received frames waiting to be read.
+func (r Radio802154) Buffered() int {
+	return int(r.buffer.Used())
+}
+
+// ChannelClear performs a clear channel assessment, and returns whether the
+// energy on the channel is below the CCA threshold.
+func (r Radio802154) ChannelClear() bool {
+	r.stop()
+	nrf.RADIO.EVENTS_CCAIDLE.Set(0)
+	nrf.RADIO.EVENTS_CCABUSY.Set(0)
+	nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_RXREADY_CCASTART)
+	nrf.RADIO.TASKS_RXEN.Set(1)
+	for nrf.RADIO.EVENTS_CCAIDLE.Get() == 0 && nrf.RADIO.EVENTS_CCABUSY.Get() == 0 {
+	}
+	clear := nrf.RADIO.EVENTS_CCAIDLE.Get() != 0
+	r.disable()
+	r.startRx()
+	return clear
+}
+
+// Tx sends a frame, which consists of the MAC header and the payload: the FCS
+// is added by the radio. The channel is accessed with unslotted CSMA-CA. If
+// the frame requests an acknowledgment, Tx waits for it and retransmits the
+// frame when it doesn't arrive. It returns the time at which the start of
+// frame delimiter was sent.
+func (r Radio802154) Tx(frame []byte) (timestamp uint32, err error) {
+	if len(frame)+2 > radioMaxPSDU {
+		return 0, ErrRadioFrameSize
+	}
+	radioTxBuffer[0] = uint8(len(frame) + 2)
+	copy(radioTxBuffer[1:], frame)
+	ackRequested := len(frame) >= 3 && frame[0]&radioAckRequest != 0
+
+	r.stop()
+	defer r.startRx()
+	for retry := 0; retry <= radioMaxFrameRetries; retry++ {
+		if !r.csmaTx() {
+			return 0, ErrChannelBusy
+		}
+		timestamp = nrf.TIMER0.CC[1].Get()
+		if !ackRequested || r.waitAck(frame[2]) {
+			return timestamp, nil
+		}
+	}
+	return timestamp, ErrNoAck
+}
+
+// csmaTx transmits the frame in the transmit buffer when the channel is clear,
+// with random backoffs while it is busy. It returns false if the channel
+// stayed busy.
+func (r Radio802154) csmaTx() bool {
+	be := uint32(radioMinBE)
+	for backoffs := 0; backoffs <= radioMaxBackoffs; backoffs++ {
+		r.delay(r.random() % (1 << be) * radioBackoffPeriod)
+
+		// Do a CCA and start transmitting right away when the channel is
+		// idle, or disable the radio when it is busy.
+		nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&radioTxBuffer))))
+		nrf.RADIO.EVENTS_CCABUSY.Set(0)
+		nrf.RADIO.EVENTS_DISABLED.Set(0)
+		nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_RXREADY_CCASTART |
+			nrf.RADIO_SHORTS_CCAIDLE_TXEN |
+			nrf.RADIO_SHORTS_CCABUSY_DISABLE |
+			nrf.RADIO_SHORTS_TXREADY_START |
+			nrf.RADIO_SHORTS_END_DISABLE)
+		nrf.RADIO.TASKS_RXEN.Set(1)
+		for nrf.RADIO.EVENTS_DISABLED.Get() == 0 {
+		}
+		if nrf.RADIO.EVENTS_CCABUSY.Get() == 0 {
+			return true
+		}
+		if be < radioMaxBE {
+			be++
+		}
+	}
+	return false
+}
+
+// waitAck receives for the acknowledgment wait duration and returns whether an
+// acknowledgment with the given sequence number arrived.
+func (r Radio802154) waitAck(seq byte) bool {
+	deadline := r.Now() + radioRampUp + radioAckWait
+	nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&radioRxBuffer))))
+	nrf.RADIO.EVENTS_END.Set(0)
+	nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_READY_START)
+	nrf.RADIO.TASKS_RXEN.Set(1)
+	for int32(r.Now()-deadline) < 0 {
+		if nrf.RADIO.EVENTS_END.Get() == 0 {
+			continue
+		}
+		nrf.RADIO.EVENTS_END.Set(0)
+		if nrf.RADIO.CRCSTATUS.Get() == nrf.RADIO_CRCSTATUS_CRCSTATUS_CRCOk &&
+			radioRxBuffer[0] == 5 &&
+			radioRxBuffer[1]&radioFrameTypeMask == radioFrameTypeAck &&
+			radioRxBuffer[3] == seq {
+			r.disable()
+			return true
+		}
+		nrf.RADIO.TASKS_START.Set(1)
+	}
+	r.disable()
+	return false
+}
+
+// delay waits for the given number of microseconds.
+func (r Radio802154) delay(us uint32) {
+	deadline := r.Now() + us
+	for int32(r.Now()-deadline) < 0 {
+	}
+}
+
+// random returns a pseudorandom number for the CSMA-CA backoff.
+func (r Radio802154) random() uint32 {
+	// xorshift32
+	radioRandom ^= radioRandom << 13
+	radioRandom ^= radioRandom >> 17
+	radioRandom ^= radioRandom << 5
+	return radioRandom
+}
+
+// stop disables the radio and its interrupt, so that it can be used directly.
+// It waits for an acknowledgment that is being sent to finish first.
+func (r Radio802154) stop() {
+	for radioState.Get() == radioStateTxAck {
+	}
+	arm.DisableIRQ(nrf.IRQ_RADIO)
+	radioState.Set(radioStateDisabled)
+	r.disable()
+}
+
+// disable disables the radio and waits until it has done so.
+func (r Radio802154) disable() {
+	nrf.RADIO.SHORTS.Set(0)
+	if nrf.RADIO.STATE.Get() == nrf.RADIO_STATE_STATE_Disabled {
+		return
+	}
+	nrf.RADIO.EVENTS_DISABLED.Set(0)
+	nrf.RADIO.TASKS_DISABLE.Set(1)
+	for nrf.RADIO.EVENTS_DISABLED.Get() == 0 {
+	}
+	nrf.RADIO.EVENTS_END.Set(0)
+}
+
+// startRx starts receiving frames from the radio interrupt.
+func (r Radio802154) startRx() {
+	nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&radioRxBuffer))))
+	nrf.RADIO.EVENTS_END.Set(0)
+	nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_READY_START | nrf.RADIO_SHORTS_ADDRESS_RSSISTART)
+	radioState.Set(radioStateRx)
+	nrf.RADIO.TASKS_RXEN.Set(1)
+	arm.EnableIRQ(nrf.IRQ_RADIO)
+}
+
+// handleInterrupt stores a received frame in the buffer and sends an
+// acknowledgment if needed. After an acknowledgment has been sent, it switches
+// back to receiving.
+func (r Radio802154) handleInterrupt() {
+	if nrf.RADIO.EVENTS_END.Get() == 0 {
+		return
+	}
+	nrf.RADIO.EVENTS_END.Set(0)
+
+	if radioState.Get() == radioStateTxAck {
+		// Switch back to receiving when the radio has been disabled.
+		nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&radioRxBuffer))))
+		nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_READY_START |
+			nrf.RADIO_SHORTS_ADDRESS_RSSISTART |
+			nrf.RADIO_SHORTS_DISABLED_RXEN)
+		radioState.Set(radioStateRx)
+		nrf.RADIO.TASKS_DISABLE.Set(1)
+		return
+	}
+
+	length := int(radioRxBuffer[0])
+	if nrf.RADIO.CRCSTATUS.Get() != nrf.RADIO_CRCSTATUS_CRCSTATUS_CRCOk || length < 5 || length > radioMaxPSDU {
+		nrf.RADIO.TASKS_START.Set(1)
+		return
+	}
+	psdu := radioRxBuffer[1 : 1+length]
+	accept, ack := radioFilter(psdu)
+	if accept {
+		frame := Radio802154Frame{
+			Length:    uint8(length - 2),
+			RSSI:      -int8(nrf.RADIO.RSSISAMPLE.Get()),
+			Timestamp: nrf.TIMER0.CC[1].Get(),
+		}
+		// The radio stores the LQI in place of the first byte of the FCS.
+		frame.LQI = psdu[length-2]
+		copy(frame.Data[:], psdu[:length-2])
+		r.buffer.Put(frame)
+	}
+	if !ack {
+		nrf.RADIO.TASKS_START.Set(1)
+		return
+	}
+
+	// Send an acknowledgment with the same sequence number. The radio turns
+	// around as fast as it can, which is well within the acknowledgment wait
+	// duration of the sender.
+	radioAckFrame = [6]byte{5, radioFrameTypeAck, 0, psdu[2], 0, 0}
+	nrf.RADIO.PACKETPTR.Set(uint32(uintptr(unsafe.Pointer(&radioAckFrame))))
+	nrf.RADIO.SHORTS.Set(nrf.RADIO_SHORTS_READY_START | nrf.RADIO_SHORTS_DISABLED_TXEN)
+	radioState.Set(radioStateTxAck)
+	nrf.RADIO.TASKS_DISABLE.Set(1)
+}
+
+// radioInterrupt802154 is the RADIO interrupt handler while the radio is in
+// 802.15.4 mode.
+func radioInterrupt802154() {
+	Radio.handleInterrupt()
+}
+
+// radioFilter returns whether a received frame must be passed on, and whether
+// it must be acknowledged.
+func radioFilter(psdu []byte) (accept, ack bool) {
+	fc := uint16(psdu[0]) | uint16(psdu[1])<<8
+	if fc&radioFrameTypeMask == radioFrameTypeAck {
+		return radioConfig.Promiscuous, false
+	}
+	// The destination PAN ID and address follow the sequence number.
+	switch (fc >> 10) & 3 {
+	case 0:
+		// No destination address, for example a beacon.
+		return true, false
+	case 2:
+		if len(psdu) < 9 {
+			return false, false
+		}
+		pan := uint16(psdu[3]) | uint16(psdu[4])<<8
+		addr := uint16(psdu[5]) | uint16(psdu[6])<<8
+		if pan != radioBroadcast && pan != radioConfig.PANID || addr != radioBroadcast && addr != radioConfig.ShortAddress {
+			return radioConfig.Promiscuous, false
+		}
+		ack = addr != radioBroadcast
+	case 3:
+		if len(psdu) < 15 {
+			return false, false
+		}
+		pan := uint16(psdu[3]) | uint16(psdu[4])<<8
+		var addr uint64
+		for i := 12; i >= 5; i-- {
+			addr = addr<<8 | uint64(psdu[i])
+		}
+		if pan != radioBroadcast && pan != radioConfig.PANID || addr != radioConfig.ExtendedAddress {
+			return radioConfig.Promiscuous, false
+		}
+		ack = true
+	default:
+		return false, false
+	}
+	return true, ack && radioConfig.AutoAck && fc&radioAckRequest != 0
+}
+
+// radioRingBuffer is a ring buffer of received frames, filled from the radio
+// interrupt.
+type radioRingBuffer struct {
+	frames [radioBufferSize]Radio802154Frame
+	head   volatile.Register8
+	tail   volatile.Register8
+}
+
+// Used returns how many frames are in the buffer.
+func (rb *radioRingBuffer) Used() uint8 {
+	return rb.head.Get() - rb.tail.Get()
+}
+
+// Put stores a frame in the buffer. If the buffer is full, the frame is
+// dropped and false is returned.
+func (rb *radioRingBuffer) Put(frame Radio802154Frame) bool {
+	if rb.Used() == radioBufferSize {
+		return false
+	}
+	rb.frames[rb.head.Get()%radioBufferSize] = frame
+	rb.head.Set(rb.head.Get() + 1)
+	return true
+}
+
+// Get returns the oldest frame from the buffer. The second return value is
+// false if the buffer is empty.
+func (rb *radioRingBuffer) Get() (Radio802154Frame, bool) {
+	if rb.Used() == 0 {
+		return Radio802154Frame{}, false
+	}
+	frame := rb.frames[rb.tail.Get()%radioBufferSize]
+	rb.tail.Set(rb.tail.Get() + 1)
+	return frame, true
+}
@@ -0,0 +1,25 @@
+// +build nrf52 nrf52840
+
+package machine
+
+import (
+	"device/nrf"
+)
+
+// Number of programmable PPI channels. Channels 20 to 31 are pre-programmed.
+const ppiChannels = 20
+
+// AddTask makes the channel trigger a second task. Every channel of the nRF52
+// can fork to one additional task.
+func (ch EventChannel) AddTask(task Task) error {
+	if nrf.PPI.FORK[ch.index].TEP.Get() != 0 {
+		return ErrEventChannelFull
+	}
+	nrf.PPI.FORK[ch.index].TEP.Set(uint32(task))
+	return nil
+}
+
+// clearForks removes the additional task of the channel.
+func (ch EventChannel) clearForks() {
+	nrf.PPI.FORK[ch.index].TEP.Set(0)
+}
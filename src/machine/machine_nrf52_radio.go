@@ -0,0 +1,40 @@
+// +build nrf52 nrf52840
+
+package machine
+
+import (
+	"device/arm"
+	"device/nrf"
+)
+
+// The radio and TIMER0 are shared by the drivers that use the radio: the
+// IEEE 802.15.4 support in this package and the Bluetooth link layer in the
+// machine/bluetooth package. Only one of them can be used at a time, so their
+// interrupts are passed to the driver that took over the radio last.
+
+var radioInterrupt, timer0Interrupt func()
+
+// SetRadioInterrupts takes over the radio for a driver, by setting the
+// functions that handle the RADIO and TIMER0 interrupts. Either may be nil.
+// Both interrupts are disabled; the driver enables the ones it uses once it
+// has configured the hardware.
+func SetRadioInterrupts(radio, timer0 func()) {
+	arm.DisableIRQ(nrf.IRQ_RADIO)
+	arm.DisableIRQ(nrf.IRQ_TIMER0)
+	radioInterrupt = radio
+	timer0Interrupt = timer0
+}
+
+//go:export RADIO_IRQHandler
+func handleRADIO() {
+	if radioInterrupt != nil {
+		radioInterrupt()
+	}
+}
+
+//go:export TIMER0_IRQHandler
+func handleTIMER0() {
+	if timer0Interrupt != nil {
+		timer0Interrupt()
+	}
+}
@@ -0,0 +1,55 @@
+// +build nrf
+
+package machine
+
+import (
+	"device/nrf"
+	"unsafe"
+)
+
+// AES encryption using the ECB peripheral of the nRF51 and nRF52. It only
+// supports encrypting with 128-bit keys; other operations are done in
+// software by crypto/aes.
+
+func init() {
+	CryptoAES = ecbEngine{}
+}
+
+// ecbData is the data structure the ECB peripheral reads the key and the
+// cleartext from, and writes the ciphertext to. It must be in RAM.
+var ecbData struct {
+	key        [16]byte
+	cleartext  [16]byte
+	ciphertext [16]byte
+}
+
+type ecbEngine struct{}
+
+// EncryptBlock encrypts a single block with a 128-bit key. It may fail when the
+// radio uses the AES hardware at the same time, in which case the caller falls
+// back to software.
+func (ecbEngine) EncryptBlock(key, dst, src []byte) bool {
+	if len(key) != 16 {
+		return false
+	}
+	copy(ecbData.key[:], key)
+	copy(ecbData.cleartext[:], src[:16])
+	nrf.ECB.ECBDATAPTR.Set(uint32(uintptr(unsafe.Pointer(&ecbData))))
+	nrf.ECB.EVENTS_ENDECB.Set(0)
+	nrf.ECB.EVENTS_ERRORECB.Set(0)
+	nrf.ECB.TASKS_STARTECB.Set(1)
+	for nrf.ECB.EVENTS_ENDECB.Get() == 0 {
+		if nrf.ECB.EVENTS_ERRORECB.Get() != 0 {
+			nrf.ECB.EVENTS_ERRORECB.Set(0)
+			return false
+		}
+	}
+	nrf.ECB.EVENTS_ENDECB.Set(0)
+	copy(dst, ecbData.ciphertext[:])
+	return true
+}
+
+// DecryptBlock is not supported by the ECB peripheral.
+func (ecbEngine) DecryptBlock(key, dst, src []byte) bool {
+	return false
+}
@@ -0,0 +1,79 @@
+// +build nrf
+
+package machine
+
+import (
+	"device/nrf"
+	"runtime/volatile"
+	"unsafe"
+)
+
+// Event is an event of a peripheral, identified by the address of its EVENTS
+// register. See EventOf.
+type Event uintptr
+
+// Task is a task of a peripheral, identified by the address of its TASKS
+// register. See TaskOf.
+type Task uintptr
+
+// EventOf returns the event of an EVENTS register, for example:
+//
+//     machine.EventOf(&nrf.TIMER1.EVENTS_COMPARE[0])
+func EventOf(reg *volatile.Register32) Event {
+	return Event(uintptr(unsafe.Pointer(reg)))
+}
+
+// TaskOf returns the task of a TASKS register, for example:
+//
+//     machine.TaskOf(&nrf.SAADC.TASKS_SAMPLE)
+func TaskOf(reg *volatile.Register32) Task {
+	return Task(uintptr(unsafe.Pointer(reg)))
+}
+
+// EventRouter routes events to tasks with the PPI (programmable peripheral
+// interconnect). Only the programmable channels are used: the channels with a
+// fixed configuration are left to the drivers that need them, like the radio.
+type EventRouter struct{}
+
+// PPI is the event router of the nRF.
+var PPI = EventRouter{}
+
+// Programmable PPI channels that are in use.
+var ppiUsed uint32
+
+// Connect routes an event to a task through a free channel and enables the
+// channel.
+func (r EventRouter) Connect(event Event, task Task) (EventChannel, error) {
+	for i := uint8(0); i < ppiChannels; i++ {
+		if ppiUsed&(1<<i) != 0 {
+			continue
+		}
+		ppiUsed |= 1 << i
+		nrf.PPI.CH[i].EEP.Set(uint32(event))
+		nrf.PPI.CH[i].TEP.Set(uint32(task))
+		ch := EventChannel{i}
+		ch.clearForks()
+		ch.Enable()
+		return ch, nil
+	}
+	return EventChannel{}, ErrNoEventChannel
+}
+
+// Enable starts routing events through this channel.
+func (ch EventChannel) Enable() {
+	nrf.PPI.CHENSET.Set(1 << ch.index)
+}
+
+// Disable stops routing events through this channel, without releasing it.
+func (ch EventChannel) Disable() {
+	nrf.PPI.CHENCLR.Set(1 << ch.index)
+}
+
+// Close disables the channel and makes it available again.
+func (ch EventChannel) Close() {
+	ch.Disable()
+	nrf.PPI.CH[ch.index].EEP.Set(0)
+	nrf.PPI.CH[ch.index].TEP.Set(0)
+	ch.clearForks()
+	ppiUsed &^= 1 << ch.index
+}
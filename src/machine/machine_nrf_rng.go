@@ -0,0 +1,24 @@
+// +build nrf
+
+package machine
+
+import (
+	"device/nrf"
+)
+
+// GetRNG returns 32 bits of random data from the RNG peripheral, which
+// generates them from thermal noise. Bias correction is enabled.
+func GetRNG() (uint32, error) {
+	nrf.RNG.CONFIG.Set(nrf.RNG_CONFIG_DERCEN_Enabled << nrf.RNG_CONFIG_DERCEN_Pos)
+	nrf.RNG.EVENTS_VALRDY.Set(0)
+	nrf.RNG.TASKS_START.Set(1)
+	var result uint32
+	for i := 0; i < 4; i++ {
+		for nrf.RNG.EVENTS_VALRDY.Get() == 0 {
+		}
+		nrf.RNG.EVENTS_VALRDY.Set(0)
+		result = result<<8 | nrf.RNG.VALUE.Get()&0xff
+	}
+	nrf.RNG.TASKS_STOP.Set(1)
+	return result, nil
+}
@@ -4,4 +4,287 @@ package machine
 
 // Peripheral abstraction layer for the stm32.
 
+import (
+	"device/arm"
+	"device/stm32"
+)
+
 type PinMode uint8
+
+// Timer is a hardware timer that calls a callback at a fixed frequency, from
+// the timer interrupt. It uses one of the general-purpose timers.
+type Timer struct {
+	Bus   *stm32.TIM_Type
+	index uint8
+}
+
+// TIM3 is used by the runtime for sleeping, so it is not made available here.
+var (
+	Timer2 = Timer{Bus: stm32.TIM2, index: 0}
+	Timer4 = Timer{Bus: stm32.TIM4, index: 1}
+)
+
+var timerCallbacks [2]func()
+
+// Configure sets the frequency of the timer. The prescaler and auto-reload
+// register are both 16 bits wide, so frequencies from below 1Hz up to a few
+// MHz are supported.
+func (t Timer) Configure(config TimerConfig) error {
+	if config.Frequency == 0 || config.Frequency > timerClock {
+		return ErrTimerFrequency
+	}
+
+	// Use the smallest prescaler (giving the highest precision) for which the
+	// auto-reload value fits in 16 bits.
+	cycles := timerClock / config.Frequency
+	prescaler := (cycles + 0xffff) / 0x10000
+	if prescaler > 0x10000 {
+		return ErrTimerFrequency
+	}
+	period := cycles / prescaler
+
+	if t.index == 0 {
+		stm32.RCC.APB1ENR.SetBits(stm32.RCC_APB1ENR_TIM2EN)
+	} else {
+		stm32.RCC.APB1ENR.SetBits(stm32.RCC_APB1ENR_TIM4EN)
+	}
+	t.Bus.CR1.ClearBits(stm32.TIM_CR1_CEN)
+	t.Bus.PSC.Set(prescaler - 1)
+	t.Bus.ARR.Set(period - 1)
+	t.Bus.CNT.Set(0)
+	return nil
+}
+
+// Start calls the callback at the configured frequency until the timer is
+// stopped. The callback is called from an interrupt, so it must be short and
+// must not block.
+func (t Timer) Start(callback func()) {
+	timerCallbacks[t.index] = callback
+	t.Bus.SR.ClearBits(stm32.TIM_SR_UIF)
+	t.Bus.DIER.SetBits(stm32.TIM_DIER_UIE)
+	irq := t.irq()
+	arm.SetPriority(irq, 0xc0) // low priority
+	arm.EnableIRQ(irq)
+	t.Bus.CR1.SetBits(stm32.TIM_CR1_CEN)
+}
+
+// Stop the timer. The callback won't be called anymore after this call.
+func (t Timer) Stop() {
+	t.Bus.CR1.ClearBits(stm32.TIM_CR1_CEN)
+	t.Bus.DIER.ClearBits(stm32.TIM_DIER_UIE)
+	arm.DisableIRQ(t.irq())
+	timerCallbacks[t.index] = nil
+}
+
+func (t Timer) irq() uint32 {
+	if t.index == 0 {
+		return stm32.IRQ_TIM2
+	}
+	return stm32.IRQ_TIM4
+}
+
+func (t Timer) handleInterrupt() {
+	if t.Bus.SR.HasBits(stm32.TIM_SR_UIF) {
+		t.Bus.SR.ClearBits(stm32.TIM_SR_UIF)
+		if callback := timerCallbacks[t.index]; callback != nil {
+			callback()
+		}
+	}
+}
+
+//go:export TIM2_IRQHandler
+func handleTIM2() {
+	Timer2.handleInterrupt()
+}
+
+//go:export TIM4_IRQHandler
+func handleTIM4() {
+	Timer4.handleInterrupt()
+}
+
+// Configure sets the timeout of the independent watchdog (IWDG). It runs from
+// the internal low speed oscillator, which is not very accurate: the actual
+// timeout may differ by tens of percents.
+func (wd watchdogImpl) Configure(config WatchdogConfig) error {
+	ticks := uint64(config.TimeoutMillis) * lsiFrequency / 1000
+
+	// Find the smallest prescaler (/4 up to /256) for which the reload value
+	// fits in 12 bits.
+	for prescaler := uint32(0); prescaler <= 6; prescaler++ {
+		reload := ticks >> (prescaler + 2)
+		if reload > 0xfff {
+			continue
+		}
+		if reload == 0 {
+			reload = 1
+		}
+		stm32.IWDG.KR.Set(0x5555) // enable write access to PR and RLR
+		stm32.IWDG.PR.Set(prescaler)
+		stm32.IWDG.RLR.Set(uint32(reload))
+		for stm32.IWDG.SR.Get() != 0 {
+			// Wait until the new values have been written.
+		}
+		return nil
+	}
+	return ErrWatchdogTimeout
+}
+
+// Start the watchdog. It cannot be stopped anymore, except by a reset.
+func (wd watchdogImpl) Start() {
+	stm32.IWDG.KR.Set(0xCCCC)
+}
+
+// Feed the watchdog, restarting the timeout.
+func (wd watchdogImpl) Feed() {
+	stm32.IWDG.KR.Set(0xAAAA)
+}
+
+// CausedReset returns whether the last reset was caused by the watchdog.
+func (wd watchdogImpl) CausedReset() bool {
+	return stm32.RCC.CSR.HasBits(stm32.RCC_CSR_IWDGRSTF)
+}
+
+// prepareDeepSleep returns false as the timer used by the scheduler to sleep
+// does not run in stop mode, so the scheduler would never be woken up.
+func prepareDeepSleep() bool {
+	return false
+}
+
+// Callbacks and pins for each of the 16 EXTI lines connected to GPIO pins.
+var (
+	pinCallbacks     [16]func(Pin)
+	pinInterruptPins [16]Pin
+)
+
+// SetInterrupt sets a callback to be called from an interrupt when the pin
+// changes. Pass a nil callback to remove the interrupt again. Pin n of every
+// port is connected to EXTI line n, so for example PA0 and PB0 cannot both
+// have an interrupt at the same time.
+func (p Pin) SetInterrupt(change PinChange, callback func(Pin)) error {
+	line := uint8(p) % 16
+	mask := uint32(1) << line
+
+	if callback == nil {
+		if pinCallbacks[line] != nil && pinInterruptPins[line] == p {
+			stm32.EXTI.IMR.ClearBits(mask)
+			pinCallbacks[line] = nil
+		}
+		return nil
+	}
+	if pinCallbacks[line] != nil && pinInterruptPins[line] != p {
+		return ErrNoPinChangeChannel
+	}
+	pinCallbacks[line] = callback
+	pinInterruptPins[line] = p
+
+	// Connect the EXTI line to the port of this pin.
+	setEXTIPort(line, uint32(p)/16)
+
+	if change&PinRising != 0 {
+		stm32.EXTI.RTSR.SetBits(mask)
+	} else {
+		stm32.EXTI.RTSR.ClearBits(mask)
+	}
+	if change&PinFalling != 0 {
+		stm32.EXTI.FTSR.SetBits(mask)
+	} else {
+		stm32.EXTI.FTSR.ClearBits(mask)
+	}
+	stm32.EXTI.PR.Set(mask) // clear pending interrupt (write 1 to clear)
+	stm32.EXTI.IMR.SetBits(mask)
+
+	irq := extiIRQ(line)
+	arm.SetPriority(irq, 0xc0) // low priority
+	arm.EnableIRQ(irq)
+	return nil
+}
+
+// extiIRQ returns the interrupt number for the given EXTI line. Lines 5-9 and
+// 10-15 share an interrupt.
+func extiIRQ(line uint8) uint32 {
+	switch {
+	case line == 0:
+		return stm32.IRQ_EXTI0
+	case line == 1:
+		return stm32.IRQ_EXTI1
+	case line == 2:
+		return stm32.IRQ_EXTI2
+	case line == 3:
+		return stm32.IRQ_EXTI3
+	case line == 4:
+		return stm32.IRQ_EXTI4
+	case line <= 9:
+		return stm32.IRQ_EXTI9_5
+	default:
+		return stm32.IRQ_EXTI15_10
+	}
+}
+
+// handleEXTI calls the callbacks of all pending EXTI lines in the given range.
+func handleEXTI(first, last uint8) {
+	for line := first; line <= last; line++ {
+		mask := uint32(1) << line
+		if !stm32.EXTI.PR.HasBits(mask) {
+			continue
+		}
+		stm32.EXTI.PR.Set(mask)
+		if callback := pinCallbacks[line]; callback != nil {
+			callback(pinInterruptPins[line])
+		}
+	}
+}
+
+//go:export EXTI0_IRQHandler
+func handleEXTI0() {
+	handleEXTI(0, 0)
+}
+
+//go:export EXTI1_IRQHandler
+func handleEXTI1() {
+	handleEXTI(1, 1)
+}
+
+//go:export EXTI2_IRQHandler
+func handleEXTI2() {
+	handleEXTI(2, 2)
+}
+
+//go:export EXTI3_IRQHandler
+func handleEXTI3() {
+	handleEXTI(3, 3)
+}
+
+//go:export EXTI4_IRQHandler
+func handleEXTI4() {
+	handleEXTI(4, 4)
+}
+
+//go:export EXTI9_5_IRQHandler
+func handleEXTI9_5() {
+	handleEXTI(5, 9)
+}
+
+//go:export EXTI15_10_IRQHandler
+func handleEXTI15_10() {
+	handleEXTI(10, 15)
+}
+
+// unlockFlash unlocks the flash control register, which is locked after reset
+// and after every flash operation.
+func unlockFlash() {
+	if stm32.FLASH.CR.HasBits(stm32.FLASH_CR_LOCK) {
+		stm32.FLASH.KEYR.Set(0x45670123)
+		stm32.FLASH.KEYR.Set(0xCDEF89AB)
+	}
+}
+
+// lockFlash locks the flash control register again.
+func lockFlash() {
+	stm32.FLASH.CR.SetBits(stm32.FLASH_CR_LOCK)
+}
+
+// waitForFlash waits until the current flash operation has finished.
+func waitForFlash() {
+	for stm32.FLASH.SR.HasBits(stm32.FLASH_SR_BSY) {
+	}
+}
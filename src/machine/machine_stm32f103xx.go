@@ -8,10 +8,23 @@ import (
 	"device/arm"
 	"device/stm32"
 	"errors"
+	"runtime/volatile"
+	"unsafe"
 )
 
 const CPU_FREQUENCY = 72000000
 
+// The APB1 bus runs at half the CPU frequency, but the timers on it are
+// clocked at twice the bus frequency.
+const timerClock = CPU_FREQUENCY
+
+// Nominal frequency of the internal low speed oscillator, used by the
+// independent watchdog.
+const lsiFrequency = 40000
+
+// Frequency of the APB1 bus, which clocks the CAN controller.
+const apb1Clock = CPU_FREQUENCY / 2
+
 const (
 	PinInput       PinMode = 0 // Input mode
 	PinOutput10MHz PinMode = 1 // Output mode, max speed 10MHz
@@ -758,3 +771,116 @@ func (i2c I2C) WriteByte(data byte) error {
 
 	return nil
 }
+
+// setEXTIPort connects the given EXTI line to a GPIO port (0 for port A, 1 for
+// port B, etc.) using the AFIO external interrupt configuration registers.
+func setEXTIPort(line uint8, port uint32) {
+	stm32.RCC.APB2ENR.SetBits(stm32.RCC_APB2ENR_AFIOEN)
+	pos := uint32(line%4) * 4
+	var reg *volatile.Register32
+	switch line / 4 {
+	case 0:
+		reg = &stm32.AFIO.EXTICR1
+	case 1:
+		reg = &stm32.AFIO.EXTICR2
+	case 2:
+		reg = &stm32.AFIO.EXTICR3
+	default:
+		reg = &stm32.AFIO.EXTICR4
+	}
+	reg.Set(reg.Get()&^(0xf<<pos) | port<<pos)
+}
+
+// The CAN receive FIFO 0 interrupt is shared with the USB low priority
+// interrupt. Both peripherals also share their buffer memory, so they cannot
+// be used at the same time.
+const canRxIRQ = stm32.IRQ_USB_LP_CAN_RX0
+
+// configureCANPins configures the pins used by CAN1: the default PA12/PA11, or
+// PB9/PB8 via AFIO remapping.
+func configureCANPins(tx, rx Pin) {
+	switch tx {
+	case PB9:
+		stm32.RCC.APB2ENR.SetBits(stm32.RCC_APB2ENR_AFIOEN)
+		stm32.AFIO.MAPR.Set(stm32.AFIO.MAPR.Get()&^stm32.AFIO_MAPR_CAN_REMAP_Msk | 2<<stm32.AFIO_MAPR_CAN_REMAP_Pos)
+		PB9.Configure(PinConfig{Mode: PinOutput50MHz + PinOutputModeAltPushPull})
+		PB8.Configure(PinConfig{Mode: PinInputModeFloating})
+	default:
+		PA12.Configure(PinConfig{Mode: PinOutput50MHz + PinOutputModeAltPushPull})
+		PA11.Configure(PinConfig{Mode: PinInputModeFloating})
+	}
+}
+
+// enableCANClock enables the peripheral clock of CAN1.
+func enableCANClock() {
+	stm32.RCC.APB1ENR.SetBits(stm32.RCC_APB1ENR_CANEN)
+}
+
+//go:export USB_LP_CAN_RX0_IRQHandler
+func handleCANRX0() {
+	CAN1.handleInterrupt()
+}
+
+// Flash is written in 16-bit half words.
+const flashWriteBlockSize = 2
+
+// flashEraseBlockSize returns the size of a flash page. Medium density devices
+// (up to 128kB of flash) have 1kB pages.
+func flashEraseBlockSize() uintptr {
+	return 1024
+}
+
+// writeFlash writes p to flash at the given address.
+func writeFlash(addr uintptr, p []byte) error {
+	unlockFlash()
+	defer lockFlash()
+	waitForFlash()
+	stm32.FLASH.SR.Set(stm32.FLASH_SR_PGERR | stm32.FLASH_SR_WRPRTERR | stm32.FLASH_SR_EOP)
+	stm32.FLASH.CR.SetBits(stm32.FLASH_CR_PG)
+	for i := 0; i < len(p); i += 2 {
+		volatile.StoreUint16((*uint16)(unsafe.Pointer(addr+uintptr(i))), uint16(p[i])|uint16(p[i+1])<<8)
+		waitForFlash()
+	}
+	stm32.FLASH.CR.ClearBits(stm32.FLASH_CR_PG)
+	if stm32.FLASH.SR.HasBits(stm32.FLASH_SR_PGERR | stm32.FLASH_SR_WRPRTERR) {
+		return ErrFlashFailed
+	}
+	return nil
+}
+
+// eraseFlashBlock erases the flash page at the given address.
+func eraseFlashBlock(addr uintptr) error {
+	unlockFlash()
+	defer lockFlash()
+	waitForFlash()
+	stm32.FLASH.SR.Set(stm32.FLASH_SR_PGERR | stm32.FLASH_SR_WRPRTERR | stm32.FLASH_SR_EOP)
+	stm32.FLASH.CR.SetBits(stm32.FLASH_CR_PER)
+	stm32.FLASH.AR.Set(uint32(addr))
+	stm32.FLASH.CR.SetBits(stm32.FLASH_CR_STRT)
+	waitForFlash()
+	stm32.FLASH.CR.ClearBits(stm32.FLASH_CR_PER)
+	if stm32.FLASH.SR.HasBits(stm32.FLASH_SR_WRPRTERR) {
+		return ErrFlashFailed
+	}
+	return nil
+}
+
+// Capabilities returns the electrical capabilities of this pin. Most pins are
+// 5V tolerant, except for the pins that can be used as ADC input and a few
+// others. No pins support high drive.
+func (p Pin) Capabilities() PinCapabilities {
+	if p == NoPin {
+		return 0
+	}
+	port, pin := p/16, p%16
+	switch {
+	case port == 0 && pin <= 7, port == 1 && pin <= 1, port == 2 && pin <= 5:
+		// PA0-PA7, PB0-PB1 and PC0-PC5 are connected to ADC1 and ADC2.
+		return PinAnalogCapable
+	case port == 1 && pin == 5, port == 2 && pin >= 13:
+		// PB5 and PC13-PC15 are not 5V tolerant either.
+		return 0
+	default:
+		return PinFiveVoltTolerant
+	}
+}
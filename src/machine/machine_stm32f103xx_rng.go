@@ -0,0 +1,31 @@
+// +build stm32,stm32f103xx
+
+package machine
+
+import (
+	"device/stm32"
+)
+
+// The STM32F103 has no RNG peripheral. Random data is gathered from the noise
+// of the internal temperature sensor, read with ADC1 (channel 16).
+
+var rngADCInitialized bool
+
+// GetRNG returns 32 bits of random data gathered from ADC noise. It is much
+// slower than a hardware random number generator.
+func GetRNG() (uint32, error) {
+	if !rngADCInitialized {
+		stm32.RCC.APB2ENR.SetBits(stm32.RCC_APB2ENR_ADC1EN)
+		stm32.ADC1.CR2.SetBits(stm32.ADC_CR2_ADON | stm32.ADC_CR2_TSVREFE)
+		// Use the shortest sample time, which gives the most noise.
+		stm32.ADC1.SMPR1.ClearBits(stm32.ADC_SMPR1_SMP16_Msk)
+		rngADCInitialized = true
+	}
+	stm32.ADC1.SQR3.Set(16)
+	return adcNoise(func() bool {
+		stm32.ADC1.CR2.SetBits(stm32.ADC_CR2_ADON)
+		for !stm32.ADC1.SR.HasBits(stm32.ADC_SR_EOC) {
+		}
+		return stm32.ADC1.DR.Get()&1 != 0
+	})
+}
@@ -0,0 +1,294 @@
+// +build stm32,stm32f103xx
+
+package machine
+
+// Interrupt driven SPI and I2C transfers for the STM32F103xx.
+
+import (
+	"device/arm"
+	"device/stm32"
+)
+
+// The transfers that are currently in progress on SPI1 and I2C1.
+var (
+	spi1Transfer *Transfer
+	i2c1Transfer *Transfer
+)
+
+// StartTx starts an asynchronous SPI transfer and returns immediately. It
+// accepts the same buffers as Tx. Use t.Done, t.Wait or t.Callback to find out
+// when the transfer has finished.
+func (spi SPI) StartTx(t *Transfer, w, r []byte) error {
+	if w == nil && r == nil {
+		return ErrTxSlicesRequired
+	}
+	if w != nil && r != nil && len(w) != len(r) {
+		return ErrTxInvalidSliceSize
+	}
+	if transferBusy(spi1Transfer) {
+		return ErrTransferBusy
+	}
+	t.start(w, r)
+	if len(w) == 0 && len(r) == 0 {
+		t.finish(nil)
+		return nil
+	}
+	spi1Transfer = t
+
+	// Drain a stale received byte, so that the first RXNE interrupt belongs
+	// to the first transmitted byte.
+	spi.Bus.DR.Get()
+
+	arm.SetPriority(stm32.IRQ_SPI1, 0xc0)
+	arm.EnableIRQ(stm32.IRQ_SPI1)
+	spi.Bus.CR2.SetBits(stm32.SPI_CR2_RXNEIE)
+	spi.Bus.DR.Set(uint32(t.nextSPIByte()))
+	return nil
+}
+
+// nextSPIByte returns the byte to send for the current position.
+func (t *Transfer) nextSPIByte() byte {
+	if t.w == nil {
+		return 0
+	}
+	return t.w[t.wpos]
+}
+
+// handleTransferInterrupt is called on every received byte. Only a single
+// byte is in flight at any time, so no data can be lost.
+func (spi SPI) handleTransferInterrupt() {
+	t := spi1Transfer
+	value := byte(spi.Bus.DR.Get())
+	if t == nil || t.Done() {
+		spi.Bus.CR2.ClearBits(stm32.SPI_CR2_RXNEIE)
+		return
+	}
+	if t.r != nil {
+		t.r[t.rpos] = value
+	}
+	t.rpos++
+	t.wpos++
+	length := len(t.r)
+	if t.r == nil {
+		length = len(t.w)
+	}
+	if t.rpos < length {
+		spi.Bus.DR.Set(uint32(t.nextSPIByte()))
+		return
+	}
+	spi.Bus.CR2.ClearBits(stm32.SPI_CR2_RXNEIE)
+	t.finish(nil)
+}
+
+//go:export SPI1_IRQHandler
+func handleSPI1() {
+	SPI1.handleTransferInterrupt()
+}
+
+// States of an I2C transfer.
+const (
+	i2cStateWrite       = iota // writing the bytes in w
+	i2cStateReadAddress        // waiting for the read address to be acknowledged
+	i2cStateRead               // reading the bytes in r
+)
+
+// StartTx starts an asynchronous I2C transaction and returns immediately. It
+// writes the bytes in w and then reads len(r) bytes into r after a repeated
+// start condition. Use t.Done, t.Wait or t.Callback to find out when the
+// transaction has finished.
+//
+// The blocking Tx method must not be used while an asynchronous transaction is
+// in progress.
+func (i2c I2C) StartTx(t *Transfer, addr uint16, w, r []byte) error {
+	if transferBusy(i2c1Transfer) {
+		return ErrTransferBusy
+	}
+	if i2c.Bus.SR2.HasBits(stm32.I2C_SR2_BUSY) {
+		return ErrTransferBusy
+	}
+	t.start(w, r)
+	t.addr = uint8(addr)
+	if len(w) == 0 && len(r) == 0 {
+		t.finish(nil)
+		return nil
+	}
+	t.state = i2cStateWrite
+	if len(w) == 0 {
+		t.state = i2cStateReadAddress
+	}
+	i2c1Transfer = t
+
+	arm.SetPriority(stm32.IRQ_I2C1_EV, 0xc0)
+	arm.EnableIRQ(stm32.IRQ_I2C1_EV)
+	arm.SetPriority(stm32.IRQ_I2C1_ER, 0xc0)
+	arm.EnableIRQ(stm32.IRQ_I2C1_ER)
+	i2c.Bus.CR2.SetBits(stm32.I2C_CR2_ITEVTEN | stm32.I2C_CR2_ITBUFEN | stm32.I2C_CR2_ITERREN)
+	i2c.Bus.CR1.ClearBits(stm32.I2C_CR1_STOP | stm32.I2C_CR1_POS)
+	i2c.Bus.CR1.SetBits(stm32.I2C_CR1_START)
+	return nil
+}
+
+// handleEventInterrupt advances the I2C state machine. It follows the event
+// sequences from the reference manual (RM0008), including the special cases
+// for reading one or two bytes.
+func (i2c I2C) handleEventInterrupt() {
+	t := i2c1Transfer
+	if t == nil || t.Done() {
+		i2c.Bus.CR2.ClearBits(stm32.I2C_CR2_ITEVTEN | stm32.I2C_CR2_ITBUFEN | stm32.I2C_CR2_ITERREN)
+		return
+	}
+	status := i2c.Bus.SR1.Get()
+
+	// EV5: the start condition was sent, send the address.
+	if status&stm32.I2C_SR1_SB != 0 {
+		if t.state == i2cStateWrite {
+			i2c.Bus.DR.Set(uint32(t.addr) << 1)
+			return
+		}
+		switch len(t.r) {
+		case 1:
+			i2c.Bus.CR1.ClearBits(stm32.I2C_CR1_ACK)
+		case 2:
+			i2c.Bus.CR1.SetBits(stm32.I2C_CR1_POS | stm32.I2C_CR1_ACK)
+		default:
+			i2c.Bus.CR1.SetBits(stm32.I2C_CR1_ACK)
+		}
+		i2c.Bus.DR.Set(uint32(t.addr)<<1 | 1)
+		return
+	}
+
+	// EV6: the address was acknowledged.
+	if status&stm32.I2C_SR1_ADDR != 0 {
+		if t.state == i2cStateReadAddress {
+			t.state = i2cStateRead
+			switch len(t.r) {
+			case 1:
+				i2c.Bus.SR2.Get() // clear ADDR
+				i2c.Bus.CR1.SetBits(stm32.I2C_CR1_STOP)
+				return
+			case 2:
+				i2c.Bus.SR2.Get() // clear ADDR
+				i2c.Bus.CR1.ClearBits(stm32.I2C_CR1_ACK)
+				// Wait for BTF, when both bytes have been received.
+				i2c.Bus.CR2.ClearBits(stm32.I2C_CR2_ITBUFEN)
+				return
+			case 3:
+				// Wait for BTF, see below.
+				i2c.Bus.CR2.ClearBits(stm32.I2C_CR2_ITBUFEN)
+			}
+		}
+		i2c.Bus.SR2.Get() // clear ADDR
+		return
+	}
+
+	if t.state == i2cStateWrite {
+		// EV8: the data register is empty.
+		if t.wpos < len(t.w) {
+			if status&stm32.I2C_SR1_TxE != 0 {
+				i2c.Bus.DR.Set(uint32(t.w[t.wpos]))
+				t.wpos++
+				if t.wpos == len(t.w) {
+					// Wait for BTF, when the last byte has been sent.
+					i2c.Bus.CR2.ClearBits(stm32.I2C_CR2_ITBUFEN)
+				}
+			}
+			return
+		}
+		// EV8_2: all bytes have been sent.
+		if status&stm32.I2C_SR1_BTF != 0 {
+			if len(t.r) == 0 {
+				i2c.Bus.CR1.SetBits(stm32.I2C_CR1_STOP)
+				i2c.finishTransfer(t, nil)
+				return
+			}
+			t.state = i2cStateReadAddress
+			i2c.Bus.CR2.SetBits(stm32.I2C_CR2_ITBUFEN)
+			i2c.Bus.CR1.SetBits(stm32.I2C_CR1_START)
+		}
+		return
+	}
+	if t.state == i2cStateReadAddress {
+		// BTF of the last written byte may still be set until the repeated
+		// start condition has been sent.
+		return
+	}
+
+	// Reading. The last three bytes are read on BTF (with the interrupt on
+	// RXNE disabled) so that the NACK and the stop condition are generated at
+	// the right moment.
+	remaining := len(t.r) - t.rpos
+	switch {
+	case remaining == 1:
+		// Single byte read: the stop condition was already requested.
+		if status&stm32.I2C_SR1_RxNE != 0 {
+			t.r[t.rpos] = byte(i2c.Bus.DR.Get())
+			t.rpos++
+			i2c.finishTransfer(t, nil)
+		}
+	case remaining == 2:
+		if status&stm32.I2C_SR1_BTF != 0 {
+			i2c.Bus.CR1.SetBits(stm32.I2C_CR1_STOP)
+			t.r[t.rpos] = byte(i2c.Bus.DR.Get())
+			t.r[t.rpos+1] = byte(i2c.Bus.DR.Get())
+			t.rpos += 2
+			i2c.Bus.CR1.ClearBits(stm32.I2C_CR1_POS)
+			i2c.finishTransfer(t, nil)
+		}
+	case remaining == 3:
+		if status&stm32.I2C_SR1_BTF != 0 {
+			i2c.Bus.CR1.ClearBits(stm32.I2C_CR1_ACK)
+			t.r[t.rpos] = byte(i2c.Bus.DR.Get())
+			t.rpos++
+			// The remaining two bytes are read on the next BTF.
+		}
+	default:
+		if status&stm32.I2C_SR1_RxNE != 0 {
+			t.r[t.rpos] = byte(i2c.Bus.DR.Get())
+			t.rpos++
+			if len(t.r)-t.rpos == 3 {
+				i2c.Bus.CR2.ClearBits(stm32.I2C_CR2_ITBUFEN)
+			}
+		}
+	}
+}
+
+// handleErrorInterrupt aborts the transfer on a bus error.
+func (i2c I2C) handleErrorInterrupt() {
+	status := i2c.Bus.SR1.Get()
+	i2c.Bus.SR1.ClearBits(stm32.I2C_SR1_AF | stm32.I2C_SR1_BERR | stm32.I2C_SR1_ARLO | stm32.I2C_SR1_OVR)
+	t := i2c1Transfer
+	if t == nil || t.Done() {
+		return
+	}
+	var err error
+	switch {
+	case status&stm32.I2C_SR1_AF != 0:
+		err = ErrI2CNoAck
+	case status&stm32.I2C_SR1_ARLO != 0:
+		// The bus was released by the hardware, don't send a stop condition.
+		i2c.finishTransfer(t, ErrI2CArbitrationLost)
+		return
+	default:
+		err = ErrI2CBusError
+	}
+	i2c.Bus.CR1.SetBits(stm32.I2C_CR1_STOP)
+	i2c.finishTransfer(t, err)
+}
+
+// finishTransfer disables the I2C interrupts and completes the transfer.
+func (i2c I2C) finishTransfer(t *Transfer, err error) {
+	i2c.Bus.CR2.ClearBits(stm32.I2C_CR2_ITEVTEN | stm32.I2C_CR2_ITBUFEN | stm32.I2C_CR2_ITERREN)
+	i2c.Bus.CR1.ClearBits(stm32.I2C_CR1_POS)
+	i2c.Bus.CR1.SetBits(stm32.I2C_CR1_ACK)
+	t.finish(err)
+}
+
+//go:export I2C1_EV_IRQHandler
+func handleI2C1Event() {
+	I2C1.handleEventInterrupt()
+}
+
+//go:export I2C1_ER_IRQHandler
+func handleI2C1Error() {
+	I2C1.handleErrorInterrupt()
+}
@@ -7,10 +7,23 @@ package machine
 import (
 	"device/arm"
 	"device/stm32"
+	"runtime/volatile"
+	"unsafe"
 )
 
 const CPU_FREQUENCY = 168000000
 
+// The APB1 bus runs at a quarter of the CPU frequency, but the timers on it
+// are clocked at twice the bus frequency.
+const timerClock = CPU_FREQUENCY / 2
+
+// Nominal frequency of the internal low speed oscillator, used by the
+// independent watchdog.
+const lsiFrequency = 32000
+
+// Frequency of the APB1 bus, which clocks the CAN controllers.
+const apb1Clock = CPU_FREQUENCY / 4
+
 const (
 	// Mode Flag
 	PinOutput        PinMode = 0
@@ -23,6 +36,13 @@ const (
 	PinModeUartTX PinMode = 4
 	PinModeUartRX PinMode = 5
 
+	// for CAN
+	PinModeCANTX PinMode = 6
+	PinModeCANRX PinMode = 7
+
+	// for SDIO
+	PinModeSDIO PinMode = 8
+
 	//GPIOx_MODER
 	GPIO_MODE_INPUT          = 0
 	GPIO_MODE_GENERAL_OUTPUT = 1
@@ -125,13 +145,26 @@ func (p Pin) Configure(config PinConfig) {
 		port.MODER.Set((uint32(port.MODER.Get())&^(0x3<<pos) | (uint32(GPIO_MODE_ALTERNABTIVE) << pos)))
 		port.PUPDR.Set((uint32(port.PUPDR.Get())&^(0x3<<pos) | (uint32(GPIO_FLOATING) << pos)))
 		p.setAltFunc(0x7)
+	} else if config.Mode == PinModeCANTX {
+		port.MODER.Set((uint32(port.MODER.Get())&^(0x3<<pos) | (uint32(GPIO_MODE_ALTERNABTIVE) << pos)))
+		port.OSPEEDR.Set((uint32(port.OSPEEDR.Get())&^(0x3<<pos) | (uint32(GPIO_SPEED_HI) << pos)))
+		p.setAltFunc(0x9)
+	} else if config.Mode == PinModeCANRX {
+		port.MODER.Set((uint32(port.MODER.Get())&^(0x3<<pos) | (uint32(GPIO_MODE_ALTERNABTIVE) << pos)))
+		port.PUPDR.Set((uint32(port.PUPDR.Get())&^(0x3<<pos) | (uint32(GPIO_PULL_UP) << pos)))
+		p.setAltFunc(0x9)
+	} else if config.Mode == PinModeSDIO {
+		port.MODER.Set((uint32(port.MODER.Get())&^(0x3<<pos) | (uint32(GPIO_MODE_ALTERNABTIVE) << pos)))
+		port.OSPEEDR.Set((uint32(port.OSPEEDR.Get())&^(0x3<<pos) | (uint32(GPIO_SPEED_HI) << pos)))
+		port.PUPDR.Set((uint32(port.PUPDR.Get())&^(0x3<<pos) | (uint32(GPIO_PULL_UP) << pos)))
+		p.setAltFunc(0xc)
 	}
 }
 
 func (p Pin) setAltFunc(af uint32) {
 	port := p.getPort()
 	pin := uint8(p) % 16
-	pos := pin * 4
+	pos := (pin % 8) * 4
 	if pin >= 8 {
 		port.AFRH.Set(uint32(port.AFRH.Get())&^(0xF<<pos) | ((af & 0xF) << pos))
 	} else {
@@ -139,6 +172,40 @@ func (p Pin) setAltFunc(af uint32) {
 	}
 }
 
+// Configure the DAC. There are two DAC channels, on PA4 and PA5.
+func (dac DAC) Configure(config DACConfig) {
+	var enable uint32
+	switch dac.Pin {
+	case PA4:
+		enable = stm32.DAC_CR_EN1
+	case PA5:
+		enable = stm32.DAC_CR_EN2
+	default:
+		return // no DAC on this pin
+	}
+
+	// Put the pin in analog mode, to avoid parasitic power consumption.
+	dac.Pin.enableClock()
+	port := dac.Pin.getPort()
+	pos := (uint8(dac.Pin) % 16) * 2
+	port.MODER.Set((uint32(port.MODER.Get())&^(0x3<<pos) | (uint32(GPIO_MODE_ANALOG) << pos)))
+
+	// Enable the DAC clock and the channel.
+	stm32.RCC.APB1ENR.SetBits(stm32.RCC_APB1ENR_DACEN)
+	stm32.DAC.CR.SetBits(enable)
+}
+
+// Set the output of the DAC to the given value, in the range 0..0xffff. The
+// DAC has a resolution of 12 bits so the lower 4 bits are ignored.
+func (dac DAC) Set(value uint16) {
+	switch dac.Pin {
+	case PA4:
+		stm32.DAC.DHR12L1.Set(uint32(value))
+	case PA5:
+		stm32.DAC.DHR12L2.Set(uint32(value))
+	}
+}
+
 // Set the pin to high or low.
 // Warning: only use this on an output pin!
 func (p Pin) Set(high bool) {
@@ -218,3 +285,119 @@ func (uart UART) WriteByte(c byte) error {
 func handleUSART2() {
 	UART1.Receive(byte((stm32.USART2.DR.Get() & 0xFF)))
 }
+
+// setEXTIPort connects the given EXTI line to a GPIO port (0 for port A, 1 for
+// port B, etc.) using the SYSCFG external interrupt configuration registers.
+func setEXTIPort(line uint8, port uint32) {
+	stm32.RCC.APB2ENR.SetBits(stm32.RCC_APB2ENR_SYSCFGEN)
+	pos := uint32(line%4) * 4
+	var reg *volatile.Register32
+	switch line / 4 {
+	case 0:
+		reg = &stm32.SYSCFG.EXTICR1
+	case 1:
+		reg = &stm32.SYSCFG.EXTICR2
+	case 2:
+		reg = &stm32.SYSCFG.EXTICR3
+	default:
+		reg = &stm32.SYSCFG.EXTICR4
+	}
+	reg.Set(reg.Get()&^(0xf<<pos) | port<<pos)
+}
+
+// CAN1 receive FIFO 0 interrupt.
+const canRxIRQ = stm32.IRQ_CAN1_RX0
+
+// configureCANPins configures the pins used by CAN1: PB8/PB9 or the default
+// PA12/PA11.
+func configureCANPins(tx, rx Pin) {
+	switch tx {
+	case PB9:
+		PB9.Configure(PinConfig{Mode: PinModeCANTX})
+		PB8.Configure(PinConfig{Mode: PinModeCANRX})
+	default:
+		PA12.Configure(PinConfig{Mode: PinModeCANTX})
+		PA11.Configure(PinConfig{Mode: PinModeCANRX})
+	}
+}
+
+// enableCANClock enables the peripheral clock of CAN1.
+func enableCANClock() {
+	stm32.RCC.APB1ENR.SetBits(stm32.RCC_APB1ENR_CAN1EN)
+}
+
+//go:export CAN1_RX0_IRQHandler
+func handleCAN1RX0() {
+	CAN1.handleInterrupt()
+}
+
+// Flash is written in 32-bit words. This requires a supply voltage of at least
+// 2.7V.
+const flashWriteBlockSize = 4
+
+// flashEraseBlockSize returns the size of the flash sectors that are used for
+// data. The first sectors are smaller (16kB and 64kB), but all sectors from
+// 128kB onwards are 128kB in size. Only those sectors are used, to keep the
+// erase block size uniform.
+func flashEraseBlockSize() uintptr {
+	return 128 * 1024
+}
+
+// writeFlash writes p to flash at the given address.
+func writeFlash(addr uintptr, p []byte) error {
+	unlockFlash()
+	defer lockFlash()
+	waitForFlash()
+	stm32.FLASH.SR.Set(flashErrors | stm32.FLASH_SR_EOP)
+	stm32.FLASH.CR.Set(2<<stm32.FLASH_CR_PSIZE_Pos | stm32.FLASH_CR_PG) // 32-bit parallelism
+	for i := 0; i < len(p); i += 4 {
+		word := uint32(p[i]) | uint32(p[i+1])<<8 | uint32(p[i+2])<<16 | uint32(p[i+3])<<24
+		volatile.StoreUint32((*uint32)(unsafe.Pointer(addr+uintptr(i))), word)
+		waitForFlash()
+	}
+	stm32.FLASH.CR.ClearBits(stm32.FLASH_CR_PG)
+	if stm32.FLASH.SR.HasBits(flashErrors) {
+		return ErrFlashFailed
+	}
+	return nil
+}
+
+// eraseFlashBlock erases the 128kB flash sector at the given address.
+func eraseFlashBlock(addr uintptr) error {
+	// Sectors 5 and up are 128kB in size and start at 128kB.
+	sector := uint32((addr-0x08000000)/(128*1024)) + 4
+	unlockFlash()
+	defer lockFlash()
+	waitForFlash()
+	stm32.FLASH.SR.Set(flashErrors | stm32.FLASH_SR_EOP)
+	stm32.FLASH.CR.Set(2<<stm32.FLASH_CR_PSIZE_Pos | sector<<stm32.FLASH_CR_SNB_Pos | stm32.FLASH_CR_SER)
+	stm32.FLASH.CR.SetBits(stm32.FLASH_CR_STRT)
+	waitForFlash()
+	stm32.FLASH.CR.ClearBits(stm32.FLASH_CR_SER)
+	if stm32.FLASH.SR.HasBits(flashErrors) {
+		return ErrFlashFailed
+	}
+	return nil
+}
+
+// Error flags in the flash status register.
+const flashErrors = stm32.FLASH_SR_PGSERR | stm32.FLASH_SR_PGPERR | stm32.FLASH_SR_PGAERR | stm32.FLASH_SR_WRPERR
+
+// Capabilities returns the electrical capabilities of this pin. The pins of
+// ADC1-ADC3 are reported as analog capable. Many pins are 5V tolerant, but as
+// the exact set depends on the package this is not reported for any pin.
+func (p Pin) Capabilities() PinCapabilities {
+	if p == NoPin {
+		return 0
+	}
+	port, pin := p/16, p%16
+	switch {
+	case port == 0 && pin <= 7, port == 1 && pin <= 1, port == 2 && pin <= 5:
+		return PinAnalogCapable
+	case port == 5 && pin >= 3 && pin <= 10:
+		// PF3-PF10 are only connected to ADC3.
+		return PinAnalogCapable
+	default:
+		return 0
+	}
+}
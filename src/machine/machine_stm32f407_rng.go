@@ -0,0 +1,34 @@
+// +build stm32,stm32f407
+
+package machine
+
+import (
+	"device/stm32"
+)
+
+// GetRNG returns 32 bits of random data from the RNG peripheral. It is clocked
+// from the 48MHz PLL output, which is configured by the runtime.
+func GetRNG() (uint32, error) {
+	if !stm32.RNG.CR.HasBits(stm32.RNG_CR_RNGEN) {
+		stm32.RCC.AHB2ENR.SetBits(stm32.RCC_AHB2ENR_RNGEN)
+		stm32.RNG.CR.SetBits(stm32.RNG_CR_RNGEN)
+	}
+	for {
+		status := stm32.RNG.SR.Get()
+		if status&stm32.RNG_SR_CECS != 0 {
+			// The RNG clock is too slow.
+			return 0, ErrRNGFailed
+		}
+		if status&stm32.RNG_SR_SECS != 0 {
+			// Seed error: restart the RNG, as described in the reference
+			// manual.
+			stm32.RNG.SR.ClearBits(stm32.RNG_SR_SEIS)
+			stm32.RNG.CR.ClearBits(stm32.RNG_CR_RNGEN)
+			stm32.RNG.CR.SetBits(stm32.RNG_CR_RNGEN)
+			continue
+		}
+		if status&stm32.RNG_SR_DRDY != 0 {
+			return stm32.RNG.DR.Get(), nil
+		}
+	}
+}
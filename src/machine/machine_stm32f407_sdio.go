@@ -0,0 +1,349 @@
+// +build stm32,stm32f407
+
+package machine
+
+// SD card support using the native SDIO controller, in 4-bit mode. This is a
+// lot faster than accessing the card in SPI mode. The pins are fixed: PC8-PC11
+// for the data lines, PC12 for the clock and PD2 for the command line.
+
+import (
+	"device/stm32"
+	"errors"
+)
+
+var (
+	ErrSDCardNoCard      = errors.New("machine: no SD card detected")
+	ErrSDCardUnsupported = errors.New("machine: unsupported SD card")
+	ErrSDCardTimeout     = errors.New("machine: SD card timeout")
+	ErrSDCardCRC         = errors.New("machine: SD card CRC error")
+	ErrSDCardTransfer    = errors.New("machine: SD card data transfer error")
+	ErrSDCardBufferSize  = errors.New("machine: SD card buffer is not a multiple of the block size")
+)
+
+// SDCardBlockSize is the size of a single block on the SD card. All reads and
+// writes are done in whole blocks.
+const SDCardBlockSize = 512
+
+// The SDIO controller is clocked from the 48MHz PLL output.
+const sdioClock = 48000000
+
+// Clock frequency used while identifying the card, which must be at most
+// 400kHz.
+const sdioInitFrequency = 400000
+
+// Number of status register polls before a command or transfer times out.
+const sdioTimeout = 0x100000
+
+// Data timeout in card clock cycles, about 0.7s at 24MHz.
+const sdioDataTimeout = 0x1000000
+
+// All static flags in the status register, cleared before each command.
+const sdioStaticFlags = 0x00c007ff
+
+// SDCardConfig is the configuration of the SD card interface.
+type SDCardConfig struct {
+	Frequency uint32 // clock frequency for data transfer, defaults to 24MHz
+}
+
+// SDCard is an SD card connected to the SDIO controller.
+type SDCard struct {
+	Bus          *stm32.SDIO_Type
+	rca          uint32 // relative card address, in the upper 16 bits
+	highCapacity bool   // SDHC/SDXC card, addressed in blocks instead of bytes
+	blocks       uint32
+}
+
+// SDIO is the SD card on the SDIO controller.
+var SDIO = &SDCard{Bus: stm32.SDIO}
+
+// Response types. The lower two bits are the WAITRESP field of the command
+// register.
+const (
+	sdioResponseNone       = 0
+	sdioResponseShort      = 1
+	sdioResponseShortNoCRC = 1 | 0x10 // R3 responses have no valid CRC
+	sdioResponseLong       = 3
+)
+
+// Configure initializes the SDIO controller and the card, and switches the
+// card to 4-bit mode at the configured frequency.
+func (sd *SDCard) Configure(config SDCardConfig) error {
+	if config.Frequency == 0 {
+		config.Frequency = 24000000
+	}
+
+	for _, pin := range []Pin{PC8, PC9, PC10, PC11, PC12, PD2} {
+		pin.Configure(PinConfig{Mode: PinModeSDIO})
+	}
+	stm32.RCC.APB2ENR.SetBits(stm32.RCC_APB2ENR_SDIOEN)
+
+	// Power on the card clock at the identification frequency. The card needs
+	// at least 74 clock cycles before the first command.
+	sd.Bus.POWER.Set(3 << stm32.SDIO_POWER_PWRCTRL_Pos)
+	sd.Bus.CLKCR.Set(sdioClockDivider(sdioInitFrequency) | stm32.SDIO_CLKCR_CLKEN)
+	for i := 0; i < 0x4000; i++ {
+		sd.Bus.POWER.Get()
+	}
+
+	// Reset the card.
+	if err := sd.command(0, 0, sdioResponseNone); err != nil {
+		return err
+	}
+
+	// Only version 2 cards respond to CMD8, and only those can be high
+	// capacity cards.
+	var hcs uint32
+	if err := sd.command(8, 0x1aa, sdioResponseShort); err == nil {
+		if sd.Bus.RESP1.Get()&0xfff != 0x1aa {
+			return ErrSDCardUnsupported
+		}
+		hcs = 1 << 30
+	}
+
+	// Wait until the card has finished its power up procedure, offering the
+	// 3.2-3.4V voltage range.
+	ready := false
+	for i := 0; i < 10000; i++ {
+		if err := sd.command(55, 0, sdioResponseShort); err != nil {
+			return ErrSDCardNoCard
+		}
+		if err := sd.command(41, 0x80100000|hcs, sdioResponseShortNoCRC); err != nil {
+			return err
+		}
+		ocr := sd.Bus.RESP1.Get()
+		if ocr&(1<<31) != 0 {
+			sd.highCapacity = ocr&(1<<30) != 0
+			ready = true
+			break
+		}
+	}
+	if !ready {
+		return ErrSDCardTimeout
+	}
+
+	// Read the card identification (not used) and get the relative card
+	// address.
+	if err := sd.command(2, 0, sdioResponseLong); err != nil {
+		return err
+	}
+	if err := sd.command(3, 0, sdioResponseShort); err != nil {
+		return err
+	}
+	sd.rca = sd.Bus.RESP1.Get() &^ 0xffff
+
+	// Read the card specific data to determine the capacity.
+	if err := sd.command(9, sd.rca, sdioResponseLong); err != nil {
+		return err
+	}
+	sd.blocks = sdioCapacity(sd.Bus.RESP1.Get(), sd.Bus.RESP2.Get(), sd.Bus.RESP3.Get())
+
+	// Select the card, use 512-byte blocks and switch to 4-bit mode.
+	if err := sd.command(7, sd.rca, sdioResponseShort); err != nil {
+		return err
+	}
+	if err := sd.command(16, SDCardBlockSize, sdioResponseShort); err != nil {
+		return err
+	}
+	if err := sd.command(55, sd.rca, sdioResponseShort); err != nil {
+		return err
+	}
+	if err := sd.command(6, 2, sdioResponseShort); err != nil {
+		return err
+	}
+
+	// Switch to the data transfer frequency. Hardware flow control stops the
+	// clock when the FIFO is full or empty, so that the FIFO can be serviced
+	// without DMA.
+	sd.Bus.CLKCR.Set(sdioClockDivider(config.Frequency) | 1<<stm32.SDIO_CLKCR_WIDBUS_Pos | stm32.SDIO_CLKCR_HWFC_EN | stm32.SDIO_CLKCR_CLKEN)
+	return nil
+}
+
+// sdioClockDivider returns the clock divider field for the highest frequency
+// that does not exceed the given frequency.
+func sdioClockDivider(frequency uint32) uint32 {
+	div := (sdioClock + frequency - 1) / frequency
+	if div < 2 {
+		div = 2
+	}
+	if div-2 > 0xff {
+		return 0xff
+	}
+	return div - 2
+}
+
+// sdioCapacity returns the number of blocks on the card, from the upper 96
+// bits of the CSD register.
+func sdioCapacity(csd3, csd2, csd1 uint32) uint32 {
+	if csd3>>30 == 1 {
+		// CSD version 2.0 (SDHC/SDXC): capacity is (C_SIZE+1) * 512kB.
+		cSize := (csd2&0x3f)<<16 | csd1>>16
+		return (cSize + 1) * 1024
+	}
+	// CSD version 1.0 (SDSC).
+	readBlLen := (csd2 >> 16) & 0xf
+	cSize := (csd2&0x3ff)<<2 | csd1>>30
+	cSizeMult := (csd1 >> 15) & 0x7
+	return (cSize + 1) << (cSizeMult + 2 + readBlLen - 9)
+}
+
+// command sends a command to the card and waits for the response.
+func (sd *SDCard) command(index uint8, arg uint32, response uint32) error {
+	sd.Bus.ICR.Set(sdioStaticFlags)
+	sd.Bus.ARG.Set(arg)
+	sd.Bus.CMD.Set(uint32(index) | (response&0x3)<<stm32.SDIO_CMD_WAITRESP_Pos | stm32.SDIO_CMD_CPSMEN)
+	for i := 0; i < sdioTimeout; i++ {
+		status := sd.Bus.STA.Get()
+		if response == sdioResponseNone {
+			if status&stm32.SDIO_STA_CMDSENT != 0 {
+				return nil
+			}
+			continue
+		}
+		if status&stm32.SDIO_STA_CTIMEOUT != 0 {
+			return ErrSDCardTimeout
+		}
+		if status&stm32.SDIO_STA_CCRCFAIL != 0 {
+			if response == sdioResponseShortNoCRC {
+				return nil
+			}
+			return ErrSDCardCRC
+		}
+		if status&stm32.SDIO_STA_CMDREND != 0 {
+			return nil
+		}
+	}
+	return ErrSDCardTimeout
+}
+
+// Size returns the capacity of the card in bytes.
+func (sd *SDCard) Size() int64 {
+	return int64(sd.blocks) * SDCardBlockSize
+}
+
+// BlockCount returns the number of blocks on the card.
+func (sd *SDCard) BlockCount() uint32 {
+	return sd.blocks
+}
+
+// address returns the command argument for the given block.
+func (sd *SDCard) address(block uint32) uint32 {
+	if sd.highCapacity {
+		return block
+	}
+	return block * SDCardBlockSize
+}
+
+// ReadBlocks reads consecutive blocks starting at the given block into buf. The
+// length of buf must be a multiple of SDCardBlockSize.
+func (sd *SDCard) ReadBlocks(block uint32, buf []byte) error {
+	if len(buf) == 0 || len(buf)%SDCardBlockSize != 0 {
+		return ErrSDCardBufferSize
+	}
+	multiple := len(buf) > SDCardBlockSize
+
+	sd.Bus.DTIMER.Set(sdioDataTimeout)
+	sd.Bus.DLEN.Set(uint32(len(buf)))
+	sd.Bus.DCTRL.Set(9<<stm32.SDIO_DCTRL_DBLOCKSIZE_Pos | stm32.SDIO_DCTRL_DTDIR | stm32.SDIO_DCTRL_DTEN)
+	cmd := uint8(17)
+	if multiple {
+		cmd = 18
+	}
+	if err := sd.command(cmd, sd.address(block), sdioResponseShort); err != nil {
+		sd.Bus.DCTRL.Set(0)
+		return err
+	}
+
+	var err error
+	i := 0
+	for {
+		status := sd.Bus.STA.Get()
+		if status&stm32.SDIO_STA_RXDAVL != 0 {
+			word := sd.Bus.FIFO.Get()
+			if i < len(buf) {
+				buf[i] = byte(word)
+				buf[i+1] = byte(word >> 8)
+				buf[i+2] = byte(word >> 16)
+				buf[i+3] = byte(word >> 24)
+				i += 4
+			}
+			continue
+		}
+		if status&(stm32.SDIO_STA_DCRCFAIL|stm32.SDIO_STA_DTIMEOUT|stm32.SDIO_STA_RXOVERR|stm32.SDIO_STA_STBITERR) != 0 {
+			err = ErrSDCardTransfer
+			break
+		}
+		if status&stm32.SDIO_STA_DATAEND != 0 {
+			break
+		}
+	}
+
+	if multiple {
+		if stopErr := sd.command(12, 0, sdioResponseShort); err == nil {
+			err = stopErr
+		}
+	}
+	return err
+}
+
+// WriteBlocks writes buf to consecutive blocks starting at the given block.
+// The length of buf must be a multiple of SDCardBlockSize.
+func (sd *SDCard) WriteBlocks(block uint32, buf []byte) error {
+	if len(buf) == 0 || len(buf)%SDCardBlockSize != 0 {
+		return ErrSDCardBufferSize
+	}
+	multiple := len(buf) > SDCardBlockSize
+
+	cmd := uint8(24)
+	if multiple {
+		cmd = 25
+	}
+	if err := sd.command(cmd, sd.address(block), sdioResponseShort); err != nil {
+		return err
+	}
+	sd.Bus.DTIMER.Set(sdioDataTimeout)
+	sd.Bus.DLEN.Set(uint32(len(buf)))
+	sd.Bus.DCTRL.Set(9<<stm32.SDIO_DCTRL_DBLOCKSIZE_Pos | stm32.SDIO_DCTRL_DTEN)
+
+	var err error
+	i := 0
+	for {
+		status := sd.Bus.STA.Get()
+		if i < len(buf) && status&stm32.SDIO_STA_TXFIFOF == 0 {
+			sd.Bus.FIFO.Set(uint32(buf[i]) | uint32(buf[i+1])<<8 | uint32(buf[i+2])<<16 | uint32(buf[i+3])<<24)
+			i += 4
+			continue
+		}
+		if status&(stm32.SDIO_STA_DCRCFAIL|stm32.SDIO_STA_DTIMEOUT|stm32.SDIO_STA_TXUNDERR|stm32.SDIO_STA_STBITERR) != 0 {
+			err = ErrSDCardTransfer
+			break
+		}
+		if status&stm32.SDIO_STA_DATAEND != 0 {
+			break
+		}
+	}
+
+	if multiple {
+		if stopErr := sd.command(12, 0, sdioResponseShort); err == nil {
+			err = stopErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return sd.waitReady()
+}
+
+// waitReady waits until the card has finished programming and is back in the
+// transfer state.
+func (sd *SDCard) waitReady() error {
+	for i := 0; i < sdioTimeout; i++ {
+		if err := sd.command(13, sd.rca, sdioResponseShort); err != nil {
+			return err
+		}
+		status := sd.Bus.RESP1.Get()
+		if status&(1<<8) != 0 && (status>>9)&0xf == 4 { // READY_FOR_DATA, state tran
+			return nil
+		}
+	}
+	return ErrSDCardTimeout
+}
@@ -0,0 +1,41 @@
+// +build nrf sam,atsamd21 stm32 avr,atmega
+
+package machine
+
+import (
+	"errors"
+	_ "unsafe" // for go:linkname
+)
+
+// ErrNoPinChangeChannel is returned by Pin.SetInterrupt when the pin cannot be
+// used for interrupts, or when all hardware channels are in use.
+var ErrNoPinChangeChannel = errors.New("machine: no pin change interrupt available for this pin")
+
+// PinChange is the edge (or edges) on which a pin change interrupt fires.
+type PinChange uint8
+
+const (
+	PinRising  PinChange = 1 << iota // low to high
+	PinFalling                       // high to low
+	PinToggle  = PinRising | PinFalling
+)
+
+// Debounce wraps a pin change callback so that changes that follow a previous
+// change within the given interval (in nanoseconds) are ignored. This filters
+// out the bouncing of mechanical buttons and switches. For example:
+//
+//     button.SetInterrupt(machine.PinFalling, machine.Debounce(int64(20*time.Millisecond), pressed))
+func Debounce(interval int64, callback func(Pin)) func(Pin) {
+	last := runtimeNanotime() - interval
+	return func(p Pin) {
+		now := runtimeNanotime()
+		if now-last < interval {
+			return
+		}
+		last = now
+		callback(p)
+	}
+}
+
+//go:linkname runtimeNanotime runtime.nanotime
+func runtimeNanotime() int64
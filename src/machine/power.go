@@ -0,0 +1,47 @@
+// +build nrf sam,atsamd21 stm32
+
+package machine
+
+import (
+	"device/arm"
+	"errors"
+)
+
+// ErrSleepModeNotSupported is returned by SetSleepMode when the chip cannot
+// keep the scheduler timer running in the requested sleep mode.
+var ErrSleepModeNotSupported = errors.New("machine: sleep mode not supported")
+
+// SleepMode is the depth of sleep the chip enters while all goroutines are
+// blocked, for example while waiting in time.Sleep. The scheduler timer is
+// always a wake-up source, so sleeping goroutines are woken in time no matter
+// which sleep mode is selected.
+type SleepMode uint8
+
+const (
+	// SleepModeIdle only stops the CPU clock. All peripherals keep running
+	// and any interrupt wakes the CPU. This is the default.
+	SleepModeIdle SleepMode = iota
+
+	// SleepModeDeep stops most clocks, including the clocks of many
+	// peripherals. This saves a lot more power, but peripherals like the UART
+	// or USB do not work while the chip is sleeping and waking up takes
+	// longer.
+	SleepModeDeep
+)
+
+// SetSleepMode selects the sleep mode used by the scheduler.
+func SetSleepMode(mode SleepMode) error {
+	switch mode {
+	case SleepModeIdle:
+		arm.SCB.SCR.ClearBits(arm.SCB_SCR_SLEEPDEEP)
+		return nil
+	case SleepModeDeep:
+		if !prepareDeepSleep() {
+			return ErrSleepModeNotSupported
+		}
+		arm.SCB.SCR.SetBits(arm.SCB_SCR_SLEEPDEEP)
+		return nil
+	default:
+		return ErrSleepModeNotSupported
+	}
+}
@@ -0,0 +1,37 @@
+package machine
+
+import "errors"
+
+// Random number generation. GetRNG is implemented for every chip: chips with a
+// true random number generator use it, others gather noise from the least
+// significant bit of ADC conversions.
+
+var (
+	ErrNoRNG     = errors.New("machine: no random number generator available")
+	ErrRNGFailed = errors.New("machine: random number generator failed")
+)
+
+// adcNoise collects 32 random bits from a noisy source, such as the least
+// significant bit of an ADC conversion. The bits are debiased with a von
+// Neumann extractor: pairs of equal bits are discarded. It fails if the source
+// appears to be stuck.
+func adcNoise(sample func() bool) (uint32, error) {
+	var result uint32
+	bits := 0
+	for tries := 0; tries < 32*64; tries++ {
+		a := sample()
+		b := sample()
+		if a == b {
+			continue
+		}
+		result <<= 1
+		if a {
+			result |= 1
+		}
+		bits++
+		if bits == 32 {
+			return result, nil
+		}
+	}
+	return 0, ErrRNGFailed
+}
@@ -0,0 +1,34 @@
+// +build nrf sam,atsamd21 stm32
+
+package machine
+
+import (
+	_ "unsafe" // for go:linkname
+)
+
+// RTC is the real-time clock. It is shared with the runtime, which uses it for
+// timekeeping, so setting the time also changes the value returned by
+// time.Now. The clock keeps running while the chip is sleeping. On the
+// STM32F103 it also keeps running across resets, as long as the backup domain
+// stays powered.
+var RTC = rtcImpl{}
+
+type rtcImpl struct{}
+
+// SetTime sets the current wall-clock time, in nanoseconds since the Unix
+// epoch (as returned by time.Time.UnixNano).
+func (rtc rtcImpl) SetTime(unixNano int64) {
+	runtimeSetTime(unixNano)
+}
+
+// Time returns the current wall-clock time in nanoseconds since the Unix
+// epoch. If the time hasn't been set, it returns the time since boot instead.
+func (rtc rtcImpl) Time() int64 {
+	return runtimeGetTime()
+}
+
+//go:linkname runtimeSetTime runtime.setTime
+func runtimeSetTime(unixNano int64)
+
+//go:linkname runtimeGetTime runtime.getTime
+func runtimeGetTime() int64
@@ -0,0 +1,15 @@
+// +build nrf sam,atsamd21 stm32
+
+package machine
+
+import "errors"
+
+// ErrTimerFrequency is returned when a hardware timer cannot run at the
+// requested frequency.
+var ErrTimerFrequency = errors.New("machine: timer frequency out of range")
+
+// TimerConfig is used to configure a hardware timer.
+type TimerConfig struct {
+	// Frequency at which the callback is called, in Hz.
+	Frequency uint32
+}
@@ -0,0 +1,96 @@
+// +build stm32,stm32f103xx
+
+package machine
+
+import (
+	"device/arm"
+	"errors"
+	"runtime/volatile"
+)
+
+// Asynchronous (interrupt driven) bus transfers. These are currently only
+// implemented for the STM32F103, other chips (such as the SAMD51) are not yet
+// supported by this version of TinyGo.
+
+var (
+	ErrTransferBusy       = errors.New("machine: bus transfer already in progress")
+	ErrI2CNoAck           = errors.New("machine: I2C device did not acknowledge")
+	ErrI2CBusError        = errors.New("machine: I2C bus error")
+	ErrI2CArbitrationLost = errors.New("machine: I2C arbitration lost")
+)
+
+// Transfer is an asynchronous bus transfer, started with StartTx on a bus. The
+// buffers passed to StartTx must not be accessed until the transfer is done.
+//
+// A Transfer can be reused once it is done, but only a single transfer can be
+// in progress on a bus at any time.
+type Transfer struct {
+	// Callback is called (if not nil) when the transfer is done. It is called
+	// from an interrupt, so it must not block or allocate memory.
+	Callback func(err error)
+
+	w, r  []byte
+	wpos  int
+	rpos  int
+	addr  uint8
+	state uint8
+	done  volatile.Register8
+	err   error
+}
+
+// Done returns whether the transfer has finished, either successfully or with
+// an error.
+func (t *Transfer) Done() bool {
+	return t.done.Get() != 0
+}
+
+// Err returns the error of a finished transfer, or nil on success.
+func (t *Transfer) Err() error {
+	return t.err
+}
+
+// Wait waits until the transfer is done and returns its error. The processor
+// sleeps until the next interrupt while waiting.
+func (t *Transfer) Wait() error {
+	for {
+		// Interrupts are disabled around the check, so that the interrupt
+		// that finishes the transfer can't fire between the check and the
+		// wfi instruction (which would then sleep until some other
+		// interrupt). A pending interrupt still wakes up wfi, and is handled
+		// as soon as interrupts are enabled again.
+		mask := arm.DisableInterrupts()
+		done := t.Done()
+		if !done {
+			arm.Asm("wfi")
+		}
+		arm.EnableInterrupts(mask)
+		if done {
+			return t.err
+		}
+	}
+}
+
+// start resets the transfer state for a new transfer.
+func (t *Transfer) start(w, r []byte) {
+	t.w = w
+	t.r = r
+	t.wpos = 0
+	t.rpos = 0
+	t.state = 0
+	t.err = nil
+	t.done.Set(0)
+}
+
+// finish marks the transfer as done and calls the callback.
+func (t *Transfer) finish(err error) {
+	t.err = err
+	t.done.Set(1)
+	if t.Callback != nil {
+		t.Callback(err)
+	}
+}
+
+// transferBusy returns whether the given transfer is still in progress.
+func transferBusy(t *Transfer) bool {
+	return t != nil && !t.Done()
+}
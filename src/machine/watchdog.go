@@ -0,0 +1,24 @@
+// +build nrf sam,atsamd21 stm32
+
+package machine
+
+import "errors"
+
+// ErrWatchdogTimeout is returned when the watchdog cannot be configured with
+// the requested timeout.
+var ErrWatchdogTimeout = errors.New("machine: watchdog timeout out of range")
+
+// WatchdogConfig is used to configure the hardware watchdog.
+type WatchdogConfig struct {
+	// Timeout in milliseconds. The chip is reset when the watchdog hasn't been
+	// fed for this long. The actual timeout may be slightly longer, depending
+	// on the resolution of the hardware.
+	TimeoutMillis uint32
+}
+
+// Watchdog is the hardware watchdog timer of the chip. Once started, it resets
+// the chip unless Feed is called regularly. On most chips it cannot be stopped
+// anymore after it has been started.
+var Watchdog = watchdogImpl{}
+
+type watchdogImpl struct{}
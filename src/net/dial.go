@@ -0,0 +1,192 @@
+package net
+
+import (
+	"time"
+)
+
+// A Dialer contains options for connecting to an address.
+type Dialer struct {
+	// Timeout is the maximum amount of time a dial will wait for a connect
+	// to complete. The default is no timeout.
+	Timeout time.Duration
+
+	// Deadline is the absolute point in time after which dials will fail.
+	// If both Timeout and Deadline are set, the earliest is used.
+	Deadline time.Time
+
+	// LocalAddr is the local address to use when dialing an address. It must
+	// be a *TCPAddr or *UDPAddr matching the network, or nil.
+	LocalAddr Addr
+
+	// KeepAlive specifies the interval between keepalive probes on TCP
+	// connections, if the network device supports them. If zero, probes are
+	// sent with a default period of 15 seconds. If negative, they are
+	// disabled.
+	KeepAlive time.Duration
+}
+
+// defaultKeepAlive is the keepalive period of dialed and accepted TCP
+// connections, like in the standard library.
+const defaultKeepAlive = 15 * time.Second
+
+// keepAlive returns the keepalive period to use, or 0 if disabled.
+func (d *Dialer) keepAlive() time.Duration {
+	switch {
+	case d.KeepAlive < 0:
+		return 0
+	case d.KeepAlive == 0:
+		return defaultKeepAlive
+	default:
+		return d.KeepAlive
+	}
+}
+
+// deadline returns the earliest of the Timeout and Deadline of the dialer.
+func (d *Dialer) deadline(now time.Time) time.Time {
+	deadline := d.Deadline
+	if d.Timeout != 0 {
+		timeout := now.Add(d.Timeout)
+		if deadline.IsZero() || timeout.Before(deadline) {
+			deadline = timeout
+		}
+	}
+	return deadline
+}
+
+// Dial connects to the address on the named network. Known networks are
+// "tcp", "tcp4", "udp" and "udp4". The address has the form "host:port", see
+// the standard library for details.
+func Dial(network, address string) (Conn, error) {
+	var d Dialer
+	return d.Dial(network, address)
+}
+
+// DialTimeout acts like Dial but takes a timeout.
+func DialTimeout(network, address string, timeout time.Duration) (Conn, error) {
+	d := Dialer{Timeout: timeout}
+	return d.Dial(network, address)
+}
+
+// Dial connects to the address on the named network.
+func (d *Dialer) Dial(network, address string) (Conn, error) {
+	proto, err := checkNetwork(network)
+	if err != nil {
+		return nil, &OpError{Op: "dial", Net: network, Err: err}
+	}
+	deadline := d.deadline(time.Now())
+	host, ip, port, err := resolveAddr(network, address)
+	if err != nil {
+		return nil, &OpError{Op: "dial", Net: network, Err: err}
+	}
+	if ip == nil {
+		return nil, &OpError{Op: "dial", Net: network, Err: errMissingAddr}
+	}
+	var laddr IP
+	var lport int
+	switch a := d.LocalAddr.(type) {
+	case *TCPAddr:
+		laddr, lport = a.IP, a.Port
+	case *UDPAddr:
+		laddr, lport = a.IP, a.Port
+	}
+	c, err := dialSocket(proto, laddr, lport, host, ip, port, deadline, nil)
+	if err != nil {
+		return nil, &OpError{Op: "dial", Net: network, Source: d.LocalAddr, Addr: makeAddr(proto, ip, port), Err: err}
+	}
+	if proto == ProtocolUDP {
+		return &UDPConn{conn: *c, raddr: &UDPAddr{IP: ip, Port: port}}, nil
+	}
+	if period := d.keepAlive(); period != 0 {
+		// Not all devices support keepalive, so errors are ignored.
+		c.setKeepAlive(period)
+	}
+	return &TCPConn{conn: *c, raddr: &TCPAddr{IP: ip, Port: port}}, nil
+}
+
+// dialSocket creates a socket and connects it to the given address. The TLS
+// options are only used for ProtocolTLS.
+func dialSocket(proto Protocol, laddr IP, lport int, host string, ip IP, port int, deadline time.Time, tls *TLSOptions) (*conn, error) {
+	dev, err := getNetdev()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := dev.Socket(proto)
+	if err != nil {
+		return nil, err
+	}
+	if laddr != nil || lport != 0 {
+		if err := dev.Bind(fd, laddr, lport); err != nil {
+			dev.Close(fd)
+			return nil, err
+		}
+	}
+	if tls != nil {
+		if err := setTLSOptions(dev, fd, tls); err != nil {
+			dev.Close(fd)
+			return nil, err
+		}
+	}
+	if err := dev.Connect(fd, host, ip, port, deadline); err != nil {
+		dev.Close(fd)
+		return nil, err
+	}
+	return &conn{fd: fd, dev: dev}, nil
+}
+
+// listenSocket creates a socket bound to the given local address.
+func listenSocket(proto Protocol, ip IP, port int) (*conn, error) {
+	dev, err := getNetdev()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := dev.Socket(proto)
+	if err != nil {
+		return nil, err
+	}
+	if err := dev.Bind(fd, ip, port); err != nil {
+		dev.Close(fd)
+		return nil, err
+	}
+	if proto == ProtocolTCP {
+		if err := dev.Listen(fd, 4); err != nil {
+			dev.Close(fd)
+			return nil, err
+		}
+	}
+	return &conn{fd: fd, dev: dev}, nil
+}
+
+func makeAddr(proto Protocol, ip IP, port int) Addr {
+	if proto == ProtocolUDP {
+		return &UDPAddr{IP: ip, Port: port}
+	}
+	return &TCPAddr{IP: ip, Port: port}
+}
+
+// Listen announces on the local network address. The network must be "tcp"
+// or "tcp4".
+func Listen(network, address string) (Listener, error) {
+	laddr, err := ResolveTCPAddr(network, address)
+	if err != nil {
+		return nil, &OpError{Op: "listen", Net: network, Err: err}
+	}
+	l, err := ListenTCP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ListenPacket announces on the local network address. The network must be
+// "udp" or "udp4".
+func ListenPacket(network, address string) (PacketConn, error) {
+	laddr, err := ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, &OpError{Op: "listen", Net: network, Err: err}
+	}
+	c, err := ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
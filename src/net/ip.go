@@ -0,0 +1,180 @@
+package net
+
+// IP address handling, compatible with the types in the standard library but
+// limited to IPv4 (with support for IPv4-in-IPv6 addresses).
+
+// IP address lengths (bytes).
+const (
+	IPv4len = 4
+	IPv6len = 16
+)
+
+// An IP is a single IP address, a slice of bytes. IPv4 addresses may be
+// either 4 or 16 bytes long.
+type IP []byte
+
+// An IPMask is a bitmask that can be used to manipulate IP addresses for IP
+// addressing and routing.
+type IPMask []byte
+
+var v4InV6Prefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+
+// Well-known IPv4 addresses.
+var (
+	IPv4bcast     = IPv4(255, 255, 255, 255) // limited broadcast
+	IPv4allsys    = IPv4(224, 0, 0, 1)       // all systems
+	IPv4allrouter = IPv4(224, 0, 0, 2)       // all routers
+	IPv4zero      = IPv4(0, 0, 0, 0)         // all zeros
+)
+
+// IPv4 returns the IP address (in 16-byte form) of the IPv4 address
+// a.b.c.d.
+func IPv4(a, b, c, d byte) IP {
+	p := make(IP, IPv6len)
+	copy(p, v4InV6Prefix)
+	p[12] = a
+	p[13] = b
+	p[14] = c
+	p[15] = d
+	return p
+}
+
+// IPv4Mask returns the IP mask (in 4-byte form) of the IPv4 mask a.b.c.d.
+func IPv4Mask(a, b, c, d byte) IPMask {
+	return IPMask{a, b, c, d}
+}
+
+// To4 converts the IPv4 address ip to a 4-byte representation. If ip is not
+// an IPv4 address, To4 returns nil.
+func (ip IP) To4() IP {
+	if len(ip) == IPv4len {
+		return ip
+	}
+	if len(ip) == IPv6len && string(ip[:12]) == string(v4InV6Prefix) {
+		return ip[12:16]
+	}
+	return nil
+}
+
+// Equal reports whether ip and x are the same IP address. An IPv4 address
+// and that same address in IPv6 form are considered to be equal.
+func (ip IP) Equal(x IP) bool {
+	a, b := ip.To4(), x.To4()
+	if a != nil && b != nil {
+		return string(a) == string(b)
+	}
+	return len(ip) == len(x) && string(ip) == string(x)
+}
+
+// IsUnspecified reports whether ip is an unspecified address.
+func (ip IP) IsUnspecified() bool {
+	ip4 := ip.To4()
+	return ip4 != nil && ip4[0] == 0 && ip4[1] == 0 && ip4[2] == 0 && ip4[3] == 0
+}
+
+// IsLoopback reports whether ip is a loopback address.
+func (ip IP) IsLoopback() bool {
+	ip4 := ip.To4()
+	return ip4 != nil && ip4[0] == 127
+}
+
+// IsMulticast reports whether ip is a multicast address.
+func (ip IP) IsMulticast() bool {
+	ip4 := ip.To4()
+	return ip4 != nil && ip4[0]&0xf0 == 0xe0
+}
+
+// Mask returns the result of masking the IP address ip with mask.
+func (ip IP) Mask(mask IPMask) IP {
+	ip4 := ip.To4()
+	if ip4 == nil || len(mask) != IPv4len {
+		return nil
+	}
+	out := make(IP, IPv4len)
+	for i := range out {
+		out[i] = ip4[i] & mask[i]
+	}
+	return out
+}
+
+// String returns the string form of the IP address ip, in dotted decimal
+// notation for IPv4 addresses.
+func (ip IP) String() string {
+	if len(ip) == 0 {
+		return "<nil>"
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return "?" + hexString(ip)
+	}
+	buf := make([]byte, 0, len("255.255.255.255"))
+	for i, b := range ip4 {
+		if i > 0 {
+			buf = append(buf, '.')
+		}
+		buf = appendDecimal(buf, int(b))
+	}
+	return string(buf)
+}
+
+// String returns the hexadecimal form of m, with no punctuation.
+func (m IPMask) String() string {
+	if len(m) == 0 {
+		return "<nil>"
+	}
+	return hexString(m)
+}
+
+// ParseIP parses s as an IPv4 address in dotted decimal form ("192.0.2.1")
+// and returns it in 16-byte form. It returns nil if s is not a valid IPv4
+// address; IPv6 addresses are not supported.
+func ParseIP(s string) IP {
+	var parts [IPv4len]byte
+	for i := 0; i < IPv4len; i++ {
+		if i > 0 {
+			if len(s) == 0 || s[0] != '.' {
+				return nil
+			}
+			s = s[1:]
+		}
+		n, digits, ok := parseDecimal(s)
+		if !ok || n > 0xff || digits > 1 && s[0] == '0' {
+			return nil
+		}
+		parts[i] = byte(n)
+		s = s[digits:]
+	}
+	if len(s) != 0 {
+		return nil
+	}
+	return IPv4(parts[0], parts[1], parts[2], parts[3])
+}
+
+// parseDecimal parses a decimal number at the start of s and returns the
+// number and the number of digits.
+func parseDecimal(s string) (n int, digits int, ok bool) {
+	for digits < len(s) && '0' <= s[digits] && s[digits] <= '9' {
+		n = n*10 + int(s[digits]-'0')
+		digits++
+		if n > 0xffff {
+			return 0, digits, false
+		}
+	}
+	return n, digits, digits > 0
+}
+
+func appendDecimal(buf []byte, n int) []byte {
+	if n >= 10 {
+		buf = appendDecimal(buf, n/10)
+	}
+	return append(buf, byte('0'+n%10))
+}
+
+func hexString(b []byte) string {
+	const digits = "0123456789abcdef"
+	s := make([]byte, 0, len(b)*2)
+	for _, c := range b {
+		s = append(s, digits[c>>4], digits[c&0xf])
+	}
+	return string(s)
+}
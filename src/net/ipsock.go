@@ -0,0 +1,155 @@
+package net
+
+// Host/port handling and address resolution.
+
+import (
+	"strconv"
+)
+
+// SplitHostPort splits a network address of the form "host:port" or
+// "[host]:port" into host and port.
+func SplitHostPort(hostport string) (host, port string, err error) {
+	i := lastIndexByte(hostport, ':')
+	if i < 0 {
+		return "", "", &AddrError{Err: "missing port in address", Addr: hostport}
+	}
+	host, port = hostport[:i], hostport[i+1:]
+	if len(host) >= 2 && host[0] == '[' && host[len(host)-1] == ']' {
+		host = host[1 : len(host)-1]
+	}
+	if lastIndexByte(host, ':') >= 0 && hostport[0] != '[' {
+		return "", "", &AddrError{Err: "too many colons in address", Addr: hostport}
+	}
+	return host, port, nil
+}
+
+// JoinHostPort combines host and port into a network address of the form
+// "host:port". If host contains a colon, the host is enclosed in square
+// brackets.
+func JoinHostPort(host, port string) string {
+	if lastIndexByte(host, ':') >= 0 {
+		return "[" + host + "]:" + port
+	}
+	return host + ":" + port
+}
+
+func lastIndexByte(s string, c byte) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// parsePort parses a port number. Service names are not supported.
+func parsePort(network, port string) (int, error) {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 0xffff {
+		return 0, &AddrError{Err: "invalid port", Addr: port}
+	}
+	return n, nil
+}
+
+// resolveAddr resolves a "host:port" address for the given network. The host
+// name is looked up using the network device, unless it is an IP address. An
+// empty host resolves to a nil IP.
+func resolveAddr(network, address string) (host string, ip IP, port int, err error) {
+	host, portString, err := SplitHostPort(address)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	port, err = parsePort(network, portString)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if host == "" {
+		return "", nil, port, nil
+	}
+	if ip = ParseIP(host); ip != nil {
+		return "", ip, port, nil
+	}
+	ips, err := LookupIP(host)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return host, ips[0], port, nil
+}
+
+// checkNetwork returns the protocol for the given network name.
+func checkNetwork(network string) (Protocol, error) {
+	switch network {
+	case "tcp", "tcp4":
+		return ProtocolTCP, nil
+	case "udp", "udp4":
+		return ProtocolUDP, nil
+	default:
+		return 0, UnknownNetworkError(network)
+	}
+}
+
+// TCPAddr represents the address of a TCP end point.
+type TCPAddr struct {
+	IP   IP
+	Port int
+	Zone string // unused, for compatibility
+}
+
+// Network returns the address's network name, "tcp".
+func (a *TCPAddr) Network() string { return "tcp" }
+
+func (a *TCPAddr) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	return addrString(a.IP, a.Port)
+}
+
+// ResolveTCPAddr returns an address of a TCP end point.
+func ResolveTCPAddr(network, address string) (*TCPAddr, error) {
+	if proto, err := checkNetwork(network); err != nil || proto != ProtocolTCP {
+		return nil, UnknownNetworkError(network)
+	}
+	_, ip, port, err := resolveAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPAddr{IP: ip, Port: port}, nil
+}
+
+// UDPAddr represents the address of a UDP end point.
+type UDPAddr struct {
+	IP   IP
+	Port int
+	Zone string // unused, for compatibility
+}
+
+// Network returns the address's network name, "udp".
+func (a *UDPAddr) Network() string { return "udp" }
+
+func (a *UDPAddr) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	return addrString(a.IP, a.Port)
+}
+
+// ResolveUDPAddr returns an address of a UDP end point.
+func ResolveUDPAddr(network, address string) (*UDPAddr, error) {
+	if proto, err := checkNetwork(network); err != nil || proto != ProtocolUDP {
+		return nil, UnknownNetworkError(network)
+	}
+	_, ip, port, err := resolveAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPAddr{IP: ip, Port: port}, nil
+}
+
+func addrString(ip IP, port int) string {
+	host := ""
+	if len(ip) != 0 {
+		host = ip.String()
+	}
+	return JoinHostPort(host, strconv.Itoa(port))
+}
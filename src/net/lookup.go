@@ -0,0 +1,70 @@
+package net
+
+// Host name resolution. Names are resolved by the network device, which
+// normally asks the DNS server it got from DHCP. Names ending in ".local" are
+// resolved with mDNS instead if the device supports multicast.
+
+// LookupIP looks up host using the network device. It returns a slice of that
+// host's IPv4 addresses.
+func LookupIP(host string) ([]IP, error) {
+	if ip := ParseIP(host); ip != nil {
+		return []IP{ip}, nil
+	}
+	dev, err := getNetdev()
+	if err != nil {
+		return nil, err
+	}
+	var ip IP
+	if _, ok := dev.(Multicaster); ok && isLocalName(host) {
+		ip, err = lookupMDNS(dev, host)
+	} else {
+		ip, err = dev.GetHostByName(host)
+	}
+	if err != nil {
+		return nil, &DNSError{Err: err.Error(), Name: host}
+	}
+	if ip == nil {
+		return nil, &DNSError{Err: errNoSuchHost.Error(), Name: host, IsNotFound: true}
+	}
+	return []IP{ip}, nil
+}
+
+// LookupHost looks up the given host using the network device. It returns a
+// slice of that host's addresses.
+func LookupHost(host string) (addrs []string, err error) {
+	ips, err := LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, nil
+}
+
+// DNSError represents a DNS lookup error.
+type DNSError struct {
+	Err         string // description of the error
+	Name        string // name looked for
+	Server      string // server used
+	IsTimeout   bool   // if true, timed out; not all timeouts set this
+	IsTemporary bool   // if true, error is temporary; not all errors set this
+	IsNotFound  bool   // if true, host could not be found
+}
+
+func (e *DNSError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	s := "lookup " + e.Name
+	if e.Server != "" {
+		s += " on " + e.Server
+	}
+	return s + ": " + e.Err
+}
+
+// Timeout reports whether the DNS lookup is known to have timed out.
+func (e *DNSError) Timeout() bool { return e.IsTimeout }
+
+// Temporary reports whether the DNS error is known to be temporary.
+func (e *DNSError) Temporary() bool { return e.IsTimeout || e.IsTemporary }
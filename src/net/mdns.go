@@ -0,0 +1,490 @@
+package net
+
+// Multicast DNS (RFC 6762) and DNS-based service discovery (RFC 6763). Names
+// ending in ".local" are resolved by asking the devices on the local network
+// directly, and an MDNSResponder answers those questions for this device and
+// the services it offers. Both need a network device that implements
+// Multicaster.
+//
+// The responder does not probe for name conflicts and only announces its
+// records once, which is good enough for a small home or lab network.
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mdnsPort     = 5353
+	mdnsTTL      = 120 // seconds
+	mdnsTimeout  = time.Second
+	mdnsAttempts = 3
+
+	// TTL of the records in replies to legacy unicast queries, which are
+	// queries that are not sent from the mDNS port.
+	mdnsLegacyTTL = 10
+
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN = 1
+
+	// The top bit of the class is the unicast-response bit in questions and
+	// the cache-flush bit in records.
+	mdnsClassFlag = 0x8000
+
+	dnsHeaderLen = 12
+)
+
+var mdnsGroup = IPv4(224, 0, 0, 251)
+
+var (
+	errResponderClosed = errors.New("mDNS responder closed")
+	errBadService      = errors.New("invalid mDNS service")
+)
+
+// Service is a DNS-SD service that can be advertised with an MDNSResponder.
+type Service struct {
+	// Instance is the name of this instance of the service, which is shown to
+	// users. For example "Kitchen light".
+	Instance string
+
+	// Type is the service type with the protocol, for example "_http._tcp".
+	Type string
+
+	// Port is the TCP or UDP port the service listens on.
+	Port int
+
+	// Text contains the entries of the TXT record, for example "path=/".
+	Text []string
+}
+
+// MDNSResponder answers mDNS queries for the host name of the device and for
+// the services that were advertised with it.
+type MDNSResponder struct {
+	dev      Netdev
+	fd       int
+	hostname string
+
+	mu       sync.Mutex
+	services []Service
+	closed   bool
+}
+
+// mdnsRecord is a resource record of a responder. Names are stored as a list
+// of labels, as a service instance name may contain dots.
+type mdnsRecord struct {
+	name   []string
+	typ    uint16
+	shared bool     // the record is not unique to this device
+	target []string // the name a PTR or SRV record refers to
+	data   []byte
+}
+
+// ListenMDNS starts answering mDNS queries for hostname.local. Services can
+// then be advertised with Advertise. The responder runs in the background
+// until it is closed.
+func ListenMDNS(hostname string) (*MDNSResponder, error) {
+	addr := &UDPAddr{IP: mdnsGroup, Port: mdnsPort}
+	dev, err := getNetdev()
+	if err != nil {
+		return nil, &OpError{Op: "listen", Net: "udp", Addr: addr, Err: err}
+	}
+	m, ok := dev.(Multicaster)
+	if !ok {
+		return nil, &OpError{Op: "listen", Net: "udp", Addr: addr, Err: errUnsupported}
+	}
+	if hostname == "" || len(hostname) > 63 || strings.IndexByte(hostname, '.') >= 0 {
+		return nil, &OpError{Op: "listen", Net: "udp", Addr: addr, Err: errors.New("invalid host name")}
+	}
+	fd, err := dev.Socket(ProtocolUDP)
+	if err != nil {
+		return nil, &OpError{Op: "listen", Net: "udp", Addr: addr, Err: err}
+	}
+	err = dev.Bind(fd, nil, mdnsPort)
+	if err == nil {
+		err = m.JoinGroup(fd, mdnsGroup)
+	}
+	if err != nil {
+		dev.Close(fd)
+		return nil, &OpError{Op: "listen", Net: "udp", Addr: addr, Err: err}
+	}
+	r := &MDNSResponder{
+		dev:      dev,
+		fd:       fd,
+		hostname: hostname,
+	}
+	go r.serve()
+	r.announce(r.records(), mdnsTTL)
+	return r, nil
+}
+
+// Advertise adds a service to the responder and announces it on the network.
+func (r *MDNSResponder) Advertise(service Service) error {
+	types := splitDNSName(service.Type)
+	if service.Instance == "" || len(service.Instance) > 63 || len(types) != 2 || service.Port <= 0 || service.Port > 0xffff {
+		return errBadService
+	}
+	if types[1] != "_tcp" && types[1] != "_udp" {
+		return errBadService
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return errResponderClosed
+	}
+	r.services = append(r.services, service)
+	r.mu.Unlock()
+	r.announce(r.records(), mdnsTTL)
+	return nil
+}
+
+// Close stops the responder. It tells the network that the records are no
+// longer valid, so that other devices remove them from their cache.
+func (r *MDNSResponder) Close() error {
+	records := r.records()
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return errResponderClosed
+	}
+	r.closed = true
+	r.mu.Unlock()
+	r.announce(records, 0)
+	return r.dev.Close(r.fd)
+}
+
+// serve answers queries until the socket is closed.
+func (r *MDNSResponder) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, ip, port, err := r.dev.RecvFrom(r.fd, buf, time.Time{})
+		if err != nil {
+			return
+		}
+		r.handleQuery(buf[:n], ip, port)
+	}
+}
+
+// records returns all records of the responder.
+func (r *MDNSResponder) records() []mdnsRecord {
+	host := []string{r.hostname, "local"}
+	var records []mdnsRecord
+	if ip, err := r.dev.Addr(); err == nil && ip.To4() != nil {
+		records = append(records, mdnsRecord{name: host, typ: dnsTypeA, data: ip.To4()})
+	}
+	r.mu.Lock()
+	services := r.services
+	r.mu.Unlock()
+	for _, service := range services {
+		typ := append(splitDNSName(service.Type), "local")
+		instance := append([]string{service.Instance}, typ...)
+		records = append(records, mdnsRecord{
+			name:   []string{"_services", "_dns-sd", "_udp", "local"},
+			typ:    dnsTypePTR,
+			shared: true,
+			target: typ,
+			data:   appendDNSName(nil, typ),
+		})
+		records = append(records, mdnsRecord{
+			name:   typ,
+			typ:    dnsTypePTR,
+			shared: true,
+			target: instance,
+			data:   appendDNSName(nil, instance),
+		})
+		srv := []byte{0, 0, 0, 0, byte(service.Port >> 8), byte(service.Port)}
+		records = append(records, mdnsRecord{
+			name:   instance,
+			typ:    dnsTypeSRV,
+			target: host,
+			data:   appendDNSName(srv, host),
+		})
+		var txt []byte
+		for _, entry := range service.Text {
+			if len(entry) <= 255 {
+				txt = append(txt, byte(len(entry)))
+				txt = append(txt, entry...)
+			}
+		}
+		if len(txt) == 0 {
+			// A TXT record must contain at least one (empty) string.
+			txt = []byte{0}
+		}
+		records = append(records, mdnsRecord{name: instance, typ: dnsTypeTXT, data: txt})
+	}
+	return records
+}
+
+// handleQuery answers a query with the matching records. The records that the
+// answers refer to, such as the SRV and TXT records of a service instance, are
+// added as additional records.
+func (r *MDNSResponder) handleQuery(msg []byte, ip IP, port int) {
+	if len(msg) < dnsHeaderLen || msg[2]&0xf8 != 0 {
+		// Not a standard query.
+		return
+	}
+	records := r.records()
+	used := make([]bool, len(records))
+	var answers, additional []int
+	pos := dnsHeaderLen
+	for i := 0; i < int(be16(msg[4:6])); i++ {
+		name, next, ok := readDNSName(msg, pos)
+		if !ok || next+4 > len(msg) {
+			return
+		}
+		qtype := be16(msg[next:])
+		pos = next + 4
+		for j, rec := range records {
+			if !used[j] && (qtype == rec.typ || qtype == dnsTypeANY) && equalDNSName(name, rec.name) {
+				used[j] = true
+				answers = append(answers, j)
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+	for i := 0; i < len(answers)+len(additional); i++ {
+		var rec mdnsRecord
+		if i < len(answers) {
+			rec = records[answers[i]]
+		} else {
+			rec = records[additional[i-len(answers)]]
+		}
+		if rec.target == nil {
+			continue
+		}
+		for j := range records {
+			if !used[j] && records[j].typ != dnsTypePTR && equalDNSName(rec.target, records[j].name) {
+				used[j] = true
+				additional = append(additional, j)
+			}
+		}
+	}
+
+	// Queries that are not sent from the mDNS port come from simple resolvers,
+	// which expect a unicast reply to the query like from a DNS server.
+	legacy := port != mdnsPort
+	var reply []byte
+	ttl := uint32(mdnsTTL)
+	if legacy {
+		reply = appendDNSHeader(reply, be16(msg[0:2]), 0x8400, int(be16(msg[4:6])), len(answers), len(additional))
+		reply = append(reply, msg[dnsHeaderLen:pos]...)
+		ttl = mdnsLegacyTTL
+	} else {
+		reply = appendDNSHeader(reply, 0, 0x8400, 0, len(answers), len(additional))
+	}
+	for _, i := range answers {
+		reply = appendDNSRecord(reply, &records[i], ttl, !legacy)
+	}
+	for _, i := range additional {
+		reply = appendDNSRecord(reply, &records[i], ttl, !legacy)
+	}
+	deadline := time.Now().Add(mdnsTimeout)
+	if legacy {
+		r.dev.SendTo(r.fd, reply, ip, port, deadline)
+	} else {
+		r.dev.SendTo(r.fd, reply, mdnsGroup, mdnsPort, deadline)
+	}
+}
+
+// announce sends an unsolicited response with the given records to the
+// network. A TTL of 0 withdraws them.
+func (r *MDNSResponder) announce(records []mdnsRecord, ttl uint32) {
+	if len(records) == 0 {
+		return
+	}
+	msg := appendDNSHeader(nil, 0, 0x8400, 0, len(records), 0)
+	for i := range records {
+		msg = appendDNSRecord(msg, &records[i], ttl, true)
+	}
+	r.dev.SendTo(r.fd, msg, mdnsGroup, mdnsPort, time.Now().Add(mdnsTimeout))
+}
+
+// isLocalName returns whether name is in the ".local" domain of mDNS.
+func isLocalName(name string) bool {
+	labels := splitDNSName(name)
+	return len(labels) >= 2 && strings.EqualFold(labels[len(labels)-1], "local")
+}
+
+// lookupMDNS resolves a ".local" name by sending a query to the mDNS group. It
+// returns a nil IP if no device answered.
+func lookupMDNS(dev Netdev, host string) (IP, error) {
+	name := splitDNSName(host)
+	for _, label := range name {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errNoSuchHost
+		}
+	}
+	query := appendDNSHeader(nil, uint16(time.Now().UnixNano()), 0, 1, 0, 0)
+	query = appendDNSName(query, name)
+	query = append(query, 0, dnsTypeA, (dnsClassIN|mdnsClassFlag)>>8, dnsClassIN&0xff)
+
+	fd, err := dev.Socket(ProtocolUDP)
+	if err != nil {
+		return nil, err
+	}
+	defer dev.Close(fd)
+	if err := dev.Bind(fd, nil, 0); err != nil {
+		return nil, err
+	}
+	var buf [512]byte
+	for i := 0; i < mdnsAttempts; i++ {
+		deadline := time.Now().Add(mdnsTimeout)
+		if _, err := dev.SendTo(fd, query, mdnsGroup, mdnsPort, deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, _, port, err := dev.RecvFrom(fd, buf[:], deadline)
+			if err != nil {
+				break
+			}
+			if port != mdnsPort {
+				continue
+			}
+			if ip := parseMDNSReply(buf[:n], name); ip != nil {
+				return ip, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// parseMDNSReply returns the address of the given name from the answers and
+// additional records of a reply, or nil if it doesn't contain it.
+func parseMDNSReply(msg []byte, name []string) IP {
+	if len(msg) < dnsHeaderLen || msg[2]&0x80 == 0 {
+		return nil
+	}
+	pos := dnsHeaderLen
+	for i := 0; i < int(be16(msg[4:6])); i++ {
+		_, next, ok := readDNSName(msg, pos)
+		if !ok {
+			return nil
+		}
+		pos = next + 4
+	}
+	count := int(be16(msg[6:8])) + int(be16(msg[8:10])) + int(be16(msg[10:12]))
+	for i := 0; i < count; i++ {
+		rname, next, ok := readDNSName(msg, pos)
+		if !ok || next+10 > len(msg) {
+			return nil
+		}
+		typ, class, length := be16(msg[next:]), be16(msg[next+2:]), int(be16(msg[next+8:]))
+		pos = next + 10
+		if pos+length > len(msg) {
+			return nil
+		}
+		if typ == dnsTypeA && class&^mdnsClassFlag == dnsClassIN && length == 4 && equalDNSName(rname, name) {
+			return IPv4(msg[pos], msg[pos+1], msg[pos+2], msg[pos+3])
+		}
+		pos += length
+	}
+	return nil
+}
+
+// splitDNSName splits a name into its labels. A trailing dot is ignored.
+func splitDNSName(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// equalDNSName compares two names. Like in the rest of DNS, the comparison is
+// case insensitive.
+func equalDNSName(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// readDNSName reads a (possibly compressed) name from a message. It returns
+// the labels and the position after the name.
+func readDNSName(msg []byte, pos int) (labels []string, next int, ok bool) {
+	next = -1
+	for jumps := 0; jumps < 16; {
+		if pos >= len(msg) {
+			return nil, 0, false
+		}
+		length := int(msg[pos])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = pos + 1
+			}
+			return labels, next, true
+		case length&0xc0 == 0xc0:
+			if pos+2 > len(msg) {
+				return nil, 0, false
+			}
+			if next < 0 {
+				next = pos + 2
+			}
+			pos = int(be16(msg[pos:]) & 0x3fff)
+			jumps++
+		case length&0xc0 != 0:
+			return nil, 0, false
+		default:
+			if pos+1+length > len(msg) {
+				return nil, 0, false
+			}
+			labels = append(labels, string(msg[pos+1:pos+1+length]))
+			pos += 1 + length
+		}
+	}
+	return nil, 0, false
+}
+
+// appendDNSName appends an uncompressed name to a message.
+func appendDNSName(buf []byte, labels []string) []byte {
+	for _, label := range labels {
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0)
+}
+
+// appendDNSHeader appends the header of a message without authority records.
+func appendDNSHeader(buf []byte, id, flags uint16, questions, answers, additional int) []byte {
+	return append(buf,
+		byte(id>>8), byte(id),
+		byte(flags>>8), byte(flags),
+		byte(questions>>8), byte(questions),
+		byte(answers>>8), byte(answers),
+		0, 0,
+		byte(additional>>8), byte(additional))
+}
+
+// appendDNSRecord appends a resource record to a message. The cache-flush bit
+// is set on unique records if flush is true.
+func appendDNSRecord(buf []byte, rec *mdnsRecord, ttl uint32, flush bool) []byte {
+	buf = appendDNSName(buf, rec.name)
+	class := uint16(dnsClassIN)
+	if flush && !rec.shared {
+		class |= mdnsClassFlag
+	}
+	return append(append(buf,
+		byte(rec.typ>>8), byte(rec.typ),
+		byte(class>>8), byte(class),
+		byte(ttl>>24), byte(ttl>>16), byte(ttl>>8), byte(ttl),
+		byte(len(rec.data)>>8), byte(len(rec.data))),
+		rec.data...)
+}
+
+func be16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
@@ -0,0 +1,259 @@
+// Package net implements a subset of the Go "net" package. See
+// https://godoc.org/net for details.
+//
+// Network connections are backed by a network device driver (for example a
+// WiFi co-processor) that implements the Netdev interface and is registered
+// with UseNetdev. Only IPv4 TCP and UDP sockets are supported.
+package net
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Errors returned by the network functions.
+var (
+	ErrNoNetdev    = errors.New("net: no network device")
+	ErrClosed      = errors.New("use of closed network connection")
+	errTimeout     = &timeoutError{}
+	errMissingAddr = errors.New("missing address")
+	errNoSuchHost  = errors.New("no such host")
+	errUnsupported = errors.New("operation not supported")
+)
+
+// Addr represents a network end point address.
+type Addr interface {
+	Network() string // name of the network (for example, "tcp", "udp")
+	String() string  // string form of address (for example, "192.0.2.1:25")
+}
+
+// Conn is a generic stream-oriented network connection.
+type Conn interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	Close() error
+	LocalAddr() Addr
+	RemoteAddr() Addr
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// PacketConn is a generic packet-oriented network connection.
+type PacketConn interface {
+	ReadFrom(p []byte) (n int, addr Addr, err error)
+	WriteTo(p []byte, addr Addr) (n int, err error)
+	Close() error
+	LocalAddr() Addr
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Listener is a generic network listener for stream-oriented protocols.
+type Listener interface {
+	Accept() (Conn, error)
+	Close() error
+	Addr() Addr
+}
+
+// Error represents a network error.
+type Error interface {
+	error
+	Timeout() bool   // Is the error a timeout?
+	Temporary() bool // Is the error temporary?
+}
+
+// OpError is the error type usually returned by functions in the net package.
+// It describes the operation, network type, and address of an error.
+type OpError struct {
+	Op     string
+	Net    string
+	Source Addr
+	Addr   Addr
+	Err    error
+}
+
+func (e *OpError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	s := e.Op
+	if e.Net != "" {
+		s += " " + e.Net
+	}
+	if e.Source != nil {
+		s += " " + e.Source.String()
+	}
+	if e.Addr != nil {
+		if e.Source != nil {
+			s += "->"
+		} else {
+			s += " "
+		}
+		s += e.Addr.String()
+	}
+	return s + ": " + e.Err.Error()
+}
+
+// Timeout returns whether the error was caused by an expired deadline.
+func (e *OpError) Timeout() bool {
+	t, ok := e.Err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// Temporary returns whether the operation may succeed when retried.
+func (e *OpError) Temporary() bool {
+	t, ok := e.Err.(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
+
+// timeoutError is returned when a deadline has expired. Drivers should return
+// ErrDeadlineExceeded (or an error with a Timeout method) in that case.
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// ErrDeadlineExceeded is returned for an expired read or write deadline.
+var ErrDeadlineExceeded error = errTimeout
+
+// AddrError describes an invalid address.
+type AddrError struct {
+	Err  string
+	Addr string
+}
+
+func (e *AddrError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	s := e.Err
+	if e.Addr != "" {
+		s = "address " + e.Addr + ": " + s
+	}
+	return s
+}
+
+func (e *AddrError) Timeout() bool   { return false }
+func (e *AddrError) Temporary() bool { return false }
+
+// UnknownNetworkError is returned for networks other than tcp, tcp4, udp and
+// udp4.
+type UnknownNetworkError string
+
+func (e UnknownNetworkError) Error() string   { return "unknown network " + string(e) }
+func (e UnknownNetworkError) Timeout() bool   { return false }
+func (e UnknownNetworkError) Temporary() bool { return false }
+
+// conn is the part of TCPConn and UDPConn that is shared: a socket on the
+// network device with deadlines.
+type conn struct {
+	fd            int
+	dev           Netdev
+	closed        bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (c *conn) ok() bool { return c != nil && !c.closed }
+
+func (c *conn) read(b []byte) (int, error) {
+	if !c.ok() {
+		return 0, ErrClosed
+	}
+	if expired(c.readDeadline) {
+		return 0, ErrDeadlineExceeded
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := c.dev.Recv(c.fd, b, c.readDeadline)
+	if n == 0 && err == nil {
+		// Sockets return zero bytes only when the connection was closed by
+		// the other end.
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *conn) write(b []byte) (int, error) {
+	if !c.ok() {
+		return 0, ErrClosed
+	}
+	if expired(c.writeDeadline) {
+		return 0, ErrDeadlineExceeded
+	}
+	written := 0
+	for written < len(b) {
+		n, err := c.dev.Send(c.fd, b[written:], c.writeDeadline)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			// A driver that makes no progress would otherwise make this
+			// loop forever.
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// expired returns whether the deadline is set and has passed. Operations fail
+// right away in that case, without calling the driver.
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// setKeepAlive enables or disables keepalive probes, if supported by the
+// network device.
+func (c *conn) setKeepAlive(period time.Duration) error {
+	if !c.ok() {
+		return ErrClosed
+	}
+	k, ok := c.dev.(KeepAliveSetter)
+	if !ok {
+		return errUnsupported
+	}
+	return k.SetKeepAlive(c.fd, period)
+}
+
+func (c *conn) close() error {
+	if !c.ok() {
+		return ErrClosed
+	}
+	c.closed = true
+	return c.dev.Close(c.fd)
+}
+
+// SetDeadline sets the read and write deadlines of the connection. A zero
+// value for t means I/O operations will not time out.
+func (c *conn) SetDeadline(t time.Time) error {
+	if !c.ok() {
+		return ErrClosed
+	}
+	c.readDeadline = t
+	c.writeDeadline = t
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future Read calls.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	if !c.ok() {
+		return ErrClosed
+	}
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	if !c.ok() {
+		return ErrClosed
+	}
+	c.writeDeadline = t
+	return nil
+}
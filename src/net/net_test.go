@@ -0,0 +1,271 @@
+package net
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// testNetdev is a network device with a single peer. Data sent over a socket
+// is recorded, and received data comes from a buffer filled by the test.
+type testNetdev struct {
+	nextfd    int
+	sockets   map[int]*testSocket
+	hosts     map[string]IP
+	sendMax   int // maximum number of bytes accepted by a single Send call
+	keepAlive time.Duration
+}
+
+type testSocket struct {
+	protocol Protocol
+	ip       IP
+	port     int
+	sent     bytes.Buffer
+	recv     bytes.Buffer
+	closed   bool
+}
+
+func newTestNetdev() *testNetdev {
+	dev := &testNetdev{
+		sockets: make(map[int]*testSocket),
+		hosts:   map[string]IP{"example.com": IPv4(93, 184, 216, 34)},
+		sendMax: 1 << 30,
+	}
+	UseNetdev(dev)
+	return dev
+}
+
+func (d *testNetdev) GetHostByName(name string) (IP, error) {
+	return d.hosts[name], nil
+}
+
+func (d *testNetdev) Addr() (IP, error) {
+	return IPv4(192, 168, 1, 2), nil
+}
+
+func (d *testNetdev) Socket(protocol Protocol) (int, error) {
+	d.nextfd++
+	d.sockets[d.nextfd] = &testSocket{protocol: protocol}
+	return d.nextfd, nil
+}
+
+func (d *testNetdev) Bind(sockfd int, ip IP, port int) error {
+	return nil
+}
+
+func (d *testNetdev) Connect(sockfd int, host string, ip IP, port int, deadline time.Time) error {
+	if port == 0 {
+		return errors.New("connection refused")
+	}
+	s := d.sockets[sockfd]
+	s.ip, s.port = ip, port
+	return nil
+}
+
+func (d *testNetdev) Listen(sockfd int, backlog int) error {
+	return nil
+}
+
+func (d *testNetdev) Accept(sockfd int, deadline time.Time) (int, IP, int, error) {
+	return 0, nil, 0, errUnsupported
+}
+
+func (d *testNetdev) Send(sockfd int, buf []byte, deadline time.Time) (int, error) {
+	if len(buf) > d.sendMax {
+		buf = buf[:d.sendMax]
+	}
+	return d.sockets[sockfd].sent.Write(buf)
+}
+
+func (d *testNetdev) Recv(sockfd int, buf []byte, deadline time.Time) (int, error) {
+	n, _ := d.sockets[sockfd].recv.Read(buf)
+	return n, nil
+}
+
+func (d *testNetdev) SendTo(sockfd int, buf []byte, ip IP, port int, deadline time.Time) (int, error) {
+	return d.sockets[sockfd].sent.Write(buf)
+}
+
+func (d *testNetdev) RecvFrom(sockfd int, buf []byte, deadline time.Time) (int, IP, int, error) {
+	s := d.sockets[sockfd]
+	n, _ := s.recv.Read(buf)
+	return n, s.ip, s.port, nil
+}
+
+func (d *testNetdev) Close(sockfd int) error {
+	d.sockets[sockfd].closed = true
+	return nil
+}
+
+func (d *testNetdev) SetKeepAlive(sockfd int, period time.Duration) error {
+	d.keepAlive = period
+	return nil
+}
+
+func TestNoNetdev(t *testing.T) {
+	UseNetdev(nil)
+	_, err := Dial("tcp", "10.0.0.1:80")
+	if opErr, ok := err.(*OpError); !ok || opErr.Err != ErrNoNetdev {
+		t.Error("expected ErrNoNetdev, got", err)
+	}
+}
+
+func TestDialTCP(t *testing.T) {
+	dev := newTestNetdev()
+	c, err := Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal("could not dial:", err)
+	}
+	s := dev.sockets[1]
+	if s.protocol != ProtocolTCP || !s.ip.Equal(IPv4(93, 184, 216, 34)) || s.port != 80 {
+		t.Errorf("connected to the wrong address: %v:%d", s.ip, s.port)
+	}
+	if c.RemoteAddr().String() != "93.184.216.34:80" {
+		t.Error("unexpected remote address:", c.RemoteAddr())
+	}
+	if dev.keepAlive != defaultKeepAlive {
+		t.Error("expected keepalive to be enabled, got period", dev.keepAlive)
+	}
+
+	if _, err := c.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatal("could not write:", err)
+	}
+	if s.sent.String() != "GET / HTTP/1.0\r\n\r\n" {
+		t.Errorf("unexpected data sent: %q", s.sent.String())
+	}
+
+	s.recv.WriteString("HTTP/1.0 200 OK\r\n")
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "HTTP/1.0 200 OK\r\n" {
+		t.Errorf("unexpected read: %q, %v", buf[:n], err)
+	}
+	if _, err := c.Read(buf); err != io.EOF {
+		t.Error("expected io.EOF once the peer has closed the connection, got", err)
+	}
+
+	if err := c.Close(); err != nil || !s.closed {
+		t.Error("could not close:", err)
+	}
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Error("expected an error when writing to a closed connection")
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	dev := newTestNetdev()
+	_, err := Dial("tcp", "10.0.0.1:0")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !dev.sockets[1].closed {
+		t.Error("socket was not closed after a failed dial")
+	}
+}
+
+func TestDialUnknownHost(t *testing.T) {
+	newTestNetdev()
+	_, err := Dial("tcp", "unknown.example.com:80")
+	opErr, ok := err.(*OpError)
+	if !ok {
+		t.Fatal("expected an *OpError, got", err)
+	}
+	if dnsErr, ok := opErr.Err.(*DNSError); !ok || !dnsErr.IsNotFound {
+		t.Error("expected a not found DNS error, got", opErr.Err)
+	}
+}
+
+func TestPartialWrite(t *testing.T) {
+	dev := newTestNetdev()
+	dev.sendMax = 3
+	c, err := Dial("tcp", "10.0.0.1:80")
+	if err != nil {
+		t.Fatal("could not dial:", err)
+	}
+	n, err := c.Write([]byte("hello, world"))
+	if n != 12 || err != nil {
+		t.Errorf("expected all 12 bytes to be written, got %d, %v", n, err)
+	}
+	if dev.sockets[1].sent.String() != "hello, world" {
+		t.Errorf("unexpected data sent: %q", dev.sockets[1].sent.String())
+	}
+}
+
+// TestShortWrite checks that a driver that keeps sending nothing without an
+// error does not make Write hang.
+func TestShortWrite(t *testing.T) {
+	dev := newTestNetdev()
+	c, err := Dial("tcp", "10.0.0.1:80")
+	if err != nil {
+		t.Fatal("could not dial:", err)
+	}
+	dev.sendMax = 0
+	n, err := c.Write([]byte("hello"))
+	if n != 0 {
+		t.Error("expected nothing to be written, got", n)
+	}
+	if opErr, ok := err.(*OpError); !ok || opErr.Err != io.ErrShortWrite {
+		t.Error("expected io.ErrShortWrite, got", err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	dev := newTestNetdev()
+	c, err := Dial("tcp", "10.0.0.1:80")
+	if err != nil {
+		t.Fatal("could not dial:", err)
+	}
+	c.SetDeadline(time.Now().Add(-time.Second))
+	if _, err := c.Write([]byte("x")); err == nil || !err.(Error).Timeout() {
+		t.Error("expected a timeout error, got", err)
+	}
+	if _, err := c.Read(make([]byte, 1)); err == nil || !err.(Error).Timeout() {
+		t.Error("expected a timeout error, got", err)
+	}
+	if dev.sockets[1].sent.Len() != 0 {
+		t.Error("data was sent after the deadline")
+	}
+}
+
+func TestDialUDP(t *testing.T) {
+	dev := newTestNetdev()
+	c, err := Dial("udp", "10.0.0.1:53")
+	if err != nil {
+		t.Fatal("could not dial:", err)
+	}
+	if _, ok := c.(*UDPConn); !ok {
+		t.Fatalf("expected a *UDPConn, got %T", c)
+	}
+	if dev.sockets[1].protocol != ProtocolUDP {
+		t.Error("expected a UDP socket")
+	}
+	if c.RemoteAddr().Network() != "udp" {
+		t.Error("unexpected remote address network:", c.RemoteAddr().Network())
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	for _, tc := range []struct {
+		hostport, host, port string
+	}{
+		{"example.com:80", "example.com", "80"},
+		{"10.0.0.1:8080", "10.0.0.1", "8080"},
+		{":53", "", "53"},
+		{"[::1]:80", "::1", "80"},
+	} {
+		host, port, err := SplitHostPort(tc.hostport)
+		if err != nil || host != tc.host || port != tc.port {
+			t.Errorf("SplitHostPort(%q) = %q, %q, %v", tc.hostport, host, port, err)
+		}
+		if JoinHostPort(host, port) != tc.hostport {
+			t.Errorf("JoinHostPort(%q, %q) = %q", host, port, JoinHostPort(host, port))
+		}
+	}
+	for _, hostport := range []string{"example.com", "::1:80"} {
+		if _, _, err := SplitHostPort(hostport); err == nil {
+			t.Errorf("SplitHostPort(%q): expected an error", hostport)
+		}
+	}
+}
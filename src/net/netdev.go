@@ -0,0 +1,167 @@
+package net
+
+// This file defines the interface between the net package and network device
+// drivers. A driver offers a socket API similar to the one of BSD sockets,
+// which is what most network co-processors (WiFiNINA, ESP-AT, RTL8720) offer
+// over SPI or UART anyway. The net package builds the usual Dial and Listen
+// functions on top of it.
+
+import (
+	"time"
+)
+
+// Protocol is the transport protocol of a socket.
+type Protocol uint8
+
+// Protocols that may be passed to Netdev.Socket. ProtocolTLS is a TCP socket
+// with TLS offloaded to the network device, see DialTLS.
+const (
+	ProtocolTCP Protocol = iota + 1
+	ProtocolUDP
+	ProtocolTLS
+)
+
+// Netdev is a network device driver. Sockets are identified by a small
+// integer, like file descriptors. All methods are called from goroutines and
+// may block, but must not block other goroutines while waiting for the device.
+// A zero deadline means the operation does not time out; when a deadline
+// expires, the driver should return ErrDeadlineExceeded.
+type Netdev interface {
+	// GetHostByName resolves a host name to an IPv4 address, using the DNS
+	// server of the device.
+	GetHostByName(name string) (IP, error)
+
+	// Addr returns the IPv4 address of the device, or an error when it is not
+	// connected to a network.
+	Addr() (IP, error)
+
+	// Socket creates a new socket for the given protocol.
+	Socket(protocol Protocol) (sockfd int, err error)
+
+	// Bind sets the local address of the socket. The IP may be nil to bind to
+	// all addresses.
+	Bind(sockfd int, ip IP, port int) error
+
+	// Connect connects the socket to a remote address. The host name is passed
+	// as well for devices that do their own name resolution or need it for
+	// TLS; it is empty when the address was given as an IP address.
+	Connect(sockfd int, host string, ip IP, port int, deadline time.Time) error
+
+	// Listen marks a bound TCP socket as accepting connections.
+	Listen(sockfd int, backlog int) error
+
+	// Accept waits for an incoming connection on a listening socket and
+	// returns a new socket for it together with the remote address.
+	Accept(sockfd int, deadline time.Time) (newfd int, ip IP, port int, err error)
+
+	// Send sends data over a connected socket. It may send less than len(buf)
+	// bytes.
+	Send(sockfd int, buf []byte, deadline time.Time) (int, error)
+
+	// Recv receives data from a connected socket. It returns zero bytes and
+	// no error when the connection was closed by the remote end.
+	Recv(sockfd int, buf []byte, deadline time.Time) (int, error)
+
+	// SendTo sends a datagram to the given address over a UDP socket.
+	SendTo(sockfd int, buf []byte, ip IP, port int, deadline time.Time) (int, error)
+
+	// RecvFrom receives a datagram from a UDP socket, together with the
+	// address it was sent from.
+	RecvFrom(sockfd int, buf []byte, deadline time.Time) (n int, ip IP, port int, err error)
+
+	// Close closes the socket.
+	Close(sockfd int) error
+}
+
+// KeepAliveSetter is implemented by network devices that can send TCP
+// keepalive probes, to detect connections that were dropped without notice.
+type KeepAliveSetter interface {
+	// SetKeepAlive enables keepalive probes on a TCP socket once the connection
+	// has been idle for the given period, and then again after every period
+	// without reply. A zero period disables them.
+	SetKeepAlive(sockfd int, period time.Duration) error
+}
+
+// Multicaster is implemented by network devices that can send and receive
+// IPv4 multicast datagrams. The net package needs it for mDNS: with such a
+// device, names ending in ".local" are resolved on the local network and
+// services can be advertised with ListenMDNS.
+type Multicaster interface {
+	// JoinGroup makes a bound UDP socket receive the datagrams sent to the
+	// given multicast group, in addition to the ones sent to the device.
+	JoinGroup(sockfd int, group IP) error
+}
+
+// Config is the IPv4 configuration of a network device.
+type Config struct {
+	// DHCP requests the configuration from a DHCP server. The other fields
+	// are ignored when it is set, except for Hostname.
+	DHCP bool
+
+	IP      IP
+	Mask    IPMask
+	Gateway IP
+	DNS     IP
+
+	// Hostname is sent to the DHCP server, if supported.
+	Hostname string
+}
+
+// Configurer is implemented by network devices whose IPv4 configuration can
+// be changed.
+type Configurer interface {
+	// SetConfig applies the configuration. For DHCP, it blocks until an
+	// address has been obtained.
+	SetConfig(config Config) error
+
+	// Config returns the current configuration, including the address that
+	// was obtained from the DHCP server.
+	Config() (Config, error)
+}
+
+// The network device used by the net package.
+var netdev Netdev
+
+// UseNetdev sets the network device used by all functions in this package.
+// It is normally called by the driver after initializing the device.
+func UseNetdev(dev Netdev) {
+	netdev = dev
+}
+
+// getNetdev returns the current network device, or ErrNoNetdev if there is
+// none.
+func getNetdev() (Netdev, error) {
+	if netdev == nil {
+		return nil, ErrNoNetdev
+	}
+	return netdev, nil
+}
+
+// Configure changes the IPv4 configuration of the network device, for
+// example to switch between DHCP and a static address. The device must
+// implement Configurer.
+func Configure(config Config) error {
+	dev, err := getNetdev()
+	if err != nil {
+		return err
+	}
+	c, ok := dev.(Configurer)
+	if !ok {
+		return errUnsupported
+	}
+	return c.SetConfig(config)
+}
+
+// CurrentConfig returns the IPv4 configuration of the network device. If the
+// device does not implement Configurer, only the IP address is filled in.
+func CurrentConfig() (Config, error) {
+	dev, err := getNetdev()
+	if err != nil {
+		return Config{}, err
+	}
+	if c, ok := dev.(Configurer); ok {
+		return c.Config()
+	}
+	ip, err := dev.Addr()
+	return Config{IP: ip}, err
+}
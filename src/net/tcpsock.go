@@ -0,0 +1,169 @@
+package net
+
+import (
+	"io"
+	"time"
+)
+
+// TCPConn is an implementation of the Conn interface for TCP network
+// connections.
+type TCPConn struct {
+	conn
+	laddr *TCPAddr
+	raddr *TCPAddr
+}
+
+// DialTCP acts like Dial for TCP networks.
+func DialTCP(network string, laddr, raddr *TCPAddr) (*TCPConn, error) {
+	if proto, err := checkNetwork(network); err != nil || proto != ProtocolTCP {
+		return nil, &OpError{Op: "dial", Net: network, Err: UnknownNetworkError(network)}
+	}
+	if raddr == nil {
+		return nil, &OpError{Op: "dial", Net: network, Err: errMissingAddr}
+	}
+	var lip IP
+	var lport int
+	if laddr != nil {
+		lip, lport = laddr.IP, laddr.Port
+	}
+	c, err := dialSocket(ProtocolTCP, lip, lport, "", raddr.IP, raddr.Port, time.Time{}, nil)
+	if err != nil {
+		return nil, &OpError{Op: "dial", Net: network, Addr: raddr, Err: err}
+	}
+	return &TCPConn{conn: *c, laddr: laddr, raddr: raddr}, nil
+}
+
+// Read reads data from the connection.
+func (c *TCPConn) Read(b []byte) (int, error) {
+	n, err := c.read(b)
+	if err != nil && err != io.EOF {
+		err = &OpError{Op: "read", Net: "tcp", Addr: c.raddr, Err: err}
+	}
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c *TCPConn) Write(b []byte) (int, error) {
+	n, err := c.write(b)
+	if err != nil {
+		err = &OpError{Op: "write", Net: "tcp", Addr: c.raddr, Err: err}
+	}
+	return n, err
+}
+
+// Close closes the connection.
+func (c *TCPConn) Close() error {
+	if err := c.close(); err != nil {
+		return &OpError{Op: "close", Net: "tcp", Addr: c.raddr, Err: err}
+	}
+	return nil
+}
+
+// SetKeepAlive sets whether the network device should send keepalive probes
+// on the connection. It returns an error if the device doesn't support them.
+func (c *TCPConn) SetKeepAlive(keepalive bool) error {
+	period := time.Duration(0)
+	if keepalive {
+		period = defaultKeepAlive
+	}
+	if err := c.setKeepAlive(period); err != nil {
+		return &OpError{Op: "set", Net: "tcp", Addr: c.raddr, Err: err}
+	}
+	return nil
+}
+
+// SetKeepAlivePeriod enables keepalive probes with the given period between
+// probes.
+func (c *TCPConn) SetKeepAlivePeriod(d time.Duration) error {
+	if d <= 0 {
+		d = defaultKeepAlive
+	}
+	if err := c.setKeepAlive(d); err != nil {
+		return &OpError{Op: "set", Net: "tcp", Addr: c.raddr, Err: err}
+	}
+	return nil
+}
+
+// LocalAddr returns the local network address, if known.
+func (c *TCPConn) LocalAddr() Addr {
+	if c.laddr == nil {
+		if ip, err := c.dev.Addr(); err == nil {
+			return &TCPAddr{IP: ip}
+		}
+		return nil
+	}
+	return c.laddr
+}
+
+// RemoteAddr returns the remote network address.
+func (c *TCPConn) RemoteAddr() Addr {
+	return c.raddr
+}
+
+// TCPListener is a TCP network listener.
+type TCPListener struct {
+	conn
+	laddr *TCPAddr
+}
+
+// ListenTCP acts like Listen for TCP networks. If the IP field of laddr is
+// nil, it listens on all addresses of the device.
+func ListenTCP(network string, laddr *TCPAddr) (*TCPListener, error) {
+	if proto, err := checkNetwork(network); err != nil || proto != ProtocolTCP {
+		return nil, &OpError{Op: "listen", Net: network, Err: UnknownNetworkError(network)}
+	}
+	if laddr == nil {
+		laddr = &TCPAddr{}
+	}
+	c, err := listenSocket(ProtocolTCP, laddr.IP, laddr.Port)
+	if err != nil {
+		return nil, &OpError{Op: "listen", Net: network, Addr: laddr, Err: err}
+	}
+	return &TCPListener{conn: *c, laddr: laddr}, nil
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *TCPListener) Accept() (Conn, error) {
+	return l.AcceptTCP()
+}
+
+// AcceptTCP accepts the next incoming call and returns the new connection.
+func (l *TCPListener) AcceptTCP() (*TCPConn, error) {
+	if !l.ok() {
+		return nil, &OpError{Op: "accept", Net: "tcp", Addr: l.laddr, Err: ErrClosed}
+	}
+	if expired(l.readDeadline) {
+		return nil, &OpError{Op: "accept", Net: "tcp", Addr: l.laddr, Err: ErrDeadlineExceeded}
+	}
+	fd, ip, port, err := l.dev.Accept(l.fd, l.readDeadline)
+	if err != nil {
+		return nil, &OpError{Op: "accept", Net: "tcp", Addr: l.laddr, Err: err}
+	}
+	c := &TCPConn{conn: conn{fd: fd, dev: l.dev}, laddr: l.laddr, raddr: &TCPAddr{IP: ip, Port: port}}
+	c.setKeepAlive(defaultKeepAlive)
+	return c, nil
+}
+
+// SetDeadline sets the deadline for Accept calls. A zero value means Accept
+// will not time out.
+func (l *TCPListener) SetDeadline(t time.Time) error {
+	if !l.ok() {
+		return &OpError{Op: "set", Net: "tcp", Addr: l.laddr, Err: ErrClosed}
+	}
+	l.readDeadline = t
+	return nil
+}
+
+// Close stops listening on the TCP address. Already accepted connections are
+// not closed.
+func (l *TCPListener) Close() error {
+	if err := l.close(); err != nil {
+		return &OpError{Op: "close", Net: "tcp", Addr: l.laddr, Err: err}
+	}
+	return nil
+}
+
+// Addr returns the listener's network address.
+func (l *TCPListener) Addr() Addr {
+	return l.laddr
+}
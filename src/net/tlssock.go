@@ -0,0 +1,129 @@
+package net
+
+// TLS connections that are offloaded to the network device. Most WiFi
+// co-processors (WiFiNINA, ESP-AT) implement TLS in their firmware, including
+// the certificate store, which is a lot smaller and faster than doing TLS on
+// the microcontroller. The crypto/tls package is built on top of this.
+
+import (
+	"io"
+	"time"
+)
+
+// TLSOptions configures a TLS connection made with DialTLS.
+type TLSOptions struct {
+	// ServerName is used for SNI and to verify the certificate of the server.
+	// It defaults to the host name in the address.
+	ServerName string
+
+	// InsecureSkipVerify disables verification of the server certificate.
+	InsecureSkipVerify bool
+
+	// RootCAs contains PEM encoded certificates of certificate authorities
+	// that are trusted in addition to the ones stored in the device.
+	RootCAs []byte
+}
+
+// TLSConfigurer is implemented by network devices that support configuring
+// TLS sockets. Devices that don't implement it only support the default
+// options: certificates are verified against the certificate store of the
+// device.
+type TLSConfigurer interface {
+	// SetTLSOptions configures a ProtocolTLS socket before it is connected.
+	SetTLSOptions(sockfd int, options TLSOptions) error
+}
+
+// setTLSOptions passes the TLS options to the device, if needed.
+func setTLSOptions(dev Netdev, fd int, options *TLSOptions) error {
+	if configurer, ok := dev.(TLSConfigurer); ok {
+		return configurer.SetTLSOptions(fd, *options)
+	}
+	if options.InsecureSkipVerify || len(options.RootCAs) != 0 {
+		return errUnsupported
+	}
+	return nil
+}
+
+// TLSConn is a TLS connection that is encrypted by the network device.
+type TLSConn struct {
+	conn
+	raddr      *TCPAddr
+	serverName string
+}
+
+// DialTLS connects to the given TCP address and performs a TLS handshake
+// using the network device. The network must be "tcp" or "tcp4".
+func (d *Dialer) DialTLS(network, address string, options TLSOptions) (*TLSConn, error) {
+	if proto, err := checkNetwork(network); err != nil || proto != ProtocolTCP {
+		return nil, &OpError{Op: "dial", Net: network, Err: UnknownNetworkError(network)}
+	}
+	deadline := d.deadline(time.Now())
+	host, ip, port, err := resolveAddr(network, address)
+	if err != nil {
+		return nil, &OpError{Op: "dial", Net: network, Err: err}
+	}
+	if ip == nil {
+		return nil, &OpError{Op: "dial", Net: network, Err: errMissingAddr}
+	}
+	if options.ServerName == "" {
+		options.ServerName = host
+	}
+	var laddr IP
+	var lport int
+	if a, ok := d.LocalAddr.(*TCPAddr); ok {
+		laddr, lport = a.IP, a.Port
+	}
+	raddr := &TCPAddr{IP: ip, Port: port}
+	c, err := dialSocket(ProtocolTLS, laddr, lport, options.ServerName, ip, port, deadline, &options)
+	if err != nil {
+		return nil, &OpError{Op: "dial", Net: network, Addr: raddr, Err: err}
+	}
+	if period := d.keepAlive(); period != 0 {
+		c.setKeepAlive(period)
+	}
+	return &TLSConn{conn: *c, raddr: raddr, serverName: options.ServerName}, nil
+}
+
+// Read reads decrypted data from the connection.
+func (c *TLSConn) Read(b []byte) (int, error) {
+	n, err := c.read(b)
+	if err != nil && err != io.EOF {
+		err = &OpError{Op: "read", Net: "tcp", Addr: c.raddr, Err: err}
+	}
+	return n, err
+}
+
+// Write encrypts and writes data to the connection.
+func (c *TLSConn) Write(b []byte) (int, error) {
+	n, err := c.write(b)
+	if err != nil {
+		err = &OpError{Op: "write", Net: "tcp", Addr: c.raddr, Err: err}
+	}
+	return n, err
+}
+
+// Close closes the connection.
+func (c *TLSConn) Close() error {
+	if err := c.close(); err != nil {
+		return &OpError{Op: "close", Net: "tcp", Addr: c.raddr, Err: err}
+	}
+	return nil
+}
+
+// LocalAddr returns the local network address, if known.
+func (c *TLSConn) LocalAddr() Addr {
+	if ip, err := c.dev.Addr(); err == nil {
+		return &TCPAddr{IP: ip}
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote network address.
+func (c *TLSConn) RemoteAddr() Addr {
+	return c.raddr
+}
+
+// ServerName returns the server name that was used for the handshake.
+func (c *TLSConn) ServerName() string {
+	return c.serverName
+}
@@ -0,0 +1,143 @@
+package net
+
+import (
+	"time"
+)
+
+// UDPConn is the implementation of the Conn interface for UDP network
+// connections. A UDPConn created with Dial is connected and can be used with
+// Read and Write, one created with ListenUDP should be used with ReadFrom and
+// WriteTo.
+type UDPConn struct {
+	conn
+	laddr *UDPAddr
+	raddr *UDPAddr
+}
+
+// DialUDP acts like Dial for UDP networks.
+func DialUDP(network string, laddr, raddr *UDPAddr) (*UDPConn, error) {
+	if proto, err := checkNetwork(network); err != nil || proto != ProtocolUDP {
+		return nil, &OpError{Op: "dial", Net: network, Err: UnknownNetworkError(network)}
+	}
+	if raddr == nil {
+		return nil, &OpError{Op: "dial", Net: network, Err: errMissingAddr}
+	}
+	var lip IP
+	var lport int
+	if laddr != nil {
+		lip, lport = laddr.IP, laddr.Port
+	}
+	c, err := dialSocket(ProtocolUDP, lip, lport, "", raddr.IP, raddr.Port, time.Time{}, nil)
+	if err != nil {
+		return nil, &OpError{Op: "dial", Net: network, Addr: raddr, Err: err}
+	}
+	return &UDPConn{conn: *c, laddr: laddr, raddr: raddr}, nil
+}
+
+// ListenUDP creates a UDP socket bound to the given local address. If the IP
+// field of laddr is nil, it receives on all addresses of the device.
+func ListenUDP(network string, laddr *UDPAddr) (*UDPConn, error) {
+	if proto, err := checkNetwork(network); err != nil || proto != ProtocolUDP {
+		return nil, &OpError{Op: "listen", Net: network, Err: UnknownNetworkError(network)}
+	}
+	if laddr == nil {
+		laddr = &UDPAddr{}
+	}
+	c, err := listenSocket(ProtocolUDP, laddr.IP, laddr.Port)
+	if err != nil {
+		return nil, &OpError{Op: "listen", Net: network, Addr: laddr, Err: err}
+	}
+	return &UDPConn{conn: *c, laddr: laddr}, nil
+}
+
+// Read reads a datagram from a connected socket. If b is too small, the rest
+// of the datagram is discarded.
+func (c *UDPConn) Read(b []byte) (int, error) {
+	if !c.ok() {
+		return 0, &OpError{Op: "read", Net: "udp", Addr: c.RemoteAddr(), Err: ErrClosed}
+	}
+	n, err := c.dev.Recv(c.fd, b, c.readDeadline)
+	if err != nil {
+		err = &OpError{Op: "read", Net: "udp", Addr: c.RemoteAddr(), Err: err}
+	}
+	return n, err
+}
+
+// Write sends b as a single datagram over a connected socket.
+func (c *UDPConn) Write(b []byte) (int, error) {
+	if !c.ok() {
+		return 0, &OpError{Op: "write", Net: "udp", Addr: c.RemoteAddr(), Err: ErrClosed}
+	}
+	n, err := c.dev.Send(c.fd, b, c.writeDeadline)
+	if err != nil {
+		err = &OpError{Op: "write", Net: "udp", Addr: c.RemoteAddr(), Err: err}
+	}
+	return n, err
+}
+
+// ReadFrom reads a datagram and returns the address it was sent from.
+func (c *UDPConn) ReadFrom(b []byte) (int, Addr, error) {
+	n, addr, err := c.ReadFromUDP(b)
+	if addr == nil {
+		return n, nil, err
+	}
+	return n, addr, err
+}
+
+// ReadFromUDP acts like ReadFrom but returns a UDPAddr.
+func (c *UDPConn) ReadFromUDP(b []byte) (int, *UDPAddr, error) {
+	if !c.ok() {
+		return 0, nil, &OpError{Op: "read", Net: "udp", Addr: c.laddr, Err: ErrClosed}
+	}
+	n, ip, port, err := c.dev.RecvFrom(c.fd, b, c.readDeadline)
+	if err != nil {
+		return n, nil, &OpError{Op: "read", Net: "udp", Addr: c.laddr, Err: err}
+	}
+	return n, &UDPAddr{IP: ip, Port: port}, nil
+}
+
+// WriteTo sends b as a single datagram to the given address, which must be a
+// *UDPAddr.
+func (c *UDPConn) WriteTo(b []byte, addr Addr) (int, error) {
+	a, ok := addr.(*UDPAddr)
+	if !ok {
+		return 0, &OpError{Op: "write", Net: "udp", Addr: addr, Err: &AddrError{Err: "unexpected address type", Addr: addr.String()}}
+	}
+	return c.WriteToUDP(b, a)
+}
+
+// WriteToUDP acts like WriteTo but takes a UDPAddr.
+func (c *UDPConn) WriteToUDP(b []byte, addr *UDPAddr) (int, error) {
+	if !c.ok() {
+		return 0, &OpError{Op: "write", Net: "udp", Addr: addr, Err: ErrClosed}
+	}
+	n, err := c.dev.SendTo(c.fd, b, addr.IP, addr.Port, c.writeDeadline)
+	if err != nil {
+		err = &OpError{Op: "write", Net: "udp", Addr: addr, Err: err}
+	}
+	return n, err
+}
+
+// Close closes the connection.
+func (c *UDPConn) Close() error {
+	if err := c.close(); err != nil {
+		return &OpError{Op: "close", Net: "udp", Addr: c.RemoteAddr(), Err: err}
+	}
+	return nil
+}
+
+// LocalAddr returns the local network address, if known.
+func (c *UDPConn) LocalAddr() Addr {
+	if c.laddr == nil {
+		return nil
+	}
+	return c.laddr
+}
+
+// RemoteAddr returns the remote network address of a connected socket.
+func (c *UDPConn) RemoteAddr() Addr {
+	if c.raddr == nil {
+		return nil
+	}
+	return c.raddr
+}
@@ -0,0 +1,248 @@
+package netstack
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// DHCP client, see RFC 2131. It obtains an address when DHCP is enabled with
+// SetConfig and renews the lease in the background.
+
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+	dhcpNak      = 6
+
+	dhcpOptSubnetMask  = 1
+	dhcpOptRouter      = 3
+	dhcpOptDNS         = 6
+	dhcpOptHostname    = 12
+	dhcpOptRequestedIP = 50
+	dhcpOptLeaseTime   = 51
+	dhcpOptMessageType = 53
+	dhcpOptServerID    = 54
+	dhcpOptParamList   = 55
+	dhcpOptEnd         = 255
+
+	dhcpHeaderLen = 240
+	dhcpTimeout   = 2 * time.Second
+	dhcpAttempts  = 4
+)
+
+var errDHCPFailed = errors.New("netstack: no reply from DHCP server")
+
+var dhcpMagic = [4]byte{99, 130, 83, 99}
+
+// dhcpLease is the configuration offered by a DHCP server.
+type dhcpLease struct {
+	ip       addr
+	mask     addr
+	router   addr
+	dns      addr
+	server   addr
+	duration time.Duration
+}
+
+// dhcpConfigure obtains a lease with the DISCOVER, OFFER, REQUEST, ACK
+// exchange and applies it.
+func (s *Stack) dhcpConfigure() error {
+	s.mu.Lock()
+	s.configGen++
+	gen := s.configGen
+	s.unlock()
+
+	fd, err := s.Socket(net.ProtocolUDP)
+	if err != nil {
+		return err
+	}
+	defer s.Close(fd)
+	if err := s.Bind(fd, nil, 68); err != nil {
+		return err
+	}
+	for i := 0; i < dhcpAttempts; i++ {
+		xid := s.newID()
+		if err := s.dhcpSend(fd, dhcpDiscover, xid, addr{}, nil); err != nil {
+			return err
+		}
+		typ, offer := s.dhcpRecv(fd, xid)
+		if typ != dhcpOffer {
+			continue
+		}
+		if err := s.dhcpSend(fd, dhcpRequest, xid, addr{}, &offer); err != nil {
+			return err
+		}
+		typ, lease := s.dhcpRecv(fd, xid)
+		if typ != dhcpAck {
+			continue
+		}
+		if lease.server.isZero() {
+			lease.server = offer.server
+		}
+		s.dhcpApply(gen, &lease)
+		return nil
+	}
+	return errDHCPFailed
+}
+
+// dhcpApply sets the configuration from a lease and starts renewing it.
+func (s *Stack) dhcpApply(gen int, lease *dhcpLease) {
+	s.mu.Lock()
+	defer s.unlock()
+	if gen != s.configGen {
+		// The configuration was changed in the meantime.
+		return
+	}
+	s.ip = lease.ip
+	s.mask = lease.mask
+	if s.mask.isZero() {
+		s.mask = addr{255, 255, 255, 0}
+	}
+	s.gateway = lease.router
+	s.dns = lease.dns
+	if lease.duration > 0 {
+		go s.dhcpRenew(gen, *lease)
+	}
+}
+
+// dhcpRenew renews a lease halfway through its duration. When the server
+// doesn't answer, it starts over after the lease has expired.
+func (s *Stack) dhcpRenew(gen int, lease dhcpLease) {
+	time.Sleep(lease.duration / 2)
+	s.mu.Lock()
+	current := gen == s.configGen
+	s.unlock()
+	if !current {
+		return
+	}
+	fd, err := s.Socket(net.ProtocolUDP)
+	if err == nil {
+		err = s.Bind(fd, nil, 68)
+		for i := 0; i < dhcpAttempts && err == nil; i++ {
+			xid := s.newID()
+			err = s.dhcpSend(fd, dhcpRequest, xid, lease.ip, &lease)
+			typ, renewed := s.dhcpRecv(fd, xid)
+			if typ == dhcpAck {
+				s.Close(fd)
+				if renewed.server.isZero() {
+					renewed.server = lease.server
+				}
+				s.dhcpApply(gen, &renewed)
+				return
+			}
+		}
+		s.Close(fd)
+	}
+	time.Sleep(lease.duration / 2)
+	s.mu.Lock()
+	if gen == s.configGen {
+		s.ip = addr{}
+	}
+	s.unlock()
+	s.dhcpConfigure()
+}
+
+// dhcpSend sends a DHCP message. A REQUEST for an offer is broadcast, while a
+// REQUEST to renew a lease (with ciaddr set) is sent to the server directly.
+func (s *Stack) dhcpSend(fd int, typ uint8, xid uint32, ciaddr addr, offer *dhcpLease) error {
+	s.mu.Lock()
+	mac := s.mac
+	hostname := s.hostname
+	s.unlock()
+
+	msg := make([]byte, dhcpHeaderLen, dhcpHeaderLen+32+len(hostname))
+	msg[0] = 1 // BOOTREQUEST
+	msg[1] = 1 // Ethernet
+	msg[2] = 6 // hardware address length
+	putBe32(msg[4:8], xid)
+	if ciaddr.isZero() {
+		putBe16(msg[10:12], 0x8000) // ask for a broadcast reply
+	}
+	copy(msg[12:16], ciaddr[:])
+	copy(msg[28:34], mac[:])
+	copy(msg[236:240], dhcpMagic[:])
+	msg = append(msg, dhcpOptMessageType, 1, typ)
+	if typ == dhcpRequest && ciaddr.isZero() {
+		msg = append(msg, dhcpOptRequestedIP, 4)
+		msg = append(msg, offer.ip[:]...)
+		msg = append(msg, dhcpOptServerID, 4)
+		msg = append(msg, offer.server[:]...)
+	}
+	if hostname != "" && len(hostname) < 256 {
+		msg = append(msg, dhcpOptHostname, byte(len(hostname)))
+		msg = append(msg, hostname...)
+	}
+	msg = append(msg, dhcpOptParamList, 3, dhcpOptSubnetMask, dhcpOptRouter, dhcpOptDNS)
+	msg = append(msg, dhcpOptEnd)
+
+	dst := broadcastAddr
+	if !ciaddr.isZero() {
+		dst = offer.server
+	}
+	_, err := s.sendTo(fd, msg, dst, 67, time.Now().Add(dhcpTimeout))
+	return err
+}
+
+// dhcpRecv waits for a reply to the given transaction and returns its type, or
+// 0 if no reply arrived in time.
+func (s *Stack) dhcpRecv(fd int, xid uint32) (uint8, dhcpLease) {
+	var buf [576]byte
+	deadline := time.Now().Add(dhcpTimeout)
+	for {
+		n, _, port, err := s.RecvFrom(fd, buf[:], deadline)
+		if err != nil {
+			return 0, dhcpLease{}
+		}
+		if port != 67 {
+			continue
+		}
+		if typ, lease := parseDHCP(buf[:n], xid); typ != 0 {
+			return typ, lease
+		}
+	}
+}
+
+// parseDHCP parses a reply from a DHCP server. It returns 0 as the message
+// type if the message is invalid or belongs to another transaction.
+func parseDHCP(msg []byte, xid uint32) (uint8, dhcpLease) {
+	var lease dhcpLease
+	if len(msg) < dhcpHeaderLen || msg[0] != 2 || be32(msg[4:8]) != xid {
+		return 0, lease
+	}
+	if [4]byte{msg[236], msg[237], msg[238], msg[239]} != dhcpMagic {
+		return 0, lease
+	}
+	copy(lease.ip[:], msg[16:20])
+	var typ uint8
+	options := msg[dhcpHeaderLen:]
+	for len(options) >= 2 && options[0] != dhcpOptEnd {
+		if options[0] == 0 {
+			// Padding.
+			options = options[1:]
+			continue
+		}
+		code, length := options[0], int(options[1])
+		if 2+length > len(options) {
+			break
+		}
+		value := options[2 : 2+length]
+		switch {
+		case code == dhcpOptMessageType && length == 1:
+			typ = value[0]
+		case code == dhcpOptSubnetMask && length == 4:
+			copy(lease.mask[:], value)
+		case code == dhcpOptRouter && length >= 4:
+			copy(lease.router[:], value)
+		case code == dhcpOptDNS && length >= 4:
+			copy(lease.dns[:], value)
+		case code == dhcpOptServerID && length == 4:
+			copy(lease.server[:], value)
+		case code == dhcpOptLeaseTime && length == 4:
+			lease.duration = time.Duration(be32(value)) * time.Second
+		}
+		options = options[2+length:]
+	}
+	return typ, lease
+}
@@ -0,0 +1,151 @@
+package netstack
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// A simple DNS resolver that looks up A records on the DNS server of the
+// network. There is no cache.
+
+const (
+	dnsTimeout  = 2 * time.Second
+	dnsAttempts = 3
+)
+
+var (
+	errNoDNSServer = errors.New("netstack: no DNS server configured")
+	errNoSuchHost  = errors.New("netstack: no such host")
+	errDNSTimeout  = errors.New("netstack: no reply from DNS server")
+	errDNSFailed   = errors.New("netstack: DNS server failure")
+	errBadName     = errors.New("netstack: invalid host name")
+)
+
+// resolve returns the IPv4 address of a host.
+func (s *Stack) resolve(name string) (net.IP, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		return ip, nil
+	}
+	s.mu.Lock()
+	server := s.dns
+	s.unlock()
+	if server.isZero() {
+		return nil, errNoDNSServer
+	}
+	query, err := dnsQuery(name, uint16(s.newID()))
+	if err != nil {
+		return nil, err
+	}
+	fd, err := s.Socket(net.ProtocolUDP)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close(fd)
+	if err := s.Connect(fd, "", server.IP(), 53, time.Time{}); err != nil {
+		return nil, err
+	}
+	var buf [512]byte
+	for i := 0; i < dnsAttempts; i++ {
+		deadline := time.Now().Add(dnsTimeout)
+		if _, err := s.Send(fd, query, deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := s.Recv(fd, buf[:], deadline)
+			if err != nil {
+				break
+			}
+			ip, ok, err := parseDNSReply(buf[:n], query)
+			if ok {
+				return ip, err
+			}
+		}
+	}
+	return nil, errDNSTimeout
+}
+
+// dnsQuery creates a recursive query for the A record of a name.
+func dnsQuery(name string, id uint16) ([]byte, error) {
+	if len(name) > 0 && name[len(name)-1] == '.' {
+		name = name[:len(name)-1]
+	}
+	if len(name) == 0 || len(name) > 253 {
+		return nil, errBadName
+	}
+	query := make([]byte, 12, 12+len(name)+6)
+	putBe16(query[0:2], id)
+	putBe16(query[2:4], 0x0100) // recursion desired
+	putBe16(query[4:6], 1)      // one question
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && name[i] != '.' {
+			continue
+		}
+		label := name[start:i]
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errBadName
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+		start = i + 1
+	}
+	query = append(query, 0, 0, 1, 0, 1) // type A, class IN
+	return query, nil
+}
+
+// parseDNSReply returns the first address in a reply. It returns false if the
+// message is not a reply to the query.
+func parseDNSReply(msg, query []byte) (net.IP, bool, error) {
+	if len(msg) < 12 || be16(msg[0:2]) != be16(query[0:2]) || msg[2]&0x80 == 0 {
+		return nil, false, nil
+	}
+	switch msg[3] & 0xf {
+	case 0:
+	case 3:
+		return nil, true, errNoSuchHost
+	default:
+		return nil, true, errDNSFailed
+	}
+	qdcount, ancount := int(be16(msg[4:6])), int(be16(msg[6:8]))
+	pos := 12
+	for i := 0; i < qdcount && pos >= 0; i++ {
+		pos = skipDNSName(msg, pos)
+		if pos >= 0 {
+			pos += 4
+		}
+	}
+	for i := 0; i < ancount; i++ {
+		pos = skipDNSName(msg, pos)
+		if pos < 0 || pos+10 > len(msg) {
+			break
+		}
+		typ, class, length := be16(msg[pos:]), be16(msg[pos+2:]), int(be16(msg[pos+8:]))
+		pos += 10
+		if pos+length > len(msg) {
+			break
+		}
+		if typ == 1 && class == 1 && length == 4 {
+			return net.IPv4(msg[pos], msg[pos+1], msg[pos+2], msg[pos+3]), true, nil
+		}
+		pos += length
+	}
+	return nil, true, errNoSuchHost
+}
+
+// skipDNSName returns the position after the (possibly compressed) name at pos,
+// or -1 if the name is invalid.
+func skipDNSName(msg []byte, pos int) int {
+	for pos >= 0 && pos < len(msg) {
+		length := int(msg[pos])
+		switch {
+		case length == 0:
+			return pos + 1
+		case length&0xc0 == 0xc0:
+			return pos + 2
+		default:
+			pos += 1 + length
+		}
+	}
+	return -1
+}
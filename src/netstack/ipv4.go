@@ -0,0 +1,343 @@
+package netstack
+
+// Ethernet, ARP, IPv4 and ICMP handling.
+
+import (
+	"time"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+
+	ipProtoICMP = 1
+	ipProtoTCP  = 6
+	ipProtoUDP  = 17
+
+	etherHeaderLen = 14
+	ipHeaderLen    = 20
+
+	// Maximum size of the payload of an IP packet. Larger packets would need
+	// fragmentation, which is not supported.
+	maxIPPayload = 1500 - ipHeaderLen
+)
+
+var broadcastMAC = [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// isMulticast returns whether a is an IPv4 multicast address (224.0.0.0/4).
+func isMulticast(a addr) bool {
+	return a[0]&0xf0 == 0xe0
+}
+
+// multicastMAC returns the Ethernet address that frames for a multicast group
+// are sent to. It contains the low 23 bits of the group address.
+func multicastMAC(group addr) [6]byte {
+	return [6]byte{0x01, 0x00, 0x5e, group[1] & 0x7f, group[2], group[3]}
+}
+
+func be16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func putBe16(b []byte, v uint16) {
+	b[0] = byte(v >> 8)
+	b[1] = byte(v)
+}
+
+func putBe32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
+
+// checksum adds the data to a running one's complement sum.
+func checksum(sum uint32, data []byte) uint32 {
+	for len(data) >= 2 {
+		sum += uint32(data[0])<<8 | uint32(data[1])
+		data = data[2:]
+	}
+	if len(data) != 0 {
+		sum += uint32(data[0]) << 8
+	}
+	return sum
+}
+
+// foldChecksum folds a running sum into the final 16-bit checksum.
+func foldChecksum(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// pseudoChecksum returns the running sum of the pseudo header used by the TCP
+// and UDP checksums.
+func pseudoChecksum(src, dst addr, proto uint8, length int) uint32 {
+	sum := checksum(0, src[:])
+	sum = checksum(sum, dst[:])
+	return sum + uint32(proto) + uint32(length)
+}
+
+type arpEntry struct {
+	ip  addr
+	mac [6]byte
+}
+
+// handleFrame processes a single incoming Ethernet frame.
+func (s *Stack) handleFrame(frame []byte) {
+	if len(frame) < etherHeaderLen {
+		return
+	}
+	switch be16(frame[12:14]) {
+	case etherTypeARP:
+		s.handleARP(frame[etherHeaderLen:])
+	case etherTypeIPv4:
+		s.handleIPv4(frame[etherHeaderLen:])
+	}
+}
+
+func (s *Stack) handleARP(pkt []byte) {
+	if len(pkt) < 28 || be16(pkt[0:2]) != 1 || be16(pkt[2:4]) != etherTypeIPv4 {
+		return
+	}
+	var senderMAC [6]byte
+	var senderIP, targetIP addr
+	copy(senderMAC[:], pkt[8:14])
+	copy(senderIP[:], pkt[14:18])
+	copy(targetIP[:], pkt[24:28])
+	if !senderIP.isZero() {
+		s.arpUpdate(senderIP, senderMAC)
+	}
+	if be16(pkt[6:8]) == 1 && !s.ip.isZero() && targetIP == s.ip {
+		s.sendARP(2, senderMAC, senderIP)
+	}
+}
+
+// sendARP sends an ARP request (op 1) or reply (op 2).
+func (s *Stack) sendARP(op uint16, mac [6]byte, ip addr) {
+	frame := s.txbuf[:etherHeaderLen+28]
+	dst := mac
+	if op == 1 {
+		dst = broadcastMAC
+		mac = [6]byte{}
+	}
+	copy(frame[0:6], dst[:])
+	copy(frame[6:12], s.mac[:])
+	putBe16(frame[12:14], etherTypeARP)
+	pkt := frame[etherHeaderLen:]
+	putBe16(pkt[0:2], 1)
+	putBe16(pkt[2:4], etherTypeIPv4)
+	pkt[4] = 6
+	pkt[5] = 4
+	putBe16(pkt[6:8], op)
+	copy(pkt[8:14], s.mac[:])
+	copy(pkt[14:18], s.ip[:])
+	copy(pkt[18:24], mac[:])
+	copy(pkt[24:28], ip[:])
+	s.queueFrame(frame)
+}
+
+func (s *Stack) arpLookup(ip addr) ([6]byte, bool) {
+	for _, e := range s.arpCache {
+		if e.ip == ip && !ip.isZero() {
+			return e.mac, true
+		}
+	}
+	return [6]byte{}, false
+}
+
+func (s *Stack) arpUpdate(ip addr, mac [6]byte) {
+	for i := range s.arpCache {
+		if s.arpCache[i].ip == ip {
+			s.arpCache[i].mac = mac
+			return
+		}
+	}
+	s.arpCache[s.arpNext] = arpEntry{ip, mac}
+	s.arpNext = (s.arpNext + 1) % len(s.arpCache)
+}
+
+// nextHop returns the address a packet to dst must be sent to on the local
+// network.
+func (s *Stack) nextHop(dst addr) addr {
+	for i := range dst {
+		if dst[i]&s.mask[i] != s.ip[i]&s.mask[i] {
+			return s.gateway
+		}
+	}
+	return dst
+}
+
+// isBroadcast returns whether dst is the limited or subnet broadcast address.
+func (s *Stack) isBroadcast(dst addr) bool {
+	if dst == broadcastAddr {
+		return true
+	}
+	if s.mask.isZero() {
+		return false
+	}
+	for i := range dst {
+		if dst[i]|s.mask[i] != 0xff || dst[i]&s.mask[i] != s.ip[i]&s.mask[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolved returns whether the hardware address of dst is known, and sends an
+// ARP request if it isn't.
+func (s *Stack) resolved(dst addr) bool {
+	if s.isBroadcast(dst) || isMulticast(dst) {
+		return true
+	}
+	hop := s.nextHop(dst)
+	if _, ok := s.arpLookup(hop); ok {
+		return true
+	}
+	if !hop.isZero() {
+		s.sendARP(1, [6]byte{}, hop)
+	}
+	return false
+}
+
+// waitResolved waits until the hardware address of dst is known. It must be
+// called without holding the lock.
+func (s *Stack) waitResolved(dst addr, deadline time.Time) error {
+	// Give up after a few ARP requests.
+	for i := 0; ; i++ {
+		s.mu.Lock()
+		ok := s.resolved(dst)
+		s.unlock()
+		if ok {
+			return nil
+		}
+		for j := time.Duration(0); j < 250*time.Millisecond; j += pollInterval {
+			if err := waitDeadline(deadline); err != nil {
+				return err
+			}
+			s.mu.Lock()
+			hop := s.nextHop(dst)
+			_, ok := s.arpLookup(hop)
+			s.unlock()
+			if ok {
+				return nil
+			}
+		}
+		if i == 3 {
+			return errHostUnreach
+		}
+	}
+}
+
+// joined returns whether a socket has joined the given multicast group.
+func (s *Stack) joined(group addr) bool {
+	for _, sk := range s.sockets {
+		if sk != nil && sk.member(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipPayload returns the part of the transmit buffer where the payload of an
+// outgoing IP packet must be written.
+func (s *Stack) ipPayload() []byte {
+	return s.txbuf[etherHeaderLen+ipHeaderLen:]
+}
+
+// sendIPv4 queues an IP packet, of which the payload has already been written
+// to ipPayload. The packet is dropped if the destination hardware address is
+// not known yet; an ARP request is sent instead.
+func (s *Stack) sendIPv4(dst addr, proto uint8, length int) bool {
+	var mac [6]byte
+	ttl := uint8(64)
+	if s.isBroadcast(dst) {
+		mac = broadcastMAC
+	} else if isMulticast(dst) {
+		// Multicast protocols like mDNS expect a TTL of 255, so that receivers
+		// can check that the packet wasn't routed.
+		mac = multicastMAC(dst)
+		ttl = 255
+	} else {
+		var ok bool
+		mac, ok = s.arpLookup(s.nextHop(dst))
+		if !ok {
+			// Note: this overwrites the transmit buffer.
+			s.resolved(dst)
+			return false
+		}
+	}
+	frame := s.txbuf[:etherHeaderLen+ipHeaderLen+length]
+	copy(frame[0:6], mac[:])
+	copy(frame[6:12], s.mac[:])
+	putBe16(frame[12:14], etherTypeIPv4)
+	hdr := frame[etherHeaderLen : etherHeaderLen+ipHeaderLen]
+	hdr[0] = 0x45 // version 4, 20 byte header
+	hdr[1] = 0
+	putBe16(hdr[2:4], uint16(ipHeaderLen+length))
+	s.ipID++
+	putBe16(hdr[4:6], s.ipID)
+	putBe16(hdr[6:8], 0x4000) // don't fragment
+	hdr[8] = ttl
+	hdr[9] = proto
+	putBe16(hdr[10:12], 0)
+	copy(hdr[12:16], s.ip[:])
+	copy(hdr[16:20], dst[:])
+	putBe16(hdr[10:12], foldChecksum(checksum(0, hdr)))
+	s.queueFrame(frame)
+	return true
+}
+
+func (s *Stack) handleIPv4(pkt []byte) {
+	if len(pkt) < ipHeaderLen || pkt[0]>>4 != 4 {
+		return
+	}
+	hlen := int(pkt[0]&0xf) * 4
+	total := int(be16(pkt[2:4]))
+	if hlen < ipHeaderLen || total < hlen || total > len(pkt) {
+		return
+	}
+	if foldChecksum(checksum(0, pkt[:hlen])) != 0 {
+		return
+	}
+	if be16(pkt[6:8])&0x3fff != 0 {
+		// Fragmented packet.
+		return
+	}
+	var src, dst addr
+	copy(src[:], pkt[12:16])
+	copy(dst[:], pkt[16:20])
+	if dst != s.ip && !s.isBroadcast(dst) && !s.ip.isZero() && !(isMulticast(dst) && s.joined(dst)) {
+		return
+	}
+	payload := pkt[hlen:total]
+	switch pkt[9] {
+	case ipProtoICMP:
+		s.handleICMP(src, dst, payload)
+	case ipProtoUDP:
+		s.handleUDP(src, dst, payload)
+	case ipProtoTCP:
+		s.handleTCP(src, dst, payload)
+	}
+}
+
+// handleICMP answers echo requests (ping).
+func (s *Stack) handleICMP(src, dst addr, pkt []byte) {
+	if len(pkt) < 8 || pkt[0] != 8 || dst != s.ip {
+		return
+	}
+	if foldChecksum(checksum(0, pkt)) != 0 {
+		return
+	}
+	out := s.ipPayload()
+	copy(out, pkt)
+	out[0] = 0 // echo reply
+	putBe16(out[2:4], 0)
+	putBe16(out[2:4], foldChecksum(checksum(0, out[:len(pkt)])))
+	s.sendIPv4(src, ipProtoICMP, len(pkt))
+}
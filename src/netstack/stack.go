@@ -0,0 +1,535 @@
+// Package netstack implements a small TCP/IPv4 stack on top of a network
+// interface that sends and receives Ethernet frames, such as an Ethernet MAC
+// or a WiFi chip in raw mode. It implements net.Netdev, so that it can be used
+// with the standard Dial and Listen functions of the net package:
+//
+//	stack := netstack.New(link)
+//	go stack.Run()
+//	net.UseNetdev(stack)
+//	err := net.Configure(net.Config{DHCP: true, Hostname: "tinygo"})
+//
+// The stack supports ARP, ICMP echo, UDP with multicast (as needed for mDNS),
+// TCP, a DHCP client and a DNS resolver. It is optimized for code size and
+// simplicity rather than throughput: TCP segments that arrive out of order are
+// dropped and there is no congestion control. IP fragments are not supported.
+//
+// All protocol processing happens in the goroutine that calls Run. Blocking
+// socket operations wait by sleeping on the scheduler until they can proceed
+// or their deadline expires.
+package netstack
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Link is a network interface that sends and receives Ethernet frames.
+type Link interface {
+	// HardwareAddr returns the MAC address of the interface.
+	HardwareAddr() [6]byte
+
+	// SendFrame transmits an Ethernet frame, starting with the destination
+	// address and without the frame check sequence. It is called without
+	// holding the lock of the stack, so it may block, but never by more than
+	// one goroutine at a time.
+	SendFrame(frame []byte) error
+
+	// RecvFrame copies a received Ethernet frame to buf and returns its length.
+	// It returns 0 without blocking if no frame is pending.
+	RecvFrame(buf []byte) (int, error)
+}
+
+// MulticastLink is implemented by links that filter multicast frames by their
+// destination address. The stack calls AddMulticast when a socket joins a
+// multicast group, so that the frames for the group are received.
+type MulticastLink interface {
+	AddMulticast(mac [6]byte) error
+}
+
+// How often the stack polls the link when it is idle, and how often blocking
+// calls check whether they can proceed.
+const pollInterval = 2 * time.Millisecond
+
+// maxSockets is the maximum number of sockets that can be open at once.
+const maxSockets = 16
+
+// maxTxQueue is the maximum number of frames waiting to be sent. Frames are
+// dropped when the queue is full, like on a busy network interface; TCP sends
+// them again later.
+const maxTxQueue = 8
+
+var (
+	errNoSockets    = errors.New("netstack: too many open sockets")
+	errBadSocket    = errors.New("netstack: invalid socket")
+	errAddrInUse    = errors.New("netstack: address already in use")
+	errNotConnected = errors.New("netstack: socket is not connected")
+	errRefused      = errors.New("netstack: connection refused")
+	errReset        = errors.New("netstack: connection reset by peer")
+	errTimedOut     = errors.New("netstack: connection timed out")
+	errNoAddress    = errors.New("netstack: no IP address configured")
+	errHostUnreach  = errors.New("netstack: host unreachable")
+	errMessageSize  = errors.New("netstack: message too long")
+)
+
+// addr is an IPv4 address.
+type addr [4]byte
+
+var broadcastAddr = addr{255, 255, 255, 255}
+
+func toAddr(ip net.IP) addr {
+	var a addr
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(a[:], ip4)
+	}
+	return a
+}
+
+func (a addr) IP() net.IP {
+	return net.IPv4(a[0], a[1], a[2], a[3])
+}
+
+func (a addr) isZero() bool {
+	return a == addr{}
+}
+
+// Stack is a TCP/IPv4 stack running on a single network interface.
+type Stack struct {
+	mu       sync.Mutex
+	link     Link
+	mac      [6]byte
+	ip       addr
+	mask     addr
+	gateway  addr
+	dns      addr
+	hostname string
+	dhcp     bool
+	// configGen is incremented on every configuration change, to stop DHCP
+	// from overwriting a newer configuration.
+	configGen  int
+	arpCache   [8]arpEntry
+	arpNext    int
+	sockets    [maxSockets]*socket
+	nextPort   uint16
+	issCounter uint32
+	ipID       uint16
+	rxbuf      [1514]byte
+	txbuf      [1514]byte
+	// Frames that were built in txbuf while the lock was held, and that are
+	// sent once it is released. See unlock.
+	txQueue [][]byte
+	txFree  [][]byte
+	sending bool
+}
+
+// New creates a new stack on the given network interface. Run must be called
+// in a separate goroutine for the stack to do anything.
+func New(link Link) *Stack {
+	return &Stack{
+		link:     link,
+		mac:      link.HardwareAddr(),
+		nextPort: 49152 + uint16(time.Now().UnixNano()&0x3fff),
+	}
+}
+
+// Run processes incoming frames and timers. It never returns.
+func (s *Stack) Run() {
+	for {
+		n, err := s.link.RecvFrame(s.rxbuf[:])
+		s.mu.Lock()
+		if err == nil && n > 0 {
+			s.handleFrame(s.rxbuf[:n])
+		}
+		s.tick(time.Now())
+		s.unlock()
+		if n == 0 {
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+// queueFrame queues a frame for transmission once the lock is released. The
+// frame is copied, so it may be built in txbuf. The lock must be held.
+func (s *Stack) queueFrame(frame []byte) {
+	if len(s.txQueue) >= maxTxQueue {
+		return
+	}
+	var buf []byte
+	if n := len(s.txFree); n != 0 {
+		buf = s.txFree[n-1]
+		s.txFree = s.txFree[:n-1]
+	}
+	s.txQueue = append(s.txQueue, append(buf, frame...))
+}
+
+// unlock releases the lock and sends the queued frames. The link is never
+// called with the lock held: the mutex doesn't support contention, so another
+// goroutine taking the lock while SendFrame blocks would panic. If another
+// goroutine is already sending, it will also send the frames queued here.
+func (s *Stack) unlock() {
+	if s.sending {
+		s.mu.Unlock()
+		return
+	}
+	s.sending = true
+	for len(s.txQueue) != 0 {
+		frame := s.txQueue[0]
+		n := copy(s.txQueue, s.txQueue[1:])
+		s.txQueue[n] = nil
+		s.txQueue = s.txQueue[:n]
+		s.mu.Unlock()
+		s.link.SendFrame(frame)
+		s.mu.Lock()
+		s.txFree = append(s.txFree, frame[:0])
+	}
+	s.sending = false
+	s.mu.Unlock()
+}
+
+// tick handles timers, such as TCP retransmissions.
+func (s *Stack) tick(now time.Time) {
+	for fd, sk := range s.sockets {
+		if sk != nil && sk.proto == net.ProtocolTCP {
+			s.tcpTimer(fd, sk, now)
+		}
+	}
+}
+
+// waitDeadline sleeps for a poll interval, or returns a timeout error if the
+// deadline has expired. It must be called without holding the lock.
+func waitDeadline(deadline time.Time) error {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return net.ErrDeadlineExceeded
+	}
+	time.Sleep(pollInterval)
+	return nil
+}
+
+// getSocket returns the socket for the given file descriptor, or nil. The
+// lock must be held.
+func (s *Stack) getSocket(fd int) *socket {
+	if fd < 0 || fd >= maxSockets {
+		return nil
+	}
+	return s.sockets[fd]
+}
+
+// allocPort returns an unused ephemeral port.
+func (s *Stack) allocPort(proto net.Protocol) uint16 {
+	for {
+		port := s.nextPort
+		s.nextPort++
+		if s.nextPort == 0 {
+			s.nextPort = 49152
+		}
+		if !s.portInUse(proto, port) {
+			return port
+		}
+	}
+}
+
+func (s *Stack) portInUse(proto net.Protocol, port uint16) bool {
+	for _, sk := range s.sockets {
+		if sk != nil && sk.proto == proto && sk.localPort == port && sk.parent == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Netdev implementation.
+
+// GetHostByName resolves a host name with the DNS server of the network.
+func (s *Stack) GetHostByName(name string) (net.IP, error) {
+	return s.resolve(name)
+}
+
+// Addr returns the IPv4 address of the stack.
+func (s *Stack) Addr() (net.IP, error) {
+	s.mu.Lock()
+	ip := s.ip
+	s.unlock()
+	if ip.isZero() {
+		return nil, errNoAddress
+	}
+	return ip.IP(), nil
+}
+
+// Socket creates a new TCP or UDP socket.
+func (s *Stack) Socket(protocol net.Protocol) (int, error) {
+	if protocol != net.ProtocolTCP && protocol != net.ProtocolUDP {
+		return -1, errors.New("netstack: unsupported protocol")
+	}
+	s.mu.Lock()
+	defer s.unlock()
+	return s.newSocket(protocol)
+}
+
+func (s *Stack) newSocket(protocol net.Protocol) (int, error) {
+	for fd, sk := range s.sockets {
+		if sk == nil {
+			s.sockets[fd] = &socket{proto: protocol}
+			return fd, nil
+		}
+	}
+	return -1, errNoSockets
+}
+
+// Bind sets the local port of the socket. Only the port is used: the stack
+// has a single address.
+func (s *Stack) Bind(sockfd int, ip net.IP, port int) error {
+	s.mu.Lock()
+	defer s.unlock()
+	sk := s.getSocket(sockfd)
+	if sk == nil {
+		return errBadSocket
+	}
+	if port == 0 {
+		sk.localPort = s.allocPort(sk.proto)
+		return nil
+	}
+	if s.portInUse(sk.proto, uint16(port)) {
+		return errAddrInUse
+	}
+	sk.localPort = uint16(port)
+	return nil
+}
+
+// Connect connects a socket. For TCP this performs the three-way handshake,
+// for UDP it only sets the remote address.
+func (s *Stack) Connect(sockfd int, host string, ip net.IP, port int, deadline time.Time) error {
+	s.mu.Lock()
+	sk := s.getSocket(sockfd)
+	if sk == nil {
+		s.unlock()
+		return errBadSocket
+	}
+	if sk.localPort == 0 {
+		sk.localPort = s.allocPort(sk.proto)
+	}
+	sk.remoteIP = toAddr(ip)
+	sk.remotePort = uint16(port)
+	if sk.proto == net.ProtocolUDP {
+		sk.connected = true
+		s.unlock()
+		return nil
+	}
+	s.tcpConnect(sk)
+	s.unlock()
+
+	for {
+		s.mu.Lock()
+		state, err := sk.state, sk.err
+		s.unlock()
+		if state == tcpStateEstablished {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := waitDeadline(deadline); err != nil {
+			s.mu.Lock()
+			s.tcpAbort(sk)
+			s.unlock()
+			return err
+		}
+	}
+}
+
+// Listen starts accepting connections on a bound TCP socket.
+func (s *Stack) Listen(sockfd int, backlog int) error {
+	s.mu.Lock()
+	defer s.unlock()
+	sk := s.getSocket(sockfd)
+	if sk == nil || sk.proto != net.ProtocolTCP {
+		return errBadSocket
+	}
+	sk.state = tcpStateListen
+	sk.backlog = backlog
+	return nil
+}
+
+// Accept waits for an incoming TCP connection.
+func (s *Stack) Accept(sockfd int, deadline time.Time) (int, net.IP, int, error) {
+	for {
+		s.mu.Lock()
+		sk := s.getSocket(sockfd)
+		if sk == nil || sk.state != tcpStateListen {
+			s.unlock()
+			return -1, nil, 0, errBadSocket
+		}
+		if len(sk.acceptQueue) != 0 {
+			fd := sk.acceptQueue[0]
+			sk.acceptQueue = sk.acceptQueue[1:]
+			child := s.sockets[fd]
+			child.parent = nil
+			ip, port := child.remoteIP.IP(), int(child.remotePort)
+			s.unlock()
+			return fd, ip, port, nil
+		}
+		s.unlock()
+		if err := waitDeadline(deadline); err != nil {
+			return -1, nil, 0, err
+		}
+	}
+}
+
+// Send sends data over a connected socket.
+func (s *Stack) Send(sockfd int, buf []byte, deadline time.Time) (int, error) {
+	s.mu.Lock()
+	sk := s.getSocket(sockfd)
+	if sk == nil {
+		s.unlock()
+		return 0, errBadSocket
+	}
+	if sk.proto == net.ProtocolUDP {
+		if !sk.connected {
+			s.unlock()
+			return 0, errNotConnected
+		}
+		ip, port := sk.remoteIP, sk.remotePort
+		s.unlock()
+		return s.sendTo(sockfd, buf, ip, port, deadline)
+	}
+	s.unlock()
+	return s.tcpSend(sk, buf, deadline)
+}
+
+// Recv receives data from a connected socket.
+func (s *Stack) Recv(sockfd int, buf []byte, deadline time.Time) (int, error) {
+	s.mu.Lock()
+	sk := s.getSocket(sockfd)
+	s.unlock()
+	if sk == nil {
+		return 0, errBadSocket
+	}
+	if sk.proto == net.ProtocolUDP {
+		n, _, _, err := s.recvFrom(sk, buf, deadline)
+		return n, err
+	}
+	return s.tcpRecv(sk, buf, deadline)
+}
+
+// SendTo sends a UDP datagram.
+func (s *Stack) SendTo(sockfd int, buf []byte, ip net.IP, port int, deadline time.Time) (int, error) {
+	return s.sendTo(sockfd, buf, toAddr(ip), uint16(port), deadline)
+}
+
+// RecvFrom receives a UDP datagram.
+func (s *Stack) RecvFrom(sockfd int, buf []byte, deadline time.Time) (int, net.IP, int, error) {
+	s.mu.Lock()
+	sk := s.getSocket(sockfd)
+	s.unlock()
+	if sk == nil || sk.proto != net.ProtocolUDP {
+		return 0, nil, 0, errBadSocket
+	}
+	n, ip, port, err := s.recvFrom(sk, buf, deadline)
+	if err != nil {
+		return n, nil, 0, err
+	}
+	return n, ip.IP(), int(port), nil
+}
+
+// SetKeepAlive enables or disables keepalive probes on a TCP socket.
+func (s *Stack) SetKeepAlive(sockfd int, period time.Duration) error {
+	s.mu.Lock()
+	defer s.unlock()
+	sk := s.getSocket(sockfd)
+	if sk == nil || sk.proto != net.ProtocolTCP {
+		return errBadSocket
+	}
+	sk.keepAlive = period
+	sk.lastRecv = time.Now()
+	sk.probes = 0
+	return nil
+}
+
+// Multicaster implementation.
+
+// JoinGroup makes a UDP socket receive the datagrams sent to a multicast
+// group, such as the one of mDNS.
+func (s *Stack) JoinGroup(sockfd int, group net.IP) error {
+	g := toAddr(group)
+	if !isMulticast(g) {
+		return errors.New("netstack: not a multicast address")
+	}
+	s.mu.Lock()
+	sk := s.getSocket(sockfd)
+	if sk == nil || sk.proto != net.ProtocolUDP {
+		s.unlock()
+		return errBadSocket
+	}
+	member := sk.member(g)
+	s.unlock()
+	if member {
+		return nil
+	}
+	if ml, ok := s.link.(MulticastLink); ok {
+		if err := ml.AddMulticast(multicastMAC(g)); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	if !sk.member(g) {
+		sk.groups = append(sk.groups, g)
+	}
+	s.unlock()
+	return nil
+}
+
+// Close closes a socket. TCP connections are closed gracefully in the
+// background.
+func (s *Stack) Close(sockfd int) error {
+	s.mu.Lock()
+	defer s.unlock()
+	sk := s.getSocket(sockfd)
+	if sk == nil {
+		return errBadSocket
+	}
+	if sk.proto == net.ProtocolTCP {
+		s.tcpClose(sockfd, sk)
+		return nil
+	}
+	sk.closed = true
+	s.sockets[sockfd] = nil
+	return nil
+}
+
+// Configurer implementation.
+
+// SetConfig sets a static IPv4 configuration, or obtains one with DHCP.
+func (s *Stack) SetConfig(config net.Config) error {
+	s.mu.Lock()
+	s.hostname = config.Hostname
+	s.dhcp = config.DHCP
+	if config.DHCP {
+		s.ip, s.mask, s.gateway, s.dns = addr{}, addr{}, addr{}, addr{}
+		s.unlock()
+		return s.dhcpConfigure()
+	}
+	s.configGen++
+	s.ip = toAddr(config.IP)
+	s.gateway = toAddr(config.Gateway)
+	s.dns = toAddr(config.DNS)
+	s.mask = addr{255, 255, 255, 0}
+	if len(config.Mask) == 4 {
+		copy(s.mask[:], config.Mask)
+	}
+	s.unlock()
+	return nil
+}
+
+// Config returns the current IPv4 configuration.
+func (s *Stack) Config() (net.Config, error) {
+	s.mu.Lock()
+	defer s.unlock()
+	return net.Config{
+		DHCP:     s.dhcp,
+		IP:       s.ip.IP(),
+		Mask:     net.IPv4Mask(s.mask[0], s.mask[1], s.mask[2], s.mask[3]),
+		Gateway:  s.gateway.IP(),
+		DNS:      s.dns.IP(),
+		Hostname: s.hostname,
+	}, nil
+}
@@ -0,0 +1,216 @@
+package netstack
+
+// These tests connect two stacks with a virtual Ethernet cable. They are run
+// with the host Go toolchain by TestNetstack in the root of the repository,
+// which provides the TinyGo version of the net package.
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// loopbackLink is one end of a virtual Ethernet cable.
+type loopbackLink struct {
+	t       *testing.T
+	mac     [6]byte
+	stack   *Stack
+	peer    *loopbackLink
+	senders int32
+
+	mu sync.Mutex
+	rx [][]byte
+}
+
+func (l *loopbackLink) HardwareAddr() [6]byte {
+	return l.mac
+}
+
+func (l *loopbackLink) SendFrame(frame []byte) error {
+	if !l.stack.sending {
+		l.t.Error("frame sent outside of unlock, possibly with the stack locked")
+	}
+	if atomic.AddInt32(&l.senders, 1) != 1 {
+		l.t.Error("SendFrame called by more than one goroutine at a time")
+	}
+	// Block for a while like a real network interface, so that other
+	// goroutines get a chance to use the stack.
+	time.Sleep(100 * time.Microsecond)
+	l.peer.mu.Lock()
+	l.peer.rx = append(l.peer.rx, append([]byte(nil), frame...))
+	l.peer.mu.Unlock()
+	atomic.AddInt32(&l.senders, -1)
+	return nil
+}
+
+func (l *loopbackLink) RecvFrame(buf []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.rx) == 0 {
+		return 0, nil
+	}
+	n := copy(buf, l.rx[0])
+	l.rx = l.rx[1:]
+	return n, nil
+}
+
+// newLoopback returns two running stacks on the 10.0.0.0/24 network, with the
+// addresses 10.0.0.1 and 10.0.0.2.
+func newLoopback(t *testing.T) (a, b *Stack) {
+	la := &loopbackLink{t: t, mac: [6]byte{0x02, 0, 0, 0, 0, 1}}
+	lb := &loopbackLink{t: t, mac: [6]byte{0x02, 0, 0, 0, 0, 2}, peer: la}
+	la.peer = lb
+	a = New(la)
+	b = New(lb)
+	la.stack = a
+	lb.stack = b
+	for i, s := range []*Stack{a, b} {
+		err := s.SetConfig(net.Config{
+			IP:   net.IPv4(10, 0, 0, byte(i+1)),
+			Mask: net.IPv4Mask(255, 255, 255, 0),
+		})
+		if err != nil {
+			t.Fatal("could not configure stack:", err)
+		}
+		go s.Run()
+	}
+	return a, b
+}
+
+func testDeadline() time.Time {
+	return time.Now().Add(10 * time.Second)
+}
+
+// pattern returns n bytes of test data.
+func pattern(n int) []byte {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = byte(i * 7 / 3)
+	}
+	return buf
+}
+
+// recvFull reads exactly len(buf) bytes from a TCP socket.
+func recvFull(s *Stack, fd int, buf []byte) error {
+	for read := 0; read < len(buf); {
+		n, err := s.Recv(fd, buf[read:], testDeadline())
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errNotConnected
+		}
+		read += n
+	}
+	return nil
+}
+
+func TestTCPLoopback(t *testing.T) {
+	a, b := newLoopback(t)
+	data := pattern(3*tcpBufSize + 100)
+
+	// Echo server on 10.0.0.2:80.
+	lfd, err := b.Socket(net.ProtocolTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Bind(lfd, nil, 80); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Listen(lfd, 2); err != nil {
+		t.Fatal(err)
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		fd, ip, _, err := b.Accept(lfd, testDeadline())
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		if !ip.Equal(net.IPv4(10, 0, 0, 1)) {
+			t.Error("unexpected remote address:", ip)
+		}
+		buf := make([]byte, len(data))
+		if err := recvFull(b, fd, buf); err != nil {
+			serverErr <- err
+			return
+		}
+		_, err = b.Send(fd, buf, testDeadline())
+		b.Close(fd)
+		serverErr <- err
+	}()
+
+	fd, err := a.Socket(net.ProtocolTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Connect(fd, "", net.IPv4(10, 0, 0, 2), 80, testDeadline()); err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	if n, err := a.Send(fd, data, testDeadline()); n != len(data) || err != nil {
+		t.Fatalf("could not send: %d, %v", n, err)
+	}
+	echo := make([]byte, len(data))
+	if err := recvFull(a, fd, echo); err != nil {
+		t.Fatal("could not receive:", err)
+	}
+	if !bytes.Equal(echo, data) {
+		t.Error("echoed data differs from the data sent")
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatal("server failed:", err)
+	}
+
+	// The server has closed the connection.
+	if n, err := a.Recv(fd, echo, testDeadline()); n != 0 || err != nil {
+		t.Errorf("expected the end of the stream, got %d, %v", n, err)
+	}
+	if err := a.Close(fd); err != nil {
+		t.Error("could not close:", err)
+	}
+}
+
+func TestTCPRefused(t *testing.T) {
+	a, _ := newLoopback(t)
+	fd, err := a.Socket(net.ProtocolTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.Connect(fd, "", net.IPv4(10, 0, 0, 2), 81, testDeadline())
+	if err != errRefused {
+		t.Error("expected errRefused, got", err)
+	}
+	a.Close(fd)
+}
+
+func TestUDPLoopback(t *testing.T) {
+	a, b := newLoopback(t)
+	bfd, err := b.Socket(net.ProtocolUDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Bind(bfd, nil, 5000); err != nil {
+		t.Fatal(err)
+	}
+	afd, err := a.Socket(net.ProtocolUDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Bind(afd, nil, 6000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.SendTo(afd, []byte("ping"), net.IPv4(10, 0, 0, 2), 5000, testDeadline()); err != nil {
+		t.Fatal("could not send:", err)
+	}
+	buf := make([]byte, 16)
+	n, ip, port, err := b.RecvFrom(bfd, buf, testDeadline())
+	if err != nil {
+		t.Fatal("could not receive:", err)
+	}
+	if string(buf[:n]) != "ping" || !ip.Equal(net.IPv4(10, 0, 0, 1)) || port != 6000 {
+		t.Errorf("unexpected datagram %q from %v:%d", buf[:n], ip, port)
+	}
+}
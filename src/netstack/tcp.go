@@ -0,0 +1,611 @@
+package netstack
+
+import (
+	"net"
+	"time"
+)
+
+// This is a minimal TCP implementation. Notable simplifications:
+//   - Segments that arrive out of order are dropped, relying on the peer to
+//     retransmit them.
+//   - Retransmission is go-back-N with an exponential backoff starting at a
+//     fixed timeout, not based on measured round-trip times.
+//   - There is no congestion control or delayed acknowledgement.
+
+const (
+	tcpHeaderLen = 20
+
+	// Size of the send and receive buffer of each connection.
+	tcpBufSize = 2048
+
+	// Default maximum segment size if the peer doesn't send one, and the one we
+	// advertise (the largest that fits in an Ethernet frame).
+	tcpDefaultMSS = 536
+	tcpMaxMSS     = maxIPPayload - tcpHeaderLen
+
+	tcpInitialRTO = time.Second
+	tcpMaxRetries = 6
+	tcpTimeWait   = 2 * time.Second
+
+	// Number of unanswered keepalive probes after which the connection is
+	// considered dead.
+	tcpKeepAliveProbes = 9
+)
+
+const (
+	tcpFlagFIN = 1 << iota
+	tcpFlagSYN
+	tcpFlagRST
+	tcpFlagPSH
+	tcpFlagACK
+)
+
+type tcpState uint8
+
+const (
+	tcpStateClosed tcpState = iota
+	tcpStateListen
+	tcpStateSynSent
+	tcpStateSynReceived
+	tcpStateEstablished
+	tcpStateFinWait1
+	tcpStateFinWait2
+	tcpStateCloseWait
+	tcpStateClosing
+	tcpStateLastAck
+	tcpStateTimeWait
+)
+
+// socket is a TCP or UDP socket.
+type socket struct {
+	proto      net.Protocol
+	closed     bool // closed by the application
+	localPort  uint16
+	remoteIP   addr
+	remotePort uint16
+	err        error
+
+	// UDP
+	connected bool
+	datagrams []datagram
+	groups    []addr // multicast groups joined with JoinGroup
+
+	// TCP
+	state       tcpState
+	parent      *socket // listening socket, until the connection is accepted
+	backlog     int
+	acceptQueue []int
+	sndUna      uint32 // oldest unacknowledged sequence number
+	sndNxt      uint32 // next sequence number to send
+	rcvNxt      uint32 // next sequence number expected from the peer
+	sndWnd      uint16
+	mss         uint16
+	finSent     bool
+	finReceived bool
+	txbuf       []byte // data not yet acknowledged by the peer
+	rxbuf       []byte // data not yet read by the application
+	timer       time.Time
+	retries     uint8
+	keepAlive   time.Duration // idle time before sending a keepalive probe
+	lastRecv    time.Time     // when the last segment was received
+	probes      uint8         // number of unanswered keepalive probes
+}
+
+// newISS returns a new initial sequence number. It is also used for DHCP and
+// DNS transaction IDs. The lock must be held.
+func (s *Stack) newISS() uint32 {
+	s.issCounter += 64000
+	return uint32(time.Now().UnixNano()/4000) + s.issCounter
+}
+
+// newID returns a new transaction ID, taking the lock.
+func (s *Stack) newID() uint32 {
+	s.mu.Lock()
+	defer s.unlock()
+	return s.newISS()
+}
+
+func seqLT(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func seqLE(a, b uint32) bool {
+	return int32(a-b) <= 0
+}
+
+func (sk *socket) initTCP(iss uint32) {
+	sk.sndUna = iss
+	sk.sndNxt = iss + 1
+	sk.mss = tcpDefaultMSS
+	sk.txbuf = make([]byte, 0, tcpBufSize)
+	sk.rxbuf = make([]byte, 0, tcpBufSize)
+}
+
+// window returns the receive window to advertise.
+func (sk *socket) window() uint16 {
+	return uint16(cap(sk.rxbuf) - len(sk.rxbuf))
+}
+
+func (sk *socket) armTimer() {
+	sk.timer = time.Now().Add(tcpInitialRTO << sk.retries)
+}
+
+// sendTCP sends a single TCP segment.
+func (s *Stack) sendTCP(dst addr, srcPort, dstPort uint16, seq, ack uint32, flags uint8, window uint16, data []byte) {
+	hlen := tcpHeaderLen
+	if flags&tcpFlagSYN != 0 {
+		hlen += 4 // MSS option
+	}
+	pkt := s.ipPayload()[:hlen+len(data)]
+	putBe16(pkt[0:2], srcPort)
+	putBe16(pkt[2:4], dstPort)
+	putBe32(pkt[4:8], seq)
+	putBe32(pkt[8:12], ack)
+	pkt[12] = uint8(hlen/4) << 4
+	pkt[13] = flags
+	putBe16(pkt[14:16], window)
+	putBe16(pkt[16:18], 0) // checksum
+	putBe16(pkt[18:20], 0) // urgent pointer
+	if flags&tcpFlagSYN != 0 {
+		pkt[20] = 2 // MSS
+		pkt[21] = 4
+		putBe16(pkt[22:24], tcpMaxMSS)
+	}
+	copy(pkt[hlen:], data)
+	putBe16(pkt[16:18], foldChecksum(checksum(pseudoChecksum(s.ip, dst, ipProtoTCP, len(pkt)), pkt)))
+	s.sendIPv4(dst, ipProtoTCP, len(pkt))
+}
+
+// sendSegment sends a segment on a connection.
+func (s *Stack) sendSegment(sk *socket, flags uint8, seq uint32, data []byte) {
+	s.sendTCP(sk.remoteIP, sk.localPort, sk.remotePort, seq, sk.rcvNxt, flags, sk.window(), data)
+}
+
+// tcpConnect starts the three-way handshake.
+func (s *Stack) tcpConnect(sk *socket) {
+	sk.initTCP(s.newISS())
+	sk.state = tcpStateSynSent
+	s.sendSegment(sk, tcpFlagSYN, sk.sndUna, nil)
+	sk.armTimer()
+}
+
+// tcpAbort resets a connection.
+func (s *Stack) tcpAbort(sk *socket) {
+	switch sk.state {
+	case tcpStateSynReceived, tcpStateEstablished, tcpStateFinWait1, tcpStateFinWait2, tcpStateCloseWait, tcpStateClosing, tcpStateLastAck:
+		s.sendSegment(sk, tcpFlagRST|tcpFlagACK, sk.sndNxt, nil)
+	}
+	sk.state = tcpStateClosed
+	sk.timer = time.Time{}
+}
+
+// tcpClose closes a connection after all data has been sent, or frees it
+// right away if there is no connection.
+func (s *Stack) tcpClose(fd int, sk *socket) {
+	sk.closed = true
+	switch sk.state {
+	case tcpStateListen:
+		// Reset all connections that have not been accepted yet.
+		for childfd, child := range s.sockets {
+			if child != nil && child.parent == sk {
+				s.tcpAbort(child)
+				s.sockets[childfd] = nil
+			}
+		}
+		s.sockets[fd] = nil
+	case tcpStateEstablished:
+		sk.state = tcpStateFinWait1
+		s.tcpOutput(sk)
+	case tcpStateCloseWait:
+		sk.state = tcpStateLastAck
+		s.tcpOutput(sk)
+	case tcpStateFinWait1, tcpStateFinWait2, tcpStateClosing, tcpStateLastAck, tcpStateTimeWait:
+		// Already closing.
+	default:
+		s.tcpAbort(sk)
+		s.sockets[fd] = nil
+	}
+}
+
+// tcpOutput sends as much pending data as the peer's window allows, followed
+// by a FIN if the connection is being closed.
+func (s *Stack) tcpOutput(sk *socket) {
+	switch sk.state {
+	case tcpStateEstablished, tcpStateCloseWait, tcpStateFinWait1, tcpStateClosing, tcpStateLastAck:
+	default:
+		return
+	}
+	if sk.finSent {
+		return
+	}
+	for {
+		inflight := int(sk.sndNxt - sk.sndUna)
+		n := len(sk.txbuf) - inflight
+		if wnd := int(sk.sndWnd) - inflight; n > wnd {
+			n = wnd
+		}
+		if n > int(sk.mss) {
+			n = int(sk.mss)
+		}
+		if n <= 0 {
+			break
+		}
+		s.sendSegment(sk, tcpFlagACK|tcpFlagPSH, sk.sndNxt, sk.txbuf[inflight:inflight+n])
+		sk.sndNxt += uint32(n)
+		if sk.timer.IsZero() {
+			sk.armTimer()
+		}
+	}
+	wantFIN := sk.state == tcpStateFinWait1 || sk.state == tcpStateLastAck || sk.state == tcpStateClosing
+	if wantFIN && int(sk.sndNxt-sk.sndUna) == len(sk.txbuf) {
+		s.sendSegment(sk, tcpFlagFIN|tcpFlagACK, sk.sndNxt, nil)
+		sk.sndNxt++
+		sk.finSent = true
+		if sk.timer.IsZero() {
+			sk.armTimer()
+		}
+	}
+}
+
+// tcpTimer handles retransmissions and TIME-WAIT expiry, and frees sockets
+// that are no longer used.
+func (s *Stack) tcpTimer(fd int, sk *socket, now time.Time) {
+	if sk.state == tcpStateClosed && sk.closed {
+		s.sockets[fd] = nil
+		return
+	}
+	if sk.keepAlive != 0 && sk.sndUna == sk.sndNxt && (sk.state == tcpStateEstablished || sk.state == tcpStateCloseWait) {
+		if now.Sub(sk.lastRecv) >= sk.keepAlive*time.Duration(sk.probes+1) {
+			if sk.probes == tcpKeepAliveProbes {
+				sk.err = errTimedOut
+				s.tcpAbort(sk)
+				return
+			}
+			// A keepalive probe is an empty segment with an old sequence
+			// number, which the peer must acknowledge.
+			sk.probes++
+			s.sendSegment(sk, tcpFlagACK, sk.sndNxt-1, nil)
+		}
+	}
+	if sk.timer.IsZero() || now.Before(sk.timer) {
+		return
+	}
+	if sk.state == tcpStateTimeWait {
+		sk.state = tcpStateClosed
+		sk.timer = time.Time{}
+		return
+	}
+	sk.retries++
+	if sk.retries > tcpMaxRetries {
+		sk.err = errTimedOut
+		s.tcpAbort(sk)
+		return
+	}
+	sk.armTimer()
+	switch sk.state {
+	case tcpStateSynSent:
+		s.sendSegment(sk, tcpFlagSYN, sk.sndUna, nil)
+	case tcpStateSynReceived:
+		s.sendSegment(sk, tcpFlagSYN|tcpFlagACK, sk.sndUna, nil)
+	default:
+		// Go back to the oldest unacknowledged byte and send everything again.
+		// A zero window is treated as a one byte window, so that the peer keeps
+		// acknowledging (a window probe).
+		sk.sndNxt = sk.sndUna
+		sk.finSent = false
+		if sk.sndWnd == 0 {
+			sk.sndWnd = 1
+		}
+		s.tcpOutput(sk)
+	}
+}
+
+func (s *Stack) handleTCP(src, dst addr, pkt []byte) {
+	if len(pkt) < tcpHeaderLen || dst != s.ip {
+		return
+	}
+	if foldChecksum(checksum(pseudoChecksum(src, dst, ipProtoTCP, len(pkt)), pkt)) != 0 {
+		return
+	}
+	srcPort, dstPort := be16(pkt[0:2]), be16(pkt[2:4])
+	seq, ack := be32(pkt[4:8]), be32(pkt[8:12])
+	hlen := int(pkt[12]>>4) * 4
+	flags := pkt[13]
+	window := be16(pkt[14:16])
+	if hlen < tcpHeaderLen || hlen > len(pkt) {
+		return
+	}
+	data := pkt[hlen:]
+	mss := uint16(tcpDefaultMSS)
+	if flags&tcpFlagSYN != 0 {
+		mss = parseMSS(pkt[tcpHeaderLen:hlen])
+	}
+
+	// Find the connection, or a listening socket.
+	var sk, listener *socket
+	for _, candidate := range s.sockets {
+		if candidate == nil || candidate.proto != net.ProtocolTCP || candidate.localPort != dstPort {
+			continue
+		}
+		if candidate.state == tcpStateListen {
+			listener = candidate
+		} else if candidate.state != tcpStateClosed && candidate.remoteIP == src && candidate.remotePort == srcPort {
+			sk = candidate
+			break
+		}
+	}
+	if sk == nil {
+		if listener != nil && flags&(tcpFlagSYN|tcpFlagRST|tcpFlagACK) == tcpFlagSYN {
+			s.tcpAcceptSYN(listener, src, srcPort, seq, window, mss)
+			return
+		}
+		// No connection: reply with a reset.
+		if flags&tcpFlagRST != 0 {
+			return
+		}
+		if flags&tcpFlagACK != 0 {
+			s.sendTCP(src, dstPort, srcPort, ack, 0, tcpFlagRST, 0, nil)
+		} else {
+			seglen := uint32(len(data))
+			if flags&tcpFlagSYN != 0 {
+				seglen++
+			}
+			if flags&tcpFlagFIN != 0 {
+				seglen++
+			}
+			s.sendTCP(src, dstPort, srcPort, 0, seq+seglen, tcpFlagRST|tcpFlagACK, 0, nil)
+		}
+		return
+	}
+
+	sk.lastRecv = time.Now()
+	sk.probes = 0
+
+	if sk.state == tcpStateSynSent {
+		if flags&tcpFlagACK != 0 && ack != sk.sndNxt {
+			if flags&tcpFlagRST == 0 {
+				s.sendTCP(src, dstPort, srcPort, ack, 0, tcpFlagRST, 0, nil)
+			}
+			return
+		}
+		if flags&tcpFlagRST != 0 {
+			if flags&tcpFlagACK != 0 {
+				sk.err = errRefused
+				sk.state = tcpStateClosed
+				sk.timer = time.Time{}
+			}
+			return
+		}
+		if flags&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN|tcpFlagACK {
+			sk.rcvNxt = seq + 1
+			sk.sndUna = ack
+			sk.sndWnd = window
+			sk.mss = mss
+			sk.state = tcpStateEstablished
+			sk.timer = time.Time{}
+			sk.retries = 0
+			s.sendSegment(sk, tcpFlagACK, sk.sndNxt, nil)
+		}
+		return
+	}
+
+	if flags&tcpFlagRST != 0 {
+		if seq-sk.rcvNxt <= uint32(sk.window()) {
+			if sk.state == tcpStateSynReceived {
+				// Never accepted, so nobody will close it.
+				sk.closed = true
+			}
+			sk.err = errReset
+			sk.state = tcpStateClosed
+			sk.timer = time.Time{}
+		}
+		return
+	}
+	if flags&tcpFlagSYN != 0 {
+		if sk.state == tcpStateSynReceived && seq+1 == sk.rcvNxt {
+			// Our SYN-ACK was lost.
+			s.sendSegment(sk, tcpFlagSYN|tcpFlagACK, sk.sndUna, nil)
+		} else {
+			s.sendSegment(sk, tcpFlagACK, sk.sndNxt, nil)
+		}
+		return
+	}
+	if flags&tcpFlagACK == 0 {
+		return
+	}
+
+	// Process the acknowledgement.
+	if sk.state == tcpStateSynReceived {
+		if ack != sk.sndNxt {
+			s.sendTCP(src, dstPort, srcPort, ack, 0, tcpFlagRST, 0, nil)
+			return
+		}
+		sk.sndUna = ack
+		sk.state = tcpStateEstablished
+		sk.timer = time.Time{}
+		sk.retries = 0
+		for fd, other := range s.sockets {
+			if other == sk {
+				sk.parent.acceptQueue = append(sk.parent.acceptQueue, fd)
+			}
+		}
+	}
+	finAcked := false
+	if seqLT(sk.sndUna, ack) && seqLE(ack, sk.sndNxt) {
+		acked := int(ack - sk.sndUna)
+		if sk.finSent && ack == sk.sndNxt {
+			finAcked = true
+			acked--
+		}
+		sk.txbuf = sk.txbuf[:copy(sk.txbuf, sk.txbuf[acked:])]
+		sk.sndUna = ack
+		sk.retries = 0
+		sk.timer = time.Time{}
+		if sk.sndUna != sk.sndNxt {
+			sk.armTimer()
+		}
+	}
+	if seqLE(ack, sk.sndNxt) {
+		sk.sndWnd = window
+	}
+	if finAcked {
+		switch sk.state {
+		case tcpStateFinWait1:
+			sk.state = tcpStateFinWait2
+		case tcpStateClosing:
+			sk.state = tcpStateTimeWait
+			sk.timer = time.Now().Add(tcpTimeWait)
+		case tcpStateLastAck:
+			sk.state = tcpStateClosed
+			return
+		}
+	}
+
+	// Process incoming data, but only when it is the next expected segment.
+	if len(data) != 0 || flags&tcpFlagFIN != 0 {
+		switch sk.state {
+		case tcpStateEstablished, tcpStateFinWait1, tcpStateFinWait2:
+			if seq == sk.rcvNxt {
+				n := len(data)
+				if free := cap(sk.rxbuf) - len(sk.rxbuf); n > free {
+					n = free
+				}
+				sk.rxbuf = append(sk.rxbuf, data[:n]...)
+				sk.rcvNxt += uint32(n)
+				if flags&tcpFlagFIN != 0 && n == len(data) {
+					sk.rcvNxt++
+					sk.finReceived = true
+					switch sk.state {
+					case tcpStateEstablished:
+						sk.state = tcpStateCloseWait
+					case tcpStateFinWait1:
+						sk.state = tcpStateClosing
+					case tcpStateFinWait2:
+						sk.state = tcpStateTimeWait
+						sk.timer = time.Now().Add(tcpTimeWait)
+					}
+				}
+			}
+		}
+		s.sendSegment(sk, tcpFlagACK, sk.sndNxt, nil)
+	} else if seq+1 == sk.rcvNxt {
+		// Keepalive probe from the peer.
+		s.sendSegment(sk, tcpFlagACK, sk.sndNxt, nil)
+	}
+	s.tcpOutput(sk)
+}
+
+// tcpAcceptSYN creates a new connection in response to a SYN on a listening
+// socket.
+func (s *Stack) tcpAcceptSYN(listener *socket, src addr, srcPort uint16, seq uint32, window, mss uint16) {
+	pending := len(listener.acceptQueue)
+	for _, sk := range s.sockets {
+		if sk != nil && sk.parent == listener && sk.state == tcpStateSynReceived {
+			pending++
+		}
+	}
+	if pending >= listener.backlog {
+		return
+	}
+	fd, err := s.newSocket(net.ProtocolTCP)
+	if err != nil {
+		return
+	}
+	sk := s.sockets[fd]
+	sk.initTCP(s.newISS())
+	sk.parent = listener
+	sk.localPort = listener.localPort
+	sk.remoteIP = src
+	sk.remotePort = srcPort
+	sk.rcvNxt = seq + 1
+	sk.sndWnd = window
+	sk.mss = mss
+	sk.state = tcpStateSynReceived
+	s.sendSegment(sk, tcpFlagSYN|tcpFlagACK, sk.sndUna, nil)
+	sk.armTimer()
+}
+
+// parseMSS returns the maximum segment size from the TCP options of a SYN.
+func parseMSS(options []byte) uint16 {
+	for len(options) != 0 {
+		switch options[0] {
+		case 0: // end of options
+			return tcpDefaultMSS
+		case 1: // no-op
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || options[1] < 2 || int(options[1]) > len(options) {
+			break
+		}
+		if options[0] == 2 && options[1] == 4 {
+			mss := be16(options[2:4])
+			if mss > tcpMaxMSS {
+				mss = tcpMaxMSS
+			}
+			if mss != 0 {
+				return mss
+			}
+		}
+		options = options[options[1]:]
+	}
+	return tcpDefaultMSS
+}
+
+// tcpSend queues data for sending, waiting for space in the send buffer.
+func (s *Stack) tcpSend(sk *socket, buf []byte, deadline time.Time) (int, error) {
+	written := 0
+	for {
+		s.mu.Lock()
+		if sk.state != tcpStateEstablished && sk.state != tcpStateCloseWait {
+			err := sk.err
+			s.unlock()
+			if err == nil {
+				err = errNotConnected
+			}
+			return written, err
+		}
+		n := cap(sk.txbuf) - len(sk.txbuf)
+		if n > len(buf)-written {
+			n = len(buf) - written
+		}
+		sk.txbuf = append(sk.txbuf, buf[written:written+n]...)
+		written += n
+		s.tcpOutput(sk)
+		s.unlock()
+		if written == len(buf) {
+			return written, nil
+		}
+		if err := waitDeadline(deadline); err != nil {
+			return written, err
+		}
+	}
+}
+
+// tcpRecv waits for data from the peer. It returns 0 without an error at the
+// end of the stream.
+func (s *Stack) tcpRecv(sk *socket, buf []byte, deadline time.Time) (int, error) {
+	for {
+		s.mu.Lock()
+		if len(sk.rxbuf) != 0 {
+			wasFull := sk.window() < sk.mss
+			n := copy(buf, sk.rxbuf)
+			sk.rxbuf = sk.rxbuf[:copy(sk.rxbuf, sk.rxbuf[n:])]
+			if wasFull && sk.window() >= sk.mss {
+				// Tell the peer it can send again.
+				s.sendSegment(sk, tcpFlagACK, sk.sndNxt, nil)
+			}
+			s.unlock()
+			return n, nil
+		}
+		if sk.finReceived || sk.state == tcpStateClosed || sk.closed {
+			err := sk.err
+			s.unlock()
+			return 0, err
+		}
+		s.unlock()
+		if err := waitDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+}
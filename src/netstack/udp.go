@@ -0,0 +1,119 @@
+package netstack
+
+import (
+	"net"
+	"time"
+)
+
+const udpHeaderLen = 8
+
+// Maximum number of datagrams queued on a UDP socket. Datagrams that arrive
+// when the queue is full are dropped.
+const udpQueueLen = 4
+
+type datagram struct {
+	ip   addr
+	port uint16
+	data []byte
+}
+
+func (s *Stack) handleUDP(src, dst addr, pkt []byte) {
+	if len(pkt) < udpHeaderLen {
+		return
+	}
+	length := int(be16(pkt[4:6]))
+	if length < udpHeaderLen || length > len(pkt) {
+		return
+	}
+	pkt = pkt[:length]
+	if be16(pkt[6:8]) != 0 && foldChecksum(checksum(pseudoChecksum(src, dst, ipProtoUDP, length), pkt)) != 0 {
+		return
+	}
+	srcPort, dstPort := be16(pkt[0:2]), be16(pkt[2:4])
+	for _, sk := range s.sockets {
+		if sk == nil || sk.proto != net.ProtocolUDP || sk.localPort != dstPort {
+			continue
+		}
+		if sk.connected && (sk.remoteIP != src || sk.remotePort != srcPort) {
+			continue
+		}
+		if isMulticast(dst) && !sk.member(dst) {
+			continue
+		}
+		if len(sk.datagrams) >= udpQueueLen {
+			return
+		}
+		data := make([]byte, length-udpHeaderLen)
+		copy(data, pkt[udpHeaderLen:])
+		sk.datagrams = append(sk.datagrams, datagram{src, srcPort, data})
+		return
+	}
+}
+
+// member returns whether the socket has joined the given multicast group.
+func (sk *socket) member(group addr) bool {
+	for _, g := range sk.groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// sendTo sends a single UDP datagram, waiting for address resolution first if
+// necessary.
+func (s *Stack) sendTo(sockfd int, buf []byte, ip addr, port uint16, deadline time.Time) (int, error) {
+	if len(buf) > maxIPPayload-udpHeaderLen {
+		return 0, errMessageSize
+	}
+	if err := s.waitResolved(ip, deadline); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.unlock()
+	sk := s.getSocket(sockfd)
+	if sk == nil || sk.proto != net.ProtocolUDP {
+		return 0, errBadSocket
+	}
+	if sk.localPort == 0 {
+		sk.localPort = s.allocPort(net.ProtocolUDP)
+	}
+	pkt := s.ipPayload()[:udpHeaderLen+len(buf)]
+	putBe16(pkt[0:2], sk.localPort)
+	putBe16(pkt[2:4], port)
+	putBe16(pkt[4:6], uint16(len(pkt)))
+	putBe16(pkt[6:8], 0)
+	copy(pkt[udpHeaderLen:], buf)
+	sum := foldChecksum(checksum(pseudoChecksum(s.ip, ip, ipProtoUDP, len(pkt)), pkt))
+	if sum == 0 {
+		sum = 0xffff
+	}
+	putBe16(pkt[6:8], sum)
+	if !s.sendIPv4(ip, ipProtoUDP, len(pkt)) {
+		return 0, errHostUnreach
+	}
+	return len(buf), nil
+}
+
+// recvFrom waits for a datagram on the socket. Datagrams that are larger than
+// buf are truncated.
+func (s *Stack) recvFrom(sk *socket, buf []byte, deadline time.Time) (int, addr, uint16, error) {
+	for {
+		s.mu.Lock()
+		if len(sk.datagrams) != 0 {
+			d := sk.datagrams[0]
+			copy(sk.datagrams, sk.datagrams[1:])
+			sk.datagrams = sk.datagrams[:len(sk.datagrams)-1]
+			s.unlock()
+			return copy(buf, d.data), d.ip, d.port, nil
+		}
+		closed := sk.closed
+		s.unlock()
+		if closed {
+			return 0, addr{}, 0, errBadSocket
+		}
+		if err := waitDeadline(deadline); err != nil {
+			return 0, addr{}, 0, err
+		}
+	}
+}
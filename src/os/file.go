@@ -11,6 +11,8 @@ import (
 
 // Portable analogs of some common system call errors.
 var (
+	ErrNotExist    = errors.New("file does not exist")
+	ErrExist       = errors.New("file already exists")
 	errUnsupported = errors.New("operation not supported")
 	notImplemented = errors.New("os: not implemented")
 )
@@ -18,15 +20,16 @@ var (
 // Stdin, Stdout, and Stderr are open Files pointing to the standard input,
 // standard output, and standard error file descriptors.
 var (
-	Stdin  = &File{0, "/dev/stdin"}
-	Stdout = &File{1, "/dev/stdout"}
-	Stderr = &File{2, "/dev/stderr"}
+	Stdin  = &File{fd: 0, name: "/dev/stdin"}
+	Stdout = &File{fd: 1, name: "/dev/stdout"}
+	Stderr = &File{fd: 2, name: "/dev/stderr"}
 )
 
 // File represents an open file descriptor.
 type File struct {
-	fd   uintptr
-	name string
+	fd     uintptr
+	name   string
+	handle FileHandle // set for files on a mounted filesystem
 }
 
 // Readdir is a stub, not yet implemented
@@ -39,14 +42,22 @@ func (f *File) Readdirnames(n int) (names []string, err error) {
 	return nil, notImplemented
 }
 
-// Stat is a stub, not yet implemented
+// Stat returns the FileInfo structure describing the file.
 func (f *File) Stat() (FileInfo, error) {
+	if f.handle != nil {
+		return Stat(f.name)
+	}
 	return nil, notImplemented
 }
 
 // NewFile returns a new File with the given file descriptor and name.
 func NewFile(fd uintptr, name string) *File {
-	return &File{fd, name}
+	return &File{fd: fd, name: name}
+}
+
+// Name returns the name of the file as presented to Open.
+func (f *File) Name() string {
+	return f.name
 }
 
 // Fd returns the integer Unix file descriptor referencing the open file. The
@@ -74,8 +85,21 @@ type PathError struct {
 
 func (e *PathError) Error() string { return e.Op + " " + e.Path + ": " + e.Err.Error() }
 
-// Open is a super simple stub function (for now), only capable of opening stdin, stdout, and stderr
+// Open opens the named file for reading. Apart from stdin, stdout and stderr,
+// only files on a mounted filesystem (see Mount) can be opened.
 func Open(name string) (*File, error) {
+	return OpenFile(name, O_RDONLY, 0)
+}
+
+// OpenFile opens the named file with the specified flag (O_RDONLY etc.).
+func OpenFile(name string, flag int, perm FileMode) (*File, error) {
+	if fs, path := findMount(name); fs != nil {
+		handle, err := fs.OpenFile(path, flag, perm)
+		if err != nil {
+			return nil, &PathError{"open", name, err}
+		}
+		return &File{fd: 999, name: name, handle: handle}, nil
+	}
 	fd := uintptr(999)
 	switch name {
 	case "/dev/stdin":
@@ -87,17 +111,25 @@ func Open(name string) (*File, error) {
 	default:
 		return nil, &PathError{"open", name, notImplemented}
 	}
-	return &File{fd, name}, nil
+	return &File{fd: fd, name: name}, nil
 }
 
-// OpenFile is a stub, passing through to the stub Open() call
-func OpenFile(name string, flag int, perm FileMode) (*File, error) {
-	return Open(name)
+// Create creates or truncates the named file.
+func Create(name string) (*File, error) {
+	return OpenFile(name, O_RDWR|O_CREATE|O_TRUNC, 0666)
 }
 
-// Create is a stub, passing through to the stub Open() call
-func Create(name string) (*File, error) {
-	return Open(name)
+// Remove removes the named file.
+func Remove(name string) error {
+	fs, path := findMount(name)
+	if fs == nil {
+		return &PathError{"remove", name, notImplemented}
+	}
+	err := fs.Remove(path)
+	if err != nil {
+		return &PathError{"remove", name, err}
+	}
+	return nil
 }
 
 type FileMode uint32
@@ -153,14 +185,23 @@ type FileInfo interface {
 	Sys() interface{} // underlying data source (can return nil)
 }
 
-// Stat is a stub, not yet implemented
+// Stat returns a FileInfo describing the named file. Only files on a mounted
+// filesystem are supported.
 func Stat(name string) (FileInfo, error) {
-	return nil, notImplemented
+	fs, path := findMount(name)
+	if fs == nil {
+		return nil, &PathError{"stat", name, notImplemented}
+	}
+	info, err := fs.Stat(path)
+	if err != nil {
+		return nil, &PathError{"stat", name, err}
+	}
+	return info, nil
 }
 
-// Lstat is a stub, not yet implemented
+// Lstat is the same as Stat, as symbolic links are not supported.
 func Lstat(name string) (FileInfo, error) {
-	return nil, notImplemented
+	return Stat(name)
 }
 
 // Getwd is a stub (for now), always returning an empty string
@@ -178,19 +219,40 @@ func TempDir() string {
 	return "/tmp"
 }
 
-// Mkdir is a stub, not yet implemented
+// Mkdir creates a directory. Only mounted filesystems that implement
+// MkdirFilesystem support directories.
 func Mkdir(name string, perm FileMode) error {
-	return notImplemented
+	fs, path := findMount(name)
+	if fs == nil {
+		return &PathError{"mkdir", name, notImplemented}
+	}
+	mfs, ok := fs.(MkdirFilesystem)
+	if !ok {
+		return &PathError{"mkdir", name, errUnsupported}
+	}
+	err := mfs.Mkdir(path, perm)
+	if err != nil {
+		return &PathError{"mkdir", name, err}
+	}
+	return nil
 }
 
-// IsExist is a stub (for now), always returning false
+// IsExist returns a boolean indicating whether the error is known to report
+// that a file or directory already exists.
 func IsExist(err error) bool {
-	return false
+	if pe, ok := err.(*PathError); ok {
+		err = pe.Err
+	}
+	return err == ErrExist
 }
 
-// IsNotExist is a stub (for now), always returning false
+// IsNotExist returns a boolean indicating whether the error is known to report
+// that a file or directory does not exist.
 func IsNotExist(err error) bool {
-	return false
+	if pe, ok := err.(*PathError); ok {
+		err = pe.Err
+	}
+	return err == ErrNotExist
 }
 
 // Getpid is a stub (for now), always returning 1
@@ -1,4 +1,4 @@
-// +build avr cortexm tinygo.riscv wasm
+// +build avr cortexm tinygo.riscv wasm,!wasi
 
 package os
 
@@ -6,14 +6,22 @@ import (
 	_ "unsafe"
 )
 
-// Read is unsupported on this system.
+// Read reads up to len(b) bytes from a file on a mounted filesystem. Reading
+// from other files is unsupported on this system.
 func (f *File) Read(b []byte) (n int, err error) {
+	if f.handle != nil {
+		return f.handle.Read(b)
+	}
 	return 0, errUnsupported
 }
 
 // Write writes len(b) bytes to the output. It returns the number of bytes
-// written or an error if this file is not stdout or stderr.
+// written or an error if this file is not stdout, stderr or a file on a
+// mounted filesystem.
 func (f *File) Write(b []byte) (n int, err error) {
+	if f.handle != nil {
+		return f.handle.Write(b)
+	}
 	switch f.fd {
 	case Stdout.fd, Stderr.fd:
 		for _, c := range b {
@@ -25,8 +33,12 @@ func (f *File) Write(b []byte) (n int, err error) {
 	}
 }
 
-// Close is unsupported on this system.
+// Close closes a file on a mounted filesystem. Closing other files is
+// unsupported on this system.
 func (f *File) Close() error {
+	if f.handle != nil {
+		return f.handle.Close()
+	}
 	return errUnsupported
 }
 
@@ -1,4 +1,4 @@
-// +build darwin linux,!avr,!cortexm,!tinygo.riscv
+// +build darwin linux,!avr,!cortexm,!tinygo.riscv,!wasi
 
 package os
 
@@ -9,16 +9,25 @@ import (
 // Read reads up to len(b) bytes from the File. It returns the number of bytes
 // read and any error encountered. At end of file, Read returns 0, io.EOF.
 func (f *File) Read(b []byte) (n int, err error) {
+	if f.handle != nil {
+		return f.handle.Read(b)
+	}
 	return syscall.Read(int(f.fd), b)
 }
 
 // Write writes len(b) bytes to the File. It returns the number of bytes written
 // and an error, if any. Write returns a non-nil error when n != len(b).
 func (f *File) Write(b []byte) (n int, err error) {
+	if f.handle != nil {
+		return f.handle.Write(b)
+	}
 	return syscall.Write(int(f.fd), b)
 }
 
 // Close closes the File, rendering it unusable for I/O.
 func (f *File) Close() error {
+	if f.handle != nil {
+		return f.handle.Close()
+	}
 	return syscall.Close(int(f.fd))
 }
@@ -0,0 +1,59 @@
+// +build wasi
+
+package os
+
+import (
+	"io"
+	"strconv"
+)
+
+// Reading and writing file descriptors is implemented in the runtime. A
+// goroutine that has to wait for a file descriptor (for example a socket
+// passed in by the host) doesn't block other goroutines.
+func readFD(fd uintptr, b []byte) (n int, errno uint16)
+func writeFD(fd uintptr, b []byte) (n int, errno uint16)
+
+// wasiError is an error number returned by a WASI system call.
+type wasiError uint16
+
+func (e wasiError) Error() string {
+	return "WASI error " + strconv.Itoa(int(e))
+}
+
+// Read reads up to len(b) bytes from the File. It returns the number of bytes
+// read and any error encountered. At end of file, Read returns 0, io.EOF.
+func (f *File) Read(b []byte) (n int, err error) {
+	if f.handle != nil {
+		return f.handle.Read(b)
+	}
+	n, errno := readFD(f.fd, b)
+	if errno != 0 {
+		return n, &PathError{"read", f.name, wasiError(errno)}
+	}
+	if n == 0 && len(b) != 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Write writes len(b) bytes to the File. It returns the number of bytes written
+// and an error, if any. Write returns a non-nil error when n != len(b).
+func (f *File) Write(b []byte) (n int, err error) {
+	if f.handle != nil {
+		return f.handle.Write(b)
+	}
+	n, errno := writeFD(f.fd, b)
+	if errno != 0 {
+		return n, &PathError{"write", f.name, wasiError(errno)}
+	}
+	return n, nil
+}
+
+// Close closes a file on a mounted filesystem. Closing other files is
+// unsupported on this system.
+func (f *File) Close() error {
+	if f.handle != nil {
+		return f.handle.Close()
+	}
+	return errUnsupported
+}
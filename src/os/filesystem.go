@@ -0,0 +1,94 @@
+package os
+
+// This file implements mounting filesystems, so that files on (for example)
+// the flash of a microcontroller can be accessed with the usual functions
+// like Open and Create.
+
+import (
+	"errors"
+	"strings"
+)
+
+// Filesystem is a filesystem that can be mounted with Mount. Paths passed to
+// its methods are relative to the mount point, without a leading slash.
+type Filesystem interface {
+	OpenFile(path string, flag int, perm FileMode) (FileHandle, error)
+	Remove(path string) error
+	Stat(path string) (FileInfo, error)
+}
+
+// MkdirFilesystem is implemented by mounted filesystems that support
+// creating directories.
+type MkdirFilesystem interface {
+	Mkdir(path string, perm FileMode) error
+}
+
+// FileHandle is an open file on a mounted filesystem.
+type FileHandle interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	Close() error
+}
+
+// mountPoint is a single mounted filesystem.
+type mountPoint struct {
+	prefix     string // mount point, with a trailing slash
+	filesystem Filesystem
+}
+
+var mounts []mountPoint
+
+// Mount makes the filesystem accessible under the given directory, for
+// example "/" or "/flash". Mounts are not nested: a path refers to the
+// filesystem with the longest matching mount point.
+func Mount(dir string, filesystem Filesystem) error {
+	prefix := mountPrefix(dir)
+	for _, mount := range mounts {
+		if mount.prefix == prefix {
+			return &PathError{"mount", dir, ErrExist}
+		}
+	}
+	mounts = append(mounts, mountPoint{prefix, filesystem})
+	return nil
+}
+
+// Unmount removes a filesystem that was mounted with Mount. Files that are
+// still open on the filesystem remain usable.
+func Unmount(dir string) error {
+	prefix := mountPrefix(dir)
+	for i, mount := range mounts {
+		if mount.prefix == prefix {
+			mounts = append(mounts[:i], mounts[i+1:]...)
+			return nil
+		}
+	}
+	return &PathError{"unmount", dir, errors.New("not mounted")}
+}
+
+func mountPrefix(dir string) string {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return dir
+}
+
+// findMount returns the filesystem the given path is on and the path relative
+// to the mount point. It returns a nil filesystem if the path is not on a
+// mounted filesystem. Relative paths are relative to the root directory.
+func findMount(name string) (Filesystem, string) {
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	var found *mountPoint
+	for i := range mounts {
+		mount := &mounts[i]
+		if strings.HasPrefix(name, mount.prefix) && (found == nil || len(mount.prefix) > len(found.prefix)) {
+			found = mount
+		}
+	}
+	if found == nil || strings.HasPrefix(name, "/dev/") && found.prefix == "/" {
+		// Don't hide stdin, stdout and stderr behind a root filesystem.
+		return nil, ""
+	}
+	return found.filesystem, name[len(found.prefix):]
+}
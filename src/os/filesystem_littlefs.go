@@ -0,0 +1,106 @@
+// +build filesystem.littlefs
+
+package os
+
+// This file mounts a LittleFS filesystem on the internal flash (machine.Flash)
+// at the root directory at startup. It is enabled with "filesystem":
+// "littlefs" in the target specification. On first use, the flash is
+// formatted.
+
+import (
+	"machine"
+	"machine/littlefs"
+)
+
+func init() {
+	fs, err := littlefs.Mount(machine.Flash)
+	if err == littlefs.ErrCorrupt {
+		fs, err = littlefs.Format(machine.Flash)
+	}
+	if err != nil {
+		// There is no way to report this error, so files simply can't be
+		// opened.
+		return
+	}
+	Mount("/", littleFilesystem{fs})
+}
+
+// littleFilesystem adapts a littlefs.FS to the Filesystem interface.
+type littleFilesystem struct {
+	fs *littlefs.FS
+}
+
+func (f littleFilesystem) OpenFile(path string, flag int, perm FileMode) (FileHandle, error) {
+	var lflag int
+	switch {
+	case flag&O_RDWR != 0:
+		lflag = littlefs.O_RDWR
+	case flag&O_WRONLY != 0:
+		lflag = littlefs.O_WRONLY
+	default:
+		lflag = littlefs.O_RDONLY
+	}
+	if flag&O_CREATE != 0 {
+		lflag |= littlefs.O_CREATE
+	}
+	if flag&O_EXCL != 0 {
+		lflag |= littlefs.O_EXCL
+	}
+	if flag&O_TRUNC != 0 {
+		lflag |= littlefs.O_TRUNC
+	}
+	if flag&O_APPEND != 0 {
+		lflag |= littlefs.O_APPEND
+	}
+	file, err := f.fs.OpenFile(path, lflag)
+	if err != nil {
+		return nil, littlefsError(err)
+	}
+	return file, nil
+}
+
+func (f littleFilesystem) Remove(path string) error {
+	return littlefsError(f.fs.Remove(path))
+}
+
+func (f littleFilesystem) Mkdir(path string, perm FileMode) error {
+	return littlefsError(f.fs.Mkdir(path))
+}
+
+func (f littleFilesystem) Stat(path string) (FileInfo, error) {
+	info, err := f.fs.Stat(path)
+	if err != nil {
+		return nil, littlefsError(err)
+	}
+	return littleFileInfo{info}, nil
+}
+
+// littlefsError converts littlefs errors to the errors of this package where
+// possible, so that IsNotExist and IsExist work.
+func littlefsError(err error) error {
+	switch err {
+	case littlefs.ErrNotExist:
+		return ErrNotExist
+	case littlefs.ErrExist:
+		return ErrExist
+	}
+	return err
+}
+
+// littleFileInfo describes a file or directory on the flash filesystem.
+type littleFileInfo struct {
+	info littlefs.Info
+}
+
+func (fi littleFileInfo) Name() string { return fi.info.Name }
+func (fi littleFileInfo) Size() int64  { return fi.info.Size }
+func (fi littleFileInfo) IsDir() bool  { return fi.info.IsDir }
+
+func (fi littleFileInfo) Mode() FileMode {
+	if fi.info.IsDir {
+		return ModeDir | 0777
+	}
+	return 0666
+}
+
+func (fi littleFileInfo) Sys() interface{} { return nil }
@@ -0,0 +1,174 @@
+// +build filesystem.semihosting
+
+package os
+
+// This file mounts the filesystem of the host at the root directory when
+// running in an emulator with semihosting support, like QEMU with the
+// -semihosting flag. It is enabled with "filesystem": "semihosting" in the
+// target specification. Paths are passed to the host as-is, without the
+// leading slash, so they are relative to the directory the emulator was
+// started in. Stdin is connected to the console of the host.
+
+import (
+	"device/arm"
+	"errors"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+// Semihosting open modes, which correspond to the fopen modes "rb", "r+b",
+// "wb", "w+b", "ab" and "a+b".
+const (
+	semihostingModeRead      = 1
+	semihostingModeReadWrite = 3
+	semihostingModeWrite     = 5
+	semihostingModeCreateRW  = 7
+	semihostingModeAppend    = 9
+	semihostingModeAppendRW  = 11
+)
+
+var errSemihosting = errors.New("semihosting call failed")
+
+func init() {
+	// The special filename ":tt" refers to the console of the host.
+	if handle, err := semihostingOpen(":tt", semihostingModeRead); err == nil {
+		Stdin.handle = handle
+	}
+	Mount("/", semihostingFilesystem{})
+}
+
+// semihostingFilesystem is the filesystem of the host.
+type semihostingFilesystem struct{}
+
+func (f semihostingFilesystem) OpenFile(path string, flag int, perm FileMode) (FileHandle, error) {
+	mode := semihostingModeRead
+	if flag&(O_WRONLY|O_RDWR) != 0 {
+		var err error
+		mode, err = f.writeMode(path, flag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file, err := semihostingOpen(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// writeMode returns the semihosting mode to open a file for writing with the
+// given flags.
+func (f semihostingFilesystem) writeMode(path string, flag int) (int, error) {
+	_, err := f.Stat(path)
+	exists := err == nil
+	if exists && flag&O_CREATE != 0 && flag&O_EXCL != 0 {
+		return 0, ErrExist
+	}
+	if !exists && flag&O_CREATE == 0 {
+		return 0, ErrNotExist
+	}
+	readWrite := flag&O_RDWR != 0
+	switch {
+	case flag&O_APPEND != 0 && readWrite:
+		return semihostingModeAppendRW, nil
+	case flag&O_APPEND != 0:
+		return semihostingModeAppend, nil
+	case flag&O_TRUNC != 0 && !readWrite:
+		return semihostingModeWrite, nil
+	case flag&O_TRUNC != 0 || !exists:
+		return semihostingModeCreateRW, nil
+	default:
+		// Modify the existing file in place.
+		return semihostingModeReadWrite, nil
+	}
+}
+
+func (f semihostingFilesystem) Remove(path string) error {
+	name := []byte(path + "\x00")
+	args := [2]uintptr{uintptr(unsafe.Pointer(&name[0])), uintptr(len(path))}
+	if arm.SemihostingCall(arm.SemihostingRemove, uintptr(unsafe.Pointer(&args))) != 0 {
+		return errSemihosting
+	}
+	return nil
+}
+
+func (f semihostingFilesystem) Stat(path string) (FileInfo, error) {
+	file, err := semihostingOpen(path, semihostingModeRead)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	args := [1]uintptr{uintptr(file)}
+	size := arm.SemihostingCall(arm.SemihostingFileLen, uintptr(unsafe.Pointer(&args)))
+	if size < 0 {
+		return nil, errSemihosting
+	}
+	name := path
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	return semihostingFileInfo{name, int64(size)}, nil
+}
+
+// semihostingOpen opens a file on the host with the given semihosting mode.
+// The host doesn't report why opening failed, so it is assumed the file
+// doesn't exist.
+func semihostingOpen(path string, mode int) (semihostingFile, error) {
+	name := []byte(path + "\x00")
+	args := [3]uintptr{uintptr(unsafe.Pointer(&name[0])), uintptr(mode), uintptr(len(path))}
+	handle := arm.SemihostingCall(arm.SemihostingOpen, uintptr(unsafe.Pointer(&args)))
+	if handle < 0 {
+		return 0, ErrNotExist
+	}
+	return semihostingFile(handle), nil
+}
+
+// semihostingFile is an open file on the host.
+type semihostingFile int
+
+func (f semihostingFile) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	// The host returns the number of bytes that were not read.
+	args := [3]uintptr{uintptr(f), uintptr(unsafe.Pointer(&b[0])), uintptr(len(b))}
+	n = len(b) - arm.SemihostingCall(arm.SemihostingRead, uintptr(unsafe.Pointer(&args)))
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f semihostingFile) Write(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	// The host returns the number of bytes that were not written.
+	args := [3]uintptr{uintptr(f), uintptr(unsafe.Pointer(&b[0])), uintptr(len(b))}
+	n = len(b) - arm.SemihostingCall(arm.SemihostingWrite, uintptr(unsafe.Pointer(&args)))
+	if n != len(b) {
+		return n, errSemihosting
+	}
+	return n, nil
+}
+
+func (f semihostingFile) Close() error {
+	args := [1]uintptr{uintptr(f)}
+	if arm.SemihostingCall(arm.SemihostingClose, uintptr(unsafe.Pointer(&args))) != 0 {
+		return errSemihosting
+	}
+	return nil
+}
+
+// semihostingFileInfo describes a file on the host.
+type semihostingFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi semihostingFileInfo) Name() string     { return fi.name }
+func (fi semihostingFileInfo) Size() int64      { return fi.size }
+func (fi semihostingFileInfo) Mode() FileMode   { return 0666 }
+func (fi semihostingFileInfo) IsDir() bool      { return false }
+func (fi semihostingFileInfo) Sys() interface{} { return nil }
@@ -0,0 +1,100 @@
+package reflect
+
+import (
+	"unsafe"
+)
+
+// This file contains the sidetables with type information that doesn't fit in
+// a type code. The variables below are placeholders: they are replaced with the
+// real sidetables by the compiler in the interface lowering pass. See
+// compiler/reflect.go for details on how they are encoded.
+
+var (
+	// Type codes of the underlying types of named non-basic types.
+	namedNonBasicTypesSidetable uintptr
+
+	// Offsets in namesSidetable of the qualified names of named types (for
+	// example "encoding/json.Number"), separately for named basic and named
+	// non-basic types.
+	namedBasicTypeNamesSidetable    uintptr
+	namedNonBasicTypeNamesSidetable uintptr
+
+	// For each array type: the element type and the length.
+	arrayTypesSidetable byte
+
+	// For each struct type: the size, the number of fields and for each field
+	// a flags byte, the type, the offset, the name and optionally the tag.
+	structTypesSidetable byte
+
+	// For each func type: the number of parameters and results, followed by
+	// their types.
+	funcTypesSidetable byte
+
+	// For each map type: the key type and the element type.
+	mapTypesSidetable byte
+
+	// For each interface type: the number of methods and for each method the
+	// name and the type.
+	interfaceTypesSidetable byte
+
+	// For each type with exported methods: the type code, the number of
+	// methods and for each method the name, the type and the index to pass to
+	// callMethod. The list ends with a zero type code.
+	methodSetsSidetable byte
+
+	// Struct field names, tags, method names and type names. Each name is
+	// stored as a length followed by the string.
+	namesSidetable byte
+)
+
+// Struct field flags, as stored in structTypesSidetable.
+const (
+	structFieldFlagAnonymous = 1 << iota
+	structFieldFlagHasTag
+	structFieldFlagExported
+)
+
+// readVarint reads a varint from the given pointer and returns it, together
+// with a pointer to the byte after it. Every byte stores 7 bits, least
+// significant bits first, and has the high bit set if more bytes follow.
+func readVarint(buf unsafe.Pointer) (uintptr, unsafe.Pointer) {
+	var n uintptr
+	shift := uintptr(0)
+	for {
+		c := *(*byte)(buf)
+		buf = unsafe.Pointer(uintptr(buf) + 1)
+		n |= uintptr(c&0x7f) << shift
+		if c < 0x80 {
+			return n, buf
+		}
+		shift += 7
+	}
+}
+
+// sidetableEntry returns a pointer to the given offset in a sidetable.
+func sidetableEntry(sidetable *byte, offset uintptr) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(unsafe.Pointer(sidetable)) + offset)
+}
+
+// sidetableUintptr returns the value at the given index in a sidetable that is
+// an array of uintptr values.
+func sidetableUintptr(sidetable *uintptr, index uintptr) uintptr {
+	return *(*uintptr)(unsafe.Pointer(uintptr(unsafe.Pointer(sidetable)) + index*unsafe.Sizeof(uintptr(0))))
+}
+
+// readName returns the name at the given offset in namesSidetable. The string
+// refers to the sidetable directly, so it doesn't allocate.
+func readName(offset uintptr) string {
+	length, data := readVarint(sidetableEntry(&namesSidetable, offset))
+	s := StringHeader{
+		Data: uintptr(data),
+		Len:  length,
+	}
+	return *(*string)(unsafe.Pointer(&s))
+}
+
+// callMethod calls the method with the given index in methodSetsSidetable. It
+// is implemented by the compiler, which calls the $reflectcall thunk of the
+// method. The receiver is passed like the value of an interface, while args
+// and results point to an array of pointers to each argument and result.
+func callMethod(index uintptr, receiver, args, results unsafe.Pointer)
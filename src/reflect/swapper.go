@@ -1,5 +1,27 @@
 package reflect
 
+import (
+	"unsafe"
+)
+
+// Swapper returns a function that swaps the elements in the provided slice.
+// It panics if the provided interface is not a slice.
 func Swapper(slice interface{}) func(i, j int) {
-	panic("unimplemented: reflect.Swapper")
+	v := ValueOf(slice)
+	if v.Kind() != Slice {
+		panic(&ValueError{"Swapper"})
+	}
+	header := *(*SliceHeader)(v.value)
+	size := v.Type().Elem().Size()
+	tmp := alloc(size)
+	return func(i, j int) {
+		if uint(i) >= uint(header.Len) || uint(j) >= uint(header.Len) {
+			panic("reflect: slice index out of range")
+		}
+		a := unsafe.Pointer(header.Data + uintptr(i)*size)
+		b := unsafe.Pointer(header.Data + uintptr(j)*size)
+		memcpy(tmp, a, size)
+		memcpy(a, b, size)
+		memcpy(b, tmp, size)
+	}
 }
@@ -1,6 +1,7 @@
 package reflect
 
 import (
+	"strconv"
 	"unsafe"
 )
 
@@ -23,6 +24,11 @@ import (
 //         The higher bits are either the contents of the type depending on the
 //         type (if n is clear) or indicate the number of the named type (if n
 //         is set).
+//         For chan, pointer and slice types the contents are the type code of
+//         the element type. For array, struct, func, map and interface types
+//         the contents are an offset into their sidetable. The number of a named type is an
+//         index into namedNonBasicTypesSidetable, which contains the type code
+//         of the underlying type. See sidetables.go.
 
 type Kind uintptr
 
@@ -129,8 +135,148 @@ func TypeOf(i interface{}) Type {
 	return ValueOf(i).typecode
 }
 
+// String returns a string representation of the type, like "[]int" or
+// "json.Number". Named types are printed using the last element of their
+// package path, which is usually the package name.
+//go:recursive
 func (t Type) String() string {
-	return "T"
+	if name := t.name(); name != "" {
+		if slash := lastIndexByte(name, '/'); slash >= 0 {
+			return name[slash+1:]
+		}
+		return name
+	}
+	switch t.Kind() {
+	case Chan:
+		return "chan " + t.Elem().String()
+	case Ptr:
+		return "*" + t.Elem().String()
+	case Slice:
+		return "[]" + t.Elem().String()
+	case Array:
+		return "[" + strconv.Itoa(t.Len()) + "]" + t.Elem().String()
+	case Map:
+		return "map[" + t.Key().String() + "]" + t.Elem().String()
+	case Func:
+		return "func" + t.signatureString()
+	case Interface:
+		if t.NumMethod() == 0 {
+			return "interface {}"
+		}
+		s := "interface {"
+		for i := 0; i < t.NumMethod(); i++ {
+			if i != 0 {
+				s += ";"
+			}
+			m := t.Method(i)
+			s += " " + m.Name + m.Type.signatureString()
+		}
+		return s + " }"
+	case Struct:
+		if t.NumField() == 0 {
+			return "struct {}"
+		}
+		s := "struct {"
+		for i := 0; i < t.NumField(); i++ {
+			if i != 0 {
+				s += ";"
+			}
+			field := t.Field(i)
+			if !field.Anonymous {
+				s += " " + field.Name
+			}
+			s += " " + field.Type.String()
+			if field.Tag != "" {
+				s += " " + strconv.Quote(string(field.Tag))
+			}
+		}
+		return s + " }"
+	default:
+		return t.Kind().String()
+	}
+}
+
+// signatureString returns the parameters and results of a func type as a
+// string, for use in String.
+func (t Type) signatureString() string {
+	s := "("
+	for i := 0; i < t.NumIn(); i++ {
+		if i != 0 {
+			s += ", "
+		}
+		s += t.In(i).String()
+	}
+	s += ")"
+	switch t.NumOut() {
+	case 0:
+	case 1:
+		s += " " + t.Out(0).String()
+	default:
+		s += " ("
+		for i := 0; i < t.NumOut(); i++ {
+			if i != 0 {
+				s += ", "
+			}
+			s += t.Out(i).String()
+		}
+		s += ")"
+	}
+	return s
+}
+
+// name returns the qualified name of a named type, like
+// "encoding/json.Number", or the empty string for unnamed types.
+func (t Type) name() string {
+	if t.isNamed() {
+		return readName(sidetableUintptr(&namedNonBasicTypeNamesSidetable, uintptr(t>>5)))
+	}
+	if t%2 == 0 && t>>6 != 0 {
+		// Named basic type: the upper bits contain the number of the name,
+		// starting at 1.
+		return readName(sidetableUintptr(&namedBasicTypeNamesSidetable, uintptr(t>>6)-1))
+	}
+	return ""
+}
+
+// Name returns the name of the type within its package. It returns the empty
+// string for unnamed types other than basic types.
+func (t Type) Name() string {
+	if name := t.name(); name != "" {
+		return name[lastIndexByte(name, '.')+1:]
+	}
+	switch kind := t.Kind(); {
+	case kind == UnsafePointer:
+		return "Pointer"
+	case kind >= Bool && kind < UnsafePointer:
+		return kind.String()
+	default:
+		return ""
+	}
+}
+
+// PkgPath returns the package path of a named type, or the empty string for
+// unnamed types and predeclared types like error.
+func (t Type) PkgPath() string {
+	name := t.name()
+	if dot := lastIndexByte(name, '.'); dot >= 0 {
+		return name[:dot]
+	}
+	return ""
+}
+
+// lastIndexByte returns the index of the last occurrence of c in s, or -1.
+// A qualified type name may contain dots in the package path, but not after
+// the last slash.
+func lastIndexByte(s string, c byte) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == c {
+			return i
+		}
+		if s[i] == '/' {
+			break
+		}
+	}
+	return -1
 }
 
 func (t Type) Kind() Kind {
@@ -142,34 +288,325 @@ func (t Type) Kind() Kind {
 	}
 }
 
+// isNamed returns whether this is a named non-basic type.
+func (t Type) isNamed() bool {
+	return t%2 != 0 && (t>>4)%2 != 0
+}
+
+// underlying returns the underlying type of a named non-basic type, or the
+// type itself otherwise. Note that named basic types are not resolved, as the
+// basic type is already stored in the type code.
+func (t Type) underlying() Type {
+	if t.isNamed() {
+		return Type(sidetableUintptr(&namedNonBasicTypesSidetable, uintptr(t>>5)))
+	}
+	return t
+}
+
+// PtrTo returns the pointer type with element t.
+func PtrTo(t Type) Type {
+	// Pointer types are unnamed and contain the type code of the element type,
+	// so they can be constructed at runtime.
+	return t<<5 | Type(Ptr-19)<<1 | 1
+}
+
 func (t Type) Elem() Type {
 	switch t.Kind() {
 	case Chan, Ptr, Slice:
-		if (t>>4)%2 != 0 {
-			panic("unimplemented: (reflect.Type).Elem() for named types")
-		}
-		return t >> 5
-	default: // not implemented: Array, Map
-		panic("unimplemented: (reflect.Type).Elem()")
+		return t.underlying() >> 5
+	case Array:
+		elem, _ := readVarint(sidetableEntry(&arrayTypesSidetable, uintptr(t.underlying()>>5)))
+		return Type(elem)
+	case Map:
+		p := sidetableEntry(&mapTypesSidetable, uintptr(t.underlying()>>5))
+		_, p = readVarint(p) // key type
+		elem, _ := readVarint(p)
+		return Type(elem)
+	default:
+		panic(&TypeError{"Elem"})
+	}
+}
+
+// Key returns the key type of a map type.
+func (t Type) Key() Type {
+	if t.Kind() != Map {
+		panic(&TypeError{"Key"})
+	}
+	key, _ := readVarint(sidetableEntry(&mapTypesSidetable, uintptr(t.underlying()>>5)))
+	return Type(key)
+}
+
+// structType returns a pointer to the fields of this struct type in
+// structTypesSidetable, the number of fields and the struct size.
+func (t Type) structType() (unsafe.Pointer, int, uintptr) {
+	if t.Kind() != Struct {
+		panic(&TypeError{"NumField"})
 	}
+	p := sidetableEntry(&structTypesSidetable, uintptr(t.underlying()>>5))
+	size, p := readVarint(p)
+	numField, p := readVarint(p)
+	return p, int(numField), size
 }
 
 func (t Type) Field(i int) StructField {
-	panic("unimplemented: (reflect.Type).Field()")
+	p, numField, _ := t.structType()
+	if uint(i) >= uint(numField) {
+		panic("reflect: field index out of range")
+	}
+
+	// Skip over all fields until the requested field is reached. This is not
+	// very fast, but it keeps the sidetable small.
+	var field StructField
+	for fieldNum := 0; fieldNum <= i; fieldNum++ {
+		flags := *(*uint8)(p)
+		p = unsafe.Pointer(uintptr(p) + 1)
+		var fieldType, offset, name, tag uintptr
+		fieldType, p = readVarint(p)
+		offset, p = readVarint(p)
+		name, p = readVarint(p)
+		if flags&structFieldFlagHasTag != 0 {
+			tag, p = readVarint(p)
+		}
+		if fieldNum != i {
+			continue
+		}
+		field = StructField{
+			Name:      readName(name),
+			Type:      Type(fieldType),
+			Offset:    offset,
+			Index:     []int{i},
+			Anonymous: flags&structFieldFlagAnonymous != 0,
+		}
+		if flags&structFieldFlagHasTag != 0 {
+			field.Tag = StructTag(readName(tag))
+		}
+		if flags&structFieldFlagExported == 0 {
+			// TODO: store the real package path. This is enough to tell
+			// exported and unexported fields apart.
+			field.PkgPath = "<unimplemented>"
+		}
+	}
+	return field
+}
+
+// FieldByName returns the struct field with the given name. Fields of
+// embedded structs are not searched.
+func (t Type) FieldByName(name string) (StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.Name == name {
+			return field, true
+		}
+	}
+	return StructField{}, false
 }
 
 func (t Type) Bits() int {
-	panic("unimplemented: (reflect.Type).Bits()")
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, Float32, Float64, Complex64, Complex128:
+		return int(t.Size()) * 8
+	default:
+		panic(&TypeError{"Bits"})
+	}
 }
 
 func (t Type) Len() int {
-	panic("unimplemented: (reflect.Type).Len()")
+	if t.Kind() != Array {
+		panic(&TypeError{"Len"})
+	}
+	p := sidetableEntry(&arrayTypesSidetable, uintptr(t.underlying()>>5))
+	_, p = readVarint(p) // element type
+	length, _ := readVarint(p)
+	return int(length)
 }
 
 func (t Type) NumField() int {
-	panic("unimplemented: (reflect.Type).NumField()")
+	_, numField, _ := t.structType()
+	return numField
+}
+
+// funcType returns a pointer to the parameter and result types of this func
+// type in funcTypesSidetable, and the number of parameters and results.
+func (t Type) funcType() (unsafe.Pointer, int, int) {
+	if t.Kind() != Func {
+		panic(&TypeError{"NumIn"})
+	}
+	p := sidetableEntry(&funcTypesSidetable, uintptr(t.underlying()>>5))
+	numIn, p := readVarint(p)
+	numOut, p := readVarint(p)
+	return p, int(numIn), int(numOut)
 }
 
+// funcParam returns parameter i of this func type, where the results follow
+// the parameters.
+func (t Type) funcParam(i int) Type {
+	p, _, _ := t.funcType()
+	var param uintptr
+	for ; i >= 0; i-- {
+		param, p = readVarint(p)
+	}
+	return Type(param)
+}
+
+func (t Type) NumIn() int {
+	_, numIn, _ := t.funcType()
+	return numIn
+}
+
+func (t Type) NumOut() int {
+	_, _, numOut := t.funcType()
+	return numOut
+}
+
+func (t Type) In(i int) Type {
+	if uint(i) >= uint(t.NumIn()) {
+		panic("reflect: parameter index out of range")
+	}
+	return t.funcParam(i)
+}
+
+func (t Type) Out(i int) Type {
+	if uint(i) >= uint(t.NumOut()) {
+		panic("reflect: result index out of range")
+	}
+	return t.funcParam(t.NumIn() + i)
+}
+
+// methodSet returns a pointer to the exported methods of this type in
+// methodSetsSidetable and the number of methods. Interface types have no entry
+// in this sidetable, see interfaceType.
+func (t Type) methodSet() (unsafe.Pointer, int) {
+	p := unsafe.Pointer(&methodSetsSidetable)
+	for {
+		var typecode, numMethod uintptr
+		typecode, p = readVarint(p)
+		if typecode == 0 {
+			// End of the list: this type has no methods.
+			return nil, 0
+		}
+		numMethod, p = readVarint(p)
+		if Type(typecode) == t {
+			return p, int(numMethod)
+		}
+		for i := uintptr(0); i < numMethod*3; i++ {
+			_, p = readVarint(p)
+		}
+	}
+}
+
+// interfaceType returns a pointer to the methods of this interface type in
+// interfaceTypesSidetable and the number of methods.
+func (t Type) interfaceType() (unsafe.Pointer, int) {
+	p := sidetableEntry(&interfaceTypesSidetable, uintptr(t.underlying()>>5))
+	numMethod, p := readVarint(p)
+	return p, int(numMethod)
+}
+
+// method returns method i of this type and the index to pass to callMethod.
+func (t Type) method(i int) (Method, uintptr) {
+	if t.Kind() == Interface {
+		p, numMethod := t.interfaceType()
+		if uint(i) >= uint(numMethod) {
+			panic("reflect: method index out of range")
+		}
+		var name, methodType uintptr
+		for j := 0; j <= i; j++ {
+			name, p = readVarint(p)
+			methodType, p = readVarint(p)
+		}
+		return Method{
+			Name:  readName(name),
+			Type:  Type(methodType),
+			Index: i,
+		}, 0
+	}
+	p, numMethod := t.methodSet()
+	if uint(i) >= uint(numMethod) {
+		panic("reflect: method index out of range")
+	}
+	var name, methodType, index uintptr
+	for j := 0; j <= i; j++ {
+		name, p = readVarint(p)
+		methodType, p = readVarint(p)
+		index, p = readVarint(p)
+	}
+	return Method{
+		Name:  readName(name),
+		Type:  Type(methodType),
+		Index: i,
+	}, index
+}
+
+// NumMethod returns the number of exported methods of this type, or the number
+// of methods (including unexported methods) of an interface type.
+func (t Type) NumMethod() int {
+	if t.Kind() == Interface {
+		_, numMethod := t.interfaceType()
+		return numMethod
+	}
+	_, numMethod := t.methodSet()
+	return numMethod
+}
+
+// Method returns exported method i of this type, sorted by name. Unlike the
+// standard library, the method type doesn't include the receiver. The receiver
+// must still be passed as the first argument when calling Func. Func is the
+// zero Value for methods of interface types.
+func (t Type) Method(i int) Method {
+	m, index := t.method(i)
+	if t.Kind() == Interface {
+		return m
+	}
+	m.Func = Value{
+		typecode: m.Type,
+		value:    unsafe.Pointer(&methodValue{receiverType: t, index: index}),
+		flags:    valueFlagMethod,
+	}
+	return m
+}
+
+// MethodByName returns the exported method with the given name.
+func (t Type) MethodByName(name string) (Method, bool) {
+	for i := 0; i < t.NumMethod(); i++ {
+		if m, _ := t.method(i); m.Name == name {
+			return t.Method(i), true
+		}
+	}
+	return Method{}, false
+}
+
+// Implements reports whether the type implements the interface type u.
+//
+// The exported methods of a concrete type are only known when the type is
+// converted to an interface somewhere in the program, which is also what is
+// needed to type assert it to an interface. Implements returns false for
+// other types and for interfaces with unexported methods.
+func (t Type) Implements(u Type) bool {
+	if u.Kind() != Interface {
+		panic("reflect: non-interface type passed to Type.Implements")
+	}
+	for i := 0; i < u.NumMethod(); i++ {
+		m, _ := u.method(i)
+		if !t.hasMethod(m.Name, m.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasMethod returns whether this type has a method with the given name and
+// type.
+func (t Type) hasMethod(name string, typ Type) bool {
+	for i := 0; i < t.NumMethod(); i++ {
+		if m, _ := t.method(i); m.Name == name {
+			return m.Type == typ
+		}
+	}
+	return false
+}
+
+// Size returns the size in bytes of a value of this type. The recursion depth
+// is bounded by the nesting of the type.
+//go:recursive
 func (t Type) Size() uintptr {
 	switch t.Kind() {
 	case Bool, Int8, Uint8:
@@ -198,12 +635,110 @@ func (t Type) Size() uintptr {
 		return unsafe.Sizeof(uintptr(0))
 	case Slice:
 		return unsafe.Sizeof(SliceHeader{})
+	case Interface:
+		return unsafe.Sizeof(interfaceHeader{})
+	case Func:
+		return unsafe.Sizeof(funcHeader{})
+	case Array:
+		return t.Elem().Size() * uintptr(t.Len())
+	case Struct:
+		_, _, size := t.structType()
+		return size
 	default:
 		panic("unimplemented: size of type")
 	}
 }
 
+// A StructField describes a single field in a struct.
 type StructField struct {
+	// Name indicates the field name.
 	Name string
-	Type Type
+
+	// PkgPath is the package path where the struct containing this field is
+	// declared for unexported fields, or the empty string for exported fields.
+	PkgPath string
+
+	Type      Type
+	Tag       StructTag // field tag string
+	Offset    uintptr   // offset within struct, in bytes
+	Index     []int     // index sequence for Type.FieldByIndex
+	Anonymous bool      // is an embedded field
+}
+
+// A StructTag is the tag string in a struct field.
+type StructTag string
+
+// Get returns the value associated with key in the tag string.
+func (tag StructTag) Get(key string) string {
+	v, _ := tag.Lookup(key)
+	return v
+}
+
+// Lookup returns the value associated with key in the tag string. It follows
+// the conventional format of space-separated key:"value" pairs.
+func (tag StructTag) Lookup(key string) (value string, ok bool) {
+	// Copied from the standard library reflect package.
+	for tag != "" {
+		// Skip leading space.
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+
+		// Scan to colon. A space, a quote or a control character is a syntax
+		// error.
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		name := string(tag[:i])
+		tag = tag[i+1:]
+
+		// Scan quoted string to find value.
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		qvalue := string(tag[:i+1])
+		tag = tag[i+1:]
+
+		if key == name {
+			value, err := strconv.Unquote(qvalue)
+			if err != nil {
+				break
+			}
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// A Method describes a single exported method.
+type Method struct {
+	Name    string
+	PkgPath string
+	Type    Type  // method type, without the receiver
+	Func    Value // func with receiver as first argument
+	Index   int   // index for Type.Method
+}
+
+type TypeError struct {
+	Method string
+}
+
+func (e *TypeError) Error() string {
+	return "reflect: call of reflect.Type." + e.Method + " on invalid type"
 }
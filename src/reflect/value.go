@@ -1,13 +1,36 @@
 package reflect
 
 import (
+	"math"
 	"unsafe"
 )
 
+type valueFlags uint8
+
+const (
+	// The value was obtained through an unexported struct field, so it cannot
+	// be modified.
+	valueFlagRO valueFlags = 1 << iota
+
+	// The value points to a methodValue: it is a method bound to a receiver
+	// (from Value.Method) or a method with the receiver as first argument
+	// (from Type.Method).
+	valueFlagMethod
+)
+
 type Value struct {
 	typecode Type
 	value    unsafe.Pointer
 	indirect bool
+	flags    valueFlags
+}
+
+// methodValue is the func value of a method, as returned by Value.Method and
+// Type.Method. See Value.Call.
+type methodValue struct {
+	receiver     Value   // bound receiver, or invalid for Type.Method
+	receiverType Type    // receiver type for Type.Method
+	index        uintptr // index to pass to callMethod
 }
 
 func Indirect(v Value) Value {
@@ -26,6 +49,14 @@ func ValueOf(i interface{}) Value {
 }
 
 func (v Value) Interface() interface{} {
+	if v.flags&valueFlagMethod != 0 {
+		panic("unimplemented: (reflect.Value).Interface() on method values")
+	}
+	if v.Kind() == Interface {
+		// The value is an interface itself (for example a struct field), so
+		// return the interface it contains.
+		return *(*interface{})(v.value)
+	}
 	i := interfaceHeader{
 		typecode: v.typecode,
 		value:    v.value,
@@ -53,11 +84,14 @@ func (v Value) Kind() Kind {
 func (v Value) IsNil() bool {
 	switch v.Kind() {
 	case Chan, Map, Ptr:
-		return v.value == nil
+		return v.pointer() == nil
 	case Func:
 		if v.value == nil {
 			return true
 		}
+		if v.flags&valueFlagMethod != 0 {
+			return false
+		}
 		fn := (*funcHeader)(v.value)
 		return fn.Code == nil
 	case Slice:
@@ -70,8 +104,10 @@ func (v Value) IsNil() bool {
 		if v.value == nil {
 			return true
 		}
+		// Check the type code and not the value: small values like false or 0
+		// are stored as a nil value pointer.
 		itf := (*interfaceHeader)(v.value)
-		return itf.value == nil
+		return itf.typecode == 0
 	default:
 		panic(&ValueError{"IsNil"})
 	}
@@ -80,7 +116,7 @@ func (v Value) IsNil() bool {
 func (v Value) Pointer() uintptr {
 	switch v.Kind() {
 	case Chan, Map, Ptr, UnsafePointer:
-		return uintptr(v.value)
+		return uintptr(v.pointer())
 	case Slice:
 		slice := (*SliceHeader)(v.value)
 		return slice.Data
@@ -91,25 +127,51 @@ func (v Value) Pointer() uintptr {
 	}
 }
 
+// pointer returns the pointer stored in this value, which must be of a kind
+// that is represented as a single pointer (chan, map, pointer or
+// unsafe.Pointer).
+func (v Value) pointer() unsafe.Pointer {
+	if v.indirect {
+		return *(*unsafe.Pointer)(v.value)
+	}
+	return v.value
+}
+
 func (v Value) IsValid() bool {
 	return v.typecode != 0
 }
 
 func (v Value) CanInterface() bool {
-	// No Value types of private data can be constructed at the moment.
-	return true
+	return v.flags&valueFlagRO == 0
 }
 
 func (v Value) CanAddr() bool {
-	panic("unimplemented: (reflect.Value).CanAddr()")
+	return v.indirect
 }
 
 func (v Value) Addr() Value {
-	panic("unimplemented: (reflect.Value).Addr()")
+	if !v.indirect {
+		panic("reflect: value is not addressable")
+	}
+	return Value{
+		typecode: PtrTo(v.Type()),
+		value:    v.value,
+		flags:    v.flags & valueFlagRO,
+	}
 }
 
 func (v Value) CanSet() bool {
-	return v.indirect
+	return v.indirect && v.flags&valueFlagRO == 0
+}
+
+// checkAddressable panics if the value cannot be set.
+func (v Value) checkAddressable() {
+	if !v.indirect {
+		panic("reflect: value is not addressable")
+	}
+	if v.flags&valueFlagRO != 0 {
+		panic("reflect: value obtained using unexported field")
+	}
 }
 
 func (v Value) Bool() bool {
@@ -265,12 +327,45 @@ func (v Value) String() string {
 	}
 }
 
+// Bytes returns the contents of a byte slice.
 func (v Value) Bytes() []byte {
-	panic("unimplemented: (reflect.Value).Bytes()")
+	if v.Kind() != Slice || v.Type().Elem().Kind() != Uint8 {
+		panic(&ValueError{"Bytes"})
+	}
+	return *(*[]byte)(v.value)
 }
 
+// Slice returns v[i:j] for a slice or string. Slicing arrays is not yet
+// supported.
 func (v Value) Slice(i, j int) Value {
-	panic("unimplemented: (reflect.Value).Slice()")
+	switch v.Kind() {
+	case Slice:
+		slice := *(*SliceHeader)(v.value)
+		if i < 0 || j < i || uintptr(j) > slice.Cap {
+			panic("reflect.Value.Slice: slice index out of bounds")
+		}
+		slice.Data += uintptr(i) * v.Type().Elem().Size()
+		slice.Len = uintptr(j - i)
+		slice.Cap -= uintptr(i)
+		return Value{
+			typecode: v.typecode,
+			value:    unsafe.Pointer(&slice),
+			flags:    v.flags & valueFlagRO,
+		}
+	case String:
+		s := *(*string)(v.value)
+		if i < 0 || j < i || j > len(s) {
+			panic("reflect.Value.Slice: string slice index out of bounds")
+		}
+		s = s[i:j]
+		return Value{
+			typecode: v.typecode,
+			value:    unsafe.Pointer(&s),
+			flags:    v.flags & valueFlagRO,
+		}
+	default: // not implemented: Array
+		panic("unimplemented: (reflect.Value).Slice()")
+	}
 }
 
 func (v Value) Len() int {
@@ -280,7 +375,11 @@ func (v Value) Len() int {
 		return int((*SliceHeader)(v.value).Len)
 	case String:
 		return int((*StringHeader)(v.value).Len)
-	default: // Array, Chan, Map
+	case Array:
+		return t.Len()
+	case Map:
+		return maplen(v.pointer())
+	default: // Chan
 		panic("unimplemented: (reflect.Value).Len()")
 	}
 }
@@ -290,22 +389,21 @@ func (v Value) Cap() int {
 	switch t.Kind() {
 	case Slice:
 		return int((*SliceHeader)(v.value).Cap)
-	default: // Array, Chan
+	case Array:
+		return t.Len()
+	default: // Chan
 		panic("unimplemented: (reflect.Value).Cap()")
 	}
 }
 
 func (v Value) NumField() int {
-	panic("unimplemented: (reflect.Value).NumField()")
+	return v.Type().NumField()
 }
 
 func (v Value) Elem() Value {
 	switch v.Kind() {
 	case Ptr:
-		ptr := v.value
-		if v.indirect {
-			ptr = *(*unsafe.Pointer)(ptr)
-		}
+		ptr := v.pointer()
 		if ptr == nil {
 			return Value{}
 		}
@@ -313,14 +411,82 @@ func (v Value) Elem() Value {
 			typecode: v.Type().Elem(),
 			value:    ptr,
 			indirect: true,
+			flags:    v.flags & valueFlagRO,
 		}
-	default: // not implemented: Interface
+	case Interface:
+		return ValueOf(*(*interface{})(v.value))
+	default:
 		panic(&ValueError{"Elem"})
 	}
 }
 
 func (v Value) Field(i int) Value {
-	panic("unimplemented: (reflect.Value).Field()")
+	field := v.Type().Field(i)
+	elem := v.valueAt(field.Type, field.Offset)
+	if field.PkgPath != "" {
+		elem.flags |= valueFlagRO
+	}
+	return elem
+}
+
+// FieldByName returns the struct field with the given name, or the zero Value
+// if there is no such field.
+func (v Value) FieldByName(name string) Value {
+	if field, ok := v.Type().FieldByName(name); ok {
+		return v.Field(field.Index[0])
+	}
+	return Value{}
+}
+
+// valueAt returns the value of the given type at the given offset within this
+// value, which must be a struct or array. The returned value is addressable if
+// this value is addressable.
+func (v Value) valueAt(typ Type, offset uintptr) Value {
+	if v.indirect {
+		return Value{
+			typecode: typ,
+			value:    unsafe.Pointer(uintptr(v.value) + offset),
+			indirect: true,
+			flags:    v.flags & valueFlagRO,
+		}
+	}
+	if v.Type().Size() > unsafe.Sizeof(uintptr(0)) {
+		// The value is stored outside of the interface value.
+		elem := loadValue(typ, unsafe.Pointer(uintptr(v.value)+offset))
+		elem.flags = v.flags & valueFlagRO
+		return elem
+	}
+	// The value is stored directly in the interface value, so extract the
+	// relevant bits.
+	value := uintptr(v.value) >> (offset * 8)
+	if size := typ.Size(); size < unsafe.Sizeof(uintptr(0)) {
+		value &= 1<<(size*8) - 1
+	}
+	return Value{
+		typecode: typ,
+		value:    unsafe.Pointer(value),
+		flags:    v.flags & valueFlagRO,
+	}
+}
+
+// loadValue returns a non-addressable value with the contents of the given
+// memory. Small values are copied into the value, like they would be stored in
+// an interface.
+func loadValue(typ Type, ptr unsafe.Pointer) Value {
+	if typ.Size() > unsafe.Sizeof(uintptr(0)) {
+		return Value{
+			typecode: typ,
+			value:    ptr,
+		}
+	}
+	var value uintptr
+	for j := typ.Size(); j != 0; j-- {
+		value = (value << 8) | uintptr(*(*uint8)(unsafe.Pointer(uintptr(ptr) + j - 1)))
+	}
+	return Value{
+		typecode: typ,
+		value:    unsafe.Pointer(value),
+	}
 }
 
 func (v Value) Index(i int) Value {
@@ -334,6 +500,7 @@ func (v Value) Index(i int) Value {
 		elem := Value{
 			typecode: v.Type().Elem(),
 			indirect: true,
+			flags:    v.flags & valueFlagRO,
 		}
 		addr := uintptr(slice.Data) + elem.Type().Size()*uintptr(i) // pointer to new value
 		elem.value = unsafe.Pointer(addr)
@@ -351,46 +518,166 @@ func (v Value) Index(i int) Value {
 			value:    unsafe.Pointer(uintptr(*(*uint8)(unsafe.Pointer(s.Data + uintptr(i))))),
 		}
 	case Array:
-		panic("unimplemented: (reflect.Value).Index()")
+		if uint(i) >= uint(v.Type().Len()) {
+			panic("reflect: array index out of range")
+		}
+		elemType := v.Type().Elem()
+		return v.valueAt(elemType, elemType.Size()*uintptr(i))
 	default:
 		panic(&ValueError{"Index"})
 	}
 }
 
+// MapKeys returns the keys of a map, in unspecified order.
 func (v Value) MapKeys() []Value {
-	panic("unimplemented: (reflect.Value).MapKeys()")
+	if v.Kind() != Map {
+		panic(&ValueError{"MapKeys"})
+	}
+	var keys []Value
+	it := v.MapRange()
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys
 }
 
+// MapIndex returns the value associated with key in a map, or the zero Value
+// if the key is not present in the map.
 func (v Value) MapIndex(key Value) Value {
-	panic("unimplemented: (reflect.Value).MapIndex()")
+	if v.Kind() != Map {
+		panic(&ValueError{"MapIndex"})
+	}
+	keyPtr, stringKey := v.mapKey(key, "reflect.Value.MapIndex")
+	m := v.pointer()
+	if m == nil {
+		return Value{}
+	}
+	elemType := v.Type().Elem()
+	elem := alloc(elemType.Size())
+	if !mapaccess(m, keyPtr, elem, stringKey) {
+		return Value{}
+	}
+	return loadValue(elemType, elem)
+}
+
+// SetMapIndex sets the element associated with key in a map to elem. If elem
+// is the zero Value, the key is deleted from the map.
+func (v Value) SetMapIndex(key, elem Value) {
+	if v.Kind() != Map {
+		panic(&ValueError{"SetMapIndex"})
+	}
+	keyPtr, stringKey := v.mapKey(key, "reflect.Value.SetMapIndex")
+	m := v.pointer()
+	if !elem.IsValid() {
+		if m != nil {
+			mapdelete(m, keyPtr, stringKey)
+		}
+		return
+	}
+	if m == nil {
+		panic("assignment to entry in nil map")
+	}
+	mapassign(m, keyPtr, elem.assignTo(v.Type().Elem(), "reflect.Value.SetMapIndex"), stringKey)
 }
 
+// mapKey returns a pointer to the key for a lookup in this map, and whether the
+// map has string keys. Like the compiler, the runtime only supports string
+// keys and keys that can be compared as plain memory.
+func (v Value) mapKey(key Value, context string) (unsafe.Pointer, bool) {
+	keyType := v.Type().Key()
+	keyPtr := key.assignTo(keyType, context)
+	if keyType.Kind() == String {
+		return keyPtr, true
+	}
+	if !keyType.isBinary() {
+		panic("unimplemented: " + context + " with key type " + keyType.String())
+	}
+	return keyPtr, false
+}
+
+// isBinary returns whether values of this type can be compared as plain
+// memory, which is how the runtime compares map keys that are not strings.
+//go:recursive
+func (t Type) isBinary() bool {
+	switch t.Kind() {
+	case Bool, Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, Ptr:
+		return true
+	case Array:
+		return t.Elem().isBinary()
+	case Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).Type.isBinary() {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// MapRange returns an iterator over the entries of a map.
 func (v Value) MapRange() *MapIter {
-	panic("unimplemented: (reflect.Value).MapRange()")
+	if v.Kind() != Map {
+		panic(&ValueError{"MapRange"})
+	}
+	return &MapIter{
+		m:  v,
+		it: mapiterinit(),
+	}
 }
 
+// A MapIter is an iterator over the entries of a map, see Value.MapRange.
 type MapIter struct {
+	m     Value
+	it    unsafe.Pointer // *runtime.hashmapIterator
+	key   Value
+	value Value
 }
 
+// Key returns the key of the current map entry.
 func (it *MapIter) Key() Value {
-	panic("unimplemented: (*reflect.MapIter).Key()")
+	if !it.key.IsValid() {
+		panic("reflect: MapIter.Key called before Next")
+	}
+	return it.key
 }
 
+// Value returns the value of the current map entry.
 func (it *MapIter) Value() Value {
-	panic("unimplemented: (*reflect.MapIter).Value()")
+	if !it.value.IsValid() {
+		panic("reflect: MapIter.Value called before Next")
+	}
+	return it.value
 }
 
+// Next advances the iterator and reports whether there is another entry.
 func (it *MapIter) Next() bool {
-	panic("unimplemented: (*reflect.MapIter).Next()")
+	m := it.m.pointer()
+	if m == nil {
+		return false
+	}
+	keyType := it.m.Type().Key()
+	elemType := it.m.Type().Elem()
+	key := alloc(keyType.Size())
+	elem := alloc(elemType.Size())
+	if !mapiternext(m, it.it, key, elem) {
+		it.key = Value{}
+		it.value = Value{}
+		return false
+	}
+	it.key = loadValue(keyType, key)
+	it.value = loadValue(elemType, elem)
+	return true
 }
 
 func (v Value) Set(x Value) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkAddressable()
 	if v.Type() != x.Type() {
 		if v.Kind() == Interface {
-			panic("reflect: unimplemented: assigning to interface of different type")
+			// Whether the type implements this interface is not checked.
+			*(*interface{})(v.value) = x.Interface()
+			return
 		} else {
 			panic("reflect: cannot assign")
 		}
@@ -405,9 +692,7 @@ func (v Value) Set(x Value) {
 }
 
 func (v Value) SetBool(x bool) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkAddressable()
 	switch v.Kind() {
 	case Bool:
 		*(*bool)(v.value) = x
@@ -417,9 +702,7 @@ func (v Value) SetBool(x bool) {
 }
 
 func (v Value) SetInt(x int64) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkAddressable()
 	switch v.Kind() {
 	case Int:
 		*(*int)(v.value) = int(x)
@@ -437,9 +720,7 @@ func (v Value) SetInt(x int64) {
 }
 
 func (v Value) SetUint(x uint64) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkAddressable()
 	switch v.Kind() {
 	case Uint:
 		*(*uint)(v.value) = uint(x)
@@ -459,9 +740,7 @@ func (v Value) SetUint(x uint64) {
 }
 
 func (v Value) SetFloat(x float64) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkAddressable()
 	switch v.Kind() {
 	case Float32:
 		*(*float32)(v.value) = float32(x)
@@ -473,9 +752,7 @@ func (v Value) SetFloat(x float64) {
 }
 
 func (v Value) SetComplex(x complex128) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkAddressable()
 	switch v.Kind() {
 	case Complex64:
 		*(*complex64)(v.value) = complex64(x)
@@ -487,9 +764,7 @@ func (v Value) SetComplex(x complex128) {
 }
 
 func (v Value) SetString(x string) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkAddressable()
 	switch v.Kind() {
 	case String:
 		*(*string)(v.value) = x
@@ -498,16 +773,332 @@ func (v Value) SetString(x string) {
 	}
 }
 
+// SetLen sets the length of a slice.
+func (v Value) SetLen(n int) {
+	v.checkAddressable()
+	if v.Kind() != Slice {
+		panic(&ValueError{"SetLen"})
+	}
+	slice := (*SliceHeader)(v.value)
+	if uint(n) > uint(slice.Cap) {
+		panic("reflect: slice length out of range in SetLen")
+	}
+	slice.Len = uintptr(n)
+}
+
+// SetBytes sets the contents of a byte slice.
+func (v Value) SetBytes(x []byte) {
+	v.checkAddressable()
+	if v.Kind() != Slice || v.Type().Elem().Kind() != Uint8 {
+		panic(&ValueError{"SetBytes"})
+	}
+	*(*[]byte)(v.value) = x
+}
+
+// OverflowInt reports whether x cannot be represented by the type of v, which
+// must be a signed integer.
+func (v Value) OverflowInt(x int64) bool {
+	switch v.Kind() {
+	case Int, Int8, Int16, Int32, Int64:
+		shift := 64 - v.Type().Size()*8
+		return x != (x<<shift)>>shift
+	default:
+		panic(&ValueError{"OverflowInt"})
+	}
+}
+
+// OverflowUint reports whether x cannot be represented by the type of v, which
+// must be an unsigned integer.
+func (v Value) OverflowUint(x uint64) bool {
+	switch v.Kind() {
+	case Uint, Uint8, Uint16, Uint32, Uint64, Uintptr:
+		shift := 64 - v.Type().Size()*8
+		return x != (x<<shift)>>shift
+	default:
+		panic(&ValueError{"OverflowUint"})
+	}
+}
+
+// OverflowFloat reports whether x cannot be represented by the type of v, which
+// must be a float.
+func (v Value) OverflowFloat(x float64) bool {
+	switch v.Kind() {
+	case Float32:
+		if x < 0 {
+			x = -x
+		}
+		return math.MaxFloat32 < x && x <= math.MaxFloat64
+	case Float64:
+		return false
+	default:
+		panic(&ValueError{"OverflowFloat"})
+	}
+}
+
+// Convert returns the value v converted to type t. Supported are conversions
+// between numeric types, between strings and byte slices, and between types
+// with the same underlying type.
+func (v Value) Convert(t Type) Value {
+	vk, tk := v.Kind(), t.Kind()
+	switch {
+	case vk.isNumeric() && tk.isNumeric():
+		result := New(t).Elem()
+		switch {
+		case vk.isInt():
+			result.setNumber(v.Int(), uint64(v.Int()), float64(v.Int()))
+		case vk.isUint():
+			result.setNumber(int64(v.Uint()), v.Uint(), float64(v.Uint()))
+		default:
+			result.setNumber(int64(v.Float()), uint64(v.Float()), v.Float())
+		}
+		return loadValue(t, result.value)
+	case vk == Slice && v.Type().Elem().Kind() == Uint8 && tk == String:
+		result := New(t).Elem()
+		result.SetString(string(v.Bytes()))
+		return loadValue(t, result.value)
+	case vk == String && tk == Slice && t.Elem().Kind() == Uint8:
+		result := New(t).Elem()
+		result.SetBytes([]byte(v.String()))
+		return loadValue(t, result.value)
+	case vk == tk && (vk < Chan || v.Type().underlying() == t.underlying()):
+		if v.indirect {
+			return loadValue(t, v.value)
+		}
+		return Value{
+			typecode: t,
+			value:    v.value,
+		}
+	default:
+		panic("reflect.Value.Convert: value of type " + v.Type().String() + " cannot be converted to type " + t.String())
+	}
+}
+
+// setNumber sets this numeric value to one of the given numbers, depending on
+// its kind.
+func (v Value) setNumber(i int64, u uint64, f float64) {
+	switch k := v.Kind(); {
+	case k.isInt():
+		v.SetInt(i)
+	case k.isUint():
+		v.SetUint(u)
+	default:
+		v.SetFloat(f)
+	}
+}
+
+func (k Kind) isInt() bool {
+	return k >= Int && k <= Int64
+}
+
+func (k Kind) isUint() bool {
+	return k >= Uint && k <= Uintptr
+}
+
+func (k Kind) isNumeric() bool {
+	return k >= Int && k <= Float64
+}
+
+// MakeSlice returns a new zero-initialized slice of the given type, length and
+// capacity.
 func MakeSlice(typ Type, len, cap int) Value {
-	panic("unimplemented: reflect.MakeSlice()")
+	if typ.Kind() != Slice {
+		panic("reflect.MakeSlice of non-slice type")
+	}
+	if len < 0 || cap < len {
+		panic("reflect.MakeSlice: len out of range")
+	}
+	slice := &SliceHeader{
+		Data: uintptr(alloc(typ.Elem().Size() * uintptr(cap))),
+		Len:  uintptr(len),
+		Cap:  uintptr(cap),
+	}
+	return Value{
+		typecode: typ,
+		value:    unsafe.Pointer(slice),
+	}
+}
+
+// Copy copies the contents of src into dst until either dst has been filled or
+// src has been exhausted, and returns the number of elements copied. Both must
+// be slices or arrays with the same element type, and dst must be settable if
+// it is an array.
+func Copy(dst, src Value) int {
+	if dst.Kind() == Array {
+		dst.checkAddressable()
+	} else if dst.Kind() != Slice {
+		panic(&ValueError{"Copy"})
+	}
+	if src.Kind() != Array && src.Kind() != Slice {
+		panic(&ValueError{"Copy"})
+	}
+	elemType := dst.Type().Elem()
+	if src.Type().Elem() != elemType {
+		panic("reflect.Copy: " + dst.Type().String() + " != " + src.Type().String())
+	}
+	n := dst.Len()
+	if src.Len() < n {
+		n = src.Len()
+	}
+	memmove(dst.dataPointer(), src.dataPointer(), uintptr(n)*elemType.Size())
+	return n
+}
+
+// dataPointer returns a pointer to the first element of a slice or array.
+func (v Value) dataPointer() unsafe.Pointer {
+	if v.Kind() == Slice {
+		return unsafe.Pointer((*SliceHeader)(v.value).Data)
+	}
+	return v.valuePointer()
+}
+
+// MakeMap creates a new map with the specified type.
+func MakeMap(typ Type) Value {
+	return MakeMapWithSize(typ, 0)
+}
+
+// MakeMapWithSize creates a new map with the specified type and initial space
+// for approximately n elements.
+func MakeMapWithSize(typ Type, n int) Value {
+	if typ.Kind() != Map {
+		panic("reflect.MakeMapWithSize of non-map type")
+	}
+	keySize := typ.Key().Size()
+	elemSize := typ.Elem().Size()
+	if keySize > 255 || elemSize > 255 {
+		// The runtime stores these sizes in a byte.
+		panic("unimplemented: reflect.MakeMapWithSize with keys or elements over 255 bytes")
+	}
+	return Value{
+		typecode: typ,
+		value:    mapmake(keySize, elemSize, uintptr(n)),
+	}
 }
 
 func Zero(typ Type) Value {
-	panic("unimplemented: reflect.Zero()")
+	if typ.Size() <= unsafe.Sizeof(uintptr(0)) {
+		return Value{
+			typecode: typ,
+		}
+	}
+	return Value{
+		typecode: typ,
+		value:    alloc(typ.Size()),
+	}
 }
 
+// New returns a value that points to a new zero value of the given type.
 func New(typ Type) Value {
-	panic("unimplemented: reflect.New()")
+	return Value{
+		typecode: PtrTo(typ),
+		value:    alloc(typ.Size()),
+	}
+}
+
+// NumMethod returns the number of exported methods of this value.
+func (v Value) NumMethod() int {
+	return v.Type().NumMethod()
+}
+
+// Method returns exported method i of this value, bound to this value as
+// receiver. Only Call is supported on the returned value.
+func (v Value) Method(i int) Value {
+	m, index := v.Type().method(i)
+	return Value{
+		typecode: m.Type,
+		value:    unsafe.Pointer(&methodValue{receiver: v, index: index}),
+		flags:    valueFlagMethod,
+	}
+}
+
+// MethodByName returns the exported method with the given name bound to this
+// value, or the zero Value if there is no such method.
+func (v Value) MethodByName(name string) Value {
+	if m, ok := v.Type().MethodByName(name); ok {
+		return v.Method(m.Index)
+	}
+	return Value{}
+}
+
+// Call calls the method v with the given arguments and returns the results.
+// Only methods (from Value.Method or Type.Method) can be called at the moment,
+// and variadic arguments must be passed as a slice.
+func (v Value) Call(in []Value) []Value {
+	if v.Kind() != Func {
+		panic(&ValueError{"Call"})
+	}
+	if v.flags&valueFlagMethod == 0 {
+		panic("unimplemented: (reflect.Value).Call() on func values")
+	}
+	method := (*methodValue)(v.value)
+	receiver := method.receiver
+	if !receiver.IsValid() {
+		// This method was obtained with Type.Method, so the receiver is the
+		// first argument.
+		if len(in) == 0 {
+			panic("reflect: Call with too few input arguments")
+		}
+		receiver, in = in[0], in[1:]
+		if receiver.Type() != method.receiverType {
+			panic("reflect: Call using wrong receiver type")
+		}
+	}
+	t := v.Type()
+	if len(in) != t.NumIn() {
+		panic("reflect: Call with wrong number of input arguments")
+	}
+
+	// Pass every argument and result as a pointer to its value.
+	args := make([]unsafe.Pointer, len(in))
+	for i, arg := range in {
+		args[i] = arg.assignTo(t.In(i), "reflect.Value.Call")
+	}
+	results := make([]unsafe.Pointer, t.NumOut())
+	for i := range results {
+		results[i] = alloc(t.Out(i).Size())
+	}
+	itf := receiver.Interface()
+	callMethod(method.index, (*interfaceHeader)(unsafe.Pointer(&itf)).value, pointerArray(args), pointerArray(results))
+
+	out := make([]Value, len(results))
+	for i, result := range results {
+		out[i] = loadValue(t.Out(i), result)
+	}
+	return out
+}
+
+// assignTo returns a pointer to this value, to store it in a location (for
+// example a method argument or map element) of the given type. The context is
+// used in the panic message if the value cannot be stored there.
+func (v Value) assignTo(typ Type, context string) unsafe.Pointer {
+	if v.Type() != typ && v.Kind() != Interface {
+		if typ.Kind() != Interface {
+			panic(context + ": value of type " + v.Type().String() + " is not assignable to type " + typ.String())
+		}
+		// Pass this value as an interface. Whether the type implements the
+		// interface is not checked.
+		itf := v.Interface()
+		return unsafe.Pointer(&itf)
+	}
+	return v.valuePointer()
+}
+
+// valuePointer returns a pointer to the memory of this value. Values that are
+// stored directly in the Value are copied to memory first.
+func (v Value) valuePointer() unsafe.Pointer {
+	if v.indirect || v.Type().Size() > unsafe.Sizeof(uintptr(0)) {
+		return v.value
+	}
+	value := v.value
+	return unsafe.Pointer(&value)
+}
+
+// pointerArray returns a pointer to the first element of the slice, or nil if
+// it is empty.
+func pointerArray(s []unsafe.Pointer) unsafe.Pointer {
+	if len(s) == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&s[0])
 }
 
 type funcHeader struct {
@@ -542,3 +1133,28 @@ func (e *ValueError) Error() string {
 
 //go:linkname memcpy runtime.memcpy
 func memcpy(dst, src unsafe.Pointer, size uintptr)
+
+//go:linkname memmove runtime.memmove
+func memmove(dst, src unsafe.Pointer, size uintptr)
+
+//go:linkname alloc runtime.alloc
+func alloc(size uintptr) unsafe.Pointer
+
+// Map functions, implemented in the runtime (see runtime/hashmap.go). Maps and
+// map iterators are passed as unsafe.Pointer, keys and values as a pointer to
+// their memory. The stringKey parameter selects between string keys and keys
+// that are compared as plain memory.
+
+func mapmake(keySize, valueSize uintptr, sizeHint uintptr) unsafe.Pointer
+
+func maplen(m unsafe.Pointer) int
+
+func mapaccess(m, key, value unsafe.Pointer, stringKey bool) bool
+
+func mapassign(m, key, value unsafe.Pointer, stringKey bool)
+
+func mapdelete(m, key unsafe.Pointer, stringKey bool)
+
+func mapiterinit() unsafe.Pointer
+
+func mapiternext(m, it, key, value unsafe.Pointer) bool
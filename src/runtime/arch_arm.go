@@ -1,4 +1,4 @@
-// +build arm,!avr,!cortexm,!tinygo.riscv
+// +build arm,!avr,!cortexm,!tinygo.riscv,!wasm
 
 package runtime
 
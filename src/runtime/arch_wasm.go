@@ -28,3 +28,11 @@ const wasmPageSize = 64 * 1024
 func align(ptr uintptr) uintptr {
 	return (ptr + 3) &^ 3
 }
+
+//go:export memset
+func memset(ptr unsafe.Pointer, c byte, size uintptr) unsafe.Pointer {
+	for i := uintptr(0); i < size; i++ {
+		*(*byte)(unsafe.Pointer(uintptr(ptr) + i)) = c
+	}
+	return ptr
+}
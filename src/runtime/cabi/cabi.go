@@ -0,0 +1,149 @@
+// +build wasm
+
+// Package cabi implements the canonical ABI of the WebAssembly component model
+// for programs built with -wasm-abi=component. It is meant to be used by
+// bindings that are generated from a WIT world definition: they convert
+// strings and lists between the representation in linear memory used by the
+// canonical ABI and Go values.
+//
+// Memory for arguments of exported functions is allocated by the host with
+// cabi_realloc. It is kept alive until the argument is lifted, after which the
+// garbage collector keeps it alive for as long as the Go value is used. For
+// example, the bindings of an exported function with the WIT signature
+// greet: func(name: string) -> string look like this:
+//
+//     //go:export greet
+//     func greet(ptr unsafe.Pointer, length uintptr) unsafe.Pointer {
+//         return cabi.ReturnString(Greet(cabi.LiftString(ptr, length)))
+//     }
+//
+//     //go:export cabi_post_greet
+//     func greetPostReturn(unsafe.Pointer) {
+//         cabi.PostReturn()
+//     }
+//
+// The version of this ABI is stored in the tinygo:cabi custom section of the
+// module, so that components that were compiled separately can be checked for
+// compatibility before they are composed.
+package cabi
+
+import (
+	"unsafe"
+)
+
+// ABIVersion is the version of the conventions implemented in this package.
+// It is incremented on every incompatible change.
+const ABIVersion = 1
+
+// pinned is the memory that was allocated by the host and not yet lifted. The
+// garbage collector doesn't know about references from the host.
+var pinned = map[unsafe.Pointer]struct{}{}
+
+// returned is the memory of results of an exported function, which must stay
+// alive until the host has read them and called the post-return function.
+var returned []unsafe.Pointer
+
+// returnArea holds the pointer and length of a string or list result, as
+// results of these types are returned indirectly.
+var returnArea [2]uintptr
+
+type stringHeader struct {
+	ptr    unsafe.Pointer
+	length uintptr
+}
+
+type sliceHeader struct {
+	ptr    unsafe.Pointer
+	length uintptr
+	cap    uintptr
+}
+
+// realloc allocates (or grows) memory for the host, as required by the
+// canonical ABI. Heap allocations are aligned to at least 8 bytes, which is
+// the largest alignment of any type in the canonical ABI.
+//go:export cabi_realloc
+func realloc(ptr unsafe.Pointer, oldSize, align, newSize uintptr) unsafe.Pointer {
+	if align > 8 {
+		panic("cabi: unsupported alignment")
+	}
+	if newSize == 0 {
+		delete(pinned, ptr)
+		return nil
+	}
+	buf := make([]byte, newSize)
+	newPtr := unsafe.Pointer(&buf[0])
+	if ptr != nil {
+		if oldSize > newSize {
+			oldSize = newSize
+		}
+		copy(buf, *(*[]byte)(unsafe.Pointer(&sliceHeader{ptr, oldSize, oldSize})))
+		delete(pinned, ptr)
+	}
+	pinned[newPtr] = struct{}{}
+	return newPtr
+}
+
+// Lift takes ownership of memory that was allocated by the host with
+// cabi_realloc and returns the pointer. Bindings use it for lists of types
+// other than bytes.
+func Lift(ptr unsafe.Pointer) unsafe.Pointer {
+	delete(pinned, ptr)
+	return ptr
+}
+
+// LiftString returns a string argument that was passed by the host. The
+// string refers to the memory of the argument, it is not copied.
+func LiftString(ptr unsafe.Pointer, length uintptr) string {
+	return *(*string)(unsafe.Pointer(&stringHeader{Lift(ptr), length}))
+}
+
+// LiftBytes returns a list<u8> argument that was passed by the host. The slice
+// refers to the memory of the argument, it is not copied.
+func LiftBytes(ptr unsafe.Pointer, length uintptr) []byte {
+	return *(*[]byte)(unsafe.Pointer(&sliceHeader{Lift(ptr), length, length}))
+}
+
+// LowerString returns the pointer and length of a string, to pass it to an
+// imported function. The string must not be used by the host after the call
+// returns.
+func LowerString(s string) (unsafe.Pointer, uintptr) {
+	h := (*stringHeader)(unsafe.Pointer(&s))
+	return h.ptr, h.length
+}
+
+// LowerBytes returns the pointer and length of a byte slice, to pass it to an
+// imported function as a list<u8>.
+func LowerBytes(b []byte) (unsafe.Pointer, uintptr) {
+	h := (*sliceHeader)(unsafe.Pointer(&b))
+	return h.ptr, h.length
+}
+
+// ReturnString stores a string result of an exported function in the return
+// area and returns a pointer to it. The string stays alive until PostReturn is
+// called.
+func ReturnString(s string) unsafe.Pointer {
+	ptr, length := LowerString(s)
+	return returnList(ptr, length)
+}
+
+// ReturnBytes is like ReturnString for a list<u8> result.
+func ReturnBytes(b []byte) unsafe.Pointer {
+	ptr, length := LowerBytes(b)
+	return returnList(ptr, length)
+}
+
+func returnList(ptr unsafe.Pointer, length uintptr) unsafe.Pointer {
+	returned = append(returned, ptr)
+	returnArea[0] = uintptr(ptr)
+	returnArea[1] = length
+	return unsafe.Pointer(&returnArea)
+}
+
+// PostReturn releases the results of an exported function. It must be called
+// from the post-return function (cabi_post_<name>) of the export.
+func PostReturn() {
+	for i := range returned {
+		returned[i] = nil
+	}
+	returned = returned[:0]
+}
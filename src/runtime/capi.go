@@ -0,0 +1,42 @@
+package runtime
+
+// This file exports a small C API, for firmware that mixes C and Go code. For
+// example, a C interrupt handler can use it to pass events to a goroutine:
+//
+//     bool tinygo_chan_send_nonblocking(void *ch, void *value);
+//     void tinygo_task_wake(void);
+//
+// The channel is passed as the Go channel value itself, which is a pointer
+// internally. It can be converted with *(*unsafe.Pointer)(unsafe.Pointer(&ch))
+// before handing it to C. These functions must not be called while Go code operates on
+// the same channel: when they are called from an interrupt, the goroutines
+// using the channel should only do so with that interrupt disabled.
+
+import (
+	"unsafe"
+)
+
+// capiUsed is set to true by the compiler when a C file in the program refers
+// to this API. Only then can an interrupt handler make a goroutine runnable
+// while all goroutines are blocked. C code that is linked in some other way,
+// for example as a library with -ldflags, is not detected.
+var capiUsed bool
+
+// chanSendNonBlocking sends the value pointed to by value over the channel if
+// a goroutine is waiting to receive from it. It returns whether the value was
+// sent. It never blocks, which makes it usable from interrupts.
+//
+//go:export tinygo_chan_send_nonblocking
+func chanSendNonBlocking(ch *channel, value unsafe.Pointer) bool {
+	return ch.trySend(value)
+}
+
+// taskWake wakes up the scheduler if it is sleeping, so that goroutines that
+// have been made runnable from C (for example with
+// tinygo_chan_send_nonblocking) run immediately instead of after the current
+// sleep has finished.
+//
+//go:export tinygo_task_wake
+func taskWake() {
+	wakeScheduler()
+}
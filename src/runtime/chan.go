@@ -59,6 +59,7 @@ func deadlockStub()
 func chanSend(sender *coroutine, ch *channel, value unsafe.Pointer) {
 	if ch == nil {
 		// A nil channel blocks forever. Do not scheduler this goroutine again.
+		taskBlocked(sender, blockedChanSendNil)
 		return
 	}
 	switch ch.state {
@@ -66,6 +67,7 @@ func chanSend(sender *coroutine, ch *channel, value unsafe.Pointer) {
 		sender.promise().ptr = value
 		ch.state = chanStateSend
 		ch.blocked = sender
+		taskBlocked(sender, blockedChanSend)
 	case chanStateRecv:
 		receiver := ch.blocked
 		receiverPromise := receiver.promise()
@@ -73,6 +75,7 @@ func chanSend(sender *coroutine, ch *channel, value unsafe.Pointer) {
 		receiverPromise.data = 1 // commaOk = true
 		ch.blocked = receiverPromise.next
 		receiverPromise.next = nil
+		taskUnblocked(receiver, blockedChanRecv)
 		activateTask(receiver)
 		activateTask(sender)
 		if ch.blocked == nil {
@@ -84,6 +87,7 @@ func chanSend(sender *coroutine, ch *channel, value unsafe.Pointer) {
 		sender.promise().ptr = value
 		sender.promise().next = ch.blocked
 		ch.blocked = sender
+		taskBlocked(sender, blockedChanSend)
 	}
 }
 
@@ -94,6 +98,7 @@ func chanSend(sender *coroutine, ch *channel, value unsafe.Pointer) {
 func chanRecv(receiver *coroutine, ch *channel, value unsafe.Pointer) {
 	if ch == nil {
 		// A nil channel blocks forever. Do not scheduler this goroutine again.
+		taskBlocked(receiver, blockedChanRecvNil)
 		return
 	}
 	switch ch.state {
@@ -104,6 +109,7 @@ func chanRecv(receiver *coroutine, ch *channel, value unsafe.Pointer) {
 		receiver.promise().data = 1 // commaOk = true
 		ch.blocked = senderPromise.next
 		senderPromise.next = nil
+		taskUnblocked(sender, blockedChanSend)
 		activateTask(receiver)
 		activateTask(sender)
 		if ch.blocked == nil {
@@ -113,6 +119,7 @@ func chanRecv(receiver *coroutine, ch *channel, value unsafe.Pointer) {
 		receiver.promise().ptr = value
 		ch.state = chanStateRecv
 		ch.blocked = receiver
+		taskBlocked(receiver, blockedChanRecv)
 	case chanStateClosed:
 		memzero(value, uintptr(ch.elementSize))
 		receiver.promise().data = 0 // commaOk = false
@@ -121,6 +128,7 @@ func chanRecv(receiver *coroutine, ch *channel, value unsafe.Pointer) {
 		receiver.promise().ptr = value
 		receiver.promise().next = ch.blocked
 		ch.blocked = receiver
+		taskBlocked(receiver, blockedChanRecv)
 	}
 }
 
@@ -146,6 +154,7 @@ func chanClose(ch *channel) {
 		receiverPromise := ch.blocked.promise()
 		memzero(receiverPromise.ptr, uintptr(ch.elementSize))
 		receiverPromise.data = 0 // commaOk = false
+		taskUnblocked(ch.blocked, blockedChanRecv)
 		activateTask(ch.blocked)
 		ch.state = chanStateClosed
 		ch.blocked = nil
@@ -155,6 +164,26 @@ func chanClose(ch *channel) {
 	}
 }
 
+// trySend sends a value to a waiting receiver, if there is one, and
+// re-activates the receiver. It returns whether the value was sent.
+func (ch *channel) trySend(value unsafe.Pointer) bool {
+	if ch == nil || ch.state != chanStateRecv {
+		return false
+	}
+	receiver := ch.blocked
+	receiverPromise := receiver.promise()
+	memcpy(receiverPromise.ptr, value, uintptr(ch.elementSize))
+	receiverPromise.data = 1 // commaOk = true
+	ch.blocked = receiverPromise.next
+	receiverPromise.next = nil
+	taskUnblocked(receiver, blockedChanRecv)
+	activateTask(receiver)
+	if ch.blocked == nil {
+		ch.state = chanStateEmpty
+	}
+	return true
+}
+
 // chanSelect is the runtime implementation of the select statement. This is
 // perhaps the most complicated statement in the Go spec. It returns the
 // selected index and the 'comma-ok' value.
@@ -178,6 +207,7 @@ func chanSelect(recvbuf unsafe.Pointer, states []chanSelectState, blocking bool)
 				memcpy(recvbuf, senderPromise.ptr, uintptr(state.ch.elementSize))
 				state.ch.blocked = senderPromise.next
 				senderPromise.next = nil
+				taskUnblocked(sender, blockedChanSend)
 				activateTask(sender)
 				if state.ch.blocked == nil {
 					state.ch.state = chanStateEmpty
@@ -192,16 +222,7 @@ func chanSelect(recvbuf unsafe.Pointer, states []chanSelectState, blocking bool)
 			// A send operation: state.value is not nil.
 			switch state.ch.state {
 			case chanStateRecv:
-				receiver := state.ch.blocked
-				receiverPromise := receiver.promise()
-				memcpy(receiverPromise.ptr, state.value, uintptr(state.ch.elementSize))
-				receiverPromise.data = 1 // commaOk = true
-				state.ch.blocked = receiverPromise.next
-				receiverPromise.next = nil
-				activateTask(receiver)
-				if state.ch.blocked == nil {
-					state.ch.state = chanStateEmpty
-				}
+				state.ch.trySend(state.value)
 				return uintptr(i), false
 			case chanStateClosed:
 				runtimePanic("send on closed channel")
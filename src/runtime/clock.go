@@ -0,0 +1,43 @@
+package runtime
+
+// This file implements the virtual clock of the runtime/fakeclock package. The
+// scheduler and the time package read the time with clockTicks, which returns
+// either the time of the hardware clock or of the virtual clock.
+
+var (
+	virtualClock bool     // whether the virtual clock is used
+	virtualTicks timeUnit // time of the virtual clock
+	clockOffset  timeUnit // added to the hardware clock after the virtual clock was used
+)
+
+// clockTicks returns the current time in ticks.
+func clockTicks() timeUnit {
+	if virtualClock {
+		return virtualTicks
+	}
+	return ticks() + clockOffset
+}
+
+//go:linkname fakeclockEnable runtime/fakeclock.enable
+func fakeclockEnable() {
+	if !virtualClock {
+		virtualTicks = clockTicks()
+		virtualClock = true
+	}
+}
+
+//go:linkname fakeclockDisable runtime/fakeclock.disable
+func fakeclockDisable() {
+	if virtualClock {
+		// Continue from the virtual time, so that the time doesn't go back.
+		virtualClock = false
+		clockOffset = virtualTicks - ticks()
+	}
+}
+
+//go:linkname fakeclockAdvance runtime/fakeclock.advance
+func fakeclockAdvance(d int64) {
+	if virtualClock {
+		virtualTicks += timeUnit(d / tickMicros)
+	}
+}
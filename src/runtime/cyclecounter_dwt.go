@@ -0,0 +1,43 @@
+// +build nrf52 nrf52840 stm32
+
+package runtime
+
+// Cycle counter of the DWT (data watchpoint and trace) unit, which is
+// available on the Cortex-M3 and Cortex-M4.
+
+import (
+	"machine"
+	"runtime/volatile"
+	"unsafe"
+)
+
+var (
+	dwtControl      = (*volatile.Register32)(unsafe.Pointer(uintptr(0xe0001000)))
+	dwtCycleCounter = (*volatile.Register32)(unsafe.Pointer(uintptr(0xe0001004)))
+	debugDEMCR      = (*volatile.Register32)(unsafe.Pointer(uintptr(0xe000edfc)))
+)
+
+const (
+	dwtControlCYCCNTENA = 1 << 0
+	debugDEMCRTRCENA    = 1 << 24
+)
+
+// enableCycleCounter starts the cycle counter. It returns whether the cycle
+// counter is supported on this chip.
+func enableCycleCounter() bool {
+	debugDEMCR.SetBits(debugDEMCRTRCENA)
+	dwtControl.SetBits(dwtControlCYCCNTENA)
+	return true
+}
+
+// readCycleCounter returns the number of processor cycles since the cycle
+// counter was enabled, wrapping around at 2^32.
+func readCycleCounter() uint32 {
+	return dwtCycleCounter.Get()
+}
+
+// cycleCounterFrequency returns the number of cycle counter increments per
+// second.
+func cycleCounterFrequency() uint32 {
+	return machine.CPU_FREQUENCY
+}
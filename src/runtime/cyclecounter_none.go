@@ -0,0 +1,16 @@
+// +build !nrf52,!nrf52840,!stm32
+
+package runtime
+
+// enableCycleCounter returns false, as there is no cycle counter on this chip.
+func enableCycleCounter() bool {
+	return false
+}
+
+func readCycleCounter() uint32 {
+	return 0
+}
+
+func cycleCounterFrequency() uint32 {
+	return 1
+}
@@ -0,0 +1,58 @@
+package runtime
+
+// Checks of the execution time of functions with a //go:deadline pragma. The
+// compiler inserts a call to deadlineStart at the start of such a function and
+// a call to deadlineCheck (or deadlineCheckLog) before it returns.
+
+var deadlineCounterEnabled bool
+
+// deadlineStart returns the cycle counter at the start of a function with a
+// deadline.
+func deadlineStart() uint32 {
+	if !deadlineCounterEnabled {
+		deadlineCounterEnabled = true
+		enableCycleCounter()
+	}
+	return readCycleCounter()
+}
+
+// deadlineElapsed returns the time in nanoseconds since the given start
+// (returned by deadlineStart).
+func deadlineElapsed(start uint32) uint64 {
+	cycles := readCycleCounter() - start
+	return uint64(cycles) * 1000000000 / uint64(cycleCounterFrequency())
+}
+
+// deadlineCheck panics if the function at the given location took longer than
+// its deadline (in nanoseconds).
+func deadlineCheck(start, deadline uint32, location *panicLocation) {
+	elapsed := deadlineElapsed(start)
+	if elapsed <= uint64(deadline) {
+		return
+	}
+	checkPanicRecursion()
+	printstring("panic: ")
+	printDeadlineExceeded(elapsed, deadline, location)
+	printPanicTrace()
+	abort()
+}
+
+// deadlineCheckLog prints a message if the function at the given location took
+// longer than its deadline, and continues.
+func deadlineCheckLog(start, deadline uint32, location *panicLocation) {
+	elapsed := deadlineElapsed(start)
+	if elapsed <= uint64(deadline) {
+		return
+	}
+	printDeadlineExceeded(elapsed, deadline, location)
+}
+
+func printDeadlineExceeded(elapsed uint64, deadline uint32, location *panicLocation) {
+	printstring("deadline exceeded: ")
+	printuint64(elapsed)
+	printstring("ns instead of ")
+	printuint32(deadline)
+	printstring("ns in ")
+	printPanicLocation(location)
+	printnl()
+}
@@ -0,0 +1,135 @@
+// Package debug contains facilities for programs to debug themselves while
+// they are running.
+//
+// Most of these functions are no-ops in TinyGo. The exceptions are
+// SetGCPercent and SetMemoryLimit, which tune the conservative garbage
+// collector, FreeOSMemory, which runs a collection, and ReadTaskStats.
+package debug
+
+import (
+	"runtime"
+	"time"
+)
+
+// SetGCPercent sets the garbage collection target percentage: a collection is
+// triggered when the ratio of freshly allocated data to live data remaining
+// after the previous collection reaches this percentage. To not collect a
+// small heap all the time, the heap may always grow by a few kilobytes. A
+// negative percentage disables these early collections: garbage is then only
+// collected when the heap is full or the memory limit is reached. It returns
+// the previous setting. The initial setting is 100.
+func SetGCPercent(percent int) int {
+	return int(setGCPercent(int32(percent)))
+}
+
+// SetMemoryLimit sets a limit on the memory used by heap objects, in bytes.
+// An allocation that would exceed the limit runs a collection first, and
+// panics with an out of memory error if the limit is still exceeded after it.
+// Unlike in Go, the limit is hard. A negative limit doesn't change the
+// setting, so that the current limit can be read. It returns the previous
+// limit. The initial limit is math.MaxInt64, which means no limit.
+func SetMemoryLimit(limit int64) int64 {
+	return setMemoryLimit(limit)
+}
+
+// Implemented in the runtime.
+func setGCPercent(percent int32) int32
+func setMemoryLimit(limit int64) int64
+
+// FreeOSMemory forces a garbage collection. Memory is never returned to the
+// operating system.
+func FreeOSMemory() {
+	runtime.GC()
+}
+
+// GCStats collect information about recent garbage collections. They are not
+// tracked by TinyGo, so they are always zero.
+type GCStats struct {
+	LastGC         time.Time
+	NumGC          int64
+	PauseTotal     time.Duration
+	Pause          []time.Duration
+	PauseEnd       []time.Time
+	PauseQuantiles []time.Duration
+}
+
+// ReadGCStats reads statistics about garbage collection into stats. They are
+// not tracked, so stats is reset to the zero value.
+func ReadGCStats(stats *GCStats) {
+	*stats = GCStats{}
+}
+
+// TaskStats contains statistics about the memory used by the coroutine frames
+// of goroutines, which hold the local state of blocking functions. They are
+// only tracked with the WebAssembly scheduler, where frames of finished
+// goroutines are pooled for reuse. On other targets, they are always zero.
+type TaskStats struct {
+	InUse  uintptr // bytes in frames of running goroutines
+	Peak   uintptr // highest value of InUse since the program started
+	Pooled uintptr // bytes in frames that are kept for reuse
+}
+
+// ReadTaskStats reads statistics about goroutine frame memory into stats.
+func ReadTaskStats(stats *TaskStats) {
+	stats.InUse, stats.Peak, stats.Pooled = readTaskStats()
+}
+
+// Implemented in the runtime.
+func readTaskStats() (inUse, peak, pooled uintptr)
+
+// SetMaxStack does nothing: goroutine stacks have a fixed size. It returns
+// the previous setting, which is reported as 0.
+func SetMaxStack(bytes int) int {
+	return 0
+}
+
+// SetMaxThreads does nothing: there is only one thread. It returns the
+// previous setting, which is reported as 0.
+func SetMaxThreads(threads int) int {
+	return 0
+}
+
+// SetPanicOnFault does nothing: faults are not turned into panics. It returns
+// the previous setting.
+func SetPanicOnFault(enabled bool) bool {
+	return false
+}
+
+// SetTraceback does nothing.
+func SetTraceback(level string) {
+}
+
+// WriteHeapDump does nothing: heap dumps are not supported.
+func WriteHeapDump(fd uintptr) {
+}
+
+// Stack returns a formatted stack trace of the calling goroutine. Stack traces
+// are not supported, so it returns nil.
+func Stack() []byte {
+	return nil
+}
+
+// PrintStack prints the stack trace returned by Stack, which is empty.
+func PrintStack() {
+}
+
+// BuildInfo represents the build information read from the running binary.
+type BuildInfo struct {
+	Path string    // The main package path
+	Main Module    // The main module information
+	Deps []*Module // Module dependencies
+}
+
+// Module represents a module.
+type Module struct {
+	Path    string  // module path
+	Version string  // module version
+	Sum     string  // checksum
+	Replace *Module // replaced by this module
+}
+
+// ReadBuildInfo returns the build information embedded in the running binary.
+// It is not embedded by TinyGo, so ok is always false.
+func ReadBuildInfo() (info *BuildInfo, ok bool) {
+	return nil, false
+}
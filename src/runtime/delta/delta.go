@@ -0,0 +1,193 @@
+// Package delta applies firmware updates that were created as a patch against
+// the running firmware with tinygo build -delta-from=old.bin. A patch is
+// usually much smaller than the new image, which matters for devices that
+// receive updates over a slow or expensive link.
+//
+// The new image is written sequentially, so it can be streamed into a second
+// flash slot while the patch arrives. For example:
+//
+//     err := delta.Apply(currentFirmware, patchStream, nextSlotWriter)
+//     if err != nil {
+//         // don't boot the new slot
+//     }
+//
+// A patch consists of a header followed by instructions:
+//
+//     magic      "TGD1"
+//     oldSize    uint32, little endian
+//     oldCRC     uint32, CRC-32 (IEEE) of the old image
+//     newSize    uint32
+//     newCRC     uint32, CRC-32 of the new image
+//     instructions until newSize bytes have been written:
+//         0, n (uvarint), n bytes: insert the bytes
+//         1, d (zigzag varint), n (uvarint): copy n bytes from the old image,
+//            starting d bytes after the end of the previous copy
+package delta
+
+import (
+	"errors"
+	"io"
+)
+
+// Errors returned by Apply.
+var (
+	ErrInvalidPatch = errors.New("delta: invalid patch")
+	ErrWrongBase    = errors.New("delta: patch is for a different image")
+	ErrChecksum     = errors.New("delta: checksum mismatch in new image")
+)
+
+const (
+	opInsert = 0
+	opCopy   = 1
+)
+
+// bufferSize is the size of the buffer used for copying. It is small, so that
+// applying a patch needs little RAM.
+const bufferSize = 64
+
+// Apply reads a patch and writes the new image to w. The old image, usually
+// the running firmware in flash, is read from old. The checksums of the old
+// and the new image are verified: if Apply returns an error, the data written
+// to w must not be used.
+func Apply(old io.ReaderAt, patch io.Reader, w io.Writer) error {
+	r := &reader{r: patch}
+	var header [20]byte
+	if _, err := io.ReadFull(patch, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != "TGD1" {
+		return ErrInvalidPatch
+	}
+	oldSize := le32(header[4:])
+	oldCRC := le32(header[8:])
+	newSize := le32(header[12:])
+	newCRC := le32(header[16:])
+
+	// Check that the patch is applied to the image it was made for.
+	var buf [bufferSize]byte
+	crc := ^uint32(0)
+	for offset := uint32(0); offset < oldSize; {
+		n := oldSize - offset
+		if n > bufferSize {
+			n = bufferSize
+		}
+		if _, err := old.ReadAt(buf[:n], int64(offset)); err == io.EOF {
+			return ErrWrongBase // old image is too small
+		} else if err != nil {
+			return err
+		}
+		crc = updateCRC(crc, buf[:n])
+		offset += n
+	}
+	if ^crc != oldCRC {
+		return ErrWrongBase
+	}
+
+	crc = ^uint32(0)
+	written := uint32(0)
+	copyEnd := int64(0)
+	for written < newSize {
+		op, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		var src io.Reader
+		switch op {
+		case opInsert:
+			n, err := r.uvarint()
+			if err != nil {
+				return err
+			}
+			src = io.LimitReader(r, int64(n))
+		case opCopy:
+			d, err := r.uvarint()
+			if err != nil {
+				return err
+			}
+			n, err := r.uvarint()
+			if err != nil {
+				return err
+			}
+			start := copyEnd + (int64(d>>1) ^ -int64(d&1)) // zigzag decoding
+			if start < 0 || start+int64(n) > int64(oldSize) {
+				return ErrInvalidPatch
+			}
+			src = io.NewSectionReader(old, start, int64(n))
+			copyEnd = start + int64(n)
+		default:
+			return ErrInvalidPatch
+		}
+		for {
+			n, err := src.Read(buf[:])
+			if n > 0 {
+				if written+uint32(n) > newSize {
+					return ErrInvalidPatch
+				}
+				crc = updateCRC(crc, buf[:n])
+				written += uint32(n)
+				if _, err := w.Write(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if ^crc != newCRC {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// reader reads bytes and varints from the patch.
+type reader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(r.r, r.buf[:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return r.buf[0], err
+}
+
+func (r *reader) uvarint() (uint64, error) {
+	var x uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, nil
+		}
+	}
+	return 0, ErrInvalidPatch
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// updateCRC updates a CRC-32 (IEEE) bit by bit, which is slow but needs no
+// lookup table.
+func updateCRC(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc ^= uint32(b)
+		for i := 0; i < 8; i++ {
+			crc = crc>>1 ^ 0xedb88320&-(crc&1)
+		}
+	}
+	return crc
+}
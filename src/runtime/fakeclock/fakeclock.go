@@ -0,0 +1,49 @@
+// Package fakeclock replaces the clock of the runtime with a virtual clock,
+// to make code that depends on timing deterministic in tests. The virtual
+// clock affects time.Now, time.Since, time.Sleep and the wakeup of sleeping
+// goroutines, on the host as well as on emulated and real hardware.
+//
+// The virtual clock only advances when Advance is called, or when all
+// goroutines are sleeping or blocked: the clock then skips ahead to the time
+// at which the next goroutine wakes up. A time.Sleep of an hour therefore
+// returns immediately, while goroutines that sleep for less time still run
+// first:
+//
+//     fakeclock.Enable()
+//     defer fakeclock.Disable()
+//     start := time.Now()
+//     time.Sleep(time.Hour)      // returns immediately
+//     println(time.Since(start)) // prints one hour
+//
+// Peripherals keep running in real time: for example, a driver that waits for
+// a sensor with time.Sleep may read it before the measurement is done.
+package fakeclock
+
+import (
+	"time"
+)
+
+// Implemented in the runtime.
+func enable()
+func disable()
+func advance(d int64)
+
+// Enable switches to the virtual clock, starting at the current time. It does
+// nothing if the virtual clock is already used.
+func Enable() {
+	enable()
+}
+
+// Disable switches back to the hardware clock. The time continues from the
+// time of the virtual clock, so it doesn't go back.
+func Disable() {
+	disable()
+}
+
+// Advance moves the virtual clock forward by the given duration. Goroutines
+// whose sleep ends within this time are woken up the next time the calling
+// goroutine sleeps or blocks. It has no effect when the virtual clock is not
+// enabled.
+func Advance(d time.Duration) {
+	advance(int64(d))
+}
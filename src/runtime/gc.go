@@ -0,0 +1,58 @@
+package runtime
+
+// This file documents the interface between the runtime and the garbage
+// collector. A garbage collector is selected with the gc.* build tag, which is
+// set by the compiler (see also the runtime/internal/config package). Every
+// garbage collector must provide the following functions:
+//
+//   * alloc(size uintptr) unsafe.Pointer: allocate zeroed memory. Called by the
+//     compiler for heap allocations.
+//   * free(ptr unsafe.Pointer): free memory that is known to be unreferenced.
+//   * GC(): run a garbage collection cycle, if supported, and increment
+//     gcCycles.
+//   * KeepAlive(x interface{}) and SetFinalizer(obj, finalizer interface{}):
+//     the public API of the runtime package.
+//   * heapStats() (size, inUse uintptr): the size of the heap and the number
+//     of bytes allocated in it, or zero if unknown.
+//
+// Collectors that need to find roots can use markGlobals and markStack, which
+// are provided separately depending on whether the compiler tracks pointers
+// (the gc.stackobjects build tag) or whether the globals and stack can be
+// scanned directly.
+//
+// The gc.custom collector forwards these functions to a collector implemented
+// in a package outside of the runtime, see gc_custom.go.
+
+// Settings of the garbage collector, changed through the runtime/debug
+// package. Only the conservative collector uses them: it collects garbage
+// before the heap is full once it has grown by gcPercent since the last
+// collection, or when an allocation would exceed memoryLimit.
+var (
+	gcPercent   int32 = 100
+	memoryLimit int64 = 1<<63 - 1
+)
+
+// gcCycles is the number of completed garbage collection cycles, see
+// runtime/metrics.
+var gcCycles uint32
+
+//go:linkname setGCPercent runtime/debug.setGCPercent
+func setGCPercent(percent int32) int32 {
+	old := gcPercent
+	gcPercent = percent
+	return old
+}
+
+//go:linkname readHeapStats runtime/shell.readHeapStats
+func readHeapStats() (size, inUse uintptr) {
+	return heapStats()
+}
+
+//go:linkname setMemoryLimit runtime/debug.setMemoryLimit
+func setMemoryLimit(limit int64) int64 {
+	old := memoryLimit
+	if limit >= 0 {
+		memoryLimit = limit
+	}
+	return old
+}
@@ -23,12 +23,23 @@ package runtime
 // area heapStart..poolStart. The actual blocks are stored in
 // poolStart..heapEnd.
 //
+// Targets may declare more memory for the heap, for example external RAM (see
+// heap-regions in the target JSON). Every region is laid out like the main
+// heap, with its own metadata. Blocks are numbered across regions: the blocks
+// of the second region follow those of the first, and so on, but an object
+// never spans two regions. By default, small objects are allocated in the main
+// heap when possible and large objects in the other regions, as internal RAM
+// is faster and small objects tend to be accessed more often. The size from
+// which objects prefer a region is configured per region (min-object-size), so
+// that the whole heap can also be moved to external RAM.
+//
 // More information:
 // https://github.com/micropython/micropython/wiki/Memory-Manager
 // "The Garbage Collection Handbook" by Richard Jones, Antony Hosking, Eliot
 // Moss.
 
 import (
+	"runtime/internal/config"
 	"unsafe"
 )
 
@@ -47,10 +58,27 @@ const (
 	blocksPerStateByte = 8 / stateBits
 )
 
+// Minimum growth of the heap between collections that are triggered by
+// gcPercent, so that a small heap isn't collected all the time.
+const gcMinHeapGrowth = 4096
+
+// heapRegion is an area of memory in which objects are allocated.
+type heapRegion struct {
+	metadata   uintptr // start of the block states
+	poolStart  uintptr // address of the first block
+	poolEnd    uintptr // address just past the last block
+	firstBlock gcBlock // number of the first block
+	endBlock   gcBlock // number of the block just past the last block
+	nextAlloc  gcBlock // the next block that should be tried by the allocator
+	minObject  uintptr // objects of at least this size prefer this region
+}
+
 var (
-	poolStart uintptr // the first heap pointer
-	nextAlloc gcBlock // the next block that should be tried by the allocator
-	endBlock  gcBlock // the block just past the end of the available space
+	heapRegions    [1 + len(config.ExtraHeapRegions)]heapRegion
+	numHeapRegions uintptr
+	endBlock       gcBlock // the block just past the end of the available space
+	heapAllocated  uintptr // bytes in allocated blocks
+	heapLive       uintptr // bytes in allocated blocks after the last collection
 )
 
 // zeroSizedAlloc is just a sentinel that gets returned when allocating 0 bytes.
@@ -90,10 +118,32 @@ func (s blockState) String() string {
 // The block number in the pool.
 type gcBlock uintptr
 
+// regionFromAddr returns the heap region that contains the given address, or
+// nil if it is not a heap address.
+func regionFromAddr(addr uintptr) *heapRegion {
+	for i := uintptr(0); i < numHeapRegions; i++ {
+		r := &heapRegions[i]
+		if addr >= r.poolStart && addr < r.poolEnd {
+			return r
+		}
+	}
+	return nil
+}
+
 // blockFromAddr returns a block given an address somewhere in the heap (which
 // might not be heap-aligned).
 func blockFromAddr(addr uintptr) gcBlock {
-	return gcBlock((addr - poolStart) / bytesPerBlock)
+	r := regionFromAddr(addr)
+	return r.firstBlock + gcBlock((addr-r.poolStart)/bytesPerBlock)
+}
+
+// region returns the heap region this block is part of.
+func (b gcBlock) region() *heapRegion {
+	i := 0
+	for b >= heapRegions[i].endBlock {
+		i++
+	}
+	return &heapRegions[i]
 }
 
 // Return a pointer to the start of the allocated object.
@@ -103,7 +153,8 @@ func (b gcBlock) pointer() unsafe.Pointer {
 
 // Return the address of the start of the allocated object.
 func (b gcBlock) address() uintptr {
-	return poolStart + uintptr(b)*bytesPerBlock
+	r := b.region()
+	return r.poolStart + uintptr(b-r.firstBlock)*bytesPerBlock
 }
 
 // findHead returns the head (first block) of an object, assuming the block
@@ -117,29 +168,37 @@ func (b gcBlock) findHead() gcBlock {
 }
 
 // findNext returns the first block just past the end of the tail. This may or
-// may not be the head of an object.
+// may not be the head of an object, or it may be endBlock.
 func (b gcBlock) findNext() gcBlock {
 	if b.state() == blockStateHead || b.state() == blockStateMark {
 		b++
 	}
-	for b.state() == blockStateTail {
+	for b != endBlock && b.state() == blockStateTail {
 		b++
 	}
 	return b
 }
 
+// stateByte returns a pointer to the metadata byte with the state of this
+// block, and the position of the state within that byte.
+func (b gcBlock) stateByte() (*uint8, uintptr) {
+	r := b.region()
+	index := uintptr(b - r.firstBlock)
+	return (*uint8)(unsafe.Pointer(r.metadata + index/blocksPerStateByte)), (index % blocksPerStateByte) * 2
+}
+
 // State returns the current block state.
 func (b gcBlock) state() blockState {
-	stateBytePtr := (*uint8)(unsafe.Pointer(heapStart + uintptr(b/blocksPerStateByte)))
-	return blockState(*stateBytePtr>>((b%blocksPerStateByte)*2)) % 4
+	stateBytePtr, shift := b.stateByte()
+	return blockState(*stateBytePtr>>shift) % 4
 }
 
 // setState sets the current block to the given state, which must contain more
 // bits than the current state. Allowed transitions: from free to any state and
 // from head to mark.
 func (b gcBlock) setState(newState blockState) {
-	stateBytePtr := (*uint8)(unsafe.Pointer(heapStart + uintptr(b/blocksPerStateByte)))
-	*stateBytePtr |= uint8(newState << ((b % blocksPerStateByte) * 2))
+	stateBytePtr, shift := b.stateByte()
+	*stateBytePtr |= uint8(newState << shift)
 	if gcAsserts && b.state() != newState {
 		runtimePanic("gc: setState() was not successful")
 	}
@@ -147,8 +206,8 @@ func (b gcBlock) setState(newState blockState) {
 
 // markFree sets the block state to free, no matter what state it was in before.
 func (b gcBlock) markFree() {
-	stateBytePtr := (*uint8)(unsafe.Pointer(heapStart + uintptr(b/blocksPerStateByte)))
-	*stateBytePtr &^= uint8(blockStateMask << ((b % blocksPerStateByte) * 2))
+	stateBytePtr, shift := b.stateByte()
+	*stateBytePtr &^= uint8(blockStateMask << shift)
 	if gcAsserts && b.state() != blockStateFree {
 		runtimePanic("gc: markFree() was not successful")
 	}
@@ -161,8 +220,8 @@ func (b gcBlock) unmark() {
 		runtimePanic("gc: unmark() on a block that is not marked")
 	}
 	clearMask := blockStateMask ^ blockStateHead // the bits to clear from the state
-	stateBytePtr := (*uint8)(unsafe.Pointer(heapStart + uintptr(b/blocksPerStateByte)))
-	*stateBytePtr &^= uint8(clearMask << ((b % blocksPerStateByte) * 2))
+	stateBytePtr, shift := b.stateByte()
+	*stateBytePtr &^= uint8(clearMask << shift)
 	if gcAsserts && b.state() != blockStateHead {
 		runtimePanic("gc: unmark() was not successful")
 	}
@@ -173,19 +232,38 @@ func (b gcBlock) unmark() {
 // any packages the runtime depends upon may not allocate memory during package
 // initialization.
 func init() {
-	totalSize := heapEnd - heapStart
+	addHeapRegion(heapStart, heapEnd, 0)
+	for _, region := range config.ExtraHeapRegions {
+		addHeapRegion(region[0], region[1], region[2])
+	}
+}
+
+// addHeapRegion adds the memory from start to end to the heap. Objects of at
+// least minObject bytes are preferably allocated in this region.
+func addHeapRegion(start, end, minObject uintptr) {
+	totalSize := end - start
 
 	// Allocate some memory to keep 2 bits of information about every block.
 	metadataSize := totalSize / (blocksPerStateByte * bytesPerBlock)
 
 	// Align the pool.
-	poolStart = (heapStart + metadataSize + (bytesPerBlock - 1)) &^ (bytesPerBlock - 1)
-	poolEnd := heapEnd &^ (bytesPerBlock - 1)
+	poolStart := (start + metadataSize + (bytesPerBlock - 1)) &^ (bytesPerBlock - 1)
+	poolEnd := end &^ (bytesPerBlock - 1)
 	numBlocks := (poolEnd - poolStart) / bytesPerBlock
-	endBlock = gcBlock(numBlocks)
+
+	r := &heapRegions[numHeapRegions]
+	numHeapRegions++
+	r.metadata = start
+	r.poolStart = poolStart
+	r.poolEnd = poolEnd
+	r.firstBlock = endBlock
+	r.endBlock = endBlock + gcBlock(numBlocks)
+	r.nextAlloc = r.firstBlock
+	r.minObject = minObject
+	endBlock = r.endBlock
 	if gcDebug {
-		println("heapStart:        ", heapStart)
-		println("heapEnd:          ", heapEnd)
+		println("heapStart:        ", start)
+		println("heapEnd:          ", end)
 		println("total size:       ", totalSize)
 		println("metadata size:    ", metadataSize)
 		println("poolStart:        ", poolStart)
@@ -198,7 +276,7 @@ func init() {
 	}
 
 	// Set all block states to 'free'.
-	memzero(unsafe.Pointer(heapStart), metadataSize)
+	memzero(unsafe.Pointer(start), metadataSize)
 }
 
 // alloc tries to find some free space on the heap, possibly doing a garbage
@@ -208,33 +286,111 @@ func alloc(size uintptr) unsafe.Pointer {
 	if size == 0 {
 		return unsafe.Pointer(&zeroSizedAlloc)
 	}
+	profileAlloc(size)
 
 	neededBlocks := (size + (bytesPerBlock - 1)) / bytesPerBlock
+	allocSize := neededBlocks * bytesPerBlock
+
+	// Collect garbage early when the heap has grown too much.
+	collected := false
+	if needsGC(allocSize) {
+		GC()
+		collected = true
+		if uint64(heapAllocated)+uint64(allocSize) > uint64(memoryLimit) {
+			runtimePanic("out of memory: memory limit exceeded")
+		}
+	}
 
+	for {
+		for rank := 0; rank < 3; rank++ {
+			for n := uintptr(0); n < numHeapRegions; n++ {
+				r := &heapRegions[n]
+				if r.allocRank(n, size) != rank {
+					continue
+				}
+				thisAlloc, ok := r.alloc(neededBlocks)
+				if !ok {
+					continue
+				}
+				if gcDebug {
+					println("found memory:", thisAlloc.pointer(), int(size))
+				}
+
+				// Set the following blocks as being allocated.
+				thisAlloc.setState(blockStateHead)
+				for i := thisAlloc + 1; i != thisAlloc+gcBlock(neededBlocks); i++ {
+					i.setState(blockStateTail)
+				}
+
+				// Return a pointer to this allocation.
+				heapAllocated += allocSize
+				pointer := thisAlloc.pointer()
+				memzero(pointer, size)
+				return pointer
+			}
+		}
+		if collected {
+			// Even after garbage collection, no free memory could be found.
+			runtimePanic("out of memory")
+		}
+		// The entire heap has been searched for free memory, but none could
+		// be found. Run a garbage collection cycle to reclaim free memory and
+		// try again.
+		GC()
+		collected = true
+	}
+}
+
+// needsGC returns whether a collection should be done before allocating the
+// given number of bytes, because the heap has grown by gcPercent since the
+// last collection or would exceed the memory limit.
+func needsGC(allocSize uintptr) bool {
+	allocated := uint64(heapAllocated) + uint64(allocSize)
+	if allocated > uint64(memoryLimit) {
+		return true
+	}
+	if gcPercent < 0 {
+		return false
+	}
+	growth := uint64(heapLive)
+	if growth < gcMinHeapGrowth {
+		growth = gcMinHeapGrowth
+	}
+	return allocated > uint64(heapLive)+growth*uint64(gcPercent)/100
+}
+
+// allocRank returns when this region (with index n) is tried for an object of
+// the given size: first the other regions that prefer objects of this size
+// (rank 0), then the main heap (rank 1), then the remaining regions (rank 2).
+func (r *heapRegion) allocRank(n, size uintptr) int {
+	if n == 0 {
+		return 1
+	}
+	if size >= r.minObject {
+		return 0
+	}
+	return 2
+}
+
+// alloc looks for a range of free blocks in this region that is big enough
+// for the given number of blocks, and returns the first block.
+func (r *heapRegion) alloc(neededBlocks uintptr) (gcBlock, bool) {
 	// Continue looping until a run of free blocks has been found that fits the
-	// requested size.
-	index := nextAlloc
+	// requested size, or the whole region has been searched.
+	index := r.nextAlloc
 	numFreeBlocks := uintptr(0)
-	heapScanCount := uint8(0)
+	searched := false
 	for {
-		if index == nextAlloc {
-			if heapScanCount == 0 {
-				heapScanCount = 1
-			} else if heapScanCount == 1 {
-				// The entire heap has been searched for free memory, but none
-				// could be found. Run a garbage collection cycle to reclaim
-				// free memory and try again.
-				heapScanCount = 2
-				GC()
-			} else {
-				// Even after garbage collection, no free memory could be found.
-				runtimePanic("out of memory")
+		if index == r.nextAlloc {
+			if searched {
+				return 0, false
 			}
+			searched = true
 		}
 
-		// Wrap around the end of the heap.
-		if index == endBlock {
-			index = 0
+		// Wrap around the end of the region.
+		if index == r.endBlock {
+			index = r.firstBlock
 			// Reset numFreeBlocks as allocations cannot wrap.
 			numFreeBlocks = 0
 		}
@@ -252,28 +408,47 @@ func alloc(size uintptr) unsafe.Pointer {
 		// Are we finished?
 		if numFreeBlocks == neededBlocks {
 			// Found a big enough range of free blocks!
-			nextAlloc = index
-			thisAlloc := index - gcBlock(neededBlocks)
-			if gcDebug {
-				println("found memory:", thisAlloc.pointer(), int(size))
-			}
-
-			// Set the following blocks as being allocated.
-			thisAlloc.setState(blockStateHead)
-			for i := thisAlloc + 1; i != nextAlloc; i++ {
-				i.setState(blockStateTail)
-			}
-
-			// Return a pointer to this allocation.
-			pointer := thisAlloc.pointer()
-			memzero(pointer, size)
-			return pointer
+			r.nextAlloc = index
+			return index - gcBlock(neededBlocks), true
 		}
 	}
 }
 
+// free releases an object that is known to be unused, so that its memory can
+// be reused right away instead of after the next collection. It is used for
+// the frames of finished coroutines (see freeTask). Pointers outside the heap,
+// like nil and zero-sized allocations, are ignored.
 func free(ptr unsafe.Pointer) {
-	// TODO: free blocks on request, when the compiler knows they're unused.
+	r := regionFromAddr(uintptr(ptr))
+	if r == nil {
+		return
+	}
+	block := blockFromAddr(uintptr(ptr))
+	if block.state() != blockStateHead || block.pointer() != ptr {
+		if gcAsserts {
+			runtimePanic("gc: free() of a pointer that is not an object")
+		}
+		return
+	}
+	if gcDebug {
+		println("free:", ptr)
+	}
+	block.markFree()
+	freed := uintptr(bytesPerBlock)
+	for b := block + 1; b != r.endBlock && b.state() == blockStateTail; b++ {
+		b.markFree()
+		freed += bytesPerBlock
+	}
+	heapAllocated -= freed
+}
+
+// heapStats returns the size of all heap regions and the number of bytes in
+// allocated blocks.
+func heapStats() (size, inUse uintptr) {
+	for i := uintptr(0); i < numHeapRegions; i++ {
+		size += heapRegions[i].poolEnd - heapRegions[i].poolStart
+	}
+	return size, heapAllocated
 }
 
 // GC performs a garbage collection cycle.
@@ -294,12 +469,18 @@ func GC() {
 	if gcDebug {
 		dumpHeap()
 	}
+
+	gcCycles++
 }
 
 // markRoots reads all pointers from start to end (exclusive) and if they look
 // like a heap pointer and are unmarked, marks them and scans that object as
 // well (recursively). The start and end parameters must be valid pointers and
 // must be aligned.
+//
+// Marking recurses through markRoot for every newly found object, so the
+// recursion depth depends on the shape of the heap.
+//go:recursive
 func markRoots(start, end uintptr) {
 	if gcDebug {
 		println("mark from", start, "to", end, int(end-start))
@@ -316,6 +497,7 @@ func markRoots(start, end uintptr) {
 	}
 }
 
+//go:recursive
 func markRoot(addr, root uintptr) {
 	if looksLikePointer(root) {
 		block := blockFromAddr(root)
@@ -327,14 +509,19 @@ func markRoot(addr, root uintptr) {
 			head.setState(blockStateMark)
 			next := block.findNext()
 			// TODO: avoid recursion as much as possible
-			markRoots(head.address(), next.address())
+			// The next block may be in another region, so don't use its
+			// address.
+			start := head.address()
+			markRoots(start, start+uintptr(next-head)*bytesPerBlock)
 		}
 	}
 }
 
-// Sweep goes through all memory and frees unmarked memory.
+// Sweep goes through all memory and frees unmarked memory. It also counts the
+// memory that is still in use.
 func sweep() {
 	freeCurrentObject := false
+	liveBlocks := uintptr(0)
 	for block := gcBlock(0); block < endBlock; block++ {
 		switch block.state() {
 		case blockStateHead:
@@ -346,6 +533,8 @@ func sweep() {
 				// This is a tail object following an unmarked head.
 				// Free it now.
 				block.markFree()
+			} else {
+				liveBlocks++
 			}
 		case blockStateMark:
 			// This is a marked object. The next tail blocks must not be freed,
@@ -353,15 +542,18 @@ func sweep() {
 			// collect this object if it is unreferenced then.
 			block.unmark()
 			freeCurrentObject = false
+			liveBlocks++
 		}
 	}
+	heapLive = liveBlocks * bytesPerBlock
+	heapAllocated = heapLive
 }
 
 // looksLikePointer returns whether this could be a pointer. Currently, it
 // simply returns whether it lies anywhere in the heap. Go allows interior
 // pointers so we can't check alignment or anything like that.
 func looksLikePointer(ptr uintptr) bool {
-	return ptr >= poolStart && ptr < heapEnd
+	return regionFromAddr(ptr) != nil
 }
 
 // dumpHeap can be used for debugging purposes. It dumps the state of each heap
@@ -0,0 +1,77 @@
+// +build gc.custom
+
+package runtime
+
+// This GC strategy lets a package outside of the runtime provide the garbage
+// collector, which is useful for experimenting with different kinds of
+// collectors. The program must import this package (usually with a blank
+// import in the main package) and must be compiled with -gc=custom.
+//
+// The collector implements the following functions using //go:linkname (which
+// requires the package to import "unsafe"):
+//
+//   * runtime.alloc(size uintptr) unsafe.Pointer: allocate zeroed memory.
+//   * runtime.free(ptr unsafe.Pointer): free memory that is known to be
+//     unreferenced. It may do nothing.
+//   * runtime.gcCollect(): run a collection cycle.
+//   * runtime.markRoot(addr, root uintptr): called by runtime.gcScanRoots for
+//     every possible pointer (root) found at the given address.
+//
+// Optionally, it can implement a write barrier:
+//
+//   * runtime.gcWriteBarrier(slot, value unsafe.Pointer): called just before a
+//     pointer value is stored in memory. The compiler only inserts calls to
+//     it when the collector provides this function. Only stores of single
+//     pointers (not strings, slices, or interfaces) are reported, and no
+//     barriers are inserted in the runtime and the collector package itself.
+//
+// The collector can call runtime.gcScanRoots (again using //go:linkname) to
+// enumerate all roots in globals and on the stack.
+
+import (
+	"unsafe"
+)
+
+func alloc(size uintptr) unsafe.Pointer
+
+func free(ptr unsafe.Pointer)
+
+func gcCollect()
+
+func markRoot(addr, root uintptr)
+
+func gcWriteBarrier(slot, value unsafe.Pointer)
+
+// GC runs a garbage collection cycle of the custom collector.
+func GC() {
+	gcCollect()
+	gcCycles++
+}
+
+// gcScanRoots calls markRoot for every root in globals and on the stack.
+func gcScanRoots() {
+	markGlobals()
+	markStack()
+}
+
+// markRoots calls markRoot for all pointer-sized words from start to end
+// (exclusive), which must be aligned.
+func markRoots(start, end uintptr) {
+	for addr := start; addr != end; addr += unsafe.Sizeof(addr) {
+		root := *(*uintptr)(unsafe.Pointer(addr))
+		markRoot(addr, root)
+	}
+}
+
+// heapStats returns zero: the heap is managed by the custom collector.
+func heapStats() (size, inUse uintptr) {
+	return 0, 0
+}
+
+func KeepAlive(x interface{}) {
+	// Unimplemented. Only required with SetFinalizer().
+}
+
+func SetFinalizer(obj interface{}, finalizer interface{}) {
+	// Unimplemented.
+}
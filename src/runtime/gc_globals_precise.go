@@ -1,5 +1,5 @@
-// +build gc.conservative
-// +build !cortexm,!tinygo.riscv
+// +build gc.conservative gc.custom
+// +build gc.stackobjects
 
 package runtime
 
@@ -17,6 +17,7 @@ func alloc(size uintptr) unsafe.Pointer {
 	// TODO: this can be optimized by not casting between pointers and ints so
 	// much. And by using platform-native data types (e.g. *uint8 for 8-bit
 	// systems).
+	profileAlloc(size)
 	size = align(size)
 	addr := heapptr
 	heapptr += size
@@ -38,6 +39,10 @@ func GC() {
 	// No-op.
 }
 
+func heapStats() (size, inUse uintptr) {
+	return heapEnd - heapStart, heapptr - heapStart
+}
+
 func KeepAlive(x interface{}) {
 	// Unimplemented. Only required with SetFinalizer().
 }
@@ -20,6 +20,10 @@ func GC() {
 	// Unimplemented.
 }
 
+func heapStats() (size, inUse uintptr) {
+	return 0, 0
+}
+
 func KeepAlive(x interface{}) {
 	// Unimplemented. Only required with SetFinalizer().
 }
@@ -4,6 +4,11 @@ package runtime
 // It is very rougly based on the implementation of the Go hashmap:
 //
 //     https://golang.org/src/runtime/map.go
+//
+// Like in Go, the iteration order is randomized: an iterator starts at a
+// random bucket and a random slot within each bucket. Keys may be deleted
+// during iteration, in which case they won't be returned if they haven't been
+// reached yet.
 
 import (
 	"unsafe"
@@ -31,9 +36,31 @@ type hashmapBucket struct {
 }
 
 type hashmapIterator struct {
-	bucketNumber uintptr
-	bucket       *hashmapBucket
-	bucketIndex  uint8
+	bucketNumber uintptr        // number of buckets visited
+	bucket       *hashmapBucket // current bucket in the chain
+	bucketIndex  uint8          // number of slots visited in the current bucket
+	startIndex   uint8          // first slot visited in every bucket
+	startBucket  uintptr        // first bucket visited
+	initialized  bool           // whether startIndex and startBucket are set
+}
+
+// hashmapRandState is the state of the pseudo-random number generator used by
+// maps.
+var hashmapRandState uint32
+
+// hashmapRand returns a pseudo-random number (xorshift32). It is seeded from
+// the clock on first use, so that the iteration order of maps usually differs
+// between runs as well.
+func hashmapRand() uint32 {
+	x := hashmapRandState
+	if x == 0 {
+		x = uint32(ticks()) | 1
+	}
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	hashmapRandState = x
+	return x
 }
 
 // Get FNV-1a hash of this key.
@@ -203,7 +230,10 @@ func hashmapGet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint3
 }
 
 // Delete a given key from the map. No-op when the key does not exist in the
-// map.
+// map. A bucket in a chain that becomes empty is removed from the chain, and
+// the bucket array is dropped when the last key is deleted, so that the memory
+// can be reused after a map has shrunk. The bucket array isn't resized in other
+// cases, as an iterator may still be walking through it.
 //go:nobounds
 func hashmapDelete(m *hashmap, key unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
 	numBuckets := uintptr(1) << m.bucketBits
@@ -219,6 +249,7 @@ func hashmapDelete(m *hashmap, key unsafe.Pointer, hash uint32, keyEqual func(x,
 	}
 
 	// Try to find the key.
+	var prevBucket *hashmapBucket
 	for bucket != nil {
 		for i := uintptr(0); i < 8; i++ {
 			slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(i)
@@ -229,10 +260,22 @@ func hashmapDelete(m *hashmap, key unsafe.Pointer, hash uint32, keyEqual func(x,
 					// Found the key, delete it.
 					bucket.tophash[i] = 0
 					m.count--
+					if m.count == 0 {
+						// The map is empty. A new bucket is allocated by
+						// hashmapSet when a key is added again.
+						m.buckets = nil
+						m.bucketBits = 0
+					} else if prevBucket != nil && bucket.tophash == [8]uint8{} {
+						// Unlink this empty bucket, which is not part of the
+						// bucket array. The next pointer of the bucket is left
+						// as-is for iterators that are still in this bucket.
+						prevBucket.next = bucket.next
+					}
 					return
 				}
 			}
 		}
+		prevBucket = bucket
 		bucket = bucket.next
 	}
 }
@@ -241,6 +284,12 @@ func hashmapDelete(m *hashmap, key unsafe.Pointer, hash uint32, keyEqual func(x,
 //go:nobounds
 func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) bool {
 	numBuckets := uintptr(1) << m.bucketBits
+	if !it.initialized {
+		it.initialized = true
+		r := hashmapRand()
+		it.startBucket = uintptr(r>>8) & (numBuckets - 1)
+		it.startIndex = uint8(r) & 7
+	}
 	for {
 		if it.bucketIndex >= 8 {
 			// end of bucket, move to the next in the chain
@@ -248,25 +297,27 @@ func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) boo
 			it.bucket = it.bucket.next
 		}
 		if it.bucket == nil {
-			if it.bucketNumber >= numBuckets {
-				// went through all buckets
+			if it.bucketNumber >= numBuckets || m.buckets == nil {
+				// went through all buckets, or the map is empty
 				return false
 			}
 			bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
-			bucketAddr := uintptr(m.buckets) + bucketSize*it.bucketNumber
+			bucketNumber := (it.startBucket + it.bucketNumber) & (numBuckets - 1)
+			bucketAddr := uintptr(m.buckets) + bucketSize*bucketNumber
 			it.bucket = (*hashmapBucket)(unsafe.Pointer(bucketAddr))
 			it.bucketNumber++ // next bucket
 		}
-		if it.bucket.tophash[it.bucketIndex] == 0 {
+		slot := (it.startIndex + it.bucketIndex) & 7
+		if it.bucket.tophash[slot] == 0 {
 			// slot is empty - move on
 			it.bucketIndex++
 			continue
 		}
 
 		bucketAddr := uintptr(unsafe.Pointer(it.bucket))
-		slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(it.bucketIndex)
+		slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(slot)
 		slotKey := unsafe.Pointer(bucketAddr + slotKeyOffset)
-		slotValueOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*uintptr(it.bucketIndex)
+		slotValueOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*uintptr(slot)
 		slotValue := unsafe.Pointer(bucketAddr + slotValueOffset)
 		memcpy(key, slotKey, uintptr(m.keySize))
 		memcpy(value, slotValue, uintptr(m.valueSize))
@@ -278,21 +329,119 @@ func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) boo
 
 // Hashmap with plain binary data keys (not containing strings etc.).
 
+// hashmapBinaryHash returns the hash of a binary key. Keys of 1, 2 or 4 bytes
+// are hashed like integer keys, so that they can use the hashmapInt*
+// functions.
+func hashmapBinaryHash(key unsafe.Pointer, n uintptr) uint32 {
+	switch n {
+	case 1:
+		return hashmapIntHash(uint32(*(*uint8)(key)))
+	case 2:
+		return hashmapIntHash(uint32(*(*uint16)(key)))
+	case 4:
+		return hashmapIntHash(*(*uint32)(key))
+	}
+	return hashmapHash(key, n)
+}
+
 func hashmapBinarySet(m *hashmap, key, value unsafe.Pointer) {
-	hash := hashmapHash(key, uintptr(m.keySize))
+	hash := hashmapBinaryHash(key, uintptr(m.keySize))
 	hashmapSet(m, key, value, hash, memequal)
 }
 
 func hashmapBinaryGet(m *hashmap, key, value unsafe.Pointer) bool {
-	hash := hashmapHash(key, uintptr(m.keySize))
+	hash := hashmapBinaryHash(key, uintptr(m.keySize))
 	return hashmapGet(m, key, value, hash, memequal)
 }
 
 func hashmapBinaryDelete(m *hashmap, key unsafe.Pointer) {
-	hash := hashmapHash(key, uintptr(m.keySize))
+	hash := hashmapBinaryHash(key, uintptr(m.keySize))
 	hashmapDelete(m, key, hash, memequal)
 }
 
+// Hashmap with bool or integer keys of at most 32 bits, which are common on
+// microcontrollers. The compiler passes the key by value, zero-extended to 32
+// bits, instead of storing it in memory first. Only the lowest keySize bytes
+// are stored in the map, which works because all supported targets are little
+// endian.
+
+// hashmapIntHash mixes the bits of an integer key (the lowbias32 function by
+// Chris Wellons), which is a lot cheaper than hashing it byte by byte.
+func hashmapIntHash(x uint32) uint32 {
+	x ^= x >> 16
+	x *= 0x7feb352d
+	x ^= x >> 15
+	x *= 0x846ca68b
+	x ^= x >> 16
+	return x
+}
+
+func hashmapIntEqual(x, y unsafe.Pointer, n uintptr) bool {
+	switch n {
+	case 1:
+		return *(*uint8)(x) == *(*uint8)(y)
+	case 2:
+		return *(*uint16)(x) == *(*uint16)(y)
+	}
+	return *(*uint32)(x) == *(*uint32)(y)
+}
+
+func hashmapIntSet(m *hashmap, key uint32, value unsafe.Pointer) {
+	hashmapSet(m, unsafe.Pointer(&key), value, hashmapIntHash(key), hashmapIntEqual)
+}
+
+func hashmapIntGet(m *hashmap, key uint32, value unsafe.Pointer) bool {
+	return hashmapGet(m, unsafe.Pointer(&key), value, hashmapIntHash(key), hashmapIntEqual)
+}
+
+func hashmapIntDelete(m *hashmap, key uint32) {
+	hashmapDelete(m, unsafe.Pointer(&key), hashmapIntHash(key), hashmapIntEqual)
+}
+
+// Hashmap with floating point keys. They can't be compared with memequal:
+// positive and negative zero are equal, and NaN is not equal to anything (not
+// even itself), so that every NaN key is a separate entry that can never be
+// found again.
+
+func hashmapFloatEqual(x, y unsafe.Pointer, n uintptr) bool {
+	if n == 4 {
+		return *(*float32)(x) == *(*float32)(y)
+	}
+	return *(*float64)(x) == *(*float64)(y)
+}
+
+func hashmapFloatHash(key unsafe.Pointer, n uintptr) uint32 {
+	var f float64
+	if n == 4 {
+		f = float64(*(*float32)(key)) // exact, so equal keys have equal hashes
+	} else {
+		f = *(*float64)(key)
+	}
+	if f != f {
+		// NaN can't be found anyway, so spread these keys over the buckets.
+		return hashmapRand()
+	}
+	if f == 0 {
+		f = 0 // hash -0 like +0
+	}
+	return hashmapHash(unsafe.Pointer(&f), 8)
+}
+
+func hashmapFloatSet(m *hashmap, key, value unsafe.Pointer) {
+	hash := hashmapFloatHash(key, uintptr(m.keySize))
+	hashmapSet(m, key, value, hash, hashmapFloatEqual)
+}
+
+func hashmapFloatGet(m *hashmap, key, value unsafe.Pointer) bool {
+	hash := hashmapFloatHash(key, uintptr(m.keySize))
+	return hashmapGet(m, key, value, hash, hashmapFloatEqual)
+}
+
+func hashmapFloatDelete(m *hashmap, key unsafe.Pointer) {
+	hash := hashmapFloatHash(key, uintptr(m.keySize))
+	hashmapDelete(m, key, hash, hashmapFloatEqual)
+}
+
 // Hashmap with string keys (a common case).
 
 func hashmapStringEqual(x, y unsafe.Pointer, n uintptr) bool {
@@ -318,3 +467,54 @@ func hashmapStringDelete(m *hashmap, key string) {
 	hash := hashmapStringHash(key)
 	hashmapDelete(m, unsafe.Pointer(&key), hash, hashmapStringEqual)
 }
+
+// Hashmap functions for the reflect package. Maps are passed as unsafe.Pointer
+// and keys as a pointer to the key value, as the reflect package doesn't know
+// the key type at compile time. Only string keys and keys that can be compared
+// with memequal are supported, like in the compiler.
+
+//go:linkname reflect_mapmake reflect.mapmake
+func reflect_mapmake(keySize, valueSize uintptr, sizeHint uintptr) unsafe.Pointer {
+	return unsafe.Pointer(hashmapMake(uint8(keySize), uint8(valueSize), sizeHint))
+}
+
+//go:linkname reflect_maplen reflect.maplen
+func reflect_maplen(m unsafe.Pointer) int {
+	return hashmapLen((*hashmap)(m))
+}
+
+//go:linkname reflect_mapaccess reflect.mapaccess
+func reflect_mapaccess(m, key, value unsafe.Pointer, stringKey bool) bool {
+	if stringKey {
+		return hashmapStringGet((*hashmap)(m), *(*string)(key), value)
+	}
+	return hashmapBinaryGet((*hashmap)(m), key, value)
+}
+
+//go:linkname reflect_mapassign reflect.mapassign
+func reflect_mapassign(m, key, value unsafe.Pointer, stringKey bool) {
+	if stringKey {
+		hashmapStringSet((*hashmap)(m), *(*string)(key), value)
+		return
+	}
+	hashmapBinarySet((*hashmap)(m), key, value)
+}
+
+//go:linkname reflect_mapdelete reflect.mapdelete
+func reflect_mapdelete(m, key unsafe.Pointer, stringKey bool) {
+	if stringKey {
+		hashmapStringDelete((*hashmap)(m), *(*string)(key))
+		return
+	}
+	hashmapBinaryDelete((*hashmap)(m), key)
+}
+
+//go:linkname reflect_mapiterinit reflect.mapiterinit
+func reflect_mapiterinit() unsafe.Pointer {
+	return unsafe.Pointer(&hashmapIterator{})
+}
+
+//go:linkname reflect_mapiternext reflect.mapiternext
+func reflect_mapiternext(m, it, key, value unsafe.Pointer) bool {
+	return hashmapNext((*hashmap)(m), (*hashmapIterator)(it), key, value)
+}
@@ -0,0 +1,27 @@
+// +build cortexm,!qemu
+
+package runtime
+
+import (
+	"device/arm"
+)
+
+// Interrupt handlers can make goroutines runnable, for example with
+// tinygo_chan_send_nonblocking (see capi.go). So when all goroutines are
+// blocked and the program uses this C API, the scheduler waits for an
+// interrupt instead of reporting a deadlock.
+const interruptWakeup = true
+
+// waitForInterrupt waits until an interrupt handler has made a goroutine
+// runnable. It returns right away if one already is.
+func waitForInterrupt() {
+	// Interrupts are disabled around the check, so that an interrupt that
+	// fires just after it is not missed: wfi also wakes up for an interrupt
+	// that is pending but masked, which is then handled once they are
+	// enabled again.
+	mask := arm.DisableInterrupts()
+	if runqueueFront == nil {
+		arm.Asm("wfi")
+	}
+	arm.EnableInterrupts(mask)
+}
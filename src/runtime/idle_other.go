@@ -0,0 +1,10 @@
+// +build !cortexm qemu
+
+package runtime
+
+// Goroutines can't be woken up by an interrupt handler on this target, so all
+// goroutines being blocked means a deadlock.
+const interruptWakeup = false
+
+func waitForInterrupt() {
+}
@@ -43,15 +43,56 @@ type interfaceMethodInfo struct {
 	funcptr   uintptr // bitcast from the actual function pointer
 }
 
-type typecodeID struct{}
+// typecodeID is a placeholder for a type code. Some types store information
+// about their contents in it, which is used to build the reflect sidetables.
+type typecodeID struct {
+	// Depending on the type kind of this typecodeID, this pointer is something
+	// different:
+	// * basic types: null
+	// * named type: pointer to the underlying type
+	// * chan/pointer/slice/array: pointer to the element type
+	// * struct: pointer to a structField array (bitcast)
+	// * func: pointer to a typecodeID pointer array with the parameter types
+	//   followed by the result types (bitcast)
+	// * map: pointer to a typecodeID pointer array with the key and element
+	//   type (bitcast)
+	// * interface: pointer to a reflectMethod array with the methods of the
+	//   interface (bitcast), or null for interfaces without methods
+	references *typecodeID
+
+	// The array length for array types, the size in bytes for struct types,
+	// the number of parameters for func types and the number of methods for
+	// interface types.
+	length uintptr
+}
+
+// structField describes a single struct field for the reflect sidetables. It
+// is not used in the final binary.
+type structField struct {
+	typecode *typecodeID // type of this struct field
+	name     *uint8      // external *i8 with the field name in its name
+	tag      *uint8      // external *i8 with the tag in its name, or nil
+	offset   uintptr     // offset from the start of the struct
+	embedded bool
+}
+
+// reflectMethod describes an exported method of a concrete type or a method of
+// an interface type for the reflect package. It is not used in the final
+// binary.
+type reflectMethod struct {
+	name     *uint8      // external *i8 with the method name in its name
+	typecode *typecodeID // method type, without the receiver
+	call     *uint8      // bitcast from the $reflectcall thunk, nil for interfaces
+}
 
 // Pseudo type used before interface lowering. By using a struct instead of a
 // function call, this is simpler to reason about during init interpretation
 // than a function call. Also, by keeping the method set around it is easier to
 // implement interfaceImplements in the interp package.
 type typeInInterface struct {
-	typecode  *typecodeID
-	methodSet *interfaceMethodInfo // nil or a GEP of an array
+	typecode       *typecodeID
+	methodSet      *interfaceMethodInfo // nil or a GEP of an array
+	reflectMethods *reflectMethod       // nil or a GEP of an array
 }
 
 // Pseudo function call used during a type assert. It is used during interface
@@ -0,0 +1,122 @@
+// Package interrupt provides timestamps that are taken at the start of every
+// interrupt handler. They are meant for measurements where the latency between
+// an interrupt and the handler code would ruin the accuracy, such as
+// integrating IMU samples or measuring a time of flight.
+//
+// Timestamps are taken from the processor cycle counter, which is currently
+// only available on the Cortex-M3 and Cortex-M4 based chips (the nRF52 and
+// STM32 series). A handler can store the timestamp together with the data it
+// queues for a goroutine, for example:
+//
+//     //go:export EXTI0_IRQHandler
+//     func handleEXTI0() {
+//         t, _ := interrupt.Timestamp()
+//         events.Put(event{time: t, value: readSensor()})
+//     }
+//
+// The package also controls individual interrupts in the interrupt controller
+// (the NVIC on Cortex-M, the PLIC on RISC-V) with Enable, Disable and
+// SetPriority. Trigger makes an interrupt pending from software, so that a
+// high priority handler can defer slow work to a lower priority one:
+//
+//     //go:export TIMER0_IRQHandler
+//     func handleTimer0() {
+//         samples.Put(readADC())
+//         interrupt.Trigger(nrf.IRQ_SWI0_EGU0) // process in SWI0_EGU0_IRQHandler
+//     }
+package interrupt
+
+import (
+	"time"
+	_ "unsafe"
+)
+
+// Time is a timestamp in processor cycles. It wraps around at 2^32 cycles
+// (about a minute at 72MHz), so it can only be used to measure short
+// durations.
+type Time uint32
+
+// EnableTimestamps starts taking timestamps at the start of every interrupt
+// handler. It returns false if timestamps are not supported on this chip.
+func EnableTimestamps() bool {
+	return enableInterruptTimestamps()
+}
+
+// Timestamp returns the time at which the currently running interrupt handler
+// was entered. The second return value is false when not called from an
+// interrupt handler or when timestamps are not enabled.
+func Timestamp() (Time, bool) {
+	t, ok := interruptTimestamp()
+	return Time(t), ok
+}
+
+// Now returns the current time, in the same unit as Timestamp.
+func Now() Time {
+	return Time(readCycleCounter())
+}
+
+// Sub returns the duration t-u. The result is only correct when the actual
+// duration is less than the wraparound time of the counter.
+func (t Time) Sub(u Time) time.Duration {
+	cycles := uint64(uint32(t - u))
+	return time.Duration(cycles * uint64(time.Second) / uint64(cycleCounterFrequency()))
+}
+
+// Priorities of interrupts. They follow the Cortex-M convention where a lower
+// number is more urgent. Only the upper bits of a priority are used on most
+// chips: the Cortex-M0 for example only has four levels (0x00, 0x40, 0x80 and
+// 0xc0).
+const (
+	PriorityHighest = 0x00
+	PriorityDefault = 0x80
+	PriorityLowest  = 0xff
+)
+
+// Enable enables the given interrupt number in the interrupt controller.
+func Enable(irq uint32) {
+	interruptEnable(irq)
+}
+
+// Disable disables the given interrupt number in the interrupt controller. It
+// can still become pending, but the handler won't run until it is enabled.
+func Disable(irq uint32) {
+	interruptDisable(irq)
+}
+
+// SetPriority sets the priority of the given interrupt number. A handler can
+// only be interrupted by interrupts with a more urgent (lower) priority.
+func SetPriority(irq uint32, priority uint8) {
+	interruptSetPriority(irq, priority)
+}
+
+// Trigger makes the given interrupt pending, so that its handler runs as soon
+// as the priority allows it (immediately if it is more urgent than the current
+// code). It returns false if the interrupt controller doesn't support
+// software-triggered interrupts, like the PLIC on RISC-V.
+func Trigger(irq uint32) bool {
+	return interruptTrigger(irq)
+}
+
+//go:linkname interruptEnable runtime.interruptEnable
+func interruptEnable(irq uint32)
+
+//go:linkname interruptDisable runtime.interruptDisable
+func interruptDisable(irq uint32)
+
+//go:linkname interruptSetPriority runtime.interruptSetPriority
+func interruptSetPriority(irq uint32, priority uint8)
+
+//go:linkname interruptTrigger runtime.interruptTrigger
+func interruptTrigger(irq uint32) bool
+
+//go:linkname enableInterruptTimestamps runtime.enableInterruptTimestamps
+func enableInterruptTimestamps() bool
+
+//go:linkname interruptTimestamp runtime.interruptTimestamp
+func interruptTimestamp() (uint32, bool)
+
+//go:linkname readCycleCounter runtime.readCycleCounter
+func readCycleCounter() uint32
+
+//go:linkname cycleCounterFrequency runtime.cycleCounterFrequency
+func cycleCounterFrequency() uint32
@@ -0,0 +1,26 @@
+// +build !cortexm,!fe310
+
+package runtime
+
+// There is no interrupt controller that can be controlled on this target, so
+// the runtime/interrupt functions do nothing.
+
+func interruptEnable(irq uint32) {
+}
+
+func interruptDisable(irq uint32) {
+}
+
+func interruptSetPriority(irq uint32, priority uint8) {
+}
+
+func interruptTrigger(irq uint32) bool {
+	return false
+}
+
+func disableInterrupts() uintptr {
+	return 0
+}
+
+func restoreInterrupts(mask uintptr) {
+}
@@ -0,0 +1,39 @@
+// +build cortexm
+
+package runtime
+
+// Control of individual interrupts in the NVIC, for the runtime/interrupt
+// package.
+
+import (
+	"device/arm"
+)
+
+func interruptEnable(irq uint32) {
+	arm.EnableIRQ(irq)
+}
+
+func interruptDisable(irq uint32) {
+	arm.DisableIRQ(irq)
+}
+
+func interruptSetPriority(irq uint32, priority uint8) {
+	arm.SetPriority(irq, uint32(priority))
+}
+
+func interruptTrigger(irq uint32) bool {
+	arm.NVIC.ISPR[irq>>5].Set(1 << (irq & 0x1f))
+	return true
+}
+
+// disableInterrupts disables all interrupts and returns the previous state,
+// which must be passed to restoreInterrupts. Calls can be nested.
+func disableInterrupts() uintptr {
+	return arm.DisableInterrupts()
+}
+
+// restoreInterrupts restores the interrupt state from before the matching
+// disableInterrupts call.
+func restoreInterrupts(mask uintptr) {
+	arm.EnableInterrupts(mask)
+}
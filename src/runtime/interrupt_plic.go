@@ -0,0 +1,56 @@
+// +build fe310
+
+package runtime
+
+// Control of individual interrupts in the PLIC (Platform-Level Interrupt
+// Controller) of the FE310, for the runtime/interrupt package. Only the
+// machine mode context of hart 0 is used.
+
+import (
+	"runtime/volatile"
+	"unsafe"
+)
+
+const (
+	plicPriority = 0x0c000000 // one 32-bit priority register per interrupt
+	plicEnable   = 0x0c002000 // enable bits for hart 0 machine mode
+)
+
+func plicRegister(addr uintptr) *volatile.Register32 {
+	return (*volatile.Register32)(unsafe.Pointer(addr))
+}
+
+func interruptEnable(irq uint32) {
+	plicRegister(plicEnable + uintptr(irq/32)*4).SetBits(1 << (irq % 32))
+}
+
+func interruptDisable(irq uint32) {
+	plicRegister(plicEnable + uintptr(irq/32)*4).ClearBits(1 << (irq % 32))
+}
+
+// interruptSetPriority converts the priority to one of the 7 PLIC levels. The
+// PLIC uses the opposite convention of the Cortex-M: a higher level is more
+// urgent, and level 0 means the interrupt never fires.
+func interruptSetPriority(irq uint32, priority uint8) {
+	level := 7 - uint32(priority)>>5
+	if level == 0 {
+		level = 1
+	}
+	plicRegister(plicPriority + uintptr(irq)*4).Set(level)
+}
+
+// interruptTrigger is not supported: the pending bits of the PLIC can't be set
+// from software.
+func interruptTrigger(irq uint32) bool {
+	return false
+}
+
+// Interrupt handlers don't run Go code on this chip yet, so nothing needs to be
+// protected against them.
+
+func disableInterrupts() uintptr {
+	return 0
+}
+
+func restoreInterrupts(mask uintptr) {
+}
@@ -0,0 +1,12 @@
+// +build !wasi
+
+package runtime
+
+// Only goroutines on WASI can wait for I/O, see iowait_wasi.go.
+
+func ioWaiting() bool {
+	return false
+}
+
+func pollIO(timeout timeUnit, hasTimeout bool) {
+}
@@ -0,0 +1,176 @@
+// +build wasm,wasi
+
+package runtime
+
+// This file lets goroutines wait for I/O on WASI without blocking other
+// goroutines. A goroutine that waits for a file descriptor (like a socket
+// passed in by the host, or stdin) to become readable or writable is added to
+// a list of waiters and blocks on a channel. When no goroutine can run, the
+// scheduler waits for all waiters and for the first sleeping goroutine in a
+// single call to poll_oneoff, instead of polling the file descriptors one by
+// one. The channel of every waiter that can continue is then closed, which
+// puts its goroutine back in the run queue.
+
+import (
+	"unsafe"
+)
+
+// Error number of a non-blocking operation that would block.
+const wasiErrnoAgain = 6
+
+// Event types of poll_oneoff.
+const (
+	wasiEventTypeClock   = 0
+	wasiEventTypeFDRead  = 1
+	wasiEventTypeFDWrite = 2
+)
+
+// wasiSubscription is a subscription of poll_oneoff. The fields after the
+// event type are a union of the clock and fd_readwrite subscriptions.
+type wasiSubscription struct {
+	userData  uint64
+	eventType uint8
+	_         [7]byte
+	u0        uint64 // clock: identifier, fd_read/fd_write: file descriptor
+	clockID   uint32
+	_         uint32
+	timeout   uint64
+	precision uint64
+	flags     uint16
+	_         [6]byte
+}
+
+// wasiEvent is an event returned by poll_oneoff.
+type wasiEvent struct {
+	userData  uint64
+	errno     uint16
+	eventType uint8
+	_         [5]byte
+	nbytes    uint64
+	flags     uint16
+	_         [6]byte
+}
+
+// ioWaiter is a goroutine that waits for a file descriptor.
+type ioWaiter struct {
+	next  *ioWaiter
+	fd    uint32
+	write bool
+	ready chan struct{}
+}
+
+// ioWaiters is the list of goroutines that wait for I/O.
+var ioWaiters *ioWaiter
+
+// Buffers for poll_oneoff, kept between calls.
+var (
+	pollSubscriptions []wasiSubscription
+	pollEvents        []wasiEvent
+)
+
+// waitIO blocks the calling goroutine until the file descriptor is readable
+// (or writable, if write is set), or has an error.
+func waitIO(fd uint32, write bool) {
+	w := &ioWaiter{fd: fd, write: write, ready: make(chan struct{})}
+	w.next = ioWaiters
+	ioWaiters = w
+	<-w.ready
+}
+
+// ioWaiting returns whether there are goroutines that wait for I/O.
+func ioWaiting() bool {
+	return ioWaiters != nil
+}
+
+// pollIO waits until a goroutine that waits for I/O can continue, or (if
+// hasTimeout is set) until the timeout has passed, whichever comes first.
+// Goroutines that can continue are added to the run queue.
+func pollIO(timeout timeUnit, hasTimeout bool) {
+	subscriptions := pollSubscriptions[:0]
+	if hasTimeout {
+		if timeout < 0 {
+			timeout = 0
+		}
+		subscriptions = append(subscriptions, wasiSubscription{
+			eventType: wasiEventTypeClock,
+			clockID:   wasiClockMonotonic,
+			timeout:   uint64(timeout),
+		})
+	}
+	for w := ioWaiters; w != nil; w = w.next {
+		eventType := uint8(wasiEventTypeFDRead)
+		if w.write {
+			eventType = wasiEventTypeFDWrite
+		}
+		subscriptions = append(subscriptions, wasiSubscription{
+			userData:  uint64(uintptr(unsafe.Pointer(w))),
+			eventType: eventType,
+			u0:        uint64(w.fd),
+		})
+	}
+	pollSubscriptions = subscriptions
+	if len(subscriptions) == 0 {
+		return
+	}
+	if cap(pollEvents) < len(subscriptions) {
+		pollEvents = make([]wasiEvent, len(subscriptions))
+	}
+	events := pollEvents[:len(subscriptions)]
+	var nevents uint32
+	if poll_oneoff(&subscriptions[0], &events[0], uint32(len(subscriptions)), &nevents) != 0 {
+		return
+	}
+	for _, event := range events[:nevents] {
+		if event.eventType == wasiEventTypeClock {
+			continue
+		}
+		// The file descriptor is ready, or has an error that the goroutine
+		// will get when it retries the operation.
+		w := (*ioWaiter)(unsafe.Pointer(uintptr(event.userData)))
+		for p := &ioWaiters; *p != nil; p = &(*p).next {
+			if *p == w {
+				*p = w.next
+				break
+			}
+		}
+		close(w.ready)
+	}
+}
+
+// readFD reads from a file descriptor for the os package, after waiting until
+// there is something to read.
+//go:linkname readFD os.readFD
+func readFD(fd uintptr, b []byte) (int, uint16) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	for {
+		waitIO(uint32(fd), false)
+		iov := wasiIOVec{unsafe.Pointer(&b[0]), uintptr(len(b))}
+		var n uint32
+		errno := fd_read(uint32(fd), &iov, 1, &n)
+		if errno != wasiErrnoAgain {
+			return int(n), errno
+		}
+	}
+}
+
+// writeFD writes to a file descriptor for the os package. It only waits for the
+// file descriptor when it can't take all data at once.
+//go:linkname writeFD os.writeFD
+func writeFD(fd uintptr, b []byte) (int, uint16) {
+	written := 0
+	for written < len(b) {
+		iov := wasiIOVec{unsafe.Pointer(&b[written]), uintptr(len(b) - written)}
+		var n uint32
+		errno := fd_write(uint32(fd), &iov, 1, &n)
+		if errno != 0 && errno != wasiErrnoAgain {
+			return written, errno
+		}
+		written += int(n)
+		if written < len(b) {
+			waitIO(uint32(fd), true)
+		}
+	}
+	return written, 0
+}
@@ -0,0 +1,10 @@
+package runtime
+
+// This file provides the values of the runtime/metrics package.
+
+//go:linkname readMetrics runtime/metrics.readRuntime
+func readMetrics() (heapSize, heapInUse uintptr, cycles, goroutines uint32, wakeupLatency int64) {
+	heapSize, heapInUse = heapStats()
+	goroutines, latency := schedStats()
+	return heapSize, heapInUse, gcCycles, goroutines, int64(latency) * tickMicros
+}
@@ -0,0 +1,174 @@
+// Package metrics provides a subset of the metrics of the Go runtime, with the
+// same API, so that monitoring code written for Go works on TinyGo:
+//
+//     samples := []metrics.Sample{
+//         {Name: "/memory/classes/heap/objects:bytes"},
+//         {Name: "/sched/goroutines:goroutines"},
+//     }
+//     metrics.Read(samples)
+//     println(samples[0].Value.Uint64(), samples[1].Value.Uint64())
+//
+// See All for the list of supported metrics. Metrics of the Go runtime that
+// are not supported (or don't make sense on TinyGo) read as KindBad, just like
+// unknown metrics in Go.
+package metrics
+
+import (
+	"math"
+)
+
+// Implemented in the runtime.
+func readRuntime() (heapSize, heapInUse uintptr, gcCycles, goroutines uint32, wakeupLatency int64)
+
+// ValueKind is a tag for a metric Value which indicates its type.
+type ValueKind int
+
+const (
+	// KindBad indicates that the Value has no type and should not be used.
+	KindBad ValueKind = iota
+
+	// KindUint64 indicates that the type of the Value is a uint64.
+	KindUint64
+
+	// KindFloat64 indicates that the type of the Value is a float64.
+	KindFloat64
+
+	// KindFloat64Histogram indicates that the type of the Value is a
+	// *Float64Histogram.
+	KindFloat64Histogram
+)
+
+// Float64Histogram represents a distribution of float64 values. No supported
+// metric is a histogram, but the type is provided for compatibility.
+type Float64Histogram struct {
+	// Counts contains the weights for each histogram bucket.
+	Counts []uint64
+
+	// Buckets contains the boundaries of the histogram buckets, in increasing
+	// order. There is one more boundary than there are counts.
+	Buckets []float64
+}
+
+// Value represents a metric value returned by the runtime.
+type Value struct {
+	kind      ValueKind
+	scalar    uint64 // contains the bits of a float64 for KindFloat64
+	histogram *Float64Histogram
+}
+
+// Kind returns the tag representing the kind of value this is.
+func (v Value) Kind() ValueKind {
+	return v.kind
+}
+
+// Uint64 returns the internal uint64 value for the metric. It panics if the
+// metric is not a KindUint64.
+func (v Value) Uint64() uint64 {
+	if v.kind != KindUint64 {
+		panic("called Uint64 on non-uint64 metric value")
+	}
+	return v.scalar
+}
+
+// Float64 returns the internal float64 value for the metric. It panics if the
+// metric is not a KindFloat64.
+func (v Value) Float64() float64 {
+	if v.kind != KindFloat64 {
+		panic("called Float64 on non-float64 metric value")
+	}
+	return math.Float64frombits(v.scalar)
+}
+
+// Float64Histogram returns the internal *Float64Histogram value for the
+// metric. It panics if the metric is not a KindFloat64Histogram.
+func (v Value) Float64Histogram() *Float64Histogram {
+	if v.kind != KindFloat64Histogram {
+		panic("called Float64Histogram on non-Float64Histogram metric value")
+	}
+	return v.histogram
+}
+
+// Description describes a runtime metric.
+type Description struct {
+	// Name is the full name of the metric which includes the unit, like
+	// "/sched/goroutines:goroutines".
+	Name string
+
+	// Description is an English language sentence describing the metric.
+	Description string
+
+	// Kind is the kind of value for this metric.
+	Kind ValueKind
+
+	// Cumulative is whether or not the metric is cumulative.
+	Cumulative bool
+}
+
+// The supported metrics, sorted by name like in Go.
+var allDesc = []Description{
+	{
+		Name:        "/gc/cycles/total:gc-cycles",
+		Description: "Count of all completed GC cycles.",
+		Kind:        KindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/memory/classes/heap/free:bytes",
+		Description: "Memory in the heap that is not in use by objects. Zero if the heap is not managed by the runtime.",
+		Kind:        KindUint64,
+	},
+	{
+		Name:        "/memory/classes/heap/objects:bytes",
+		Description: "Memory occupied by live objects and dead objects that have not yet been collected. Zero if the heap is not managed by the runtime.",
+		Kind:        KindUint64,
+	},
+	{
+		Name:        "/sched/goroutines:goroutines",
+		Description: "Count of live goroutines.",
+		Kind:        KindUint64,
+	},
+	{
+		Name:        "/sched/wakeup-latency-max:seconds",
+		Description: "Longest time by which the scheduler woke up a sleeping goroutine late. Specific to TinyGo.",
+		Kind:        KindFloat64,
+		Cumulative:  true,
+	},
+}
+
+// All returns a slice containing metric descriptions for all supported
+// metrics.
+func All() []Description {
+	return allDesc
+}
+
+// Sample captures a single metric sample.
+type Sample struct {
+	// Name is the name of the metric sampled.
+	Name string
+
+	// Value is the value of the metric sample.
+	Value Value
+}
+
+// Read populates each Value field in the given slice of metric samples.
+// Samples with a name that is not supported get a Value of KindBad.
+func Read(m []Sample) {
+	heapSize, heapInUse, gcCycles, goroutines, wakeupLatency := readRuntime()
+	for i := range m {
+		sample := &m[i]
+		switch sample.Name {
+		case "/gc/cycles/total:gc-cycles":
+			sample.Value = Value{kind: KindUint64, scalar: uint64(gcCycles)}
+		case "/memory/classes/heap/free:bytes":
+			sample.Value = Value{kind: KindUint64, scalar: uint64(heapSize - heapInUse)}
+		case "/memory/classes/heap/objects:bytes":
+			sample.Value = Value{kind: KindUint64, scalar: uint64(heapInUse)}
+		case "/sched/goroutines:goroutines":
+			sample.Value = Value{kind: KindUint64, scalar: uint64(goroutines)}
+		case "/sched/wakeup-latency-max:seconds":
+			sample.Value = Value{kind: KindFloat64, scalar: math.Float64bits(float64(wakeupLatency) / 1e9)}
+		default:
+			sample.Value = Value{}
+		}
+	}
+}
@@ -5,18 +5,63 @@ package runtime
 //go:export llvm.trap
 func trap()
 
-// Builtin function panic(msg), used as a compiler intrinsic.
+// printingPanic is set while the value passed to panic is printed. Printing it
+// may call the Error or String method of the value, which may panic itself.
+var printingPanic bool
+
+// Builtin function panic(msg), used as a compiler intrinsic. Values that
+// implement error or fmt.Stringer are printed with their Error or String
+// method, see printitf.
 func _panic(message interface{}) {
+	checkPanicRecursion()
+	printingPanic = true
 	printstring("panic: ")
 	printitf(message)
 	printnl()
+	printPanicTrace()
 	abort()
 }
 
+// checkPanicRecursion aborts with a clear message if a panic happened while
+// printing the value of another panic, instead of printing a confusing mix of
+// both messages (or recursing forever).
+func checkPanicRecursion() {
+	if printingPanic {
+		printnl()
+		printstring("panic while printing panic value")
+		printnl()
+		abort()
+	}
+}
+
+// The Error interface identifies a run time error. It is only provided for
+// compatibility with the standard library: runtime panics currently always
+// abort the program, so no value of this type is ever created.
+type Error interface {
+	error
+
+	// RuntimeError is a no-op function but serves to distinguish types that
+	// are run time errors from ordinary errors.
+	RuntimeError()
+}
+
 // Cause a runtime panic, which is (currently) always a string.
 func runtimePanic(msg string) {
+	checkPanicRecursion()
 	printstring("panic: runtime error: ")
 	println(msg)
+	printPanicTrace()
+	abort()
+}
+
+// Panic with a constant message that was replaced by a code, to remove the
+// message from the binary (-scrub). The message can be found in the map file
+// of the build.
+func panicCode(code uint32) {
+	printstring("panic: #")
+	printuint32(code)
+	printnl()
+	printPanicTrace()
 	abort()
 }
 
@@ -40,6 +85,46 @@ func nilPanic() {
 	runtimePanic("nil pointer dereference")
 }
 
+// The source location of a check that failed, for -nil-check=location,
+// -check=overflow and //go:deadline. It is created by the compiler.
+type panicLocation struct {
+	function string
+	file     string
+	line     uintptr
+}
+
+// Panic with a runtime error, printing where it happened.
+func runtimePanicAt(msg string, location *panicLocation) {
+	checkPanicRecursion()
+	printstring("panic: runtime error: ")
+	printstring(msg)
+	printstring(" at ")
+	printPanicLocation(location)
+	printnl()
+	printPanicTrace()
+	abort()
+}
+
+// printPanicLocation prints a location as "pkg.Func (file:line)".
+func printPanicLocation(location *panicLocation) {
+	printstring(location.function)
+	printstring(" (")
+	printstring(location.file)
+	printbyte(':')
+	printuint32(uint32(location.line))
+	printbyte(')')
+}
+
+// Panic when trying to dereference a nil pointer, printing where it happened.
+func nilPanicAt(location *panicLocation) {
+	runtimePanicAt("nil pointer dereference", location)
+}
+
+// Panic on a signed integer overflow, with -check=overflow.
+func overflowPanic(location *panicLocation) {
+	runtimePanicAt("integer overflow", location)
+}
+
 // Panic when trying to acces an array or slice out of bounds.
 func lookupPanic() {
 	runtimePanic("index out of range")
@@ -50,6 +135,18 @@ func slicePanic() {
 	runtimePanic("slice out of range")
 }
 
+// Panic when calling unsafe.Slice with an invalid length, or with a nil pointer
+// and a non-zero length.
+func unsafeSlicePanic() {
+	runtimePanic("unsafe.Slice: len out of range")
+}
+
+// Panic when calling unsafe.String with an invalid length, or with a nil
+// pointer and a non-zero length.
+func unsafeStringPanic() {
+	runtimePanic("unsafe.String: len out of range")
+}
+
 func blockingPanic() {
 	runtimePanic("trying to do blocking operation in exported function")
 }
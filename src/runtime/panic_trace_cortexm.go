@@ -0,0 +1,48 @@
+// +build cortexm
+
+package runtime
+
+import (
+	"device/arm"
+	"runtime/internal/config"
+	"unsafe"
+)
+
+// Maximum number of calls printed in a panic trace.
+const panicTraceDepth = 16
+
+// printPanicTrace prints the return addresses of the calls that led to a
+// panic. With -panic-trace, the compiler keeps a frame pointer in every
+// function (r7 in Thumb code), which points to the saved frame pointer of the
+// caller followed by the return address. The printed addresses can be
+// converted to source locations with tinygo addr2line.
+//go:noinline
+func printPanicTrace() {
+	if !config.PanicTrace {
+		return
+	}
+	printstring("trace (tinygo addr2line <binary> <address>...):\n")
+	sp := getCurrentStackPointer()
+	fp := arm.ReadRegister("r7")
+	for i := 0; i < panicTraceDepth; i++ {
+		// Stop at the end of the chain: the reset handler is called by the
+		// hardware, so the frame pointer it saves is not a valid frame.
+		if fp&3 != 0 || fp < sp || fp+8 > stackTop {
+			break
+		}
+		lr := *(*uintptr)(unsafe.Pointer(fp + 4))
+		if lr >= 0xffffffe0 {
+			// EXC_RETURN: the frame below is the interrupted code.
+			printstring("  (interrupt)\n")
+		} else if i != 0 {
+			// Skip the call to this function. The address of the call
+			// instruction is just before the return address (which has the
+			// Thumb bit set).
+			printstring("  ")
+			printptr(lr&^1 - 1)
+			printnl()
+		}
+		sp = fp
+		fp = *(*uintptr)(unsafe.Pointer(fp))
+	}
+}
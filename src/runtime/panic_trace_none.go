@@ -0,0 +1,8 @@
+// +build !cortexm
+
+package runtime
+
+// printPanicTrace prints a call trace on a panic. It is only implemented for
+// Cortex-M (-panic-trace).
+func printPanicTrace() {
+}
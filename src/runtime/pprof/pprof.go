@@ -0,0 +1,99 @@
+// Package pprof writes CPU and heap profiles in the format of the pprof
+// visualization tool, so that they can be analyzed with go tool pprof:
+//
+//     go tool pprof -http=:8080 cpu.pprof
+//
+// Profiles are only recorded in programs built with the -pprof flag, which
+// instruments every function outside the runtime. Stacks are recorded from
+// this instrumentation instead of by unwinding the call stack, so profiling
+// works the same way on every target, including WebAssembly. Profiles contain
+// function names and source locations, so the binary is not needed to view
+// them.
+//
+// The heap profile only contains allocations (alloc_objects and alloc_space),
+// not the memory that is still in use.
+package pprof
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+//go:linkname readProfile runtime.readProfile
+func readProfile(kind int, fn func(count, bytes int64, stack []uintptr))
+
+//go:linkname profileInstrumented runtime.profileInstrumented
+func profileInstrumented() bool
+
+// function has the same layout as runtime.profileFunction.
+type function struct {
+	name string
+	file string
+	line uintptr
+}
+
+const (
+	kindCPU  = 0
+	kindHeap = 1
+)
+
+// cpuProfileRate is the number of CPU samples per second, like in Go.
+const cpuProfileRate = 100
+
+var (
+	cpuWriter io.Writer
+	cpuStart  time.Time
+)
+
+var errNotInstrumented = errors.New("pprof: profiling requires a build with -pprof")
+
+// StartCPUProfile enables CPU profiling. The profile is written to w when
+// StopCPUProfile is called.
+func StartCPUProfile(w io.Writer) error {
+	if !profileInstrumented() {
+		return errNotInstrumented
+	}
+	if cpuWriter != nil {
+		return errors.New("cpu profiling already in use")
+	}
+	cpuWriter = w
+	cpuStart = time.Now()
+	runtime.SetCPUProfileRate(cpuProfileRate)
+	return nil
+}
+
+// StopCPUProfile stops the current CPU profile, if any, and writes it.
+func StopCPUProfile() {
+	if cpuWriter == nil {
+		return
+	}
+	runtime.SetCPUProfileRate(0)
+	b := newProfileBuilder("samples", "count", "cpu", "nanoseconds", "cpu", "nanoseconds", 1e9/cpuProfileRate)
+	b.timeNanos = cpuStart.UnixNano()
+	b.durationNanos = int64(time.Since(cpuStart))
+	readProfile(kindCPU, b.addSample)
+	cpuWriter.Write(b.build())
+	cpuWriter = nil
+}
+
+// WriteHeapProfile writes a profile of the heap allocations since the start of
+// the program to w.
+func WriteHeapProfile(w io.Writer) error {
+	if !profileInstrumented() {
+		return errNotInstrumented
+	}
+	b := newProfileBuilder("alloc_objects", "count", "alloc_space", "bytes", "space", "bytes", int64(runtime.MemProfileRate))
+	b.timeNanos = time.Now().UnixNano()
+	readProfile(kindHeap, b.addSample)
+	_, err := w.Write(b.build())
+	return err
+}
+
+// functionAt returns the function descriptor at the given address in a stack
+// returned by readProfile.
+func functionAt(addr uintptr) *function {
+	return (*function)(unsafe.Pointer(addr))
+}
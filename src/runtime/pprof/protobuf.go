@@ -0,0 +1,169 @@
+package pprof
+
+// This file encodes profiles in the protocol buffer format of pprof, see
+// https://github.com/google/pprof/blob/master/proto/profile.proto. Only the
+// messages and fields that are needed are implemented.
+
+// Field numbers of the Profile message.
+const (
+	tagProfileSampleType    = 1
+	tagProfileSample        = 2
+	tagProfileLocation      = 4
+	tagProfileFunction      = 5
+	tagProfileStringTable   = 6
+	tagProfileTimeNanos     = 9
+	tagProfileDurationNanos = 10
+	tagProfilePeriodType    = 11
+	tagProfilePeriod        = 12
+)
+
+// protobuf is a protocol buffer encoder.
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protobuf) uint64(tag int, x uint64) {
+	b.varint(uint64(tag)<<3 | 0) // varint wire type
+	b.varint(x)
+}
+
+func (b *protobuf) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protobuf) bytes(tag int, data []byte) {
+	b.varint(uint64(tag)<<3 | 2) // length-delimited wire type
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protobuf) string(tag int, s string) {
+	b.bytes(tag, []byte(s))
+}
+
+// message encodes a nested message.
+func (b *protobuf) message(tag int, fn func(m *protobuf)) {
+	m := &protobuf{}
+	fn(m)
+	b.bytes(tag, m.data)
+}
+
+// packed encodes a packed repeated field of integers.
+func (b *protobuf) packed(tag int, values []uint64) {
+	m := &protobuf{}
+	for _, x := range values {
+		m.varint(x)
+	}
+	b.bytes(tag, m.data)
+}
+
+// profileBuilder collects the samples of a profile and encodes it.
+type profileBuilder struct {
+	samples       protobuf
+	strings       []string
+	stringIndex   map[string]int
+	functions     map[*function]uint64 // function (and location) IDs
+	functionList  []*function
+	sampleType    [2][2]int // type and unit of the two values of a sample
+	periodType    [2]int
+	period        int64
+	timeNanos     int64
+	durationNanos int64
+}
+
+func newProfileBuilder(countType, countUnit, valueType, valueUnit, periodType, periodUnit string, period int64) *profileBuilder {
+	b := &profileBuilder{
+		stringIndex: map[string]int{},
+		functions:   map[*function]uint64{},
+		period:      period,
+	}
+	b.stringID("") // the first string must be empty
+	b.sampleType = [2][2]int{
+		{b.stringID(countType), b.stringID(countUnit)},
+		{b.stringID(valueType), b.stringID(valueUnit)},
+	}
+	b.periodType = [2]int{b.stringID(periodType), b.stringID(periodUnit)}
+	return b
+}
+
+func (b *profileBuilder) stringID(s string) int {
+	if id, ok := b.stringIndex[s]; ok {
+		return id
+	}
+	b.stringIndex[s] = len(b.strings)
+	b.strings = append(b.strings, s)
+	return len(b.strings) - 1
+}
+
+// addSample adds a sample with the given values. The stack is a list of
+// function descriptors, from the innermost to the outermost function. Every
+// function has a single location.
+func (b *profileBuilder) addSample(count, value int64, stack []uintptr) {
+	locations := make([]uint64, len(stack))
+	for i, addr := range stack {
+		fn := functionAt(addr)
+		id, ok := b.functions[fn]
+		if !ok {
+			id = uint64(len(b.functionList) + 1)
+			b.functions[fn] = id
+			b.functionList = append(b.functionList, fn)
+		}
+		locations[i] = id
+	}
+	b.samples.message(tagProfileSample, func(m *protobuf) {
+		m.packed(1, locations)                              // location_id
+		m.packed(2, []uint64{uint64(count), uint64(value)}) // value
+	})
+}
+
+// build returns the encoded profile.
+func (b *profileBuilder) build() []byte {
+	p := &protobuf{}
+	for _, st := range b.sampleType {
+		st := st
+		p.message(tagProfileSampleType, func(m *protobuf) {
+			m.int64(1, int64(st[0])) // type
+			m.int64(2, int64(st[1])) // unit
+		})
+	}
+	p.data = append(p.data, b.samples.data...)
+	for i, fn := range b.functionList {
+		id := uint64(i + 1)
+		line := int64(fn.line)
+		p.message(tagProfileLocation, func(m *protobuf) {
+			m.uint64(1, id)                  // id
+			m.message(4, func(l *protobuf) { // line
+				l.uint64(1, id) // function_id
+				l.int64(2, line)
+			})
+		})
+		name := int64(b.stringID(fn.name))
+		file := int64(b.stringID(fn.file))
+		p.message(tagProfileFunction, func(m *protobuf) {
+			m.uint64(1, id)  // id
+			m.int64(2, name) // name
+			m.int64(3, name) // system_name
+			m.int64(4, file) // filename
+			m.int64(5, line) // start_line
+		})
+	}
+	for _, s := range b.strings {
+		p.string(tagProfileStringTable, s)
+	}
+	p.int64(tagProfileTimeNanos, b.timeNanos)
+	p.int64(tagProfileDurationNanos, b.durationNanos)
+	p.message(tagProfilePeriodType, func(m *protobuf) {
+		m.int64(1, int64(b.periodType[0]))
+		m.int64(2, int64(b.periodType[1]))
+	})
+	p.int64(tagProfilePeriod, b.period)
+	return p.data
+}
@@ -15,6 +15,8 @@ func printstring(s string) {
 	}
 }
 
+// The recursion depth is bounded by the number of digits.
+//go:recursive
 func printuint8(n uint8) {
 	if TargetBits >= 32 {
 		printuint32(uint32(n))
@@ -76,6 +78,8 @@ func printint32(n int32) {
 	printuint32(uint32(n))
 }
 
+// The recursion depth is bounded by the number of digits.
+//go:recursive
 func printuint64(n uint64) {
 	prevdigits := n / 10
 	if prevdigits != 0 {
@@ -196,6 +200,12 @@ func printspace() {
 	putchar(' ')
 }
 
+// printbyte prints a single byte. It is used by the compiler for the
+// punctuation of composite values.
+func printbyte(c byte) {
+	putchar(c)
+}
+
 func printnl() {
 	putchar('\r')
 	putchar('\n')
@@ -0,0 +1,182 @@
+package runtime
+
+// This file implements the profiler that is used by the runtime/pprof package.
+// When a program is built with -pprof, the compiler inserts calls to
+// profileEnter and profileExit in every function outside the runtime, which
+// maintain a shadow call stack. CPU profiles take a sample of this stack when
+// the sampling period has passed at one of these calls, and heap profiles
+// take a sample on allocations.
+//
+// Goroutines share the shadow stack: the scheduler restores its depth when a
+// goroutine yields, but a goroutine that is resumed in the middle of a
+// function doesn't have the frames of that function on the shadow stack. Its
+// samples are attributed to the functions it calls after resuming.
+
+import (
+	"runtime/internal/config"
+	"unsafe"
+)
+
+// profileFunction describes an instrumented function. The compiler creates
+// one for every instrumented function.
+type profileFunction struct {
+	name string
+	file string
+	line uintptr
+}
+
+// maxProfileDepth is the number of frames that are kept of the shadow stack.
+// Deeper frames are counted but not recorded.
+const maxProfileDepth = 32
+
+var (
+	profileStack [maxProfileDepth]*profileFunction
+	profileDepth int
+)
+
+// profileRecord is a recorded sample, with the stack from the outermost to the
+// innermost function.
+type profileRecord struct {
+	next  *profileRecord
+	count int64
+	bytes int64
+	depth int
+	stack [maxProfileDepth]*profileFunction
+}
+
+var (
+	cpuProfile    *profileRecord
+	cpuPeriod     int64 // sampling period in nanoseconds, 0 if not profiling
+	cpuNextSample int64
+
+	memProfile     *profileRecord
+	memProfileNext int64 // bytes until the next heap sample
+
+	profileBusy bool // the profiler is allocating a record
+)
+
+// MemProfileRate controls the fraction of memory allocations that are
+// recorded in the heap profile: on average, one allocation is recorded per
+// MemProfileRate bytes allocated. Set it to 1 to record every allocation.
+var MemProfileRate int = 512 * 1024
+
+// SetCPUProfileRate sets the CPU profiling rate to hz samples per second. If hz
+// is zero, profiling is turned off. Samples are only taken in programs built
+// with -pprof.
+func SetCPUProfileRate(hz int) {
+	if hz <= 0 {
+		cpuPeriod = 0
+		return
+	}
+	cpuPeriod = 1e9 / int64(hz)
+	cpuNextSample = nanotime() + cpuPeriod
+	cpuProfile = nil
+}
+
+// profileEnter is inserted by the compiler at the start of every instrumented
+// function.
+func profileEnter(fn *profileFunction) {
+	if profileDepth < maxProfileDepth {
+		profileStack[profileDepth] = fn
+	}
+	profileDepth++
+	if cpuPeriod != 0 {
+		profileSampleCPU()
+	}
+}
+
+// profileExit is inserted by the compiler before every return of an
+// instrumented function.
+func profileExit() {
+	if cpuPeriod != 0 {
+		profileSampleCPU()
+	}
+	if profileDepth > 0 {
+		profileDepth--
+	}
+}
+
+// profileSampleCPU takes a sample if the sampling period has passed, counting
+// every period that passed since the last sample.
+func profileSampleCPU() {
+	now := nanotime()
+	if now < cpuNextSample {
+		return
+	}
+	n := (now-cpuNextSample)/cpuPeriod + 1
+	cpuNextSample += n * cpuPeriod
+	cpuProfile = profileRecordSample(cpuProfile, n, n*cpuPeriod)
+}
+
+// profileAlloc is called by the garbage collector on every heap allocation.
+func profileAlloc(size uintptr) {
+	if !config.Profile || profileBusy || MemProfileRate <= 0 || size == 0 {
+		return
+	}
+	memProfileNext -= int64(size)
+	if memProfileNext > 0 {
+		return
+	}
+	// Record this allocation for all allocations of the last sampling
+	// interval.
+	count := int64(MemProfileRate) / int64(size)
+	if count < 1 {
+		count = 1
+	}
+	memProfileNext += int64(MemProfileRate)
+	memProfile = profileRecordSample(memProfile, count, count*int64(size))
+}
+
+// profileRecordSample adds a sample of the current shadow stack to the list
+// of records and returns the new list.
+func profileRecordSample(list *profileRecord, count, bytes int64) *profileRecord {
+	depth := profileDepth
+	if depth > maxProfileDepth {
+		depth = maxProfileDepth
+	}
+	for r := list; r != nil; r = r.next {
+		if r.depth == depth && r.sameStack() {
+			r.count += count
+			r.bytes += bytes
+			return list
+		}
+	}
+	profileBusy = true
+	r := &profileRecord{next: list, count: count, bytes: bytes, depth: depth}
+	profileBusy = false
+	copy(r.stack[:depth], profileStack[:depth])
+	return r
+}
+
+// sameStack returns whether the record has the same stack as the current
+// shadow stack.
+func (r *profileRecord) sameStack() bool {
+	for i := 0; i < r.depth; i++ {
+		if r.stack[i] != profileStack[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readProfile calls fn for every record of a CPU profile (kind 0) or a heap
+// profile (kind 1). The stack is a list of *profileFunction values, from the
+// innermost to the outermost function. It is used by runtime/pprof.
+func readProfile(kind int, fn func(count, bytes int64, stack []uintptr)) {
+	list := cpuProfile
+	if kind == 1 {
+		list = memProfile
+	}
+	var stack [maxProfileDepth]uintptr
+	for r := list; r != nil; r = r.next {
+		for i := 0; i < r.depth; i++ {
+			stack[i] = uintptr(unsafe.Pointer(r.stack[r.depth-1-i]))
+		}
+		fn(r.count, r.bytes, stack[:r.depth])
+	}
+}
+
+// profileInstrumented returns whether the program was built with -pprof.
+func profileInstrumented() bool {
+	return config.Profile
+}
@@ -81,21 +81,51 @@ func memequal(x, y unsafe.Pointer, n uintptr) bool {
 
 //go:linkname sleep time.Sleep
 func sleep(d int64) {
+	if virtualClock {
+		// Nothing else can run, so let the time pass immediately.
+		virtualTicks += timeUnit(d / tickMicros)
+		return
+	}
 	sleepTicks(timeUnit(d / tickMicros))
 }
 
 func nanotime() int64 {
-	return int64(ticks()) * tickMicros
+	return int64(clockTicks()) * tickMicros
 }
 
+// timeOffset is the wall-clock time (in nanoseconds since the Unix epoch) at
+// which the monotonic clock was zero. It is zero until the time is set, so
+// that time.Now returns the time since boot until then.
+var timeOffset int64
+
+// storeTimeOffset saves timeOffset so that it survives a reset. It is only set
+// on targets where the clock keeps running across resets.
+var storeTimeOffset func()
+
 //go:linkname now time.now
 func now() (sec int64, nsec int32, mono int64) {
 	mono = nanotime()
-	sec = mono / (1000 * 1000 * 1000)
-	nsec = int32(mono - sec*(1000*1000*1000))
+	wall := mono + timeOffset
+	sec = wall / (1000 * 1000 * 1000)
+	nsec = int32(wall - sec*(1000*1000*1000))
 	return
 }
 
+// setTime sets the current wall-clock time in nanoseconds since the Unix
+// epoch. It is called by machine.RTC.
+func setTime(unixNano int64) {
+	timeOffset = unixNano - nanotime()
+	if storeTimeOffset != nil {
+		storeTimeOffset()
+	}
+}
+
+// getTime returns the current wall-clock time in nanoseconds since the Unix
+// epoch. It is called by machine.RTC.
+func getTime() int64 {
+	return nanotime() + timeOffset
+}
+
 // Copied from the Go runtime source code.
 //go:linkname os_sigpipe os.sigpipe
 func os_sigpipe() {
@@ -233,8 +233,6 @@ var (
 
 var timerWakeup volatile.Register8
 
-const asyncScheduler = false
-
 // sleepTicks should sleep for d number of microseconds.
 func sleepTicks(d timeUnit) {
 	for d != 0 {
@@ -283,6 +281,11 @@ func timerSleep(ticks uint32) {
 	}
 }
 
+// wakeScheduler ends the current sleep of the scheduler early.
+func wakeScheduler() {
+	timerWakeup.Set(1)
+}
+
 //go:export RTC_IRQHandler
 func handleRTC() {
 	// disable IRQ for CMP0 compare
@@ -312,3 +315,16 @@ func initADCClock() {
 		sam.GCLK_CLKCTRL_CLKEN)
 	waitForSync()
 }
+
+// Suspend puts the chip in standby mode until it is woken up by an enabled
+// interrupt, for example from a pin. Timers do not wake up the chip and
+// time.Now does not advance while the chip is suspended. Use
+// machine.SetSleepMode to select the sleep mode used while goroutines are
+// sleeping instead.
+func Suspend() {
+	sam.RTC_MODE0.INTENCLR.Set(sam.RTC_MODE0_INTENSET_CMP0)
+	scr := arm.SCB.SCR.Get()
+	arm.SCB.SCR.SetBits(arm.SCB_SCR_SLEEPDEEP)
+	arm.Asm("wfi")
+	arm.SCB.SCR.Set(scr)
+}
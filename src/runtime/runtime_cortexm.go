@@ -76,6 +76,15 @@ type interruptStack struct {
 // https://blog.feabhas.com/2013/02/developing-a-generic-hard-fault-handler-for-arm-cortex-m3cortex-m4/
 //go:export handleHardFault
 func handleHardFault(sp *interruptStack) {
+	if uintptr(unsafe.Pointer(&sp.PC)) >= 0x20000000 && *(*uint16)(unsafe.Pointer(sp.PC)) == 0xde01 {
+		// The instruction "udf #1", which the compiler inserts for failed nil
+		// checks with -nil-check=trap. The address is converted to a source
+		// location by the serial monitor of tinygo flash.
+		printstring("panic: runtime error: nil pointer dereference at:\n  ")
+		printptr(sp.PC)
+		printnl()
+		abort()
+	}
 	print("fatal error: ")
 	if uintptr(unsafe.Pointer(sp)) < 0x20000000 {
 		print("stack overflow")
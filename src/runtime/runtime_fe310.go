@@ -99,8 +99,6 @@ func ticks() timeUnit {
 	}
 }
 
-const asyncScheduler = false
-
 func sleepTicks(d timeUnit) {
 	target := ticks() + d
 	for ticks() < target {
@@ -51,8 +51,6 @@ func putchar(c byte) {
 	machine.UART0.WriteByte(c)
 }
 
-const asyncScheduler = false
-
 func sleepTicks(d timeUnit) {
 	for d != 0 {
 		ticks()                       // update timestamp
@@ -97,9 +95,27 @@ func rtc_sleep(ticks uint32) {
 	}
 }
 
+// wakeScheduler ends the current sleep of the scheduler early.
+func wakeScheduler() {
+	rtc_wakeup.Set(1)
+}
+
 //go:export RTC1_IRQHandler
 func handleRTC1() {
 	nrf.RTC1.INTENCLR.Set(nrf.RTC_INTENSET_COMPARE0)
 	nrf.RTC1.EVENTS_COMPARE[0].Set(0)
 	rtc_wakeup.Set(1)
 }
+
+// Suspend puts the chip in System OFF mode, the deepest sleep mode available.
+// The chip can only be woken up by a pin configured with
+// machine.Pin.EnableWakeup, and it will reset when it wakes up, so Suspend
+// never returns. Use machine.SetSleepMode to select the sleep mode used while
+// goroutines are sleeping instead.
+func Suspend() {
+	nrf.POWER.SYSTEMOFF.Set(nrf.POWER_SYSTEMOFF_SYSTEMOFF_Enter)
+	for {
+		// System OFF is not entered immediately in a debug session.
+		arm.Asm("wfe")
+	}
+}
@@ -26,8 +26,6 @@ func main() {
 	abort()
 }
 
-const asyncScheduler = false
-
 func sleepTicks(d timeUnit) {
 	// TODO: actually sleep here for the given time.
 	timestamp += d
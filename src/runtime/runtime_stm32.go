@@ -2,6 +2,11 @@
 
 package runtime
 
+import (
+	"device/arm"
+	"device/stm32"
+)
+
 type timeUnit int64
 
 //go:export Reset_Handler
@@ -11,3 +16,22 @@ func main() {
 	callMain()
 	abort()
 }
+
+// Suspend puts the chip in stop mode until it is woken up by an external
+// interrupt (EXTI), for example from a pin. Timers do not wake up the chip and
+// time does not advance while the chip is suspended. Use machine.SetSleepMode
+// to select the sleep mode used while goroutines are sleeping instead.
+func Suspend() {
+	// Enter stop mode (not standby) with the voltage regulator in low power
+	// mode.
+	stm32.RCC.APB1ENR.SetBits(stm32.RCC_APB1ENR_PWREN)
+	stm32.PWR.CR.ClearBits(stm32.PWR_CR_PDDS)
+	stm32.PWR.CR.SetBits(stm32.PWR_CR_LPDS)
+	arm.SCB.SCR.SetBits(arm.SCB_SCR_SLEEPDEEP)
+	arm.Asm("wfi")
+	arm.SCB.SCR.ClearBits(arm.SCB_SCR_SLEEPDEEP)
+
+	// The chip runs from the internal oscillator after waking up from stop
+	// mode, so the clocks need to be configured again.
+	initCLK()
+}
@@ -68,6 +68,21 @@ func initRTC() {
 	// access to backup register
 	stm32.PWR.CR.SetBits(stm32.PWR_CR_DBP)
 
+	// The RTC is in the backup domain, which is not reset by a system reset.
+	// When the RTC is already running, keep it running and restore the
+	// wall-clock time offset from the backup registers.
+	storeTimeOffset = storeBackupTimeOffset
+	if stm32.RCC.BDCR.HasBits(stm32.RCC_BDCR_RTCEN) {
+		timeOffset = int64(stm32.BKP.DR1.Get()&0xffff) |
+			int64(stm32.BKP.DR2.Get()&0xffff)<<16 |
+			int64(stm32.BKP.DR3.Get()&0xffff)<<32 |
+			int64(stm32.BKP.DR4.Get()&0xffff)<<48
+		stm32.RTC.CRL.ClearBits(stm32.RTC_CRL_RSF)
+		for !stm32.RTC.CRL.HasBits(stm32.RTC_CRL_RSF) {
+		}
+		return
+	}
+
 	// Enable LSE
 	stm32.RCC.BDCR.SetBits(stm32.RCC_BDCR_LSEON)
 
@@ -97,6 +112,15 @@ func initRTC() {
 	}
 }
 
+// storeBackupTimeOffset saves the wall-clock time offset in the backup
+// registers, which are 16 bits each.
+func storeBackupTimeOffset() {
+	stm32.BKP.DR1.Set(uint32(timeOffset) & 0xffff)
+	stm32.BKP.DR2.Set(uint32(timeOffset>>16) & 0xffff)
+	stm32.BKP.DR3.Set(uint32(timeOffset>>32) & 0xffff)
+	stm32.BKP.DR4.Set(uint32(timeOffset>>48) & 0xffff)
+}
+
 // Enable the TIM3 clock.
 func initTIM() {
 	stm32.RCC.APB1ENR.SetBits(stm32.RCC_APB1ENR_TIM3EN)
@@ -105,8 +129,6 @@ func initTIM() {
 	arm.EnableIRQ(stm32.IRQ_TIM3)
 }
 
-const asyncScheduler = false
-
 // sleepTicks should sleep for specific number of microseconds.
 func sleepTicks(d timeUnit) {
 	for d != 0 {
@@ -180,6 +202,11 @@ func timerSleep(ticks uint32) {
 	}
 }
 
+// wakeScheduler ends the current sleep of the scheduler early.
+func wakeScheduler() {
+	timerWakeup.Set(1)
+}
+
 //go:export TIM3_IRQHandler
 func handleTIM3() {
 	if stm32.TIM3.SR.HasBits(stm32.TIM_SR_UIF) {
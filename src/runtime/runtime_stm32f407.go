@@ -143,8 +143,6 @@ func initTIM7() {
 	arm.EnableIRQ(stm32.IRQ_TIM7)
 }
 
-const asyncScheduler = false
-
 // sleepTicks should sleep for specific number of microseconds.
 func sleepTicks(d timeUnit) {
 	timerSleep(uint32(d))
@@ -183,6 +181,11 @@ func timerSleep(ticks uint32) {
 	}
 }
 
+// wakeScheduler ends the current sleep of the scheduler early.
+func wakeScheduler() {
+	timerWakeup.Set(1)
+}
+
 //go:export TIM3_IRQHandler
 func handleTIM3() {
 	if stm32.TIM3.SR.HasBits(stm32.TIM_SR_UIF) {
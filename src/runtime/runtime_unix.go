@@ -1,4 +1,4 @@
-// +build darwin linux,!avr,!cortexm,!tinygo.riscv
+// +build darwin linux,!avr,!cortexm,!tinygo.riscv,!wasi
 
 package runtime
 
@@ -60,8 +60,6 @@ func putchar(c byte) {
 	_putchar(int(c))
 }
 
-const asyncScheduler = false
-
 func sleepTicks(d timeUnit) {
 	usleep(uint(d) / 1000)
 }
@@ -1,11 +1,7 @@
-// +build wasm
+// +build wasm,!wasi
 
 package runtime
 
-import (
-	"unsafe"
-)
-
 type timeUnit float64 // time in milliseconds, just like Date.now() in JavaScript
 
 const tickMicros = 1000000
@@ -54,7 +50,32 @@ func go_scheduler() {
 	scheduler()
 }
 
-const asyncScheduler = true
+// tinygo_poll runs all goroutines until they are blocked or sleeping and
+// returns without waiting. It is meant for hosts that cannot block and instead
+// drive the scheduler from their own event loop, for example for modules
+// without a main function that are only called through exported functions.
+// The return value is the time (in milliseconds, on the clock used by
+// runtime.ticks) at which tinygo_poll must be called again to wake up sleeping
+// goroutines, or -1 if no goroutine is sleeping. It must also be called again
+// after calling an exported function that may have started or unblocked a
+// goroutine.
+//go:export tinygo_poll
+func tinygo_poll() timeUnit {
+	deadline, sleeping := schedulerPoll()
+	if !sleeping {
+		return -1
+	}
+	return deadline
+}
+
+// tinygo_gc runs a garbage collection cycle. Hosts that drive the program
+// with tinygo_poll can call it when the program is idle (after tinygo_poll
+// returned), when no Go code is on the stack: a collection is cheapest then,
+// and it doesn't happen later in the middle of handling an event.
+//go:export tinygo_gc
+func tinygo_gc() {
+	GC()
+}
 
 // This function is called by the scheduler.
 // Schedule a call to runtime.scheduler, do not actually sleep.
@@ -68,11 +89,3 @@ func ticks() timeUnit
 func abort() {
 	trap()
 }
-
-//go:export memset
-func memset(ptr unsafe.Pointer, c byte, size uintptr) unsafe.Pointer {
-	for i := uintptr(0); i < size; i++ {
-		*(*byte)(unsafe.Pointer(uintptr(ptr) + i)) = c
-	}
-	return ptr
-}
@@ -0,0 +1,79 @@
+// +build wasm,wasi
+
+package runtime
+
+// This file implements the runtime for WebAssembly modules that run on a WASI
+// host, like wasmtime. Unlike in the browser, the scheduler doesn't return to
+// the host when all goroutines are sleeping: it waits in poll_oneoff instead,
+// together with the goroutines that wait for I/O (see iowait_wasi.go).
+
+import (
+	"unsafe"
+)
+
+type timeUnit int64 // time in nanoseconds
+
+const tickMicros = 1
+
+// Clock ID of the monotonic clock.
+const wasiClockMonotonic = 1
+
+//go:wasm-module wasi_unstable
+//go:export fd_write
+func fd_write(fd uint32, iovs *wasiIOVec, iovsLen uint32, nwritten *uint32) uint16
+
+//go:wasm-module wasi_unstable
+//go:export fd_read
+func fd_read(fd uint32, iovs *wasiIOVec, iovsLen uint32, nread *uint32) uint16
+
+//go:wasm-module wasi_unstable
+//go:export clock_time_get
+func clock_time_get(clockID uint32, precision uint64, time *uint64) uint16
+
+//go:wasm-module wasi_unstable
+//go:export poll_oneoff
+func poll_oneoff(in *wasiSubscription, out *wasiEvent, nsubscriptions uint32, nevents *uint32) uint16
+
+//go:wasm-module wasi_unstable
+//go:export proc_exit
+func proc_exit(code uint32)
+
+// wasiIOVec is a buffer passed to fd_read and fd_write.
+type wasiIOVec struct {
+	buf    unsafe.Pointer
+	bufLen uintptr
+}
+
+//go:export _start
+func _start() {
+	initAll()
+	callMain()
+}
+
+func putchar(c byte) {
+	iov := wasiIOVec{unsafe.Pointer(&c), 1}
+	var nwritten uint32
+	fd_write(1, &iov, 1, &nwritten)
+}
+
+// sleepTicks sleeps for the given time. Goroutines that wait for I/O are woken
+// up in the meantime when they can continue, see pollIO.
+func sleepTicks(d timeUnit) {
+	pollIO(d, true)
+}
+
+func ticks() timeUnit {
+	var t uint64
+	clock_time_get(wasiClockMonotonic, 1, &t)
+	return timeUnit(t)
+}
+
+// Abort executes the wasm 'unreachable' instruction.
+func abort() {
+	trap()
+}
+
+//go:linkname syscall_Exit syscall.Exit
+func syscall_Exit(code int) {
+	proc_exit(uint32(code))
+}
@@ -1,3 +1,5 @@
+// +build scheduler.coroutines
+
 package runtime
 
 // This file implements the Go scheduler using coroutines.
@@ -19,8 +21,14 @@ package runtime
 //
 // For more background on coroutines in LLVM:
 // https://llvm.org/docs/Coroutines.html
+//
+// The scheduler is selected with the scheduler.* build tag. Every scheduler
+// must provide the functions that are called by the compiler and by the rest
+// of the runtime: makeGoroutine, getCoroutine, sleepTask, activateTask,
+// setTaskPromisePtr, getTaskPromisePtr, getTaskPromiseData and scheduler.
 
 import (
+	"runtime/internal/config"
 	"unsafe"
 )
 
@@ -73,27 +81,11 @@ var (
 	sleepQueueBaseTime timeUnit
 )
 
-// Simple logging, for debugging.
-func scheduleLog(msg string) {
-	if schedulerDebug {
-		println(msg)
-	}
-}
-
-// Simple logging with a task pointer, for debugging.
-func scheduleLogTask(msg string, t *coroutine) {
-	if schedulerDebug {
-		println(msg, t)
-	}
-}
-
 // Set the task to sleep for a given time.
 //
 // This is a compiler intrinsic.
 func sleepTask(caller *coroutine, duration int64) {
-	if schedulerDebug {
-		println("  set sleep:", caller, uint(duration/tickMicros))
-	}
+	traceTaskSleep(uintptr(unsafe.Pointer(caller)), duration)
 	promise := caller.promise()
 	promise.data = uint(duration / tickMicros) // TODO: longer durations
 	addSleepTask(caller)
@@ -107,10 +99,132 @@ func activateTask(task *coroutine) {
 	if task == nil {
 		return
 	}
-	scheduleLogTask("  set runnable:", task)
+	if task == &mainParentTask {
+		// main.main returned.
+		mainExited = true
+		return
+	}
 	runqueuePushBack(task)
 }
 
+// mainParentTask is passed by the compiler as the parent coroutine of
+// main.main. It is not a real coroutine: main.main reactivates it when it
+// returns, which marks the main goroutine as exited.
+var mainParentTask coroutine
+
+// mainExited is set once main.main has returned.
+var mainExited bool
+
+// Reasons why a goroutine can be blocked forever, as reported on a deadlock.
+const (
+	blockedChanSend uint8 = iota
+	blockedChanRecv
+	blockedChanSendNil
+	blockedChanRecvNil
+	blockedSelectNoCases
+	numBlockedReasons
+)
+
+// blockedTasks is the number of goroutines that are blocked, by reason.
+var blockedTasks [numBlockedReasons]uint32
+
+// maxBlockedTasks is the number of blocked goroutines that are remembered, to
+// list them on a deadlock. Goroutines beyond that are only counted.
+const maxBlockedTasks = 8
+
+// blockedTaskList contains the goroutines that are blocked, and why. Free
+// entries have a nil task.
+var blockedTaskList [maxBlockedTasks]struct {
+	task   *coroutine
+	reason uint8
+}
+
+// taskBlocked records that a goroutine blocks for the given reason.
+func taskBlocked(task *coroutine, reason uint8) {
+	// A goroutine can be unblocked from an interrupt (see capi.go).
+	mask := disableInterrupts()
+	blockedTasks[reason]++
+	for i := range blockedTaskList {
+		if blockedTaskList[i].task == nil {
+			blockedTaskList[i].task = task
+			blockedTaskList[i].reason = reason
+			break
+		}
+	}
+	restoreInterrupts(mask)
+}
+
+// taskUnblocked records that a goroutine that was blocked for the given
+// reason can run again.
+func taskUnblocked(task *coroutine, reason uint8) {
+	mask := disableInterrupts()
+	blockedTasks[reason]--
+	for i := range blockedTaskList {
+		if blockedTaskList[i].task == task {
+			blockedTaskList[i].task = nil
+			break
+		}
+	}
+	restoreInterrupts(mask)
+}
+
+// selectNoCases is called by a goroutine that blocks forever in a select
+// statement without cases.
+//
+// This is a compiler intrinsic.
+func selectNoCases(task *coroutine) {
+	taskBlocked(task, blockedSelectNoCases)
+}
+
+// deadlock is called by the scheduler when main.main hasn't returned yet but
+// no goroutine can run or will wake up from sleep anymore: all goroutines are
+// blocked forever. Like the Go runtime, it reports this as a fatal error with
+// a list of goroutines and the operation they are blocked on. There are no
+// stack traces, so a goroutine is identified by the coroutine the scheduler
+// would resume (the innermost blocking function, see TraceHooks).
+func deadlock() {
+	printstring("fatal error: all goroutines are asleep - deadlock!\n\n")
+	listed := uint32(0)
+	for _, blocked := range blockedTaskList {
+		if blocked.task == nil {
+			continue
+		}
+		listed++
+		printstring("goroutine ")
+		printptr(uintptr(unsafe.Pointer(blocked.task)))
+		printstring(" [")
+		printBlockedReason(blocked.reason)
+		printstring("]\n")
+	}
+	total := uint32(0)
+	for _, n := range blockedTasks {
+		total += n
+	}
+	if total > listed {
+		printstring("...additional goroutines: ")
+		printuint32(total - listed)
+		printnl()
+	}
+	abort()
+}
+
+// printBlockedReason prints the operation a goroutine is blocked on, like the
+// Go runtime does in a goroutine header.
+func printBlockedReason(reason uint8) {
+	switch reason {
+	case blockedChanSend:
+		printstring("chan send")
+	case blockedChanRecv:
+		printstring("chan receive")
+	case blockedChanSendNil:
+		printstring("chan send (nil chan)")
+	case blockedChanRecvNil:
+		printstring("chan receive (nil chan)")
+	case blockedSelectNoCases:
+		printstring("select (no cases)")
+	}
+}
+
 // getTaskPromisePtr is a helper function to set the current .ptr field of a
 // coroutine promise.
 func setTaskPromisePtr(task *coroutine, value unsafe.Pointer) {
@@ -136,7 +250,7 @@ func getTaskPromiseData(task *coroutine) uint {
 // done.
 func runqueuePushBack(t *coroutine) {
 	if t.done() {
-		scheduleLogTask("  destroy task:", t)
+		traceTaskDone(uintptr(unsafe.Pointer(t)))
 		t.destroy()
 		return
 	}
@@ -145,27 +259,29 @@ func runqueuePushBack(t *coroutine) {
 			panic("runtime: runqueuePushBack: expected next task to be nil")
 		}
 	}
+	traceTaskReady(uintptr(unsafe.Pointer(t)))
+	// Interrupt handlers can add tasks to the run queue too (see capi.go), so
+	// they must not run while it is modified.
+	mask := disableInterrupts()
 	if runqueueBack == nil { // empty runqueue
-		scheduleLogTask("  add to runqueue front:", t)
 		runqueueBack = t
 		runqueueFront = t
 	} else {
-		scheduleLogTask("  add to runqueue back:", t)
 		lastTaskPromise := runqueueBack.promise()
 		lastTaskPromise.next = t
 		runqueueBack = t
 	}
+	restoreInterrupts(mask)
 }
 
 // Get a task from the front of the run queue. Returns nil if there is none.
 func runqueuePopFront() *coroutine {
+	mask := disableInterrupts()
 	t := runqueueFront
 	if t == nil {
+		restoreInterrupts(mask)
 		return nil
 	}
-	if schedulerDebug {
-		println("    runqueuePopFront:", t)
-	}
 	promise := t.promise()
 	runqueueFront = promise.next
 	if runqueueFront == nil {
@@ -173,6 +289,7 @@ func runqueuePopFront() *coroutine {
 		runqueueBack = nil
 	}
 	promise.next = nil
+	restoreInterrupts(mask)
 	return t
 }
 
@@ -183,9 +300,8 @@ func addSleepTask(t *coroutine) {
 			panic("runtime: addSleepTask: expected next task to be nil")
 		}
 	}
-	now := ticks()
+	now := clockTicks()
 	if sleepQueue == nil {
-		scheduleLog("  -> sleep new queue")
 		// Create new linked list for the sleep queue.
 		sleepQueue = t
 		sleepQueueBaseTime = now
@@ -197,7 +313,6 @@ func addSleepTask(t *coroutine) {
 
 	// Insert at front of sleep queue.
 	if promise.data < sleepQueue.promise().data {
-		scheduleLog("  -> sleep at start")
 		sleepQueue.promise().data -= promise.data
 		promise.next = sleepQueue
 		sleepQueue = t
@@ -210,10 +325,8 @@ func addSleepTask(t *coroutine) {
 		promise.data -= queueIndex.promise().data
 		if queueIndex.promise().next == nil || queueIndex.promise().data > promise.data {
 			if queueIndex.promise().next == nil {
-				scheduleLog("  -> sleep at end")
 				promise.next = nil
 			} else {
-				scheduleLog("  -> sleep in middle")
 				promise.next = queueIndex.promise().next
 				promise.next.promise().data -= promise.data
 			}
@@ -224,41 +337,105 @@ func addSleepTask(t *coroutine) {
 	}
 }
 
+// wakeupLatencyMax is the longest time by which a sleeping task was woken up
+// later than it should have been, see runtime/metrics.
+var wakeupLatencyMax timeUnit
+
+// wakeSleepingTask adds a task that is done sleeping to the end of the runqueue
+// so it will be executed soon.
+func wakeSleepingTask(now timeUnit) {
+	if sleepQueue != nil && now-sleepQueueBaseTime >= timeUnit(sleepQueue.promise().data) {
+		t := sleepQueue
+		promise := t.promise()
+		sleepQueueBaseTime += timeUnit(promise.data)
+		if late := now - sleepQueueBaseTime; late > wakeupLatencyMax {
+			wakeupLatencyMax = late
+		}
+		sleepQueue = promise.next
+		promise.next = nil
+		runqueuePushBack(t)
+	}
+}
+
+// schedStats returns the number of goroutines and the longest wakeup latency of
+// a sleeping goroutine, for runtime/metrics. A goroutine that isn't running is
+// either in the run queue, in the sleep queue or blocked, so they can be
+// counted without keeping track of them separately.
+func schedStats() (goroutines uint32, wakeupLatency timeUnit) {
+	goroutines = 1 // the running goroutine
+	for t := runqueueFront; t != nil; t = t.promise().next {
+		goroutines++
+	}
+	for t := sleepQueue; t != nil; t = t.promise().next {
+		goroutines++
+	}
+	for _, n := range blockedTasks {
+		goroutines += n
+	}
+	return goroutines, wakeupLatencyMax
+}
+
+// idleHook is called instead of sleeping when set, see SetIdleHook.
+var idleHook func()
+
+// SetIdleHook sets a function that is called by the scheduler when all
+// goroutines are sleeping, instead of sleeping itself. This is useful when
+// TinyGo runs on top of a host system such as an RTOS: the hook can yield the
+// CPU to other tasks (for example with vTaskDelay) or wait for an interrupt.
+// The hook should return after a short while, as sleeping goroutines are only
+// woken up after it returns. It is called from the scheduler, so it must not
+// block on channels or call time.Sleep. Pass nil to remove the hook.
+func SetIdleHook(hook func()) {
+	idleHook = hook
+}
+
 // Run the scheduler until all tasks have finished.
 func scheduler() {
 	// Main scheduler loop.
 	for {
-		scheduleLog("\n  schedule")
-		now := ticks()
-
-		// Add tasks that are done sleeping to the end of the runqueue so they
-		// will be executed soon.
-		if sleepQueue != nil && now-sleepQueueBaseTime >= timeUnit(sleepQueue.promise().data) {
-			t := sleepQueue
-			scheduleLogTask("  awake:", t)
-			promise := t.promise()
-			sleepQueueBaseTime += timeUnit(promise.data)
-			sleepQueue = promise.next
-			promise.next = nil
-			runqueuePushBack(t)
-		}
+		now := clockTicks()
+
+		wakeSleepingTask(now)
 
 		t := runqueuePopFront()
 		if t == nil {
+			traceSchedulerIdle()
+			if sleepQueue == nil && ioWaiting() {
+				// Only goroutines that wait for I/O are left. Wait until one
+				// of them can continue.
+				pollIO(0, false)
+				continue
+			}
+			if sleepQueue == nil && !mainExited && interruptWakeup && capiUsed {
+				// All goroutines are blocked, but an interrupt handler may
+				// still make one of them runnable through the C API.
+				waitForInterrupt()
+				continue
+			}
 			if sleepQueue == nil {
-				// No more tasks to execute.
-				// It would be nice if we could detect deadlocks here, because
-				// there might still be functions waiting on each other in a
-				// deadlock.
-				scheduleLog("  no tasks left!")
+				// No more tasks to execute. If main.main is still running, it
+				// is blocked forever, and so are all other goroutines. With
+				// the async scheduler, an event from the host may still wake
+				// up a goroutine.
+				if !mainExited && !config.AsyncScheduler {
+					deadlock()
+				}
 				return
 			}
 			timeLeft := timeUnit(sleepQueue.promise().data) - (now - sleepQueueBaseTime)
-			if schedulerDebug {
-				println("  sleeping...", sleepQueue, uint(timeLeft))
+			if virtualClock {
+				// Skip ahead to the time the next goroutine wakes up.
+				virtualTicks += timeLeft
+				continue
+			}
+			if idleHook != nil {
+				// Let the host (for example an RTOS) decide how to wait. The
+				// timers are checked again after the hook returns.
+				idleHook()
+				continue
 			}
 			sleepTicks(timeUnit(timeLeft))
-			if asyncScheduler {
+			if config.AsyncScheduler {
 				// The sleepTicks function above only sets a timeout at which
 				// point the scheduler will be called again. It does not really
 				// sleep.
@@ -268,8 +445,48 @@ func scheduler() {
 		}
 
 		// Run the given task.
-		scheduleLog("  <- runqueuePopFront")
-		scheduleLogTask("  run:", t)
-		t.resume()
+		runTask(t)
+	}
+}
+
+// schedulerPoll runs all goroutines that are runnable, including sleeping
+// goroutines whose timeout has passed, until they are all blocked or sleeping.
+// Unlike scheduler it never waits. It returns the time at which the first
+// sleeping goroutine should be woken up, or false if no goroutine is sleeping.
+func schedulerPoll() (timeUnit, bool) {
+	for {
+		wakeSleepingTask(clockTicks())
+
+		t := runqueuePopFront()
+		if t == nil {
+			if sleepQueue == nil {
+				return 0, false
+			}
+			return sleepQueueBaseTime + timeUnit(sleepQueue.promise().data), true
+		}
+
+		runTask(t)
+	}
+}
+
+// runTask resumes the given task until it blocks, sleeps or finishes, and calls
+// the trace hooks around it. A task that finished is destroyed right away,
+// which returns its frame to the heap (or the frame pool, see task_pool.go).
+// Only coroutines that returned after being suspended at least once end up
+// here, the frames of coroutines that returned without blocking are left to
+// the garbage collector.
+func runTask(t *coroutine) {
+	if traceHooks.TaskSwitchedIn != nil {
+		traceHooks.TaskSwitchedIn(uintptr(unsafe.Pointer(t)))
+	}
+	depth := profileDepth
+	t.resume()
+	profileDepth = depth // see profile.go
+	if traceHooks.TaskSwitchedOut != nil {
+		traceHooks.TaskSwitchedOut(uintptr(unsafe.Pointer(t)))
+	}
+	if t.done() {
+		traceTaskDone(uintptr(unsafe.Pointer(t)))
+		t.destroy()
 	}
 }
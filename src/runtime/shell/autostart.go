@@ -0,0 +1,12 @@
+// +build shell
+
+package shell
+
+import (
+	"machine"
+)
+
+// Start the shell on the default console when built with -tags=shell.
+func init() {
+	go Serve(machine.UART0)
+}
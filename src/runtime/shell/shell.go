@@ -0,0 +1,221 @@
+// Package shell implements a small command shell that helps with bringing up
+// new hardware without a debugger. It can list goroutines, show memory
+// statistics, read and write memory and change pins:
+//
+//     > help
+//     tasks                 list goroutines
+//     mem                   show heap and goroutine memory
+//     peek addr [count]     read 32-bit words from memory
+//     poke addr value       write a 32-bit word to memory
+//     pin n [high|low|toggle]
+//                           read or set a pin
+//     gc                    run a garbage collection
+//
+// Import the package for its side effect and build with -tags=shell to start
+// the shell on the default console, machine.UART0:
+//
+//     import _ "runtime/shell"
+//
+// Without the shell build tag the import does nothing, so it can be left in
+// the program. Serve runs a shell on another transport, for example a second
+// UART. Several shells may run at the same time, each in its own goroutine.
+//
+// To track goroutines, the shell installs trace hooks (see
+// runtime.SetTraceHooks) when it starts, which replaces any hooks installed by
+// the program.
+package shell
+
+import (
+	"io"
+	"machine"
+	"runtime"
+	"runtime/debug"
+	"runtime/volatile"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// Implemented in the runtime.
+func readHeapStats() (size, inUse uintptr)
+
+// Serve runs a shell on the given transport until reading from it fails.
+func Serve(rw io.ReadWriter) error {
+	startTracking()
+	s := &shell{rw: rw}
+	for {
+		s.print("> ")
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		s.run(strings.Fields(line))
+	}
+}
+
+type shell struct {
+	rw   io.ReadWriter
+	line [80]byte
+}
+
+// readLine reads a line and echoes it back, handling backspace.
+func (s *shell) readLine() (string, error) {
+	n := 0
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '\r' || c == '\n':
+			s.print("\r\n")
+			return string(s.line[:n]), nil
+		case c == '\b' || c == 0x7f:
+			if n > 0 {
+				n--
+				s.print("\b \b")
+			}
+		case c >= ' ' && n < len(s.line):
+			s.line[n] = c
+			n++
+			s.rw.Write(s.line[n-1 : n])
+		}
+	}
+}
+
+// readByte waits for the next byte. UARTs return no data instead of blocking
+// when their buffer is empty, so poll them while letting other goroutines run.
+func (s *shell) readByte() (byte, error) {
+	var buf [1]byte
+	for {
+		n, err := s.rw.Read(buf[:])
+		if n == 1 {
+			return buf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *shell) print(msg string) {
+	io.WriteString(s.rw, msg)
+}
+
+func (s *shell) println(fields ...string) {
+	s.print(strings.Join(fields, " ") + "\r\n")
+}
+
+// run executes a single command.
+func (s *shell) run(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	switch args[0] {
+	case "help":
+		s.println("tasks                 list goroutines")
+		s.println("mem                   show heap and goroutine memory")
+		s.println("peek addr [count]     read 32-bit words from memory")
+		s.println("poke addr value       write a 32-bit word to memory")
+		s.println("pin n [high|low|toggle]")
+		s.println("                      read or set a pin")
+		s.println("gc                    run a garbage collection")
+	case "tasks":
+		s.listTasks()
+	case "mem":
+		size, inUse := readHeapStats()
+		s.println("heap:", formatUint(uint64(inUse)), "of", formatUint(uint64(size)), "bytes in use")
+		var stats debug.TaskStats
+		debug.ReadTaskStats(&stats)
+		s.println("goroutine frames:", formatUint(uint64(stats.InUse)), "bytes in use,", formatUint(uint64(stats.Peak)), "peak,", formatUint(uint64(stats.Pooled)), "pooled")
+	case "peek":
+		if len(args) < 2 || len(args) > 3 {
+			s.println("usage: peek addr [count]")
+			return
+		}
+		addr, ok := s.parseNumber(args[1])
+		count := uint64(1)
+		if ok && len(args) == 3 {
+			count, ok = s.parseNumber(args[2])
+		}
+		if !ok {
+			return
+		}
+		for i := uint64(0); i < count; i++ {
+			ptr := uintptr(addr) &^ 3
+			value := volatile.LoadUint32((*uint32)(unsafe.Pointer(ptr + uintptr(i*4))))
+			s.println(formatHex(uint64(ptr)+i*4)+":", formatHex(uint64(value)))
+		}
+	case "poke":
+		if len(args) != 3 {
+			s.println("usage: poke addr value")
+			return
+		}
+		addr, ok := s.parseNumber(args[1])
+		if !ok {
+			return
+		}
+		value, ok := s.parseNumber(args[2])
+		if !ok {
+			return
+		}
+		// A volatile store, so that writing a peripheral register works.
+		volatile.StoreUint32((*uint32)(unsafe.Pointer(uintptr(addr)&^3)), uint32(value))
+	case "pin":
+		if len(args) < 2 || len(args) > 3 {
+			s.println("usage: pin n [high|low|toggle]")
+			return
+		}
+		n, ok := s.parseNumber(args[1])
+		if !ok {
+			return
+		}
+		pin := machine.Pin(n)
+		if len(args) == 3 {
+			var high bool
+			switch args[2] {
+			case "high":
+				high = true
+			case "low":
+				high = false
+			case "toggle":
+				high = !pin.Get()
+			default:
+				s.println("usage: pin n [high|low|toggle]")
+				return
+			}
+			pin.Configure(machine.PinConfig{Mode: machine.PinOutput})
+			pin.Set(high)
+		}
+		if pin.Get() {
+			s.println("pin", args[1], "is high")
+		} else {
+			s.println("pin", args[1], "is low")
+		}
+	case "gc":
+		runtime.GC()
+	default:
+		s.println("unknown command:", args[0])
+	}
+}
+
+// parseNumber parses a decimal or hexadecimal (0x prefix) number, printing an
+// error if it is invalid.
+func (s *shell) parseNumber(arg string) (uint64, bool) {
+	n, err := strconv.ParseUint(arg, 0, 32)
+	if err != nil {
+		s.println("invalid number:", arg)
+		return 0, false
+	}
+	return n, true
+}
+
+func formatUint(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func formatHex(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
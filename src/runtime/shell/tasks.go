@@ -0,0 +1,111 @@
+package shell
+
+// This file keeps track of goroutines with the trace hooks of the runtime.
+// The runtime identifies a task by the coroutine that the scheduler resumes,
+// so a goroutine that is blocked in a nested function call is listed with the
+// coroutine of the innermost blocking function.
+
+import (
+	"runtime"
+)
+
+const (
+	taskRunning = iota + 1
+	taskReady
+	taskSleeping
+	taskBlocked
+)
+
+var taskStateNames = [...]string{
+	taskRunning:  "running",
+	taskReady:    "ready",
+	taskSleeping: "sleeping",
+	taskBlocked:  "blocked",
+}
+
+// maxTasks is the number of tasks that can be listed. Tasks beyond that are
+// only counted.
+const maxTasks = 32
+
+var (
+	tracking bool
+	tasks    [maxTasks]struct {
+		id    uintptr
+		state uint8
+	}
+	tasksCreated  uint32
+	tasksFinished uint32
+)
+
+// startTracking installs the trace hooks, unless another shell already did.
+func startTracking() {
+	if tracking {
+		return
+	}
+	tracking = true
+	runtime.SetTraceHooks(runtime.TraceHooks{
+		TaskCreated: func(fn uintptr) {
+			tasksCreated++
+		},
+		TaskSwitchedIn: func(task uintptr) {
+			setTaskState(task, taskRunning)
+		},
+		TaskSwitchedOut: func(task uintptr) {
+			// A task that is switched out without becoming ready or going to
+			// sleep waits for something, like a channel operation.
+			if getTaskState(task) == taskRunning {
+				setTaskState(task, taskBlocked)
+			}
+		},
+		TaskReady: func(task uintptr) {
+			setTaskState(task, taskReady)
+		},
+		TaskSleep: func(task uintptr, duration int64) {
+			setTaskState(task, taskSleeping)
+		},
+		TaskDone: func(task uintptr) {
+			tasksFinished++
+			setTaskState(task, 0)
+		},
+	})
+}
+
+func getTaskState(id uintptr) uint8 {
+	for i := range tasks {
+		if tasks[i].id == id {
+			return tasks[i].state
+		}
+	}
+	return 0
+}
+
+// setTaskState updates the state of a task, adding it to the list if needed.
+// A state of 0 removes it.
+func setTaskState(id uintptr, state uint8) {
+	free := -1
+	for i := range tasks {
+		if tasks[i].id == id {
+			tasks[i].state = state
+			if state == 0 {
+				tasks[i].id = 0
+			}
+			return
+		}
+		if tasks[i].id == 0 && free < 0 {
+			free = i
+		}
+	}
+	if state != 0 && free >= 0 {
+		tasks[free].id = id
+		tasks[free].state = state
+	}
+}
+
+func (s *shell) listTasks() {
+	for _, task := range tasks {
+		if task.id != 0 {
+			s.println("task", formatHex(uint64(task.id))+":", taskStateNames[task.state])
+		}
+	}
+	s.println(formatUint(uint64(tasksCreated)), "goroutines started,", formatUint(uint64(tasksFinished)), "finished since the shell started")
+}
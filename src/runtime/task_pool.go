@@ -0,0 +1,131 @@
+// +build scheduler.coroutines
+
+package runtime
+
+// This file implements a pool for coroutine frames. A coroutine frame holds
+// the local state of a blocking function while it is suspended, so every
+// goroutine (and every blocking call inside it) allocates one. With the async
+// scheduler on WebAssembly, where goroutines come and go all the time, these
+// allocations quickly fragment the heap. Frames of finished coroutines are
+// therefore kept in a pool with a few size classes and reused for the next
+// coroutine of the same size class.
+//
+// On other targets, frames are allocated from and returned to the heap
+// directly, to not spend any memory on the pool.
+
+import (
+	"runtime/internal/config"
+	"unsafe"
+)
+
+// Size classes of the frame pool: powers of two from the smallest to the
+// largest class. Larger frames are not pooled.
+const (
+	taskPoolMinShift = 5  // 32 bytes
+	taskPoolMaxShift = 12 // 4kB
+	taskPoolClasses  = taskPoolMaxShift - taskPoolMinShift + 1
+)
+
+// taskFrameHeader precedes every frame and records its size (rounded up to
+// the size class), so that freeTask knows where to put the frame back.
+type taskFrameHeader struct {
+	size uintptr
+}
+
+// taskFrameFree is a frame in the pool, linked to the next free frame of the
+// same size class.
+type taskFrameFree struct {
+	next *taskFrameFree
+}
+
+var (
+	// Free lists of the frame pool, per size class.
+	taskPool [taskPoolClasses]*taskFrameFree
+
+	// Statistics, see runtime/debug.ReadTaskStats.
+	taskFrameInUse  uintptr // bytes in frames used by running coroutines
+	taskFramePeak   uintptr // highest value of taskFrameInUse
+	taskFramePooled uintptr // bytes in frames that are kept in the pool
+)
+
+// taskFrameHeaderSize is the size of the header before a pooled frame, rounded
+// up to keep the frame itself aligned.
+func taskFrameHeaderSize() uintptr {
+	return align(unsafe.Sizeof(taskFrameHeader{}))
+}
+
+// taskSizeClass returns the size class for a frame of the given size, or
+// taskPoolClasses if the frame is too big to be pooled.
+func taskSizeClass(size uintptr) uintptr {
+	class := uintptr(0)
+	for size > 1<<(class+taskPoolMinShift) {
+		class++
+		if class == taskPoolClasses {
+			break
+		}
+	}
+	return class
+}
+
+// allocTask allocates a coroutine frame. It is called by the compiler instead
+// of alloc in the coroutine setup of every blocking function.
+func allocTask(size uintptr) unsafe.Pointer {
+	if !config.AsyncScheduler {
+		return alloc(size)
+	}
+	class := taskSizeClass(size)
+	if class < taskPoolClasses {
+		size = 1 << (class + taskPoolMinShift)
+	}
+	taskFrameInUse += size
+	if taskFrameInUse > taskFramePeak {
+		taskFramePeak = taskFrameInUse
+	}
+	headerSize := taskFrameHeaderSize()
+	if class < taskPoolClasses && taskPool[class] != nil {
+		// Reuse a frame from the pool. Frames are cleared when they are put
+		// in the pool, only the link to the next frame needs to be cleared.
+		frame := taskPool[class]
+		taskPool[class] = frame.next
+		frame.next = nil
+		taskFramePooled -= size
+		return unsafe.Pointer(frame)
+	}
+	header := (*taskFrameHeader)(alloc(headerSize + size))
+	header.size = size
+	return unsafe.Pointer(uintptr(unsafe.Pointer(header)) + headerSize)
+}
+
+// freeTask releases a coroutine frame allocated with allocTask. It is called
+// by the compiler in the cleanup of a coroutine, when the coroutine returns.
+func freeTask(ptr unsafe.Pointer) {
+	if !config.AsyncScheduler {
+		free(ptr)
+		return
+	}
+	if ptr == nil {
+		return
+	}
+	header := (*taskFrameHeader)(unsafe.Pointer(uintptr(ptr) - taskFrameHeaderSize()))
+	size := header.size
+	taskFrameInUse -= size
+	class := taskSizeClass(size)
+	if class == taskPoolClasses {
+		// Too big to be pooled.
+		free(unsafe.Pointer(header))
+		return
+	}
+	taskFramePooled += size
+
+	// Clear the frame, so that pointers in it don't keep other objects alive
+	// while it is in the pool.
+	memzero(ptr, size)
+	frame := (*taskFrameFree)(ptr)
+	frame.next = taskPool[class]
+	taskPool[class] = frame
+}
+
+//go:linkname readTaskStats runtime/debug.readTaskStats
+func readTaskStats() (inUse, peak, pooled uintptr) {
+	return taskFrameInUse, taskFramePeak, taskFramePooled
+}
@@ -0,0 +1,156 @@
+package runtime
+
+// This file implements hooks for trace recorders such as Percepio Tracealyzer
+// or SEGGER SystemView, similar to the trace macros of FreeRTOS. The compiler
+// and the scheduler call the trace* functions below at the relevant points.
+// When SetTraceHooks is never called (and interrupt timestamps are not
+// enabled), the optimizer removes these calls.
+
+// TraceHooks contains the functions that are called on scheduler and interrupt
+// events. Any of them may be nil.
+//
+// A task is identified by the coroutine that is resumed by the scheduler. Note
+// that a goroutine that is blocked in a nested function call is resumed as the
+// coroutine of the innermost blocking function, so a single goroutine may use
+// different task identifiers over its lifetime.
+//
+// The hooks are called with interrupts enabled (except for the interrupt
+// hooks, which are called from the interrupt itself) and must not block.
+type TraceHooks struct {
+	// TaskCreated is called when a goroutine is started, with the address of
+	// the function that runs in the new goroutine.
+	TaskCreated func(fn uintptr)
+
+	// TaskSwitchedIn is called right before the scheduler resumes a task.
+	TaskSwitchedIn func(task uintptr)
+
+	// TaskSwitchedOut is called when a task returns control to the scheduler,
+	// because it is blocked, sleeping, or finished.
+	TaskSwitchedOut func(task uintptr)
+
+	// TaskReady is called when a task is added to the run queue: when it is
+	// woken up after sleeping, unblocked by a channel operation, or when a
+	// blocking function it called returns.
+	TaskReady func(task uintptr)
+
+	// TaskSleep is called when a task starts sleeping for the given duration
+	// in nanoseconds, as in time.Sleep.
+	TaskSleep func(task uintptr, duration int64)
+
+	// TaskDone is called when a task has finished and its resources are
+	// released.
+	TaskDone func(task uintptr)
+
+	// SchedulerIdle is called when no task is runnable. The scheduler then
+	// sleeps until the next sleeping task must be woken up. When no task is
+	// sleeping, it waits for an interrupt on chips where an interrupt handler
+	// can unblock a task, and otherwise reports a deadlock (or returns, once
+	// main.main has returned).
+	SchedulerIdle func()
+
+	// ISREnter is called at the start of an interrupt handler, with the
+	// address of the handler.
+	ISREnter func(handler uintptr)
+
+	// ISRExit is called when an interrupt handler returns.
+	ISRExit func()
+}
+
+var traceHooks TraceHooks
+
+// SetTraceHooks installs the given trace hooks, replacing all previously
+// installed hooks. Call it early in main (or in an init function) so that no
+// events are missed. Pass an empty TraceHooks value to remove all hooks.
+func SetTraceHooks(hooks TraceHooks) {
+	traceHooks = hooks
+}
+
+// traceTaskCreated is inserted by the compiler before every go statement.
+func traceTaskCreated(fn uintptr) {
+	if traceHooks.TaskCreated != nil {
+		traceHooks.TaskCreated(fn)
+	}
+}
+
+// traceTaskReady is called by the scheduler when a task becomes runnable.
+func traceTaskReady(task uintptr) {
+	if traceHooks.TaskReady != nil {
+		traceHooks.TaskReady(task)
+	}
+}
+
+// traceTaskSleep is called by the scheduler when a task starts sleeping.
+func traceTaskSleep(task uintptr, duration int64) {
+	if traceHooks.TaskSleep != nil {
+		traceHooks.TaskSleep(task, duration)
+	}
+}
+
+// traceTaskDone is called by the scheduler when a task has finished.
+func traceTaskDone(task uintptr) {
+	if traceHooks.TaskDone != nil {
+		traceHooks.TaskDone(task)
+	}
+}
+
+// traceSchedulerIdle is called by the scheduler when no task is runnable.
+func traceSchedulerIdle() {
+	if traceHooks.SchedulerIdle != nil {
+		traceHooks.SchedulerIdle()
+	}
+}
+
+// traceISREnter is inserted by the compiler at the start of every interrupt
+// handler.
+func traceISREnter(handler uintptr) {
+	if interruptTimestampsEnabled {
+		if interruptNesting < maxInterruptNesting {
+			interruptTimestamps[interruptNesting] = readCycleCounter()
+		}
+		interruptNesting++
+	}
+	if traceHooks.ISREnter != nil {
+		traceHooks.ISREnter(handler)
+	}
+}
+
+// traceISRExit is inserted by the compiler before every return of an interrupt
+// handler.
+func traceISRExit() {
+	if traceHooks.ISRExit != nil {
+		traceHooks.ISRExit()
+	}
+	if interruptTimestampsEnabled && interruptNesting > 0 {
+		interruptNesting--
+	}
+}
+
+// Timestamps taken at the start of interrupt handlers, for the
+// runtime/interrupt package. Interrupts with a higher priority can interrupt
+// a running handler, so there is a timestamp for each nesting level.
+const maxInterruptNesting = 8
+
+var (
+	interruptTimestampsEnabled bool
+	interruptNesting           uint8
+	interruptTimestamps        [maxInterruptNesting]uint32
+)
+
+// enableInterruptTimestamps starts recording timestamps at the start of every
+// interrupt handler. It returns false if there is no suitable clock.
+func enableInterruptTimestamps() bool {
+	if !enableCycleCounter() {
+		return false
+	}
+	interruptTimestampsEnabled = true
+	return true
+}
+
+// interruptTimestamp returns the timestamp of the currently running interrupt
+// handler. The second return value is false outside of an interrupt handler.
+func interruptTimestamp() (uint32, bool) {
+	if interruptNesting == 0 || interruptNesting > maxInterruptNesting {
+		return 0, false
+	}
+	return interruptTimestamps[interruptNesting-1], true
+}
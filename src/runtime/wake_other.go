@@ -0,0 +1,8 @@
+// +build !nrf,!atsamd21,!stm32
+
+package runtime
+
+// wakeScheduler ends the current sleep of the scheduler early. It does nothing
+// on targets where sleeping cannot be interrupted.
+func wakeScheduler() {
+}
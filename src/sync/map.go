@@ -0,0 +1,85 @@
+package sync
+
+// Map is a very simple implementation of sync.Map: a list of key/value pairs
+// protected by a mutex. Lookups are a linear search, so it is only suitable
+// for a small number of keys, like the type caches in encoding/json.
+// Interface values are used as keys, so it doesn't need the hashmap to support
+// interface keys.
+type Map struct {
+	lock    Mutex
+	entries []mapEntry
+}
+
+type mapEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// find returns the index of the entry with the given key, or -1 if there is no
+// such entry. The caller must hold the lock.
+func (m *Map) find(key interface{}) int {
+	for i, entry := range m.entries {
+		if entry.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Load returns the value stored in the map for a key, or nil if no value is
+// present. The ok result indicates whether value was found in the map.
+func (m *Map) Load(key interface{}) (value interface{}, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if i := m.find(key); i >= 0 {
+		return m.entries[i].value, true
+	}
+	return nil, false
+}
+
+// Store sets the value for a key.
+func (m *Map) Store(key, value interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if i := m.find(key); i >= 0 {
+		m.entries[i].value = value
+		return
+	}
+	m.entries = append(m.entries, mapEntry{key, value})
+}
+
+// LoadOrStore returns the existing value for the key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value
+// was loaded, false if stored.
+func (m *Map) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if i := m.find(key); i >= 0 {
+		return m.entries[i].value, true
+	}
+	m.entries = append(m.entries, mapEntry{key, value})
+	return value, false
+}
+
+// Delete deletes the value for a key.
+func (m *Map) Delete(key interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if i := m.find(key); i >= 0 {
+		m.entries = append(m.entries[:i], m.entries[i+1:]...)
+	}
+}
+
+// Range calls f sequentially for each key and value present in the map. If f
+// returns false, Range stops the iteration. Unlike the standard library, keys
+// that are deleted by f may cause other keys to be skipped.
+func (m *Map) Range(f func(key, value interface{}) bool) {
+	m.lock.Lock()
+	entries := m.entries
+	m.lock.Unlock()
+	for _, entry := range entries {
+		if !f(entry.key, entry.value) {
+			break
+		}
+	}
+}
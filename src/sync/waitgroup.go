@@ -0,0 +1,28 @@
+package sync
+
+// WaitGroup waits for a collection of goroutines to finish. Like the mutexes in
+// this package, it assumes there is only one thread of operation: Wait cannot
+// block yet, so it panics when the counter is not zero.
+type WaitGroup struct {
+	counter int
+}
+
+// Add adds delta, which may be negative, to the WaitGroup counter.
+func (wg *WaitGroup) Add(delta int) {
+	wg.counter += delta
+	if wg.counter < 0 {
+		panic("sync: negative WaitGroup counter")
+	}
+}
+
+// Done decrements the WaitGroup counter by one.
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+// Wait returns when the WaitGroup counter is zero.
+func (wg *WaitGroup) Wait() {
+	if wg.counter != 0 {
+		panic("todo: block on WaitGroup")
+	}
+}
@@ -1,4 +1,4 @@
-// +build avr cortexm
+// +build avr cortexm wasi
 
 package syscall
 
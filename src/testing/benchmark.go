@@ -0,0 +1,193 @@
+package testing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+	_ "unsafe" // for go:linkname
+)
+
+// benchTime is the minimum time a benchmark is run, like the default of
+// go test -benchtime.
+const benchTime = time.Second
+
+// BenchmarkToCall is a reference to a benchmark that should be called during a
+// test suite run.
+type BenchmarkToCall struct {
+	// Name of the benchmark to call.
+	Name string
+	// Function reference to the benchmark.
+	Func func(*B)
+}
+
+// B is a type passed to benchmark functions to manage benchmark timing and to
+// specify the number of iterations to run.
+//
+// On chips with a cycle counter (like the DWT cycle counter of the Cortex-M3
+// and Cortex-M4), the number of processor cycles per iteration is reported in
+// addition to the time, which is much more precise than the system timer.
+type B struct {
+	name   string
+	output io.Writer
+
+	// flags the benchmark as having failed when non-zero
+	failed int
+
+	N int
+
+	timerOn     bool
+	start       time.Time
+	startCycles uint32
+	duration    time.Duration
+	cycles      uint64
+	bytes       int64
+}
+
+//go:linkname enableCycleCounter runtime.enableCycleCounter
+func enableCycleCounter() bool
+
+//go:linkname readCycleCounter runtime.readCycleCounter
+func readCycleCounter() uint32
+
+// StartTimer starts timing a test. This function is called automatically
+// before a benchmark starts, but it can also be used to resume timing after a
+// call to StopTimer.
+func (b *B) StartTimer() {
+	if !b.timerOn {
+		b.timerOn = true
+		b.start = time.Now()
+		b.startCycles = readCycleCounter()
+	}
+}
+
+// StopTimer stops timing a test. This can be used to pause the timer while
+// performing complex initialization that you don't want to measure.
+func (b *B) StopTimer() {
+	if b.timerOn {
+		// The cycle counter wraps around, which is fine as long as the timer
+		// doesn't run for 2^32 cycles at a time.
+		b.cycles += uint64(readCycleCounter() - b.startCycles)
+		b.duration += time.Since(b.start)
+		b.timerOn = false
+	}
+}
+
+// ResetTimer zeroes the elapsed benchmark time. It does not affect whether the
+// timer is running.
+func (b *B) ResetTimer() {
+	if b.timerOn {
+		b.start = time.Now()
+		b.startCycles = readCycleCounter()
+	}
+	b.duration = 0
+	b.cycles = 0
+}
+
+// SetBytes records the number of bytes processed in a single operation. If
+// this is called, the benchmark will report MB/s.
+func (b *B) SetBytes(n int64) {
+	b.bytes = n
+}
+
+// ReportAllocs is accepted for compatibility, but allocations are not
+// reported.
+func (b *B) ReportAllocs() {
+}
+
+// Error is equivalent to Log followed by Fail
+func (b *B) Error(args ...interface{}) {
+	fmt.Fprintf(b.output, "\t")
+	fmt.Fprintln(b.output, args...)
+	b.Fail()
+}
+
+func (b *B) Fail() {
+	b.failed = 1
+}
+
+// runN runs the benchmark function with b.N set to n.
+func (b *B) runN(fn func(*B), n int) {
+	b.N = n
+	b.duration = 0
+	b.cycles = 0
+	b.timerOn = false
+	b.StartTimer()
+	fn(b)
+	b.StopTimer()
+}
+
+// run runs the benchmark function with an increasing number of iterations,
+// until it took at least benchTime.
+func (b *B) run(fn func(*B)) {
+	n := 1
+	b.runN(fn, n)
+	for b.failed == 0 && b.duration < benchTime && n < 1e9 {
+		// Predict the number of iterations needed, with some margin, but
+		// don't grow too fast in case the first runs were unusually quick.
+		last := n
+		if ns := b.duration.Nanoseconds(); ns > 0 {
+			n = int(int64(benchTime) * int64(n) / ns * 6 / 5)
+		} else {
+			n *= 100
+		}
+		if n > last*100 {
+			n = last * 100
+		}
+		if n <= last {
+			n = last + 1
+		}
+		if n > 1e9 {
+			n = 1e9
+		}
+		b.runN(fn, n)
+	}
+}
+
+// result returns the results of the benchmark in the format of go test -bench.
+func (b *B) result(cycles bool) string {
+	result := fmt.Sprintf("%s\t%8d\t%10d ns/op", b.name, b.N, b.duration.Nanoseconds()/int64(b.N))
+	if cycles {
+		result += fmt.Sprintf("\t%10d cycles/op", b.cycles/uint64(b.N))
+	}
+	if b.bytes > 0 && b.duration > 0 {
+		mbPerSec := float64(b.bytes) * float64(b.N) / 1e6 / b.duration.Seconds()
+		result += fmt.Sprintf("\t%8.2f MB/s", mbPerSec)
+	}
+	return result
+}
+
+// runBenchmarks runs all benchmarks of the test suite and prints the results.
+// It returns the number of benchmarks that failed.
+func (m *M) runBenchmarks() int {
+	if len(m.Benchmarks) == 0 {
+		return 0
+	}
+	cycles := enableCycleCounter()
+	failures := 0
+	for _, benchmark := range m.Benchmarks {
+		b := &B{
+			name:   benchmark.Name,
+			output: &bytes.Buffer{},
+		}
+		b.run(benchmark.Func)
+		output := b.output.(*bytes.Buffer).String()
+
+		result := b.result(cycles)
+		if b.failed != 0 {
+			result = "--- FAIL: " + b.name
+		}
+		switch m.Format {
+		case "tap":
+			// Benchmarks are not TAP tests, so report them as comments.
+			fmt.Println("# " + result)
+		case "json":
+			fmt.Printf("{\"Action\":\"output\",\"Test\":%s,\"Output\":%s}\n", quoteJSON(b.name), quoteJSON(result+"\n"+output))
+		default:
+			fmt.Println(result)
+			fmt.Print(output)
+		}
+		failures += b.failed
+	}
+	return failures
+}
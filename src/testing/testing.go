@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // T is a test helper.
@@ -28,37 +31,161 @@ type TestToCall struct {
 type M struct {
 	// tests is a list of the test names to execute
 	Tests []TestToCall
+
+	// Benchmarks is a list of the benchmarks to run after the tests passed.
+	Benchmarks []BenchmarkToCall
+
+	// Format is the output format of the test results: "tap" for the Test
+	// Anything Protocol (version 13), "json" for a stream of JSON objects like
+	// the ones printed by go test -json, or anything else for the usual go test
+	// output. The structured formats are meant for test binaries running on
+	// hardware, where the output is read back over a serial port or
+	// semihosting and parsed by a CI system.
+	Format string
 }
 
 // Run the test suite.
 func (m *M) Run() int {
+	if m.Format == "tap" {
+		fmt.Println("TAP version 13")
+		fmt.Printf("1..%d\n", len(m.Tests))
+	}
+
 	failures := 0
-	for _, test := range m.Tests {
+	for i, test := range m.Tests {
 		t := &T{
 			name:   test.Name,
 			output: &bytes.Buffer{},
 		}
 
-		fmt.Printf("=== RUN   %s\n", test.Name)
+		if m.Format == "json" {
+			fmt.Printf("{\"Action\":\"run\",\"Test\":%s}\n", quoteJSON(test.Name))
+		} else if m.Format != "tap" {
+			fmt.Printf("=== RUN   %s\n", test.Name)
+		}
+		start := time.Now()
 		test.Func(t)
+		duration := time.Since(start)
 
-		if t.failed == 0 {
-			fmt.Printf("--- PASS: %s\n", test.Name)
-		} else {
-			fmt.Printf("--- FAIL: %s\n", test.Name)
+		switch m.Format {
+		case "tap":
+			m.reportTAP(i+1, t, duration)
+		case "json":
+			m.reportJSON(t, duration)
+		default:
+			if t.failed == 0 {
+				fmt.Printf("--- PASS: %s (%.2fs)\n", test.Name, duration.Seconds())
+			} else {
+				fmt.Printf("--- FAIL: %s (%.2fs)\n", test.Name, duration.Seconds())
+			}
+			fmt.Println(t.output)
 		}
-		fmt.Println(t.output)
 
 		failures += t.failed
 	}
 
-	if failures > 0 {
-		fmt.Printf("exit status %d\n", failures)
-		fmt.Println("FAIL")
+	if failures == 0 {
+		failures += m.runBenchmarks()
+	}
+
+	switch m.Format {
+	case "tap":
+		fmt.Printf("# pass %d\n", len(m.Tests)-failures)
+		fmt.Printf("# fail %d\n", failures)
+	case "json":
+		action := "pass"
+		if failures > 0 {
+			action = "fail"
+		}
+		fmt.Printf("{\"Action\":\"%s\"}\n", action)
+	default:
+		if failures > 0 {
+			fmt.Printf("exit status %d\n", failures)
+			fmt.Println("FAIL")
+		} else {
+			fmt.Println("PASS")
+		}
 	}
 	return failures
 }
 
+// reportTAP prints the result of a single test as a TAP test line. The output
+// of a failed test is included as a YAML diagnostic block.
+func (m *M) reportTAP(n int, t *T, duration time.Duration) {
+	ms := float64(duration) / float64(time.Millisecond)
+	if t.failed == 0 {
+		fmt.Printf("ok %d - %s # time=%.3fms\n", n, t.name, ms)
+		return
+	}
+	fmt.Printf("not ok %d - %s # time=%.3fms\n", n, t.name, ms)
+	fmt.Println("  ---")
+	fmt.Printf("  duration_ms: %.3f\n", ms)
+	if output := t.output.(*bytes.Buffer).String(); output != "" {
+		fmt.Println("  message: |")
+		for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+			fmt.Println("    " + strings.TrimLeft(line, "\t"))
+		}
+	}
+	fmt.Println("  ...")
+}
+
+// reportJSON prints the output and the result of a single test as JSON
+// objects, one per line, with the same fields as go test -json.
+func (m *M) reportJSON(t *T, duration time.Duration) {
+	name := quoteJSON(t.name)
+	if output := t.output.(*bytes.Buffer).String(); output != "" {
+		for _, line := range strings.SplitAfter(output, "\n") {
+			if line != "" {
+				fmt.Printf("{\"Action\":\"output\",\"Test\":%s,\"Output\":%s}\n", name, quoteJSON(line))
+			}
+		}
+	}
+	action := "pass"
+	if t.failed != 0 {
+		action = "fail"
+	}
+	fmt.Printf("{\"Action\":\"%s\",\"Test\":%s,\"Elapsed\":%.3f}\n", action, name, duration.Seconds())
+}
+
+// quoteJSON returns s as a JSON string literal. Unlike strconv.Quote, it
+// doesn't produce escape sequences such as \x00 and \a that are not valid in
+// JSON. Invalid UTF-8 is replaced with U+FFFD, like encoding/json does.
+func quoteJSON(s string) string {
+	const hex = "0123456789abcdef"
+	buf := make([]byte, 0, len(s)+2)
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf = append(buf, "\\ufffd"...)
+			} else {
+				buf = append(buf, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		switch {
+		case c == '"' || c == '\\':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, '\\', 'n')
+		case c == '\r':
+			buf = append(buf, '\\', 'r')
+		case c == '\t':
+			buf = append(buf, '\\', 't')
+		case c < 0x20 || c == 0x7f:
+			buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			buf = append(buf, c)
+		}
+		i++
+	}
+	buf = append(buf, '"')
+	return string(buf)
+}
+
 func TestMain(m *M) {
 	os.Exit(m.Run())
 }
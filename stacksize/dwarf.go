@@ -0,0 +1,294 @@
+package stacksize
+
+// This file implements a parser of DWARF call frame information (the
+// .debug_frame section), which is used to determine the stack frame size of
+// every function in a binary.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// frameInfo is the stack frame size of a single function, as found in the
+// .debug_frame section.
+type frameInfo struct {
+	start     uint64 // start address of the function
+	end       uint64 // end address of the function (exclusive)
+	frameSize uint64 // maximum CFA offset, which is the stack usage of the function
+}
+
+// commonInfo is a parsed CIE (Common Information Entry).
+type commonInfo struct {
+	codeAlignmentFactor uint64
+	dataAlignmentFactor int64
+	initialInstructions []byte
+}
+
+// parseFrames reads all FDEs (Frame Description Entries) from the given
+// .debug_frame section and calculates the maximum stack frame size of each of
+// them. The returned slice is sorted by start address.
+func parseFrames(data []byte, byteOrder binary.ByteOrder, addressSize int) ([]frameInfo, error) {
+	cies := make(map[uint32]*commonInfo)
+	var frames []frameInfo
+	offset := 0
+	for offset < len(data) {
+		if len(data)-offset < 4 {
+			return nil, errors.New("stacksize: truncated .debug_frame section")
+		}
+		length := byteOrder.Uint32(data[offset:])
+		if length == 0xffffffff {
+			return nil, errors.New("stacksize: 64-bit DWARF is not supported")
+		}
+		start := offset
+		end := offset + 4 + int(length)
+		if end > len(data) || length < 4 {
+			return nil, errors.New("stacksize: truncated .debug_frame entry")
+		}
+		entry := data[offset+4 : end]
+		offset = end
+		id := byteOrder.Uint32(entry)
+		r := &dwarfReader{buf: entry[4:], byteOrder: byteOrder, addressSize: addressSize}
+		if id == 0xffffffff {
+			// CIE
+			cie, err := parseCIE(r)
+			if err != nil {
+				return nil, err
+			}
+			cies[uint32(start)] = cie
+			continue
+		}
+
+		// FDE
+		cie := cies[id]
+		if cie == nil {
+			return nil, fmt.Errorf("stacksize: FDE at offset %d refers to unknown CIE", start)
+		}
+		initialLocation := r.address()
+		addressRange := r.address()
+		if r.err != nil {
+			return nil, r.err
+		}
+		frameSize, err := maxCFAOffset(cie, cie.initialInstructions, r.buf[r.pos:], byteOrder, addressSize)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frameInfo{
+			start:     initialLocation,
+			end:       initialLocation + addressRange,
+			frameSize: frameSize,
+		})
+	}
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].start < frames[j].start
+	})
+	return frames, nil
+}
+
+// parseCIE parses the contents of a CIE, after the length and CIE_id fields.
+func parseCIE(r *dwarfReader) (*commonInfo, error) {
+	version := r.uint8()
+	augmentation := r.cstring()
+	if augmentation != "" {
+		return nil, fmt.Errorf("stacksize: unsupported CIE augmentation %#v", augmentation)
+	}
+	if version >= 4 {
+		r.uint8() // address_size
+		r.uint8() // segment_size
+	}
+	cie := &commonInfo{
+		codeAlignmentFactor: r.uleb128(),
+		dataAlignmentFactor: r.sleb128(),
+	}
+	if version == 1 {
+		r.uint8() // return_address_register
+	} else {
+		r.uleb128() // return_address_register
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	cie.initialInstructions = r.buf[r.pos:]
+	return cie, nil
+}
+
+// maxCFAOffset runs the call frame instructions of a CIE and FDE and returns
+// the highest CFA offset seen. This is the amount of stack space used by the
+// function, as the CFA is the stack pointer value at the call site.
+func maxCFAOffset(cie *commonInfo, initial, instructions []byte, byteOrder binary.ByteOrder, addressSize int) (uint64, error) {
+	var cfaOffset, maxOffset int64
+	var stack []int64 // for DW_CFA_remember_state and DW_CFA_restore_state
+	for _, program := range [][]byte{initial, instructions} {
+		r := &dwarfReader{buf: program, byteOrder: byteOrder, addressSize: addressSize}
+		for r.pos < len(r.buf) && r.err == nil {
+			op := r.uint8()
+			switch op >> 6 {
+			case 1: // DW_CFA_advance_loc
+				continue
+			case 2: // DW_CFA_offset
+				r.uleb128()
+				continue
+			case 3: // DW_CFA_restore
+				continue
+			}
+			switch op {
+			case 0x00: // DW_CFA_nop
+			case 0x01: // DW_CFA_set_loc
+				r.address()
+			case 0x02: // DW_CFA_advance_loc1
+				r.uint8()
+			case 0x03: // DW_CFA_advance_loc2
+				r.skip(2)
+			case 0x04: // DW_CFA_advance_loc4
+				r.skip(4)
+			case 0x05: // DW_CFA_offset_extended
+				r.uleb128()
+				r.uleb128()
+			case 0x06, 0x07, 0x08: // DW_CFA_restore_extended, DW_CFA_undefined, DW_CFA_same_value
+				r.uleb128()
+			case 0x09: // DW_CFA_register
+				r.uleb128()
+				r.uleb128()
+			case 0x0a: // DW_CFA_remember_state
+				stack = append(stack, cfaOffset)
+			case 0x0b: // DW_CFA_restore_state
+				if len(stack) == 0 {
+					return 0, errors.New("stacksize: DW_CFA_restore_state without DW_CFA_remember_state")
+				}
+				cfaOffset = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			case 0x0c: // DW_CFA_def_cfa
+				r.uleb128()
+				cfaOffset = int64(r.uleb128())
+			case 0x0d: // DW_CFA_def_cfa_register
+				r.uleb128()
+			case 0x0e: // DW_CFA_def_cfa_offset
+				cfaOffset = int64(r.uleb128())
+			case 0x0f: // DW_CFA_def_cfa_expression
+				r.skip(int(r.uleb128()))
+			case 0x10, 0x16: // DW_CFA_expression, DW_CFA_val_expression
+				r.uleb128()
+				r.skip(int(r.uleb128()))
+			case 0x11, 0x15: // DW_CFA_offset_extended_sf, DW_CFA_val_offset_sf
+				r.uleb128()
+				r.sleb128()
+			case 0x12: // DW_CFA_def_cfa_sf
+				r.uleb128()
+				cfaOffset = r.sleb128() * cie.dataAlignmentFactor
+			case 0x13: // DW_CFA_def_cfa_offset_sf
+				cfaOffset = r.sleb128() * cie.dataAlignmentFactor
+			case 0x14: // DW_CFA_val_offset
+				r.uleb128()
+				r.uleb128()
+			case 0x2e: // DW_CFA_GNU_args_size
+				r.uleb128()
+			default:
+				return 0, fmt.Errorf("stacksize: unknown call frame instruction 0x%02x", op)
+			}
+			if cfaOffset > maxOffset {
+				maxOffset = cfaOffset
+			}
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+	}
+	return uint64(maxOffset), nil
+}
+
+// dwarfReader is a simple reader for the variable-length values used in DWARF.
+// Errors are sticky: after the first error all reads return zero.
+type dwarfReader struct {
+	buf         []byte
+	pos         int
+	byteOrder   binary.ByteOrder
+	addressSize int
+	err         error
+}
+
+func (r *dwarfReader) skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if n < 0 || r.pos+n > len(r.buf) {
+		r.err = errors.New("stacksize: unexpected end of DWARF data")
+		return
+	}
+	r.pos += n
+}
+
+func (r *dwarfReader) uint8() uint8 {
+	r.skip(1)
+	if r.err != nil {
+		return 0
+	}
+	return r.buf[r.pos-1]
+}
+
+func (r *dwarfReader) address() uint64 {
+	r.skip(r.addressSize)
+	if r.err != nil {
+		return 0
+	}
+	switch r.addressSize {
+	case 2:
+		return uint64(r.byteOrder.Uint16(r.buf[r.pos-2:]))
+	case 4:
+		return uint64(r.byteOrder.Uint32(r.buf[r.pos-4:]))
+	case 8:
+		return r.byteOrder.Uint64(r.buf[r.pos-8:])
+	}
+	r.err = fmt.Errorf("stacksize: unsupported address size %d", r.addressSize)
+	return 0
+}
+
+func (r *dwarfReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.buf[r.pos:], 0)
+	if i < 0 {
+		r.err = errors.New("stacksize: unterminated string in DWARF data")
+		return ""
+	}
+	s := string(r.buf[r.pos : r.pos+i])
+	r.pos += i + 1
+	return s
+}
+
+func (r *dwarfReader) uleb128() uint64 {
+	var result uint64
+	var shift uint
+	for {
+		b := r.uint8()
+		if r.err != nil {
+			return 0
+		}
+		result |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return result
+		}
+	}
+}
+
+func (r *dwarfReader) sleb128() int64 {
+	var result int64
+	var shift uint
+	for {
+		b := r.uint8()
+		if r.err != nil {
+			return 0
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift // sign extend
+			}
+			return result
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package stacksize
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// debugFrameEntry returns a .debug_frame entry (CIE or FDE) with the given ID
+// and contents, prefixed with its length.
+func debugFrameEntry(id uint32, contents ...byte) []byte {
+	buf := make([]byte, 8, 8+len(contents))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(4+len(contents)))
+	binary.LittleEndian.PutUint32(buf[4:], id)
+	return append(buf, contents...)
+}
+
+// testCIE is a CIE as emitted by LLVM for Thumb code: version 1, no
+// augmentation, code alignment 2, data alignment -4, return address in lr and
+// the CFA initially at sp+0.
+var testCIE = debugFrameEntry(0xffffffff,
+	1,           // version
+	0,           // augmentation ""
+	2,           // code_alignment_factor
+	0x7c,        // data_alignment_factor (-4)
+	14,          // return_address_register (lr)
+	0x0c, 13, 0, // DW_CFA_def_cfa sp, 0
+)
+
+// testFDE returns an FDE that refers to testCIE (at offset 0) for the given
+// address range.
+func testFDE(start, size uint32, instructions ...byte) []byte {
+	contents := make([]byte, 8, 8+len(instructions))
+	binary.LittleEndian.PutUint32(contents[0:], start)
+	binary.LittleEndian.PutUint32(contents[4:], size)
+	return debugFrameEntry(0, append(contents, instructions...)...)
+}
+
+func concat(entries ...[]byte) []byte {
+	var data []byte
+	for _, entry := range entries {
+		data = append(data, entry...)
+	}
+	return data
+}
+
+func TestParseFrames(t *testing.T) {
+	data := concat(
+		testCIE,
+		testFDE(0x100, 0x20,
+			0x41,    // DW_CFA_advance_loc 1
+			0x0e, 8, // DW_CFA_def_cfa_offset 8
+			0x8e, 1, // DW_CFA_offset lr, 1
+			0x8b, 2, // DW_CFA_offset r11, 2
+			0x0a,             // DW_CFA_remember_state
+			0x42,             // DW_CFA_advance_loc 2
+			0x0e, 0x98, 0x01, // DW_CFA_def_cfa_offset 152
+			0x0b, // DW_CFA_restore_state
+			0x00, // DW_CFA_nop
+		),
+		testFDE(0x40, 0x10,
+			0x12, 13, 0x7c, // DW_CFA_def_cfa_sf sp, -1 (factored: 4)
+			0x02, 4, // DW_CFA_advance_loc1 4
+			0x13, 0x7a, // DW_CFA_def_cfa_offset_sf -6 (factored: 24)
+			0x03, 1, 0, // DW_CFA_advance_loc2 1
+			0x0e, 0, // DW_CFA_def_cfa_offset 0
+		),
+		testFDE(0x80, 0x8), // leaf function without stack usage
+	)
+	frames, err := parseFrames(data, binary.LittleEndian, 4)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := []frameInfo{
+		{start: 0x40, end: 0x50, frameSize: 24},
+		{start: 0x80, end: 0x88, frameSize: 0},
+		{start: 0x100, end: 0x120, frameSize: 152},
+	}
+	if len(frames) != len(expected) {
+		t.Fatalf("expected %d frames, got %d: %v", len(expected), len(frames), frames)
+	}
+	for i, frame := range frames {
+		if frame != expected[i] {
+			t.Errorf("frame %d: expected %+v, got %+v", i, expected[i], frame)
+		}
+	}
+}
+
+func TestParseFramesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"truncated length", []byte{1, 0}, "truncated .debug_frame section"},
+		{"truncated entry", testCIE[:len(testCIE)-1], "truncated .debug_frame entry"},
+		{"64-bit", []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}, "64-bit DWARF"},
+		{"unknown CIE", testFDE(0x100, 4), "refers to unknown CIE"},
+		{"augmentation", debugFrameEntry(0xffffffff, 1, 'z', 'R', 0, 2, 0x7c, 14), "unsupported CIE augmentation"},
+		{"unknown instruction", concat(testCIE, testFDE(0x100, 4, 0x3f)), "unknown call frame instruction 0x3f"},
+		{"restore without remember", concat(testCIE, testFDE(0x100, 4, 0x0b)), "DW_CFA_restore_state without DW_CFA_remember_state"},
+		{"truncated instruction", concat(testCIE, testFDE(0x100, 4, 0x0e)), "unexpected end of DWARF data"},
+	}
+	for _, tc := range tests {
+		_, err := parseFrames(tc.data, binary.LittleEndian, 4)
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		} else if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error containing %#v, got %#v", tc.name, tc.err, err.Error())
+		}
+	}
+}
+
+func TestLEB128(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		unsigned uint64
+		signed   int64
+	}{
+		{[]byte{0x02}, 2, 2},
+		{[]byte{0x7f}, 127, -1},
+		{[]byte{0x80, 0x01}, 128, 128},
+		{[]byte{0xe5, 0x8e, 0x26}, 624485, 624485},
+		{[]byte{0xc0, 0xbb, 0x78}, 1973696, -123456},
+	}
+	for _, tc := range tests {
+		r := &dwarfReader{buf: tc.data}
+		if v := r.uleb128(); v != tc.unsigned || r.err != nil {
+			t.Errorf("uleb128(%x): expected %d, got %d (err: %v)", tc.data, tc.unsigned, v, r.err)
+		}
+		r = &dwarfReader{buf: tc.data}
+		if v := r.sleb128(); v != tc.signed || r.err != nil {
+			t.Errorf("sleb128(%x): expected %d, got %d (err: %v)", tc.data, tc.signed, v, r.err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package stacksize tries to determine the worst-case stack usage of a linked
+// binary, by combining the call graph of the program with the stack frame size
+// of each function.
+//
+// The call graph is determined by disassembling all call instructions, and
+// frame sizes are read from the DWARF call frame information. Indirect calls
+// and recursion make it impossible to determine an upper bound, in which case
+// this is reported instead of a (wrong) stack size.
+//
+// Currently only ARM (Thumb) binaries are supported, as used on Cortex-M
+// microcontrollers.
+package stacksize
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// SizeType indicates whether a stack size could be determined, and if not,
+// why not.
+type SizeType uint8
+
+const (
+	Undefined    SizeType = iota // not yet calculated
+	Unknown                      // no frame information for some function
+	Bounded                      // the stack size is known
+	Recursive                    // the function is (indirectly) recursive
+	IndirectCall                 // the function does an indirect call
+)
+
+func (t SizeType) String() string {
+	switch t {
+	case Undefined:
+		return "undefined"
+	case Unknown:
+		return "unknown"
+	case Bounded:
+		return "bounded"
+	case Recursive:
+		return "recursive"
+	case IndirectCall:
+		return "indirect call"
+	default:
+		return "?"
+	}
+}
+
+// CallNode is a node in the call graph. Every function in the binary has a
+// single node, which may have multiple names (aliases).
+type CallNode struct {
+	Names          []string
+	Address        uint64 // address at which the function is linked (without the Thumb bit)
+	Size           uint64 // function size in bytes
+	FrameSize      uint64 // frame size of the function itself, if FrameSizeKnown is set
+	FrameSizeKnown bool
+	Indirect       bool        // whether this function does an indirect call
+	Children       []*CallNode // functions called by this function
+
+	// Cached results of StackSize.
+	stackSize     uint64
+	stackSizeType SizeType
+	culprit       *CallNode // function that caused an unknown stack size
+	visiting      bool      // used for recursion detection
+}
+
+// Name returns a human-readable name for this function.
+func (node *CallNode) Name() string {
+	if len(node.Names) == 0 {
+		return "<unknown>"
+	}
+	return node.Names[0]
+}
+
+// StackSize returns the worst-case stack size of this function, including all
+// the functions it calls. If the stack size cannot be determined, the second
+// return value indicates why and the third return value is the function that
+// caused it.
+func (node *CallNode) StackSize() (uint64, SizeType, *CallNode) {
+	if node.stackSizeType != Undefined {
+		return node.stackSize, node.stackSizeType, node.culprit
+	}
+	if node.visiting {
+		// This function is called by one of its callees.
+		return 0, Recursive, node
+	}
+	node.visiting = true
+	defer func() {
+		node.visiting = false
+	}()
+
+	if !node.FrameSizeKnown {
+		node.stackSizeType = Unknown
+		node.culprit = node
+		return 0, node.stackSizeType, node.culprit
+	}
+	if node.Indirect {
+		node.stackSizeType = IndirectCall
+		node.culprit = node
+		return 0, node.stackSizeType, node.culprit
+	}
+	var maxChildSize uint64
+	for _, child := range node.Children {
+		size, sizeType, culprit := child.StackSize()
+		if sizeType != Bounded {
+			if sizeType == Recursive && culprit != node {
+				// Don't cache this result: this function itself may not be
+				// recursive, it may just be part of a cycle that started
+				// elsewhere.
+				return 0, sizeType, culprit
+			}
+			node.stackSizeType = sizeType
+			node.culprit = culprit
+			return 0, node.stackSizeType, node.culprit
+		}
+		if size > maxChildSize {
+			maxChildSize = size
+		}
+	}
+	node.stackSize = node.FrameSize + maxChildSize
+	node.stackSizeType = Bounded
+	return node.stackSize, node.stackSizeType, nil
+}
+
+// CallGraph parses the given ELF file and returns the call graph of all
+// functions in it, indexed by symbol name.
+func CallGraph(f *elf.File) (map[string]*CallNode, error) {
+	if f.Machine != elf.EM_ARM {
+		return nil, errors.New("stacksize: only ARM binaries are supported")
+	}
+
+	// Read frame sizes.
+	frameSection := f.Section(".debug_frame")
+	if frameSection == nil {
+		return nil, errors.New("stacksize: no .debug_frame section, was the binary compiled without debug information?")
+	}
+	frameData, err := frameSection.Data()
+	if err != nil {
+		return nil, err
+	}
+	frames, err := parseFrames(frameData, f.ByteOrder, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a node for each function.
+	symbols, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]*CallNode)
+	nodesByAddress := make(map[uint64]*CallNode)
+	for _, symbol := range symbols {
+		if elf.ST_TYPE(symbol.Info) != elf.STT_FUNC {
+			continue
+		}
+		address := symbol.Value &^ 1 // clear the Thumb bit
+		node := nodesByAddress[address]
+		if node == nil {
+			node = &CallNode{
+				Address: address,
+				Size:    symbol.Size,
+			}
+			nodesByAddress[address] = node
+			i := sort.Search(len(frames), func(i int) bool {
+				return frames[i].start >= address
+			})
+			if i < len(frames) && frames[i].start == address {
+				node.FrameSize = frames[i].frameSize
+				node.FrameSizeKnown = true
+			}
+		}
+		node.Names = append(node.Names, symbol.Name)
+		nodes[symbol.Name] = node
+	}
+
+	// Find all call instructions.
+	for _, node := range nodesByAddress {
+		code, err := readCode(f, node.Address, node.Size)
+		if err != nil {
+			return nil, err
+		}
+		if code == nil {
+			continue
+		}
+		for _, call := range thumbCalls(code, node.Address, f.ByteOrder) {
+			if call.indirect {
+				node.Indirect = true
+				continue
+			}
+			if call.target > node.Address && call.target < node.Address+node.Size {
+				continue // branch within the same function
+			}
+			if child := nodesByAddress[call.target]; child != nil {
+				node.Children = append(node.Children, child)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// readCode returns the contents of the given function, or nil if it isn't
+// stored in the file.
+func readCode(f *elf.File, address, size uint64) ([]byte, error) {
+	for _, section := range f.Sections {
+		if section.Type != elf.SHT_PROGBITS || section.Flags&elf.SHF_EXECINSTR == 0 {
+			continue
+		}
+		if address < section.Addr || address+size > section.Addr+section.Size {
+			continue
+		}
+		code := make([]byte, size)
+		_, err := section.ReadAt(code, int64(address-section.Addr))
+		return code, err
+	}
+	return nil, nil
+}
+
+// thumbCall is a single call found in Thumb code.
+type thumbCall struct {
+	target   uint64
+	indirect bool
+}
+
+// thumbCalls disassembles the given Thumb code and returns all calls (and tail
+// calls) in it.
+func thumbCalls(code []byte, address uint64, byteOrder binary.ByteOrder) []thumbCall {
+	var calls []thumbCall
+	for i := 0; i+2 <= len(code); i += 2 {
+		hw1 := byteOrder.Uint16(code[i:])
+		if hw1&0xff87 == 0x4780 {
+			// BLX <Rm>
+			calls = append(calls, thumbCall{indirect: true})
+			continue
+		}
+		if hw1>>11 != 0x1d && hw1>>11 != 0x1e && hw1>>11 != 0x1f {
+			continue // 16-bit instruction
+		}
+		// 32-bit instruction
+		if i+4 > len(code) {
+			break
+		}
+		hw2 := byteOrder.Uint16(code[i+2:])
+		i += 2
+		if hw1>>11 != 0x1e {
+			continue
+		}
+		// BL <label> or B.W <label> (the latter is used for tail calls)
+		if hw2&0xd000 != 0xd000 && hw2&0xd000 != 0x9000 {
+			continue
+		}
+		s := uint32(hw1>>10) & 1
+		j1 := uint32(hw2>>13) & 1
+		j2 := uint32(hw2>>11) & 1
+		i1 := ^(j1 ^ s) & 1
+		i2 := ^(j2 ^ s) & 1
+		imm := s<<24 | i1<<23 | i2<<22 | uint32(hw1&0x3ff)<<12 | uint32(hw2&0x7ff)<<1
+		offset := int64(int32(imm<<7) >> 7) // sign extend from 25 bits
+		pc := address + uint64(i-2) + 4
+		calls = append(calls, thumbCall{target: uint64(int64(pc) + offset)})
+	}
+	return calls
+}
+
+// EntryPoints returns the names of all functions that are called from outside
+// the program: the reset handler (or main), interrupt handlers and goroutine
+// resume functions. The names are sorted alphabetically.
+func EntryPoints(nodes map[string]*CallNode) []string {
+	var names []string
+	for name := range nodes {
+		if name == "Reset_Handler" || name == "main" && nodes["Reset_Handler"] == nil || strings.HasSuffix(name, "_Handler") || strings.HasSuffix(name, "IRQHandler") || strings.HasSuffix(name, ".resume") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,109 @@
+package stacksize
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// thumbCode returns the little-endian encoding of the given halfwords.
+func thumbCode(halfwords ...uint16) []byte {
+	code := make([]byte, 2*len(halfwords))
+	for i, hw := range halfwords {
+		binary.LittleEndian.PutUint16(code[2*i:], hw)
+	}
+	return code
+}
+
+func TestThumbCalls(t *testing.T) {
+	tests := []struct {
+		name    string
+		address uint64
+		code    []byte
+		calls   []thumbCall
+	}{
+		{"no calls", 0x1000, thumbCode(0xb580, 0x2000, 0xbd80), nil},
+		{"bl forward", 0x1000, thumbCode(0xf000, 0xf800), []thumbCall{{target: 0x1004}}},
+		{"bl to self", 0x1000, thumbCode(0xf7ff, 0xfffe), []thumbCall{{target: 0x1000}}},
+		{"bl far", 0x1000, thumbCode(0xf001, 0xf800), []thumbCall{{target: 0x2004}}},
+		{"bl backward", 0x2000, thumbCode(0xf7fe, 0xfffe), []thumbCall{{target: 0x1000}}},
+		{"b.w tail call", 0x1000, thumbCode(0xf000, 0xb800), []thumbCall{{target: 0x1004}}},
+		{"blx register", 0x1000, thumbCode(0x4798), []thumbCall{{indirect: true}}},
+		{"bx lr is a return", 0x1000, thumbCode(0x4770), nil},
+		// The second halfword of ldr.w r4, [r7, #1944] looks like blx r3.
+		{"32-bit instruction", 0x1000, thumbCode(0xf8d7, 0x4798), nil},
+		{"conditional b.w", 0x1000, thumbCode(0xf000, 0x8000), nil},
+		{"after other instructions", 0x1000, thumbCode(0xb580, 0xf8d7, 0x4798, 0xf000, 0xf800, 0x4798), []thumbCall{{target: 0x100a}, {indirect: true}}},
+		{"truncated 32-bit instruction", 0x1000, thumbCode(0xf000), nil},
+	}
+	for _, tc := range tests {
+		calls := thumbCalls(tc.code, tc.address, binary.LittleEndian)
+		if len(calls) != len(tc.calls) {
+			t.Errorf("%s: expected calls %v, got %v", tc.name, tc.calls, calls)
+			continue
+		}
+		for i, call := range calls {
+			if call != tc.calls[i] {
+				t.Errorf("%s: expected calls %v, got %v", tc.name, tc.calls, calls)
+				break
+			}
+		}
+	}
+}
+
+func TestStackSize(t *testing.T) {
+	leaf := &CallNode{Names: []string{"leaf"}, FrameSize: 8, FrameSizeKnown: true}
+	middle := &CallNode{Names: []string{"middle"}, FrameSize: 16, FrameSizeKnown: true, Children: []*CallNode{leaf}}
+	top := &CallNode{Names: []string{"top"}, FrameSize: 32, FrameSizeKnown: true, Children: []*CallNode{leaf, middle}}
+	unknown := &CallNode{Names: []string{"unknown"}}
+	callsUnknown := &CallNode{Names: []string{"callsUnknown"}, FrameSize: 8, FrameSizeKnown: true, Children: []*CallNode{unknown}}
+	indirect := &CallNode{Names: []string{"indirect"}, FrameSize: 8, FrameSizeKnown: true, Indirect: true}
+	recursive := &CallNode{Names: []string{"recursive"}, FrameSize: 8, FrameSizeKnown: true}
+	recursive.Children = []*CallNode{recursive}
+	cycleA := &CallNode{Names: []string{"cycleA"}, FrameSize: 8, FrameSizeKnown: true}
+	cycleB := &CallNode{Names: []string{"cycleB"}, FrameSize: 8, FrameSizeKnown: true, Children: []*CallNode{cycleA}}
+	cycleA.Children = []*CallNode{cycleB}
+	callsCycle := &CallNode{Names: []string{"callsCycle"}, FrameSize: 8, FrameSizeKnown: true, Children: []*CallNode{cycleB}}
+
+	tests := []struct {
+		node     *CallNode
+		size     uint64
+		sizeType SizeType
+		culprit  *CallNode
+	}{
+		{leaf, 8, Bounded, nil},
+		{top, 56, Bounded, nil},
+		{callsUnknown, 0, Unknown, unknown},
+		{indirect, 0, IndirectCall, indirect},
+		{recursive, 0, Recursive, recursive},
+		{callsCycle, 0, Recursive, cycleB},
+		{cycleA, 0, Recursive, cycleB}, // found through cycleB above
+		{cycleB, 0, Recursive, cycleB},
+	}
+	for _, tc := range tests {
+		size, sizeType, culprit := tc.node.StackSize()
+		if size != tc.size || sizeType != tc.sizeType || culprit != tc.culprit {
+			t.Errorf("%s: expected %d, %s, %s; got %d, %s, %s", tc.node.Name(), tc.size, tc.sizeType, tc.culprit.Name(), size, sizeType, culprit.Name())
+		}
+	}
+}
+
+func TestEntryPoints(t *testing.T) {
+	nodes := map[string]*CallNode{
+		"Reset_Handler":           {},
+		"main":                    {},
+		"SysTick_Handler":         {},
+		"UARTE0_UART0_IRQHandler": {},
+		"main.worker.resume":      {},
+		"main.worker":             {},
+	}
+	names := EntryPoints(nodes)
+	expected := []string{"Reset_Handler", "SysTick_Handler", "UARTE0_UART0_IRQHandler", "main.worker.resume"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+	}
+}
@@ -30,6 +30,7 @@ type TargetSpec struct {
 	GOARCH     string   `json:"goarch"`
 	BuildTags  []string `json:"build-tags"`
 	GC         string   `json:"gc"`
+	Filesystem string   `json:"filesystem"` // filesystem mounted at startup (littlefs, semihosting)
 	Compiler   string   `json:"compiler"`
 	Linker     string   `json:"linker"`
 	RTLib      string   `json:"rtlib"` // compiler runtime library (libgcc, compiler-rt)
@@ -38,9 +39,54 @@ type TargetSpec struct {
 	ExtraFiles []string `json:"extra-files"`
 	Emulator   []string `json:"emulator"`
 	Flasher    string   `json:"flash"`
+	SerialPort []string `json:"serial-port"`     // USB IDs ("vid:pid") of the serial port used by {port}
+	FlashAlgo  string   `json:"flash-algorithm"` // chip family for -programmer=cmsis-dap
+	Bootloader string   `json:"bootloader"`      // built-in flash method (bossa, dfu, picoboot, uf2) used if the flash command is not installed
 	OCDDaemon  []string `json:"ocd-daemon"`
 	GDB        string   `json:"gdb"`
 	GDBCmds    []string `json:"gdb-initial-cmds"`
+	ImageHash  string   `json:"image-hash-offset"` // offset of the image hash (and signature) in the image, see sign.go
+
+	// Memory that is added to the heap besides the RAM in the linker script,
+	// like external RAM. Only the conservative GC supports it.
+	HeapRegions []HeapRegionSpec `json:"heap-regions"`
+
+	// Peripheral registers that can be written without side effects (other
+	// than on the peripheral) during compile-time evaluation of package
+	// initializers, like clock enable registers.
+	Registers []RegisterSpec `json:"mmio-registers"`
+}
+
+// HeapRegionSpec declares an area of memory for the heap. The start address
+// and size are strings, so that they can be written in hexadecimal and with a
+// size suffix, for example {"start": "0x60000000", "size": "8M"}. The memory
+// must be usable when the runtime initializes: if it needs to be set up first,
+// like an SDRAM controller, the startup code of the target must do so.
+//
+// Objects of at least min-object-size bytes (256 by default) are preferably
+// allocated in the region, smaller objects only when the main heap is full.
+// Set it to "0" to put the whole Go heap in the region, with the main heap as
+// a fallback.
+type HeapRegionSpec struct {
+	Start     string `json:"start"`
+	Size      string `json:"size"`
+	MinObject string `json:"min-object-size"`
+}
+
+// RegisterSpec describes a peripheral register for the interp package. The
+// address and the value after reset are strings, so that they can be written
+// in hexadecimal. The kind is "write-only" (the default) if reads must be done
+// at runtime, because the hardware or code that ran before the program (like a
+// bootloader) may have changed the register. Stores are kept, and don't stop
+// the interpreter. It is "memory" if the register only changes when the
+// program writes it and has its reset value at startup, so that it can be read
+// at compile time. Only use "memory" when that is true on every board that
+// uses the target.
+type RegisterSpec struct {
+	Name    string `json:"name"` // for documentation only
+	Address string `json:"address"`
+	Reset   string `json:"reset"`
+	Kind    string `json:"kind"`
 }
 
 // copyProperties copies all properties that are set in spec2 into itself.
@@ -65,6 +111,9 @@ func (spec *TargetSpec) copyProperties(spec2 *TargetSpec) {
 	if spec2.GC != "" {
 		spec.GC = spec2.GC
 	}
+	if spec2.Filesystem != "" {
+		spec.Filesystem = spec2.Filesystem
+	}
 	if spec2.Compiler != "" {
 		spec.Compiler = spec2.Compiler
 	}
@@ -83,6 +132,15 @@ func (spec *TargetSpec) copyProperties(spec2 *TargetSpec) {
 	if spec2.Flasher != "" {
 		spec.Flasher = spec2.Flasher
 	}
+	if spec2.FlashAlgo != "" {
+		spec.FlashAlgo = spec2.FlashAlgo
+	}
+	if spec2.Bootloader != "" {
+		spec.Bootloader = spec2.Bootloader
+	}
+	if len(spec2.SerialPort) != 0 {
+		spec.SerialPort = spec2.SerialPort
+	}
 	if len(spec2.OCDDaemon) != 0 {
 		spec.OCDDaemon = spec2.OCDDaemon
 	}
@@ -92,6 +150,15 @@ func (spec *TargetSpec) copyProperties(spec2 *TargetSpec) {
 	if len(spec2.GDBCmds) != 0 {
 		spec.GDBCmds = spec2.GDBCmds
 	}
+	if spec2.ImageHash != "" {
+		spec.ImageHash = spec2.ImageHash
+	}
+	if len(spec2.HeapRegions) != 0 {
+		spec.HeapRegions = spec2.HeapRegions
+	}
+	if len(spec2.Registers) != 0 {
+		spec.Registers = spec2.Registers
+	}
 }
 
 // load reads a target specification from the JSON in the given io.Reader. It
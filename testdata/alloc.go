@@ -0,0 +1,47 @@
+package main
+
+// This program allocates enough memory to run the garbage collector a few
+// times. It is also built with -no-recursion, see TestNoRecursion.
+
+import "runtime"
+
+type node struct {
+	value int
+	next  *node
+	data  []byte
+}
+
+func main() {
+	var list *node
+	total := 0
+	for i := 0; i < 2000; i++ {
+		n := &node{value: i, data: make([]byte, 50)}
+		n.data[49] = byte(i)
+		if i%16 == 0 {
+			// Keep every 16th node alive, drop the others.
+			n.next = list
+			list = n
+		}
+		total += len(n.data)
+	}
+	runtime.GC()
+
+	count := 0
+	sum := 0
+	for n := list; n != nil; n = n.next {
+		if n.data[49] != byte(n.value) {
+			println("corrupted node:", n.value)
+		}
+		count++
+		sum += n.value
+	}
+	println("allocated:", total)
+	println("kept:", count, sum)
+
+	m := map[string][]int{}
+	for i := 0; i < 100; i++ {
+		key := string(byte('a' + i%26))
+		m[key] = append(m[key], i)
+	}
+	println("map:", len(m), len(m["a"]), m["z"][2])
+}
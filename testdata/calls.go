@@ -40,6 +40,7 @@ func main() {
 
 	// deferred functions
 	testDefer()
+	testDeferLoop(deferred)
 
 	// Take a bound method and use it as a function pointer.
 	// This function pointer needs a context pointer.
@@ -85,6 +86,23 @@ func testDefer() {
 	println("deferring...")
 }
 
+func testDeferLoop(fn func(string, int)) {
+	for i := 0; i < 3; i++ {
+		defer deferred("...run as defer in loop", i)
+	}
+	for i := 0; i < 2; i++ {
+		j := i
+		defer func() {
+			println("...run closure deferred in loop:", j)
+		}()
+	}
+
+	thing := &Thing{"method value"}
+	method := thing.Print
+	defer method("bar")
+	defer fn("...run as deferred func value", 7)
+}
+
 func deferred(msg string, i int) {
 	println(msg, i)
 }
@@ -28,6 +28,11 @@ func main() {
 	var printer Printer
 	printer = &myPrinter{}
 	printer.Print()
+
+	// Blocking calls in deferred functions must be awaited as well.
+	println("deferred wait:")
+	deferredWait()
+	println("end deferred waiting")
 }
 
 func sub() {
@@ -42,6 +47,15 @@ func wait() {
 	println("  wait end")
 }
 
+func deferredWait() {
+	defer wait()
+	defer func() {
+		time.Sleep(time.Millisecond)
+		println("  deferred closure")
+	}()
+	println("  deferredWait returns")
+}
+
 func delayedValue() int {
 	time.Sleep(time.Millisecond)
 	return 42
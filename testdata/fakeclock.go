@@ -0,0 +1,23 @@
+package main
+
+import (
+	"runtime/fakeclock"
+	"time"
+)
+
+func main() {
+	fakeclock.Enable()
+	start := time.Now()
+	go func() {
+		time.Sleep(time.Hour)
+		println("goroutine woke up after", int64(time.Since(start)/time.Second), "seconds")
+	}()
+	time.Sleep(2 * time.Hour)
+	println("main woke up after", int64(time.Since(start)/time.Second), "seconds")
+	fakeclock.Advance(30 * time.Minute)
+	println("after advance:", int64(time.Since(start)/time.Minute), "minutes")
+	fakeclock.Disable()
+	if time.Since(start) < 150*time.Minute {
+		println("time went back after disabling the virtual clock")
+	}
+}
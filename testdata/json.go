@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+type point struct {
+	X, Y int
+}
+
+type shape struct {
+	Name    string            `json:"name"`
+	Points  []point           `json:"points"`
+	Center  *point            `json:"center,omitempty"`
+	Scale   float64           `json:"scale"`
+	Visible bool              `json:"visible"`
+	Tags    map[string]string `json:"tags"`
+	hidden  int
+}
+
+func main() {
+	println("marshal:")
+	marshal(42)
+	marshal("foo\n")
+	marshal([]int{1, 2, 3})
+	marshal([]byte("data"))
+	marshal(map[string]int{"b": 2, "a": 1})
+	marshal(&point{3, 5})
+	marshal(shape{
+		Name:    "triangle",
+		Points:  []point{{0, 0}, {4, 0}, {0, 3}},
+		Scale:   1.5,
+		Visible: true,
+		Tags:    map[string]string{"color": "red"},
+	})
+	marshal([]interface{}{nil, true, "x", 2.5})
+
+	println("unmarshal:")
+	var s shape
+	unmarshal(`{"name":"square","points":[{"X":1,"Y":2},{"X":3,"Y":4}],"center":{"X":2,"Y":3},"scale":0.5,"tags":{"a":"b"}}`, &s)
+	println(s.Name, len(s.Points), s.Points[1].X, s.Points[1].Y, s.Center.X, s.Center.Y, int(s.Scale*10), s.Visible, s.Tags["a"])
+	var ints []int
+	unmarshal(`[5, 6, 7, 8, 9]`, &ints)
+	println(len(ints), ints[0], ints[4])
+	var m map[string]int
+	unmarshal(`{"one": 1, "two": 2}`, &m)
+	println(len(m), m["one"], m["two"])
+	var p *point
+	unmarshal(`{"X": 7}`, &p)
+	println(p.X, p.Y)
+	var v interface{}
+	unmarshal(`{"list": [1, "two", null]}`, &v)
+	list := v.(map[string]interface{})["list"].([]interface{})
+	println(len(list), int(list[0].(float64)), list[1].(string), list[2] == nil)
+	var small int8
+	if err := json.Unmarshal([]byte(`300`), &small); err != nil {
+		println("error:", err.Error())
+	}
+}
+
+func marshal(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		println("error:", err.Error())
+		return
+	}
+	println(string(data))
+}
+
+func unmarshal(data string, v interface{}) {
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		println("error:", err.Error())
+	}
+}
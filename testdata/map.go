@@ -23,6 +23,8 @@ var testMapArrayKey = map[ArrayKey]int{
 	ArrayKey([4]byte{4, 3, 2, 1}): 4321,
 }
 var testmapIntInt = map[int]int{1: 1, 2: 4, 3: 9}
+var testmapByteKey = map[uint8]string{1: "one", 200: "two hundred"}
+var testmapFloatKey = map[float64]string{0: "zero", 1.5: "one and a half", -2.25: "minus two and a quarter"}
 
 func main() {
 	m := map[string]int{"answer": 42, "foo": 3}
@@ -59,13 +61,26 @@ func main() {
 	squares = make(map[int]int, 20)
 	testBigMap(squares, 40)
 	println("tested growing of a map")
+
+	testDeleteDuringRange()
+	testFloatKeys()
+	testSmallKeys()
+	testShrink()
 }
 
 func readMap(m map[string]int, key string) {
 	println("map length:", len(m))
 	println("map read:", key, "=", m[key])
-	for k, v := range m {
-		println(" ", k, "=", v)
+	// The iteration order is random, so sort the keys by value.
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+		for i := len(keys) - 1; i > 0 && m[keys[i]] < m[keys[i-1]]; i-- {
+			keys[i], keys[i-1] = keys[i-1], keys[i]
+		}
+	}
+	for _, k := range keys {
+		println(" ", k, "=", m[k])
 	}
 }
 
@@ -92,3 +107,77 @@ func testBigMap(squares map[int]int, n int) {
 		}
 	}
 }
+
+func testDeleteDuringRange() {
+	m := make(map[int]int)
+	for i := 0; i < 20; i++ {
+		m[i] = i
+	}
+	// Every iteration deletes a pair of keys, so the other key of the pair
+	// must not be returned anymore.
+	n := 0
+	for k := range m {
+		delete(m, k)
+		delete(m, k^1)
+		n++
+	}
+	println("deleted during range:", n, len(m))
+}
+
+func testFloatKeys() {
+	zero := 0.0
+	negZero := -zero
+	nan := zero / zero
+	m := make(map[float64]int)
+	m[zero] = 1
+	m[negZero] = 2
+	m[nan] = 3
+	m[nan] = 4
+	_, ok := m[nan]
+	println("float keys:", len(m), m[0], ok)
+	delete(m, nan)
+	println("float keys after delete:", len(m))
+
+	m32 := map[float32]string{1.5: "one and a half"}
+	println("float32 key:", m32[1.5])
+
+	// This map is created at compile time.
+	println("package float map:", len(testmapFloatKey), testmapFloatKey[negZero], testmapFloatKey[1.5], testmapFloatKey[-2.25])
+}
+
+func testShrink() {
+	m := make(map[int]int, 64)
+	for i := 0; i < 64; i++ {
+		m[i] = i * i
+	}
+	for i := 0; i < 64; i++ {
+		delete(m, i)
+	}
+	n := 0
+	for range m {
+		n++
+	}
+	println("emptied map:", len(m), n, m[3])
+	m[3] = 9
+	m[100] = 1
+	println("refilled map:", len(m), m[3], m[100], m[4])
+}
+
+func testSmallKeys() {
+	println("byte key:", testmapByteKey[200], len(testmapByteKey))
+	testmapByteKey[2] = "two"
+	delete(testmapByteKey, 1)
+	println("byte key:", testmapByteKey[1] == "", testmapByteKey[2], len(testmapByteKey))
+
+	b := map[bool]int{true: 1}
+	b[false] = 2
+	println("bool key:", b[true], b[false], len(b))
+
+	m := make(map[int16]int32)
+	for i := int16(-50); i < 50; i++ {
+		m[i*3] = int32(i)
+	}
+	delete(m, -150)
+	_, ok := m[-150]
+	println("int16 key:", len(m), m[-3], m[147], ok)
+}
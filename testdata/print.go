@@ -1,5 +1,19 @@
 package main
 
+type point struct {
+	x, y int
+}
+
+type nested struct {
+	a struct {
+		b struct {
+			c struct {
+				d int
+			}
+		}
+	}
+}
+
 func main() {
 	// test basic printing
 	println("hello world!")
@@ -36,7 +50,15 @@ func main() {
 	println(interface{}(nil))
 
 	// print map
-	println(map[string]int{"three": 3, "five": 5})
+	println(map[string]int{"three": 3})
+	var nilmap map[int]int
+	println(nilmap)
+
+	// print composite values
+	println(point{1, 2}, [3]int{4, 5, 6}, []string{"a", "b"})
+	println([]point{{1, 2}, {3, 4}}, []byte(nil))
+	println(make([]int, 20))
+	println(nested{})
 
 	// TODO: print pointer
 
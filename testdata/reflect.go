@@ -11,6 +11,27 @@ type (
 	myslice2 []myint
 )
 
+type point struct {
+	X, Y int
+}
+
+func (p point) Scale(n int) point {
+	return point{p.X * n, p.Y * n}
+}
+
+func (p point) Split() (int, int) {
+	return p.X, p.Y
+}
+
+func (p point) String() string {
+	return "point"
+}
+
+func (p *point) Move(dx, dy int) {
+	p.X += dx
+	p.Y += dy
+}
+
 func main() {
 	println("matching types")
 	println(reflect.TypeOf(int(3)) == reflect.TypeOf(int(5)))
@@ -81,6 +102,15 @@ func main() {
 		// structs
 		struct{}{},
 		struct{ error }{},
+		struct {
+			a int
+			b string
+			c bool `foo:"bar"`
+		}{42, "foo", true},
+		struct {
+			A int8
+			B int16
+		}{-5, 300},
 	} {
 		showValue(reflect.ValueOf(v), "")
 	}
@@ -217,6 +247,48 @@ func main() {
 	if rv.Len() != 2 || rv.Index(0).Int() != 3 {
 		panic("slice was changed while setting part of it")
 	}
+
+	// Set struct fields
+	s := &struct {
+		A int
+		B string
+		c bool
+	}{}
+	rv = reflect.ValueOf(s).Elem()
+	rv.Field(0).SetInt(5)
+	rv.FieldByName("B").Set(reflect.ValueOf("bar"))
+	if s.A != 5 || s.B != "bar" {
+		panic("could not set struct fields")
+	}
+	if rv.Field(2).CanSet() {
+		panic("unexported struct field is settable")
+	}
+
+	// Set array element
+	arr := [3]int16{5, 6, 7}
+	rv = reflect.ValueOf(&arr).Elem()
+	rv.Index(1).SetInt(-3)
+	if arr[1] != -3 || rv.Len() != 3 {
+		panic("could not set array element")
+	}
+
+	// Call methods
+	println("\nmethods:")
+	p := point{3, 4}
+	rv = reflect.ValueOf(p)
+	println("methods:", rv.NumMethod())
+	for i := 0; i < rv.NumMethod(); i++ {
+		println("method:", rv.Type().Method(i).Name)
+	}
+	results := rv.MethodByName("Scale").Call([]reflect.Value{reflect.ValueOf(2)})
+	println("scaled:", results[0].Field(0).Int(), results[0].Field(1).Int())
+	results = rv.MethodByName("Split").Call(nil)
+	println("split:", results[0].Int(), results[1].Int())
+	results = reflect.ValueOf(&p).MethodByName("Move").Call([]reflect.Value{reflect.ValueOf(1), reflect.ValueOf(-1)})
+	println("moved:", p.X, p.Y, len(results))
+	method, _ := reflect.TypeOf(p).MethodByName("String")
+	results = method.Func.Call([]reflect.Value{reflect.ValueOf(p)})
+	println("string:", results[0].String())
 }
 
 func emptyFunc() {
@@ -251,7 +323,10 @@ func showValue(rv reflect.Value, indent string) {
 	case reflect.UnsafePointer:
 		println(indent+"  pointer:", rv.Pointer() != 0)
 	case reflect.Array:
-		println(indent + "  array")
+		println(indent+"  array:", rt.Len(), rt.Elem().Kind().String())
+		for i := 0; i < rv.Len(); i++ {
+			showValue(rv.Index(i), indent+"  ")
+		}
 	case reflect.Chan:
 		println(indent+"  chan:", rt.Elem().Kind().String())
 		println(indent+"  nil:", rv.IsNil())
@@ -279,7 +354,16 @@ func showValue(rv reflect.Value, indent string) {
 			showValue(rv.Index(i), indent+"  ")
 		}
 	case reflect.Struct:
-		println(indent + "  struct")
+		println(indent+"  struct:", rt.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			field := rt.Field(i)
+			println(indent+"  field:", i, field.Name)
+			if field.Tag != "" {
+				println(indent+"  tag:", string(field.Tag))
+			}
+			println(indent+"  embedded:", field.Anonymous)
+			showValue(rv.Field(i), indent+"  ")
+		}
 	default:
 		println(indent + "  unknown type kind!")
 	}
@@ -0,0 +1,44 @@
+package main
+
+// Read embedded data without copying it, see OptimizeStringToBytes and
+// OptimizeBytesToString in the compiler.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+)
+
+// Never modified, so it stays in read-only memory.
+var asset = [...]byte{'t', 'i', 'n', 'y', 'g', 'o', '\n'}
+
+const message = "hello from rodata"
+
+func main() {
+	// string from a constant global
+	s := string(asset[:])
+	print("string: ", s)
+	println("len:", len(s))
+
+	// io.Reader over a constant global
+	data, err := ioutil.ReadAll(strings.NewReader(string(asset[:])))
+	println("strings.Reader:", string(data[:len(data)-1]), err == nil)
+	data, err = ioutil.ReadAll(bytes.NewReader(asset[:]))
+	println("bytes.Reader over array:", string(data[:len(data)-1]), err == nil)
+
+	// io.Reader over a string constant
+	r := bytes.NewReader([]byte(message))
+	buf := make([]byte, 5)
+	n, err := r.Read(buf)
+	println("bytes.Reader:", n, string(buf[:n]), err == nil, r.Len())
+	data, err = ioutil.ReadAll(r)
+	println("bytes.Reader rest:", string(data), err == nil, r.Len())
+	r.Reset([]byte(message[6:]))
+	data, err = ioutil.ReadAll(r)
+	println("bytes.Reader reset:", string(data), err == nil)
+
+	// Modifying the result of a conversion must not modify the original.
+	b := []byte(message)
+	b[0] = 'H'
+	println("modified copy:", string(b), message)
+}
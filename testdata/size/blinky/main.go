@@ -0,0 +1,21 @@
+package main
+
+// Blinking an LED, which is what most people try first on a new board. It
+// measures the size of the GPIO driver and the sleep implementation.
+
+import (
+	"machine"
+	"time"
+)
+
+func main() {
+	led := machine.LED
+	led.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	for {
+		led.Low()
+		time.Sleep(time.Millisecond * 500)
+
+		led.High()
+		time.Sleep(time.Millisecond * 500)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+// Goroutines and channels, which pull in the scheduler and coroutine lowering.
+
+import "time"
+
+func main() {
+	ch := make(chan int)
+	go producer(ch)
+	for n := range ch {
+		println("received:", n)
+	}
+}
+
+func producer(ch chan int) {
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		ch <- i
+	}
+	close(ch)
+}
@@ -0,0 +1,22 @@
+package main
+
+// Maps with string keys and interface values, which pull in the hashmap
+// implementation, memory allocation and type asserts.
+
+func main() {
+	m := map[string]interface{}{
+		"one": 1,
+		"two": "two",
+	}
+	m["three"] = 3.0
+	for k, v := range m {
+		switch v := v.(type) {
+		case int:
+			println(k, v)
+		case string:
+			println(k, v)
+		default:
+			println(k, "other")
+		}
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+// The smallest possible program, measuring the fixed overhead of the runtime.
+
+func main() {
+}
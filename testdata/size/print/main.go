@@ -0,0 +1,9 @@
+package main
+
+// Printing strings and numbers, which pulls in the print functions of the
+// runtime and the UART or other output driver.
+
+func main() {
+	println("hello world")
+	println("number:", 42, -1, 3.5)
+}
@@ -0,0 +1,113 @@
+package main
+
+// This file implements tinygo test for boards: the test binary is flashed like
+// any other program and the results are read from the serial port of the
+// board. The testing package prints a final line in every output format (see
+// -test-format), which tells whether the tests passed.
+//
+// Output that the board prints before the serial port is opened is lost. This
+// is usually not a problem for boards with a native USB port, as they wait
+// for the port to be opened, nor for boards of which the flash tool resets the
+// board only when it exits.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// errTestsFailed is returned when the tests on a board have run but failed.
+var errTestsFailed = errors.New("tests failed")
+
+// collectTestResults reads the output of a test binary from the serial port of
+// the board and prints it, until the tests have finished or the timeout
+// expires. It returns errTestsFailed if a test failed or the test binary
+// panicked.
+func collectTestResults(port string, spec *TargetSpec, executable string, config *BuildConfig) error {
+	symbolizer, err := NewSymbolizer(executable)
+	if err != nil {
+		return err
+	}
+	f, err := openSerialPort(port, spec, config.baudRate)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := make(chan string)
+	go func() {
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimRight(line, "\r\n")
+		}
+	}()
+
+	timeout := time.After(config.testTimeout)
+	panicked := false
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return errors.New("serial port closed before the tests finished")
+			}
+			fmt.Println(line)
+			printTraceLocations(os.Stdout, symbolizer, line)
+			if panicked {
+				// Print the panic trace until the board goes silent.
+				timeout = time.After(time.Second)
+				continue
+			}
+			if strings.HasPrefix(line, "panic: ") {
+				panicked = true
+				timeout = time.After(time.Second)
+				continue
+			}
+			if passed, done := testResult(config.testConfig.Format, line); done {
+				if !passed {
+					return errTestsFailed
+				}
+				return nil
+			}
+		case <-timeout:
+			if panicked {
+				return errTestsFailed
+			}
+			return fmt.Errorf("tests did not finish within %s (see -test-timeout)", config.testTimeout)
+		}
+	}
+}
+
+// testResult returns whether the tests passed and whether the tests are done,
+// based on a line of output in the given format.
+func testResult(format, line string) (passed, done bool) {
+	switch format {
+	case "tap":
+		// The last lines are "# pass <n>" and "# fail <n>".
+		if strings.HasPrefix(line, "# fail ") {
+			return line == "# fail 0", true
+		}
+	case "json":
+		switch line {
+		case `{"Action":"pass"}`:
+			return true, true
+		case `{"Action":"fail"}`:
+			return false, true
+		}
+	default:
+		switch line {
+		case "PASS":
+			return true, true
+		case "FAIL":
+			return false, true
+		}
+	}
+	return false, false
+}
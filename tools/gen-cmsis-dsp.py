@@ -0,0 +1,160 @@
+#!/usr/bin/env python3
+
+# Generate Go bindings for a subset of the CMSIS-DSP library from arm_math.h.
+# The bindings are thin: they declare the C structs and functions as they are,
+# the Go API on top of them is written by hand in src/dsp.
+
+import re
+import argparse
+
+# Functions to generate bindings for. When adding a function here, also add the
+# source file that implements it to cmsisDSPSources in cmsisdsp.go.
+functions = [
+    'arm_biquad_cascade_df1_f32',
+    'arm_biquad_cascade_df1_init_f32',
+    'arm_dot_prod_f32',
+    'arm_fir_f32',
+    'arm_fir_init_f32',
+    'arm_fir_init_q31',
+    'arm_fir_q31',
+    'arm_mat_add_f32',
+    'arm_mat_init_f32',
+    'arm_mat_inverse_f32',
+    'arm_mat_mult_f32',
+    'arm_mat_trans_f32',
+    'arm_max_f32',
+    'arm_mean_f32',
+    'arm_rfft_fast_f32',
+    'arm_rfft_fast_init_f32',
+    'arm_rms_f32',
+]
+
+# Scalar C types and their Go equivalents. The arm_status enum is a single byte
+# because targets are compiled with -fshort-enums.
+scalarTypes = {
+    'void':      '',
+    'float32_t': 'float32',
+    'float64_t': 'float64',
+    'q7_t':      'int8',
+    'q15_t':     'int16',
+    'q31_t':     'int32',
+    'q63_t':     'int64',
+    'int8_t':    'int8',
+    'int16_t':   'int16',
+    'int32_t':   'int32',
+    'uint8_t':   'uint8',
+    'uint16_t':  'uint16',
+    'uint32_t':  'uint32',
+    'arm_status': 'armStatus',
+}
+
+def goTypeName(cname):
+    # arm_fir_instance_f32 -> firInstanceF32
+    parts = cname[len('arm_'):].split('_')
+    return parts[0] + ''.join(part.capitalize() for part in parts[1:])
+
+def stripComments(text):
+    text = re.sub(r'/\*.*?\*/', ' ', text, flags=re.DOTALL)
+    text = re.sub(r'//[^\n]*', ' ', text)
+    return text
+
+def parseDecl(decl):
+    # Parse a declaration like "const float32_t * pSrc" into its base type,
+    # pointer depth and name.
+    decl = decl.replace('const ', ' ').replace('*', ' * ')
+    tokens = decl.split()
+    name = tokens[-1]
+    ctype = tokens[0]
+    pointers = tokens.count('*')
+    return ctype, pointers, name
+
+class Header:
+    def __init__(self, path):
+        text = stripComments(open(path).read())
+        self.structs = {}
+        for m in re.finditer(r'typedef\s+struct\s*\{([^}]*)\}\s*(\w+)\s*;', text):
+            fields = []
+            for field in m.group(1).split(';'):
+                if not field.strip():
+                    continue
+                fields.append(parseDecl(field))
+            self.structs[m.group(2)] = fields
+        self.functions = {}
+        for m in re.finditer(r'(\w+)\s+(arm_\w+)\s*\(([^)]*)\)\s*;', text):
+            params = []
+            for param in m.group(3).split(','):
+                if param.strip() in ('', 'void'):
+                    continue
+                params.append(parseDecl(param))
+            self.functions[m.group(2)] = (m.group(1), params)
+
+    def goType(self, ctype, pointers, usedStructs):
+        if ctype in scalarTypes:
+            gotype = scalarTypes[ctype]
+        elif ctype in self.structs:
+            gotype = goTypeName(ctype)
+            if ctype not in usedStructs:
+                usedStructs.append(ctype)
+                # Also add structs that are embedded in this struct.
+                for fieldType, fieldPointers, _ in self.structs[ctype]:
+                    self.goType(fieldType, fieldPointers, usedStructs)
+        else:
+            raise ValueError('unknown C type: ' + ctype)
+        if pointers and gotype == '':
+            return 'unsafe.Pointer'
+        return '*' * pointers + gotype
+
+def writeGo(outfile, header):
+    usedStructs = []
+    decls = []
+    for name in functions:
+        if name not in header.functions:
+            raise ValueError('function not found in header: ' + name)
+        result, params = header.functions[name]
+        goParams = []
+        for ctype, pointers, pname in params:
+            goParams.append('{} {}'.format(pname, header.goType(ctype, pointers, usedStructs)))
+        goResult = header.goType(result, 0, usedStructs)
+        if goResult:
+            goResult = ' ' + goResult
+        decls.append('//go:export {name}\nfunc {name}({params}){result}\n'.format(
+            name=name,
+            params=', '.join(goParams),
+            result=goResult))
+
+    imports = ''
+    if any('unsafe.Pointer' in decl for decl in decls):
+        imports = 'import "unsafe"\n\n'
+
+    out = open(outfile, 'w')
+    out.write('''\
+// Automatically generated file. DO NOT EDIT.
+// Generated by gen-cmsis-dsp.py from arm_math.h.
+
+// +build cortexm
+
+package dsp
+
+{imports}// armStatus is the arm_status enum of CMSIS-DSP.
+type armStatus int8
+
+'''.format(imports=imports))
+    for cname in sorted(usedStructs):
+        out.write('// {} is {} from CMSIS-DSP.\n'.format(goTypeName(cname), cname))
+        out.write('type {} struct {{\n'.format(goTypeName(cname)))
+        fields = header.structs[cname]
+        width = max(len(fname) for _, _, fname in fields)
+        for ctype, pointers, fname in fields:
+            out.write('\t{} {}\n'.format(fname.ljust(width), header.goType(ctype, pointers, usedStructs)))
+        out.write('}\n\n')
+    out.write('\n'.join(decls))
+    out.close()
+
+if __name__ == '__main__':
+    parser = argparse.ArgumentParser(description='Generate Go bindings for CMSIS-DSP')
+    parser.add_argument('header', metavar='header', type=str,
+                        help='path to arm_math.h')
+    parser.add_argument('outfile', metavar='outfile', type=str,
+                        help='output Go file')
+    args = parser.parse_args()
+    writeGo(args.outfile, Header(args.header))
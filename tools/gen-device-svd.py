@@ -208,8 +208,12 @@ def addInterrupt(interrupts, intrName, intrIndex, description):
             'description': description,
         }
 
+# Parse the bitfields of a register. Returns the constants to define and a
+# description of each field that is used to generate typed field values and
+# accessor methods.
 def parseBitfields(groupName, regName, fieldsEls, bitfieldPrefix=''):
     fields = []
+    fieldInfos = []
     if fieldsEls:
         for fieldEl in fieldsEls[0].findall('field'):
             # Some bitfields (like the STM32H7x7) contain invalid bitfield
@@ -236,6 +240,12 @@ def parseBitfields(groupName, regName, fieldsEls, bitfieldPrefix=''):
                 'description': 'Bit mask of %s field.' % fieldName,
                 'value':       (0xffffffff >> (31 - (msb - lsb))) << lsb,
             })
+            fieldInfo = {
+                'name':        fieldName,
+                'constName':   '{}_{}{}_{}'.format(groupName, bitfieldPrefix, regName, fieldName),
+                'enums':       [],
+            }
+            fieldInfos.append(fieldInfo)
             if lsb == msb: # single bit
                 fields.append({
                     'name':        '{}_{}{}_{}'.format(groupName, bitfieldPrefix, regName, fieldName),
@@ -251,7 +261,13 @@ def parseBitfields(groupName, regName, fieldsEls, bitfieldPrefix=''):
                     'description': enumDescription,
                     'value':       enumValue,
                 })
-    return fields
+                if validName.match(enumName) and enumName not in [e['name'] for e in fieldInfo['enums']]:
+                    fieldInfo['enums'].append({
+                        'name':        enumName,
+                        'description': enumDescription,
+                        'value':       enumValue,
+                    })
+    return fields, fieldInfos
 
 class Register:
     def __init__(self, element, baseAddress):
@@ -305,19 +321,25 @@ def parseRegister(groupName, regEl, baseAddress, bitfieldPrefix=''):
                     'address':     regAddress,
                     'description': reg.description(),
                     'bitfields':   [],
+                    'fields':      [],
                     'array':       None,
                     'elementsize': reg.size(),
                 })
             # set first result bitfield
             shortName = reg.name().replace('_%s', '').replace('%s', '')
-            results[0]['bitfields'] = parseBitfields(groupName, shortName, fieldsEls, bitfieldPrefix)
+            results[0]['bitfields'], results[0]['fields'] = parseBitfields(groupName, shortName, fieldsEls, bitfieldPrefix)
+            # The field constants are shared between all registers, so no
+            # accessor methods can be generated for them.
+            results[0]['accessors'] = False
             return results
 
+    bitfields, fields = parseBitfields(groupName, reg.name(), fieldsEls, bitfieldPrefix)
     return [{
         'name':        reg.name(),
         'address':     reg.address(),
         'description': reg.description(),
-        'bitfields':   parseBitfields(groupName, reg.name(), fieldsEls, bitfieldPrefix),
+        'bitfields':   bitfields,
+        'fields':      fields,
         'array':       reg.dim(),
         'elementsize': reg.size(),
     }]
@@ -375,6 +397,7 @@ const (
                 # In Nordic SVD files, these registers are deprecated or
                 # duplicates, so can be ignored.
                 #print('skip: %s.%s %s - %s %s' % (peripheral['name'], register['name'], address, register['address'], register['elementsize']))
+                register['accessors'] = False
                 continue
             eSize = register['elementsize']
             if eSize == 4:
@@ -476,6 +499,12 @@ const (
             for subregister in register.get('registers', []):
                 writeGoRegisterBitfields(out, subregister, register['name'] + '.' + subregister['name'])
         out.write(')\n')
+        for register in peripheral['registers']:
+            writeGoRegisterFieldTypes(out, register)
+            for subregister in register.get('registers', []):
+                writeGoRegisterFieldTypes(out, subregister)
+        for register in peripheral['registers']:
+            writeGoRegisterAccessors(out, peripheral, register)
 
 def writeGoRegisterBitfields(out, register, name):
     out.write('\n\t// {}'.format(name))
@@ -488,6 +517,55 @@ def writeGoRegisterBitfields(out, register, name):
             out.write(' // {description}'.format(**bitfield))
         out.write('\n')
 
+# Define a type for each field with enumerated values, so that only these
+# values can be passed to the field accessor methods without a conversion. The
+# untyped constants above are kept as they are used throughout the machine
+# package.
+def writeGoRegisterFieldTypes(out, register):
+    for field in register.get('fields', []):
+        if not field['enums']:
+            continue
+        out.write('\n// {constName}_Field is a value of the {name} field.\ntype {constName}_Field uint32\n'.format(**field))
+        out.write('\nconst (\n')
+        for enum in field['enums']:
+            out.write('\t{constName}_Field_{name} {constName}_Field = 0x{value:x}'.format(constName=field['constName'], **enum))
+            if enum['description']:
+                out.write(' // {description}'.format(**enum))
+            out.write('\n')
+        out.write(')\n')
+
+# Define Get and Set methods for each field of a register. They are only
+# defined for plain registers: not for arrays of registers or registers in a
+# cluster.
+def writeGoRegisterAccessors(out, peripheral, register):
+    if 'registers' in register or register['array'] is not None or not register.get('accessors', True):
+        return
+    regType = {1: 'uint8', 2: 'uint16'}.get(register['elementsize'], 'uint32')
+    for field in register['fields']:
+        valueType = regType
+        if field['enums']:
+            valueType = field['constName'] + '_Field'
+        values = {
+            'typeName':  peripheral['groupName'] + '_Type',
+            'regName':   register['name'],
+            'fieldName': field['name'],
+            'constName': field['constName'],
+            'regType':   regType,
+            'valueType': valueType,
+        }
+        out.write('''
+// Get{regName}_{fieldName} returns the value of the {fieldName} field in the {regName} register.
+func (o *{typeName}) Get{regName}_{fieldName}() {valueType} {{
+	return {valueType}((o.{regName}.Get() & {constName}_Msk) >> {constName}_Pos)
+}}
+
+// Set{regName}_{fieldName} changes the {fieldName} field in the {regName} register,
+// leaving the other fields unchanged.
+func (o *{typeName}) Set{regName}_{fieldName}(value {valueType}) {{
+	o.{regName}.Set(o.{regName}.Get()&^{constName}_Msk | {regType}(value)<<{constName}_Pos)
+}}
+'''.format(**values))
+
 
 def writeAsm(outdir, device):
     # The interrupt vector, which is hard to write directly in Go.
@@ -12,10 +12,10 @@ import (
 	"io/ioutil"
 )
 
-// ConvertELFFileToUF2File converts an ELF file to a UF2 file.
-func ConvertELFFileToUF2File(infile, outfile string) error {
+// ConvertELFFileToUF2File converts one or more ELF files to a UF2 file.
+func ConvertELFFileToUF2File(infiles []string, outfile string) error {
 	// Read the .text segment.
-	_, data, err := ExtractROM(infile)
+	_, data, err := ExtractROMs(infiles)
 	if err != nil {
 		return err
 	}
@@ -26,21 +26,37 @@ func ConvertELFFileToUF2File(infile, outfile string) error {
 
 // ConvertBinToUF2 converts the binary bytes in input to UF2 formatted data.
 func ConvertBinToUF2(input []byte) ([]byte, int) {
+	return convertChangedBinToUF2(input, nil)
+}
+
+// convertChangedBinToUF2 is like ConvertBinToUF2, but it leaves out the blocks
+// that are the same in the previous image (if not nil). A UF2 bootloader
+// writes every block at its own address, so the other blocks are left as they
+// are.
+func convertChangedBinToUF2(input, previous []byte) ([]byte, int) {
 	blocks := split(input, 256)
+	var changed []int
+	for i, block := range blocks {
+		start := i * 256
+		if previous != nil && start+len(block) <= len(previous) && bytes.Equal(block, previous[start:start+len(block)]) {
+			continue
+		}
+		changed = append(changed, i)
+	}
 	output := make([]byte, 0)
 
 	bl := NewUF2Block()
-	bl.SetNumBlocks(len(blocks))
+	bl.SetNumBlocks(len(changed))
 
-	for i := 0; i < len(blocks); i++ {
-		bl.SetBlockNo(i)
+	for n, i := range changed {
+		bl.SetBlockNo(n)
 		bl.SetData(blocks[i])
+		bl.targetAddr = uf2StartAddress + uint32(i)*bl.payloadSize
 
 		output = append(output, bl.Bytes()...)
-		bl.IncrementAddress(bl.payloadSize)
 	}
 
-	return output, len(blocks)
+	return output, len(changed)
 }
 
 const (